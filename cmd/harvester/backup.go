@@ -0,0 +1,65 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// validateBackup checks the --backup-* flags, which only configure the
+// Velero install of --backup-schedule
+func validateBackup(cliFlags *types.CliFlags) error {
+	if cliFlags.BackupSchedule == "" {
+		// defaulted, so CreateFlagConstraints cannot tell it was set
+		if cliFlags.BackupTTL != internalharvester.DefaultBackupTTL {
+			return errors.New("--backup-ttl requires --backup-schedule")
+		}
+		return nil
+	}
+
+	if err := internalharvester.ValidateBackupSchedule(cliFlags.BackupSchedule); err != nil {
+		return fmt.Errorf("invalid --backup-schedule: %w", err)
+	}
+	if err := internalharvester.ValidateBackupTTL(cliFlags.BackupTTL); err != nil {
+		return fmt.Errorf("invalid --backup-ttl: %w", err)
+	}
+	if _, err := internalharvester.BackupFromEnv(cliFlags.BackupStorage, cliFlags.BackupBucket, cliFlags.BackupPrefix, cliFlags.BackupSchedule, cliFlags.BackupTTL); err != nil {
+		return fmt.Errorf("invalid --backup-storage: %w", err)
+	}
+
+	return nil
+}
+
+// configureBackups stores the object store credentials next to Velero and
+// commits its BackupStorageLocation and Schedule to the gitops repository
+func configureBackups(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	config, err := internalharvester.BackupFromEnv(cliFlags.BackupStorage, cliFlags.BackupBucket, cliFlags.BackupPrefix, cliFlags.BackupSchedule, cliFlags.BackupTTL)
+	if err != nil {
+		return err
+	}
+
+	if err := client.ApplyBackupCredentials(ctx, config); err != nil {
+		return fmt.Errorf("failed to store backup storage credentials: %w", err)
+	}
+
+	manifests, err := internalharvester.BackupManifests(config)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("schedule velero backups to %s", config.Storage)
+	if err := commitRegistryFile(ctx, commits, cliFlags, "velero-backup.yaml", manifests, message); err != nil {
+		return fmt.Errorf("failed to commit velero backup schedule: %w", err)
+	}
+
+	return nil
+}
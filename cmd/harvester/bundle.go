@@ -0,0 +1,177 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/konstructio/kubefirst-api/pkg/configs"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+)
+
+func runBundleCreate(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output flag: %w", err)
+	}
+
+	templateURL, err := cmd.Flags().GetString("gitops-template-url")
+	if err != nil {
+		return fmt.Errorf("failed to get gitops-template-url flag: %w", err)
+	}
+
+	templateBranch, err := cmd.Flags().GetString("gitops-template-branch")
+	if err != nil {
+		return fmt.Errorf("failed to get gitops-template-branch flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	lowBandwidth, err := cmd.Flags().GetBool("low-bandwidth")
+	if err != nil {
+		return fmt.Errorf("failed to get low-bandwidth flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Snapshot GitOps Template")
+
+	gitops, commit, err := internalharvester.SnapshotGitopsTemplate(ctx, templateURL, templateBranch, proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to snapshot gitops template: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	images := internalharvester.BundleImages(gitops)
+	var slim map[string]string
+	if lowBandwidth {
+		stepper.NewProgressStep("Look Up Slim Images")
+
+		httpClient, err := internalharvester.NewHTTPClient(proxy)
+		if err != nil {
+			wrerr := fmt.Errorf("invalid --proxy: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		estimate := internalharvester.EstimateDownload(ctx, internalharvester.NewRegistryImageLayers(httpClient, ""), images, true)
+		slim = estimate.SlimImages()
+		for i, image := range images {
+			if variant, ok := slim[image]; ok {
+				images[i] = variant
+			}
+		}
+		sort.Strings(images)
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("%d images replaced by their slim variant, pulling the images downloads %s", len(slim), humanize.IBytes(uint64(estimate.Total))))
+	}
+
+	stepper.NewProgressStep("Write Bundle")
+
+	executable, err := os.Executable()
+	if err != nil {
+		wrerr := fmt.Errorf("failed to locate the kubefirst binary: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	cli, err := os.ReadFile(executable)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to read the kubefirst binary: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	entries := []internalharvester.BundleEntry{
+		{Path: internalharvester.BundleCLI, Mode: 0o755, Content: cli},
+		{Path: internalharvester.BundleRunScript, Mode: 0o755, Content: []byte(internalharvester.BundleRunScriptContent)},
+	}
+
+	names := make([]string, 0, len(gitops))
+	for name := range gitops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries = append(entries, internalharvester.BundleEntry{Path: internalharvester.BundleGitopsDir + name, Mode: 0o644, Content: gitops[name]})
+	}
+
+	lock := internalharvester.BundleLock{
+		CLIVersion:           configs.K1Version,
+		CreatedAt:            time.Now().UTC(),
+		GitopsTemplateURL:    templateURL,
+		GitopsTemplateBranch: templateBranch,
+		GitopsTemplateCommit: commit,
+		Images:               images,
+		LowBandwidth:         lowBandwidth,
+		SlimImages:           slim,
+	}
+
+	f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o644)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create bundle: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	defer f.Close()
+
+	if err := internalharvester.WriteBundle(f, lock, entries); err != nil {
+		wrerr := fmt.Errorf("failed to write bundle: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := f.Close(); err != nil {
+		wrerr := fmt.Errorf("failed to write bundle: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Bundle written to %s with gitops template commit %s", output, commit))
+	if len(lock.Images) > 0 {
+		stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Mirror the %d images listed in %s into a registry the site can reach", len(lock.Images), internalharvester.BundleLockfile))
+	}
+
+	return nil
+}
+
+func runBundleVerify(cmd *cobra.Command, args []string) error {
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	stepper.NewProgressStep("Verify Bundle")
+
+	bundle, err := internalharvester.OpenBundle(args[0])
+	if err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	if err := bundle.Verify(); err != nil {
+		wrerr := fmt.Errorf("bundle %s is incomplete: %w", args[0], err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Bundle %s matches its lockfile: kubefirst %s, gitops template commit %s", args[0], bundle.Lock.CLIVersion, bundle.Lock.GitopsTemplateCommit))
+
+	return nil
+}
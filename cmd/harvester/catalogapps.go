@@ -0,0 +1,128 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"github.com/konstructio/kubefirst/internal/catalog"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// orderCatalogApps sorts the catalog apps in install order, the same on
+// every run: the apps reading secrets after the External Secrets Operator
+// syncing them, then by name
+func orderCatalogApps(catalogApps []apiTypes.GitopsCatalogApp) ([]apiTypes.GitopsCatalogApp, error) {
+	names := make([]string, 0, len(catalogApps))
+	apps := map[string]apiTypes.GitopsCatalogApp{}
+	dependsOn := map[string][]string{}
+	for _, app := range catalogApps {
+		names = append(names, app.Name)
+		apps[app.Name] = app
+		if len(app.SecretKeys) > 0 {
+			dependsOn[app.Name] = []string{internalharvester.ExternalSecretsCatalogApp}
+		}
+	}
+
+	order, err := internalharvester.OrderCatalogApps(names, dependsOn)
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]apiTypes.GitopsCatalogApp, 0, len(order))
+	for _, name := range order {
+		ordered = append(ordered, apps[name])
+	}
+
+	return ordered, nil
+}
+
+// catalogAppNames returns the names of catalogApps, in order
+func catalogAppNames(catalogApps []apiTypes.GitopsCatalogApp) []string {
+	names := make([]string, 0, len(catalogApps))
+	for _, app := range catalogApps {
+		names = append(names, app.Name)
+	}
+
+	return names
+}
+
+// skipHealthyCatalogApps drops the catalog apps an earlier run already
+// installed and ArgoCD reports Healthy/Synced, so a rerun only retries the
+// failed and pending ones
+func skipHealthyCatalogApps(ctx context.Context, client *internalharvester.Client, catalogApps []apiTypes.GitopsCatalogApp, stepper step.Stepper) ([]apiTypes.GitopsCatalogApp, error) {
+	if len(catalogApps) == 0 {
+		return catalogApps, nil
+	}
+
+	recorded, err := client.LoadCatalogAppsState(ctx)
+	if err != nil || len(recorded) == 0 {
+		return catalogApps, err
+	}
+
+	// the recorded status is only a hint, ArgoCD has the last word
+	live, err := client.CatalogAppStatuses(ctx, catalogAppNames(catalogApps))
+	if err != nil {
+		return catalogApps, nil
+	}
+	remaining := live.Remaining(catalogAppNames(catalogApps))
+
+	var kept []apiTypes.GitopsCatalogApp
+	var skipped []string
+	for _, app := range catalogApps {
+		if slices.Contains(remaining, app.Name) {
+			kept = append(kept, app)
+		} else {
+			skipped = append(skipped, app.Name)
+		}
+	}
+	if len(skipped) > 0 {
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Skipping catalog apps already Healthy: %s", strings.Join(skipped, ", ")))
+	}
+
+	return kept, nil
+}
+
+// reportCatalogApps records the status of the catalog apps in the state
+// secret and prints them as a table. It runs whether provisioning
+// succeeded or not, a failure to record only being warned about
+func reportCatalogApps(ctx context.Context, client *internalharvester.Client, order []string, stepper step.Stepper) {
+	if len(order) == 0 {
+		return
+	}
+
+	state, err := client.RecordCatalogApps(ctx, order)
+	if err != nil {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Failed to record the catalog app statuses: %v", err))
+		return
+	}
+
+	stepper.InfoStep(step.EmojiBulb, "Catalog apps:\n"+state.Render())
+}
+
+// validateVClusterApps checks the catalog apps of --vcluster-apps against
+// the gitops catalog, as --install-catalog-apps is
+func validateVClusterApps(ctx context.Context, cliFlags *types.CliFlags) error {
+	apps, err := internalharvester.ParseVClusterApps(cliFlags.VClusters, cliFlags.VClusterApps)
+	if err != nil {
+		return err
+	}
+
+	names := internalharvester.VClusterCatalogApps(apps)
+	if len(names) == 0 {
+		return nil
+	}
+
+	_, _, err = catalog.ValidateCatalogApps(ctx, strings.Join(names, ","))
+	return err
+}
@@ -7,14 +7,18 @@ See the LICENSE file for more details.
 package harvester
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/konstructio/kubefirst/internal/catalog"
-	"github.com/konstructio/kubefirst/internal/cluster"
-	"github.com/konstructio/kubefirst/internal/provision"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
 	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/ui"
 	"github.com/konstructio/kubefirst/internal/utilities"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
@@ -27,140 +31,1173 @@ func NewCommand() *cobra.Command {
 	harvesterCmd := &cobra.Command{
 		Use:   "harvester",
 		Short: "kubefirst Harvester installation",
-		Long:  "kubefirst Harvester cluster installation using existing kubeconfig",
+		Long: `kubefirst Harvester cluster installation using existing kubeconfig
+
+Each --profile keeps its kubefirst config, reports and kubeconfig in
+$HOME/.k1/harvester-profiles/<profile>, the default profile keeps them in
+$HOME/.kubefirst and $HOME/.k1/harvester. --ca-cert is trusted for the git
+provider, UniFi, Vault and the other HTTPS endpoints, --no-proxy takes
+hosts such as the Harvester API server and the UniFi controller. --ca-cert
+and --commit-per-change default to what create recorded.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// cobra only runs the closest hook, the root one initializes viper
+			if root := cmd.Root(); root.PersistentPreRunE != nil {
+				if err := root.PersistentPreRunE(cmd, args); err != nil {
+					return err
+				}
+			}
+
+			if err := useProfile(cmd); err != nil {
+				return err
+			}
+
+			if err := configureProxy(cmd); err != nil {
+				return err
+			}
+
+			return configureTLS(cmd)
+		},
 	}
 
 	// on error, doesnt show helper/usage
 	harvesterCmd.SilenceUsage = true
 
+	harvesterCmd.PersistentFlags().StringSlice("ca-cert", []string{}, "PEM file or directory of CA certificates to trust, repeatable")
+	harvesterCmd.PersistentFlags().Bool("insecure-skip-tls-verify", false, "do not verify TLS certificates of HTTPS endpoints, for labs only")
+	harvesterCmd.PersistentFlags().String("http-proxy", "", "proxy url for http requests of this run, overrides HTTP_PROXY")
+	harvesterCmd.PersistentFlags().String("https-proxy", "", "proxy url for https requests of this run, overrides HTTPS_PROXY")
+	harvesterCmd.PersistentFlags().String("no-proxy", "", "comma separated hosts, domains and CIDRs reached without a proxy, overrides NO_PROXY")
+	harvesterCmd.PersistentFlags().String("profile", internalharvester.DefaultProfile, "name of the management cluster profile of this machine to work on")
+	harvesterCmd.RegisterFlagCompletionFunc("profile", completeProfiles)
+	harvesterCmd.PersistentFlags().Bool("commit-per-change", false, "push every gitops change as a commit of its own")
+
 	// wire up new commands
-	harvesterCmd.AddCommand(Create(), Destroy(), RootCredentials())
+	harvesterCmd.AddCommand(Create(), Destroy(), RootCredentials(), Sync(), Rollback(), Pin(), Bundle(), ExportConfig(), Status(), List(), Describe(), Report(), Compare(), Replicate(), Failover(), VCluster(), Profiles(), Maintenance(), Vault(), Exposure(), Connect(), RotateCredentials(), Completion(), Docs())
 
 	return harvesterCmd
 }
 
-func Create() *cobra.Command{
+func Create() *cobra.Command {
 	createCmd := &cobra.Command{
-		Use:              "create",
-		Short:            "create the kubefirst platform on Harvester",
+		Use:   "create",
+		Short: "create the kubefirst platform on Harvester",
+		Long: fmt.Sprintf(`create the kubefirst platform on an existing Harvester cluster
+
+Flags are read from the command line, then their KUBEFIRST_HARVESTER_<FLAG>
+environment variables, then the cluster config of --from-config.
+--print-flags shows where each value came from, with secrets masked.
+
+Kubeconfig: --kubeconfig-from-secret reads the kubeconfig from a secret of
+the cluster kubefirst runs in, key kubeconfig by default, so it never
+touches the disk; the kubeconfig itself held by
+$%[1]s does the same. --kubeconfig-context is required in
+--ci mode when the kubeconfig has several contexts.
+
+DNS: cloudflare reads CF_API_TOKEN, route53 reads --route53-hosted-zone-id
+and the AWS credentials of the environment; kubefirst-api provisions the
+cluster with the first --dns-provider. --prune-dns never touches records
+kubefirst did not create. --dns-check-doh, for networks that block or
+hijack port 53, resolves through
+%[2]s without a url.
+
+Git: --git-protocol https clones and pushes with the git provider token, or
+the --github-app-id installation token, and needs no ssh keys. The lockfile
+of --from-bundle sets the gitops template url and branch, and the template
+checks run against its snapshot; kubefirst-api still clones the template
+from the network. --gitops-overlay-dir deep-merges its YAML files onto the
+file at the same path, adds its other files verbatim and removes the paths
+listed in its .delete file. --git-lfs-patterns needs a git provider serving
+LFS and cannot match the manifest files ArgoCD renders.
+
+Cluster: a --cluster-type workload cluster is registered with the ArgoCD of
+the management cluster of --mgmt-kubeconfig, which deploys
+workloads/<cluster-name> of its gitops repository to it. --ha fails unless
+--ha-node-count Harvester hosts are schedulable and a quorum of control
+plane nodes is Ready before ArgoCD installs; kubefirst does not add control
+plane nodes. --gpu-nodes installs the NVIDIA GPU Operator and taints the
+nodes nvidia.com/gpu=present:NoSchedule. With --lb-ip-range-name, ArgoCD and
+the ingress services request the management pool and the vCluster services
+the tenant pool. --registry-mirror pulls the docker.io, ghcr.io, quay.io and
+registry.k8s.io images as <mirror>/<source registry>/<repository>.
+--components-version-file pins any of
+%[3]s,
+overriding --istio-version, --kubefirst-pro-version and --gpu-driver-version.
+
+Low bandwidth: --low-bandwidth pulls the %[4]s variant of the
+gitops images where their registry has one, turns off
+%[5]s
+unless set explicitly, runs the platform charts with a single replica and
+asks to confirm the estimated download. It defaults to true for a
+--from-bundle built with it.
+
+vClusters: --trust-bundle distributes the --ca-cert certificates as the
+%[6]s configmap, key %[7]s, into the default
+namespace of every vCluster or the --trust-bundle-target ones.
+--trust-manager projects it with the public CAs into the host namespaces as
+the %[8]s configmap.
+--trust-bundle-probe-url is fetched from the first target with the trust
+bundle as its only CAs.
+--vcluster-appset keeps a directory per vCluster in the gitops repository,
+so adding one is a single commit. --vcluster-network-isolation still lets
+Istio and ArgoCD in. An empty app list of --vcluster-apps installs none, and
+unlisted vClusters get none either. --vcluster-connect is authorized by
+Istio ambient mode. --vcluster-node-selector optionally tolerates the taint
+of the same key and value, e.g. ml:gpu=true:NoSchedule.
+
+Ingress: --ingress-mode unifi publishes through a UniFi WAN port forward,
+cloudflare-tunnel through a Cloudflare Tunnel without a WAN port and
+requires --dns-provider cloudflare, none only publishes dns records on the
+internal load balancer addresses. The --unifi-port-mapping forwards are
+configured after the ingress phase and removed on destroy, with proto one of
+tcp, udp, tcp_udp.
+
+Add-ons: --install-observability and --logging enable the observability
+phase, root-credentials shows the Grafana admin password. --logging without
+--install-observability serves a Grafana scoped to the logs. The static
+--vault-auto-unseal stores the unseal keys in a Kubernetes secret and is
+meant for homelabs. The file --vault-audit rotates daily or at 100MiB
+keeping %[9]d logs, syslog needs a syslog daemon reachable from the Vault
+pods. --vault-team-policies lets every vcluster read its own
+secret/vclusters/<name> path and the platform-admin ServiceAccount of the
+vault namespace administer Vault; change them with harvester vault policies
+apply. The credentials of --external-secrets-backend and --backup-storage
+are read from the environment.
+
+Runs: --retry-count only reruns the git credentials and management cluster
+phases after a network timeout, a 429 or an unavailable API server or
+kubefirst-api, configuration errors fail at once. Declining a phase of
+--confirm-phases halts after the previous one as --stop-after would. --tui
+is ignored with --ci or in a terminal smaller than %[10]dx%[11]d. --dry-run
+only sends server-side dry runs to a cluster kubefirst provisioned, pushes
+nothing and skips kubefirst-api, DNS, Vault and the verification steps.
+--export-manifests lays the manifests out as the gitops repository, with
+what is applied directly under applied/. --cost-estimate sums the vCluster
+resource quotas, Vault and ArgoCD PVC sizes and Istio control plane requests
+of the gitops template.`,
+			internalharvester.KubeconfigEnv, internalharvester.DefaultDoHURL, strings.Join(internalharvester.VersionedComponents(), ", "),
+			strings.Join(internalharvester.SlimTagSuffixes, " or "), strings.Join(internalharvester.LowBandwidthOptionalFlags, ", "),
+			internalharvester.TrustBundleName, internalharvester.TrustBundleKey, internalharvester.TrustManagerBundle,
+			internalharvester.VaultAuditMaxFiles, ui.MinDashboardWidth, ui.MinDashboardHeight),
 		TraverseChildren: true,
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			cloudProvider := "harvester"
-			estimatedTimeMin := 25
 			ctx := cmd.Context()
-			stepper := step.NewStepFactory(cmd.ErrOrStderr())
 
-			stepper.DisplayLogHints(cloudProvider, estimatedTimeMin)
+			fromConfig, err := applyFlagSources(cmd)
+			if err != nil {
+				return fmt.Errorf("failed to apply the environment and --from-config: %w", err)
+			}
 
-			stepper.NewProgressStep("Validate Configuration")
+			if interactive, _ := cmd.Flags().GetBool("interactive"); interactive {
+				proceed, err := runCreateWizard(cmd, fromConfig)
+				if err != nil || !proceed {
+					return err
+				}
+			}
 
-			cliFlags, err := utilities.GetFlags(cmd, cloudProvider)
-			if err != nil {
-				wrerr := fmt.Errorf("failed to get flags: %w", err)
-				stepper.FailCurrentStep(wrerr)
-				return wrerr
+			if err := applyLowBandwidth(cmd); err != nil {
+				return err
 			}
 
-			_, catalogApps, err := catalog.ValidateCatalogApps(ctx, cliFlags.InstallCatalogApps)
-			if err != nil {
-				wrerr := fmt.Errorf("validation of catalog apps failed: %w", err)
-				stepper.FailCurrentStep(wrerr)
-				return wrerr
+			if printFlags, _ := cmd.Flags().GetString("print-flags"); printFlags != "" {
+				return printEffectiveFlags(cmd, printFlags)
 			}
 
-			err = ValidateProvidedFlags(cliFlags.GitProvider)
+			// asked before the plan, which depends on --stop-after
+			confirmPhases, _ := cmd.Flags().GetBool("confirm-phases")
+			showPlan, _ := cmd.Flags().GetBool("plan")
+			ci, _ := cmd.Flags().GetBool("ci")
+			if confirmPhases && !showPlan && !ci {
+				proceed, err := confirmCreatePhases(cmd)
+				if err != nil || !proceed {
+					return err
+				}
+			}
+
+			plan, err := provisionPlan(cmd)
 			if err != nil {
-				wrerr := fmt.Errorf("provided flags validation failed: %w", err)
-				stepper.FailCurrentStep(wrerr)
-				return wrerr
+				return fmt.Errorf("failed to plan provisioning: %w", err)
 			}
 
-			stepper.CompleteCurrentStep()
-			clusterClient := cluster.Client{}
+			if showPlan {
+				largeFiles, err := planLargeFiles(ctx, cmd)
+				if err != nil {
+					return fmt.Errorf("failed to check the gitops template for large files: %w", err)
+				}
+				fmt.Fprint(cmd.OutOrStdout(), plan.Render()+largeFiles)
+				return nil
+			}
 
-			provision := provision.NewProvisioner(provision.NewProvisionWatcher(cliFlags.ClusterName, &clusterClient), stepper)
+			if costEstimate, _ := cmd.Flags().GetBool("cost-estimate"); costEstimate {
+				estimate, err := planCostEstimate(ctx, cmd)
+				if err != nil {
+					return fmt.Errorf("failed to estimate the resource consumption: %w", err)
+				}
+				fmt.Fprint(cmd.OutOrStdout(), estimate)
+				return nil
+			}
 
-			if err := provision.ProvisionManagementCluster(ctx, cliFlags, catalogApps); err != nil {
-				return fmt.Errorf("failed to create harvester management cluster: %w", err)
+			if lowBandwidth, _ := cmd.Flags().GetBool("low-bandwidth"); lowBandwidth {
+				proceed, err := confirmLowBandwidthDownload(ctx, cmd)
+				if err != nil {
+					return fmt.Errorf("failed to estimate the download of --low-bandwidth: %w", err)
+				}
+				if !proceed {
+					return nil
+				}
 			}
 
-			return nil
+			if tui, _ := cmd.Flags().GetBool("tui"); tui && !ci {
+				if step.Plain(cmd.ErrOrStderr()) && ui.DashboardFits(cmd.ErrOrStderr()) {
+					fmt.Fprintln(cmd.ErrOrStderr(), "--tui is ignored without colors, falling back to the standard output")
+					return runCreate(ctx, cmd, plan, fromConfig, ui.Frontend{})
+				}
+				if ui.DashboardFits(cmd.ErrOrStderr()) {
+					return runCreateDashboard(cmd, plan, fromConfig)
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "--tui needs a terminal of at least %dx%d, falling back to the standard output\n", ui.MinDashboardWidth, ui.MinDashboardHeight)
+			}
+
+			return runCreate(ctx, cmd, plan, fromConfig, ui.Frontend{})
 		},
 	}
 
+	createCmd.Flags().Bool("plan", false, "print the steps create would run and their time estimates, then exit")
+	createCmd.Flags().String("from-config", "", "cluster config written by export-config to use as defaults")
+	createCmd.Flags().String("print-flags", "", fmt.Sprintf("print the resolved flags and the source of their values, then exit: %s", strings.Join(internalharvester.PrintFlagsFormats, "|")))
+	createCmd.Flags().Lookup("print-flags").NoOptDefVal = internalharvester.PrintFlagsTable
+
 	// Harvester-specific flags
 	createCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
-	createCmd.Flags().String("alerts-email", "", "email address for let's encrypt certificate notifications (required)")
-	createCmd.MarkFlagRequired("alerts-email")
+	createCmd.Flags().String("kubeconfig-from-secret", "", "secret namespace/name[:key] to read the Harvester kubeconfig from")
+	createCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to install into (default its only context)")
+	// alerts-email is required, but may come from --from-config so it is
+	// checked once the config is applied
+	createCmd.Flags().String("alerts-email", "", "comma-separated email addresses for certificate and provisioning notifications (required)")
 	createCmd.Flags().Bool("ci", false, "if running kubefirst in ci, set this flag to disable interactive features")
+	createCmd.Flags().Bool("confirm-phases", false, "ask to proceed with each phase before provisioning starts")
+	createCmd.Flags().Bool("tui", false, "show the steps in a full-screen dashboard")
+	createCmd.Flags().Bool("interactive", false, "ask for the required flags in a guided wizard")
 	createCmd.Flags().String("cloud-region", "on-premise", "NOT USED, PRESENT FOR COMPATIBILITY")
 	createCmd.Flags().String("node-type", "on-premise", "NOT USED, PRESENT FOR COMPATIBILITY")
 	createCmd.Flags().String("node-count", "1", "NOT USED, PRESENT FOR COMPATIBILITY")
 	createCmd.Flags().String("subdomain", "", "NOT USED, PRESENT FOR COMPATIBILITY")
-	createCmd.Flags().Bool("install-kubefirst-pro", true, "NOT USED, PRESENT FOR COMPATIBILITY")
+	createCmd.Flags().Bool("install-kubefirst-pro", true, "whether or not to install kubefirst pro")
+	createCmd.Flags().String("kubefirst-pro-version", internalharvester.LatestKubefirstProVersion, "kubefirst pro chart version to pin, latest resolves to the newest stable release")
+	createCmd.Flags().String("kubefirst-pro-chart-url", internalharvester.DefaultKubefirstProChartURL, "helm repository to install kubefirst pro from")
+	createCmd.Flags().String("components-version-file", "", "YAML mapping of component to the version it is pinned to")
 	createCmd.Flags().String("cluster-name", "kubefirst", "the name of the cluster to create")
 	createCmd.Flags().String("cluster-type", "mgmt", "the type of cluster to create (mgmt|workload)")
-	createCmd.Flags().String("dns-provider", "cloudflare", "DNS provider - one of: cloudflare")
-	createCmd.Flags().String("domain-name", "dittmanfamily.com", "the domain name for your cluster")
+	createCmd.Flags().String("mgmt-kubeconfig", "", "the kubeconfig of the management cluster a workload cluster is registered with")
+	createCmd.Flags().StringToString("cluster-labels", map[string]string{}, "labels to record on the cluster for harvester list --selector (e.g. env=prod,team=platform), repeatable")
+	createCmd.Flags().String("dns-provider", internalharvester.DNSProviderCloudflare, "comma-separated DNS providers to publish the platform records to: cloudflare, route53")
+	createCmd.Flags().String("route53-hosted-zone-id", "", "the Route 53 hosted zone of the domain, required with --dns-provider route53")
+	createCmd.Flags().String("domain-name", "", "the domain name for your cluster (required)")
+	createCmd.Flags().StringSlice("extra-domains", []string{}, "comma-separated list of additional domains to create DNS records and certificates for")
+	createCmd.Flags().StringToString("vcluster-domain-map", map[string]string{}, "per-vCluster ingress domains (e.g. dev=dev.example.org,prod=apps.example.com)")
+	createCmd.Flags().String("storage-class", "", "the storage class of the ArgoCD, Vault and vCluster PVCs (default the cluster default)")
+	createCmd.Flags().StringToString("vcluster-storage-class", map[string]string{}, "per-vCluster storage classes of the vCluster syncer PVCs (e.g. dev=longhorn,prod=ceph)")
+	createCmd.Flags().Bool("prune-dns", false, "delete the dns records kubefirst created that the current domains no longer need")
 	createCmd.Flags().String("git-provider", "github", "git provider - one of: github, gitlab")
 	createCmd.Flags().String("git-protocol", "ssh", "git protocol - one of: https, ssh")
-	createCmd.Flags().String("github-org", "holybitsllc", "the GitHub organization for the new GitOps repository - required if using GitHub")
+	createCmd.Flags().String("github-org", "", "the GitHub organization for the new GitOps repository - required if using GitHub")
+	createCmd.Flags().Int64("github-app-id", 0, "id of a GitHub App to authenticate as instead of GITHUB_TOKEN")
+	createCmd.Flags().String("github-app-key-path", "", "path to the PEM private key of --github-app-id")
 	createCmd.Flags().String("gitlab-group", "", "the GitLab group for the new GitOps project - required if using GitLab")
 	createCmd.Flags().String("gitops-template-url", "https://github.com/konstructio/gitops-template.git", "the fully qualified url to the gitops-template repository")
 	createCmd.Flags().String("gitops-template-branch", "", "the branch to use for the gitops-template repository")
+	createCmd.Flags().String("from-bundle", "", "bootstrap bundle from harvester bundle create to take the gitops template from")
 	createCmd.Flags().String("install-catalog-apps", "", "comma separated values to install after provision")
-	createCmd.Flags().String("lb-ip-range", "10.0.12.0/24", "IP range for Harvester load balancer pool")
+	createCmd.Flags().String("registry-mirror", "", "registry host and path prefix the Harvester nodes pull images through")
+	createCmd.Flags().String("proxy", "", "proxy url for every outbound connection kubefirst makes (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	createCmd.Flags().String("dns-check-doh", "", "DNS-over-HTTPS resolver to check dns propagation with instead of the system one")
+	createCmd.Flags().Lookup("dns-check-doh").NoOptDefVal = internalharvester.DefaultDoHURL
+	createCmd.Flags().Bool("low-bandwidth", false, "prefer slim images and single replicas for sites on slow links")
+	createCmd.Flags().Bool("ha", false, "require the existing cluster to run a highly-available control plane")
+	createCmd.Flags().Int("ha-node-count", internalharvester.DefaultHANodeCount, "number of control plane nodes the cluster is expected to run with --ha, must be odd")
+	createCmd.Flags().StringSlice("gpu-nodes", []string{}, "comma-separated names or label selectors of the Harvester nodes with NVIDIA GPUs")
+	createCmd.Flags().String("gpu-driver-version", internalharvester.DefaultGPUDriverVersion, "tag of the NVIDIA driver image the GPU Operator runs")
+	createCmd.Flags().StringSlice("lb-ip-range", []string{"10.0.12.0/24"}, "IP ranges for the Harvester load balancer pool, repeatable or comma-separated")
+	createCmd.Flags().String("lb-pool-name", internalharvester.LBPoolName, "name of the MetalLB address pool of --lb-ip-range")
+	createCmd.Flags().StringSlice("lb-ip-range-name", []string{}, "<name>:<range> IP ranges for named load balancer pools, repeatable")
 
 	// vCluster flags
 	createCmd.Flags().StringSlice("vclusters", []string{"dev", "test", "prod"}, "comma-separated list of vCluster environments to create")
-	
+	createCmd.Flags().Bool("vcluster-ingress-wildcard", false, "route *.<vcluster>.<domain> into each vCluster")
+	createCmd.Flags().Bool("vcluster-appset", true, "generate the vCluster applications with an ArgoCD ApplicationSet")
+	createCmd.Flags().Bool("vcluster-network-isolation", false, "deny ingress between vCluster namespaces with network policies")
+	createCmd.Flags().StringSlice("allow-vcluster-to-vcluster", []string{}, "comma-separated src:dst vCluster pairs exempt from --vcluster-network-isolation (e.g. dev:test)")
+	createCmd.Flags().Bool("trust-bundle", false, "distribute the --ca-cert certificates into the vClusters")
+	createCmd.Flags().StringSlice("trust-bundle-target", []string{}, "<vcluster>[/<namespace>] entries receiving the trust bundle, repeatable")
+	createCmd.Flags().Bool("trust-manager", false, "install trust-manager to project the trust bundle into the host namespaces")
+	createCmd.Flags().String("trust-bundle-namespace-selector", "", "label selector of the host namespaces trust-manager projects into")
+	createCmd.Flags().String("trust-bundle-probe-url", "", "https URL signed by a --ca-cert CA to verify the trust bundle against")
+	createCmd.Flags().StringArray("vcluster-apps", []string{}, "catalog apps installed into a vCluster as vcluster:app,app entries, repeatable")
+	createCmd.Flags().StringSlice("vcluster-connect", []string{}, "expose a Service of a vCluster to another as src->dst:[namespace/]service, repeatable")
+	createCmd.Flags().StringSlice("vcluster-spec", []string{}, "per-vCluster resources and Kubernetes version, repeatable or comma-separated (e.g. dev=cpu:2,mem:4Gi,k8s:v1.29)")
+	createCmd.Flags().StringSlice("vcluster-node-selector", []string{}, "schedule a vCluster onto labelled nodes as vcluster:key=value[:effect], repeatable")
+	createCmd.Flags().String("vcluster-default-spec", "", "resources and Kubernetes version of vClusters without a --vcluster-spec entry (e.g. cpu:1,mem:2Gi)")
+
 	// Istio/Gateway flags
 	createCmd.Flags().Bool("install-istio", true, "install Istio in ambient mode")
-	createCmd.Flags().String("istio-version", "latest", "version of Istio to install")
+	createCmd.Flags().StringToString("vcluster-istio", map[string]string{}, "per-vCluster Istio ambient mode (e.g. dev=false,prod=true)")
+	createCmd.Flags().String("istio-version", internalharvester.LatestIstioVersion, "version of Istio to install, latest resolves to the newest compatible release")
 	createCmd.Flags().Bool("install-kgateway", true, "install Kubernetes Gateway API and Kgateway")
 
 	// Git repository flags
 	createCmd.Flags().String("gitops-repo", "harvester-argo", "name of the GitOps repository")
+	createCmd.Flags().String("large-file-warn-size", internalharvester.DefaultLargeFileWarnSize, "size above which a file pushed to the gitops repository is reported")
+	createCmd.Flags().String("large-file-max-size", internalharvester.DefaultLargeFileMaxSize, "size above which a file pushed to the gitops repository is refused, see --allow-large-files")
+	createCmd.Flags().Bool("allow-large-files", false, "push files above --large-file-max-size to the gitops repository anyway")
+	createCmd.Flags().StringSlice("git-lfs-patterns", []string{}, "gitattributes patterns of the files to store with Git LFS (e.g. *.tgz)")
+	createCmd.Flags().String("gitops-overlay-dir", "", "local directory merged over the rendered gitops repository")
+	createCmd.Flags().String("gitops-registry-path", "", "path of the ArgoCD root app-of-apps inside the GitOps repository (default registry/<cluster-name>)")
+	createCmd.Flags().Bool("no-branch-protection", false, "leave the GitOps repository main branch unprotected, allowing direct and force pushes")
+	createCmd.Flags().Bool("argocd-write-access", false, "give the ArgoCD deploy key push access to the GitOps repository instead of read-only access")
 
 	// UniFi ingress flags
-	createCmd.Flags().String("unifi-host", "", "UniFi controller host/IP for port-forward and SSL cert upload (e.g. 192.168.1.1)")
+	createCmd.Flags().String("ingress-mode", internalharvester.IngressModeUniFi, "how the ingress is published - one of: unifi, cloudflare-tunnel, none")
+	createCmd.Flags().String("unifi-host", "", "UniFi controller host/IP (e.g. 192.168.1.1), required with --ingress-mode unifi")
 	createCmd.Flags().String("unifi-user", "admin", "UniFi controller username")
-	createCmd.Flags().String("unifi-password", "", "UniFi controller password")
+	createCmd.Flags().String("unifi-password", "", "UniFi controller password, required with --ingress-mode unifi")
+	createCmd.Flags().String("unifi-site", internalharvester.DefaultUniFiSite, "UniFi site the port forwards are configured in")
+	createCmd.Flags().StringSlice("unifi-port-mapping", []string{}, "WAN port forward as proto:external-port:internal-ip:internal-port, repeatable")
+
+	// OIDC/SSO flags
+	createCmd.Flags().String("oidc-issuer-url", "", "issuer url of the OIDC provider ArgoCD and Vault log in against (enables the sso phase)")
+	createCmd.Flags().String("oidc-client-id", "", "OIDC client id registered for kubefirst with the provider")
+	createCmd.Flags().String("oidc-client-secret", "", "OIDC client secret, or env:NAME / file:PATH to read it from an environment variable or file")
+	createCmd.Flags().String("oidc-admin-group", "", "OIDC group granted admin access to ArgoCD and Vault")
+
+	// Observability flags
+	createCmd.Flags().Bool("install-observability", false, "install kube-prometheus-stack with Grafana on grafana.<domain-name>")
+	createCmd.Flags().Bool("logging", false, "install Loki with Promtail on every node")
+	createCmd.Flags().String("logging-retention", internalharvester.DefaultLoggingRetention, "how long Loki keeps logs, in whole days (e.g. 168h, 7d, 2w)")
 
 	// Staged provisioning — stop cleanly after the named phase:
 	//   argocd   → ArgoCD installed + registry app deployed
-	//   ingress  → Cloudflare DNS + UniFi port-forward live
+	//   ingress  → Cloudflare DNS + UniFi port-forward or Cloudflare Tunnel live
 	//   vcluster → platform-vcluster ArgoCD app Healthy/Synced
 	//   vault    → vault ArgoCD app Healthy/Synced
-	createCmd.Flags().String("stop-after", "", "halt provisioning after phase: argocd|ingress|vcluster|vault")
+	//   sso      → ArgoCD and Vault wired to the OIDC provider (only with --oidc-* flags)
+	//   observability → kube-prometheus-stack ArgoCD app Healthy/Synced (only with --install-observability)
+	createCmd.Flags().Bool("skip-verify", false, "skip the final platform health verification after provisioning")
+	createCmd.Flags().Duration("verify-timeout", internalharvester.DefaultVerifyTimeout, "how long the platform gets to become Healthy/Synced during the final verification")
+	createCmd.Flags().Bool("watch-verbose", false, "stream every ArgoCD application health transition while waiting on provisioning")
+	createCmd.Flags().Duration("degraded-grace-period", internalharvester.DefaultDegradedGracePeriod, "fail provisioning once an ArgoCD application has been Degraded for longer than this")
+	createCmd.Flags().Int("max-health-flaps", internalharvester.DefaultMaxHealthFlaps, "fail provisioning once an ArgoCD application has become Degraded more than this many times")
+	createCmd.Flags().String("stop-after", "", "halt provisioning after phase: argocd|ingress|vcluster|vault|sso|observability")
+	createCmd.Flags().Bool("wait", true, "with --stop-after, wait for the applications of the phase to be Healthy/Synced")
+
+	// External Secrets Operator reads from an existing secret store
+	createCmd.Flags().Bool("external-secrets", false, "install External Secrets Operator reading from --external-secrets-backend")
+	createCmd.Flags().String("external-secrets-backend", "", fmt.Sprintf("secret store External Secrets Operator reads from: %s", strings.Join(internalharvester.ExternalSecretsBackends, "|")))
+
+	// Velero backups of the volumes and resources of every namespace
+	createCmd.Flags().String("backup-schedule", "", "cron expression of the Velero backups (e.g. \"0 2 * * *\")")
+	createCmd.Flags().String("backup-storage", "", fmt.Sprintf("object store of the backups: %s", strings.Join(internalharvester.BackupStorages, "|")))
+	createCmd.Flags().String("backup-ttl", internalharvester.DefaultBackupTTL, "how long Velero keeps each backup")
+	createCmd.Flags().String("backup-bucket", "", "bucket, or Azure blob container, of the backups")
+	createCmd.Flags().String("backup-prefix", "", "path in --backup-bucket the backups are written under (default the root of the bucket)")
+
+	// Vault auto-unseal and seeding
+	createCmd.Flags().String("vault-auto-unseal", "", fmt.Sprintf("unseal Vault automatically after restarts: %s", strings.Join(internalharvester.VaultUnsealModes, "|")))
+	createCmd.Flags().String("vault-seed-file", "", "YAML of Vault KV paths and values to write once Vault is initialized")
+	createCmd.Flags().String("vault-audit", internalharvester.VaultAuditFile, fmt.Sprintf("audit device of Vault: %s", strings.Join(internalharvester.VaultAuditModes, "|")))
+	createCmd.Flags().String("vault-audit-size", internalharvester.DefaultVaultAuditSize, "size of the audit log volume of every Vault server (file audit)")
+	createCmd.Flags().Bool("vault-team-policies", false, "install starter Vault policies and Kubernetes auth roles")
+
+	// Chat notifications
+	createCmd.Flags().String("slack-webhook", "", "Slack incoming webhook url to post the provisioning outcome to")
+	createCmd.Flags().String("teams-webhook", "", "Microsoft Teams incoming webhook url to post the provisioning outcome to")
+	createCmd.Flags().String("notify-webhook", "", "webhook url to post the provisioning outcome to as JSON")
+	createCmd.Flags().StringSlice("notify-on-phase", []string{}, "also notify when these install steps finish, named as for --resume-from (e.g. argocd-install,verify-platform-health)")
+	createCmd.Flags().String("notify-webhook-url", "", "webhook url to post the progress of the run to, as selected by --notify-on")
+	createCmd.Flags().String("notify-format", "", fmt.Sprintf("payload format of --notify-webhook-url: %s (default detected from the url)", strings.Join(internalharvester.NotifyFormats, "|")))
+	createCmd.Flags().String("notify-on", internalharvester.NotifyOnAll, fmt.Sprintf("events posted to --notify-webhook-url: %s", strings.Join(internalharvester.NotifyOnValues, "|")))
+
+	createCmd.Flags().Int("api-retry-max", internalharvester.DefaultAPIRetryMax, "number of times a failing git provider or Cloudflare API call is retried")
+	createCmd.Flags().Int("retry-count", internalharvester.DefaultPhaseRetryCount, "number of times a phase failing transiently is run again")
+	createCmd.Flags().Duration("retry-backoff", internalharvester.DefaultPhaseRetryBackoff, "delay before a phase is first run again, doubling with every other")
+
+	createCmd.Flags().String("report-path", "", "directory to write the installation report to (default $HOME/.k1/harvester/<cluster-name>)")
+	createCmd.Flags().String("summary-file", "", "file to write a provisioning summary to, Markdown for .md and JSON otherwise")
+	createCmd.Flags().String("metrics-file", "", "file to write the duration of every phase to as JSON")
+
+	// Existing provision state for --cluster-name is refused unless one of
+	// these says what to do with it
+	createCmd.Flags().Bool("force", false, "discard the existing provision state of the cluster and provision it again from scratch")
+	createCmd.Flags().String("resume-from", "", fmt.Sprintf("install step to continue an existing cluster from (e.g. argocd-install), or %s", internalharvester.ResumeInterrupted))
+	createCmd.MarkFlagsMutuallyExclusive("force", "resume-from")
+
+	// Manifest export
+	createCmd.Flags().String("export-manifests", "", "directory to write every manifest create pushes or applies to")
+	createCmd.Flags().Bool("export-include-secrets", false, "keep the secrets in --export-manifests instead of redacting them")
+	createCmd.Flags().Bool("dry-run", false, "render the manifests without side effects (requires --export-manifests)")
+	createCmd.Flags().Bool("cost-estimate", false, "with --dry-run, print the resources the platform would consume, then exit")
+	createCmd.Flags().Float64("cost-rate-cpu", 0, "price of a vCPU per hour, e.g. 0.048, to add the hourly cost to --cost-estimate")
+	createCmd.Flags().Float64("cost-rate-memory", 0, "price of a GiB of memory per hour, e.g. 0.006, to add the hourly cost to --cost-estimate")
+	createCmd.MarkFlagsMutuallyExclusive("interactive", "ci")
+	createCmd.MarkFlagsRequiredTogether("github-app-id", "github-app-key-path")
+	registerCreateCompletions(createCmd)
 
 	return createCmd
 }
 
+// runCreate provisions the cluster planned by plan, rendering its steps to
+// the standard output or, when frontend has a Log, to frontend. A retry from
+// the dashboard resumes the state the previous attempt left
+func runCreate(ctx context.Context, cmd *cobra.Command, plan internalharvester.Plan, fromConfig map[string]bool, frontend ui.Frontend) (err error) {
+	cloudProvider := "harvester"
+	out, errOut := cmd.OutOrStdout(), cmd.ErrOrStderr()
+	var stepperOptions []step.Option
+	var interrupt *internalharvester.Interrupt
+	// runCtx is the context of the steps, what the run reports once it is
+	// done still goes out after an interrupt canceled it
+	runCtx := ctx
+	if frontend.Log != nil {
+		errOut = frontend.Log
+		stepperOptions = append(stepperOptions, step.WithEventChannel(frontend.Events), step.WithGate(frontend.Gate))
+	} else {
+		// the dashboard reads Ctrl-C as a key, Ctrl-C stops the run
+		// between two steps otherwise
+		interrupt, runCtx = internalharvester.NewInterrupt(ctx)
+		stepperOptions = append(stepperOptions, step.WithGate(interrupt.Gate))
+		if ci, _ := cmd.Flags().GetBool("ci"); ci {
+			stepperOptions = append(stepperOptions, step.WithSingleLineSteps())
+		}
+	}
+
+	notifications := newProvisionNotifications()
+	// deferred first so they run once every step event is drained, the
+	// phase durations printed after the usage summary
+	clusterName, _ := cmd.Flags().GetString("cluster-name")
+	metricsFile, _ := cmd.Flags().GetString("metrics-file")
+	durations := newPhaseDurations(notifications.tracker, strings.TrimSpace(clusterName), metricsFile, errOut)
+	defer func() { durations.finish(err, out, errOut) }()
+	weights := durations.history.Weights(plan.Weights())
+	progress := newProvisionProgress(weights)
+	usage := newInstallUsage(notifications.tracker)
+	defer func() { usage.finish(ctx, err, out, errOut) }()
+	summaryFile, _ := cmd.Flags().GetString("summary-file")
+	summary := newProvisionSummary(notifications.tracker, summaryFile)
+	defer func() { summary.finish(ctx, err, errOut) }()
+	defer func() { notifications.finish(ctx, err, errOut) }()
+	defer func() { progress.finish(err, errOut) }()
+
+	stepper := step.NewStepFactory(errOut, append(stepperOptions,
+		step.WithEventChannel(notifications.events),
+		step.WithEventChannel(progress.events),
+		step.WithProgress(progress.progress),
+	)...)
+
+	if interrupt != nil {
+		defer watchInterrupts(interrupt, stepper)()
+		// deferred last to run first, reporting the interruption to the
+		// deferred calls above
+		defer func() { err = finishInterrupt(ctx, interrupt, plan, err, stepper) }()
+	}
+
+	stepper.DisplayLogHints(cloudProvider, internalharvester.EstimateMinutes(weights))
+
+	stepper.NewProgressStep("Validate Configuration")
+
+	cliFlags, err := utilities.GetFlags(cmd, cloudProvider)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to get flags: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	if err := applyComponentVersions(cliFlags); err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+	identityWarnings, err := checkIdentityFlags(cliFlags)
+	if err != nil {
+		wrerr := fmt.Errorf("invalid configuration:\n%w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	for _, warning := range identityWarnings {
+		stepper.InfoStep(step.EmojiWarning, warning)
+	}
+	summary.configure(cliFlags)
+
+	if ownerFlag := internalharvester.GitOwnerFlag(cliFlags.GitProvider); fromConfig[ownerFlag] {
+		owner, _ := cmd.Flags().GetString(ownerFlag)
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("The gitops repository is created under --%s %s %s, pass --%s to use another owner", ownerFlag, owner, cliFlags.Provenance[ownerFlag], ownerFlag))
+	}
+
+	pathSet := cmd.Flags().Changed("kubeconfig-path") && !fromConfig["kubeconfig-path"]
+	if err := configureKubeconfig(runCtx, cliFlags, pathSet); err != nil {
+		wrerr := fmt.Errorf("invalid kubeconfig source: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	kubeContext, err := selectKubeContext(cliFlags)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to select kubeconfig context: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	// the spinner of a running step would render over the prompt
+	if kubeContext == "" {
+		kubeContext, err = promptKubeContext(cmd.InOrStdin(), errOut, cliFlags.HarvesterKubeconfigPath)
+		if err != nil {
+			return fmt.Errorf("failed to select kubeconfig context: %w", err)
+		}
+	}
+
+	cliFlags.KubeconfigContext = kubeContext
+	viper.Set(kubeContextKey, kubeContext)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record kubeconfig context: %w", err)
+	}
+
+	stepper.NewProgressStep("Run Pre-flight Checks")
+
+	notifier, err := internalharvester.NewNotifier(cliFlags.SlackWebhook, cliFlags.TeamsWebhook, cliFlags.NotifyWebhook, cliFlags.Proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to configure notifications: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	hook, err := internalharvester.NewHookNotifier(cliFlags.NotifyWebhookURL, cliFlags.NotifyFormat, cliFlags.Proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to configure notifications: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	if !cliFlags.DryRun {
+		notifications.configure(notifier, hook, cliFlags)
+	}
+
+	if cliFlags.ExternalSecrets {
+		cliFlags.InstallCatalogApps = internalharvester.WithCatalogApp(cliFlags.InstallCatalogApps, internalharvester.ExternalSecretsCatalogApp)
+	}
+	// the catalog app reads its config keys from the environment
+	if len(cliFlags.GPUNodes) > 0 {
+		cliFlags.InstallCatalogApps = internalharvester.WithCatalogApp(cliFlags.InstallCatalogApps, internalharvester.GPUOperatorCatalogApp)
+		if err := os.Setenv(internalharvester.GPUDriverVersionEnv, cliFlags.GPUDriverVersion); err != nil {
+			wrerr := fmt.Errorf("failed to set %s: %w", internalharvester.GPUDriverVersionEnv, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+	}
+
+	if cliFlags.BackupSchedule != "" {
+		cliFlags.InstallCatalogApps = internalharvester.WithCatalogApp(cliFlags.InstallCatalogApps, internalharvester.VeleroCatalogApp)
+		if err := os.Setenv(internalharvester.VeleroProviderEnv, internalharvester.VeleroProvider(cliFlags.BackupStorage)); err != nil {
+			wrerr := fmt.Errorf("failed to set %s: %w", internalharvester.VeleroProviderEnv, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+	}
+
+	_, catalogApps, err := catalog.ValidateCatalogApps(runCtx, cliFlags.InstallCatalogApps)
+	if err != nil {
+		wrerr := fmt.Errorf("validation of catalog apps failed: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	if err := validateVClusterApps(runCtx, cliFlags); err != nil {
+		wrerr := fmt.Errorf("validation of --vcluster-apps failed: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	result, err := Provision(runCtx, cliFlags, catalogApps, ProvisionOptions{
+		Stepper: stepper,
+		onClient: func(client *internalharvester.Client) {
+			usage.configure(client, cliFlags)
+			summary.configureClient(client)
+			progress.configure(client)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Report == nil {
+		fmt.Fprintf(out, "Manifests written to %s\n", cliFlags.ExportManifests)
+		return nil
+	}
+	printInstallationReport(out, result.Report, result.ReportPath)
+
+	return clearInterrupt()
+}
+
+func Sync() *cobra.Command {
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "force-sync ArgoCD applications on Harvester",
+		Long:  "trigger a hard refresh and sync of ArgoCD applications on the Harvester cluster instead of waiting for the polling interval",
+		RunE:  runSync,
+	}
+
+	syncCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	syncCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	syncCmd.Flags().String("app", "*", "name glob of the ArgoCD applications to sync")
+	syncCmd.Flags().Bool("wait", false, "wait until each synced application is Synced and Healthy")
+	syncCmd.Flags().Duration("timeout", internalharvester.DefaultPhaseTimeout, "how long to wait for applications when --wait is set")
+
+	return syncCmd
+}
+
+func Status() *cobra.Command {
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "show the status of the kubefirst platform on Harvester",
+		Long:  "show the health of the ArgoCD applications of the Harvester cluster in the kubefirst config and, when it was created with --install-observability, of the Prometheus scrape targets, optionally regenerating its installation report",
+		RunE:  runStatus,
+	}
+
+	statusCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	statusCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	statusCmd.Flags().Bool("report", false, "regenerate the installation report from the kubefirst config and the live cluster")
+	statusCmd.Flags().String("report-path", "", "directory to write the installation report to (default the path used by create)")
+
+	return statusCmd
+}
+
+func List() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "list the kubefirst clusters on Harvester",
+		Long:  "list the Harvester clusters kubefirst-api knows with the labels they were created with, optionally filtered by a label selector",
+		RunE:  runList,
+	}
+
+	listCmd.Flags().String("selector", "", "only list the clusters matching this label selector (e.g. env=prod,team in (platform,data))")
+
+	return listCmd
+}
+
+func Describe() *cobra.Command {
+	describeCmd := &cobra.Command{
+		Use:   "describe",
+		Short: "show detailed information about the kubefirst platform on Harvester",
+		Long: `show the nodes, versions, applications and DNS configuration of the Harvester cluster
+
+The Kubernetes, ArgoCD and Istio versions, ArgoCD applications, vclusters
+and cert-manager ClusterIssuers are listed. The output is sorted and free of
+timestamps so runs can be diffed to detect drift, and unhealthy components
+never make it fail.`,
+		RunE: runDescribe,
+	}
+
+	describeCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	describeCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	describeCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	describeCmd.Flags().StringP("output", "o", "text", "output format, text or yaml")
+
+	return describeCmd
+}
+
+func VCluster() *cobra.Command {
+	vclusterCmd := &cobra.Command{
+		Use:   "vcluster",
+		Short: "inspect, add, delete and promote the vClusters of the Harvester platform",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "list the vClusters and their resources and Kubernetes version",
+		Long:  "list the vClusters in the kubefirst config with their host namespace, domain, whether the namespace exists, the spec create applied from --vcluster-spec and --vcluster-default-spec and the nodes --vcluster-node-selector placed it on",
+		RunE:  runVClusterList,
+	}
+
+	listCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	listCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	listCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+
+	addCmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "add a vCluster to the vcluster ApplicationSet",
+		Long:  "commit the directory of a new vCluster with its chart values to the gitops repository, the vcluster ApplicationSet generates its ArgoCD application from it; requires a cluster created with --vcluster-appset",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runVClusterAdd,
+	}
+
+	addCmd.Flags().String("spec", "", "resources and Kubernetes version of the vCluster, as for --vcluster-spec")
+
+	deleteCmd := &cobra.Command{
+		Use:               "delete <name>",
+		Short:             "remove a vCluster from the vcluster ApplicationSet",
+		Long:              "remove the directory of a vCluster from the gitops repository, the vcluster ApplicationSet then deletes its ArgoCD application and the vCluster; its namespace is left behind; requires a cluster created with --vcluster-appset",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRecordedVClusterArg,
+		RunE:              runVClusterDelete,
+	}
+
+	promoteCmd := &cobra.Command{
+		Use:   "promote <name>",
+		Short: "move the applications of a vCluster to a workload cluster",
+		Long: `promote a vCluster environment to a workload cluster ArgoCD manages
+
+The workload cluster is granted the Vault paths of the vCluster secrets, the
+volume data is optionally moved with Velero, and the ArgoCD applications are
+re-pointed in the gitops repository and verified Synced and Healthy there
+before the vCluster is decommissioned. Each completed stage is recorded so a
+rerun resumes where a failure stopped, and a migration report lists what was
+moved and what is left to do by hand.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeRecordedVClusterArg,
+		RunE:              runVClusterPromote,
+	}
+
+	promoteCmd.Flags().String("to-cluster", "", "name ArgoCD has registered the workload cluster under (required)")
+	promoteCmd.MarkFlagRequired("to-cluster")
+	promoteCmd.Flags().Bool("migrate-data", false, "move the volume data of the vCluster with a Velero backup and restore")
+	promoteCmd.Flags().String("target-kubeconfig", "", "path to the kubeconfig of the workload cluster, for --migrate-data")
+	promoteCmd.Flags().String("target-namespace", "", "namespace of the workload cluster the volume claims are restored to (default the vCluster name)")
+	promoteCmd.Flags().Duration("timeout", internalharvester.DefaultPhaseTimeout, "how long the applications get to become Healthy/Synced on the workload cluster")
+	promoteCmd.Flags().String("report-path", "", "directory to write the migration report to (default the path used by create)")
+
+	for _, subCmd := range []*cobra.Command{addCmd, deleteCmd, promoteCmd} {
+		subCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+		subCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+		subCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster and the git provider (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	}
+
+	vclusterCmd.AddCommand(listCmd, addCmd, deleteCmd, promoteCmd)
+
+	return vclusterCmd
+}
+
+func Profiles() *cobra.Command {
+	profilesCmd := &cobra.Command{
+		Use:   "profiles",
+		Short: "inspect the management cluster profiles of this machine",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "list the profiles with their cluster, domain and last known health",
+		Long:  "list the profiles with a kubefirst config on this machine, the cluster and domain create recorded in each and the application health the last harvester status of the profile found",
+		Args:  cobra.NoArgs,
+		RunE:  runProfilesList,
+	}
+
+	profilesCmd.AddCommand(listCmd)
+
+	return profilesCmd
+}
+
+func Maintenance() *cobra.Command {
+	maintenanceCmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "prepare the platform for the drain of a Harvester host and reverse it after",
+	}
+
+	prepareCmd := &cobra.Command{
+		Use:   "prepare",
+		Short: "report the platform components a drain of a node disrupts, optionally moving them first",
+		Long: `report the platform components a drain of the node takes down
+
+The components with ready replicas on other nodes keep serving, the ones only
+running on the node go down with the drain. With --migrate the node is
+cordoned and the movable ones are moved off it, Deployments by a restart
+surging their replacement elsewhere first and StatefulSets such as the
+vcluster control planes by rescheduling their pods. Vault without auto-unseal
+is not moved as it comes back sealed.`,
+		Args: cobra.NoArgs,
+		RunE: runMaintenancePrepare,
+	}
+
+	prepareCmd.Flags().Bool("migrate", false, "cordon the node and move the movable components off it")
+	prepareCmd.Flags().Duration("timeout", internalharvester.DefaultPhaseTimeout, "how long each component gets to become ready on another node")
+
+	completeCmd := &cobra.Command{
+		Use:   "complete",
+		Short: "reverse the changes maintenance prepare made to a node",
+		Long:  "uncordon the node when maintenance prepare --migrate cordoned it and forget its maintenance state. The components moved off the node stay where they run",
+		Args:  cobra.NoArgs,
+		RunE:  runMaintenanceComplete,
+	}
+
+	for _, cmd := range []*cobra.Command{prepareCmd, completeCmd} {
+		cmd.Flags().String("node", "", "name of the Harvester node (required)")
+		cmd.MarkFlagRequired("node")
+		cmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+		cmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+		cmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	}
+
+	maintenanceCmd.AddCommand(prepareCmd, completeCmd)
+
+	return maintenanceCmd
+}
+
+func Vault() *cobra.Command {
+	vaultCmd := &cobra.Command{
+		Use:   "vault",
+		Short: "manage the Vault of the Harvester platform",
+	}
+
+	policiesCmd := &cobra.Command{
+		Use:   "policies",
+		Short: "manage team-scoped Vault policies",
+	}
+
+	applyCmd := &cobra.Command{
+		Use:   "apply",
+		Short: "apply declarative Vault policies and Kubernetes auth roles",
+		Long: `commit Vault policies and auth roles to the gitops repository and write them to Vault
+
+The policies and roles the previously committed file defined and this one
+does not are deleted. A role with a vcluster binds ServiceAccounts inside
+that vcluster through the identities it syncs to the host. Verification logs
+in under every role and checks it reads only the paths its policies allow.`,
+		RunE: runVaultPoliciesApply,
+	}
+
+	applyCmd.Flags().String("file", "", "YAML file of policies and roles to apply (required)")
+	applyCmd.MarkFlagRequired("file")
+	applyCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	applyCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	applyCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster and git provider (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	applyCmd.Flags().Bool("skip-verify", false, "skip logging in under every role to check the paths it can read")
+
+	policiesCmd.AddCommand(applyCmd)
+	vaultCmd.AddCommand(policiesCmd)
+
+	return vaultCmd
+}
+
+func Report() *cobra.Command {
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "show what the Harvester install cost in time and resources",
+		Long:  "show the usage summary create recorded locally: wall time, time per phase, bytes downloaded, external API calls per provider, resources the platform requests and load balancer addresses it holds; nothing is sent anywhere",
+		RunE:  runReport,
+	}
+
+	reportCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+	reportCmd.Flags().String("report-path", "", "directory the usage summary was written to (default the path used by create)")
+	reportCmd.Flags().Bool("refresh", false, "query the resources and load balancer addresses from the live cluster instead of showing those recorded by create")
+	reportCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file, used with --refresh")
+	reportCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+
+	return reportCmd
+}
+
+func Compare() *cobra.Command {
+	compareCmd := &cobra.Command{
+		Use:   "compare",
+		Short: "show how the configurations of two Harvester clusters differ",
+		Long: `compare the configurations of two Harvester clusters
+
+Each cluster is read as kubefirst-api records it, or as a checkpoint written
+by export-config when it cannot be read. The flags that differ, the catalog
+apps and vClusters only one of them has and the Istio and Kgateway versions
+they provision are shown; nothing is changed.`,
+		RunE: runCompare,
+	}
+
+	compareCmd.Flags().String("cluster-a", "", "name of the first cluster to compare")
+	compareCmd.Flags().String("cluster-b", "", "name of the second cluster to compare")
+	compareCmd.Flags().String("checkpoint-a", "", "config export-config wrote for the first cluster, compared when --cluster-a is not given or cannot be read")
+	compareCmd.Flags().String("checkpoint-b", "", "config export-config wrote for the second cluster, compared when --cluster-b is not given or cannot be read")
+	compareCmd.Flags().StringP("output", "o", "text", "output format, text (a unified diff) or json (a list of field, a_value and b_value)")
+
+	return compareCmd
+}
+
+func ExportConfig() *cobra.Command {
+	exportCmd := &cobra.Command{
+		Use:   "export-config",
+		Short: "export the Harvester cluster configuration as YAML",
+		Long:  "export the configuration of the provisioned Harvester cluster as a YAML document that can be passed back to create with --from-config; secrets are replaced with placeholders",
+		RunE:  runExportConfig,
+	}
+
+	exportCmd.Flags().String("cluster-name", "", "name of the cluster to export (default the cluster in the kubefirst config)")
+	exportCmd.Flags().StringP("output", "o", "", "file to write the configuration to (default stdout)")
+	exportCmd.Flags().Bool("with-provenance", false, "comment every flag with where create read its value from")
+
+	return exportCmd
+}
+
+func Rollback() *cobra.Command {
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback",
+		Short: "roll the Harvester gitops repository back to a recorded commit, or the platform back to a phase",
+		Long: `revert the gitops repository or the provisioned phases to an earlier state
+
+The gitops repository default branch is reverted to a commit recorded by a
+previous operation with a new commit, then the ArgoCD applications are
+synced and waited on to become Healthy. With --to-phase the ArgoCD
+applications, dns records and ingress layer the later phases applied are
+removed instead, latest first, printing them and only removing them with
+--confirm; the removed phases are recorded for create --resume-from to
+provision again.`,
+		RunE: runRollback,
+	}
+
+	rollbackCmd.Flags().String("to", "", "recorded commit to roll back to: a SHA (at least 7 characters) or \"previous\"")
+	rollbackCmd.Flags().String("to-phase", "", fmt.Sprintf("phase to roll the platform back to: %s", strings.Join(internalharvester.Phases, "|")))
+	rollbackCmd.Flags().Bool("confirm", false, "with --to-phase, remove the resources instead of only printing them")
+	rollbackCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	rollbackCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster and git provider (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	rollbackCmd.Flags().Duration("timeout", internalharvester.DefaultPhaseTimeout, "how long to wait for applications to become Healthy/Synced after the rollback")
+	rollbackCmd.Flags().Duration("finalizer-timeout", internalharvester.DefaultFinalizerTimeout, "with --to-phase, how long an object may stay deleting before its finalizers are reported")
+	rollbackCmd.MarkFlagsOneRequired("to", "to-phase")
+	rollbackCmd.MarkFlagsMutuallyExclusive("to", "to-phase")
+	rollbackCmd.RegisterFlagCompletionFunc("to-phase", listCompletion(internalharvester.Phases))
+
+	return rollbackCmd
+}
+
+func Pin() *cobra.Command {
+	pinCmd := &cobra.Command{
+		Use:   "pin",
+		Short: "pin the component versions of the Harvester gitops repository",
+		Long:  "lock the helm chart versions of argocd, cert-manager, istio, kgateway and vault in the gitops repository to the versions ArgoCD deployed, or with --unpin revert them to ranges accepting patch releases; the changes are printed as a diff and only pushed with --confirm",
+		RunE:  runPin,
+	}
+
+	pinCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	pinCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	pinCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster and git provider (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	pinCmd.Flags().Bool("unpin", false, "revert pinned chart versions to ranges accepting patch releases of the deployed versions")
+	pinCmd.Flags().Bool("confirm", false, "commit and push the changes to the gitops repository instead of only printing them")
+
+	return pinCmd
+}
+
+func Bundle() *cobra.Command {
+	bundleCmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "build and verify bootstrap bundles pinning the kubefirst binary and gitops template of a site",
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "build a bootstrap bundle",
+		Long: `package kubefirst and a snapshot of the gitops template into a bootstrap bundle
+
+The tar archive holds the kubefirst binary, a snapshot of the gitops
+template, a lockfile listing their checksums and the images the template
+references, and a run script calling harvester create --from-bundle. The
+bundle does not make create work offline: kubefirst-api clones the gitops
+template from its url when provisioning, and the images are listed for
+mirroring into --registry-mirror, not packaged.`,
+		RunE: runBundleCreate,
+	}
+
+	createCmd.Flags().String("output", "bootstrap.tar", "path to write the bundle to")
+	createCmd.Flags().String("gitops-template-url", "https://github.com/konstructio/gitops-template.git", "the fully qualified url to the gitops-template repository")
+	createCmd.Flags().String("gitops-template-branch", "", "the branch to use for the gitops-template repository")
+	createCmd.Flags().String("proxy", "", "proxy url for connections to the git provider (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	createCmd.Flags().Bool("low-bandwidth", false, "list slim image variants and record --low-bandwidth in the lockfile")
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify <bundle>",
+		Short: "check a bootstrap bundle against its lockfile",
+		Long:  "check that a bootstrap bundle, the archive or the directory it was extracted to, holds exactly the files its lockfile records with matching checksums",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runBundleVerify,
+	}
+
+	bundleCmd.AddCommand(createCmd, verifyCmd)
+
+	return bundleCmd
+}
+
+func Replicate() *cobra.Command {
+	replicateCmd := &cobra.Command{
+		Use:   "replicate",
+		Short: "set up a warm-standby Harvester management cluster",
+		Long: `replicate the platform onto a standby Harvester cluster
+
+The ArgoCD of the standby is pointed at the registry of the gitops
+repository the primary deploys, raft snapshots of the primary Vault are
+restored on it with a CronJob, and the dns records harvester failover moves
+onto it are recorded. ArgoCD has to be installed on the standby already.`,
+		RunE: runReplicate,
+	}
+
+	replicateCmd.Flags().String("target-kubeconfig", "", "path to the kubeconfig of the standby Harvester cluster (required)")
+	replicateCmd.MarkFlagRequired("target-kubeconfig")
+	replicateCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to the kubeconfig of the primary Harvester cluster")
+	replicateCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester clusters and cloudflare (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	replicateCmd.Flags().String("schedule", internalharvester.DefaultReplicationSchedule, "cron schedule of the Vault replication onto the standby")
+
+	return replicateCmd
+}
+
+func Failover() *cobra.Command {
+	failoverCmd := &cobra.Command{
+		Use:   "failover",
+		Short: "make the standby Harvester management cluster the active site",
+		Long:  "stop Vault replication onto the standby recorded by harvester replicate, switch its Vault to the replicated credentials and point the platform dns records at its load balancers; the primary is not contacted, so this works when it is down",
+		RunE:  runFailover,
+	}
+
+	failoverCmd.Flags().String("proxy", "", "proxy url for connections to the standby Harvester cluster and cloudflare (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+
+	return failoverCmd
+}
+
 func Destroy() *cobra.Command {
 	destroyCmd := &cobra.Command{
 		Use:   "destroy",
 		Short: "destroy the kubefirst platform on Harvester",
-		Long:  "destroy the kubefirst platform running on Harvester and remove all resources",
-		RunE: func(cmd *cobra.Command, _ []string) error {
-			// Destroy implementation
-			return fmt.Errorf("destroy command not yet implemented")
-		},
+		Long: `destroy the kubefirst platform running on Harvester and remove all resources
+
+With --phases only the named phases are torn down and recorded for create
+--resume-from to rebuild. What is removed is listed and confirmed by typing
+the cluster name unless --yes is set. --force-finalizers never strips
+third-party finalizers.`,
+		RunE: runDestroy,
 	}
 
+	destroyCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	destroyCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	destroyCmd.Flags().String("proxy", "", "proxy url for connections to the git provider (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	destroyCmd.Flags().Duration("finalizer-timeout", internalharvester.DefaultFinalizerTimeout, "how long an object may stay deleting before destroy reports the finalizers holding it")
+	destroyCmd.Flags().Bool("force-finalizers", false, "strip the finalizers kubefirst added from objects stuck past --finalizer-timeout")
+	destroyCmd.Flags().StringSlice("phases", []string{}, fmt.Sprintf("only tear down these phases: %s", strings.Join(internalharvester.TeardownPhases, "|")))
+	destroyCmd.Flags().Bool("force", false, "with --phases, tear down a phase while leaving the phases depending on it running")
+	destroyCmd.Flags().Bool("yes", false, "skip the confirmation listing what destroy removes, e.g. in ci")
+	destroyCmd.RegisterFlagCompletionFunc("phases", listCompletion(internalharvester.TeardownPhases))
+
 	return destroyCmd
 }
 
+func Exposure() *cobra.Command {
+	exposureCmd := &cobra.Command{
+		Use:   "exposure",
+		Short: "inventory what the Harvester platform exposes",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "list the addresses and ports the platform is reachable on and from where",
+		Long: `list what the Harvester cluster exposes and where it is reachable from
+
+Every LoadBalancer service, Gateway listener and Ingress host is listed with
+the UniFi port forwards and Cloudflare records pointing at it, and whether
+it is reachable from the lan or the internet. Entries no ArgoCD application
+syncs from the gitops repository are flagged as unexpected. With --baseline
+it fails when an exposure is not in the approved set, --update-baseline pins
+the current set.`,
+		RunE: runExposureList,
+	}
+
+	listCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	listCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	listCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster, UniFi and Cloudflare (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	listCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+	listCmd.Flags().String("baseline", "", "file of approved exposures, list fails when an exposure is not in it")
+	listCmd.Flags().Bool("update-baseline", false, "pin the current exposures as the approved set in --baseline")
+
+	exposureCmd.AddCommand(listCmd)
+
+	return exposureCmd
+}
+
+func Connect() *cobra.Command {
+	connectCmd := &cobra.Command{
+		Use:   "connect argocd|vault|grafana|vcluster/<name>...",
+		Short: "port-forward the platform UIs and vcluster APIs to localhost",
+		Long: `port-forward a local port to each component through the Harvester kubeconfig
+
+Works before DNS or ingress are live and prints the local URL and login
+credentials of each component. The tunnels stay open until Ctrl-C and follow
+the pods when they restart; several components are forwarded on consecutive
+local ports.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeConnectComponents,
+		RunE:              runConnect,
+	}
+
+	connectCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	connectCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	connectCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+	connectCmd.Flags().Int("local-port", 0, "local port of the first component (default a free port per component)")
+	connectCmd.Flags().Bool("open", false, "open the UIs in the browser once they are forwarded")
+
+	return connectCmd
+}
+
+func Completion() *cobra.Command {
+	completionCmd := &cobra.Command{
+		Use:       "completion bash|zsh|fish|powershell",
+		Short:     "generate the shell completion script of kubefirst with the Harvester flag values",
+		Long:      "generate the completion script of the kubefirst command line for a shell, completing the provider, phase, install step, catalog app and vcluster values of the harvester commands; load it with e.g. source <(kubefirst harvester completion bash)",
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs: completionShells,
+		RunE:      runCompletion,
+	}
+
+	return completionCmd
+}
+
+func Docs() *cobra.Command {
+	docsCmd := &cobra.Command{
+		Use:    "docs",
+		Short:  "generate the Markdown reference pages of the harvester commands",
+		Long:   "generate a Markdown reference page for the harvester command and every subcommand from their cobra definitions, with their usage, flags and defaults, so the docs follow the flags",
+		Hidden: true,
+		Args:   cobra.NoArgs,
+		// the pages are generated from the definitions alone, without the
+		// kubefirst config the harvester hook reads
+		PersistentPreRunE: func(*cobra.Command, []string) error { return nil },
+		RunE:              runDocs,
+	}
+
+	docsCmd.Flags().String("dir", "docs/harvester", "directory to write the reference pages to")
+
+	return docsCmd
+}
+
+func RotateCredentials() *cobra.Command {
+	rotateCmd := &cobra.Command{
+		Use:   "rotate-credentials git|cloudflare|unifi|argocd-admin|kbot-ssh...",
+		Short: "rotate the credentials of the Harvester platform",
+		Long: `rotate each named credential at its source where the source allows it
+
+A GitLab token rotates through its API, a Cloudflare user token is rolled,
+the ArgoCD admin password is generated and a new kbot SSH key replaces the
+gitops deploy key. GitHub tokens and the UniFi password cannot be changed
+through an API; create the new one and set it as NEW_GITHUB_TOKEN,
+NEW_CF_API_TOKEN or NEW_UNIFI_PASSWORD.
+
+Every in-cluster secret and gitops YAML file embedding the old value gets
+the new one, the workloads reading those secrets are restarted and the
+dependent component is verified: ArgoCD fetches the gitops repository, the
+Cloudflare token lists zones, UniFi and ArgoCD accept the login. Secrets
+written by an ExternalSecret are reported, not changed.`,
+		ValidArgs: internalharvester.CredentialTargets,
+		Args:      cobra.OnlyValidArgs,
+		RunE:      runRotateCredentials,
+	}
+
+	rotateCmd.Flags().Bool("all", false, "rotate every credential")
+	rotateCmd.Flags().Bool("dry-run", false, "list what a rotation would touch without changing anything")
+	rotateCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	rotateCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	rotateCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster, git provider and Cloudflare (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+
+	return rotateCmd
+}
+
 func RootCredentials() *cobra.Command {
 	authCmd := &cobra.Command{
 		Use:   "root-credentials",
 		Short: "retrieve root credentials for Harvester cluster",
-		Long:  "retrieve root authentication information for Harvester resources",
-		RunE: func(cmd *cobra.Command, _ []string) error {
-			// Root credentials implementation
-			return fmt.Errorf("root-credentials command not yet implemented")
-		},
+		Long:  "retrieve the ArgoCD admin password, the Vault root token and, with --install-observability, the Grafana admin password of the Harvester cluster, and how Vault is unsealed",
+		RunE:  runRootCredentials,
 	}
 
+	authCmd.Flags().String("kubeconfig-path", "$HOME/.kube/harvester.yaml", "path to Harvester kubeconfig file")
+	authCmd.Flags().String("kubeconfig-context", "", "context of the kubeconfig to use (default the context recorded by create)")
+	authCmd.Flags().String("proxy", "", "proxy url for connections to the Harvester cluster (default honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY)")
+
 	return authCmd
 }
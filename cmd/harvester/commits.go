@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// commitPerChange reads --commit-per-change, defaulting to the choice
+// recorded by create
+func commitPerChange(cmd *cobra.Command) (bool, error) {
+	perChange, err := cmd.Flags().GetBool("commit-per-change")
+	if err != nil {
+		return false, fmt.Errorf("failed to get commit-per-change flag: %w", err)
+	}
+	if !cmd.Flags().Changed("commit-per-change") {
+		perChange = viper.GetBool("flags.commit-per-change")
+	}
+
+	return perChange, nil
+}
+
+// gitopsCommits batches the gitops changes of a command into as few commits
+// as the command allows, recording every commit in the gitops history
+type gitopsCommits struct {
+	client *internalharvester.Client
+	repo   *internalharvester.GitopsRepo
+	batch  *internalharvester.CommitBatch
+}
+
+// newGitopsCommits batches changes to repo, committing each on its own with
+// --commit-per-change
+func newGitopsCommits(client *internalharvester.Client, repo *internalharvester.GitopsRepo, perChange bool) *gitopsCommits {
+	return &gitopsCommits{
+		client: client,
+		repo:   repo,
+		batch:  internalharvester.NewCommitBatch(repo, perChange),
+	}
+}
+
+// add stages files under the change message
+func (c *gitopsCommits) add(ctx context.Context, files map[string][]byte, message string) error {
+	commit, err := c.batch.Add(ctx, files, message)
+	if err != nil {
+		return err
+	}
+	if commit == nil {
+		return nil
+	}
+
+	return recordGitopsCommit(ctx, c.client, commit)
+}
+
+// flush commits the staged changes
+func (c *gitopsCommits) flush(ctx context.Context) error {
+	commit, err := c.batch.Flush(ctx)
+	if err != nil {
+		return err
+	}
+	if commit == nil {
+		return nil
+	}
+
+	return recordGitopsCommit(ctx, c.client, commit)
+}
+
+// stagedYAMLFiles returns the yaml files of the gitops repository with the
+// changes staged in c over them, for a step to change what an earlier step
+// staged rather than override it
+func (c *gitopsCommits) stagedYAMLFiles(ctx context.Context) (map[string][]byte, error) {
+	files, err := c.repo.ReadYAMLFiles(ctx, "/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+	for name, content := range c.batch.Staged() {
+		if path.Ext(name) != ".yaml" && path.Ext(name) != ".yml" {
+			continue
+		}
+		if content == nil {
+			delete(files, name)
+			continue
+		}
+		files[name] = content
+	}
+
+	return files, nil
+}
+
+// flushGitopsCommits pushes the staged changes under a step of their own,
+// before a step that needs ArgoCD to have synced them
+func flushGitopsCommits(ctx context.Context, commits *gitopsCommits, stepper step.Stepper) error {
+	if len(commits.batch.Pending()) == 0 {
+		return nil
+	}
+
+	stepper.NewProgressStep("Push GitOps Changes")
+
+	if err := commits.flush(ctx); err != nil {
+		wrerr := fmt.Errorf("failed to push gitops changes: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
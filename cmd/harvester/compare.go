@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/konstructio/kubefirst/internal/cluster"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runCompare(cmd *cobra.Command, _ []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output flag: %w", err)
+	}
+	if output != "text" && output != "json" {
+		return fmt.Errorf("unknown --output %q, must be text or json", output)
+	}
+
+	sides := make([]*internalharvester.ClusterConfig, 0, 2)
+	names := make([]string, 0, 2)
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+	for _, side := range []string{"a", "b"} {
+		clusterName, _ := cmd.Flags().GetString("cluster-" + side)
+		checkpoint, _ := cmd.Flags().GetString("checkpoint-" + side)
+		if clusterName == "" && checkpoint == "" {
+			return fmt.Errorf("pass --cluster-%s, --checkpoint-%s or both", side, side)
+		}
+
+		cfg, name, err := compareConfig(cmd.Context(), stepper, clusterName, checkpoint)
+		if err != nil {
+			return err
+		}
+		sides = append(sides, cfg)
+		names = append(names, name)
+	}
+
+	differences, err := internalharvester.CompareClusterConfigs(sides[0], sides[1], comparePins(stepper, sides[0]), comparePins(stepper, sides[1]))
+	if err != nil {
+		return fmt.Errorf("failed to compare %s and %s: %w", names[0], names[1], err)
+	}
+
+	if output == "json" {
+		if differences == nil {
+			differences = []internalharvester.ConfigDifference{}
+		}
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(differences); err != nil {
+			return fmt.Errorf("failed to render differences: %w", err)
+		}
+		return nil
+	}
+
+	fmt.Fprint(cmd.OutOrStdout(), internalharvester.RenderConfigDifferences(names[0], names[1], differences, !step.Plain(cmd.OutOrStdout())))
+
+	return nil
+}
+
+// compareConfig returns the configuration of one side of compare and the
+// name it is shown under: the cluster kubefirst-api records, or the
+// checkpoint written by export-config when only it is given or the cluster
+// cannot be read. Nothing is written anywhere
+func compareConfig(ctx context.Context, stepper step.Stepper, clusterName, checkpoint string) (*internalharvester.ClusterConfig, string, error) {
+	if clusterName == "" {
+		cfg, err := internalharvester.LoadClusterConfig(checkpoint)
+		if err != nil {
+			return nil, "", err
+		}
+		return cfg, checkpoint, nil
+	}
+
+	clusterClient := cluster.Client{}
+	provisioned, err := clusterClient.GetCluster(ctx, clusterName)
+	if err != nil {
+		wrerr := kubefirstAPIError(fmt.Sprintf("failed to read cluster %q", clusterName), err)
+		if checkpoint == "" {
+			return nil, "", fmt.Errorf("%w, pass the checkpoint export-config wrote for it to compare it offline", wrerr)
+		}
+
+		cfg, err := internalharvester.LoadClusterConfig(checkpoint)
+		if err != nil {
+			return nil, "", err
+		}
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Comparing cluster %q as of the checkpoint %s: %v", clusterName, checkpoint, wrerr))
+		return cfg, fmt.Sprintf("%s (%s)", clusterName, checkpoint), nil
+	}
+
+	// the kubefirst config only holds the settings of the cluster it created
+	local := clusterName == viper.GetString("flags.cluster-name")
+	cfg, _ := internalharvester.NewClusterConfig(exportedFlags(provisioned, local))
+
+	return cfg, clusterName, nil
+}
+
+// comparePins returns the versions the --components-version-file of cfg
+// pins, none when it has none or the file is not readable here
+func comparePins(stepper step.Stepper, cfg *internalharvester.ClusterConfig) internalharvester.ComponentVersions {
+	file, _ := cfg.Flags["components-version-file"].(string)
+	if file == "" {
+		return nil
+	}
+
+	pins, err := internalharvester.LoadComponentVersions(file)
+	if err != nil {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Comparing the versions of the flags alone: %v", err))
+		return nil
+	}
+
+	return pins
+}
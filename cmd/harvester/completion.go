@@ -0,0 +1,187 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/konstructio/kubefirst-api/pkg/configs"
+	"github.com/konstructio/kubefirst/internal/catalog"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/provision"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// completionShells are the shells harvester completion generates scripts for
+var completionShells = []string{"bash", "zsh", "fish", "powershell"}
+
+// catalogCompletionTimeout bounds the read of the gitops catalog a
+// completion waits on, the cached names are completed once it passes
+const catalogCompletionTimeout = 3 * time.Second
+
+func runCompletion(cmd *cobra.Command, args []string) error {
+	// the script completes the whole kubefirst command line, shells call
+	// back into the binary by the name of the root command
+	root := cmd.Root()
+	out := cmd.OutOrStdout()
+
+	var err error
+	switch args[0] {
+	case "bash":
+		err = root.GenBashCompletionV2(out, true)
+	case "zsh":
+		err = root.GenZshCompletion(out)
+	case "fish":
+		err = root.GenFishCompletion(out, true)
+	case "powershell":
+		err = root.GenPowerShellCompletionWithDesc(out)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to generate %s completion: %w", args[0], err)
+	}
+
+	return nil
+}
+
+// completeList completes the last element of a comma-separated flag value
+// with the values not listed yet
+func completeList(values []string, toComplete string) []string {
+	listed := strings.Split(toComplete, ",")
+	prefix := strings.Join(listed[:len(listed)-1], ",")
+	if prefix != "" {
+		prefix += ","
+	}
+
+	var completions []string
+	for _, value := range values {
+		if !slices.Contains(listed, value) {
+			completions = append(completions, prefix+value)
+		}
+	}
+
+	return completions
+}
+
+// listCompletion completes a comma-separated flag with values
+func listCompletion(values []string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeList(values, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	}
+}
+
+// completeRecordedVClusters completes with the vclusters recorded in the
+// kubefirst config of the profile by create. Completion skips the hooks
+// reading the config, so it is read here
+func completeRecordedVClusters(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if err := configs.InitializeViperConfig(cmd); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if err := useProfile(cmd); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return completeList(viper.GetStringSlice("flags.vclusters"), toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// completeRecordedVClusterArg completes the vcluster argument with the
+// vclusters recorded in the kubefirst config of the profile
+func completeRecordedVClusterArg(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if err := configs.InitializeViperConfig(cmd); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	if err := useProfile(cmd); err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return viper.GetStringSlice("flags.vclusters"), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeCatalogApps completes --install-catalog-apps with the apps of the
+// gitops catalog, cached for CatalogAppsCacheTTL so completion stays fast
+// and works offline
+func completeCatalogApps(cmd *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	path, err := internalharvester.DefaultCatalogAppsCachePath()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), catalogCompletionTimeout)
+	defer cancel()
+
+	apps, err := internalharvester.CachedCatalogApps(ctx, path, time.Now(), func(ctx context.Context) ([]string, error) {
+		active, err := catalog.ReadActiveApplications(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(active.Apps))
+		for _, app := range active.Apps {
+			names = append(names, app.Name)
+		}
+		return names, nil
+	})
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	return completeList(apps, toComplete), cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// completeConnectComponents completes the components of connect not passed
+// yet, the vclusters recorded by create included
+func completeConnectComponents(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
+	components := slices.Clone(internalharvester.ConnectComponents)
+	if err := configs.InitializeViperConfig(cmd); err == nil && useProfile(cmd) == nil {
+		for _, vcluster := range viper.GetStringSlice("flags.vclusters") {
+			components = append(components, "vcluster/"+vcluster)
+		}
+	}
+
+	var completions []string
+	for _, component := range components {
+		if !slices.Contains(args, component) {
+			completions = append(completions, component)
+		}
+	}
+
+	return completions, cobra.ShellCompDirectiveNoFileComp
+}
+
+// registerCreateCompletions completes the provider, phase, install step,
+// catalog app and vcluster flags of create
+func registerCreateCompletions(createCmd *cobra.Command) {
+	completions := map[string]func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective){
+		"git-provider":             cobra.FixedCompletions(supportedGitProviders, cobra.ShellCompDirectiveNoFileComp),
+		"git-protocol":             cobra.FixedCompletions(supportedGitProtocolOverride, cobra.ShellCompDirectiveNoFileComp),
+		"dns-provider":             listCompletion(internalharvester.DNSProviders),
+		"ingress-mode":             cobra.FixedCompletions(internalharvester.IngressModes, cobra.ShellCompDirectiveNoFileComp),
+		"cluster-type":             cobra.FixedCompletions(internalharvester.ClusterTypes, cobra.ShellCompDirectiveNoFileComp),
+		"stop-after":               cobra.FixedCompletions(internalharvester.Phases, cobra.ShellCompDirectiveNoFileComp),
+		"resume-from":              cobra.FixedCompletions(append(provision.InstallStepSlugs(), internalharvester.ResumeInterrupted), cobra.ShellCompDirectiveNoFileComp),
+		"install-catalog-apps":     completeCatalogApps,
+		"logging-retention":        cobra.FixedCompletions([]string{"7d", "14d", "30d"}, cobra.ShellCompDirectiveNoFileComp),
+		"vault-auto-unseal":        cobra.FixedCompletions(internalharvester.VaultUnsealModes, cobra.ShellCompDirectiveNoFileComp),
+		"vault-audit":              cobra.FixedCompletions(internalharvester.VaultAuditModes, cobra.ShellCompDirectiveNoFileComp),
+		"notify-on":                cobra.FixedCompletions(internalharvester.NotifyOnValues, cobra.ShellCompDirectiveNoFileComp),
+		"notify-format":            cobra.FixedCompletions(internalharvester.NotifyFormats, cobra.ShellCompDirectiveNoFileComp),
+		"external-secrets-backend": cobra.FixedCompletions(internalharvester.ExternalSecretsBackends, cobra.ShellCompDirectiveNoFileComp),
+		"backup-storage":           cobra.FixedCompletions(internalharvester.BackupStorages, cobra.ShellCompDirectiveNoFileComp),
+		"gitops-overlay-dir":       cobra.FixedCompletions(nil, cobra.ShellCompDirectiveFilterDirs),
+		"vclusters":                completeRecordedVClusters,
+	}
+	for name, completion := range completions {
+		createCmd.RegisterFlagCompletionFunc(name, completion)
+	}
+}
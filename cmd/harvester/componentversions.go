@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/viper"
+)
+
+// applyComponentVersions overrides the version flags of the components
+// --components-version-file pins, recording them in the kubefirst config
+func applyComponentVersions(cliFlags *types.CliFlags) error {
+	if cliFlags.ComponentsVersionFile == "" {
+		return nil
+	}
+
+	versions, err := internalharvester.LoadComponentVersions(cliFlags.ComponentsVersionFile)
+	if err != nil {
+		return fmt.Errorf("invalid --components-version-file: %w", err)
+	}
+	if version, ok := versions["istio"]; ok {
+		cliFlags.IstioVersion = version
+		viper.Set("flags.istio-version", version)
+	}
+	if version, ok := versions["gpu-driver"]; ok {
+		cliFlags.GPUDriverVersion = version
+		viper.Set("flags.gpu-driver-version", version)
+	}
+	// recorded once ValidateProvidedFlags checked the chart has it
+	if version, ok := versions["kubefirst-pro"]; ok {
+		cliFlags.KubefirstProVersion = version
+	}
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record component versions: %w", err)
+	}
+
+	return nil
+}
+
+// componentVersions returns the versions of the components cliFlags
+// install, once their version flags are resolved
+func componentVersions(cliFlags *types.CliFlags) (internalharvester.ComponentVersions, error) {
+	installed := map[string]string{
+		"argocd":       "",
+		"cert-manager": "",
+		"vault":        "",
+	}
+	if cliFlags.InstallIstio {
+		installed["istio"] = cliFlags.IstioVersion
+	}
+	if cliFlags.InstallKgateway {
+		installed["kgateway"] = ""
+	}
+	if cliFlags.InstallKubefirstPro {
+		installed["kubefirst-pro"] = cliFlags.KubefirstProVersion
+	}
+	if len(cliFlags.GPUNodes) > 0 {
+		installed["gpu-driver"] = cliFlags.GPUDriverVersion
+	}
+
+	var pins internalharvester.ComponentVersions
+	if cliFlags.ComponentsVersionFile != "" {
+		var err error
+		pins, err = internalharvester.LoadComponentVersions(cliFlags.ComponentsVersionFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --components-version-file: %w", err)
+		}
+	}
+
+	return internalharvester.EffectiveComponentVersions(installed, pins), nil
+}
+
+// pinComponentCharts stages the chart versions --components-version-file
+// pins in the gitops repository, over the changes already staged in commits
+// such as the overlay
+func pinComponentCharts(ctx context.Context, cliFlags *types.CliFlags, commits *gitopsCommits) (int, error) {
+	versions, err := internalharvester.LoadComponentVersions(cliFlags.ComponentsVersionFile)
+	if err != nil {
+		return 0, err
+	}
+	pins := versions.ChartPins()
+	if len(pins) == 0 {
+		return 0, nil
+	}
+
+	files, err := commits.stagedYAMLFiles(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	changed := internalharvester.PinChartVersions(files, pins)
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	return len(changed), commits.add(ctx, changed, "pin component versions")
+}
+
+// pinKubefirstPro stages the kubefirst pro version ValidateProvidedFlags
+// resolved and its chart repository in the gitops repository, which
+// kubefirst-api renders with the chart of the template
+func pinKubefirstPro(ctx context.Context, cliFlags *types.CliFlags, commits *gitopsCommits) (int, error) {
+	files, err := commits.stagedYAMLFiles(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	changed, err := internalharvester.PinKubefirstPro(files, cliFlags.KubefirstProVersion, cliFlags.KubefirstProChartURL)
+	if err != nil {
+		return 0, err
+	}
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	return len(changed), commits.add(ctx, changed, fmt.Sprintf("pin kubefirst pro %s", cliFlags.KubefirstProVersion))
+}
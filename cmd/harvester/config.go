@@ -0,0 +1,226 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"github.com/konstructio/kubefirst/internal/cluster"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func runExportConfig(cmd *cobra.Command, _ []string) error {
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	clusterName, err := cmd.Flags().GetString("cluster-name")
+	if err != nil {
+		return fmt.Errorf("failed to get cluster-name flag: %w", err)
+	}
+	if clusterName == "" {
+		clusterName = viper.GetString("flags.cluster-name")
+	}
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output flag: %w", err)
+	}
+
+	withProvenance, err := cmd.Flags().GetBool("with-provenance")
+	if err != nil {
+		return fmt.Errorf("failed to get with-provenance flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Export Cluster Configuration")
+
+	clusterClient := cluster.Client{}
+	provisioned, err := clusterClient.GetCluster(cmd.Context(), clusterName)
+	if err != nil {
+		wrerr := kubefirstAPIError(fmt.Sprintf("failed to read cluster %q", clusterName), err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	flags := exportedFlags(provisioned, true)
+
+	cfg, redacted := internalharvester.NewClusterConfig(flags)
+
+	// create records where every value came from, clusters created before
+	// it did are exported without
+	var provenances map[string]string
+	if withProvenance {
+		provenances = viper.GetStringMapString("flag-provenance")
+	}
+
+	data, err := internalharvester.MarshalClusterConfig(cfg, provenances)
+	if err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	if output == "" {
+		_, err = cmd.OutOrStdout().Write(data)
+	} else {
+		err = os.WriteFile(output, data, 0o600)
+	}
+	if err != nil {
+		wrerr := fmt.Errorf("failed to write cluster config: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	if len(redacted) > 0 {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Secrets were replaced with %s and are ignored by --from-config, pass them as flags when creating: --%s", internalharvester.SecretPlaceholder, strings.Join(redacted, ", --")))
+	}
+
+	return nil
+}
+
+// exportedFlags returns the create flags of the provisioned cluster: the
+// ones kubefirst-api records and, when local is set, the harvester
+// settings of the kubefirst config, which only holds those of the cluster
+// it created
+func exportedFlags(provisioned *apiTypes.Cluster, local bool) map[string]interface{} {
+	// harvester specific settings only live in the kubefirst config
+	flags := map[string]interface{}{}
+	if local {
+		createFlags := Create().Flags()
+		for name := range viper.GetStringMap("flags") {
+			if createFlags.Lookup(name) != nil {
+				flags[name] = viper.Get("flags." + name)
+			}
+		}
+	}
+
+	catalogApps := make([]string, 0, len(provisioned.PostInstallCatalogApps))
+	for _, app := range provisioned.PostInstallCatalogApps {
+		catalogApps = append(catalogApps, app.Name)
+	}
+
+	ownerFlag := internalharvester.GitOwnerFlag(provisioned.GitProvider)
+
+	// the provisioned cluster is authoritative for everything it records,
+	// except alerts-email of which it only records the first address
+	for name, value := range map[string]interface{}{
+		"cluster-name":           provisioned.ClusterName,
+		"cluster-type":           provisioned.ClusterType,
+		"cloud-region":           provisioned.CloudRegion,
+		"domain-name":            provisioned.DomainName,
+		"subdomain":              provisioned.SubdomainName,
+		"dns-provider":           provisioned.DNSProvider,
+		"git-provider":           provisioned.GitProvider,
+		"git-protocol":           provisioned.GitProtocol,
+		ownerFlag:                provisioned.GitAuth.Owner,
+		"gitops-template-url":    provisioned.GitopsTemplateURL,
+		"gitops-template-branch": provisioned.GitopsTemplateBranch,
+		"install-catalog-apps":   strings.Join(catalogApps, ","),
+	} {
+		flags[name] = value
+	}
+
+	// kubefirst-api only records the first of the dns providers the records
+	// are published to
+	providers := viper.GetString("flags.dns-provider")
+	if primary, err := internalharvester.PrimaryDNSProvider(providers); err == nil && local && primary == provisioned.DNSProvider {
+		flags["dns-provider"] = providers
+	}
+
+	return flags
+}
+
+// applyFlagSources sets the flags of cmd that were not given explicitly
+// from their KUBEFIRST_HARVESTER_ environment variable or else the cluster
+// config named by --from-config, recording the provenance of every value.
+// It returns the names of the flags it set
+func applyFlagSources(cmd *cobra.Command) (map[string]bool, error) {
+	path, err := cmd.Flags().GetString("from-config")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get from-config flag: %w", err)
+	}
+
+	var cfg *internalharvester.ClusterConfig
+	values := map[string]string{}
+	if path != "" {
+		cfg, err = internalharvester.LoadClusterConfig(path)
+		if err != nil {
+			return nil, err
+		}
+
+		values, err = cfg.FlagValues()
+		if err != nil {
+			return nil, fmt.Errorf("invalid cluster config %q: %w", path, err)
+		}
+
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if cmd.Flags().Lookup(name) == nil || name == "from-config" {
+				return nil, unknownConfigFlagError(cmd, path, name)
+			}
+		}
+	}
+
+	applied := map[string]bool{}
+	var errs []error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "from-config" {
+			return
+		}
+
+		provenance := internalharvester.ResolveFlagProvenance(flag.Name, flag.Changed, os.LookupEnv, cfg)
+		var value string
+		switch provenance.Source {
+		case internalharvester.FlagSourceEnv:
+			value = os.Getenv(provenance.Env)
+		case internalharvester.FlagSourceFile:
+			value = values[flag.Name]
+		default:
+			return
+		}
+
+		if err := cmd.Flags().Set(flag.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid --%s %s: %w", flag.Name, provenance, err))
+			return
+		}
+		internalharvester.SetFlagProvenance(flag, provenance)
+		applied[flag.Name] = true
+	})
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// unknownConfigFlagError names the flag closest to the unknown name, if any
+func unknownConfigFlagError(cmd *cobra.Command, path, name string) error {
+	var known []string
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name != "from-config" {
+			known = append(known, flag.Name)
+		}
+	})
+
+	if suggestion := internalharvester.SuggestFlag(name, known); suggestion != "" {
+		return fmt.Errorf("cluster config %q sets unknown flag %q, did you mean %q?", path, name, suggestion)
+	}
+
+	return fmt.Errorf("cluster config %q sets unknown flag %q", path, name)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+)
+
+// confirmCreatePhases asks before each phase create would run whether to go
+// on with it. kubefirst-api applies the phases in a single run, so they are
+// all asked before it starts. Declining a phase halts provisioning after
+// the previous one by setting --stop-after, declining argocd cancels create.
+// It reports whether create should go on
+func confirmCreatePhases(cmd *cobra.Command) (bool, error) {
+	stopAfter, err := cmd.Flags().GetString("stop-after")
+	if err != nil {
+		return false, fmt.Errorf("failed to get stop-after flag: %w", err)
+	}
+	installObservability, err := cmd.Flags().GetBool("install-observability")
+	if err != nil {
+		return false, fmt.Errorf("failed to get install-observability flag: %w", err)
+	}
+	logging, err := cmd.Flags().GetBool("logging")
+	if err != nil {
+		return false, fmt.Errorf("failed to get logging flag: %w", err)
+	}
+
+	var phases []string
+	for _, phase := range internalharvester.Phases {
+		if !internalharvester.PhaseEnabled(stopAfter, phase) {
+			continue
+		}
+		if phase == internalharvester.PhaseObservability && !internalharvester.GrafanaEnabled(stopAfter, installObservability, logging) {
+			continue
+		}
+		phases = append(phases, phase)
+	}
+
+	out := cmd.ErrOrStderr()
+	declined, err := promptPhases(cmd.InOrStdin(), out, phases)
+	if err != nil {
+		return false, err
+	}
+
+	switch declined {
+	case "":
+		return true, nil
+	case phases[0]:
+		fmt.Fprintf(out, "create cancelled before phase %s, nothing was provisioned\n", declined)
+		return false, nil
+	default:
+		previous := phases[slices.Index(phases, declined)-1]
+		if err := cmd.Flags().Set("stop-after", previous); err != nil {
+			return false, fmt.Errorf("failed to set --stop-after: %w", err)
+		}
+		fmt.Fprintf(out, "provisioning halts after phase %s, as with --stop-after %s\n", previous, previous)
+		return true, nil
+	}
+}
+
+// promptPhases asks "proceed with phase X? [y/N]" for each of phases in
+// order, returning the first one declined, empty when all are confirmed.
+// End of input declines
+func promptPhases(in io.Reader, out io.Writer, phases []string) (string, error) {
+	scanner := bufio.NewScanner(in)
+	for _, phase := range phases {
+		fmt.Fprintf(out, "proceed with phase %s? [y/N]: ", phase)
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", fmt.Errorf("failed to read confirmation: %w", err)
+			}
+			fmt.Fprintln(out)
+			return phase, nil
+		}
+
+		switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+		case "y", "yes":
+		default:
+			return phase, nil
+		}
+	}
+
+	return "", nil
+}
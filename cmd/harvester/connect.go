@@ -0,0 +1,146 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"sync"
+	"syscall"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runConnect(cmd *cobra.Command, args []string) error {
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	localPort, err := cmd.Flags().GetInt("local-port")
+	if err != nil {
+		return fmt.Errorf("failed to get local-port flag: %w", err)
+	}
+
+	open, err := cmd.Flags().GetBool("open")
+	if err != nil {
+		return fmt.Errorf("failed to get open flag: %w", err)
+	}
+
+	vault := internalharvester.VaultEnabled(viper.GetString("flags.stop-after"))
+	observability := internalharvester.GrafanaEnabled(viper.GetString("flags.stop-after"), viper.GetBool("flags.install-observability"), viper.GetBool("flags.logging"))
+	targets := make([]internalharvester.ConnectTarget, 0, len(args))
+	for _, component := range args {
+		target, err := internalharvester.ResolveConnectTarget(component, vault, observability, viper.GetStringSlice("flags.vclusters"))
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		return fmt.Errorf("failed to create harvester client: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// connecting goes on without the hints when they cannot be read
+	credentials, err := client.ReadRootCredentials(ctx, vault, observability, viper.GetString("flags.vault-auto-unseal"))
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "credentials not read: %v\n", err)
+		credentials = &internalharvester.RootCredentials{}
+	}
+
+	return forwardTargets(ctx, cmd, client, targets, localPort, open, credentials)
+}
+
+// forwardTargets forwards a local port to every target until ctx is done or
+// one of them cannot be forwarded, on consecutive ports from localPort when
+// set and on free ports otherwise
+func forwardTargets(ctx context.Context, cmd *cobra.Command, client *internalharvester.Client, targets []internalharvester.ConnectTarget, localPort int, open bool, credentials *internalharvester.RootCredentials) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i, target := range targets {
+		port := 0
+		if localPort != 0 {
+			port = localPort + i
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := client.PortForward(ctx, target, port, func(port int) {
+				mu.Lock()
+				defer mu.Unlock()
+				fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", target.Component, target.URL(port))
+				if hint := credentials.Hint(target, port); hint != "" {
+					fmt.Fprintf(cmd.OutOrStdout(), "  %s\n", hint)
+				}
+				if open && target.Browsable() {
+					if err := openBrowser(target.URL(port)); err != nil {
+						fmt.Fprintf(cmd.ErrOrStderr(), "%s: failed to open the browser: %v\n", target.Component, err)
+					}
+				}
+			}, func(err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v, reconnecting\n", target.Component, err)
+			})
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to connect to %s: %w", target.Component, err)
+				}
+				mu.Unlock()
+				cancel()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return firstErr
+}
+
+// openBrowser opens url in the default browser of the desktop
+func openBrowser(url string) error {
+	var command *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		command = exec.Command("open", url)
+	case "windows":
+		command = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		command = exec.Command("xdg-open", url)
+	}
+
+	if err := command.Start(); err != nil {
+		return fmt.Errorf("failed to run %s: %w", command.Path, err)
+	}
+
+	return nil
+}
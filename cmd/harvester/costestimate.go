@@ -0,0 +1,66 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+)
+
+// planCostEstimate renders the resources the platform the flags of cmd
+// describe would consume, read from the gitops template without touching
+// the cluster
+func planCostEstimate(ctx context.Context, cmd *cobra.Command) (string, error) {
+	flags := cmd.Flags()
+
+	dryRun, _ := flags.GetBool("dry-run")
+	cpuRate, err := flags.GetFloat64("cost-rate-cpu")
+	if err != nil {
+		return "", fmt.Errorf("failed to get cost-rate-cpu flag: %w", err)
+	}
+	memoryRate, err := flags.GetFloat64("cost-rate-memory")
+	if err != nil {
+		return "", fmt.Errorf("failed to get cost-rate-memory flag: %w", err)
+	}
+	if err := internalharvester.CheckFlagConstraints(internalharvester.CostEstimateFlagConstraints, internalharvester.FlagValues{
+		"cost-estimate":    "true",
+		"dry-run":          strconv.FormatBool(dryRun),
+		"cost-rate-cpu":    strconv.FormatFloat(cpuRate, 'f', -1, 64),
+		"cost-rate-memory": strconv.FormatFloat(memoryRate, 'f', -1, 64),
+	}); err != nil {
+		return "", err
+	}
+	if cpuRate < 0 || memoryRate < 0 {
+		return "", fmt.Errorf("--cost-rate-cpu and --cost-rate-memory cannot be negative")
+	}
+
+	vclusters, err := flags.GetStringSlice("vclusters")
+	if err != nil {
+		return "", fmt.Errorf("failed to get vclusters flag: %w", err)
+	}
+	stopAfter, _ := flags.GetString("stop-after")
+	installIstio, _ := flags.GetBool("install-istio")
+
+	files, err := planTemplateFiles(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	estimate, err := internalharvester.EstimateResources(files, internalharvester.CostEstimateOptions{
+		VClusters: vclusters,
+		Vault:     internalharvester.VaultEnabled(stopAfter),
+		Istio:     installIstio,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "Projected resource consumption, nothing is provisioned:\n\n" + estimate.Render(cpuRate, memoryRate), nil
+}
@@ -7,28 +7,424 @@ See the LICENSE file for more details.
 package harvester
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
 
 	internalssh "github.com/konstructio/kubefirst-api/pkg/ssh"
+	"github.com/konstructio/kubefirst/internal/cluster"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
 	_ "k8s.io/client-go/plugin/pkg/client/auth" // required for k8s authentication
 )
 
-func ValidateProvidedFlags(gitProvider string) error {
-	switch gitProvider {
-	case "github":
-		key, err := internalssh.GetHostKey("github.com")
+// createFlagValues returns the flags of cliFlags that
+// internalharvester.CreateFlagConstraints checks
+func createFlagValues(cliFlags *types.CliFlags) internalharvester.FlagValues {
+	return internalharvester.FlagValues{
+		"git-provider":                    cliFlags.GitProvider,
+		"git-protocol":                    cliFlags.GitProtocol,
+		"github-org":                      cliFlags.GithubOrg,
+		"gitlab-group":                    cliFlags.GitlabGroup,
+		"github-app-id":                   strconv.FormatInt(cliFlags.GitHubAppID, 10),
+		"dry-run":                         strconv.FormatBool(cliFlags.DryRun),
+		"export-manifests":                cliFlags.ExportManifests,
+		"export-include-secrets":          strconv.FormatBool(cliFlags.ExportIncludeSecrets),
+		"from-bundle":                     cliFlags.FromBundle,
+		"ingress-mode":                    internalharvester.IngressModeOf(cliFlags.IngressMode),
+		"unifi-port-mapping":              strings.Join(cliFlags.UniFiPortMappings, ","),
+		"allow-vcluster-to-vcluster":      strings.Join(cliFlags.VClusterAllows, ","),
+		"install-istio":                   strconv.FormatBool(cliFlags.InstallIstio),
+		"install-kgateway":                strconv.FormatBool(cliFlags.InstallKgateway),
+		"extra-domains":                   strings.Join(cliFlags.ExtraDomains, ","),
+		"vcluster-domain-map":             internalharvester.DomainMapString(cliFlags.VClusterDomainMap),
+		"vcluster-istio":                  strings.Join(internalharvester.AmbientVClusters(cliFlags.VClusterIstio), ","),
+		"vcluster-connect":                strings.Join(cliFlags.VClusterConnections, ","),
+		"vcluster-network-isolation":      strconv.FormatBool(cliFlags.VClusterNetworkIsolation),
+		"wait":                            strconv.FormatBool(cliFlags.Wait),
+		"stop-after":                      cliFlags.StopAfter,
+		"external-secrets":                strconv.FormatBool(cliFlags.ExternalSecrets),
+		"external-secrets-backend":        cliFlags.ExternalSecretsBackend,
+		"vault-auto-unseal":               cliFlags.VaultAutoUnseal,
+		"vault-seed-file":                 cliFlags.VaultSeedFile,
+		"vault-team-policies":             strconv.FormatBool(cliFlags.VaultTeamPolicies),
+		"backup-schedule":                 cliFlags.BackupSchedule,
+		"backup-storage":                  cliFlags.BackupStorage,
+		"backup-bucket":                   cliFlags.BackupBucket,
+		"backup-prefix":                   cliFlags.BackupPrefix,
+		"trust-bundle":                    strconv.FormatBool(cliFlags.TrustBundle),
+		"ca-cert":                         strings.Join(cliFlags.CACerts, ","),
+		"trust-bundle-target":             strings.Join(cliFlags.TrustBundleTargets, ","),
+		"trust-manager":                   strconv.FormatBool(cliFlags.TrustManager),
+		"trust-bundle-namespace-selector": cliFlags.TrustBundleSelector,
+		"trust-bundle-probe-url":          cliFlags.TrustBundleProbeURL,
+		"cost-estimate":                   strconv.FormatBool(cliFlags.CostEstimate),
+		"cost-rate-cpu":                   strconv.FormatFloat(cliFlags.CostRateCPU, 'f', -1, 64),
+		"cost-rate-memory":                strconv.FormatFloat(cliFlags.CostRateMemory, 'f', -1, 64),
+		"low-bandwidth":                   strconv.FormatBool(cliFlags.LowBandwidth),
+		"ha":                              strconv.FormatBool(cliFlags.HA),
+		"cluster-type":                    cliFlags.ClusterType,
+		"mgmt-kubeconfig":                 cliFlags.MgmtKubeconfig,
+	}
+}
+
+// checkIdentityFlags normalizes the identity flags of cliFlags, recording
+// them in the kubefirst config, and returns their violations together with
+// those of CreateFlagConstraints, along with the warnings of the
+// normalization
+func checkIdentityFlags(cliFlags *types.CliFlags) ([]string, error) {
+	identity := internalharvester.IdentityFlags{
+		ClusterName: cliFlags.ClusterName,
+		DomainName:  cliFlags.DomainName,
+		AlertsEmail: cliFlags.AlertsEmail,
+		GitProvider: cliFlags.GitProvider,
+		Owners: map[string]string{
+			"github-org":   cliFlags.GithubOrg,
+			"gitlab-group": cliFlags.GitlabGroup,
+		},
+		Provenance: cliFlags.Provenance,
+	}
+	// the gitops template url of a bundle comes from its lockfile
+	if cliFlags.FromBundle == "" {
+		identity.GitopsTemplateURL = cliFlags.GitopsTemplateURL
+	}
+	warnings := identity.Normalize()
+
+	cliFlags.ClusterName = identity.ClusterName
+	cliFlags.DomainName = identity.DomainName
+	cliFlags.AlertsEmail = identity.AlertsEmail
+	if identity.GitopsTemplateURL != "" {
+		cliFlags.GitopsTemplateURL = identity.GitopsTemplateURL
+	}
+	viper.Set("flags.cluster-name", cliFlags.ClusterName)
+	viper.Set("flags.domain-name", cliFlags.DomainName)
+	viper.Set("flags.alerts-email", cliFlags.AlertsEmail)
+
+	return warnings, errors.Join(identity.Validate(), internalharvester.CheckFlagConstraints(internalharvester.CreateFlagConstraints, createFlagValues(cliFlags)))
+}
+
+// validateDNSProviders ensures every --dns-provider has the settings it
+// publishes the records with, reporting the missing ones of each provider
+func validateDNSProviders(ctx context.Context, cliFlags *types.CliFlags) error {
+	providers, err := internalharvester.ParseDNSProviders(cliFlags.DNSProvider)
+	if err != nil {
+		return fmt.Errorf("invalid --dns-provider: %w", err)
+	}
+
+	flags := internalharvester.DNSProviderFlags{
+		CloudflareAPIToken:  os.Getenv("CF_API_TOKEN"),
+		Route53HostedZoneID: cliFlags.Route53HostedZoneID,
+	}
+	if slices.Contains(providers, internalharvester.DNSProviderRoute53) {
+		credentials, err := awsCredentials(ctx)
 		if err != nil {
-			return fmt.Errorf("known_hosts file does not exist - please run `ssh-keyscan github.com >> ~/.ssh/known_hosts` to remedy")
+			return fmt.Errorf("invalid --dns-provider route53: %w", err)
+		}
+		flags.AWSCredentials = credentials
+	}
+	if err := internalharvester.ValidateDNSProviders(ctx, providers, flags); err != nil {
+		return fmt.Errorf("invalid --dns-provider %s:\n%w", cliFlags.DNSProvider, err)
+	}
+
+	return nil
+}
+
+func ValidateProvidedFlags(ctx context.Context, cliFlags *types.CliFlags) error {
+	if !slices.Contains(supportedGitProviders, cliFlags.GitProvider) {
+		return fmt.Errorf("unknown --git-provider %q, must be one of %v", cliFlags.GitProvider, supportedGitProviders)
+	}
+
+	// checked first, a missing owner would otherwise only surface once
+	// kubefirst-api creates the repository
+	warnings, err := checkIdentityFlags(cliFlags)
+	for _, warning := range warnings {
+		log.Warn().Msg(warning)
+	}
+	if err != nil {
+		return err
+	}
+	if err := validateDNSProviders(ctx, cliFlags); err != nil {
+		return err
+	}
+	if err := internalharvester.ValidateClusterType(cliFlags.ClusterType); err != nil {
+		return fmt.Errorf("invalid --cluster-type: %w", err)
+	}
+	if err := internalharvester.ValidateIngressMode(cliFlags.IngressMode, cliFlags.DNSProvider, cliFlags.UniFiHost, cliFlags.UniFiPassword); err != nil {
+		return fmt.Errorf("invalid --ingress-mode: %w", err)
+	}
+	if _, err := internalharvester.ParseUniFiPortMappings(cliFlags.UniFiPortMappings); err != nil {
+		return fmt.Errorf("invalid --unifi-port-mapping: %w", err)
+	}
+	if _, err := internalharvester.ParseLBPools(cliFlags.HarvesterLBPoolName, cliFlags.HarvesterLBIPRanges, cliFlags.HarvesterLBIPRangeNames); err != nil {
+		return fmt.Errorf("invalid --lb-ip-range: %w", err)
+	}
+	for _, domain := range cliFlags.ExtraDomains {
+		if err := internalharvester.ValidateDomainName(domain); err != nil {
+			return fmt.Errorf("invalid --extra-domains: %w", err)
 		}
-		log.Info().Msgf("%q %s", "github.com", key.Type())
-	case "gitlab":
-		key, err := internalssh.GetHostKey("gitlab.com")
+	}
+	if err := internalharvester.ValidateVClusterDomainMap(cliFlags.VClusters, cliFlags.VClusterDomainMap); err != nil {
+		return fmt.Errorf("invalid --vcluster-domain-map: %w", err)
+	}
+	if err := internalharvester.ValidateVClusterStorageClasses(cliFlags.VClusters, cliFlags.VClusterStorageClasses); err != nil {
+		return fmt.Errorf("invalid --vcluster-storage-class: %w", err)
+	}
+	if err := cluster.ValidateClusterLabels(cliFlags.ClusterLabels); err != nil {
+		return fmt.Errorf("invalid --cluster-labels: %w", err)
+	}
+	if err := internalharvester.ValidateVClusterIstio(cliFlags.VClusters, cliFlags.VClusterIstio); err != nil {
+		return fmt.Errorf("invalid --vcluster-istio: %w", err)
+	}
+	if _, err := internalharvester.ParseVClusterAllows(cliFlags.VClusters, cliFlags.VClusterAllows); err != nil {
+		return fmt.Errorf("invalid --allow-vcluster-to-vcluster: %w", err)
+	}
+	connections, err := internalharvester.ParseVClusterConnections(cliFlags.VClusters, cliFlags.VClusterConnections)
+	if err != nil {
+		return fmt.Errorf("invalid --vcluster-connect: %w", err)
+	}
+	if err := internalharvester.ValidateVClusterConnectIstio(connections, cliFlags.VClusterIstio); err != nil {
+		return fmt.Errorf("invalid --vcluster-connect: %w", err)
+	}
+	if _, err := internalharvester.ParseVClusterApps(cliFlags.VClusters, cliFlags.VClusterApps); err != nil {
+		return fmt.Errorf("invalid --vcluster-apps: %w", err)
+	}
+	if err := validateTrustBundle(cliFlags); err != nil {
+		return err
+	}
+	if _, err := internalharvester.ParseVClusterSpec(cliFlags.VClusterDefaultSpec); err != nil {
+		return fmt.Errorf("invalid --vcluster-default-spec: %w", err)
+	}
+	if _, err := internalharvester.ResolveVClusterSpecs(cliFlags.VClusters, cliFlags.VClusterSpecs, cliFlags.VClusterDefaultSpec); err != nil {
+		return fmt.Errorf("invalid --vcluster-spec: %w", err)
+	}
+	if _, err := internalharvester.ParseVClusterPlacements(cliFlags.VClusters, cliFlags.VClusterNodeSelectors); err != nil {
+		return fmt.Errorf("invalid --vcluster-node-selector: %w", err)
+	}
+	if cliFlags.APIRetryMax < 0 {
+		return fmt.Errorf("invalid --api-retry-max: %d must not be negative", cliFlags.APIRetryMax)
+	}
+	if cliFlags.RetryCount < 0 {
+		return fmt.Errorf("invalid --retry-count: %d must not be negative", cliFlags.RetryCount)
+	}
+	if cliFlags.RetryBackoff <= 0 {
+		return fmt.Errorf("invalid --retry-backoff: %s must be positive", cliFlags.RetryBackoff)
+	}
+	if cliFlags.HA {
+		if err := internalharvester.ValidateHANodeCount(cliFlags.HANodeCount); err != nil {
+			return fmt.Errorf("invalid --ha-node-count: %w", err)
+		}
+	}
+	if len(cliFlags.GPUNodes) > 0 {
+		if err := internalharvester.ValidateGPUDriverVersion(cliFlags.GPUDriverVersion); err != nil {
+			return fmt.Errorf("invalid --gpu-driver-version: %w", err)
+		}
+	}
+	if err := internalharvester.ValidateStopAfter(cliFlags.StopAfter); err != nil {
+		return fmt.Errorf("invalid --stop-after: %w", err)
+	}
+	if cliFlags.StopAfter == internalharvester.PhaseObservability && !cliFlags.InstallObservability && !cliFlags.Logging {
+		return errors.New("--stop-after observability requires --install-observability or --logging")
+	}
+	if cliFlags.Logging {
+		if _, err := internalharvester.ParseLoggingRetention(cliFlags.LoggingRetention); err != nil {
+			return fmt.Errorf("invalid --logging-retention: %w", err)
+		}
+	}
+	if cliFlags.ExternalSecrets {
+		if _, err := internalharvester.ExternalSecretsFromEnv(cliFlags.ExternalSecretsBackend); err != nil {
+			return fmt.Errorf("invalid --external-secrets-backend: %w", err)
+		}
+	}
+	if err := validateBackup(cliFlags); err != nil {
+		return err
+	}
+	if err := vaultAutoUnseal(cliFlags).Validate(); err != nil {
+		return fmt.Errorf("invalid --vault-auto-unseal: %w", err)
+	}
+	if cliFlags.VaultAutoUnseal == internalharvester.VaultUnsealStatic {
+		log.Warn().Msgf("--vault-auto-unseal static leaves the Vault unseal keys in %s, anyone able to read it can unseal Vault", internalharvester.VaultUnsealKeysLocation())
+	}
+	if err := vaultAudit(cliFlags).Validate(); err != nil {
+		return fmt.Errorf("invalid --vault-audit: %w", err)
+	}
+	if cliFlags.VaultSeedFile != "" {
+		if _, err := internalharvester.ParseVaultSeedFile(cliFlags.VaultSeedFile); err != nil {
+			return fmt.Errorf("invalid --vault-seed-file: %w", err)
+		}
+	}
+
+	policy, err := internalharvester.NewLargeFilePolicy(cliFlags.LargeFileWarnSize, cliFlags.LargeFileMaxSize, cliFlags.AllowLargeFiles, cliFlags.GitLFSPatterns)
+	if err != nil {
+		return fmt.Errorf("invalid --large-file-warn-size, --large-file-max-size or --git-lfs-patterns: %w", err)
+	}
+	policy.OnWarning = func(message string) {
+		log.Warn().Msg(message)
+	}
+
+	var bundle *internalharvester.Bundle
+	if cliFlags.FromBundle != "" {
+		var err error
+		if bundle, err = internalharvester.OpenBundle(cliFlags.FromBundle); err != nil {
+			return fmt.Errorf("invalid --from-bundle: %w", err)
+		}
+		if err := bundle.Verify(); err != nil {
+			return fmt.Errorf("bundle %s is incomplete: %w", cliFlags.FromBundle, err)
+		}
+		log.Info().Msgf("gitops template %s at commit %s from bundle %s", bundle.Lock.GitopsTemplateURL, bundle.Lock.GitopsTemplateCommit, cliFlags.FromBundle)
+		if _, err := checkTemplateFiles(policy, bundle.Gitops); err != nil {
+			return err
+		}
+
+		cliFlags.GitopsTemplateURL = bundle.Lock.GitopsTemplateURL
+		cliFlags.GitopsTemplateBranch = bundle.Lock.GitopsTemplateBranch
+		log.Warn().Msgf("kubefirst-api clones the gitops template from %s when provisioning, the site still needs to reach it", cliFlags.GitopsTemplateURL)
+	}
+
+	if err := logProxyRoutes(cliFlags); err != nil {
+		return err
+	}
+	if err := internalharvester.CheckProxyConnectivity(ctx, cliFlags.Proxy, cliFlags.GitopsTemplateURL); err != nil {
+		return fmt.Errorf("proxy pre-check failed: %w", err)
+	}
+
+	if cliFlags.RegistryMirror != "" {
+		if err := internalharvester.ValidateRegistryMirror(cliFlags.RegistryMirror); err != nil {
+			return fmt.Errorf("invalid --registry-mirror: %w", err)
+		}
+
+		httpClient, err := internalharvester.NewHTTPClient(cliFlags.Proxy)
+		if err != nil {
+			return fmt.Errorf("failed to create http client: %w", err)
+		}
+		if err := internalharvester.CheckRegistryMirror(ctx, httpClient, cliFlags.RegistryMirror); err != nil {
+			return fmt.Errorf("invalid --registry-mirror: %w", err)
+		}
+	}
+
+	if cliFlags.SlackWebhook != "" {
+		if err := internalharvester.ValidateWebhookURL(cliFlags.SlackWebhook); err != nil {
+			return fmt.Errorf("invalid --slack-webhook: %w", err)
+		}
+	}
+	if cliFlags.TeamsWebhook != "" {
+		if err := internalharvester.ValidateWebhookURL(cliFlags.TeamsWebhook); err != nil {
+			return fmt.Errorf("invalid --teams-webhook: %w", err)
+		}
+	}
+	if cliFlags.NotifyWebhook != "" {
+		if err := internalharvester.ValidateWebhookURL(cliFlags.NotifyWebhook); err != nil {
+			return fmt.Errorf("invalid --notify-webhook: %w", err)
+		}
+	}
+	if cliFlags.NotifyWebhookURL != "" {
+		if err := internalharvester.ValidateWebhookURL(cliFlags.NotifyWebhookURL); err != nil {
+			return fmt.Errorf("invalid --notify-webhook-url: %w", err)
+		}
+	}
+	if err := internalharvester.ValidateNotifyFormat(cliFlags.NotifyFormat); err != nil {
+		return fmt.Errorf("invalid --notify-format: %w", err)
+	}
+	if err := internalharvester.ValidateNotifyOn(cliFlags.NotifyOn); err != nil {
+		return fmt.Errorf("invalid --notify-on: %w", err)
+	}
+
+	if _, err := internalharvester.NewHostResolver(cliFlags.DNSCheckDoH, cliFlags.Proxy); err != nil {
+		return fmt.Errorf("invalid --dns-check-doh: %w", err)
+	}
+
+	if cliFlags.GitopsOverlayDir != "" {
+		if _, err := internalharvester.LoadGitopsOverlay(cliFlags.GitopsOverlayDir); err != nil {
+			return fmt.Errorf("invalid --gitops-overlay-dir: %w", err)
+		}
+	}
+	if cliFlags.ComponentsVersionFile != "" {
+		if _, err := internalharvester.LoadComponentVersions(cliFlags.ComponentsVersionFile); err != nil {
+			return fmt.Errorf("invalid --components-version-file: %w", err)
+		}
+	}
+	if cliFlags.GitopsRegistryPath != "" {
+		registryPath := internalharvester.RegistryPath(cliFlags.GitopsRegistryPath, cliFlags.ClusterName)
+
+		var exists bool
+		switch {
+		case bundle != nil:
+			exists = bundle.PathExists(registryPath, cliFlags.ClusterName)
+		default:
+			var err error
+			exists, err = internalharvester.TemplatePathExists(ctx, cliFlags.GitopsTemplateURL, cliFlags.GitopsTemplateBranch, registryPath, cliFlags.ClusterName, cliFlags.Proxy)
+			if err != nil {
+				return fmt.Errorf("unable to validate --gitops-registry-path: %w", err)
+			}
+		}
+		if !exists {
+			return fmt.Errorf("--gitops-registry-path %q does not exist in gitops template %q", registryPath, cliFlags.GitopsTemplateURL)
+		}
+		cliFlags.GitopsRegistryPath = registryPath
+	}
+
+	if cliFlags.InstallKubefirstPro {
+		httpClient, err := internalharvester.NewHTTPClient(cliFlags.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid --proxy: %w", err)
+		}
+
+		version, err := internalharvester.ResolveKubefirstProVersion(ctx, httpClient, cliFlags.KubefirstProChartURL, cliFlags.KubefirstProVersion)
 		if err != nil {
-			return fmt.Errorf("known_hosts file does not exist - please run `ssh-keyscan gitlab.com >> ~/.ssh/known_hosts` to remedy")
+			return fmt.Errorf("invalid --kubefirst-pro-version: %w", err)
+		}
+		log.Info().Msgf("kubefirst pro version %q pinned to %s from %s", cliFlags.KubefirstProVersion, version, cliFlags.KubefirstProChartURL)
+
+		cliFlags.KubefirstProVersion = version
+		viper.Set("flags.kubefirst-pro-version", version)
+		if err := viper.WriteConfig(); err != nil {
+			return fmt.Errorf("failed to pin kubefirst pro version: %w", err)
+		}
+	}
+
+	if oidc := oidcConfig(cliFlags); oidc.Enabled() {
+		if err := oidc.Validate(); err != nil {
+			return fmt.Errorf("invalid sso configuration: %w", err)
+		}
+
+		clientSecret, err := internalharvester.ResolveSecret(cliFlags.OIDCClientSecret)
+		if err != nil {
+			return fmt.Errorf("unable to resolve --oidc-client-secret: %w", err)
+		}
+		cliFlags.OIDCClientSecret = clientSecret
+
+		httpClient, err := internalharvester.NewHTTPClient(cliFlags.Proxy)
+		if err != nil {
+			return fmt.Errorf("invalid --proxy: %w", err)
+		}
+
+		if _, err := internalharvester.FetchDiscoveryDocument(ctx, httpClient, cliFlags.OIDCIssuerURL); err != nil {
+			return fmt.Errorf("unable to reach oidc issuer: %w", err)
+		}
+	}
+
+	log.Info().Msgf("ingress domains: %v", internalharvester.Domains(cliFlags.DomainName, cliFlags.ExtraDomains, cliFlags.VClusterDomainMap))
+
+	if err := validateGitProtocol(cliFlags); err != nil {
+		return err
+	}
+
+	switch cliFlags.GitProvider {
+	case "github", "gitlab":
+		if cliFlags.GitProtocol == "ssh" {
+			gitHost := cliFlags.GitProvider + ".com"
+			key, err := internalssh.GetHostKey(gitHost)
+			if err != nil {
+				return fmt.Errorf("known_hosts file does not exist - please run `ssh-keyscan %s >> ~/.ssh/known_hosts` to remedy", gitHost)
+			}
+			log.Info().Msgf("%q %s", gitHost, key.Type())
 		}
-		log.Info().Msgf("%q %s", "gitlab.com", key.Type())
 	}
 
 	return nil
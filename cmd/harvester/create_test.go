@@ -0,0 +1,205 @@
+package harvester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseCreateFlags resolves args, env and the cluster config of flags, if
+// any, as the create command does before validating them
+func parseCreateFlags(t *testing.T, args []string, env map[string]string, flags string) *types.CliFlags {
+	t.Helper()
+
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	viper.SetConfigFile(filepath.Join(t.TempDir(), "kubefirst.yaml"))
+
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+	if flags != "" {
+		path := filepath.Join(t.TempDir(), "cluster.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("apiVersion: "+internalharvester.ClusterConfigAPIVersion+"\nkind: "+internalharvester.ClusterConfigKind+"\nflags:\n"+flags), 0o600))
+		args = append(args, "--from-config", path)
+	}
+
+	cmd, _, err := NewCommand().Find([]string{"create"})
+	require.NoError(t, err)
+	require.NoError(t, cmd.ParseFlags(args))
+	_, err = applyFlagSources(cmd)
+	require.NoError(t, err)
+	cliFlags, err := ParseFlags(cmd)
+	require.NoError(t, err)
+
+	return cliFlags
+}
+
+func TestValidateProvidedFlags(t *testing.T) {
+	valid := []string{"--domain-name", "example.com", "--alerts-email", "ops@example.com", "--github-org", "holybits", "--git-protocol", "https", "--ingress-mode", "none", "--install-kubefirst-pro=false"}
+	credentials := map[string]string{
+		"CF_API_TOKEN":          "token",
+		"GITHUB_TOKEN":          "token",
+		"AWS_ACCESS_KEY_ID":     "id",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		config  string
+		wantErr string
+	}{
+		{
+			name: "valid flags",
+			args: valid,
+		},
+		{
+			name:    "unknown git provider",
+			args:    append([]string{"--git-provider", "bitbucket"}, valid...),
+			wantErr: `unknown --git-provider "bitbucket"`,
+		},
+		{
+			name:    "missing domain name",
+			args:    []string{"--alerts-email", "ops@example.com", "--github-org", "holybits", "--git-protocol", "https", "--ingress-mode", "none"},
+			wantErr: `required flag "domain-name" not set`,
+		},
+		{
+			name:    "invalid cluster name from the command line",
+			args:    append([]string{"--cluster-name", "Not_A_Name"}, valid...),
+			wantErr: "invalid --cluster-name from the command line",
+		},
+		{
+			name:    "invalid cluster name from the environment",
+			args:    valid,
+			env:     map[string]string{"KUBEFIRST_HARVESTER_CLUSTER_NAME": "Not_A_Name"},
+			wantErr: "invalid --cluster-name from env KUBEFIRST_HARVESTER_CLUSTER_NAME",
+		},
+		{
+			name:    "invalid cluster name from the config file",
+			args:    valid,
+			config:  "  cluster-name: Not_A_Name\n",
+			wantErr: "invalid --cluster-name from config file ",
+		},
+		{
+			name:   "command line over the environment and the config file",
+			args:   append([]string{"--cluster-name", "homelab"}, valid...),
+			env:    map[string]string{"KUBEFIRST_HARVESTER_CLUSTER_NAME": "Not_A_Name"},
+			config: "  cluster-name: Not_A_Name\n",
+		},
+		{
+			name:    "environment over the config file",
+			args:    valid,
+			env:     map[string]string{"KUBEFIRST_HARVESTER_CLUSTER_NAME": "Not_A_Name"},
+			config:  "  cluster-name: homelab\n",
+			wantErr: "invalid --cluster-name from env KUBEFIRST_HARVESTER_CLUSTER_NAME",
+		},
+		{
+			name:    "missing cloudflare token",
+			args:    valid,
+			env:     map[string]string{"CF_API_TOKEN": ""},
+			wantErr: "your CF_API_TOKEN environment variable is not set",
+		},
+		{
+			name:    "route53 without hosted zone",
+			args:    append([]string{"--dns-provider", "cloudflare,route53"}, valid...),
+			wantErr: `required flag "route53-hosted-zone-id" not set`,
+		},
+		{
+			name: "route53 with hosted zone",
+			args: append([]string{"--dns-provider", "cloudflare,route53", "--route53-hosted-zone-id", "Z123"}, valid...),
+		},
+		{
+			name:    "https without git token",
+			args:    valid,
+			env:     map[string]string{"GITHUB_TOKEN": ""},
+			wantErr: "--git-protocol https clones and pushes the gitops repository with your GITHUB_TOKEN",
+		},
+		{
+			name:    "unifi ingress without controller",
+			args:    append(valid, "--ingress-mode", "unifi"),
+			wantErr: `invalid --ingress-mode: required flag "unifi-host" not set`,
+		},
+		{
+			name: "unifi ingress",
+			args: append(valid, "--ingress-mode", "unifi", "--unifi-host", "unifi.lan", "--unifi-password", "secret"),
+		},
+		{
+			name:    "ambient vcluster without istio",
+			args:    append(valid, "--vcluster-istio", "dev=false,prod=true", "--install-istio=false"),
+			wantErr: "--vcluster-istio requires --install-istio",
+		},
+		{
+			name: "vcluster opting out of istio without it",
+			args: append(valid, "--vcluster-istio", "dev=false", "--install-istio=false"),
+		},
+		{
+			name:    "invalid extra domain",
+			args:    append(valid, "--extra-domains", "https://example.org"),
+			wantErr: "invalid --extra-domains: \"https://example.org\" has a https:// scheme",
+		},
+		{
+			name:    "extra domains without kgateway",
+			args:    append(valid, "--extra-domains", "example.org", "--install-kgateway=false"),
+			wantErr: "--extra-domains requires --install-kgateway",
+		},
+		{
+			name:    "unknown cluster type",
+			args:    append([]string{"--cluster-type", "edge"}, valid...),
+			wantErr: "invalid --cluster-type",
+		},
+		{
+			name:    "negative api retries",
+			args:    append([]string{"--api-retry-max", "-1"}, valid...),
+			wantErr: "invalid --api-retry-max: -1 must not be negative",
+		},
+		{
+			name:    "stop after observability without it",
+			args:    append([]string{"--stop-after", internalharvester.PhaseObservability}, valid...),
+			wantErr: "--stop-after observability requires --install-observability or --logging",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range credentials {
+				t.Setenv(name, value)
+			}
+			cliFlags := parseCreateFlags(t, tt.args, tt.env, tt.config)
+
+			err := ValidateProvidedFlags(context.Background(), cliFlags)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCreateFlagProvenance(t *testing.T) {
+	cliFlags := parseCreateFlags(t,
+		[]string{"--domain-name", "example.com"},
+		map[string]string{"KUBEFIRST_HARVESTER_DOMAIN_NAME": "example.org", "KUBEFIRST_HARVESTER_GITHUB_ORG": "holybits"},
+		"  domain-name: example.net\n  github-org: konstructio\n  alerts-email: ops@example.com\n",
+	)
+
+	assert.Equal(t, "example.com", cliFlags.DomainName)
+	assert.Equal(t, "holybits", cliFlags.GithubOrg)
+	assert.Equal(t, "ops@example.com", cliFlags.AlertsEmail)
+	assert.Equal(t, "kubefirst", cliFlags.ClusterName)
+
+	assert.Equal(t, "from the command line", cliFlags.Provenance["domain-name"])
+	assert.Equal(t, "from env KUBEFIRST_HARVESTER_GITHUB_ORG", cliFlags.Provenance["github-org"])
+	assert.Contains(t, cliFlags.Provenance["alerts-email"], "from config file ")
+	assert.Equal(t, "by default", cliFlags.Provenance["cluster-name"])
+	assert.Equal(t, cliFlags.Provenance, viper.GetStringMapString("flag-provenance"))
+}
@@ -0,0 +1,43 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runRootCredentials(cmd *cobra.Command, _ []string) error {
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		return fmt.Errorf("failed to create harvester client: %w", err)
+	}
+
+	vault := internalharvester.VaultEnabled(viper.GetString("flags.stop-after"))
+	observability := internalharvester.GrafanaEnabled(viper.GetString("flags.stop-after"), viper.GetBool("flags.install-observability"), viper.GetBool("flags.logging"))
+	credentials, err := client.ReadRootCredentials(cmd.Context(), vault, observability, viper.GetString("flags.vault-auto-unseal"))
+	if err != nil {
+		return fmt.Errorf("failed to read root credentials: %w", err)
+	}
+
+	fmt.Fprint(cmd.OutOrStdout(), credentials.Text())
+
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runDescribe(cmd *cobra.Command, _ []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output flag: %w", err)
+	}
+	if output != "text" && output != "yaml" {
+		return fmt.Errorf("unknown --output %q, must be text or yaml", output)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		return fmt.Errorf("failed to create harvester client: %w", err)
+	}
+
+	stopAfter := viper.GetString("flags.stop-after")
+	opts := internalharvester.DescribeOptions{
+		ClusterName:             viper.GetString("flags.cluster-name"),
+		DomainName:              viper.GetString("flags.domain-name"),
+		DNSProvider:             viper.GetString("flags.dns-provider"),
+		ExtraDomains:            viper.GetStringSlice("flags.extra-domains"),
+		VClusterDomainMap:       viper.GetStringMapString("flags.vcluster-domain-map"),
+		VClusterIngressWildcard: viper.GetBool("flags.vcluster-ingress-wildcard"),
+		Vault:                   internalharvester.VaultEnabled(stopAfter),
+	}
+	if internalharvester.PhaseEnabled(stopAfter, internalharvester.PhaseVCluster) {
+		opts.VClusters = viper.GetStringSlice("flags.vclusters")
+	}
+
+	description, err := client.Describe(cmd.Context(), opts)
+	if err != nil {
+		return fmt.Errorf("failed to describe cluster: %w", err)
+	}
+
+	if output == "text" {
+		fmt.Fprint(cmd.OutOrStdout(), description.Text())
+		return nil
+	}
+
+	data, err := description.YAML()
+	if err != nil {
+		return err
+	}
+	cmd.OutOrStdout().Write(data)
+
+	return nil
+}
@@ -0,0 +1,526 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/provision"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runDestroy(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	finalizerTimeout, err := cmd.Flags().GetDuration("finalizer-timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get finalizer-timeout flag: %w", err)
+	}
+
+	forceFinalizers, err := cmd.Flags().GetBool("force-finalizers")
+	if err != nil {
+		return fmt.Errorf("failed to get force-finalizers flag: %w", err)
+	}
+
+	phases, err := cmd.Flags().GetStringSlice("phases")
+	if err != nil {
+		return fmt.Errorf("failed to get phases flag: %w", err)
+	}
+
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return fmt.Errorf("failed to get force flag: %w", err)
+	}
+
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		return fmt.Errorf("failed to get yes flag: %w", err)
+	}
+
+	if len(phases) > 0 {
+		phases, err = internalharvester.ValidateTeardownPhases(phases, force)
+		if err != nil {
+			return fmt.Errorf("invalid --phases: %w", err)
+		}
+	}
+
+	stepper.NewProgressStep("Connect to Harvester")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := pingCluster(ctx, client, stepper); err != nil {
+		return err
+	}
+
+	if err := checkProfileCluster(ctx, client, stepper); err != nil {
+		return err
+	}
+
+	var teardowns []phaseTeardown
+	if len(phases) == 0 {
+		scope, err := client.TeardownScope(ctx)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to list the platform resources: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		teardowns = append(teardowns, phaseTeardown{scope: scope})
+	}
+
+	targets := internalharvester.TeardownTargets(viper.GetStringSlice("flags.vclusters"), viper.GetBool("flags.external-secrets"), viper.GetBool("flags.vcluster-appset"))
+	for _, phase := range phases {
+		scope, err := client.PhaseTeardownScope(ctx, phase, targets)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to list the resources of phase %s: %w", phase, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		teardowns = append(teardowns, phaseTeardown{phase: phase, scope: scope})
+	}
+
+	lbPools, err := recordedLBPools()
+	if err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	// the records create published point at the ingress layer, they go
+	// with it
+	var records []dnsProviderRecords
+	if len(phases) == 0 || slices.Contains(phases, internalharvester.PhaseIngress) {
+		records, err = destroyDNSRecords(ctx, client)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to list the dns records to remove: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+	}
+
+	stepper.CompleteCurrentStep()
+
+	// the gitops repository outlives a scoped teardown
+	removeDeployKeys := len(phases) == 0 && viper.GetInt64(deployKeyIDKey) != 0
+	removeHTTPSCredential := len(phases) == 0 && viper.GetBool(argoCDHTTPSCredentialKey)
+	// the tunnel publishes the ingress layer, it goes with it
+	removeTunnel := viper.GetString(cloudflareTunnelKey) != "" && (len(phases) == 0 || slices.Contains(phases, internalharvester.PhaseIngress))
+	removePortForwards := len(viper.GetStringSlice(uniFiPortForwardsKey)) > 0 && (len(phases) == 0 || slices.Contains(phases, internalharvester.PhaseIngress))
+
+	interrupted, wasInterrupted, err := internalharvester.ParseInterruptState(viper.GetString(interruptStateKey))
+	if err != nil {
+		return fmt.Errorf("failed to read the interrupt state in the kubefirst config: %w", err)
+	}
+	if wasInterrupted && len(phases) == 0 {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Create was interrupted before %q on %s, destroying what it created", interrupted.Step, interrupted.Time.Format(time.RFC3339)))
+	}
+
+	if !yes {
+		resources := destroyResources(teardowns, lbPools, removeDeployKeys)
+		if removeHTTPSCredential {
+			resources = append(resources, fmt.Sprintf("the ArgoCD https credential of the gitops repository %s", viper.GetString("flags.gitops-repo")))
+		}
+		for _, provider := range records {
+			for _, record := range provider.records {
+				resources = append(resources, fmt.Sprintf("the %s record of %s at %s", record.Type, record.Name, provider.name))
+			}
+		}
+		if removeTunnel {
+			resources = append(resources, fmt.Sprintf("the Cloudflare Tunnel %s and its dns records", internalharvester.TunnelName(viper.GetString("flags.cluster-name"))))
+		}
+		if removePortForwards {
+			resources = append(resources, fmt.Sprintf("the UniFi port forwards %s", strings.Join(viper.GetStringSlice(uniFiPortForwardsKey), ", ")))
+		}
+		if err := confirmDestroy(cmd.InOrStdin(), cmd.ErrOrStderr(), viper.GetString("flags.cluster-name"), resources); err != nil {
+			return err
+		}
+	}
+
+	watchdog := internalharvester.NewFinalizerWatchdog(finalizerTimeout, forceFinalizers, func(message string) {
+		stepper.InfoStep(step.EmojiWarning, message)
+	})
+
+	state, err := internalharvester.ParseTeardownState(viper.GetString(teardownStateKey))
+	if err != nil {
+		return fmt.Errorf("failed to read the teardown state in the kubefirst config: %w", err)
+	}
+
+	// the records stop pointing at the ingress layer before it is removed
+	if countRecords(records) > 0 {
+		stepper.NewProgressStep("Remove DNS Records")
+
+		deleted, err := deleteDNSRecords(ctx, records)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to remove dns records: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("dns records deleted %s", strings.Join(deleted, ", ")))
+	}
+
+	var applications, namespaces int
+	for _, teardown := range teardowns {
+		if err := teardown.run(ctx, client, lbPools, watchdog, stepper); err != nil {
+			return err
+		}
+		applications += len(teardown.scope.Applications)
+		namespaces += len(teardown.scope.Namespaces)
+
+		if teardown.phase == "" {
+			continue
+		}
+		// recorded per phase, an interrupted teardown is still rebuilt
+		state.Record(teardown.phase, teardown.paused)
+		viper.Set(teardownStateKey, state.String())
+		if err := viper.WriteConfig(); err != nil {
+			return fmt.Errorf("failed to record the teardown of phase %s: %w", teardown.phase, err)
+		}
+	}
+
+	if removeTunnel {
+		stepper.NewProgressStep("Delete Cloudflare Tunnel")
+
+		deleted, err := deleteCloudflareTunnel(ctx, client)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to delete cloudflare tunnel: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		if len(deleted) > 0 {
+			stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("dns records deleted %s", strings.Join(deleted, ", ")))
+		}
+	}
+
+	if removePortForwards {
+		stepper.NewProgressStep("Remove UniFi Port Forwards")
+
+		deleted, err := deleteUniFiPortForwards(ctx, client)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to remove unifi port forwards: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		if len(deleted) > 0 {
+			stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("unifi port forwards deleted %s", strings.Join(deleted, ", ")))
+		}
+	}
+
+	if removeHTTPSCredential {
+		stepper.NewProgressStep("Remove ArgoCD Repository Credential")
+
+		if err := removeArgoCDHTTPSCredential(ctx, client); err != nil {
+			wrerr := fmt.Errorf("failed to remove ArgoCD repository credential: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if removeDeployKeys {
+		gitopsRepo, err := recordedGitopsRepo(client)
+		if err != nil {
+			return fmt.Errorf("failed to resolve gitops repository: %w", err)
+		}
+
+		stepper.NewProgressStep("Remove ArgoCD Deploy Key")
+
+		if err := removeDeployKey(ctx, gitopsRepo); err != nil {
+			wrerr := fmt.Errorf("failed to remove deploy key: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	// the interrupted create is gone with the platform
+	if wasInterrupted && len(phases) == 0 {
+		if err := clearInterrupt(); err != nil {
+			return err
+		}
+	}
+
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Removed %d applications and %d namespaces", applications, namespaces))
+	if len(phases) > 0 {
+		stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Removed phases %s, rebuild them with: kubefirst harvester create --resume-from %s", strings.Join(phases, ", "), resumeStep(state.Phases)))
+	}
+
+	return nil
+}
+
+// teardownStateKey holds the internalharvester.TeardownState of the phases
+// destroy --phases removed
+const teardownStateKey = "harvester.teardown-state"
+
+// phaseTeardown is the teardown of a phase, or of the whole platform when
+// phase is empty
+type phaseTeardown struct {
+	phase  string
+	scope  internalharvester.TeardownScope
+	paused map[string]json.RawMessage
+}
+
+// run removes the scope of t, each step only starting once the previous one
+// is fully gone: namespaces deleted while their content still has
+// finalizers hang forever
+func (t *phaseTeardown) run(ctx context.Context, client *internalharvester.Client, lbPools internalharvester.LBPools, watchdog *internalharvester.FinalizerWatchdog, stepper step.Stepper) error {
+	// addresses only have to be free once the ingress layer is gone
+	if t.phase != "" && t.phase != internalharvester.PhaseIngress && t.phase != internalharvester.PhaseArgoCD {
+		lbPools = nil
+	}
+
+	var teardown []struct {
+		title string
+		run   func(ctx context.Context) error
+	}
+	add := func(title string, run func(ctx context.Context) error) {
+		if t.phase != "" {
+			title = fmt.Sprintf("%s (%s)", title, t.phase)
+		}
+		teardown = append(teardown, struct {
+			title string
+			run   func(ctx context.Context) error
+		}{title, run})
+	}
+
+	if len(t.scope.Owners) > 0 {
+		// the owners would recreate the applications as soon as deleted
+		add("Pause ArgoCD Sync", func(ctx context.Context) error {
+			var err error
+			t.paused, err = client.PauseSync(ctx, t.scope.Owners)
+			return err
+		})
+	}
+	add("Delete ArgoCD Applications", func(ctx context.Context) error { return client.DeleteApplications(ctx, t.scope, watchdog) })
+	add("Wait for Workload Deletion", func(ctx context.Context) error { return client.WaitForWorkloadDeletion(ctx, t.scope, watchdog) })
+	// the Vault StatefulSet leaves its audit log claims behind
+	add("Delete Vault Audit Logs", func(ctx context.Context) error { return client.DeleteVaultAuditClaims(ctx, t.scope, watchdog) })
+	add("Release Load Balancer Addresses", func(ctx context.Context) error { return client.ReleaseLoadBalancers(ctx, t.scope, lbPools, watchdog) })
+	add("Remove Webhooks and CRDs", func(ctx context.Context) error { return client.DeleteOwnedResources(ctx, t.scope, watchdog) })
+	add("Delete Namespaces", func(ctx context.Context) error { return client.DeleteNamespaces(ctx, t.scope, watchdog) })
+
+	for _, teardownStep := range teardown {
+		stepper.NewProgressStep(teardownStep.title)
+
+		stepCtx, cancel := context.WithTimeout(ctx, internalharvester.DefaultPhaseTimeout)
+		err := teardownStep.run(stepCtx)
+		cancel()
+		if err != nil {
+			wrerr := fmt.Errorf("failed to %s: %w", strings.ToLower(teardownStep.title), err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	return nil
+}
+
+// recordedLBPools returns the load balancer address pools recorded by
+// create, nil when it configured none
+func recordedLBPools() (internalharvester.LBPools, error) {
+	ranges, namedRanges := viper.GetStringSlice("flags.lb-ip-range"), viper.GetStringSlice("flags.lb-ip-range-name")
+	if len(ranges) == 0 && len(namedRanges) == 0 {
+		return nil, nil
+	}
+
+	lbPools, err := internalharvester.ParseLBPools(viper.GetString("flags.lb-pool-name"), ranges, namedRanges)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lb-ip-range in the kubefirst config: %w", err)
+	}
+
+	return lbPools, nil
+}
+
+// destroyResources lists what destroy removes, for its confirmation
+func destroyResources(teardowns []phaseTeardown, lbPools internalharvester.LBPools, deployKey bool) []string {
+	var resources []string
+	for _, teardown := range teardowns {
+		prefix := ""
+		if teardown.phase != "" {
+			prefix = fmt.Sprintf("phase %s: ", teardown.phase)
+		}
+		if len(teardown.scope.Applications) > 0 {
+			resources = append(resources, fmt.Sprintf("%sArgoCD applications %s", prefix, strings.Join(teardown.scope.Applications, ", ")))
+		}
+		if len(teardown.scope.Namespaces) > 0 {
+			resources = append(resources, fmt.Sprintf("%snamespaces %s, with their load balancer services and volumes", prefix, strings.Join(teardown.scope.Namespaces, ", ")))
+		}
+		if len(teardown.scope.Owners) > 0 {
+			resources = append(resources, fmt.Sprintf("%sautomated sync of %s, paused until create rebuilds the phase", prefix, strings.Join(teardown.scope.Owners, ", ")))
+		}
+	}
+	if lbPools != nil {
+		resources = append(resources, fmt.Sprintf("the addresses of %s are released", lbPools))
+	}
+	if deployKey {
+		resources = append(resources, fmt.Sprintf("the ArgoCD deploy key of the gitops repository %s", viper.GetString("flags.gitops-repo")))
+	}
+
+	return resources
+}
+
+// confirmDestroy lists resources and asks for the cluster name before
+// anything is removed
+func confirmDestroy(in io.Reader, out io.Writer, clusterName string, resources []string) error {
+	fmt.Fprintf(out, "destroy removes from cluster %q:\n", clusterName)
+	for _, resource := range resources {
+		fmt.Fprintf(out, "  - %s\n", resource)
+	}
+	fmt.Fprintf(out, "type the cluster name to confirm: ")
+
+	scanner := bufio.NewScanner(in)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read confirmation: %w", err)
+		}
+		return errors.New("destroy not confirmed, pass --yes to skip the confirmation")
+	}
+	if answer := strings.TrimSpace(scanner.Text()); answer != clusterName {
+		return fmt.Errorf("destroy not confirmed: %q is not the cluster name %q", answer, clusterName)
+	}
+
+	return nil
+}
+
+// resumeStep returns the install step create resumes from to rebuild the
+// torn down phases
+func resumeStep(phases []string) string {
+	switch {
+	case slices.Contains(phases, internalharvester.PhaseArgoCD):
+		return provision.StepSlug(provision.ArgoCDInstallCheck)
+	case slices.Contains(phases, internalharvester.PhaseVault):
+		return provision.StepSlug(provision.VaultInitializedCheck)
+	default:
+		return provision.StepSlug(provision.FinalCheck)
+	}
+}
+
+// restoreTornDownPhases sets back the sync the teardown of phases paused,
+// for ArgoCD to recreate their applications, and forgets the teardown
+func restoreTornDownPhases(ctx context.Context, client *internalharvester.Client, stepper step.Stepper) error {
+	state, err := internalharvester.ParseTeardownState(viper.GetString(teardownStateKey))
+	if err != nil {
+		return fmt.Errorf("failed to read the teardown state in the kubefirst config: %w", err)
+	}
+	if len(state.Phases) == 0 {
+		return nil
+	}
+
+	stepper.NewProgressStep("Restore Torn Down Phases")
+
+	if err := client.RestoreSync(ctx, state.PausedSync); err != nil {
+		wrerr := fmt.Errorf("failed to restore the sync of phases %s: %w", strings.Join(state.Phases, ", "), err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	viper.Set(teardownStateKey, "")
+	if err := viper.WriteConfig(); err != nil {
+		wrerr := fmt.Errorf("failed to clear the teardown state: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
+
+// destroyDNSRecords returns the records create reconciled for the platform
+// hosts of the cluster, at every dns provider create published them to
+func destroyDNSRecords(ctx context.Context, client *internalharvester.Client) ([]dnsProviderRecords, error) {
+	providers, err := recordedDNSProviders(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return managedDNSRecords(ctx, providers, recordedPlatformHosts(viper.GetString("flags.stop-after")), internalharvester.ManagedRecordComment(viper.GetString("flags.cluster-name")))
+}
+
+// deleteCloudflareTunnel deletes the Cloudflare Tunnel create published the
+// ingress through and the CNAME records pointing at it, returning the hosts
+// of the records deleted
+func deleteCloudflareTunnel(ctx context.Context, client *internalharvester.Client) ([]string, error) {
+	tunnel, err := internalharvester.ParseCloudflareTunnel(viper.GetString(cloudflareTunnelKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cloudflare tunnel in the kubefirst config: %w", err)
+	}
+
+	dns, err := internalharvester.NewCloudflareDNS(os.Getenv("CF_API_TOKEN"), client.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	dns.Retry = client.Retry
+
+	deleted, err := dns.DeleteTunnel(ctx, tunnel, viper.GetStringSlice(dnsZonesKey), internalharvester.ManagedRecordComment(viper.GetString("flags.cluster-name")))
+	if err != nil {
+		return deleted, err
+	}
+
+	viper.Set(cloudflareTunnelKey, "")
+	if err := viper.WriteConfig(); err != nil {
+		return deleted, fmt.Errorf("failed to remove cloudflare tunnel from config: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// deleteUniFiPortForwards deletes the UniFi port forwards create configured,
+// returning the names of those deleted
+func deleteUniFiPortForwards(ctx context.Context, client *internalharvester.Client) ([]string, error) {
+	controller, err := newUniFiController(client)
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := controller.DeletePortForwards(ctx, viper.GetStringSlice(uniFiPortForwardsKey))
+	if err != nil {
+		return deleted, err
+	}
+
+	viper.Set(uniFiPortForwardsKey, []string{})
+	if err := viper.WriteConfig(); err != nil {
+		return deleted, fmt.Errorf("failed to remove unifi port forwards from config: %w", err)
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,68 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDNSProvider holds records in memory
+type fakeDNSProvider struct {
+	internalharvester.DNSProvider
+	records []internalharvester.DNSRecord
+	err     error
+}
+
+func (p *fakeDNSProvider) ManagedRecords(_ context.Context, hosts []string, comment string) ([]internalharvester.DNSRecord, error) {
+	var managed []internalharvester.DNSRecord
+	for _, record := range p.records {
+		if record.Comment == comment && slices.Contains(hosts, record.Name) {
+			managed = append(managed, record)
+		}
+	}
+
+	return managed, nil
+}
+
+func (p *fakeDNSProvider) DeleteRecord(_ context.Context, record internalharvester.DNSRecord) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.records = slices.DeleteFunc(p.records, func(other internalharvester.DNSRecord) bool { return other == record })
+
+	return nil
+}
+
+func TestDestroyDNSRecords(t *testing.T) {
+	comment := internalharvester.ManagedRecordComment("homelab")
+	cloudflare := &fakeDNSProvider{records: []internalharvester.DNSRecord{
+		{ID: "1", Type: "A", Name: "argocd.example.com", Content: "10.0.12.5", Comment: comment},
+		{ID: "2", Type: "A", Name: "gitea.example.com", Content: "10.0.12.7"},
+	}}
+	route53 := &fakeDNSProvider{records: []internalharvester.DNSRecord{
+		{ID: "argocd.example.com.", Type: "A", Name: "argocd.example.com", Content: "10.0.12.5", Comment: comment},
+	}}
+	providers := []dnsProviderClient{{name: "cloudflare", dns: cloudflare}, {name: "route53", dns: route53}}
+
+	records, err := managedDNSRecords(context.Background(), providers, []string{"argocd.example.com", "gitea.example.com"}, comment)
+	require.NoError(t, err)
+	assert.Equal(t, 2, countRecords(records))
+
+	deleted, err := deleteDNSRecords(context.Background(), records)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"argocd.example.com (cloudflare)", "argocd.example.com (route53)"}, deleted)
+	assert.Equal(t, []internalharvester.DNSRecord{{ID: "2", Type: "A", Name: "gitea.example.com", Content: "10.0.12.7"}}, cloudflare.records)
+	assert.Empty(t, route53.records)
+
+	route53.records = []internalharvester.DNSRecord{{Name: "vault.example.com", Comment: comment}}
+	route53.err = fmt.Errorf("test error")
+	records, err = managedDNSRecords(context.Background(), providers, []string{"vault.example.com"}, comment)
+	require.NoError(t, err)
+	_, err = deleteDNSRecords(context.Background(), records)
+	require.EqualError(t, err, "route53: test error")
+}
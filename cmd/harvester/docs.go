@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func runDocs(cmd *cobra.Command, _ []string) error {
+	dir, _ := cmd.Flags().GetString("dir")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create docs directory: %w", err)
+	}
+
+	// the pages document the harvester command tree, docs itself is hidden
+	root := cmd.Parent()
+	count, err := writeDocPages(root, root, dir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "wrote %d reference pages to %s\n", count, dir)
+
+	return nil
+}
+
+// writeDocPages writes the Markdown page of cmd and of every available
+// subcommand of it to dir, returning how many it wrote. Pages link up to
+// their parent down to root
+func writeDocPages(root, cmd *cobra.Command, dir string) (int, error) {
+	count := 0
+	for _, child := range availableCommands(cmd) {
+		written, err := writeDocPages(root, child, dir)
+		if err != nil {
+			return 0, err
+		}
+		count += written
+	}
+
+	path := filepath.Join(dir, docPageName(cmd))
+	if err := os.WriteFile(path, docPage(root, cmd), 0o644); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return count + 1, nil
+}
+
+// availableCommands returns the subcommands of cmd that are not hidden,
+// deprecated or help topics, by name
+func availableCommands(cmd *cobra.Command) []*cobra.Command {
+	var commands []*cobra.Command
+	for _, child := range cmd.Commands() {
+		if child.IsAvailableCommand() && !child.IsAdditionalHelpTopicCommand() {
+			commands = append(commands, child)
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name() < commands[j].Name() })
+
+	return commands
+}
+
+// docPageName returns the file name of the page of cmd, e.g.
+// kubefirst_harvester_create.md
+func docPageName(cmd *cobra.Command) string {
+	return strings.ReplaceAll(cmd.CommandPath(), " ", "_") + ".md"
+}
+
+// docPage renders the reference page of cmd from its cobra definition:
+// usage, examples, flags and the commands around it. Nothing depends on
+// the time or the machine, so regenerated pages only differ as the flags do
+func docPage(root, cmd *cobra.Command) []byte {
+	cmd.InitDefaultHelpFlag()
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "## %s\n\n%s\n\n", cmd.CommandPath(), cmd.Short)
+	if cmd.Long != "" {
+		fmt.Fprintf(&b, "### Synopsis\n\n%s\n\n", cmd.Long)
+	}
+	if cmd.Runnable() {
+		fmt.Fprintf(&b, "```\n%s\n```\n\n", cmd.UseLine())
+	}
+	if cmd.Example != "" {
+		fmt.Fprintf(&b, "### Examples\n\n```\n%s\n```\n\n", cmd.Example)
+	}
+
+	if flags := cmd.NonInheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&b, "### Options\n\n```\n%s```\n\n", flags.FlagUsages())
+	}
+	if flags := cmd.InheritedFlags(); flags.HasAvailableFlags() {
+		fmt.Fprintf(&b, "### Options inherited from parent commands\n\n```\n%s```\n\n", flags.FlagUsages())
+	}
+
+	children := availableCommands(cmd)
+	if cmd == root && len(children) == 0 {
+		return b.Bytes()
+	}
+	b.WriteString("### SEE ALSO\n\n")
+	if cmd != root {
+		parent := cmd.Parent()
+		fmt.Fprintf(&b, "* [%s](%s)\t - %s\n", parent.CommandPath(), docPageName(parent), parent.Short)
+	}
+	for _, child := range children {
+		fmt.Fprintf(&b, "* [%s](%s)\t - %s\n", child.CommandPath(), docPageName(child), child.Short)
+	}
+	b.WriteString("\n")
+
+	return b.Bytes()
+}
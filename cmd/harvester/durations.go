@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+)
+
+// phaseDurations times the phases of a create against those of the
+// previous successful ones, recorded in the phase durations file
+type phaseDurations struct {
+	path    string
+	history *internalharvester.PhaseDurations
+	tracker *internalharvester.PhaseTracker
+
+	// metricsFile receives the breakdown of the run of clusterName, when set
+	metricsFile string
+	clusterName string
+	startedAt   time.Time
+}
+
+// newPhaseDurations loads the recorded durations. Failing that the run is
+// estimated from the plan alone and records nothing
+func newPhaseDurations(tracker *internalharvester.PhaseTracker, clusterName, metricsFile string, errOut io.Writer) *phaseDurations {
+	d := &phaseDurations{
+		tracker:     tracker,
+		history:     &internalharvester.PhaseDurations{Phases: map[string][]float64{}},
+		metricsFile: metricsFile,
+		clusterName: clusterName,
+		startedAt:   time.Now(),
+	}
+
+	path, err := internalharvester.DefaultPhaseDurationsPath()
+	if err == nil {
+		var history *internalharvester.PhaseDurations
+		if history, err = internalharvester.LoadPhaseDurations(path); err == nil {
+			d.path, d.history = path, history
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "warning: failed to read phase durations, estimating from the plan: %v\n", err)
+	}
+
+	return d
+}
+
+// finish prints how long each phase of the run took, writes the
+// --metrics-file breakdown and, once it succeeded for real, records the
+// durations for the next runs. It must run after the step events are
+// drained. Failed writes are reported to errOut but never fail the run
+func (d *phaseDurations) finish(runErr error, out, errOut io.Writer) {
+	phases := d.tracker.Phases()
+	if len(phases) == 0 {
+		return
+	}
+
+	fmt.Fprintf(out, "\n%s", d.history.Render(phases))
+
+	if d.metricsFile != "" {
+		metrics := internalharvester.NewPhaseMetrics(d.clusterName, d.startedAt, time.Now(), runErr == nil, phases)
+		if err := internalharvester.WritePhaseMetrics(d.metricsFile, metrics); err != nil {
+			fmt.Fprintf(errOut, "warning: failed to write --metrics-file: %v\n", err)
+		}
+	}
+
+	if runErr != nil || internalharvester.DryRun() || d.path == "" {
+		return
+	}
+	d.history.Record(phases)
+	if err := d.history.Save(d.path); err != nil {
+		fmt.Fprintf(errOut, "warning: failed to record phase durations: %v\n", err)
+	}
+}
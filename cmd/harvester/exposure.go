@@ -0,0 +1,173 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runExposureList(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output flag: %w", err)
+	}
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown --output %q, must be table or json", output)
+	}
+
+	baselinePath, err := cmd.Flags().GetString("baseline")
+	if err != nil {
+		return fmt.Errorf("failed to get baseline flag: %w", err)
+	}
+
+	updateBaseline, err := cmd.Flags().GetBool("update-baseline")
+	if err != nil {
+		return fmt.Errorf("failed to get update-baseline flag: %w", err)
+	}
+	if updateBaseline && baselinePath == "" {
+		return errors.New("--update-baseline requires --baseline")
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	clusterName := viper.GetString("flags.cluster-name")
+	if clusterName == "" {
+		return errors.New("no cluster recorded in the kubefirst config, run harvester create first")
+	}
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		return fmt.Errorf("failed to create harvester client: %w", err)
+	}
+
+	inventory, err := exposureInventory(ctx, client, clusterName)
+	if err != nil {
+		return err
+	}
+
+	if output == "table" {
+		if err := inventory.WriteTable(cmd.OutOrStdout()); err != nil {
+			return fmt.Errorf("failed to render exposures: %w", err)
+		}
+	} else {
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(inventory); err != nil {
+			return fmt.Errorf("failed to render exposures: %w", err)
+		}
+	}
+
+	if baselinePath == "" {
+		return nil
+	}
+
+	if updateBaseline {
+		if err := internalharvester.WriteExposureBaseline(baselinePath, inventory.Exposures); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "pinned %d exposures in %s\n", len(inventory.Exposures), baselinePath)
+		return nil
+	}
+
+	return checkExposureBaseline(cmd.ErrOrStderr(), baselinePath, inventory.Exposures)
+}
+
+// exposureInventory correlates the Kubernetes exposures with the UniFi
+// port forwards and the records of the cluster at every dns provider. UniFi
+// and the dns providers are skipped when create was not configured for
+// them and noted in the inventory when they cannot be queried
+func exposureInventory(ctx context.Context, client *internalharvester.Client, clusterName string) (*internalharvester.ExposureInventory, error) {
+	kubernetes, err := client.KubernetesExposures(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := &internalharvester.ExposureInventory{}
+
+	var forwards []internalharvester.UniFiForward
+	if viper.GetString("flags.unifi-host") != "" {
+		controller, err := newUniFiController(client)
+		if err == nil {
+			forwards, err = controller.PortForwards(ctx)
+		}
+		if err != nil {
+			inventory.Errors = append(inventory.Errors, fmt.Sprintf("unifi port forwards not listed: %v", err))
+		}
+	}
+
+	var records []internalharvester.DNSRecord
+	providers, err := recordedDNSProviders(ctx, client)
+	if err != nil {
+		inventory.Errors = append(inventory.Errors, fmt.Sprintf("dns records not listed: %v", err))
+	}
+	hosts := recordedPlatformHosts(viper.GetString("flags.stop-after"))
+	for _, provider := range providers {
+		found, err := provider.dns.ZoneRecords(ctx, hosts)
+		if err != nil {
+			inventory.Errors = append(inventory.Errors, fmt.Sprintf("%s dns records not listed: %v", provider.name, err))
+			continue
+		}
+		records = append(records, found...)
+	}
+
+	inventory.Exposures = internalharvester.CorrelateExposures(kubernetes, forwards, records, internalharvester.ManagedRecordComment(clusterName))
+
+	return inventory, nil
+}
+
+// recordedPlatformHosts are the platform hosts of the cluster recorded by
+// create when provisioning halts after stopAfter, their zones hold the
+// records of the cluster
+func recordedPlatformHosts(stopAfter string) []string {
+	vault := internalharvester.VaultEnabled(stopAfter)
+	var vclusters []string
+	if internalharvester.PhaseEnabled(stopAfter, internalharvester.PhaseVCluster) {
+		vclusters = viper.GetStringSlice("flags.vclusters")
+	}
+
+	return internalharvester.PlatformHosts(viper.GetString("flags.domain-name"), vault, vclusters, viper.GetStringMapString("flags.vcluster-domain-map"), viper.GetStringSlice("flags.extra-domains"), viper.GetBool("flags.vcluster-ingress-wildcard"))
+}
+
+// checkExposureBaseline fails when exposures holds entries the baseline
+// at path does not approve, so scheduled runs notice new openings
+func checkExposureBaseline(errOut io.Writer, path string, exposures []internalharvester.Exposure) error {
+	baseline, err := internalharvester.ReadExposureBaseline(path)
+	if err != nil {
+		return err
+	}
+
+	added, removed := baseline.Diff(exposures)
+	for _, exposure := range removed {
+		fmt.Fprintf(errOut, "no longer exposed: %s %s %s:%s\n", exposure.Kind, exposure.Name, exposure.Address, exposure.Port)
+	}
+	for _, exposure := range added {
+		fmt.Fprintf(errOut, "not in baseline: %s %s %s:%s\n", exposure.Kind, exposure.Name, exposure.Address, exposure.Port)
+	}
+	if len(added) > 0 {
+		return fmt.Errorf("%d exposures are not in baseline %s, approve them with --update-baseline", len(added), path)
+	}
+
+	return nil
+}
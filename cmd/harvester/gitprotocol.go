@@ -0,0 +1,131 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/viper"
+)
+
+const (
+	// githubAppInstallationKey is where the kubefirst config keeps the
+	// installation of --github-app-id on the GitHub organization
+	githubAppInstallationKey = "harvester.github-app-installation-id"
+	// argoCDHTTPSCredentialKey records that create stored the https
+	// credential ArgoCD clones the gitops repository with, for destroy to
+	// remove it
+	argoCDHTTPSCredentialKey = "harvester.argocd-https-credential"
+)
+
+// gitTokenEnv is the environment variable holding the token of gitProvider
+func gitTokenEnv(gitProvider string) string {
+	switch gitProvider {
+	case "gitlab":
+		return "GITLAB_TOKEN"
+	default:
+		return "GITHUB_TOKEN"
+	}
+}
+
+// validateGitProtocol ensures https mode has a credential to clone and push
+// with: the token of the git provider, or the GitHub App of --github-app-id
+func validateGitProtocol(cliFlags *types.CliFlags) error {
+	if cliFlags.GitHubAppID != 0 {
+		if _, err := internalharvester.LoadGitHubApp(cliFlags.GitHubAppID, cliFlags.GitHubAppKeyPath); err != nil {
+			return fmt.Errorf("invalid --github-app-key-path: %w", err)
+		}
+		return nil
+	}
+
+	if cliFlags.GitProtocol == "https" {
+		if tokenEnv := gitTokenEnv(cliFlags.GitProvider); os.Getenv(tokenEnv) == "" {
+			return fmt.Errorf("--git-protocol https clones and pushes the gitops repository with your %s, which is not set. Please set it or pass --github-app-id and --github-app-key-path", tokenEnv)
+		}
+	}
+
+	return nil
+}
+
+// configureGitHubApp exchanges the GitHub App of --github-app-id for a token
+// of its installation on the GitHub organization, recording the
+// installation for the ArgoCD credential. kubefirst-api and the git
+// provider setup read the token from GITHUB_TOKEN, it is valid for an hour,
+// longer than provisioning takes
+func configureGitHubApp(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) error {
+	if cliFlags.GitHubAppID == 0 {
+		return nil
+	}
+
+	app, err := internalharvester.LoadGitHubApp(cliFlags.GitHubAppID, cliFlags.GitHubAppKeyPath)
+	if err != nil {
+		return err
+	}
+
+	installationID, token, err := app.InstallationToken(ctx, client.HTTPClient, cliFlags.GithubOrg)
+	if err != nil {
+		return err
+	}
+	if err := os.Setenv("GITHUB_TOKEN", token); err != nil {
+		return fmt.Errorf("failed to set GITHUB_TOKEN: %w", err)
+	}
+
+	viper.Set(githubAppInstallationKey, installationID)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record github app installation in config: %w", err)
+	}
+
+	return nil
+}
+
+// applyArgoCDHTTPSCredential stores the credential ArgoCD clones the https
+// remote of the gitops repository with: the GitHub App, which ArgoCD mints
+// its own tokens with, or the token of the git provider. A deploy key left
+// by an ssh run is removed
+func applyArgoCDHTTPSCredential(ctx context.Context, client *internalharvester.Client, gitopsRepo *internalharvester.GitopsRepo, cliFlags *types.CliFlags) error {
+	if cliFlags.GitHubAppID != 0 {
+		app, err := internalharvester.LoadGitHubApp(cliFlags.GitHubAppID, cliFlags.GitHubAppKeyPath)
+		if err != nil {
+			return err
+		}
+		if err := client.ApplyArgoCDRepoGitHubApp(ctx, gitopsRepo.URL, app, viper.GetInt64(githubAppInstallationKey)); err != nil {
+			return fmt.Errorf("failed to store github app credential for ArgoCD: %w", err)
+		}
+	} else if err := client.ApplyArgoCDRepoToken(ctx, gitopsRepo.URL, gitopsRepo.Auth.Username, gitopsRepo.Auth.Password); err != nil {
+		return fmt.Errorf("failed to store %s credential for ArgoCD: %w", cliFlags.GitProvider, err)
+	}
+
+	viper.Set(argoCDHTTPSCredentialKey, true)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record ArgoCD credential in config: %w", err)
+	}
+
+	return removeDeployKey(ctx, gitopsRepo)
+}
+
+// removeArgoCDHTTPSCredential deletes the https credential create stored
+// for ArgoCD, if it stored one
+func removeArgoCDHTTPSCredential(ctx context.Context, client *internalharvester.Client) error {
+	if !viper.GetBool(argoCDHTTPSCredentialKey) {
+		return nil
+	}
+
+	if err := client.DeleteArgoCDRepoSecret(ctx); err != nil {
+		return err
+	}
+
+	viper.Set(argoCDHTTPSCredentialKey, false)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to remove ArgoCD credential from config: %w", err)
+	}
+
+	return nil
+}
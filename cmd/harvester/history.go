@@ -0,0 +1,109 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/viper"
+)
+
+// gitopsHistoryKey is where the local kubefirst config keeps its copy of
+// the gitops history, so it survives the cluster becoming unreachable
+const gitopsHistoryKey = "harvester.gitops-history"
+
+// recordGitopsSHA appends the commit operation produced to the history in
+// the cluster state secret and the local kubefirst config. A non-empty
+// irreversible marks the operation as impossible to roll back past
+func recordGitopsSHA(ctx context.Context, client *internalharvester.Client, operation, sha, irreversible string) error {
+	return recordGitops(ctx, client, internalharvester.GitopsRecord{
+		Operation:    operation,
+		SHA:          sha,
+		Irreversible: irreversible,
+	})
+}
+
+// recordGitopsCommit records a commit pushed by a commit batch, keeping the
+// logical changes it holds in the history
+func recordGitopsCommit(ctx context.Context, client *internalharvester.Client, commit *internalharvester.GitopsCommit) error {
+	record := internalharvester.GitopsRecord{
+		Operation: commit.Message,
+		SHA:       commit.SHA,
+	}
+	if len(commit.Changes) > 1 {
+		record.Operation = strings.Join(commit.Changes, ", ")
+		record.Changes = commit.Changes
+	}
+
+	return recordGitops(ctx, client, record)
+}
+
+func recordGitops(ctx context.Context, client *internalharvester.Client, record internalharvester.GitopsRecord) error {
+	history, err := client.LoadGitopsHistory(ctx)
+	if err != nil {
+		return err
+	}
+
+	record.Time = time.Now().UTC()
+	history = history.Append(record)
+
+	if err := client.SaveGitopsHistory(ctx, history); err != nil {
+		return err
+	}
+
+	encoded, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to encode gitops history: %w", err)
+	}
+
+	viper.Set(gitopsHistoryKey, string(encoded))
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to write gitops history to config: %w", err)
+	}
+
+	return nil
+}
+
+// localGitopsHistory reads the copy of the gitops history kept in the local
+// kubefirst config
+func localGitopsHistory() (internalharvester.GitopsHistory, error) {
+	encoded := viper.GetString(gitopsHistoryKey)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	var history internalharvester.GitopsHistory
+	if err := json.Unmarshal([]byte(encoded), &history); err != nil {
+		return nil, fmt.Errorf("failed to parse %s in the kubefirst config: %w", gitopsHistoryKey, err)
+	}
+
+	return history, nil
+}
+
+// recordedGitopsRepo resolves the gitops repository recorded in the
+// kubefirst config by create
+func recordedGitopsRepo(client *internalharvester.Client) (*internalharvester.GitopsRepo, error) {
+	gitProvider := viper.GetString("flags.git-provider")
+
+	gitOwner := viper.GetString("flags.github-owner")
+	if gitProvider == "gitlab" {
+		gitOwner = viper.GetString("flags.gitlab-owner")
+	}
+
+	gitopsRepo := viper.GetString("flags.gitops-repo")
+	if gitProvider == "" || gitOwner == "" || gitopsRepo == "" {
+		return nil, errors.New("no gitops repository recorded in the kubefirst config, run harvester create first")
+	}
+
+	return client.NewGitopsRepo(gitProvider, gitOwner, gitopsRepo)
+}
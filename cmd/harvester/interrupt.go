@@ -0,0 +1,159 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/konstructio/kubefirst/internal/cluster"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/provision"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/viper"
+)
+
+// interruptStateKey holds the internalharvester.InterruptState of the
+// create an interrupt stopped
+const interruptStateKey = "harvester.interrupted"
+
+// watchInterrupts follows Ctrl-C for interrupt until the returned stop is
+// called. The first one is reported through stepper and stops the run at
+// the next step, a second one records the run as interrupted and exits
+func watchInterrupts(interrupt *internalharvester.Interrupt, stepper step.Stepper) (stop func()) {
+	interrupt.Notify = func(message string) {
+		stepper.InfoStep(step.EmojiWarning, message)
+	}
+	interrupt.Exit = func(code int) {
+		stepName, _ := interrupt.Interrupted()
+		_ = recordInterrupt(internalharvester.InterruptState{Step: stepName, Resources: createdResources(), Time: time.Now()})
+		os.Exit(code)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go interrupt.Watch(signals)
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+// finishInterrupt turns the error of a create an interrupt stopped into an
+// InterruptedError, after recording where it stopped and printing what it
+// had already created
+func finishInterrupt(ctx context.Context, interrupt *internalharvester.Interrupt, plan internalharvester.Plan, err error, stepper step.Stepper) error {
+	stepName, interrupted := interrupt.Interrupted()
+	if err == nil || !interrupted {
+		return err
+	}
+
+	state := internalharvester.InterruptState{Step: stepName, Resources: createdResources(), Time: time.Now()}
+	for _, planned := range plan {
+		if planned.Name == stepName {
+			state.Phase = planned.Phase
+		}
+	}
+
+	// the run context is canceled, kubefirst-api still answers
+	watcher := provision.NewProvisionWatcher(viper.GetString("flags.cluster-name"), &cluster.Client{})
+	if pending, found, pendingErr := watcher.PendingStep(context.WithoutCancel(ctx)); pendingErr == nil && found {
+		state.Resources = append([]string{fmt.Sprintf("the kubefirst-api cluster %s, provisioned up to %q", watcher.GetClusterName(), pending)}, state.Resources...)
+	}
+
+	if recordErr := recordInterrupt(state); recordErr != nil {
+		return errors.Join(&internalharvester.InterruptedError{Step: stepName, Err: err}, recordErr)
+	}
+
+	if len(state.Resources) == 0 {
+		stepper.InfoStep(step.EmojiNoEntry, fmt.Sprintf("Interrupted before %q, nothing outside this machine was created yet", stepName))
+	} else {
+		stepper.InfoStep(step.EmojiNoEntry, fmt.Sprintf("Interrupted before %q, already created:\n  - %s", stepName, strings.Join(state.Resources, "\n  - ")))
+	}
+	if phase := plan.CompletedPhase(stepName); phase != "" {
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Stopped at a safe point: provisioned through phase %s, as --stop-after %s leaves it", phase, phase))
+	}
+	stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Continue with: kubefirst harvester create --resume-from %s, or remove what was created with: kubefirst harvester destroy", internalharvester.ResumeInterrupted))
+
+	return &internalharvester.InterruptedError{Step: stepName, Err: err}
+}
+
+// clearInterrupt forgets the interrupted create a create or destroy
+// completed after
+func clearInterrupt() error {
+	if viper.GetString(interruptStateKey) == "" {
+		return nil
+	}
+	viper.Set(interruptStateKey, "")
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to clear the interrupt state: %w", err)
+	}
+
+	return nil
+}
+
+func recordInterrupt(state internalharvester.InterruptState) error {
+	viper.Set(interruptStateKey, state.String())
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record the interrupt: %w", err)
+	}
+
+	return nil
+}
+
+// createdResources lists the external resources the kubefirst config
+// records create made, for an interrupted create to report
+func createdResources() []string {
+	var resources []string
+	gitopsRepo := viper.GetString("flags.gitops-repo")
+	if viper.GetString(gitopsHistoryKey) != "" {
+		resources = append(resources, fmt.Sprintf("commits pushed to the gitops repository %s", gitopsRepo))
+	}
+	if id := viper.GetInt64(deployKeyIDKey); id != 0 {
+		resources = append(resources, fmt.Sprintf("the ArgoCD deploy key %d of the gitops repository %s", id, gitopsRepo))
+	}
+	if viper.GetBool(argoCDHTTPSCredentialKey) {
+		resources = append(resources, fmt.Sprintf("the ArgoCD https credential of the gitops repository %s", gitopsRepo))
+	}
+	if zones := viper.GetStringSlice(dnsZonesKey); len(zones) > 0 {
+		resources = append(resources, fmt.Sprintf("dns records in %s", strings.Join(zones, ", ")))
+	}
+	if viper.GetString(cloudflareTunnelKey) != "" {
+		resources = append(resources, fmt.Sprintf("the Cloudflare Tunnel %s and its dns records", internalharvester.TunnelName(viper.GetString("flags.cluster-name"))))
+	}
+	if forwards := viper.GetStringSlice(uniFiPortForwardsKey); len(forwards) > 0 {
+		resources = append(resources, fmt.Sprintf("the UniFi port forwards %s", strings.Join(forwards, ", ")))
+	}
+
+	return resources
+}
+
+// resumeInterrupted resolves --resume-from interrupted to the install step
+// kubefirst-api stopped before, none when the interrupted create did not
+// get to request the cluster
+func resumeInterrupted(ctx context.Context, watcher *provision.Watcher) (string, error) {
+	_, found, err := internalharvester.ParseInterruptState(viper.GetString(interruptStateKey))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the interrupt state in the kubefirst config: %w", err)
+	}
+	if !found {
+		return "", fmt.Errorf("--resume-from %s: cluster %q has no interrupted create recorded", internalharvester.ResumeInterrupted, watcher.GetClusterName())
+	}
+
+	pending, found, err := watcher.PendingStep(ctx)
+	if err != nil || !found {
+		return "", err
+	}
+
+	return provision.StepSlug(pending), nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// kubeContextKey is where create records the kubeconfig context the other
+// harvester commands default to
+const kubeContextKey = "flags.kubeconfig-context"
+
+// kubeContextFlag returns --kubeconfig-context when cmd has it set, and the
+// context recorded by create otherwise
+func kubeContextFlag(cmd *cobra.Command) string {
+	if flag := cmd.Flags().Lookup("kubeconfig-context"); flag != nil && flag.Value.String() != "" {
+		return flag.Value.String()
+	}
+
+	return viper.GetString(kubeContextKey)
+}
+
+// configureKubeconfig keeps the Harvester kubeconfig of
+// --kubeconfig-from-secret or $KUBEFIRST_KUBECONFIG in memory for the
+// clients to use instead of --kubeconfig-path. pathSet reports whether
+// --kubeconfig-path was passed, as only one source may be given. The
+// kubeconfig has to be valid
+func configureKubeconfig(ctx context.Context, cliFlags *types.CliFlags, pathSet bool) error {
+	raw := os.Getenv(internalharvester.KubeconfigEnv)
+
+	var sources []string
+	if pathSet {
+		sources = append(sources, "--kubeconfig-path")
+	}
+	if cliFlags.KubeconfigFromSecret != "" {
+		sources = append(sources, "--kubeconfig-from-secret")
+	}
+	if raw != "" {
+		sources = append(sources, "$"+internalharvester.KubeconfigEnv)
+	}
+	if len(sources) > 1 {
+		return fmt.Errorf("pass only one of --kubeconfig-path, --kubeconfig-from-secret and $%s, got %s", internalharvester.KubeconfigEnv, strings.Join(sources, " and "))
+	}
+
+	switch {
+	case cliFlags.KubeconfigFromSecret != "":
+		content, err := internalharvester.ReadKubeconfigSecret(ctx, cliFlags.KubeconfigFromSecret, cliFlags.Proxy)
+		if err != nil {
+			return err
+		}
+		return internalharvester.SetKubeconfig(content, "secret "+cliFlags.KubeconfigFromSecret)
+	case raw != "":
+		return internalharvester.SetKubeconfig([]byte(raw), "$"+internalharvester.KubeconfigEnv)
+	}
+
+	return nil
+}
+
+// selectKubeContext resolves the kubeconfig context create uses. It returns
+// an empty context, and no error, when the kubeconfig has several contexts
+// and the user has to be prompted for one
+func selectKubeContext(cliFlags *types.CliFlags) (string, error) {
+	kubeContext, err := internalharvester.SelectContext(cliFlags.HarvesterKubeconfigPath, cliFlags.KubeconfigContext)
+	if errors.Is(err, internalharvester.ErrAmbiguousContext) && !cliFlags.Ci {
+		return "", nil
+	}
+
+	return kubeContext, err
+}
+
+// promptKubeContext asks for one of the contexts of the kubeconfig at
+// kubeconfigPath, by number or by name
+func promptKubeContext(in io.Reader, out io.Writer, kubeconfigPath string) (string, error) {
+	contexts, err := internalharvester.KubeconfigContexts(kubeconfigPath)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(out, "%s has multiple contexts:\n", internalharvester.KubeconfigLocation(kubeconfigPath))
+	for i, name := range contexts {
+		fmt.Fprintf(out, "  %d) %s\n", i+1, name)
+	}
+
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprintf(out, "context to install into [1-%d]: ", len(contexts))
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return "", fmt.Errorf("failed to read context: %w", err)
+			}
+			return "", errors.New("no context selected, set one with --kubeconfig-context")
+		}
+
+		answer := strings.TrimSpace(scanner.Text())
+		if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= len(contexts) {
+			return contexts[i-1], nil
+		}
+		for _, name := range contexts {
+			if name == answer {
+				return name, nil
+			}
+		}
+		fmt.Fprintf(out, "%q is not one of the contexts\n", answer)
+	}
+}
+
+// pingCluster fails the current step when the Harvester cluster of client
+// cannot be used, naming why and what to check
+func pingCluster(ctx context.Context, client *internalharvester.Client, stepper step.Stepper) error {
+	err := client.Ping(ctx)
+	if err == nil {
+		return nil
+	}
+
+	wrerr := fmt.Errorf("failed to connect to the Harvester cluster: %w", err)
+	var pingErr *internalharvester.PingError
+	if errors.As(err, &pingErr) {
+		wrerr = fmt.Errorf("failed to connect to the Harvester cluster, %s: %w", pingErr.Hint(), err)
+	}
+	stepper.FailCurrentStep(wrerr)
+	return wrerr
+}
@@ -0,0 +1,121 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/cobra"
+)
+
+// largeFilePolicy bounds the files create commits to the gitops repository,
+// noting the large ones under the current step of stepper
+func largeFilePolicy(cliFlags *types.CliFlags, stepper step.Stepper) (internalharvester.LargeFilePolicy, error) {
+	policy, err := internalharvester.NewLargeFilePolicy(cliFlags.LargeFileWarnSize, cliFlags.LargeFileMaxSize, cliFlags.AllowLargeFiles, cliFlags.GitLFSPatterns)
+	if err != nil {
+		return policy, err
+	}
+	policy.OnWarning = func(message string) {
+		stepper.InfoStep(step.EmojiWarning, message)
+	}
+
+	return policy, nil
+}
+
+// checkTemplateFiles checks the gitops template files kubefirst-api pushes
+// to the gitops repository. It pushes them as they are, so none is stored
+// with Git LFS
+func checkTemplateFiles(policy internalharvester.LargeFilePolicy, files map[string][]byte) ([]internalharvester.LargeFile, error) {
+	policy.LFSPatterns = nil
+
+	large, err := policy.Check(files)
+	if err != nil {
+		return large, fmt.Errorf("gitops template: %w", err)
+	}
+
+	return large, nil
+}
+
+// planLargeFiles renders the large files of the gitops template the flags
+// of cmd would push, or an empty string when there are none. The template
+// is pulled or read like create does
+func planLargeFiles(ctx context.Context, cmd *cobra.Command) (string, error) {
+	flags := cmd.Flags()
+
+	values := map[string]string{}
+	for _, flag := range []string{"large-file-warn-size", "large-file-max-size"} {
+		value, err := flags.GetString(flag)
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s flag: %w", flag, err)
+		}
+		values[flag] = value
+	}
+	allowLargeFiles, err := flags.GetBool("allow-large-files")
+	if err != nil {
+		return "", fmt.Errorf("failed to get allow-large-files flag: %w", err)
+	}
+
+	policy, err := internalharvester.NewLargeFilePolicy(values["large-file-warn-size"], values["large-file-max-size"], allowLargeFiles, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid --large-file-warn-size or --large-file-max-size: %w", err)
+	}
+
+	files, err := planTemplateFiles(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+
+	large, err := checkTemplateFiles(policy, files)
+	var largeErr *internalharvester.LargeFilesError
+	if err != nil && !errors.As(err, &largeErr) {
+		return "", err
+	}
+	if len(large) == 0 {
+		return "", nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintln(&b, "\nLarge files entering the gitops repository:")
+	for _, file := range large {
+		fmt.Fprintf(&b, "  %s\n", file)
+	}
+	if largeErr != nil {
+		fmt.Fprintf(&b, "create refuses to push them: %v\n", err)
+	}
+
+	return b.String(), nil
+}
+
+// planTemplateFiles returns the files of the gitops template the flags of
+// cmd point to, cloned or read from the bundle like create does
+func planTemplateFiles(ctx context.Context, cmd *cobra.Command) (map[string][]byte, error) {
+	values := map[string]string{}
+	for _, flag := range []string{"gitops-template-url", "gitops-template-branch", "from-bundle", "proxy"} {
+		value, err := cmd.Flags().GetString(flag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s flag: %w", flag, err)
+		}
+		values[flag] = value
+	}
+
+	if values["from-bundle"] != "" {
+		bundle, err := internalharvester.OpenBundle(values["from-bundle"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --from-bundle: %w", err)
+		}
+		return bundle.Gitops, nil
+	}
+
+	files, _, err := internalharvester.SnapshotGitopsTemplate(ctx, values["gitops-template-url"], values["gitops-template-branch"], values["proxy"])
+	return files, err
+}
@@ -0,0 +1,59 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/konstructio/kubefirst/internal/cluster"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func runList(cmd *cobra.Command, _ []string) error {
+	selectorFlag, err := cmd.Flags().GetString("selector")
+	if err != nil {
+		return fmt.Errorf("failed to get selector flag: %w", err)
+	}
+
+	selector, err := labels.Parse(selectorFlag)
+	if err != nil {
+		return fmt.Errorf("invalid --selector: %w", err)
+	}
+
+	client := &cluster.Client{}
+	clusters, err := client.ListClusters(cmd.Context(), selector)
+	if err != nil {
+		return kubefirstAPIError("failed to list the harvester clusters", err)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tTYPE\tPARENT\tLABELS")
+	for _, labeled := range clusters {
+		if labeled.CloudProvider != "harvester" {
+			continue
+		}
+
+		clusterLabels := cluster.FormatClusterLabels(labeled.Labels)
+		if clusterLabels == "" {
+			clusterLabels = "<none>"
+		}
+		parent := labeled.Parent
+		if parent == "" {
+			parent = "<none>"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", labeled.ClusterName, labeled.Status, labeled.ClusterType, parent, clusterLabels)
+	}
+	w.Flush()
+
+	fmt.Fprint(cmd.OutOrStdout(), b.String())
+
+	return nil
+}
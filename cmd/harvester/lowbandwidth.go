@@ -0,0 +1,155 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/cobra"
+)
+
+// applyLowBandwidth turns --low-bandwidth on for a bundle built with it,
+// unless the flag is set, and turns off the optional components that are
+// not set explicitly
+func applyLowBandwidth(cmd *cobra.Command) error {
+	flags := cmd.Flags()
+
+	if fromBundle, _ := flags.GetString("from-bundle"); fromBundle != "" && !flags.Changed("low-bandwidth") {
+		bundle, err := internalharvester.OpenBundle(fromBundle)
+		if err != nil {
+			return fmt.Errorf("invalid --from-bundle: %w", err)
+		}
+		if bundle.Lock.LowBandwidth {
+			if err := flags.Set("low-bandwidth", "true"); err != nil {
+				return fmt.Errorf("failed to set --low-bandwidth: %w", err)
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "--low-bandwidth is on, the bundle %s was built with it\n", fromBundle)
+		}
+	}
+
+	if lowBandwidth, _ := flags.GetBool("low-bandwidth"); !lowBandwidth {
+		return nil
+	}
+
+	var disabled []string
+	for _, flag := range internalharvester.LowBandwidthOptionalFlags {
+		if flags.Changed(flag) {
+			continue
+		}
+		if err := flags.Set(flag, "false"); err != nil {
+			return fmt.Errorf("failed to set --%s: %w", flag, err)
+		}
+		disabled = append(disabled, "--"+flag)
+	}
+	if len(disabled) > 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "--low-bandwidth turns off %s, pass them explicitly to install the components anyway\n", strings.Join(disabled, ", "))
+	}
+
+	return nil
+}
+
+// confirmLowBandwidthDownload prints what pulling the images of the gitops
+// template downloads and asks before provisioning starts, except in --ci
+// mode and for a dry run, which pulls nothing. It reports whether create
+// should go on
+func confirmLowBandwidthDownload(ctx context.Context, cmd *cobra.Command) (bool, error) {
+	flags := cmd.Flags()
+	proxy, _ := flags.GetString("proxy")
+	mirror, _ := flags.GetString("registry-mirror")
+
+	files, err := planTemplateFiles(ctx, cmd)
+	if err != nil {
+		return false, err
+	}
+	httpClient, err := internalharvester.NewHTTPClient(proxy)
+	if err != nil {
+		return false, fmt.Errorf("invalid --proxy: %w", err)
+	}
+	estimate := internalharvester.EstimateDownload(ctx, internalharvester.NewRegistryImageLayers(httpClient, mirror), internalharvester.BundleImages(files), true)
+
+	out := cmd.ErrOrStderr()
+	fmt.Fprintf(out, "Estimated download of --low-bandwidth:\n\n%s\n", estimate.Render())
+
+	ci, _ := flags.GetBool("ci")
+	dryRun, _ := flags.GetBool("dry-run")
+	if ci || dryRun {
+		return true, nil
+	}
+
+	proceed, err := promptDownload(cmd.InOrStdin(), out)
+	if err != nil || !proceed {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// promptDownload asks to proceed with the download, end of input declines
+func promptDownload(in io.Reader, out io.Writer) (bool, error) {
+	fmt.Fprint(out, "proceed with the download? [y/N]: ")
+
+	scanner := bufio.NewScanner(in)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return false, fmt.Errorf("failed to read confirmation: %w", err)
+		}
+		fmt.Fprintln(out)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+	case "y", "yes":
+		return true, nil
+	}
+	fmt.Fprintln(out, "create cancelled before provisioning, nothing was downloaded")
+
+	return false, nil
+}
+
+// applyLowBandwidthProfile stages the slim variants of the images of the
+// gitops repository and a single replica for the platform charts. The slim
+// variants a --from-bundle lockfile records are used as they are, the
+// images mirrored for the site being the ones it lists, others are looked
+// up on the registries. It returns the number of slim variants pulled
+func applyLowBandwidthProfile(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) (int, error) {
+	files, err := commits.stagedYAMLFiles(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var slim map[string]string
+	if cliFlags.FromBundle != "" {
+		bundle, err := internalharvester.OpenBundle(cliFlags.FromBundle)
+		if err != nil {
+			return 0, fmt.Errorf("invalid --from-bundle: %w", err)
+		}
+		slim = bundle.Lock.SlimImages
+	}
+	if slim == nil {
+		layers := internalharvester.NewRegistryImageLayers(client.HTTPClient, cliFlags.RegistryMirror)
+		slim = internalharvester.EstimateDownload(ctx, layers, internalharvester.BundleImages(files), true).SlimImages()
+	}
+
+	changed := internalharvester.SlimImageFiles(files, slim)
+	maps.Copy(files, changed)
+	replicas, err := internalharvester.LowBandwidthFiles(files)
+	if err != nil {
+		return 0, err
+	}
+	maps.Copy(changed, replicas)
+	if len(changed) == 0 {
+		return 0, nil
+	}
+
+	return len(slim), commits.add(ctx, changed, "apply low bandwidth profile")
+}
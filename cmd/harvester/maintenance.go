@@ -0,0 +1,249 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// maintenanceKey holds the internalharvester.MaintenanceStates of the
+// nodes maintenance prepare changed, for maintenance complete to reverse
+const maintenanceKey = "harvester.maintenance"
+
+// maintenanceWorkloads returns the platform workloads of the cluster in the
+// kubefirst config a node drain can disrupt
+func maintenanceWorkloads() []internalharvester.MaintenanceWorkload {
+	stopAfter := viper.GetString("flags.stop-after")
+	ingressPhase := internalharvester.PhaseEnabled(stopAfter, internalharvester.PhaseIngress)
+	components := internalharvester.PlatformComponents(
+		viper.GetBool("flags.install-istio") && ingressPhase,
+		viper.GetBool("flags.install-kgateway") && ingressPhase,
+		internalharvester.VaultEnabled(stopAfter),
+		internalharvester.ObservabilityEnabled(stopAfter, viper.GetBool("flags.install-observability")),
+	)
+
+	var vclusters []string
+	if internalharvester.PhaseEnabled(stopAfter, internalharvester.PhaseVCluster) {
+		vclusters = viper.GetStringSlice("flags.vclusters")
+	}
+
+	return internalharvester.MaintenanceWorkloads(components, vclusters, viper.GetString("flags.vault-auto-unseal"))
+}
+
+// maintenanceClient connects to the Harvester cluster of the maintenance
+// command cmd
+func maintenanceClient(cmd *cobra.Command, stepper step.Stepper) (*internalharvester.Client, string, error) {
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	node, err := cmd.Flags().GetString("node")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get node flag: %w", err)
+	}
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, "", wrerr
+	}
+
+	if err := pingCluster(cmd.Context(), client, stepper); err != nil {
+		return nil, "", err
+	}
+
+	return client, node, nil
+}
+
+func runMaintenancePrepare(cmd *cobra.Command, _ []string) error {
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	migrate, err := cmd.Flags().GetBool("migrate")
+	if err != nil {
+		return fmt.Errorf("failed to get migrate flag: %w", err)
+	}
+
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get timeout flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Check Node Workloads")
+
+	client, node, err := maintenanceClient(cmd, stepper)
+	if err != nil {
+		return err
+	}
+
+	report, err := client.MaintenanceImpact(cmd.Context(), node, maintenanceWorkloads())
+	if err != nil {
+		wrerr := fmt.Errorf("failed to check the platform components on node %s: %w", node, err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStepString(report.Render())
+
+	var movable []internalharvester.MaintenanceComponent
+	disrupted := 0
+	for _, component := range report.Singletons() {
+		if component.Impact == internalharvester.MaintenanceMovable {
+			movable = append(movable, component)
+		} else {
+			disrupted++
+		}
+	}
+
+	if !migrate {
+		switch {
+		case len(movable) > 0:
+			stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("%d component(s) only run on node %s and go down with the drain, rerun with --migrate to move them off it first", len(movable), node))
+		case disrupted == 0:
+			stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Node %s can be drained without disrupting the platform", node))
+		}
+		return nil
+	}
+
+	states, err := internalharvester.ParseMaintenanceStates(viper.GetString(maintenanceKey))
+	if err != nil {
+		return fmt.Errorf("failed to read the maintenance state in the kubefirst config: %w", err)
+	}
+	state, found := states.Find(node)
+	if !found {
+		state = internalharvester.MaintenanceState{Node: node, Prepared: time.Now().UTC()}
+	}
+
+	stepper.NewProgressStep("Cordon Node")
+
+	cordoned, err := client.SetNodeSchedulable(cmd.Context(), node, false)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to cordon node %s: %w", node, err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	// a node already cordoned before prepare stays so after complete
+	state.Cordoned = state.Cordoned || cordoned
+	if err := recordMaintenance(states.Record(state)); err != nil {
+		return err
+	}
+
+	stepper.CompleteCurrentStep()
+
+	for _, component := range movable {
+		stepper.NewProgressStep(fmt.Sprintf("Move %s", component.Workload))
+
+		moveCtx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		err := client.MoveOffNode(moveCtx, component, node)
+		cancel()
+		if err != nil {
+			wrerr := fmt.Errorf("failed to move %s off node %s: %w", component.Workload, node, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		state.Moved = append(state.Moved, component.Workload.String())
+		if err := recordMaintenance(states.Record(state)); err != nil {
+			return err
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if disrupted > 0 {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("%d component(s) cannot move and go down with the drain of node %s", disrupted, node))
+	}
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Node %s is ready to drain, run harvester maintenance complete --node %s once it is back", node, node))
+
+	return nil
+}
+
+func runMaintenanceComplete(cmd *cobra.Command, _ []string) error {
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	stepper.NewProgressStep("Complete Node Maintenance")
+
+	client, node, err := maintenanceClient(cmd, stepper)
+	if err != nil {
+		return err
+	}
+
+	states, err := internalharvester.ParseMaintenanceStates(viper.GetString(maintenanceKey))
+	if err != nil {
+		wrerr := fmt.Errorf("failed to read the maintenance state in the kubefirst config: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	state, found := states.Find(node)
+	if !found {
+		wrerr := fmt.Errorf("node %s was not prepared with harvester maintenance prepare --migrate, nothing to reverse", node)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if state.Cordoned {
+		if _, err := client.SetNodeSchedulable(cmd.Context(), node, true); err != nil {
+			wrerr := fmt.Errorf("failed to uncordon node %s: %w", node, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+	}
+
+	if err := recordMaintenance(states.Remove(node)); err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	stepper.CompleteCurrentStep()
+	if state.Cordoned {
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Node %s uncordoned", node))
+	}
+	if len(state.Moved) > 0 {
+		stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Moved off the node and left where they run: %s", strings.Join(state.Moved, ", ")))
+	}
+
+	return nil
+}
+
+func recordMaintenance(states internalharvester.MaintenanceStates) error {
+	viper.Set(maintenanceKey, states.String())
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record the maintenance state: %w", err)
+	}
+
+	return nil
+}
+
+// warnSingletonRisks warns about the platform components only running on a
+// cordoned or draining node
+func warnSingletonRisks(ctx context.Context, client *internalharvester.Client, stepper step.Stepper) error {
+	risks, err := client.SingletonRisks(ctx, maintenanceWorkloads())
+	if err != nil {
+		return err
+	}
+
+	for _, risk := range risks {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("%s: a drain takes it down, run harvester maintenance prepare --node %s", risk, risk.Node))
+	}
+
+	return nil
+}
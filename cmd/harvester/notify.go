@@ -0,0 +1,166 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/provision"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// credentialEnvVars are the environment variables create reads credentials
+// from
+var credentialEnvVars = []string{"GITHUB_TOKEN", "GITLAB_TOKEN", "CF_API_TOKEN", "AWS_SECRET_ACCESS_KEY", "ARM_CLIENT_SECRET", "AZURE_CLIENT_SECRET"}
+
+// provisionNotifications follows the steps of a create run and posts them
+// to the configured chat webhooks and the --notify-webhook-url hook
+type provisionNotifications struct {
+	events  chan step.StepEvent
+	tracker *internalharvester.PhaseTracker
+	drained chan struct{}
+	pending sync.WaitGroup
+
+	mu       sync.Mutex
+	notifier *internalharvester.Notifier
+	notifyOn map[string]bool
+	hook     *internalharvester.Notifier
+	hookOn   string
+	base     internalharvester.Notification
+	secrets  []string
+	errs     []error
+}
+
+// newProvisionNotifications starts draining step events, the stepper blocks
+// on its event channel otherwise
+func newProvisionNotifications() *provisionNotifications {
+	n := &provisionNotifications{
+		events:  make(chan step.StepEvent, 16),
+		tracker: internalharvester.NewPhaseTracker(),
+		drained: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(n.drained)
+		for event := range n.events {
+			phase, finished := n.tracker.Observe(event)
+			if finished {
+				n.notifyPhase(phase)
+			}
+		}
+	}()
+
+	return n
+}
+
+// configure sets the webhooks once the flags are parsed and announces the
+// run to the hook, steps finished before are still part of the final
+// notification
+func (n *provisionNotifications) configure(notifier, hook *internalharvester.Notifier, cliFlags *types.CliFlags) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.notifier = notifier
+	n.notifyOn = map[string]bool{}
+	for _, phase := range cliFlags.NotifyOnPhase {
+		n.notifyOn[provision.StepSlug(phase)] = true
+	}
+	n.hook = hook
+	n.hookOn = cliFlags.NotifyOn
+
+	// invalid addresses are rejected by ValidateProvidedFlags
+	alertsEmails, _ := internalharvester.ParseAlertsEmails(cliFlags.AlertsEmail)
+	var endpoints []string
+	for _, host := range internalharvester.PropagationHosts(cliFlags.DomainName, internalharvester.VaultEnabled(cliFlags.StopAfter)) {
+		endpoints = append(endpoints, "https://"+host)
+	}
+	n.base = internalharvester.Notification{
+		ClusterName:  cliFlags.ClusterName,
+		ArgoCDURL:    fmt.Sprintf("https://argocd.%s", cliFlags.DomainName),
+		AlertsEmails: alertsEmails,
+		Endpoints:    endpoints,
+	}
+
+	// error text leaves the machine, it must not carry any credential
+	n.secrets = []string{cliFlags.OIDCClientSecret, cliFlags.UniFiPassword, cliFlags.SlackWebhook, cliFlags.TeamsWebhook, cliFlags.NotifyWebhook, cliFlags.NotifyWebhookURL}
+	for _, name := range credentialEnvVars {
+		n.secrets = append(n.secrets, os.Getenv(name))
+	}
+
+	start := n.base
+	start.Start = true
+	n.sendLocked(context.Background(), n.hook, start, internalharvester.NotifyOnSelects(n.hookOn, start))
+}
+
+func (n *provisionNotifications) notifyPhase(phase internalharvester.PhaseRecord) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	notification := n.base
+	notification.Phase = phase.Name
+	notification.Phases = []internalharvester.PhaseRecord{phase}
+	notification.Percent = n.tracker.Percent()
+
+	n.sendLocked(context.Background(), n.notifier, notification, n.notifyOn[provision.StepSlug(phase.Name)])
+	// failed phases reach the hook with the outcome of the run
+	n.sendLocked(context.Background(), n.hook, notification, phase.Status == step.StatusComplete && internalharvester.NotifyOnSelects(n.hookOn, notification))
+}
+
+// sendLocked posts notification to notifier in the background when selected
+func (n *provisionNotifications) sendLocked(ctx context.Context, notifier *internalharvester.Notifier, notification internalharvester.Notification, selected bool) {
+	if !selected || !notifier.Enabled() {
+		return
+	}
+
+	n.pending.Add(1)
+	go func() {
+		defer n.pending.Done()
+		if err := notifier.Send(ctx, notification); err != nil {
+			n.mu.Lock()
+			n.errs = append(n.errs, err)
+			n.mu.Unlock()
+		}
+	}()
+}
+
+// finish posts the outcome of the run and waits for every post, which are
+// each bounded by internalharvester.NotificationTimeout. Failed posts are
+// reported to out but never fail the run
+func (n *provisionNotifications) finish(ctx context.Context, runErr error, out io.Writer) {
+	close(n.events)
+	<-n.drained
+
+	n.mu.Lock()
+	notification := n.base
+	if notification.ClusterName != "" {
+		notification.Final = true
+		notification.Succeeded = runErr == nil
+		notification.Phases = n.tracker.Phases()
+		notification.Percent = 100
+		if runErr != nil {
+			notification.Error = internalharvester.RedactSecrets(runErr.Error(), n.secrets)
+			notification.Percent = n.tracker.Percent()
+		}
+
+		ctx := context.WithoutCancel(ctx)
+		n.sendLocked(ctx, n.notifier, notification, true)
+		n.sendLocked(ctx, n.hook, notification, internalharvester.NotifyOnSelects(n.hookOn, notification))
+	}
+	n.mu.Unlock()
+
+	n.pending.Wait()
+
+	for _, err := range n.errs {
+		fmt.Fprintf(out, "warning: failed to send provisioning notification: %v\n", err)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"sort"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+)
+
+func runPin(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	unpin, err := cmd.Flags().GetBool("unpin")
+	if err != nil {
+		return fmt.Errorf("failed to get unpin flag: %w", err)
+	}
+
+	confirm, err := cmd.Flags().GetBool("confirm")
+	if err != nil {
+		return fmt.Errorf("failed to get confirm flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Read Deployed Chart Versions")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	deployed, err := client.DeployedChartVersions(ctx)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to read deployed chart versions: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	charts := make([]string, 0, len(deployed))
+	for chart := range deployed {
+		charts = append(charts, chart)
+	}
+	sort.Strings(charts)
+
+	pins := map[string]string{}
+	for _, chart := range charts {
+		version, ok := internalharvester.PinVersion(deployed[chart], unpin)
+		if !ok {
+			stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Skipping chart %s, its deployed version %q is not an exact version", chart, deployed[chart]))
+			continue
+		}
+		pins[chart] = version
+	}
+
+	stepper.CompleteCurrentStep()
+
+	stepper.NewProgressStep("Prepare GitOps Changes")
+
+	gitopsRepo, err := recordedGitopsRepo(client)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to resolve gitops repository: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	files, err := gitopsRepo.ReadYAMLFiles(ctx, "/")
+	if err != nil {
+		wrerr := fmt.Errorf("failed to read gitops repository: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	changed := internalharvester.PinChartVersions(files, pins)
+
+	stepper.CompleteCurrentStep()
+
+	if len(changed) == 0 {
+		stepper.InfoStep(step.EmojiCheck, "The gitops repository already has these chart versions, nothing to change")
+		return nil
+	}
+
+	fmt.Fprint(cmd.OutOrStdout(), internalharvester.DiffFiles(files, changed))
+
+	if !confirm {
+		stepper.InfoStep(step.EmojiBulb, "Rerun with --confirm to commit and push these changes")
+		return nil
+	}
+
+	stepper.NewProgressStep("Push Pinned Versions")
+
+	message := "pin deployed chart versions"
+	if unpin {
+		message = "unpin chart versions to patch release ranges"
+	}
+
+	sha, err := gitopsRepo.CommitFiles(ctx, changed, message)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to push pinned versions: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := recordGitopsSHA(ctx, client, message, sha, ""); err != nil {
+		wrerr := fmt.Errorf("failed to record pin commit: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
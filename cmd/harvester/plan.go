@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+)
+
+// provisionPlan builds the plan of the create run described by the flags of
+// cmd, without the side effects of parsing them with utilities.GetFlags
+func provisionPlan(cmd *cobra.Command) (internalharvester.Plan, error) {
+	flags := cmd.Flags()
+
+	stopAfter, err := flags.GetString("stop-after")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stop-after flag: %w", err)
+	}
+	ha, err := flags.GetBool("ha")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ha flag: %w", err)
+	}
+	gpuNodes, err := flags.GetStringSlice("gpu-nodes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gpu-nodes flag: %w", err)
+	}
+	installIstio, err := flags.GetBool("install-istio")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get install-istio flag: %w", err)
+	}
+	installKgateway, err := flags.GetBool("install-kgateway")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get install-kgateway flag: %w", err)
+	}
+	extraDomains, err := flags.GetStringSlice("extra-domains")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get extra-domains flag: %w", err)
+	}
+	vclusterDomainMap, err := flags.GetStringToString("vcluster-domain-map")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vcluster-domain-map flag: %w", err)
+	}
+	installObservability, err := flags.GetBool("install-observability")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get install-observability flag: %w", err)
+	}
+	logging, err := flags.GetBool("logging")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logging flag: %w", err)
+	}
+	vclusters, err := flags.GetStringSlice("vclusters")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vclusters flag: %w", err)
+	}
+	vclusterIstio, err := flags.GetStringToString("vcluster-istio")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vcluster-istio flag: %w", err)
+	}
+	vclusterIngressWildcard, err := flags.GetBool("vcluster-ingress-wildcard")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vcluster-ingress-wildcard flag: %w", err)
+	}
+	vclusterNetworkIsolation, err := flags.GetBool("vcluster-network-isolation")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vcluster-network-isolation flag: %w", err)
+	}
+	vclusterAppSet, err := flags.GetBool("vcluster-appset")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vcluster-appset flag: %w", err)
+	}
+	registryMirror, err := flags.GetString("registry-mirror")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get registry-mirror flag: %w", err)
+	}
+	trustBundle, err := flags.GetBool("trust-bundle")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trust-bundle flag: %w", err)
+	}
+	trustBundleProbeURL, err := flags.GetString("trust-bundle-probe-url")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trust-bundle-probe-url flag: %w", err)
+	}
+	skipVerify, err := flags.GetBool("skip-verify")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get skip-verify flag: %w", err)
+	}
+	wait, err := flags.GetBool("wait")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wait flag: %w", err)
+	}
+	externalSecrets, err := flags.GetBool("external-secrets")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get external-secrets flag: %w", err)
+	}
+	backupSchedule, err := flags.GetString("backup-schedule")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backup-schedule flag: %w", err)
+	}
+	vaultAutoUnseal, err := flags.GetString("vault-auto-unseal")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vault-auto-unseal flag: %w", err)
+	}
+	vaultSeedFile, err := flags.GetString("vault-seed-file")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vault-seed-file flag: %w", err)
+	}
+
+	vaultTeamPolicies, err := flags.GetBool("vault-team-policies")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get vault-team-policies flag: %w", err)
+	}
+
+	var oidc internalharvester.OIDCConfig
+	for flag, value := range map[string]*string{
+		"oidc-issuer-url":    &oidc.IssuerURL,
+		"oidc-client-id":     &oidc.ClientID,
+		"oidc-client-secret": &oidc.ClientSecret,
+		"oidc-admin-group":   &oidc.AdminGroup,
+	} {
+		if *value, err = flags.GetString(flag); err != nil {
+			return nil, fmt.Errorf("failed to get %s flag: %w", flag, err)
+		}
+	}
+
+	if err := internalharvester.ValidateStopAfter(stopAfter); err != nil {
+		return nil, fmt.Errorf("invalid --stop-after: %w", err)
+	}
+
+	return internalharvester.BuildPlan(internalharvester.PlanOptions{
+		StopAfter:                stopAfter,
+		HA:                       ha,
+		GPU:                      len(gpuNodes) > 0,
+		InstallIstio:             installIstio,
+		InstallKgateway:          installKgateway,
+		IngressDomains:           len(extraDomains) > 0 || len(vclusterDomainMap) > 0,
+		VClusters:                vclusters,
+		VClusterIstio:            len(vclusterIstio) > 0,
+		VClusterIngressWildcard:  vclusterIngressWildcard,
+		VClusterNetworkIsolation: vclusterNetworkIsolation,
+		VClusterAppSet:           vclusterAppSet && len(vclusters) > 0,
+		RegistryMirror:           registryMirror != "",
+		SSO:                      oidc.Enabled(),
+		Observability:            installObservability,
+		Logging:                  logging,
+		TrustBundle:              trustBundle,
+		TrustBundleProbe:         trustBundle && trustBundleProbeURL != "",
+		SkipVerify:               skipVerify,
+		Wait:                     wait,
+		ExternalSecrets:          externalSecrets,
+		Backup:                   backupSchedule != "",
+		VaultAutoUnseal:          vaultAutoUnseal,
+		VaultSeed:                vaultSeedFile != "",
+		VaultTeamPolicies:        vaultTeamPolicies,
+	}), nil
+}
@@ -0,0 +1,1522 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/viper"
+)
+
+// runPostProvision runs the Harvester specific steps the CLI performs against
+// the cluster once kubefirst-api reports the platform as provisioned
+func runPostProvision(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, stepper step.Stepper) error {
+	stepper.NewProgressStep("Record GitOps Commit")
+
+	gitopsRepo, err := clusterGitopsRepo(client, cliFlags)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to record provisioned gitops commit: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := recordProvisionedSHA(ctx, client, gitopsRepo); err != nil {
+		wrerr := fmt.Errorf("failed to record provisioned gitops commit: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	// the changes staged before a step fails are pushed all the same, what
+	// they go with outside of gitops is already applied. They are pushed
+	// too when an interrupt canceled ctx
+	commits := newGitopsCommits(client, gitopsRepo, cliFlags.CommitPerChange)
+	err = runPostProvisionSteps(ctx, client, cliFlags, commits, stepper)
+	if flushErr := flushGitopsCommits(context.WithoutCancel(ctx), commits, stepper); flushErr != nil && err == nil {
+		return flushErr
+	}
+
+	return err
+}
+
+// runPostProvisionSteps runs the post-provision steps, staging the gitops
+// changes they make in commits
+func runPostProvisionSteps(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits, stepper step.Stepper) error {
+	if !cliFlags.DryRun {
+		if err := restoreTornDownPhases(ctx, client, stepper); err != nil {
+			return err
+		}
+	}
+
+	if cliFlags.GitopsOverlayDir != "" {
+		stepper.NewProgressStep("Apply GitOps Overlay")
+
+		result, err := applyGitopsOverlay(ctx, cliFlags, commits)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to apply gitops overlay: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiCheck, result.Summary())
+	}
+
+	if cliFlags.ComponentsVersionFile != "" {
+		stepper.NewProgressStep("Pin Component Versions")
+
+		pinned, err := pinComponentCharts(ctx, cliFlags, commits)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to pin component versions: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Pinned the chart versions of --components-version-file in %d gitops files", pinned))
+	}
+
+	if cliFlags.InstallKubefirstPro {
+		stepper.NewProgressStep("Pin Kubefirst Pro")
+
+		pinned, err := pinKubefirstPro(ctx, cliFlags, commits)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to pin kubefirst pro: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Pinned kubefirst pro %s from %s in %d gitops files", cliFlags.KubefirstProVersion, cliFlags.KubefirstProChartURL, pinned))
+	}
+
+	if cliFlags.LowBandwidth {
+		stepper.NewProgressStep("Apply Low Bandwidth Profile")
+
+		slim, err := applyLowBandwidthProfile(ctx, client, cliFlags, commits)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to apply low bandwidth profile: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Pulling %d slim image variants, the platform charts run a single replica", slim))
+	}
+
+	stepper.NewProgressStep("Configure Load Balancer Pool")
+
+	lbPools, err := internalharvester.ParseLBPools(cliFlags.HarvesterLBPoolName, cliFlags.HarvesterLBIPRanges, cliFlags.HarvesterLBIPRangeNames)
+	if err != nil {
+		wrerr := fmt.Errorf("invalid load balancer range: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := configureLBPool(ctx, client, cliFlags, commits, lbPools); err != nil {
+		wrerr := fmt.Errorf("failed to configure load balancer pool: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	// a dry run commits nothing for ArgoCD to allocate or resolve
+	if internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseIngress) && !cliFlags.DryRun {
+		// the pools have to be committed for ArgoCD to create them
+		if err := flushGitopsCommits(ctx, commits, stepper); err != nil {
+			return err
+		}
+
+		stepper.NewProgressStep("Verify Load Balancer Allocation")
+
+		lbCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).LBAllocation)
+		defer cancel()
+
+		if err := client.VerifyLBAllocation(lbCtx, lbPools); err != nil {
+			wrerr := fmt.Errorf("load balancer allocation check failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+
+		if domains := ingressDomains(cliFlags); len(domains) > 0 {
+			stepper.NewProgressStep("Configure Ingress Domains")
+
+			domainsCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).LBAllocation)
+			defer cancel()
+
+			if err := client.ApplyIngressDomains(domainsCtx, domains); err != nil {
+				wrerr := fmt.Errorf("failed to configure ingress domains: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+		}
+
+		if cliFlags.IngressMode == internalharvester.IngressModeCloudflareTunnel {
+			stepper.NewProgressStep("Configure Cloudflare Tunnel")
+
+			result, err := configureCloudflareTunnel(ctx, client, cliFlags, commits)
+			if err != nil {
+				wrerr := fmt.Errorf("failed to configure cloudflare tunnel: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+			if result.Changed() || len(result.Unmanaged) > 0 {
+				stepper.InfoStep(step.EmojiCheck, result.Summary())
+			}
+		} else if len(cliFlags.DNSProviders) > 0 {
+			stepper.NewProgressStep("Reconcile DNS Records")
+
+			results, err := reconcileDNS(ctx, client, cliFlags)
+			if err != nil {
+				wrerr := fmt.Errorf("failed to reconcile dns records: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+			for _, provider := range cliFlags.DNSProviders {
+				if result := results[provider]; result.Changed() || len(result.Unmanaged) > 0 {
+					stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("%s: %s", provider, result.Summary()))
+				}
+			}
+		}
+
+		stepper.NewProgressStep("Verify DNS Propagation")
+
+		if err := verifyDNSPropagation(ctx, cliFlags); err != nil {
+			wrerr := fmt.Errorf("dns propagation check failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+
+		if len(cliFlags.UniFiPortMappings) > 0 {
+			stepper.NewProgressStep("Configure UniFi Port Forwards")
+
+			changed, err := configureUniFiPortForwards(ctx, client, cliFlags)
+			if err != nil {
+				wrerr := fmt.Errorf("failed to configure unifi port forwards: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+			if len(changed) > 0 {
+				stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("unifi port forwards configured %s", strings.Join(changed, ", ")))
+			}
+		}
+	}
+
+	if classes := storageClasses(cliFlags); !classes.IsZero() {
+		stepper.NewProgressStep("Configure Storage Classes")
+
+		if err := configureStorageClasses(ctx, commits, classes); err != nil {
+			wrerr := fmt.Errorf("failed to configure storage classes: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	vclusterPhase := internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseVCluster)
+
+	if cliFlags.VClusterAppSet && len(cliFlags.VClusters) > 0 && vclusterPhase {
+		stepper.NewProgressStep("Configure vCluster ApplicationSet")
+
+		if err := configureVClusterAppSet(ctx, client, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to configure vcluster applicationset: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if !cliFlags.VClusterAppSet && len(cliFlags.VClusters) > 0 && vclusterPhase {
+		stepper.NewProgressStep("Configure vCluster Values")
+
+		if err := configureVClusterValues(ctx, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to configure vcluster values: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if len(cliFlags.VClusterApps) > 0 && vclusterPhase {
+		stepper.NewProgressStep("Configure vCluster Catalog Apps")
+
+		if err := configureVClusterApps(ctx, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to configure vcluster catalog apps: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if len(cliFlags.VClusterConnections) > 0 && vclusterPhase {
+		stepper.NewProgressStep("Configure vCluster Connections")
+
+		if err := configureVClusterConnections(ctx, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to configure vcluster connections: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.TrustBundle && vclusterPhase {
+		stepper.NewProgressStep("Distribute Trust Bundle")
+
+		if err := distributeTrustBundle(ctx, client, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to distribute the trust bundle: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if len(cliFlags.VClusterIstio) > 0 && vclusterPhase {
+		stepper.NewProgressStep("Configure vCluster Istio Ambient Mode")
+
+		if err := client.ApplyVClusterIstio(ctx, cliFlags.VClusterIstio); err != nil {
+			wrerr := fmt.Errorf("failed to configure vcluster istio ambient mode: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.VClusterIngressWildcard && vclusterPhase {
+		stepper.NewProgressStep("Configure vCluster Wildcard Ingress")
+
+		if err := client.ApplyVClusterIngressWildcards(ctx, cliFlags.VClusters, cliFlags.DomainName, cliFlags.VClusterDomainMap); err != nil {
+			wrerr := fmt.Errorf("failed to configure vcluster wildcard ingress: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.VClusterNetworkIsolation && vclusterPhase {
+		stepper.NewProgressStep("Apply vCluster Network Policies")
+
+		allows, err := internalharvester.ParseVClusterAllows(cliFlags.VClusters, cliFlags.VClusterAllows)
+		if err != nil {
+			wrerr := fmt.Errorf("invalid --allow-vcluster-to-vcluster: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		connections, err := internalharvester.ParseVClusterConnections(cliFlags.VClusters, cliFlags.VClusterConnections)
+		if err != nil {
+			wrerr := fmt.Errorf("invalid --vcluster-connect: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		// connected vclusters reach each other through the isolation
+		for _, allow := range internalharvester.VClusterConnectionAllows(connections) {
+			if !slices.Contains(allows, allow) {
+				allows = append(allows, allow)
+			}
+		}
+
+		if err := client.ApplyVClusterNetworkPolicies(ctx, cliFlags.VClusters, allows); err != nil {
+			wrerr := fmt.Errorf("failed to apply vcluster network policies: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	vaultPhase := internalharvester.VaultEnabled(cliFlags.StopAfter)
+
+	if cliFlags.VaultAutoUnseal == internalharvester.VaultUnsealStatic && vaultPhase {
+		stepper.NewProgressStep("Configure Vault Auto-Unseal")
+
+		if err := client.ApplyVaultStaticUnseal(ctx); err != nil {
+			wrerr := fmt.Errorf("failed to configure vault auto-unseal: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Vault unseals itself with the keys in %s: anyone able to read that secret can unseal Vault, keep it to homelabs", internalharvester.VaultUnsealKeysLocation()))
+	}
+
+	audit := vaultAudit(cliFlags)
+	if audit.Mode == internalharvester.VaultAuditFile && vaultPhase {
+		stepper.NewProgressStep("Configure Vault Audit Volume")
+
+		if err := configureVaultAuditVolume(ctx, commits, audit, cliFlags.RegistryMirror); err != nil {
+			wrerr := fmt.Errorf("failed to configure vault audit volume: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+
+		if !cliFlags.DryRun {
+			// the volume has to be committed for ArgoCD to add it
+			if err := flushGitopsCommits(ctx, commits, stepper); err != nil {
+				return err
+			}
+
+			stepper.NewProgressStep("Roll Out Vault Audit Volume")
+
+			rolloutCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).VaultAuditRollout)
+			defer cancel()
+
+			if err := client.RolloutVaultAuditStorage(rolloutCtx); err != nil {
+				wrerr := fmt.Errorf("failed to roll out vault audit volume: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+		}
+	}
+
+	if audit.Enabled() && vaultPhase && !cliFlags.DryRun {
+		stepper.NewProgressStep("Enable Vault Audit Device")
+
+		if err := enableVaultAudit(ctx, client, cliFlags, audit); err != nil {
+			wrerr := fmt.Errorf("failed to enable vault audit device: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.VaultSeedFile != "" && vaultPhase && !cliFlags.DryRun {
+		stepper.NewProgressStep("Seed Vault Secrets")
+
+		if err := seedVault(ctx, client, cliFlags); err != nil {
+			wrerr := fmt.Errorf("failed to seed vault: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.VaultTeamPolicies && vaultPhase {
+		stepper.NewProgressStep("Configure Vault Team Policies")
+
+		if err := configureVaultTeamPolicies(ctx, client, cliFlags, commits, stepper); err != nil {
+			wrerr := fmt.Errorf("failed to configure vault team policies: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.ExternalSecrets && internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseVault) {
+		stepper.NewProgressStep("Configure External Secrets")
+
+		if err := configureExternalSecrets(ctx, client, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to configure external secrets: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.BackupSchedule != "" {
+		stepper.NewProgressStep("Configure Backups")
+
+		if err := configureBackups(ctx, client, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to configure backups: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if oidcConfig(cliFlags).Enabled() && internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseSSO) {
+		stepper.NewProgressStep("Configure SSO")
+
+		if err := configureSSO(ctx, client, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to configure sso: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if internalharvester.ObservabilityEnabled(cliFlags.StopAfter, cliFlags.InstallObservability) {
+		stepper.NewProgressStep("Install Observability")
+
+		if err := installObservability(ctx, client, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to install observability: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if internalharvester.LoggingEnabled(cliFlags.StopAfter, cliFlags.Logging) {
+		stepper.NewProgressStep("Install Logging")
+
+		if err := installLogging(ctx, client, cliFlags, commits); err != nil {
+			wrerr := fmt.Errorf("failed to install logging: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if cliFlags.ClusterType == internalharvester.ClusterTypeWorkload {
+		stepper.NewProgressStep("Register Workload Cluster")
+
+		parent, err := registerWorkloadCluster(ctx, client, cliFlags, commits, stepper)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to register workload cluster: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Management cluster %s deploys %s of the gitops repository to this cluster", parent, internalharvester.WorkloadGitopsPath(cliFlags.ClusterName)))
+
+		stepper.CompleteCurrentStep()
+	}
+
+	if err := flushGitopsCommits(ctx, commits, stepper); err != nil {
+		return err
+	}
+
+	if cliFlags.DryRun {
+		return nil
+	}
+
+	stepper.NewProgressStep("Protect GitOps Repository")
+
+	if err := protectGitopsRepo(ctx, client, cliFlags, stepper); err != nil {
+		wrerr := fmt.Errorf("failed to protect gitops repository: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	if cliFlags.StopAfter != "" && cliFlags.Wait {
+		stepper.NewProgressStep("Wait for Phase Applications")
+
+		if err := waitForStopAfterPhase(ctx, client, cliFlags, stepper); err != nil {
+			wrerr := fmt.Errorf("phase %s did not become Healthy/Synced: %w", cliFlags.StopAfter, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	// --wait=false leaves the submitted applications to sync on their own
+	if !cliFlags.SkipVerify && (cliFlags.StopAfter == "" || cliFlags.Wait) {
+		stepper.NewProgressStep("Verify Platform Health")
+
+		if err := verifyPlatformHealth(ctx, client, cliFlags, stepper); err != nil {
+			wrerr := fmt.Errorf("platform health verification failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+
+		if audit.Enabled() && vaultPhase {
+			stepper.NewProgressStep("Verify Vault Audit Device")
+
+			if err := verifyVaultAudit(ctx, client, cliFlags, audit); err != nil {
+				wrerr := fmt.Errorf("vault audit verification failed: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+		}
+
+		if cliFlags.TrustBundle && cliFlags.TrustBundleProbeURL != "" && vclusterPhase {
+			stepper.NewProgressStep("Verify Trust Bundle")
+
+			if err := verifyTrustBundle(ctx, client, cliFlags); err != nil {
+				wrerr := fmt.Errorf("trust bundle verification failed: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+		}
+
+		if cliFlags.VClusterIngressWildcard && vclusterPhase {
+			stepper.NewProgressStep("Verify vCluster Wildcard Ingress")
+
+			if err := verifyWildcardIngress(ctx, cliFlags); err != nil {
+				wrerr := fmt.Errorf("vcluster wildcard ingress verification failed: %w", err)
+				stepper.FailCurrentStep(wrerr)
+				return wrerr
+			}
+
+			stepper.CompleteCurrentStep()
+		}
+	}
+
+	// catalog apps, the GPU operator among them, only install on full runs
+	if len(cliFlags.GPUNodes) > 0 && cliFlags.StopAfter == "" {
+		stepper.NewProgressStep("Run GPU Smoke Test")
+
+		smokeCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).GPUSmokeTest)
+		defer cancel()
+
+		if err := client.RunGPUSmokeTest(smokeCtx); err != nil {
+			wrerr := fmt.Errorf("gpu smoke test failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	return nil
+}
+
+// waitForStopAfterPhase blocks until the applications of the --stop-after
+// phase are Healthy/Synced, within the --verify-timeout budget, noting
+// their progress under the current step
+func waitForStopAfterPhase(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, stepper step.Stepper) error {
+	waitCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).PlatformHealth)
+	defer cancel()
+
+	target := internalharvester.PhaseTargets(cliFlags.StopAfter, cliFlags.VClusters, cliFlags.ExternalSecrets, cliFlags.VClusterAppSet)
+
+	return client.WaitForPhaseApplications(waitCtx, target, func(ready, total int) {
+		step.ReportProgress(stepper, ready, total)
+		stepper.InfoStep(step.EmojiAlarm, fmt.Sprintf("%d of %d %s application(s) Healthy/Synced", ready, total, cliFlags.StopAfter))
+	})
+}
+
+// provisionBudget returns the deadlines of the waits of a create run
+func provisionBudget(cliFlags *types.CliFlags) internalharvester.Budget {
+	return internalharvester.NewBudget(cliFlags.VerifyTimeout)
+}
+
+// dnsZonesKey is where the kubefirst config keeps the cloudflare zones
+// holding the records create manages, for --prune-dns to find the ones of
+// domains no longer in use
+const dnsZonesKey = "harvester.dns-zones"
+
+// reconcileDNS points the platform records at the ingress serving them at
+// every --dns-provider at once, creating missing ones and with --prune-dns
+// deleting the managed records the current domains no longer need. Every
+// provider must succeed, the error names each one that failed
+func reconcileDNS(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) (map[string]internalharvester.DNSReconcileResult, error) {
+	desired, err := platformDNSRecords(ctx, client, cliFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	targets, err := dnsTargets(ctx, client, cliFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := internalharvester.PublishDNSRecords(ctx, targets, desired, internalharvester.ManagedRecordComment(cliFlags.ClusterName), cliFlags.PruneDNS)
+	if err != nil {
+		return results, err
+	}
+
+	if result, ok := results[internalharvester.DNSProviderCloudflare]; ok {
+		viper.Set(dnsZonesKey, result.Zones)
+		if err := viper.WriteConfig(); err != nil {
+			return results, fmt.Errorf("failed to record dns zones in config: %w", err)
+		}
+	}
+
+	return results, nil
+}
+
+// dnsTargets returns the client of every --dns-provider, in the order given
+func dnsTargets(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) ([]internalharvester.DNSTarget, error) {
+	providers, err := newDNSProviders(ctx, client, cliFlags.DNSProviders, cliFlags.Route53HostedZoneID)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]internalharvester.DNSTarget, 0, len(providers))
+	for _, provider := range providers {
+		target := internalharvester.DNSTarget{Provider: provider.name, Publisher: provider.dns}
+		if provider.name == internalharvester.DNSProviderCloudflare {
+			target.KnownZones = viper.GetStringSlice(dnsZonesKey)
+		}
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// dnsProviderClient is the client of one of the dns providers the platform
+// records are published to
+type dnsProviderClient struct {
+	name string
+	dns  internalharvester.DNSProvider
+}
+
+// recordedDNSProviders returns the client of every dns provider create
+// published the platform records to, in the order they were given. A
+// cluster recorded without one has none
+func recordedDNSProviders(ctx context.Context, client *internalharvester.Client) ([]dnsProviderClient, error) {
+	value := viper.GetString("flags.dns-provider")
+	if value == "" {
+		return nil, nil
+	}
+
+	providers, err := internalharvester.ParseDNSProviders(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dns-provider in the kubefirst config: %w", err)
+	}
+
+	return newDNSProviders(ctx, client, providers, viper.GetString("flags.route53-hosted-zone-id"))
+}
+
+// newDNSProviders returns the client of every provider, Route 53 calling
+// the hosted zone route53HostedZoneID
+func newDNSProviders(ctx context.Context, client *internalharvester.Client, providers []string, route53HostedZoneID string) ([]dnsProviderClient, error) {
+	clients := make([]dnsProviderClient, 0, len(providers))
+	for _, provider := range providers {
+		switch provider {
+		case internalharvester.DNSProviderCloudflare:
+			dns, err := internalharvester.NewCloudflareDNS(os.Getenv("CF_API_TOKEN"), client.HTTPClient)
+			if err != nil {
+				return nil, err
+			}
+			dns.Retry = client.Retry
+			clients = append(clients, dnsProviderClient{name: provider, dns: dns})
+		case internalharvester.DNSProviderRoute53:
+			credentials, err := awsCredentials(ctx)
+			if err != nil {
+				return nil, err
+			}
+			dns, err := internalharvester.NewRoute53DNS(route53HostedZoneID, credentials, client.HTTPClient)
+			if err != nil {
+				return nil, err
+			}
+			dns.Retry = client.Retry
+			clients = append(clients, dnsProviderClient{name: provider, dns: dns})
+		}
+	}
+
+	return clients, nil
+}
+
+// dnsProviderRecords are records at one of the dns providers
+type dnsProviderRecords struct {
+	dnsProviderClient
+	records []internalharvester.DNSRecord
+}
+
+func countRecords(providers []dnsProviderRecords) int {
+	count := 0
+	for _, provider := range providers {
+		count += len(provider.records)
+	}
+
+	return count
+}
+
+// managedDNSRecords returns the records of hosts managed under comment at
+// every provider
+func managedDNSRecords(ctx context.Context, providers []dnsProviderClient, hosts []string, comment string) ([]dnsProviderRecords, error) {
+	records := make([]dnsProviderRecords, 0, len(providers))
+	for _, provider := range providers {
+		managed, err := provider.dns.ManagedRecords(ctx, hosts, comment)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", provider.name, err)
+		}
+		records = append(records, dnsProviderRecords{dnsProviderClient: provider, records: managed})
+	}
+
+	return records, nil
+}
+
+// deleteDNSRecords deletes records at their provider, returning the hosts
+// of those deleted
+func deleteDNSRecords(ctx context.Context, records []dnsProviderRecords) ([]string, error) {
+	var deleted []string
+	for _, provider := range records {
+		for _, record := range provider.records {
+			if err := provider.dns.DeleteRecord(ctx, record); err != nil {
+				return deleted, fmt.Errorf("%s: %w", provider.name, err)
+			}
+			deleted = append(deleted, fmt.Sprintf("%s (%s)", record.Name, provider.name))
+		}
+	}
+
+	return deleted, nil
+}
+
+// awsCredentials returns the AWS credentials of the environment, the ones
+// Route 53 is called with
+func awsCredentials(ctx context.Context) (aws.CredentialsProvider, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS SDK config: %w", err)
+	}
+
+	return cfg.Credentials, nil
+}
+
+// desiredDNSRecords returns the Cloudflare client of the platform records
+// and the address of the ingress serving each of their hosts
+func desiredDNSRecords(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) (*internalharvester.CloudflareDNS, map[string]string, error) {
+	dns, err := internalharvester.NewCloudflareDNS(os.Getenv("CF_API_TOKEN"), client.HTTPClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	dns.Retry = client.Retry
+
+	desired, err := platformDNSRecords(ctx, client, cliFlags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dns, desired, nil
+}
+
+// platformVClusters returns the vclusters provisioning creates, none when it
+// halts before the vcluster phase
+func platformVClusters(cliFlags *types.CliFlags) []string {
+	if internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseVCluster) {
+		return cliFlags.VClusters
+	}
+
+	return nil
+}
+
+// ingressDomains returns the domains the domains gateway serves besides the
+// platform domain
+func ingressDomains(cliFlags *types.CliFlags) []internalharvester.IngressDomain {
+	return internalharvester.IngressDomains(cliFlags.DomainName, cliFlags.ExtraDomains, platformVClusters(cliFlags), cliFlags.VClusterDomainMap)
+}
+
+// platformDNSRecords returns the address of the ingress serving each host
+// of the platform records
+func platformDNSRecords(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) (map[string]string, error) {
+	hosts := internalharvester.PlatformHosts(cliFlags.DomainName, internalharvester.VaultEnabled(cliFlags.StopAfter), platformVClusters(cliFlags), cliFlags.VClusterDomainMap, cliFlags.ExtraDomains, cliFlags.VClusterIngressWildcard)
+
+	return client.DesiredDNSRecords(ctx, hosts)
+}
+
+// gitopsOverlayKey is the config key of the internalharvester.OverlayRecord
+// of the overlay merged over the gitops repository
+const gitopsOverlayKey = "harvester.gitops-overlay"
+
+// applyGitopsOverlay stages the changes --gitops-overlay-dir makes to the
+// gitops repository kubefirst-api rendered, ahead of the changes of the
+// other steps, and records the overlay with its hash. A dry run exports the
+// merged files for review
+func applyGitopsOverlay(ctx context.Context, cliFlags *types.CliFlags, commits *gitopsCommits) (internalharvester.OverlayResult, error) {
+	overlay, err := internalharvester.LoadGitopsOverlay(cliFlags.GitopsOverlayDir)
+	if err != nil {
+		return internalharvester.OverlayResult{}, err
+	}
+
+	repository, err := commits.repo.ReadFiles(ctx, "/")
+	if err != nil {
+		return internalharvester.OverlayResult{}, fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+
+	result, err := overlay.Apply(repository)
+	if err != nil {
+		return internalharvester.OverlayResult{}, err
+	}
+	if len(result.Files) > 0 {
+		if err := commits.add(ctx, result.Files, "apply gitops overlay"); err != nil {
+			return result, err
+		}
+	}
+
+	if cliFlags.DryRun {
+		return result, nil
+	}
+	viper.Set(gitopsOverlayKey, internalharvester.OverlayRecord{Dir: overlay.Dir, Hash: overlay.Hash}.String())
+	if err := viper.WriteConfig(); err != nil {
+		return result, fmt.Errorf("failed to record gitops overlay in config: %w", err)
+	}
+
+	return result, nil
+}
+
+// uniFiPortForwardsKey is the config key of the names of the UniFi port
+// forwards create configured, for destroy to delete them
+const uniFiPortForwardsKey = "harvester.unifi-port-forwards"
+
+// newUniFiController connects to the UniFi controller and site recorded in
+// the kubefirst config
+func newUniFiController(client *internalharvester.Client) (*internalharvester.UniFiController, error) {
+	controller, err := internalharvester.NewUniFiController(viper.GetString("flags.unifi-host"), viper.GetString("flags.unifi-user"), viper.GetString("flags.unifi-password"), client.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	controller.Site = viper.GetString("flags.unifi-site")
+
+	return controller, nil
+}
+
+// configureUniFiPortForwards creates or updates the port forwards of
+// --unifi-port-mapping and reads them back from the controller. Their names
+// are recorded before any is created so destroy finds them after a partial
+// run
+func configureUniFiPortForwards(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) ([]string, error) {
+	mappings, err := internalharvester.ParseUniFiPortMappings(cliFlags.UniFiPortMappings)
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make([]internalharvester.UniFiForward, 0, len(mappings))
+	names := make([]string, 0, len(mappings))
+	for _, mapping := range mappings {
+		forward := mapping.Forward(cliFlags.ClusterName)
+		desired = append(desired, forward)
+		names = append(names, forward.Name)
+	}
+
+	viper.Set(uniFiPortForwardsKey, names)
+	if err := viper.WriteConfig(); err != nil {
+		return nil, fmt.Errorf("failed to record unifi port forwards in config: %w", err)
+	}
+
+	controller, err := newUniFiController(client)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := controller.UpsertPortForwards(ctx, desired)
+	if err != nil {
+		return changed, err
+	}
+
+	if err := controller.VerifyPortForwards(ctx, desired); err != nil {
+		return changed, err
+	}
+
+	return changed, nil
+}
+
+// cloudflareTunnelKey is the config key of the Cloudflare Tunnel create
+// published the ingress through, for destroy to delete it
+const cloudflareTunnelKey = "harvester.cloudflare-tunnel"
+
+// configureCloudflareTunnel publishes the platform hosts through the
+// Cloudflare Tunnel of the cluster: the tunnel forwards them to the
+// ingress serving them, cloudflared connects it from the cluster and
+// proxied CNAME records point the hosts at it. The tunnel is recorded
+// before anything else so destroy finds it after a partial run
+func configureCloudflareTunnel(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) (internalharvester.DNSReconcileResult, error) {
+	dns, origins, err := desiredDNSRecords(ctx, client, cliFlags)
+	if err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	account, err := dns.TunnelAccount(ctx, cliFlags.DomainName)
+	if err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	tunnel, err := dns.EnsureTunnel(ctx, account, internalharvester.TunnelName(cliFlags.ClusterName))
+	if err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	viper.Set(cloudflareTunnelKey, tunnel.String())
+	if err := viper.WriteConfig(); err != nil {
+		return internalharvester.DNSReconcileResult{}, fmt.Errorf("failed to record cloudflare tunnel in config: %w", err)
+	}
+
+	if err := dns.ConfigureTunnel(ctx, tunnel, origins); err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	token, err := dns.TunnelToken(ctx, tunnel)
+	if err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	if err := client.ApplyCloudflaredToken(ctx, token); err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	manifests, err := internalharvester.CloudflaredManifests(cliFlags.RegistryMirror)
+	if err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	if err := commitRegistryFile(ctx, commits, cliFlags, "cloudflared.yaml", manifests, "deploy cloudflared"); err != nil {
+		return internalharvester.DNSReconcileResult{}, err
+	}
+
+	hosts := make([]string, 0, len(origins))
+	for host := range origins {
+		hosts = append(hosts, host)
+	}
+	slices.Sort(hosts)
+
+	result, err := dns.ReconcileTunnelRecords(ctx, tunnel, hosts, internalharvester.ManagedRecordComment(cliFlags.ClusterName), viper.GetStringSlice(dnsZonesKey))
+	if err != nil {
+		return result, err
+	}
+
+	viper.Set(dnsZonesKey, result.Zones)
+	if err := viper.WriteConfig(); err != nil {
+		return result, fmt.Errorf("failed to record dns zones in config: %w", err)
+	}
+
+	return result, nil
+}
+
+// verifyDNSPropagation waits for the platform hosts to resolve through the
+// system resolver, or DNS-over-HTTPS with --dns-check-doh
+func verifyDNSPropagation(ctx context.Context, cliFlags *types.CliFlags) error {
+	resolver, err := internalharvester.NewHostResolver(cliFlags.DNSCheckDoH, cliFlags.Proxy)
+	if err != nil {
+		return err
+	}
+
+	hosts := internalharvester.PropagationHosts(cliFlags.DomainName, internalharvester.VaultEnabled(cliFlags.StopAfter))
+	hosts = append(hosts, internalharvester.IngressDomainHosts(ingressDomains(cliFlags))...)
+
+	dnsCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).DNSPropagation)
+	defer cancel()
+
+	return internalharvester.WaitForDNSPropagation(dnsCtx, resolver, hosts)
+}
+
+// verifyWildcardIngress resolves a random host under the wildcard of every
+// vcluster, as verifyDNSPropagation resolves the platform hosts, and
+// checks the wildcard gateway serves it with a certificate valid for it
+func verifyWildcardIngress(ctx context.Context, cliFlags *types.CliFlags) error {
+	resolver, err := internalharvester.NewHostResolver(cliFlags.DNSCheckDoH, cliFlags.Proxy)
+	if err != nil {
+		return err
+	}
+	httpClient, err := internalharvester.NewHTTPClient(cliFlags.Proxy)
+	if err != nil {
+		return fmt.Errorf("failed to create http client: %w", err)
+	}
+
+	hosts, err := internalharvester.WildcardProbeHosts(cliFlags.VClusters, cliFlags.DomainName, cliFlags.VClusterDomainMap)
+	if err != nil {
+		return err
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).DNSPropagation)
+	defer cancel()
+
+	if err := internalharvester.WaitForDNSPropagation(probeCtx, resolver, hosts); err != nil {
+		return err
+	}
+
+	return internalharvester.ProbeWildcardIngress(probeCtx, httpClient, hosts)
+}
+
+// configureLBPool commits the load balancer address pools next to the
+// registry applications, so the pools are reconciled by ArgoCD like the
+// rest of the platform, then points the platform and vcluster services at
+// the management and tenant pools. It refuses ranges another MetalLB pool
+// of the cluster already hands out
+func configureLBPool(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits, lbPools internalharvester.LBPools) error {
+	conflicts, err := client.LBPoolConflicts(ctx, lbPools)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("load balancer ranges conflict with existing MetalLB address pools: %s; choose free ranges with --lb-ip-range or remove those pools", strings.Join(conflicts, "; "))
+	}
+
+	manifests, err := internalharvester.LBPoolManifests(lbPools)
+	if err != nil {
+		return fmt.Errorf("failed to render load balancer pool: %w", err)
+	}
+
+	message := fmt.Sprintf("configure load balancer pool %s", lbPools)
+	if err := commitRegistryFile(ctx, commits, cliFlags, "lb-ip-pool.yaml", manifests, message); err != nil {
+		return fmt.Errorf("failed to commit load balancer pool: %w", err)
+	}
+
+	var vclusters []string
+	if internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseVCluster) {
+		vclusters = cliFlags.VClusters
+	}
+	if err := client.AssignLBPools(ctx, internalharvester.LBPoolAssignments(lbPools, vclusters)); err != nil {
+		return fmt.Errorf("failed to assign load balancer pools: %w", err)
+	}
+
+	return nil
+}
+
+// recordProvisionedSHA records the commit kubefirst-api left the gitops
+// repository at, the baseline every later rollback returns to
+func recordProvisionedSHA(ctx context.Context, client *internalharvester.Client, gitopsRepo *internalharvester.GitopsRepo) error {
+	sha, err := gitopsRepo.Head(ctx)
+	if err != nil {
+		return err
+	}
+
+	return recordGitopsSHA(ctx, client, "provision", sha, "")
+}
+
+// commitRegistryFile stages content as name inside the registry directory
+// of the cluster's gitops repository under the change message
+func commitRegistryFile(ctx context.Context, commits *gitopsCommits, cliFlags *types.CliFlags, name string, content []byte, message string) error {
+	registryPath := internalharvester.RegistryPath(cliFlags.GitopsRegistryPath, cliFlags.ClusterName)
+	files := map[string][]byte{
+		path.Join(registryPath, name): content,
+	}
+
+	return commits.add(ctx, files, message)
+}
+
+func clusterGitopsRepo(client *internalharvester.Client, cliFlags *types.CliFlags) (*internalharvester.GitopsRepo, error) {
+	gitOwner := cliFlags.GithubOrg
+	if cliFlags.GitProvider == "gitlab" {
+		gitOwner = cliFlags.GitlabGroup
+	}
+
+	gitopsRepo, err := client.NewGitopsRepo(cliFlags.GitProvider, gitOwner, cliFlags.GitopsRepo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve gitops repository: %w", err)
+	}
+
+	return gitopsRepo, nil
+}
+
+// verifyPlatformHealth waits for every ArgoCD application and the core
+// components of the enabled phases to become healthy, printing a diagnostic
+// per unhealthy resource on timeout
+func verifyPlatformHealth(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, stepper step.Stepper) error {
+	ingressPhase := internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseIngress)
+	components := internalharvester.PlatformComponents(
+		cliFlags.InstallIstio && ingressPhase,
+		cliFlags.InstallKgateway && ingressPhase,
+		internalharvester.VaultEnabled(cliFlags.StopAfter),
+		internalharvester.ObservabilityEnabled(cliFlags.StopAfter, cliFlags.InstallObservability),
+	)
+
+	verifyCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).PlatformHealth)
+	defer cancel()
+
+	err := client.VerifyPlatformHealth(verifyCtx, components)
+
+	var healthErr *internalharvester.PlatformHealthError
+	if errors.As(err, &healthErr) {
+		stepper.InfoStepString(healthErr.Report())
+	}
+
+	return err
+}
+
+// oidcConfig collects the --oidc-* flags
+func oidcConfig(cliFlags *types.CliFlags) internalharvester.OIDCConfig {
+	return internalharvester.OIDCConfig{
+		IssuerURL:    cliFlags.OIDCIssuerURL,
+		ClientID:     cliFlags.OIDCClientID,
+		ClientSecret: cliFlags.OIDCClientSecret,
+		AdminGroup:   cliFlags.OIDCAdminGroup,
+	}
+}
+
+// vaultAutoUnseal collects the --vault-auto-unseal flags
+func vaultAutoUnseal(cliFlags *types.CliFlags) internalharvester.VaultAutoUnseal {
+	return internalharvester.VaultAutoUnseal{
+		Mode: cliFlags.VaultAutoUnseal,
+	}
+}
+
+// seedVault writes the paths of --vault-seed-file into the initialized Vault
+// vaultAudit collects the --vault-audit flags, the file audit log goes to
+// the logging stack when --logging installs one
+func vaultAudit(cliFlags *types.CliFlags) internalharvester.VaultAudit {
+	return internalharvester.VaultAudit{
+		Mode: cliFlags.VaultAudit,
+		Size: cliFlags.VaultAuditSize,
+		Ship: internalharvester.LoggingEnabled(cliFlags.StopAfter, cliFlags.Logging),
+	}
+}
+
+// configureVaultAuditVolume commits the audit volume and its rotation
+// sidecar to the Vault chart values of the gitops repository
+func configureVaultAuditVolume(ctx context.Context, commits *gitopsCommits, audit internalharvester.VaultAudit, registryMirror string) error {
+	files, err := commits.repo.ReadYAMLFiles(ctx, "/")
+	if err != nil {
+		return fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+
+	changed, err := internalharvester.VaultAuditFiles(files, audit, registryMirror)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return commits.add(ctx, changed, "configure vault audit volume")
+}
+
+func enableVaultAudit(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, audit internalharvester.VaultAudit) error {
+	vaultClient, err := client.NewVaultClient(ctx, cliFlags.DomainName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to vault: %w", err)
+	}
+
+	return internalharvester.EnableVaultAudit(ctx, vaultClient, audit)
+}
+
+func verifyVaultAudit(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, audit internalharvester.VaultAudit) error {
+	vaultClient, err := client.NewVaultClient(ctx, cliFlags.DomainName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to vault: %w", err)
+	}
+
+	return internalharvester.VerifyVaultAudit(ctx, vaultClient, audit)
+}
+
+func seedVault(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) error {
+	seed, err := internalharvester.ParseVaultSeedFile(cliFlags.VaultSeedFile)
+	if err != nil {
+		return err
+	}
+
+	vaultClient, err := client.NewVaultClient(ctx, cliFlags.DomainName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to vault: %w", err)
+	}
+
+	return internalharvester.SeedVault(ctx, vaultClient, seed)
+}
+
+// configureSSO commits the ArgoCD OIDC settings to the gitops repository so
+// they survive ArgoCD syncs, and enables OIDC login on Vault directly since
+// Vault auth methods are not managed through gitops, except in a dry run
+func configureSSO(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	oidc := oidcConfig(cliFlags)
+
+	if err := client.ApplyArgoCDOIDCSecret(ctx, oidc.ClientSecret); err != nil {
+		return fmt.Errorf("failed to store ArgoCD oidc client secret: %w", err)
+	}
+
+	manifests, err := internalharvester.ArgoCDSSOManifests(oidc, cliFlags.DomainName)
+	if err != nil {
+		return fmt.Errorf("failed to render ArgoCD sso configuration: %w", err)
+	}
+
+	if err := commitRegistryFile(ctx, commits, cliFlags, "argocd-sso.yaml", manifests, "configure ArgoCD sso"); err != nil {
+		return fmt.Errorf("failed to commit ArgoCD sso configuration: %w", err)
+	}
+
+	if cliFlags.DryRun {
+		return nil
+	}
+
+	vaultClient, err := client.NewVaultClient(ctx, cliFlags.DomainName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to vault: %w", err)
+	}
+
+	if err := internalharvester.ConfigureVaultOIDC(ctx, vaultClient, oidc, cliFlags.DomainName); err != nil {
+		return fmt.Errorf("failed to configure vault oidc: %w", err)
+	}
+
+	return nil
+}
+
+// installObservability stores the Grafana admin password in the cluster and
+// commits kube-prometheus-stack, the ServiceMonitors of the installed
+// platform components and the platform dashboards to the gitops repository.
+// Grafana is served through the same ingress class and issuer as ArgoCD
+func installObservability(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	if err := client.ApplyGrafanaAdminSecret(ctx); err != nil {
+		return err
+	}
+
+	ingress, err := client.ReadPlatformIngress(ctx, cliFlags.DomainName)
+	if err != nil {
+		return fmt.Errorf("failed to read the platform ingress: %w", err)
+	}
+
+	manifests, err := internalharvester.ObservabilityManifests(internalharvester.ObservabilityOptions{
+		DomainName: cliFlags.DomainName,
+		VClusters:  cliFlags.VClusters,
+		Istio:      cliFlags.InstallIstio,
+		Kgateway:   cliFlags.InstallKgateway,
+		Vault:      internalharvester.VaultEnabled(cliFlags.StopAfter),
+		Loki:       internalharvester.LoggingEnabled(cliFlags.StopAfter, cliFlags.Logging),
+		Ingress:    ingress,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := commitRegistryFile(ctx, commits, cliFlags, "observability.yaml", manifests, "install observability"); err != nil {
+		return fmt.Errorf("failed to commit observability manifests: %w", err)
+	}
+
+	return nil
+}
+
+// installLogging commits Loki and Promtail to the gitops repository. The
+// Grafana of observability gets Loki as a datasource, without it the
+// logging stack serves its own Grafana the way observability would
+func installLogging(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	retention, err := internalharvester.ParseLoggingRetention(cliFlags.LoggingRetention)
+	if err != nil {
+		return fmt.Errorf("invalid --logging-retention: %w", err)
+	}
+
+	opts := internalharvester.LoggingOptions{
+		ClusterName: cliFlags.ClusterName,
+		Retention:   retention,
+		Grafana:     !internalharvester.ObservabilityEnabled(cliFlags.StopAfter, cliFlags.InstallObservability),
+		DomainName:  cliFlags.DomainName,
+	}
+	if opts.Grafana {
+		if err := client.ApplyGrafanaAdminSecret(ctx); err != nil {
+			return err
+		}
+
+		if opts.Ingress, err = client.ReadPlatformIngress(ctx, cliFlags.DomainName); err != nil {
+			return fmt.Errorf("failed to read the platform ingress: %w", err)
+		}
+	}
+
+	manifests, err := internalharvester.LoggingManifests(opts)
+	if err != nil {
+		return err
+	}
+
+	if err := commitRegistryFile(ctx, commits, cliFlags, "logging.yaml", manifests, "install logging"); err != nil {
+		return fmt.Errorf("failed to commit logging manifests: %w", err)
+	}
+
+	return nil
+}
+
+// configureVClusterAppSet commits the vcluster ApplicationSet and a
+// directory per vcluster holding its chart values to the gitops repository.
+// The ApplicationSet clones the repository the way the root application
+// does
+func configureVClusterAppSet(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	registryPath := internalharvester.RegistryPath(cliFlags.GitopsRegistryPath, cliFlags.ClusterName)
+	root, err := client.RootApplication(ctx, registryPath)
+	if err != nil {
+		return err
+	}
+
+	connections, err := internalharvester.ParseVClusterConnections(cliFlags.VClusters, cliFlags.VClusterConnections)
+	if err != nil {
+		return fmt.Errorf("invalid --vcluster-connect: %w", err)
+	}
+	trust, err := trustBundleTargets(cliFlags.TrustBundle, cliFlags.VClusters, cliFlags.TrustBundleTargets)
+	if err != nil {
+		return err
+	}
+	values, err := vclusterValues(cliFlags.VClusters, cliFlags.VClusterSpecs, cliFlags.VClusterDefaultSpec, cliFlags.VClusterNodeSelectors, len(cliFlags.GPUNodes) > 0, storageClasses(cliFlags), connections, trust)
+	if err != nil {
+		return err
+	}
+
+	files, err := internalharvester.VClusterAppSetFiles(root.Spec.GetSource().RepoURL, registryPath, cliFlags.VClusters, values)
+	if err != nil {
+		return err
+	}
+
+	return commits.add(ctx, files, "generate vclusters with an applicationset")
+}
+
+// configureVClusterValues sets the chart values of the --vcluster-spec,
+// --vcluster-default-spec, --vcluster-node-selector and --gpu-nodes flags in
+// the per-vcluster applications of the gitops template. The storage class,
+// connection and trust bundle values are set by their own steps
+func configureVClusterValues(ctx context.Context, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	values, err := vclusterValues(cliFlags.VClusters, cliFlags.VClusterSpecs, cliFlags.VClusterDefaultSpec, cliFlags.VClusterNodeSelectors, len(cliFlags.GPUNodes) > 0, internalharvester.StorageClasses{}, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	files, err := commits.stagedYAMLFiles(ctx)
+	if err != nil {
+		return err
+	}
+
+	changed, err := internalharvester.VClusterValuesFiles(files, values)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return commits.add(ctx, changed, "configure vcluster values")
+}
+
+// configureVClusterApps commits the catalog app applications of every
+// vcluster --vcluster-apps lists to the registry directory, the vclusters
+// listed without apps getting a file without any
+func configureVClusterApps(ctx context.Context, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	apps, err := internalharvester.ParseVClusterApps(cliFlags.VClusters, cliFlags.VClusterApps)
+	if err != nil {
+		return fmt.Errorf("invalid --vcluster-apps: %w", err)
+	}
+
+	registryPath := internalharvester.RegistryPath(cliFlags.GitopsRegistryPath, cliFlags.ClusterName)
+	files := map[string][]byte{}
+	for vcluster, names := range apps {
+		manifests, err := internalharvester.VClusterAppsManifests(vcluster, names)
+		if err != nil {
+			return err
+		}
+		files[path.Join(registryPath, internalharvester.VClusterAppsFile(vcluster))] = manifests
+	}
+
+	return commits.add(ctx, files, "install vcluster catalog apps")
+}
+
+// configureVClusterConnections commits the services the vclusters of
+// --vcluster-connect replicate to the chart values of their applications,
+// which the ApplicationSet directories already hold, and the Istio policies
+// authorizing the connections
+func configureVClusterConnections(ctx context.Context, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	connections, err := internalharvester.ParseVClusterConnections(cliFlags.VClusters, cliFlags.VClusterConnections)
+	if err != nil {
+		return fmt.Errorf("invalid --vcluster-connect: %w", err)
+	}
+
+	if !cliFlags.VClusterAppSet {
+		files, err := commits.repo.ReadYAMLFiles(ctx, "/")
+		if err != nil {
+			return fmt.Errorf("failed to read gitops repository: %w", err)
+		}
+
+		changed, err := internalharvester.VClusterConnectionFiles(files, connections)
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 {
+			if err := commits.add(ctx, changed, "replicate vcluster connection services"); err != nil {
+				return err
+			}
+		}
+	}
+
+	policies, err := internalharvester.VClusterConnectionPolicies(cliFlags.VClusters, connections)
+	if err != nil {
+		return err
+	}
+
+	return commitRegistryFile(ctx, commits, cliFlags, "vcluster-connect.yaml", policies, "authorize vcluster connections")
+}
+
+// storageClasses returns the --storage-class and --vcluster-storage-class
+// storage classes
+func storageClasses(cliFlags *types.CliFlags) internalharvester.StorageClasses {
+	return internalharvester.StorageClasses{Default: cliFlags.StorageClass, VClusters: cliFlags.VClusterStorageClasses}
+}
+
+// configureStorageClasses commits the storage classes to the helm values of
+// the platform ArgoCD applications in the gitops repository, for ArgoCD to
+// create their PVCs with
+func configureStorageClasses(ctx context.Context, commits *gitopsCommits, classes internalharvester.StorageClasses) error {
+	files, err := commits.repo.ReadYAMLFiles(ctx, "/")
+	if err != nil {
+		return fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+
+	changed, err := internalharvester.StorageClassFiles(files, classes)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	return commits.add(ctx, changed, "configure storage classes")
+}
+
+// configureExternalSecrets stores the backend credentials next to External
+// Secrets Operator and commits the ClusterSecretStore reading them to the
+// gitops repository
+func configureExternalSecrets(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	config, err := internalharvester.ExternalSecretsFromEnv(cliFlags.ExternalSecretsBackend)
+	if err != nil {
+		return err
+	}
+
+	if err := client.ApplyExternalSecretsCredentials(ctx, config); err != nil {
+		return fmt.Errorf("failed to store secret store credentials: %w", err)
+	}
+
+	manifests, err := internalharvester.ClusterSecretStoreManifests(config)
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("configure %s cluster secret store", config.Backend)
+	if err := commitRegistryFile(ctx, commits, cliFlags, "cluster-secret-store.yaml", manifests, message); err != nil {
+		return fmt.Errorf("failed to commit cluster secret store: %w", err)
+	}
+
+	return nil
+}
+
+// newHealthTracker builds the tracker the provision watcher uses to fail fast
+// on ArgoCD applications that are stuck degraded or flapping
+func newHealthTracker(client *internalharvester.Client, cliFlags *types.CliFlags, stepper step.Stepper) *internalharvester.HealthTracker {
+	var onTransition func(message string)
+	if cliFlags.WatchVerbose {
+		onTransition = func(message string) {
+			stepper.InfoStep(step.EmojiWrench, message)
+		}
+	}
+
+	tracker := internalharvester.NewHealthTracker(client, cliFlags.DegradedGracePeriod, cliFlags.MaxHealthFlaps, onTransition)
+	// the applications ArgoCD syncs move the watcher steps along
+	tracker.SetProgressFunc(func(ready, total int) {
+		step.ReportProgress(stepper, ready, total)
+	})
+
+	return tracker
+}
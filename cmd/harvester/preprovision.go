@@ -0,0 +1,70 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// runPreProvision runs the Harvester specific checks against the cluster
+// that have to pass before kubefirst-api starts installing ArgoCD
+func runPreProvision(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, stepper step.Stepper) error {
+	if cliFlags.HA {
+		stepper.NewProgressStep("Verify Control Plane Quorum")
+
+		quorumCtx, cancel := context.WithTimeout(ctx, provisionBudget(cliFlags).ControlPlaneQuorum)
+		defer cancel()
+
+		if err := client.WaitForControlPlaneQuorum(quorumCtx, cliFlags.HANodeCount); err != nil {
+			wrerr := fmt.Errorf("control plane quorum of %d nodes not reached: %w", internalharvester.Quorum(cliFlags.HANodeCount), err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	// configured before kubefirst-api installs anything pulling an image
+	if cliFlags.RegistryMirror != "" {
+		stepper.NewProgressStep("Configure Registry Mirror")
+
+		if err := client.ApplyContainerdRegistry(ctx, cliFlags.RegistryMirror); err != nil {
+			wrerr := fmt.Errorf("failed to configure registry mirror: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Harvester nodes pull %s through %s", strings.Join(internalharvester.MirroredRegistries, ", "), cliFlags.RegistryMirror))
+	}
+
+	// tainted before the GPU operator and the vclusters schedule onto them
+	if len(cliFlags.GPUNodes) > 0 {
+		stepper.NewProgressStep("Taint GPU Nodes")
+
+		nodes, err := client.ResolveGPUNodes(ctx, cliFlags.GPUNodes)
+		if err == nil {
+			err = client.TaintGPUNodes(ctx, nodes)
+		}
+		if err != nil {
+			wrerr := fmt.Errorf("failed to taint gpu nodes: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Tainted GPU nodes %s with %s=%s:NoSchedule", strings.Join(nodes, ", "), internalharvester.GPUTaintKey, internalharvester.GPUTaintValue))
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// printEffectiveFlags prints the create flags as resolved from their
+// defaults, the --from-config file, the environment and the command line,
+// followed by the credential environment variables, with secrets masked
+func printEffectiveFlags(cmd *cobra.Command, format string) error {
+	var flags []internalharvester.EffectiveFlag
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if flag.Name == "print-flags" {
+			return
+		}
+
+		flags = append(flags, internalharvester.NewProvenanceFlag(flag.Name, flagString(flag), internalharvester.FlagProvenanceOf(flag)))
+	})
+
+	for _, name := range credentialEnvVars {
+		if value, ok := os.LookupEnv(name); ok {
+			flags = append(flags, internalharvester.NewEffectiveFlag(name, value, internalharvester.FlagSourceEnv))
+		}
+	}
+
+	if err := internalharvester.WriteEffectiveFlags(cmd.OutOrStdout(), flags, format); err != nil {
+		return fmt.Errorf("failed to print flags: %w", err)
+	}
+
+	return nil
+}
+
+// flagString renders lists the way they are passed on the command line
+// rather than pflag's bracketed form
+func flagString(flag *pflag.Flag) string {
+	if list, ok := flag.Value.(pflag.SliceValue); ok {
+		return strings.Join(list.GetSlice(), ",")
+	}
+
+	return flag.Value.String()
+}
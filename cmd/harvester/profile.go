@@ -0,0 +1,187 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// profileHealthKey holds the internalharvester.ProfileHealth status last
+// found, for profiles list
+const profileHealthKey = "harvester.health"
+
+// useProfile switches the kubefirst config to the one of --profile, the
+// root hook having read the default one. A profile other than the default
+// reads its cached kubeconfig unless --kubeconfig-path is set
+func useProfile(cmd *cobra.Command) error {
+	profile, err := cmd.Flags().GetString("profile")
+	if err != nil {
+		return fmt.Errorf("failed to get profile flag: %w", err)
+	}
+	if err := internalharvester.ValidateProfileName(profile); err != nil {
+		return fmt.Errorf("invalid --profile: %w", err)
+	}
+
+	internalharvester.SetProfile(profile)
+	if profile == internalharvester.DefaultProfile {
+		return nil
+	}
+
+	configFile, err := internalharvester.ProfileConfigFile(profile)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(configFile), 0o700); err != nil {
+		return fmt.Errorf("failed to create the directory of profile %s: %w", profile, err)
+	}
+	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(configFile, nil, 0o600); err != nil {
+			return fmt.Errorf("failed to create the kubefirst config of profile %s: %w", profile, err)
+		}
+	}
+
+	viper.SetConfigFile(configFile)
+	viper.SetConfigType("yaml")
+	if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read the kubefirst config of profile %s: %w", profile, err)
+	}
+
+	kubeconfig, err := internalharvester.ProfileKubeconfig(profile)
+	if err != nil {
+		return err
+	}
+	flag := cmd.Flags().Lookup("kubeconfig-path")
+	if flag == nil || flag.Changed {
+		return nil
+	}
+	if _, err := os.Stat(kubeconfig); err == nil {
+		if err := flag.Value.Set(kubeconfig); err != nil {
+			return fmt.Errorf("failed to use the kubeconfig of profile %s: %w", profile, err)
+		}
+	}
+
+	return nil
+}
+
+// cacheProfileKubeconfig keeps a copy of the kubeconfig create connected
+// with in the directory of the active profile, for the later commands of
+// the profile to read without --kubeconfig-path
+func cacheProfileKubeconfig(kubeconfigPath string) error {
+	cached, err := internalharvester.ProfileKubeconfig(internalharvester.ActiveProfile())
+	if err != nil || cached == "" {
+		return err
+	}
+
+	source := os.ExpandEnv(kubeconfigPath)
+	if source == cached {
+		return nil
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return fmt.Errorf("failed to read kubeconfig %s: %w", source, err)
+	}
+	if err := os.WriteFile(cached, data, 0o600); err != nil {
+		return fmt.Errorf("failed to cache the kubeconfig in %s: %w", cached, err)
+	}
+
+	return nil
+}
+
+// recordProfileHealth records how many applications status found Healthy
+// and Synced
+func recordProfileHealth(ready, total int) error {
+	viper.Set(profileHealthKey, internalharvester.ProfileHealth{Ready: ready, Total: total, Checked: time.Now().UTC()}.String())
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record the platform health: %w", err)
+	}
+
+	return nil
+}
+
+// checkProfileCluster refuses to go on when the Harvester cluster of the
+// kubeconfig recorded another kubefirst cluster than the one of the active
+// profile
+func checkProfileCluster(ctx context.Context, client *internalharvester.Client, stepper step.Stepper) error {
+	profile := internalharvester.ActiveProfile()
+	clusterName := viper.GetString("flags.cluster-name")
+	if clusterName == "" {
+		wrerr := fmt.Errorf("profile %s records no cluster, pass the --profile that created the cluster", profile)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	recorded, found, err := client.RecordedClusterName(ctx)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to read the cluster name recorded on the Harvester cluster: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	if !found {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("The Harvester cluster records no kubefirst cluster name, make sure it runs cluster %q of profile %s", clusterName, profile))
+		return nil
+	}
+	if recorded != clusterName {
+		wrerr := fmt.Errorf("profile %s records cluster %q but the Harvester cluster of the kubeconfig runs cluster %q, refusing to go on; pass the --profile of %q or the kubeconfig of %q", profile, clusterName, recorded, recorded, clusterName)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	return nil
+}
+
+func runProfilesList(cmd *cobra.Command, _ []string) error {
+	profiles, err := internalharvester.ListProfiles()
+	if err != nil {
+		return fmt.Errorf("failed to list profiles: %w", err)
+	}
+	if len(profiles) == 0 {
+		return errors.New("no profiles on this machine, create a cluster with harvester create --profile <name>")
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tCLUSTER\tDOMAIN\tHEALTH")
+	for _, profile := range profiles {
+		health := "unknown, run harvester status"
+		if profile.Health != nil {
+			health = profile.Health.Summary()
+		}
+		name := profile.Name
+		if name == internalharvester.ActiveProfile() {
+			name += " (active)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, profile.ClusterName, profile.Domain, health)
+	}
+
+	return w.Flush()
+}
+
+// completeProfiles completes --profile with the profiles on this machine
+func completeProfiles(_ *cobra.Command, _ []string, _ string) ([]string, cobra.ShellCompDirective) {
+	profiles, err := internalharvester.ListProfiles()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var names []string
+	for _, profile := range profiles {
+		names = append(names, profile.Name)
+	}
+
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
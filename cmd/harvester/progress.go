@@ -0,0 +1,100 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+)
+
+// progressWriteTimeout bounds every write of the progress ConfigMap, the
+// stepper waits on it
+const progressWriteTimeout = 5 * time.Second
+
+// provisionProgress records the percent-complete of a create run in the
+// progress ConfigMap as its steps report it
+type provisionProgress struct {
+	events   chan step.StepEvent
+	progress *step.Progress
+	drained  chan struct{}
+
+	mu     sync.Mutex
+	client *internalharvester.Client
+	latest internalharvester.ProvisionProgress
+	err    error
+}
+
+// newProvisionProgress starts draining step events, the stepper blocks on
+// its event channel otherwise
+func newProvisionProgress(weights map[string]time.Duration) *provisionProgress {
+	p := &provisionProgress{
+		events:   make(chan step.StepEvent, 16),
+		progress: step.NewProgress(weights),
+		drained:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.drained)
+		for event := range p.events {
+			p.record(event.Percent, event.Phase, string(event.Status), event.Timestamp)
+		}
+	}()
+
+	return p
+}
+
+// configure sets the cluster to record the progress in, steps reported
+// before are recorded with the next one
+func (p *provisionProgress) configure(client *internalharvester.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.client = client
+}
+
+func (p *provisionProgress) record(percent int, stepName, status string, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.latest = internalharvester.ProvisionProgress{Percent: percent, Step: stepName, Status: status, UpdatedAt: at}
+	if p.client == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), progressWriteTimeout)
+	defer cancel()
+	// only the last failure is reported, the next write may well succeed
+	p.err = p.client.RecordProgress(ctx, p.latest)
+}
+
+// finish records the outcome of the run, 100 percent once it succeeded.
+// Failed writes are reported to out but never fail the run
+func (p *provisionProgress) finish(runErr error, out io.Writer) {
+	close(p.events)
+	<-p.drained
+
+	status := string(step.StatusFailed)
+	if runErr == nil {
+		p.progress.Finish()
+		status = string(step.StatusComplete)
+	}
+
+	p.mu.Lock()
+	latest := p.latest
+	p.mu.Unlock()
+	p.record(p.progress.Percent(), latest.Step, status, time.Now())
+
+	if p.err != nil {
+		fmt.Fprintf(out, "warning: failed to record provisioning progress: %v\n", p.err)
+	}
+}
@@ -0,0 +1,450 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// promotionKey holds the internalharvester.Promotion of the promote of
+// vcluster, so a failed promote resumes where it stopped
+func promotionKey(vcluster string) string {
+	return "harvester.promotions." + vcluster
+}
+
+// promoteRun is what the stages of a vcluster promote share
+type promoteRun struct {
+	client    *internalharvester.Client
+	promotion *internalharvester.Promotion
+	// vclusterServer and targetServer are the API servers ArgoCD has
+	// registered the vcluster and the workload cluster under, the
+	// vcluster one empty when it is addressed by name only
+	vclusterServer string
+	targetServer   string
+	gitopsRepo     *internalharvester.GitopsRepo
+}
+
+// runVClusterPromote moves the applications of a vcluster to a workload
+// cluster ArgoCD manages, stage by stage, recording each stage completed
+// so a rerun picks up where a failure stopped, and writes a migration
+// report of what was moved and what is left to do by hand
+func runVClusterPromote(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	name := args[0]
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	target, err := cmd.Flags().GetString("to-cluster")
+	if err != nil {
+		return fmt.Errorf("failed to get to-cluster flag: %w", err)
+	}
+	if target == name {
+		return fmt.Errorf("--to-cluster: vcluster %q cannot be promoted to itself", name)
+	}
+
+	migrateData, err := cmd.Flags().GetBool("migrate-data")
+	if err != nil {
+		return fmt.Errorf("failed to get migrate-data flag: %w", err)
+	}
+
+	targetKubeconfig, err := cmd.Flags().GetString("target-kubeconfig")
+	if err != nil {
+		return fmt.Errorf("failed to get target-kubeconfig flag: %w", err)
+	}
+	if migrateData && targetKubeconfig == "" {
+		return errors.New("--migrate-data requires --target-kubeconfig, the workload cluster the volume data is restored to")
+	}
+
+	targetNamespace, err := cmd.Flags().GetString("target-namespace")
+	if err != nil {
+		return fmt.Errorf("failed to get target-namespace flag: %w", err)
+	}
+	if targetNamespace == "" {
+		targetNamespace = name
+	}
+
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get timeout flag: %w", err)
+	}
+
+	reportPath, err := cmd.Flags().GetString("report-path")
+	if err != nil {
+		return fmt.Errorf("failed to get report-path flag: %w", err)
+	}
+	if reportPath == "" {
+		reportPath, err = internalharvester.DefaultReportDir(viper.GetString("flags.cluster-name"))
+		if err != nil {
+			return err
+		}
+	}
+
+	promotion, found, err := internalharvester.ParsePromotion(viper.GetString(promotionKey(name)))
+	if err != nil {
+		return fmt.Errorf("failed to read the promotion of vcluster %q in the kubefirst config: %w", name, err)
+	}
+	switch {
+	case found && !promotion.Finished.IsZero():
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("vCluster %s was promoted to %s on %s", name, promotion.Target, promotion.Finished.Format(time.RFC3339)))
+		return nil
+	case found && promotion.Target != target:
+		return fmt.Errorf("vcluster %q is being promoted to %q, rerun with --to-cluster %s to finish that promotion first", name, promotion.Target, promotion.Target)
+	case !found && !slices.Contains(viper.GetStringSlice("flags.vclusters"), name):
+		return fmt.Errorf("vcluster %q is not in the kubefirst config", name)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Resolve Promotion")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := pingCluster(ctx, client, stepper); err != nil {
+		return err
+	}
+
+	run := &promoteRun{client: client}
+
+	run.targetServer, found, err = client.ArgoCDClusterServer(ctx, target)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to look up the ArgoCD cluster %q: %w", target, err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	if !found {
+		wrerr := fmt.Errorf("workload cluster %q is not registered with ArgoCD, register it with argocd cluster add first", target)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	run.vclusterServer, _, err = client.ArgoCDClusterServer(ctx, name)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to look up the ArgoCD cluster %q: %w", name, err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if promotion == nil {
+		applications, err := client.VClusterApplications(ctx, name, run.vclusterServer)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to list the applications of vcluster %q: %w", name, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		if len(applications) == 0 {
+			wrerr := fmt.Errorf("no ArgoCD application deploys into vcluster %q, there is nothing to promote", name)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		promotion = &internalharvester.Promotion{VCluster: name, Target: target, Applications: applications, Started: time.Now()}
+		if err := recordPromotion(promotion); err != nil {
+			stepper.FailCurrentStep(err)
+			return err
+		}
+	}
+	run.promotion = promotion
+
+	stepper.CompleteCurrentStep()
+
+	stages := []struct {
+		name  string
+		title string
+		run   func(ctx context.Context) (moved, followUps []string, err error)
+	}{
+		{internalharvester.PromoteStageSecrets, "Grant Vault Access", run.grantSecrets},
+		{internalharvester.PromoteStageData, "Migrate Volume Data", func(ctx context.Context) ([]string, []string, error) {
+			return run.migrateData(ctx, migrateData, targetKubeconfig, targetNamespace, proxy)
+		}},
+		{internalharvester.PromoteStageRepoint, "Re-point Applications", run.repoint},
+		{internalharvester.PromoteStageVerify, fmt.Sprintf("Verify Applications on %s", target), func(ctx context.Context) ([]string, []string, error) {
+			return run.verify(ctx, timeout)
+		}},
+		{internalharvester.PromoteStageDecommission, "Decommission vCluster", run.decommission},
+	}
+	for _, stage := range stages {
+		if promotion.Done(stage.name) {
+			stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("%s completed by a previous run, skipping", stage.title))
+			continue
+		}
+
+		stepper.NewProgressStep(stage.title)
+
+		moved, followUps, err := stage.run(ctx)
+		if err != nil {
+			stepper.FailCurrentStep(err)
+			return errors.Join(err, writePromotionReport(reportPath, promotion, stepper))
+		}
+
+		promotion.Complete(stage.name, moved, followUps)
+		if err := recordPromotion(promotion); err != nil {
+			stepper.FailCurrentStep(err)
+			return err
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	promotion.Finished = time.Now()
+	if err := recordPromotion(promotion); err != nil {
+		return err
+	}
+
+	stepper.InfoStep(step.EmojiTada, fmt.Sprintf("vCluster %s promoted to %s", name, target))
+
+	return writePromotionReport(reportPath, promotion, stepper)
+}
+
+// grantSecrets lets the target read the Vault paths the secrets of the
+// vcluster come from, the secrets created inside the vcluster are left to
+// recreate by hand
+func (r *promoteRun) grantSecrets(ctx context.Context) ([]string, []string, error) {
+	vcluster, target := r.promotion.VCluster, r.promotion.Target
+
+	vaultSecrets, rawSecrets, err := r.client.VClusterSecrets(ctx, vcluster)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the secrets of vcluster %q: %w", vcluster, err)
+	}
+
+	var moved, followUps []string
+	for _, secret := range rawSecrets {
+		followUps = append(followUps, fmt.Sprintf("recreate secret %s on %s, it was created inside the vCluster rather than from Vault", secret, target))
+	}
+	if len(vaultSecrets) == 0 {
+		return moved, followUps, nil
+	}
+
+	if !internalharvester.VaultEnabled(viper.GetString("flags.stop-after")) {
+		for _, secret := range vaultSecrets {
+			followUps = append(followUps, fmt.Sprintf("recreate secret %s on %s, the platform has no Vault to grant access to", secret, target))
+		}
+		return moved, followUps, nil
+	}
+
+	vaultClient, err := r.client.NewVaultClient(ctx, viper.GetString("flags.domain-name"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create vault client: %w", err)
+	}
+
+	policy, written, err := internalharvester.GrantPromotedSecrets(ctx, vaultClient, vcluster, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to grant %s access to the secrets of vcluster %q: %w", target, vcluster, err)
+	}
+
+	moved = append(moved, fmt.Sprintf("Vault policy %s grants %s read access to the secrets of the vCluster", policy, target))
+	for _, secret := range vaultSecrets {
+		moved = append(moved, fmt.Sprintf("secret %s keeps its Vault path", secret))
+	}
+	if !written {
+		mount := internalharvester.PromotedVaultMount(target)
+		followUps = append(followUps, fmt.Sprintf("enable the Kubernetes auth method of %s at auth/%s and bind policy %s with: vault write auth/%s/role/%s bound_service_account_names='*' bound_service_account_namespaces='*' token_policies=%s", target, mount, policy, mount, vcluster, policy))
+	}
+
+	return moved, followUps, nil
+}
+
+// migrateData moves the volume data of the vcluster to the target with a
+// Velero backup and restore. Without --migrate-data the claims are only
+// listed for the report
+func (r *promoteRun) migrateData(ctx context.Context, migrate bool, targetKubeconfig, targetNamespace, proxy string) ([]string, []string, error) {
+	vcluster, target := r.promotion.VCluster, r.promotion.Target
+	hostNamespace := internalharvester.VClusterNamespace(vcluster)
+
+	claims, err := r.client.VClusterClaims(ctx, vcluster)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the volume claims of vcluster %q: %w", vcluster, err)
+	}
+
+	var moved, followUps []string
+	hostNames := slices.Sorted(maps.Keys(claims))
+	if !migrate {
+		for _, hostName := range hostNames {
+			followUps = append(followUps, fmt.Sprintf("the data of volume claim %s was not migrated, it stays in %s/%s until the namespace is deleted", claims[hostName], hostNamespace, hostName))
+		}
+		return moved, followUps, nil
+	}
+	if len(claims) == 0 {
+		return moved, followUps, nil
+	}
+
+	backup := "promote-" + vcluster
+	r.promotion.Backup = backup
+	if err := r.client.BackupVCluster(ctx, vcluster, backup); err != nil {
+		return nil, nil, fmt.Errorf("failed to back vcluster %q up: %w", vcluster, err)
+	}
+
+	targetClient, err := internalharvester.NewClient(targetKubeconfig, "", proxy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create client for %s: %w", target, err)
+	}
+
+	if err := targetClient.RestoreVolumes(ctx, backup, hostNamespace, targetNamespace); err != nil {
+		return nil, nil, fmt.Errorf("failed to restore the volumes of vcluster %q on %s: %w", vcluster, target, err)
+	}
+
+	for _, hostName := range hostNames {
+		moved = append(moved, fmt.Sprintf("volume claim %s restored on %s as %s/%s", claims[hostName], target, targetNamespace, hostName))
+		followUps = append(followUps, fmt.Sprintf("point the workloads claiming %s at the restored claim %s/%s, the vCluster gave it its host name", claims[hostName], targetNamespace, hostName))
+	}
+
+	return moved, followUps, nil
+}
+
+// repoint commits the destinations of the applications of the vcluster
+// re-pointed to the target, so ArgoCD moves them rather than reverting a
+// change made on the applications themselves
+func (r *promoteRun) repoint(ctx context.Context) ([]string, []string, error) {
+	vcluster, target := r.promotion.VCluster, r.promotion.Target
+
+	gitopsRepo, err := r.recordedGitopsRepo()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	files, err := gitopsRepo.ReadYAMLFiles(ctx, "/")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+
+	changed := internalharvester.RepointApplications(files, vcluster, r.vclusterServer, target)
+	if len(changed) == 0 {
+		return nil, nil, nil
+	}
+
+	message := fmt.Sprintf("promote vcluster %s to %s", vcluster, target)
+	if err := r.commit(ctx, changed, message); err != nil {
+		return nil, nil, err
+	}
+
+	var moved []string
+	for _, file := range slices.Sorted(maps.Keys(changed)) {
+		moved = append(moved, fmt.Sprintf("destinations in %s re-pointed to %s", file, target))
+	}
+
+	return moved, nil, nil
+}
+
+// verify waits for the applications of the vcluster to be Synced and
+// Healthy on the target
+func (r *promoteRun) verify(ctx context.Context, timeout time.Duration) ([]string, []string, error) {
+	target := r.promotion.Target
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := r.client.WaitForPromotedApplications(ctx, r.promotion.Applications, target, r.targetServer); err != nil {
+		return nil, nil, fmt.Errorf("applications not ready on %s: %w", target, err)
+	}
+
+	var moved []string
+	for _, application := range r.promotion.Applications {
+		moved = append(moved, fmt.Sprintf("application %s Synced and Healthy on %s", application, target))
+	}
+
+	return moved, nil, nil
+}
+
+// decommission removes the vcluster from the vcluster ApplicationSet; its
+// namespace and its ArgoCD cluster are left for the report
+func (r *promoteRun) decommission(ctx context.Context) ([]string, []string, error) {
+	vcluster := r.promotion.VCluster
+	followUps := []string{fmt.Sprintf("delete namespace %s once its data is no longer needed", internalharvester.VClusterNamespace(vcluster))}
+	if r.vclusterServer != "" {
+		followUps = append(followUps, fmt.Sprintf("remove the ArgoCD cluster %s with: argocd cluster rm %s", vcluster, r.vclusterServer))
+	}
+
+	vclusters, err := appSetVClusters()
+	if err != nil {
+		followUps = append(followUps, fmt.Sprintf("remove %s from --vclusters and rerun harvester create to delete the vCluster", vcluster))
+		return nil, followUps, nil
+	}
+
+	registryPath := internalharvester.RegistryPath(viper.GetString("flags.gitops-registry-path"), viper.GetString("flags.cluster-name"))
+	files := map[string][]byte{path.Join(internalharvester.VClustersPath(registryPath), vcluster): nil}
+	if err := r.commit(ctx, files, fmt.Sprintf("delete vcluster %s promoted to %s", vcluster, r.promotion.Target)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := forgetVCluster(vclusters, vcluster); err != nil {
+		return nil, nil, err
+	}
+
+	return []string{fmt.Sprintf("vCluster %s removed from the vcluster ApplicationSet", vcluster)}, followUps, nil
+}
+
+func (r *promoteRun) recordedGitopsRepo() (*internalharvester.GitopsRepo, error) {
+	if r.gitopsRepo == nil {
+		gitopsRepo, err := recordedGitopsRepo(r.client)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve gitops repository: %w", err)
+		}
+		r.gitopsRepo = gitopsRepo
+	}
+
+	return r.gitopsRepo, nil
+}
+
+func (r *promoteRun) commit(ctx context.Context, files map[string][]byte, message string) error {
+	gitopsRepo, err := r.recordedGitopsRepo()
+	if err != nil {
+		return err
+	}
+
+	sha, err := gitopsRepo.CommitFiles(ctx, files, message)
+	if err != nil {
+		return fmt.Errorf("failed to push %q: %w", message, err)
+	}
+
+	if err := recordGitopsSHA(ctx, r.client, message, sha, ""); err != nil {
+		return fmt.Errorf("failed to record %q: %w", message, err)
+	}
+
+	return nil
+}
+
+func recordPromotion(promotion *internalharvester.Promotion) error {
+	viper.Set(promotionKey(promotion.VCluster), promotion.String())
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record the promotion of vcluster %q: %w", promotion.VCluster, err)
+	}
+
+	return nil
+}
+
+func writePromotionReport(reportPath string, promotion *internalharvester.Promotion, stepper step.Stepper) error {
+	markdownPath, err := internalharvester.WritePromotionReport(reportPath, promotion)
+	if err != nil {
+		return fmt.Errorf("failed to write the migration report: %w", err)
+	}
+
+	stepper.InfoStep(step.EmojiBook, fmt.Sprintf("Migration report written to %s", markdownPath))
+
+	return nil
+}
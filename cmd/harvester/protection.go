@@ -0,0 +1,87 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/viper"
+)
+
+// deployKeyIDKey is where the kubefirst config keeps the provider id of the
+// ArgoCD deploy key so destroy can remove it
+const deployKeyIDKey = "harvester.deploy-key-id"
+
+// protectGitopsRepo protects the default branch of the gitops repository
+// and moves ArgoCD from the user token onto a deploy key scoped to that
+// single repository, or onto an https credential with --git-protocol https.
+// The token that protected the branch keeps pushing to it directly
+func protectGitopsRepo(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, stepper step.Stepper) error {
+	gitopsRepo, err := clusterGitopsRepo(client, cliFlags)
+	if err != nil {
+		return err
+	}
+
+	if !cliFlags.NoBranchProtection {
+		if err := gitopsRepo.ProtectBranch(ctx, internalharvester.GitopsBranch); err != nil {
+			return err
+		}
+	}
+
+	if cliFlags.GitProtocol == "https" {
+		return applyArgoCDHTTPSCredential(ctx, client, gitopsRepo, cliFlags)
+	}
+
+	if err := removeDeployKey(ctx, gitopsRepo); err != nil {
+		return err
+	}
+
+	publicKey, privateKey, err := internalharvester.GenerateDeployKey()
+	if err != nil {
+		return err
+	}
+
+	keyID, err := gitopsRepo.AddDeployKey(ctx, fmt.Sprintf("kubefirst-argocd-%s", cliFlags.ClusterName), publicKey, cliFlags.ArgoCDWriteAccess)
+	if err != nil {
+		return err
+	}
+
+	viper.Set(deployKeyIDKey, keyID)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record deploy key in config: %w", err)
+	}
+
+	if err := client.ApplyArgoCDRepoSecret(ctx, gitopsRepo.SSHURL(), privateKey); err != nil {
+		return fmt.Errorf("failed to store deploy key for ArgoCD: %w", err)
+	}
+
+	return nil
+}
+
+// removeDeployKey deletes the ArgoCD deploy key recorded in the kubefirst
+// config from the git provider, if there is one
+func removeDeployKey(ctx context.Context, gitopsRepo *internalharvester.GitopsRepo) error {
+	keyID := viper.GetInt64(deployKeyIDKey)
+	if keyID == 0 {
+		return nil
+	}
+
+	if err := gitopsRepo.DeleteDeployKey(ctx, keyID); err != nil {
+		return err
+	}
+
+	viper.Set(deployKeyIDKey, 0)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to remove deploy key from config: %w", err)
+	}
+
+	return nil
+}
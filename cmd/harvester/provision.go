@@ -0,0 +1,333 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"github.com/konstructio/kubefirst/internal/cluster"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/provision"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/konstructio/kubefirst/internal/utilities"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// CliFlags and Stepper name the internal types Provision takes so code
+// outside this module can use it
+type (
+	CliFlags = types.CliFlags
+	Stepper  = step.Stepper
+)
+
+// ProvisionOptions configures Provision
+type ProvisionOptions struct {
+	// Stepper renders the provisioning steps, they are discarded when nil
+	Stepper Stepper
+
+	// Retry resumes the provision state an earlier attempt left from its
+	// pending step, as retrying from the --tui dashboard does
+	Retry bool
+
+	// onClient is handed the Harvester client once it is created
+	onClient func(*internalharvester.Client)
+}
+
+// ProvisionResult describes a successful Provision
+type ProvisionResult struct {
+	// CompletedPhases are the names of the steps that completed, in order
+	CompletedPhases []string
+	// VClusters are the vclusters created, none when --stop-after halted
+	// before the vcluster phase
+	VClusters []string
+	// Credentials tell where the platform credentials can be read from
+	Credentials []internalharvester.ReportCredential
+	// Report is the installation report, written to ReportPath, nil for a
+	// dry run
+	Report     *internalharvester.InstallationReport
+	ReportPath string
+	// Warnings are the warnings reported along the way
+	Warnings []string
+}
+
+// ParseFlags returns the flags of a create command, such as one built by
+// Create, and records them in the kubefirst config the provisioning steps
+// read. Provision expects its flags to come from here
+func ParseFlags(cmd *cobra.Command) (*CliFlags, error) {
+	cliFlags, err := utilities.GetFlags(cmd, "harvester")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get flags: %w", err)
+	}
+	if err := applyComponentVersions(cliFlags); err != nil {
+		return nil, err
+	}
+
+	return cliFlags, nil
+}
+
+// Provision validates cliFlags and provisions the kubefirst platform on the
+// Harvester cluster they point to, installing catalogApps, as the create
+// command does. The kubeconfig context has to be set in cliFlags
+func Provision(ctx context.Context, cliFlags *CliFlags, catalogApps []apiTypes.GitopsCatalogApp, opts ProvisionOptions) (*ProvisionResult, error) {
+	result := &ProvisionResult{}
+
+	var stepper step.Stepper = step.NewStepFactory(io.Discard)
+	if opts.Stepper != nil {
+		stepper = opts.Stepper
+	}
+	stepper = &recordingStepper{Stepper: stepper, result: result}
+
+	stepper.NewProgressStep("Run Pre-flight Checks")
+
+	if err := ValidateProvidedFlags(ctx, cliFlags); err != nil {
+		wrerr := fmt.Errorf("provided flags validation failed: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+
+	if cliFlags.ExportManifests != "" {
+		internalharvester.SetManifestExport(&internalharvester.ManifestExport{Dir: cliFlags.ExportManifests, IncludeSecrets: cliFlags.ExportIncludeSecrets})
+	}
+	internalharvester.SetDryRun(cliFlags.DryRun)
+
+	harvesterClient, err := internalharvester.NewClient(cliFlags.HarvesterKubeconfigPath, cliFlags.KubeconfigContext, cliFlags.Proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+	harvesterClient.Retry = internalharvester.NewAPIRetry(cliFlags.APIRetryMax, stepper)
+	harvesterClient.RegistryMirror = cliFlags.RegistryMirror
+	if harvesterClient.LargeFiles, err = largeFilePolicy(cliFlags, stepper); err != nil {
+		wrerr := fmt.Errorf("invalid large file limits: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+	harvesterClient.Manifests = internalharvester.ManifestValidation{
+		Validator: harvesterClient.ManifestValidator(),
+		OnSkip: func(message string) {
+			stepper.InfoStep(step.EmojiWarning, message)
+		},
+	}
+	if opts.onClient != nil {
+		opts.onClient(harvesterClient)
+	}
+
+	if err := pingCluster(ctx, harvesterClient, stepper); err != nil {
+		return nil, err
+	}
+
+	if err := configureGitHubApp(ctx, harvesterClient, cliFlags); err != nil {
+		wrerr := fmt.Errorf("failed to authenticate as github app %d: %w", cliFlags.GitHubAppID, err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+
+	if cliFlags.HA {
+		if err := harvesterClient.CheckHAHosts(ctx, cliFlags.HANodeCount); err != nil {
+			wrerr := fmt.Errorf("pre-flight check for --ha failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return nil, wrerr
+		}
+	}
+
+	if len(cliFlags.GPUNodes) > 0 {
+		if _, err := harvesterClient.ResolveGPUNodes(ctx, cliFlags.GPUNodes); err != nil {
+			wrerr := fmt.Errorf("pre-flight check for --gpu-nodes failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return nil, wrerr
+		}
+	}
+
+	if err := harvesterClient.VerifyStorageClasses(ctx, storageClasses(cliFlags)); err != nil {
+		wrerr := fmt.Errorf("pre-flight check for --storage-class failed: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+
+	if cliFlags.ClusterType == internalharvester.ClusterTypeWorkload {
+		if _, err := resolveManagementCluster(ctx, harvesterClient, cliFlags, stepper); err != nil {
+			wrerr := fmt.Errorf("pre-flight check for --mgmt-kubeconfig failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return nil, wrerr
+		}
+	}
+
+	if cliFlags.InstallIstio {
+		if err := pinIstioVersion(harvesterClient, cliFlags, stepper); err != nil {
+			wrerr := fmt.Errorf("pre-flight check for --istio-version failed: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return nil, wrerr
+		}
+	}
+
+	catalogApps, err = orderCatalogApps(catalogApps)
+	if err != nil {
+		wrerr := fmt.Errorf("pre-flight check for --install-catalog-apps failed: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+	catalogOrder := catalogAppNames(catalogApps)
+
+	clusterClient := cluster.Client{}
+	if err := clusterClient.Ping(ctx); err != nil {
+		wrerr := kubefirstAPIError("pre-flight check for kubefirst-api failed", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	versions, err := componentVersions(cliFlags)
+	if err != nil {
+		return nil, err
+	}
+	stepper.InfoStep(step.EmojiBulb, "Component versions: "+versions.String())
+
+	watcher := provision.NewProvisionWatcher(cliFlags.ClusterName, &clusterClient)
+	watcher.SetHealthChecker(newHealthTracker(harvesterClient, cliFlags, stepper))
+
+	// a dry run renders against the platform kubefirst-api already built
+	if cliFlags.DryRun {
+		stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Dry run: the manifests are written to %s, the cluster is only sent server-side dry runs and nothing is pushed", cliFlags.ExportManifests))
+	} else {
+		if err := checkExistingState(ctx, watcher, cliFlags, opts.Retry, stepper); err != nil {
+			return nil, err
+		}
+
+		if err := harvesterClient.RecordClusterName(ctx, cliFlags.ClusterName); err != nil {
+			return nil, fmt.Errorf("failed to record the cluster name on the Harvester cluster: %w", err)
+		}
+		if err := cacheProfileKubeconfig(cliFlags.HarvesterKubeconfigPath); err != nil {
+			return nil, fmt.Errorf("failed to cache the kubeconfig of profile %s: %w", internalharvester.ActiveProfile(), err)
+		}
+
+		if catalogApps, err = skipHealthyCatalogApps(ctx, harvesterClient, catalogApps, stepper); err != nil {
+			return nil, fmt.Errorf("failed to read the catalog app statuses: %w", err)
+		}
+	}
+
+	if err := runPreProvision(ctx, harvesterClient, cliFlags, stepper); err != nil {
+		return nil, fmt.Errorf("failed to prepare harvester management cluster: %w", err)
+	}
+
+	if !cliFlags.DryRun {
+		provisioner := provision.NewProvisioner(watcher, stepper)
+
+		if err := provisioner.ProvisionManagementCluster(ctx, cliFlags, catalogApps); err != nil {
+			reportCatalogApps(ctx, harvesterClient, catalogOrder, stepper)
+			return nil, kubefirstAPIError("failed to create harvester management cluster", err)
+		}
+	}
+
+	err = runPostProvision(ctx, harvesterClient, cliFlags, stepper)
+	if !cliFlags.DryRun {
+		reportCatalogApps(ctx, harvesterClient, catalogOrder, stepper)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to finalize harvester management cluster: %w", err)
+	}
+
+	if cliFlags.DryRun {
+		return result, nil
+	}
+
+	stepper.NewProgressStep("Write Installation Report")
+
+	report, markdownPath, err := writeInstallationReport(ctx, harvesterClient, cliFlags.ReportPath)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to write installation report: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return nil, wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	if internalharvester.PhaseEnabled(cliFlags.StopAfter, internalharvester.PhaseVCluster) {
+		result.VClusters = cliFlags.VClusters
+	}
+	result.Credentials = report.Credentials
+	result.Report = report
+	result.ReportPath = markdownPath
+
+	return result, nil
+}
+
+// recordingStepper records the steps completing and the warnings reported
+// through it in result
+type recordingStepper struct {
+	step.Stepper
+	result  *ProvisionResult
+	current string
+}
+
+func (s *recordingStepper) NewProgressStep(stepName string) {
+	// starting another step completes the current one
+	if s.current != stepName {
+		s.complete()
+		s.current = stepName
+	}
+	s.Stepper.NewProgressStep(stepName)
+}
+
+func (s *recordingStepper) CompleteCurrentStep() {
+	s.complete()
+	s.Stepper.CompleteCurrentStep()
+}
+
+func (s *recordingStepper) FailCurrentStep(err error) {
+	s.current = ""
+	s.Stepper.FailCurrentStep(err)
+}
+
+func (s *recordingStepper) InfoStep(emoji, message string) {
+	if emoji == step.EmojiWarning {
+		s.result.Warnings = append(s.result.Warnings, message)
+	}
+	s.Stepper.InfoStep(emoji, message)
+}
+
+func (s *recordingStepper) ReportProgress(done, total int) {
+	step.ReportProgress(s.Stepper, done, total)
+}
+
+func (s *recordingStepper) complete() {
+	if s.current != "" {
+		s.result.CompletedPhases = append(s.result.CompletedPhases, s.current)
+		s.current = ""
+	}
+}
+
+// pinIstioVersion checks --istio-version against the Kubernetes version of
+// the cluster and pins latest to the newest compatible release, which the
+// platform installs from the kubefirst config
+func pinIstioVersion(client *internalharvester.Client, cliFlags *CliFlags, stepper Stepper) error {
+	version, known, err := client.ResolveIstioVersion(cliFlags.IstioVersion)
+	if err != nil {
+		return err
+	}
+	if !known {
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Istio %s is not one of the releases kubefirst knows the supported Kubernetes versions of, it is installed unchecked: %s", version, internalharvester.IstioMatrix()))
+	}
+	if version == cliFlags.IstioVersion {
+		return nil
+	}
+
+	stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Istio version %q pinned to %s, the newest release supporting the Kubernetes version of the cluster", cliFlags.IstioVersion, version))
+	cliFlags.IstioVersion = version
+	viper.Set("flags.istio-version", version)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to pin istio version: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"slices"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// configureProxy overrides the proxy environment variables of the process
+// with --http-proxy, --https-proxy and --no-proxy, before any client is
+// built, so git, the Go clients and kubefirst-api agree on the proxy
+func configureProxy(cmd *cobra.Command) error {
+	settings := map[string]string{}
+	for _, flag := range []string{"http-proxy", "https-proxy", "no-proxy"} {
+		value, err := cmd.Flags().GetString(flag)
+		if err != nil {
+			return fmt.Errorf("failed to get %s flag: %w", flag, err)
+		}
+		settings[flag] = value
+	}
+
+	return internalharvester.SetProxyEnv(settings)
+}
+
+// logProxyRoutes prints the proxy every external endpoint of create is
+// reached through, warning about LAN endpoints that are not exempted
+func logProxyRoutes(cliFlags *types.CliFlags) error {
+	routes, warnings, err := internalharvester.ProxyRoutes(cliFlags.Proxy, proxyEndpoints(cliFlags))
+	if err != nil {
+		return fmt.Errorf("invalid proxy settings: %w", err)
+	}
+
+	for _, route := range routes {
+		log.Info().Msgf("proxy: %s", route)
+	}
+	for _, warning := range warnings {
+		log.Warn().Msg(warning)
+	}
+
+	return nil
+}
+
+// proxyEndpoints lists the endpoints create calls with the flags set
+func proxyEndpoints(cliFlags *types.CliFlags) []internalharvester.ProxyEndpoint {
+	var endpoints []internalharvester.ProxyEndpoint
+	if server, err := internalharvester.KubeconfigServer(cliFlags.HarvesterKubeconfigPath, cliFlags.KubeconfigContext); err == nil {
+		endpoints = append(endpoints, internalharvester.ProxyEndpoint{Name: "Harvester API server", URL: server, Local: true})
+	}
+	if cliFlags.UniFiHost != "" && cliFlags.IngressMode == internalharvester.IngressModeUniFi {
+		endpoints = append(endpoints, internalharvester.ProxyEndpoint{Name: "UniFi controller", URL: internalharvester.UniFiURL(cliFlags.UniFiHost), Local: true})
+	}
+
+	switch cliFlags.GitProvider {
+	case "github":
+		endpoints = append(endpoints, internalharvester.ProxyEndpoint{Name: "GitHub API", URL: internalharvester.GitHubAPIURL})
+	case "gitlab":
+		endpoints = append(endpoints, internalharvester.ProxyEndpoint{Name: "GitLab API", URL: internalharvester.GitLabAPIURL})
+	}
+	endpoints = append(endpoints, internalharvester.ProxyEndpoint{Name: "gitops template", URL: cliFlags.GitopsTemplateURL})
+	if slices.Contains(cliFlags.DNSProviders, internalharvester.DNSProviderCloudflare) {
+		endpoints = append(endpoints, internalharvester.ProxyEndpoint{Name: "Cloudflare API", URL: internalharvester.CloudflareAPIURL})
+	}
+	if slices.Contains(cliFlags.DNSProviders, internalharvester.DNSProviderRoute53) {
+		endpoints = append(endpoints, internalharvester.ProxyEndpoint{Name: "Route 53 API", URL: internalharvester.Route53APIURL})
+	}
+
+	optional := []internalharvester.ProxyEndpoint{
+		{Name: "OIDC issuer", URL: cliFlags.OIDCIssuerURL},
+		{Name: "DNS-over-HTTPS resolver", URL: cliFlags.DNSCheckDoH},
+		{Name: "Slack webhook", URL: cliFlags.SlackWebhook},
+		{Name: "Teams webhook", URL: cliFlags.TeamsWebhook},
+		{Name: "notification webhook", URL: cliFlags.NotifyWebhook},
+		{Name: "lifecycle webhook", URL: cliFlags.NotifyWebhookURL},
+	}
+	if cliFlags.InstallKubefirstPro {
+		optional = append(optional, internalharvester.ProxyEndpoint{Name: "kubefirst pro charts", URL: cliFlags.KubefirstProChartURL})
+	}
+	for _, endpoint := range optional {
+		if endpoint.URL != "" {
+			endpoints = append(endpoints, endpoint)
+		}
+	}
+
+	return endpoints
+}
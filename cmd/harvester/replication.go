@@ -0,0 +1,371 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// replicationKey is where the kubefirst config tracks the warm-standby
+// management cluster and which site is active
+const replicationKey = "harvester.replication"
+
+func runReplicate(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	targetKubeconfig, err := cmd.Flags().GetString("target-kubeconfig")
+	if err != nil {
+		return fmt.Errorf("failed to get target-kubeconfig flag: %w", err)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	schedule, err := cmd.Flags().GetString("schedule")
+	if err != nil {
+		return fmt.Errorf("failed to get schedule flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Connect to Standby Cluster")
+
+	state, err := loadReplicationState()
+	if err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+	if state != nil && state.ActiveSite == internalharvester.SiteStandby {
+		wrerr := fmt.Errorf("the standby %s took over at %s and is the active site, replication would overwrite it with the old primary", state.StandbyKubeconfig, state.FailedOverAt.Format(time.RFC3339))
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	primary, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	standby, err := internalharvester.NewClient(targetKubeconfig, "", proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create standby harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	primary.Retry = internalharvester.NewAPIRetry(recordedAPIRetryMax(), stepper)
+	standby.RegistryMirror = viper.GetString("flags.registry-mirror")
+
+	stepper.CompleteCurrentStep()
+
+	stepper.NewProgressStep("Provision Standby From GitOps")
+
+	if err := provisionStandby(ctx, primary, standby); err != nil {
+		wrerr := fmt.Errorf("failed to provision standby: %w", err)
+		if errors.Is(err, internalharvester.ErrArgoCDUnreachable) {
+			wrerr = fmt.Errorf("unable to reach ArgoCD on the standby cluster, ArgoCD has to be installed there before it can follow the gitops repository: %w", err)
+		}
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	domainName := viper.GetString("flags.domain-name")
+	vault := internalharvester.VaultEnabled(viper.GetString("flags.stop-after"))
+
+	if vault {
+		stepper.NewProgressStep("Configure Vault Replication")
+
+		primaryUnseal, err := primary.VaultUnsealSecret(ctx)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to read primary vault credentials: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		if err := standby.ApplyVaultReplication(ctx, fmt.Sprintf("https://vault.%s", domainName), schedule, primaryUnseal); err != nil {
+			wrerr := fmt.Errorf("failed to configure vault replication: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	stepper.NewProgressStep("Record DNS Records")
+
+	records, err := replicatedRecords(ctx, primary, domainName, vault)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to record the dns records of the primary: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	state = &internalharvester.ReplicationState{
+		StandbyKubeconfig: targetKubeconfig,
+		ActiveSite:        internalharvester.SitePrimary,
+		Schedule:          schedule,
+		Records:           records,
+		ConfiguredAt:      time.Now().UTC(),
+	}
+	if err := saveReplicationState(state); err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Standby %s follows the gitops repository, DNS stays on the primary until harvester failover", targetKubeconfig))
+
+	return nil
+}
+
+// provisionStandby points the ArgoCD of standby at the registry the primary
+// deploys, with the primary's repository credentials
+func provisionStandby(ctx context.Context, primary, standby *internalharvester.Client) error {
+	registryPath := internalharvester.RegistryPath(viper.GetString("flags.gitops-registry-path"), viper.GetString("flags.cluster-name"))
+
+	root, err := primary.RootApplication(ctx, registryPath)
+	if err != nil {
+		return err
+	}
+
+	if err := standby.CopyArgoCDRepositories(ctx, primary); err != nil {
+		return err
+	}
+
+	return standby.ApplyRootApplication(ctx, root)
+}
+
+// replicatedRecords maps every platform DNS record onto the LoadBalancer
+// service of the primary it points at, the service failover points it at
+// on the standby. Every dns provider holds the same addresses, the records
+// are read from the first
+func replicatedRecords(ctx context.Context, primary *internalharvester.Client, domainName string, vault bool) ([]internalharvester.ReplicatedRecord, error) {
+	providers, err := recordedDNSProviders(ctx, primary)
+	if err != nil {
+		return nil, err
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("no dns provider is recorded in the kubefirst config, the platform records cannot be failed over")
+	}
+	dns := providers[0].dns
+
+	var vclusters []string
+	if internalharvester.PhaseEnabled(viper.GetString("flags.stop-after"), internalharvester.PhaseVCluster) {
+		vclusters = viper.GetStringSlice("flags.vclusters")
+	}
+	hosts := internalharvester.PlatformHosts(domainName, vault, vclusters, viper.GetStringMapString("flags.vcluster-domain-map"), viper.GetStringSlice("flags.extra-domains"), viper.GetBool("flags.vcluster-ingress-wildcard"))
+
+	records := make([]internalharvester.ReplicatedRecord, 0, len(hosts))
+	for _, host := range hosts {
+		record, err := dns.ARecord(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		namespace, service, err := primary.LoadBalancerServiceFor(ctx, record.Content)
+		if err != nil {
+			return nil, fmt.Errorf("%q points at %s: %w", host, record.Content, err)
+		}
+
+		records = append(records, internalharvester.ReplicatedRecord{Host: host, Namespace: namespace, Service: service})
+	}
+
+	return records, nil
+}
+
+func runFailover(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Connect to Standby Cluster")
+
+	state, err := loadReplicationState()
+	if err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+	if state == nil {
+		wrerr := errors.New("no standby is recorded in the kubefirst config, run harvester replicate first")
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	if state.ActiveSite == internalharvester.SiteStandby {
+		wrerr := fmt.Errorf("the standby %s is already the active site since %s", state.StandbyKubeconfig, state.FailedOverAt.Format(time.RFC3339))
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	standby, err := internalharvester.NewClient(state.StandbyKubeconfig, "", proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create standby harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+	standby.Retry = internalharvester.NewAPIRetry(recordedAPIRetryMax(), stepper)
+
+	stepper.CompleteCurrentStep()
+
+	if internalharvester.VaultEnabled(viper.GetString("flags.stop-after")) {
+		stepper.NewProgressStep("Promote Standby Vault")
+
+		if err := standby.PromoteVault(ctx); err != nil {
+			wrerr := fmt.Errorf("failed to promote standby vault: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	stepper.NewProgressStep("Point DNS Records at Standby")
+
+	if err := flipRecords(ctx, standby, state.Records); err != nil {
+		wrerr := fmt.Errorf("failed to point dns records at the standby: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	state.ActiveSite = internalharvester.SiteStandby
+	state.FailedOverAt = time.Now().UTC()
+	if err := saveReplicationState(state); err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Standby %s is now the active site", state.StandbyKubeconfig))
+
+	return nil
+}
+
+// flipRecords points every replicated record at the address the standby
+// allocated to its LoadBalancer service, at every dns provider
+func flipRecords(ctx context.Context, standby *internalharvester.Client, records []internalharvester.ReplicatedRecord) error {
+	providers, err := recordedDNSProviders(ctx, standby)
+	if err != nil {
+		return err
+	}
+
+	for _, replicated := range records {
+		address, err := standby.LoadBalancerAddress(ctx, replicated.Namespace, replicated.Service)
+		if err != nil {
+			return err
+		}
+
+		for _, provider := range providers {
+			record, err := provider.dns.ARecord(ctx, replicated.Host)
+			if err != nil {
+				return fmt.Errorf("%s: %w", provider.name, err)
+			}
+
+			if record.Content == address {
+				continue
+			}
+
+			if err := provider.dns.UpdateARecord(ctx, record, address); err != nil {
+				return fmt.Errorf("%s: %w", provider.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// replicationStatus describes the replication state for harvester status,
+// or returns an empty string when there is no standby
+func replicationStatus(ctx context.Context, proxy string) (string, error) {
+	state, err := loadReplicationState()
+	if err != nil || state == nil {
+		return "", err
+	}
+
+	var lastReplication string
+	switch {
+	case state.ActiveSite == internalharvester.SiteStandby:
+		lastReplication = fmt.Sprintf("stopped by the failover at %s", state.FailedOverAt.Format(time.RFC3339))
+	case !internalharvester.VaultEnabled(viper.GetString("flags.stop-after")):
+		lastReplication = "vault is not installed"
+	default:
+		standby, err := internalharvester.NewClient(state.StandbyKubeconfig, "", proxy)
+		if err != nil {
+			return "", fmt.Errorf("failed to create standby harvester client: %w", err)
+		}
+
+		last, err := standby.LastVaultReplication(ctx)
+		switch {
+		case err != nil:
+			lastReplication = fmt.Sprintf("unknown: %v", err)
+		case last.IsZero():
+			lastReplication = "never"
+		default:
+			lastReplication = last.Format(time.RFC3339)
+		}
+	}
+
+	return fmt.Sprintf("Active site: %s, standby %s, last vault replication: %s", state.ActiveSite, state.StandbyKubeconfig, lastReplication), nil
+}
+
+func loadReplicationState() (*internalharvester.ReplicationState, error) {
+	encoded := viper.GetString(replicationKey)
+	if encoded == "" {
+		return nil, nil
+	}
+
+	var state internalharvester.ReplicationState
+	if err := json.Unmarshal([]byte(encoded), &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s in the kubefirst config: %w", replicationKey, err)
+	}
+
+	return &state, nil
+}
+
+func saveReplicationState(state *internalharvester.ReplicationState) error {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode replication state: %w", err)
+	}
+
+	viper.Set(replicationKey, string(encoded))
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to write replication state to config: %w", err)
+	}
+
+	return nil
+}
+
+// recordedAPIRetryMax returns the --api-retry-max the cluster was created
+// with, clusters created before the flag existed get the default
+func recordedAPIRetryMax() int {
+	if !viper.IsSet("flags.api-retry-max") {
+		return internalharvester.DefaultAPIRetryMax
+	}
+
+	return viper.GetInt("flags.api-retry-max")
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/spf13/viper"
+)
+
+// writeInstallationReport builds the installation report of the cluster in
+// the kubefirst config from the live cluster and writes it to reportPath,
+// or the default report directory. It returns the report along with the
+// path of its Markdown file
+func writeInstallationReport(ctx context.Context, client *internalharvester.Client, reportPath string) (*internalharvester.InstallationReport, string, error) {
+	gitopsRepo, err := recordedGitopsRepo(client)
+	if err != nil {
+		return nil, "", err
+	}
+
+	resolver, err := internalharvester.NewHostResolver(viper.GetString("flags.dns-check-doh"), viper.GetString("flags.proxy"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	clusterName := viper.GetString("flags.cluster-name")
+	stopAfter := viper.GetString("flags.stop-after")
+
+	opts := internalharvester.ReportOptions{
+		ClusterName:             clusterName,
+		DomainName:              viper.GetString("flags.domain-name"),
+		GitopsRepoURL:           gitopsRepo.URL,
+		KubeconfigPath:          viper.GetString("flags.kubeconfig-path"),
+		VClusterDomainMap:       viper.GetStringMapString("flags.vcluster-domain-map"),
+		ExtraDomains:            viper.GetStringSlice("flags.extra-domains"),
+		VClusterIngressWildcard: viper.GetBool("flags.vcluster-ingress-wildcard"),
+		Vault:                   internalharvester.VaultEnabled(stopAfter),
+		VaultAutoUnseal:         viper.GetString("flags.vault-auto-unseal"),
+	}
+	if internalharvester.PhaseEnabled(stopAfter, internalharvester.PhaseVCluster) {
+		opts.VClusters = viper.GetStringSlice("flags.vclusters")
+	}
+	if viper.GetBool("flags.install-istio") {
+		opts.IstioVersion = viper.GetString("flags.istio-version")
+	}
+
+	if reportPath == "" {
+		reportPath, err = internalharvester.DefaultReportDir(clusterName)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	report, err := client.BuildInstallationReport(ctx, resolver, opts)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to build installation report: %w", err)
+	}
+
+	markdownPath, err := internalharvester.WriteInstallationReport(reportPath, report)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return report, markdownPath, nil
+}
+
+// printInstallationReport prints where the report was written followed by
+// its endpoints
+func printInstallationReport(out io.Writer, report *internalharvester.InstallationReport, markdownPath string) {
+	fmt.Fprintf(out, "Installation report written to %s\n\n%s", markdownPath, report.Summary())
+}
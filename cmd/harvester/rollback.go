@@ -0,0 +1,348 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("to-phase") {
+		return runPhaseRollback(cmd, args)
+	}
+
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	to, err := cmd.Flags().GetString("to")
+	if err != nil {
+		return fmt.Errorf("failed to get to flag: %w", err)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get timeout flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Resolve Rollback Target")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	history, err := client.LoadGitopsHistory(ctx)
+	if err == nil && len(history) == 0 {
+		history, err = localGitopsHistory()
+	}
+	if err != nil {
+		wrerr := fmt.Errorf("failed to load gitops history: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	target, err := history.RollbackTarget(to)
+	if err != nil {
+		wrerr := fmt.Errorf("cannot roll back to %q: %w", to, err)
+		if errors.Is(err, internalharvester.ErrIrreversible) {
+			wrerr = fmt.Errorf("cannot roll back to %q, the cluster state it describes no longer exists and reverting the gitops repository would not restore it: %w", to, err)
+		}
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Rolling back to %s recorded by %q at %s", target.SHA, target.Operation, target.Time.Format("2006-01-02 15:04:05")))
+
+	stepper.NewProgressStep("Roll Back GitOps Repository")
+
+	gitopsRepo, err := recordedGitopsRepo(client)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to resolve gitops repository: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	message := fmt.Sprintf("rollback to %s (%s)", target.SHA, target.Operation)
+	sha, err := gitopsRepo.RevertTo(ctx, target.SHA, message)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to roll back gitops repository: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := recordGitopsSHA(ctx, client, message, sha, ""); err != nil {
+		wrerr := fmt.Errorf("failed to record rollback commit: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	stepper.NewProgressStep("Sync ArgoCD Applications")
+
+	apps, err := client.ListApplications(ctx, "*")
+	if err != nil {
+		wrerr := fmt.Errorf("failed to find applications to sync: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	names := make([]string, 0, len(apps))
+	for _, app := range apps {
+		if err := client.SyncApplication(ctx, app.Name); err != nil {
+			wrerr := fmt.Errorf("failed to sync application %q: %w", app.Name, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		names = append(names, app.Name)
+	}
+
+	stepper.CompleteCurrentStep()
+
+	stepper.NewProgressStep("Wait for Applications Healthy/Synced")
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := client.WaitForApplications(waitCtx, names); err != nil {
+		wrerr := fmt.Errorf("applications did not converge after rollback: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
+
+// runPhaseRollback removes what the phases provisioned after --to-phase
+// applied, latest phase first, and records them as torn down for create
+// --resume-from to provision again. The removal is printed and only run
+// with --confirm; a cluster already at the phase is left alone
+func runPhaseRollback(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	toPhase, err := cmd.Flags().GetString("to-phase")
+	if err != nil {
+		return fmt.Errorf("failed to get to-phase flag: %w", err)
+	}
+
+	confirm, err := cmd.Flags().GetBool("confirm")
+	if err != nil {
+		return fmt.Errorf("failed to get confirm flag: %w", err)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	finalizerTimeout, err := cmd.Flags().GetDuration("finalizer-timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get finalizer-timeout flag: %w", err)
+	}
+
+	clusterName := viper.GetString("flags.cluster-name")
+	if clusterName == "" {
+		return errors.New("no cluster recorded in the kubefirst config, run harvester create first")
+	}
+
+	state, err := internalharvester.ParseTeardownState(viper.GetString(teardownStateKey))
+	if err != nil {
+		return fmt.Errorf("failed to read the teardown state in the kubefirst config: %w", err)
+	}
+
+	stopAfter := viper.GetString("flags.stop-after")
+	phases, err := internalharvester.RollbackPhases(toPhase, stopAfter, state.Phases)
+	if err != nil {
+		return fmt.Errorf("invalid --to-phase: %w", err)
+	}
+
+	stepper.NewProgressStep("Plan Rollback")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	targets := internalharvester.RollbackTargets(viper.GetStringSlice("flags.vclusters"), viper.GetBool("flags.external-secrets"), viper.GetBool("flags.vcluster-appset"))
+	var teardowns []phaseTeardown
+	for _, phase := range phases {
+		scope, err := client.PhaseTeardownScope(ctx, phase, targets)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to list the resources of phase %s: %w", phase, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		// a phase that failed before applying anything has nothing to remove
+		if len(scope.Applications) > 0 || len(scope.Namespaces) > 0 {
+			teardowns = append(teardowns, phaseTeardown{phase: phase, scope: scope})
+		}
+	}
+
+	records, err := rollbackDNSRecords(ctx, client, clusterName, stopAfter, toPhase)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to list the dns records to remove: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	lbPools, err := recordedLBPools()
+	if err != nil {
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	stepper.CompleteCurrentStep()
+
+	if len(teardowns) == 0 && countRecords(records) == 0 {
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Cluster %q is already at phase %s, nothing to roll back", clusterName, toPhase))
+		return nil
+	}
+
+	printRollbackPlan(cmd.OutOrStdout(), clusterName, toPhase, teardowns, records)
+	if !confirm {
+		stepper.InfoStep(step.EmojiBulb, "Rerun with --confirm to roll back")
+		return nil
+	}
+
+	watchdog := internalharvester.NewFinalizerWatchdog(finalizerTimeout, false, func(message string) {
+		stepper.InfoStep(step.EmojiWarning, message)
+	})
+
+	// the records stop pointing at the ingress layer before it is removed
+	removeRecords := func() error {
+		if countRecords(records) == 0 {
+			return nil
+		}
+
+		stepper.NewProgressStep("Remove DNS Records")
+
+		if _, err := deleteDNSRecords(ctx, records); err != nil {
+			stepper.FailCurrentStep(err)
+			return err
+		}
+		records = nil
+
+		stepper.CompleteCurrentStep()
+
+		return nil
+	}
+
+	for _, teardown := range teardowns {
+		if teardown.phase == internalharvester.PhaseIngress {
+			if err := removeRecords(); err != nil {
+				return err
+			}
+		}
+		if err := teardown.run(ctx, client, lbPools, watchdog, stepper); err != nil {
+			return err
+		}
+
+		state.Record(teardown.phase, teardown.paused)
+		viper.Set(teardownStateKey, state.String())
+		if err := viper.WriteConfig(); err != nil {
+			return fmt.Errorf("failed to record the rollback of phase %s: %w", teardown.phase, err)
+		}
+	}
+	if err := removeRecords(); err != nil {
+		return err
+	}
+
+	// phases with nothing to remove are recorded too, a rerun is a no-op
+	for _, phase := range phases {
+		state.Record(phase, nil)
+	}
+	viper.Set(teardownStateKey, state.String())
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record the rollback to phase %s: %w", toPhase, err)
+	}
+
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Rolled back cluster %q to phase %s", clusterName, toPhase))
+	stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("Provision the later phases again with: kubefirst harvester create --resume-from %s", resumeStep(state.Phases)))
+
+	return nil
+}
+
+// rollbackDNSRecords returns the records create manages for the hosts the
+// phases after toPhase serve, at every dns provider create published them
+// to. At argocd no ingress serves any host
+func rollbackDNSRecords(ctx context.Context, client *internalharvester.Client, clusterName, stopAfter, toPhase string) ([]dnsProviderRecords, error) {
+	var kept []string
+	if internalharvester.PhaseEnabled(toPhase, internalharvester.PhaseIngress) {
+		kept = recordedPlatformHosts(toPhase)
+	}
+	removed := slices.DeleteFunc(recordedPlatformHosts(stopAfter), func(host string) bool {
+		return slices.Contains(kept, host)
+	})
+	if len(removed) == 0 {
+		return nil, nil
+	}
+
+	providers, err := recordedDNSProviders(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return managedDNSRecords(ctx, providers, removed, internalharvester.ManagedRecordComment(clusterName))
+}
+
+// printRollbackPlan lists what rolling back to toPhase removes, in the
+// order it is removed
+func printRollbackPlan(out io.Writer, clusterName, toPhase string, teardowns []phaseTeardown, records []dnsProviderRecords) {
+	var names []string
+	for _, provider := range records {
+		for _, record := range provider.records {
+			names = append(names, fmt.Sprintf("%s (%s)", record.Name, provider.name))
+		}
+	}
+
+	fmt.Fprintf(out, "rolling back cluster %q to phase %s removes, latest phase first:\n", clusterName, toPhase)
+	for _, teardown := range teardowns {
+		if teardown.phase == internalharvester.PhaseIngress && len(names) > 0 {
+			fmt.Fprintf(out, "  - dns records %s\n", strings.Join(names, ", "))
+			names = nil
+		}
+		for _, resource := range destroyResources([]phaseTeardown{teardown}, nil, false) {
+			fmt.Fprintf(out, "  - %s\n", resource)
+		}
+	}
+	if len(names) > 0 {
+		fmt.Fprintf(out, "  - dns records %s\n", strings.Join(names, ", "))
+	}
+}
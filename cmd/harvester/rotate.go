@@ -0,0 +1,490 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// credentialRotation rotates one credential. old are the values in-cluster
+// secrets and gitops files embed, found without changing anything. rotate
+// changes the credential at its source and returns what replaces the old
+// values, verify checks the component depending on it still works and
+// finish removes what the old credential left behind once it does
+type credentialRotation struct {
+	target string
+	action string
+	old    []string
+	rotate func(ctx context.Context) ([]internalharvester.CredentialReplacement, error)
+	verify func(ctx context.Context) error
+	finish func(ctx context.Context) error
+}
+
+func runRotateCredentials(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	if viper.GetString("flags.cluster-name") == "" {
+		return errors.New("no cluster recorded in the kubefirst config, run harvester create first")
+	}
+
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return fmt.Errorf("failed to get all flag: %w", err)
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return fmt.Errorf("failed to get dry-run flag: %w", err)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	perChange, err := commitPerChange(cmd)
+	if err != nil {
+		return err
+	}
+
+	targets, err := internalharvester.ResolveCredentialTargets(args, all)
+	if err != nil {
+		return err
+	}
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		return fmt.Errorf("failed to create harvester client: %w", err)
+	}
+
+	gitopsRepo, err := recordedGitopsRepo(client)
+	if err != nil {
+		return fmt.Errorf("failed to resolve gitops repository: %w", err)
+	}
+	commits := newGitopsCommits(client, gitopsRepo, perChange)
+
+	rotations := make([]*credentialRotation, 0, len(targets))
+	for _, target := range targets {
+		rotation, err := prepareRotation(ctx, client, gitopsRepo, target, stepper)
+		if err != nil {
+			return fmt.Errorf("cannot rotate %s: %w", target, err)
+		}
+		rotations = append(rotations, rotation)
+	}
+
+	if dryRun {
+		files, err := gitopsRepo.ReadYAMLFiles(ctx, "/")
+		if err != nil {
+			return fmt.Errorf("failed to read gitops repository: %w", err)
+		}
+
+		planned := make([]internalharvester.CredentialRotation, 0, len(rotations))
+		for _, rotation := range rotations {
+			touched, err := rotationTouches(ctx, client, rotation)
+			if err != nil {
+				return err
+			}
+			touched.Files = internalharvester.FilesEmbedding(files, rotation.old)
+			planned = append(planned, touched)
+		}
+
+		return internalharvester.WriteRotationTable(cmd.OutOrStdout(), planned)
+	}
+
+	for _, rotation := range rotations {
+		stepper.NewProgressStep(fmt.Sprintf("Rotate %s Credential", rotation.target))
+
+		if err := rotateCredential(ctx, client, commits, rotation, stepper); err != nil {
+			wrerr := fmt.Errorf("failed to rotate %s: %w", rotation.target, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+
+		stepper.CompleteCurrentStep()
+	}
+
+	return nil
+}
+
+// rotationTouches returns the secrets embedding the old values of rotation
+// and the workloads reading them
+func rotationTouches(ctx context.Context, client *internalharvester.Client, rotation *credentialRotation) (internalharvester.CredentialRotation, error) {
+	touched := internalharvester.CredentialRotation{Target: rotation.target, Action: rotation.action}
+
+	secrets, err := client.FindSecrets(ctx, rotation.old)
+	if err != nil {
+		return touched, err
+	}
+	touched.Secrets = secrets
+
+	if touched.Workloads, err = client.WorkloadsUsingSecrets(ctx, secrets); err != nil {
+		return touched, err
+	}
+
+	return touched, nil
+}
+
+// rotateCredential changes the credential at its source, swaps it into the
+// secrets and gitops files embedding it, restarts the workloads reading
+// those secrets and verifies the dependent component
+func rotateCredential(ctx context.Context, client *internalharvester.Client, commits *gitopsCommits, rotation *credentialRotation, stepper step.Stepper) error {
+	touched, err := rotationTouches(ctx, client, rotation)
+	if err != nil {
+		return err
+	}
+
+	replacements, err := rotation.rotate(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range touched.Secrets {
+		if secret.ExternalSecret != "" {
+			stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Secret %s is written by ExternalSecret %s, update the credential at its source", secret, secret.ExternalSecret))
+		}
+	}
+	if err := client.ReplaceInSecrets(ctx, touched.Secrets, replacements); err != nil {
+		return err
+	}
+
+	// read after the commits of the previous targets, not to revert them
+	files, err := commits.repo.ReadYAMLFiles(ctx, "/")
+	if err != nil {
+		return fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+	if changed := internalharvester.ReplaceInFiles(files, replacements); len(changed) > 0 {
+		if err := commits.add(ctx, changed, fmt.Sprintf("rotate the %s credential", rotation.target)); err != nil {
+			return err
+		}
+	}
+	if err := commits.flush(ctx); err != nil {
+		return fmt.Errorf("failed to push gitops changes: %w", err)
+	}
+
+	if err := client.RestartWorkloads(ctx, touched.Workloads); err != nil {
+		return err
+	}
+
+	verifyCtx, cancel := context.WithTimeout(ctx, internalharvester.DefaultCredentialVerifyTimeout)
+	defer cancel()
+
+	if err := client.WaitForWorkloads(verifyCtx, touched.Workloads); err != nil {
+		return err
+	}
+	if err := rotation.verify(verifyCtx); err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+
+	if rotation.finish == nil {
+		return nil
+	}
+
+	return rotation.finish(ctx)
+}
+
+// prepareRotation returns the rotation of target, failing before anything
+// changes when the credential cannot be rotated
+func prepareRotation(ctx context.Context, client *internalharvester.Client, gitopsRepo *internalharvester.GitopsRepo, target string, stepper step.Stepper) (*credentialRotation, error) {
+	switch target {
+	case "git":
+		return gitRotation(client, gitopsRepo, stepper)
+	case "cloudflare":
+		return cloudflareRotation(client, stepper)
+	case "unifi":
+		return uniFiRotation(client)
+	case "argocd-admin":
+		return argoCDAdminRotation(ctx, client)
+	case "kbot-ssh":
+		return kbotRotation(ctx, client, gitopsRepo)
+	default:
+		return nil, fmt.Errorf("unknown credential %q", target)
+	}
+}
+
+// replaceCredentialEnv points env at value for the rest of the run and
+// tells the user to do the same for the next ones
+func replaceCredentialEnv(env, value string, stepper step.Stepper) error {
+	if err := os.Setenv(env, value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", env, err)
+	}
+	stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Set %s to the new credential for the next runs and revoke the previous one if it still works", env))
+
+	return nil
+}
+
+// gitRotation rotates the git token through the provider API, which only
+// GitLab has, or replaces it with the one of NEW_<TOKEN ENV>
+func gitRotation(client *internalharvester.Client, gitopsRepo *internalharvester.GitopsRepo, stepper step.Stepper) (*credentialRotation, error) {
+	gitProvider := viper.GetString("flags.git-provider")
+	env := internalharvester.GitTokenEnv(gitProvider)
+	newEnv := internalharvester.NewCredentialEnvPrefix + env
+	old := gitopsRepo.Auth.Password
+	replacement := os.Getenv(newEnv)
+
+	action := fmt.Sprintf("replace %s with %s", env, newEnv)
+	switch {
+	case replacement == old:
+		return nil, fmt.Errorf("%s holds the current token", newEnv)
+	case replacement == "" && gitProvider != "gitlab":
+		return nil, fmt.Errorf("%s tokens cannot be rotated through the API, create a new one and set %s", gitProvider, newEnv)
+	case replacement == "":
+		action = "rotate the gitlab token"
+	}
+
+	return &credentialRotation{
+		target: "git",
+		action: action,
+		old:    []string{old},
+		rotate: func(ctx context.Context) ([]internalharvester.CredentialReplacement, error) {
+			if replacement == "" {
+				rotated, err := gitopsRepo.RotateToken(ctx)
+				if err != nil {
+					return nil, err
+				}
+				replacement = rotated
+				stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("New %s: %s", env, rotated))
+			}
+			gitopsRepo.Auth.Password = replacement
+
+			return []internalharvester.CredentialReplacement{{Old: old, New: replacement}}, replaceCredentialEnv(env, replacement, stepper)
+		},
+		verify: func(ctx context.Context) error {
+			if _, err := gitopsRepo.Head(ctx); err != nil {
+				return fmt.Errorf("the new token cannot read the gitops repository: %w", err)
+			}
+			return verifyRootApplicationFetch(ctx, client)
+		},
+	}, nil
+}
+
+// cloudflareRotation rolls CF_API_TOKEN at Cloudflare, or replaces it with
+// NEW_CF_API_TOKEN
+func cloudflareRotation(client *internalharvester.Client, stepper step.Stepper) (*credentialRotation, error) {
+	const env = "CF_API_TOKEN"
+	newEnv := internalharvester.NewCredentialEnvPrefix + env
+	old := os.Getenv(env)
+	if old == "" {
+		return nil, fmt.Errorf("your %s environment variable is not set, it holds the token to rotate", env)
+	}
+	replacement := os.Getenv(newEnv)
+	if replacement == old {
+		return nil, fmt.Errorf("%s holds the current token", newEnv)
+	}
+
+	action := fmt.Sprintf("replace %s with %s", env, newEnv)
+	if replacement == "" {
+		action = "roll the cloudflare token"
+	}
+
+	return &credentialRotation{
+		target: "cloudflare",
+		action: action,
+		old:    []string{old},
+		rotate: func(ctx context.Context) ([]internalharvester.CredentialReplacement, error) {
+			if replacement == "" {
+				dns, err := internalharvester.NewCloudflareDNS(old, client.HTTPClient)
+				if err != nil {
+					return nil, err
+				}
+				if replacement, err = dns.RollToken(ctx); err != nil {
+					return nil, err
+				}
+				stepper.InfoStep(step.EmojiBulb, fmt.Sprintf("New %s: %s", env, replacement))
+			}
+
+			return []internalharvester.CredentialReplacement{{Old: old, New: replacement}}, replaceCredentialEnv(env, replacement, stepper)
+		},
+		verify: func(ctx context.Context) error {
+			dns, err := internalharvester.NewCloudflareDNS(replacement, client.HTTPClient)
+			if err != nil {
+				return err
+			}
+			zones, err := dns.Zones(ctx)
+			if err != nil {
+				return err
+			}
+			if len(zones) == 0 {
+				return errors.New("the new cloudflare token lists no zone, external-dns would manage no record")
+			}
+			return nil
+		},
+	}, nil
+}
+
+// uniFiRotation replaces the UniFi password with NEW_UNIFI_PASSWORD. UniFi
+// controllers change passwords in their UI only
+func uniFiRotation(client *internalharvester.Client) (*credentialRotation, error) {
+	const newEnv = internalharvester.NewCredentialEnvPrefix + "UNIFI_PASSWORD"
+	host, user, old := viper.GetString("flags.unifi-host"), viper.GetString("flags.unifi-user"), viper.GetString("flags.unifi-password")
+	if old == "" {
+		return nil, errors.New("no unifi password recorded in the kubefirst config")
+	}
+	replacement := os.Getenv(newEnv)
+	switch replacement {
+	case "":
+		return nil, fmt.Errorf("change the password in the UniFi controller, then set %s to it", newEnv)
+	case old:
+		return nil, fmt.Errorf("%s holds the current password", newEnv)
+	}
+
+	return &credentialRotation{
+		target: "unifi",
+		action: fmt.Sprintf("replace the unifi password with %s", newEnv),
+		old:    []string{old},
+		rotate: func(context.Context) ([]internalharvester.CredentialReplacement, error) {
+			viper.Set("flags.unifi-password", replacement)
+			if err := viper.WriteConfig(); err != nil {
+				return nil, fmt.Errorf("failed to record unifi password in config: %w", err)
+			}
+			return []internalharvester.CredentialReplacement{{Old: old, New: replacement}}, nil
+		},
+		verify: func(ctx context.Context) error {
+			controller, err := internalharvester.NewUniFiController(host, user, replacement, client.HTTPClient)
+			if err != nil {
+				return err
+			}
+			controller.Site = viper.GetString("flags.unifi-site")
+			_, err = controller.PortForwards(ctx)
+			return err
+		},
+	}, nil
+}
+
+// argoCDAdminRotation sets a generated ArgoCD admin password
+func argoCDAdminRotation(ctx context.Context, client *internalharvester.Client) (*credentialRotation, error) {
+	old := viper.GetString("components.argocd.password")
+	if old == "" {
+		var err error
+		if old, err = client.ArgoCDAdminPassword(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var password string
+	return &credentialRotation{
+		target: "argocd-admin",
+		action: "set a generated ArgoCD admin password",
+		old:    []string{old},
+		rotate: func(ctx context.Context) ([]internalharvester.CredentialReplacement, error) {
+			var err error
+			if password, err = internalharvester.GenerateArgoCDAdminPassword(); err != nil {
+				return nil, err
+			}
+			if err := client.SetArgoCDAdminPassword(ctx, password); err != nil {
+				return nil, err
+			}
+
+			viper.Set("components.argocd.password", password)
+			if err := viper.WriteConfig(); err != nil {
+				return nil, fmt.Errorf("failed to record argocd password in config: %w", err)
+			}
+
+			return []internalharvester.CredentialReplacement{{Old: old, New: password}}, nil
+		},
+		verify: func(ctx context.Context) error {
+			return client.VerifyArgoCDLogin(ctx, password)
+		},
+	}, nil
+}
+
+// kbotRotation generates a new kbot SSH key, adds it as the ArgoCD deploy
+// key of the gitops repository and swaps it for the old kbot and deploy
+// keys. The old deploy key is only removed once ArgoCD fetches with the
+// new one
+func kbotRotation(ctx context.Context, client *internalharvester.Client, gitopsRepo *internalharvester.GitopsRepo) (*credentialRotation, error) {
+	oldPrivateKey, oldPublicKey := viper.GetString("kbot.private-key"), viper.GetString("kbot.public-key")
+	oldDeployKey, err := client.ArgoCDRepoSSHKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	https := viper.GetBool(argoCDHTTPSCredentialKey)
+
+	action := fmt.Sprintf("generate a kbot ssh key and replace the deploy key of %s", gitopsRepo.URL)
+	if https {
+		action = "generate a kbot ssh key, ArgoCD fetches over https and keeps its credential"
+	}
+
+	var keyID int64
+	return &credentialRotation{
+		target: "kbot-ssh",
+		action: action,
+		old:    []string{oldPrivateKey, oldDeployKey, oldPublicKey},
+		rotate: func(ctx context.Context) ([]internalharvester.CredentialReplacement, error) {
+			publicKey, privateKey, err := internalharvester.GenerateDeployKey()
+			if err != nil {
+				return nil, err
+			}
+
+			if !https {
+				title := fmt.Sprintf("kubefirst-argocd-%s", viper.GetString("flags.cluster-name"))
+				if keyID, err = gitopsRepo.AddDeployKey(ctx, title, publicKey, viper.GetBool("flags.argocd-write-access")); err != nil {
+					return nil, err
+				}
+				if oldDeployKey == "" {
+					if err := client.ApplyArgoCDRepoSecret(ctx, gitopsRepo.SSHURL(), privateKey); err != nil {
+						return nil, fmt.Errorf("failed to store deploy key for ArgoCD: %w", err)
+					}
+				}
+			}
+
+			viper.Set("kbot.public-key", publicKey)
+			viper.Set("kbot.private-key", string(privateKey))
+			if err := viper.WriteConfig(); err != nil {
+				return nil, fmt.Errorf("failed to record kbot key in config: %w", err)
+			}
+
+			return []internalharvester.CredentialReplacement{
+				{Old: oldPrivateKey, New: string(privateKey)},
+				{Old: oldDeployKey, New: string(privateKey)},
+				{Old: oldPublicKey, New: publicKey},
+			}, nil
+		},
+		verify: func(ctx context.Context) error {
+			return verifyRootApplicationFetch(ctx, client)
+		},
+		finish: func(ctx context.Context) error {
+			if keyID == 0 {
+				return nil
+			}
+			if err := removeDeployKey(ctx, gitopsRepo); err != nil {
+				return err
+			}
+
+			viper.Set(deployKeyIDKey, keyID)
+			if err := viper.WriteConfig(); err != nil {
+				return fmt.Errorf("failed to record deploy key in config: %w", err)
+			}
+			return nil
+		},
+	}, nil
+}
+
+// verifyRootApplicationFetch checks ArgoCD still fetches the gitops
+// repository, through the application of the registry path
+func verifyRootApplicationFetch(ctx context.Context, client *internalharvester.Client) error {
+	registryPath := internalharvester.RegistryPath(viper.GetString("flags.gitops-registry-path"), viper.GetString("flags.cluster-name"))
+	root, err := client.RootApplication(ctx, registryPath)
+	if err != nil {
+		return err
+	}
+
+	return client.VerifyRepoFetch(ctx, root.Name)
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/konstructio/kubefirst/internal/cluster"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/provision"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// checkExistingState refuses to provision over the state of an earlier
+// attempt for the same cluster name unless --force or --resume-from is set.
+// A retry resumes the state from its pending step, as --resume-from
+// interrupted does for the create Ctrl-C stopped
+func checkExistingState(ctx context.Context, watcher *provision.Watcher, cliFlags *types.CliFlags, retry bool, stepper step.Stepper) error {
+	stepper.NewProgressStep("Check Existing Cluster State")
+
+	if retry {
+		pending, found, err := watcher.PendingStep(ctx)
+		if err != nil {
+			wrerr := kubefirstAPIError("failed to check existing cluster state", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		cliFlags.ResumeFrom = ""
+		if found {
+			cliFlags.ResumeFrom = provision.StepSlug(pending)
+		}
+	}
+	if cliFlags.ResumeFrom == internalharvester.ResumeInterrupted {
+		resumeFrom, err := resumeInterrupted(ctx, watcher)
+		if err != nil {
+			wrerr := fmt.Errorf("failed to resume the interrupted create: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		// interrupted before kubefirst-api held any state, it starts over
+		cliFlags.ResumeFrom = resumeFrom
+		if resumeFrom == "" {
+			stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Resuming the interrupted create of cluster %q from the start, kubefirst-api holds no state for it yet", cliFlags.ClusterName))
+		}
+	}
+
+	err := watcher.CheckExistingState(ctx)
+	switch {
+	case err == nil:
+		if cliFlags.ResumeFrom != "" {
+			wrerr := fmt.Errorf("cannot resume cluster %q from %q: it has no provision state, run create without --resume-from", cliFlags.ClusterName, cliFlags.ResumeFrom)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+	case !errors.Is(err, provision.ErrExistingState):
+		wrerr := kubefirstAPIError("failed to check existing cluster state", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	case cliFlags.ResumeFrom != "":
+		if err := watcher.ResumeFrom(cliFlags.ResumeFrom); err != nil {
+			wrerr := fmt.Errorf("invalid --resume-from: %w", err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Resuming cluster %q from %s", cliFlags.ClusterName, cliFlags.ResumeFrom))
+	case cliFlags.Force:
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Discarding the existing provision state of cluster %q", cliFlags.ClusterName))
+	default:
+		stepper.FailCurrentStep(err)
+		return err
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
+
+// kubefirstAPIError words the failure of a kubefirst-api cluster operation
+// for the step output, telling what to do when it is one of the typed
+// failures of the cluster client
+func kubefirstAPIError(message string, err error) error {
+	if hint := cluster.Hint(err); hint != "" {
+		return fmt.Errorf("%s, %s: %w", message, hint, err)
+	}
+
+	return fmt.Errorf("%s: %w", message, err)
+}
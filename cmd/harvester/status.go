@@ -0,0 +1,154 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runStatus(cmd *cobra.Command, _ []string) error {
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	writeReport, err := cmd.Flags().GetBool("report")
+	if err != nil {
+		return fmt.Errorf("failed to get report flag: %w", err)
+	}
+
+	reportPath, err := cmd.Flags().GetString("report-path")
+	if err != nil {
+		return fmt.Errorf("failed to get report-path flag: %w", err)
+	}
+	if reportPath == "" {
+		reportPath = viper.GetString("flags.report-path")
+	}
+
+	stepper.NewProgressStep("Check Platform Status")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := pingCluster(cmd.Context(), client, stepper); err != nil {
+		return err
+	}
+
+	apps, err := client.ListApplications(cmd.Context(), "*")
+	if err != nil {
+		wrerr := fmt.Errorf("failed to list applications: %w", err)
+		if errors.Is(err, internalharvester.ErrArgoCDUnreachable) {
+			wrerr = fmt.Errorf("unable to reach ArgoCD on the Harvester cluster, check the kubeconfig and that ArgoCD is installed: %w", err)
+		}
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "APPLICATION\tSYNC\tHEALTH")
+	ready := 0
+	for i := range apps {
+		if internalharvester.IsApplicationReady(&apps[i]) {
+			ready++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", apps[i].Name, apps[i].Status.Sync.Status, apps[i].Status.Health.Status)
+	}
+	w.Flush()
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStepString(b.String())
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("%d of %d application(s) Healthy/Synced", ready, len(apps)))
+	if err := recordProfileHealth(ready, len(apps)); err != nil {
+		return err
+	}
+
+	if err := warnSingletonRisks(cmd.Context(), client, stepper); err != nil {
+		return fmt.Errorf("failed to check the nodes of the platform components: %w", err)
+	}
+
+	progress, err := client.ReadProgress(cmd.Context())
+	if err != nil {
+		return fmt.Errorf("failed to read provisioning progress: %w", err)
+	}
+	if progress != nil {
+		stepper.InfoStep(step.EmojiAlarm, progress.Summary())
+	}
+
+	replication, err := replicationStatus(cmd.Context(), proxy)
+	if err != nil {
+		return fmt.Errorf("failed to read replication status: %w", err)
+	}
+	if replication != "" {
+		stepper.InfoStep(step.EmojiCheck, replication)
+	}
+
+	if internalharvester.ObservabilityEnabled(viper.GetString("flags.stop-after"), viper.GetBool("flags.install-observability")) {
+		if err := printPrometheusTargets(cmd.Context(), client, stepper); err != nil {
+			return fmt.Errorf("failed to check prometheus targets: %w", err)
+		}
+	}
+
+	if !writeReport {
+		return nil
+	}
+
+	stepper.NewProgressStep("Write Installation Report")
+
+	report, markdownPath, err := writeInstallationReport(cmd.Context(), client, reportPath)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to write installation report: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+	printInstallationReport(cmd.OutOrStdout(), report, markdownPath)
+
+	return nil
+}
+
+// printPrometheusTargets reports how many scrape targets of the
+// observability phase are up, with the last error of every other one
+func printPrometheusTargets(ctx context.Context, client *internalharvester.Client, stepper step.Stepper) error {
+	targets, err := client.PrometheusTargets(ctx)
+	if err != nil {
+		return err
+	}
+
+	up := 0
+	for _, target := range targets {
+		if target.Up() {
+			up++
+			continue
+		}
+		stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Prometheus target %s of job %s is %s: %s", target.ScrapeURL, target.Job, target.Health, target.LastError))
+	}
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("%d of %d Prometheus target(s) up", up, len(targets)))
+
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// provisionSummary writes the --summary-file of a create run once it ends,
+// successful or not
+type provisionSummary struct {
+	path      string
+	startedAt time.Time
+	tracker   *internalharvester.PhaseTracker
+	client    *internalharvester.Client
+	cliFlags  *types.CliFlags
+}
+
+// newProvisionSummary takes path from the summary-file flag so runs failing
+// before the flags are read still write their summary
+func newProvisionSummary(tracker *internalharvester.PhaseTracker, path string) *provisionSummary {
+	return &provisionSummary{path: path, startedAt: time.Now(), tracker: tracker}
+}
+
+// configure sets the flags of the run, which may take --summary-file from
+// --from-config
+func (s *provisionSummary) configure(cliFlags *types.CliFlags) {
+	s.cliFlags = cliFlags
+	if cliFlags.SummaryFile != "" {
+		s.path = cliFlags.SummaryFile
+	}
+}
+
+// configureClient sets the cluster the vclusters and applications are read
+// from
+func (s *provisionSummary) configureClient(client *internalharvester.Client) {
+	s.client = client
+}
+
+// finish writes the summary of the run. It must run after the step events
+// are drained so the tracker holds the failed phase. Failures are reported
+// to errOut but never fail the run
+func (s *provisionSummary) finish(ctx context.Context, runErr error, errOut io.Writer) {
+	if s.path == "" {
+		return
+	}
+
+	var opts internalharvester.SummaryOptions
+	var vclusters []string
+	if s.cliFlags != nil {
+		opts = internalharvester.SummaryOptions{
+			ClusterName:     s.cliFlags.ClusterName,
+			GitProvider:     s.cliFlags.GitProvider,
+			GitOwner:        summaryGitOwner(s.cliFlags),
+			KubeconfigPath:  s.cliFlags.HarvesterKubeconfigPath,
+			Vault:           internalharvester.VaultEnabled(s.cliFlags.StopAfter),
+			VaultAutoUnseal: s.cliFlags.VaultAutoUnseal,
+		}
+		vclusters = s.cliFlags.VClusters
+	}
+
+	summary := internalharvester.NewProvisionSummary(opts, s.startedAt, s.tracker.Phases(), runErr)
+
+	if s.client != nil {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), usageQueryTimeout)
+		defer cancel()
+		if err := s.client.QuerySummary(ctx, summary, vclusters); err != nil {
+			summary.QueryError = err.Error()
+		}
+	}
+
+	if err := internalharvester.WriteProvisionSummary(s.path, summary); err != nil {
+		fmt.Fprintf(errOut, "warning: failed to write provisioning summary: %v\n", err)
+	}
+}
+
+func summaryGitOwner(cliFlags *types.CliFlags) string {
+	switch cliFlags.GitProvider {
+	case "gitlab":
+		return cliFlags.GitlabGroup
+	default:
+		return cliFlags.GithubOrg
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+)
+
+func runSync(cmd *cobra.Command, _ []string) error {
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	appPattern, err := cmd.Flags().GetString("app")
+	if err != nil {
+		return fmt.Errorf("failed to get app flag: %w", err)
+	}
+
+	wait, err := cmd.Flags().GetBool("wait")
+	if err != nil {
+		return fmt.Errorf("failed to get wait flag: %w", err)
+	}
+
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return fmt.Errorf("failed to get timeout flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Sync ArgoCD Applications")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	apps, err := client.ListApplications(cmd.Context(), appPattern)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to find applications to sync: %w", err)
+		if errors.Is(err, internalharvester.ErrArgoCDUnreachable) {
+			wrerr = fmt.Errorf("unable to reach ArgoCD on the Harvester cluster, check the kubeconfig and that ArgoCD is installed: %w", err)
+		}
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	names := make([]string, 0, len(apps))
+	for _, app := range apps {
+		if err := client.SyncApplication(cmd.Context(), app.Name); err != nil {
+			wrerr := fmt.Errorf("failed to sync application %q: %w", app.Name, err)
+			stepper.FailCurrentStep(wrerr)
+			return wrerr
+		}
+		names = append(names, app.Name)
+	}
+
+	stepper.CompleteCurrentStep()
+	stepper.InfoStep(step.EmojiCheck, fmt.Sprintf("Triggered sync for %d application(s): %v", len(names), names))
+
+	if !wait {
+		return nil
+	}
+
+	stepper.NewProgressStep("Wait for Applications Healthy/Synced")
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+	defer cancel()
+
+	if err := client.WaitForApplications(ctx, names); err != nil {
+		wrerr := fmt.Errorf("applications did not converge: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
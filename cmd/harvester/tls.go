@@ -0,0 +1,54 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// caCertKey is where create records --ca-cert for the other harvester
+// commands to trust the same CAs
+const caCertKey = "flags.ca-cert"
+
+// configureTLS loads --ca-cert, or the CA certificates recorded by create,
+// and sets the TLS options of every client the harvester commands build.
+// It runs before any of them, so a file that does not parse fails the
+// command before anything is provisioned
+func configureTLS(cmd *cobra.Command) error {
+	caCerts, err := cmd.Flags().GetStringSlice("ca-cert")
+	if err != nil {
+		return fmt.Errorf("failed to get ca-cert flag: %w", err)
+	}
+	if !cmd.Flags().Changed("ca-cert") {
+		caCerts = viper.GetStringSlice(caCertKey)
+	}
+
+	insecure, err := cmd.Flags().GetBool("insecure-skip-tls-verify")
+	if err != nil {
+		return fmt.Errorf("failed to get insecure-skip-tls-verify flag: %w", err)
+	}
+
+	caBundle, err := internalharvester.LoadCACerts(caCerts)
+	if err != nil {
+		return fmt.Errorf("invalid --ca-cert: %w", err)
+	}
+
+	if err := internalharvester.SetTLSOptions(internalharvester.TLSOptions{CABundle: caBundle, InsecureSkipVerify: insecure}); err != nil {
+		return fmt.Errorf("invalid --ca-cert: %w", err)
+	}
+
+	if insecure {
+		fmt.Fprintf(cmd.ErrOrStderr(), "\n%s WARNING: --insecure-skip-tls-verify is set, the certificates of the git provider, UniFi controller, Vault and every other HTTPS endpoint are NOT verified. Anyone on the network path can impersonate them and read the tokens kubefirst sends. Use it in labs only, pass --ca-cert to trust a private CA instead\n\n", step.EmojiWarning)
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// validateTrustBundle checks the --trust-bundle flags, which distribute the
+// --ca-cert certificates and so need some
+func validateTrustBundle(cliFlags *types.CliFlags) error {
+	if !cliFlags.TrustBundle {
+		return nil
+	}
+
+	if len(cliFlags.VClusters) == 0 && !cliFlags.TrustManager {
+		return errors.New("--trust-bundle has nowhere to go without --vclusters or --trust-manager")
+	}
+	targets, err := internalharvester.ParseTrustBundleTargets(cliFlags.VClusters, cliFlags.TrustBundleTargets)
+	if err != nil {
+		return fmt.Errorf("invalid --trust-bundle-target: %w", err)
+	}
+	if _, err := internalharvester.ParseTrustBundleSelector(cliFlags.TrustBundleSelector); err != nil {
+		return fmt.Errorf("invalid --trust-bundle-namespace-selector: %w", err)
+	}
+
+	if cliFlags.TrustBundleProbeURL != "" {
+		probe, err := url.Parse(cliFlags.TrustBundleProbeURL)
+		if err != nil || probe.Scheme != "https" || probe.Host == "" {
+			return fmt.Errorf("invalid --trust-bundle-probe-url %q: expected an https URL", cliFlags.TrustBundleProbeURL)
+		}
+		if len(targets) == 0 {
+			return errors.New("--trust-bundle-probe-url runs in a vcluster but --vclusters is empty")
+		}
+	}
+
+	return nil
+}
+
+// trustBundleTargets returns the vcluster namespaces of the trust bundle,
+// none without --trust-bundle
+func trustBundleTargets(enabled bool, vclusters, entries []string) ([]internalharvester.TrustBundleTarget, error) {
+	if !enabled {
+		return nil, nil
+	}
+
+	targets, err := internalharvester.ParseTrustBundleTargets(vclusters, entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --trust-bundle-target: %w", err)
+	}
+
+	return targets, nil
+}
+
+// distributeTrustBundle applies the --ca-cert certificates as the trust
+// bundle ConfigMaps of the host cluster and commits the syncer config
+// bringing them into the vclusters, which the ApplicationSet directories
+// already hold, along with trust-manager when asked for
+func distributeTrustBundle(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits) error {
+	bundle, err := internalharvester.LoadCACerts(cliFlags.CACerts)
+	if err != nil {
+		return fmt.Errorf("invalid --ca-cert: %w", err)
+	}
+	targets, err := trustBundleTargets(cliFlags.TrustBundle, cliFlags.VClusters, cliFlags.TrustBundleTargets)
+	if err != nil {
+		return err
+	}
+
+	if err := client.ApplyTrustBundle(ctx, bundle, targets); err != nil {
+		return err
+	}
+
+	if !cliFlags.VClusterAppSet {
+		files, err := commits.repo.ReadYAMLFiles(ctx, "/")
+		if err != nil {
+			return fmt.Errorf("failed to read gitops repository: %w", err)
+		}
+
+		changed, err := internalharvester.TrustBundleFiles(files, targets)
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 {
+			if err := commits.add(ctx, changed, "sync the trust bundle into the vclusters"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !cliFlags.TrustManager {
+		return nil
+	}
+
+	selector, err := internalharvester.ParseTrustBundleSelector(cliFlags.TrustBundleSelector)
+	if err != nil {
+		return fmt.Errorf("invalid --trust-bundle-namespace-selector: %w", err)
+	}
+	manifests, err := internalharvester.TrustManagerManifests(selector)
+	if err != nil {
+		return err
+	}
+
+	return commitRegistryFile(ctx, commits, cliFlags, "trust-manager.yaml", manifests, "install trust-manager")
+}
+
+// verifyTrustBundle fetches --trust-bundle-probe-url from the first
+// --trust-bundle-target with the trust bundle as the only CAs
+func verifyTrustBundle(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags) error {
+	targets, err := trustBundleTargets(cliFlags.TrustBundle, cliFlags.VClusters, cliFlags.TrustBundleTargets)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return errors.New("no vcluster receives the trust bundle")
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, internalharvester.DefaultTrustBundleProbeTimeout)
+	defer cancel()
+
+	if err := client.RunTrustBundleProbe(probeCtx, targets[0], cliFlags.TrustBundleProbeURL); err != nil {
+		return fmt.Errorf("vcluster %s: %w", targets[0], err)
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/ui"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// runCreateDashboard runs create under the --tui dashboard, which renders
+// the same step events as the standard output. Log lines still go to the
+// log file and are shown under their step. What create prints to the
+// standard output, such as the installation report, is printed once the
+// dashboard closes
+func runCreateDashboard(cmd *cobra.Command, plan internalharvester.Plan, fromConfig map[string]bool) error {
+	out := cmd.OutOrStdout()
+	defer cmd.SetOut(out)
+
+	clusterName, _ := cmd.Flags().GetString("cluster-name")
+	dashboard := ui.NewDashboard("kubefirst harvester create " + clusterName)
+
+	var printed bytes.Buffer
+	err := ui.RunDashboard(cmd.Context(), dashboard, cmd.InOrStdin(), cmd.ErrOrStderr(), func(ctx context.Context, frontend ui.Frontend) error {
+		printed.Reset()
+		cmd.SetOut(io.MultiWriter(&printed, frontend.Log))
+
+		logger := log.Logger
+		defer func() { log.Logger = logger }()
+		log.Logger = log.Logger.Hook(zerolog.HookFunc(func(_ *zerolog.Event, level zerolog.Level, message string) {
+			fmt.Fprintf(frontend.Log, "%s %s\n", level, message)
+		}))
+
+		return runCreate(ctx, cmd, plan, fromConfig, frontend)
+	})
+
+	fmt.Fprint(out, printed.String())
+
+	return err
+}
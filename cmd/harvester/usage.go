@@ -0,0 +1,174 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// usageQueryTimeout bounds the cluster queries of the usage summary, which
+// must not hold up the end of a run
+const usageQueryTimeout = 30 * time.Second
+
+// installUsage collects the usage summary of a create run. It is written
+// to the report directory once the run ends, successful or not, and its
+// paragraph printed when it succeeded
+type installUsage struct {
+	startedAt time.Time
+	tracker   *internalharvester.PhaseTracker
+	client    *internalharvester.Client
+	cliFlags  types.CliFlags
+}
+
+func newInstallUsage(tracker *internalharvester.PhaseTracker) *installUsage {
+	return &installUsage{startedAt: time.Now(), tracker: tracker}
+}
+
+// configure sets the cluster the summary queries, nothing is recorded for
+// runs failing before it is known
+func (u *installUsage) configure(client *internalharvester.Client, cliFlags *types.CliFlags) {
+	u.client = client
+	u.cliFlags = *cliFlags
+}
+
+// finish writes the usage summary of the run. It must run after the step
+// events are drained so the tracker holds every phase. Failures are
+// reported to errOut but never fail the run
+func (u *installUsage) finish(ctx context.Context, runErr error, out, errOut io.Writer) {
+	if u.client == nil {
+		return
+	}
+
+	summary := internalharvester.NewUsageSummary(u.cliFlags.ClusterName, u.startedAt, runErr == nil, u.tracker.Phases())
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), usageQueryTimeout)
+	defer cancel()
+	queryUsage(ctx, u.client, u.cliFlags.HarvesterLBPoolName, u.cliFlags.HarvesterLBIPRanges, u.cliFlags.HarvesterLBIPRangeNames, summary)
+
+	reportPath := u.cliFlags.ReportPath
+	if reportPath == "" {
+		var err error
+		reportPath, err = internalharvester.DefaultReportDir(u.cliFlags.ClusterName)
+		if err != nil {
+			fmt.Fprintf(errOut, "warning: failed to write usage summary: %v\n", err)
+			return
+		}
+	}
+
+	if _, err := internalharvester.WriteUsageSummary(reportPath, summary); err != nil {
+		fmt.Fprintf(errOut, "warning: failed to write usage summary: %v\n", err)
+		return
+	}
+
+	if runErr == nil {
+		fmt.Fprintf(out, "\n%s", summary.Paragraph())
+	}
+}
+
+// queryUsage fills in the resources and load balancer addresses of summary
+// from the live cluster, noting why when they cannot be queried
+func queryUsage(ctx context.Context, client *internalharvester.Client, lbPoolName string, lbIPRanges, lbIPRangeNames []string, summary *internalharvester.UsageSummary) {
+	resources, err := client.PlatformResources(ctx)
+	if err != nil {
+		resources = internalharvester.UsageResources{Namespaces: []string{}, QueryError: err.Error()}
+	}
+	summary.Resources = resources
+
+	if len(lbIPRanges) == 0 && len(lbIPRangeNames) == 0 {
+		return
+	}
+	lbPools, err := internalharvester.ParseLBPools(lbPoolName, lbIPRanges, lbIPRangeNames)
+	if err != nil {
+		summary.LoadBalancerIPs = internalharvester.UsageAddresses{Pool: strings.Join(slices.Concat(lbIPRanges, lbIPRangeNames), ","), Used: []string{}, QueryError: err.Error()}
+		return
+	}
+	addresses, err := client.LoadBalancerAddresses(ctx, lbPools)
+	if err != nil {
+		addresses = internalharvester.UsageAddresses{Pool: lbPools.String(), Used: []string{}, QueryError: err.Error()}
+	}
+	summary.LoadBalancerIPs = addresses
+}
+
+func runReport(cmd *cobra.Command, _ []string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return fmt.Errorf("failed to get output flag: %w", err)
+	}
+	if output != "table" && output != "json" {
+		return fmt.Errorf("unknown --output %q, must be table or json", output)
+	}
+
+	reportPath, err := cmd.Flags().GetString("report-path")
+	if err != nil {
+		return fmt.Errorf("failed to get report-path flag: %w", err)
+	}
+	if reportPath == "" {
+		reportPath = viper.GetString("flags.report-path")
+	}
+	if reportPath == "" {
+		clusterName := viper.GetString("flags.cluster-name")
+		if clusterName == "" {
+			return fmt.Errorf("no cluster in the kubefirst config, pass --report-path")
+		}
+		reportPath, err = internalharvester.DefaultReportDir(clusterName)
+		if err != nil {
+			return err
+		}
+	}
+
+	summary, err := internalharvester.ReadUsageSummary(reportPath)
+	if err != nil {
+		return err
+	}
+
+	refresh, err := cmd.Flags().GetBool("refresh")
+	if err != nil {
+		return fmt.Errorf("failed to get refresh flag: %w", err)
+	}
+	if refresh {
+		kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+		if err != nil {
+			return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+		}
+		proxy, err := cmd.Flags().GetString("proxy")
+		if err != nil {
+			return fmt.Errorf("failed to get proxy flag: %w", err)
+		}
+
+		client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+		if err != nil {
+			return fmt.Errorf("failed to create harvester client: %w", err)
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), usageQueryTimeout)
+		defer cancel()
+		queryUsage(ctx, client, viper.GetString("flags.lb-pool-name"), viper.GetStringSlice("flags.lb-ip-range"), viper.GetStringSlice("flags.lb-ip-range-name"), summary)
+	}
+
+	if output == "table" {
+		return summary.WriteTable(cmd.OutOrStdout())
+	}
+
+	encoder := json.NewEncoder(cmd.OutOrStdout())
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(summary); err != nil {
+		return fmt.Errorf("failed to render usage summary: %w", err)
+	}
+
+	return nil
+}
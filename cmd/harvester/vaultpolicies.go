@@ -0,0 +1,209 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// vaultPoliciesPath is where the declarative source of the Vault policies
+// of a cluster is committed. It stays outside the registry directory,
+// ArgoCD has nothing to apply from it
+func vaultPoliciesPath(clusterName string) string {
+	return path.Join("vault-policies", clusterName+".yaml")
+}
+
+func runVaultPoliciesApply(cmd *cobra.Command, _ []string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	clusterName := viper.GetString("flags.cluster-name")
+	domainName := viper.GetString("flags.domain-name")
+	if clusterName == "" || domainName == "" {
+		return errors.New("no cluster recorded in the kubefirst config, run harvester create first")
+	}
+
+	file, err := cmd.Flags().GetString("file")
+	if err != nil {
+		return fmt.Errorf("failed to get file flag: %w", err)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	skipVerify, err := cmd.Flags().GetBool("skip-verify")
+	if err != nil {
+		return fmt.Errorf("failed to get skip-verify flag: %w", err)
+	}
+
+	perChange, err := commitPerChange(cmd)
+	if err != nil {
+		return err
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", file, err)
+	}
+
+	policies, err := internalharvester.ParseVaultPolicies(content, viper.GetStringSlice("flags.vclusters"))
+	if err != nil {
+		return fmt.Errorf("invalid vault policies %s: %w", file, err)
+	}
+
+	stepper.NewProgressStep("Apply Vault Policies")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	gitopsRepo, err := recordedGitopsRepo(client)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to resolve gitops repository: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	commits := newGitopsCommits(client, gitopsRepo, perChange)
+	if err := applyVaultPolicies(ctx, commits, clusterName, domainName, policies); err != nil {
+		wrerr := fmt.Errorf("failed to apply vault policies: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := commits.flush(ctx); err != nil {
+		wrerr := fmt.Errorf("failed to push vault policies: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	if skipVerify {
+		return nil
+	}
+
+	stepper.NewProgressStep("Verify Vault Policies")
+
+	if err := verifyVaultPolicies(ctx, client, domainName, policies, stepper); err != nil {
+		wrerr := fmt.Errorf("vault policy verification failed: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
+
+// applyVaultPolicies stages policies in commits as the declarative source
+// of the cluster's Vault policies, then writes them to Vault, pruning what
+// the previously committed source defined and policies no longer does
+func applyVaultPolicies(ctx context.Context, commits *gitopsCommits, clusterName, domainName string, policies *internalharvester.VaultPolicies) error {
+	content, err := policies.YAML()
+	if err != nil {
+		return err
+	}
+
+	files, err := commits.repo.ReadYAMLFiles(ctx, "/")
+	if err != nil {
+		return fmt.Errorf("failed to read gitops repository: %w", err)
+	}
+
+	sourcePath := vaultPoliciesPath(clusterName)
+	var previous *internalharvester.VaultPolicies
+	if committed, ok := files[sourcePath]; ok {
+		previous, err = internalharvester.ReadVaultPolicies(committed)
+		if err != nil {
+			return fmt.Errorf("failed to read committed %s: %w", sourcePath, err)
+		}
+	}
+
+	if !bytes.Equal(files[sourcePath], content) {
+		if err := commits.add(ctx, map[string][]byte{sourcePath: content}, "apply vault policies"); err != nil {
+			return fmt.Errorf("failed to commit %s: %w", sourcePath, err)
+		}
+	}
+
+	vaultClient, err := commits.client.NewVaultClient(ctx, domainName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to vault: %w", err)
+	}
+
+	return internalharvester.ApplyVaultPolicies(ctx, vaultClient, policies, previous)
+}
+
+// verifyVaultPolicies logs in under every role of policies and fails when
+// one reads a path it should not or cannot read one it should
+func verifyVaultPolicies(ctx context.Context, client *internalharvester.Client, domainName string, policies *internalharvester.VaultPolicies, stepper step.Stepper) error {
+	vaultClient, err := client.NewVaultClient(ctx, domainName)
+	if err != nil {
+		return fmt.Errorf("failed to connect to vault: %w", err)
+	}
+
+	checks, err := client.VerifyVaultPolicies(ctx, vaultClient, policies)
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+	for _, check := range checks {
+		if check.Skipped != "" {
+			stepper.InfoStep(step.EmojiWarning, fmt.Sprintf("Skipping role %s: %s", check.Role, check.Skipped))
+			continue
+		}
+		for _, violation := range check.Violations {
+			violations = append(violations, fmt.Sprintf("role %s %s", check.Role, violation))
+		}
+	}
+	if len(violations) > 0 {
+		return errors.New(strings.Join(violations, "; "))
+	}
+
+	return nil
+}
+
+// configureVaultTeamPolicies installs the starter policies of
+// --vault-team-policies along with the ServiceAccount of the platform admin
+func configureVaultTeamPolicies(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits, stepper step.Stepper) error {
+	if err := client.ApplyVaultPlatformAdmin(ctx); err != nil {
+		return err
+	}
+
+	policies := internalharvester.StarterVaultPolicies(cliFlags.VClusters)
+	if err := applyVaultPolicies(ctx, commits, cliFlags.ClusterName, cliFlags.DomainName, policies); err != nil {
+		return err
+	}
+
+	if cliFlags.SkipVerify || cliFlags.DryRun {
+		return nil
+	}
+
+	return verifyVaultPolicies(ctx, client, cliFlags.DomainName, policies, stepper)
+}
@@ -0,0 +1,279 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func runVClusterList(cmd *cobra.Command, _ []string) error {
+	vclusters := viper.GetStringSlice("flags.vclusters")
+	if len(vclusters) == 0 || !internalharvester.PhaseEnabled(viper.GetString("flags.stop-after"), internalharvester.PhaseVCluster) {
+		return errors.New("no vclusters in the kubefirst config")
+	}
+
+	specs, err := internalharvester.ResolveVClusterSpecs(vclusters, viper.GetStringSlice("flags.vcluster-spec"), viper.GetString("flags.vcluster-default-spec"))
+	if err != nil {
+		return fmt.Errorf("invalid vcluster spec in the kubefirst config: %w", err)
+	}
+	placements, err := internalharvester.ParseVClusterPlacements(vclusters, viper.GetStringSlice("flags.vcluster-node-selector"))
+	if err != nil {
+		return fmt.Errorf("invalid vcluster node selector in the kubefirst config: %w", err)
+	}
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		return fmt.Errorf("failed to create harvester client: %w", err)
+	}
+
+	described, err := client.DescribeVClusters(cmd.Context(), vclusters, viper.GetString("flags.domain-name"), viper.GetStringMapString("flags.vcluster-domain-map"))
+	if err != nil {
+		return fmt.Errorf("failed to list vclusters: %w", err)
+	}
+
+	w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tNAMESPACE\tDOMAIN\tPRESENT\tSPEC\tNODES")
+	for _, vcluster := range described {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", vcluster.Name, vcluster.Namespace, vcluster.Domain, vcluster.Present, specs[vcluster.Name], placements[vcluster.Name])
+	}
+
+	return w.Flush()
+}
+
+// runVClusterAdd commits the directory of a new vcluster, which the
+// vcluster ApplicationSet turns into its application, and records it
+func runVClusterAdd(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if err := internalharvester.ValidateClusterName(name); err != nil {
+		return fmt.Errorf("invalid vcluster name: %w", err)
+	}
+
+	spec, err := cmd.Flags().GetString("spec")
+	if err != nil {
+		return fmt.Errorf("failed to get spec flag: %w", err)
+	}
+
+	vclusters, err := appSetVClusters()
+	if err != nil {
+		return err
+	}
+	if slices.Contains(vclusters, name) {
+		return fmt.Errorf("vcluster %q already exists", name)
+	}
+	vclusters = append(vclusters, name)
+
+	specs := viper.GetStringSlice("flags.vcluster-spec")
+	if spec != "" {
+		specs = append(specs, name+"="+spec)
+	}
+	storage := internalharvester.StorageClasses{Default: viper.GetString("flags.storage-class"), VClusters: viper.GetStringMapString("flags.vcluster-storage-class")}
+	connections, err := internalharvester.ParseVClusterConnections(vclusters, viper.GetStringSlice("flags.vcluster-connect"))
+	if err != nil {
+		return fmt.Errorf("invalid vcluster connections in the kubefirst config: %w", err)
+	}
+	trust, err := trustBundleTargets(viper.GetBool("flags.trust-bundle"), vclusters, viper.GetStringSlice("flags.trust-bundle-target"))
+	if err != nil {
+		return fmt.Errorf("invalid trust bundle targets in the kubefirst config: %w", err)
+	}
+	values, err := vclusterValues(vclusters, specs, viper.GetString("flags.vcluster-default-spec"), viper.GetStringSlice("flags.vcluster-node-selector"), len(viper.GetStringSlice("flags.gpu-nodes")) > 0, storage, connections, trust)
+	if err != nil {
+		return err
+	}
+
+	registryPath := internalharvester.RegistryPath(viper.GetString("flags.gitops-registry-path"), viper.GetString("flags.cluster-name"))
+	files := internalharvester.VClusterDirectoryFiles(registryPath, name, values[name])
+	if err := commitVClusterChange(cmd, files, fmt.Sprintf("add vcluster %s", name)); err != nil {
+		return err
+	}
+
+	viper.Set("flags.vclusters", vclusters)
+	viper.Set("flags.vcluster-spec", specs)
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record vcluster %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// runVClusterDelete removes the directory of a vcluster, the ApplicationSet
+// then deletes its application and the workloads it synced
+func runVClusterDelete(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	vclusters, err := appSetVClusters()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(vclusters, name) {
+		return fmt.Errorf("vcluster %q is not in the kubefirst config", name)
+	}
+
+	registryPath := internalharvester.RegistryPath(viper.GetString("flags.gitops-registry-path"), viper.GetString("flags.cluster-name"))
+	files := map[string][]byte{path.Join(internalharvester.VClustersPath(registryPath), name): nil}
+	if err := commitVClusterChange(cmd, files, fmt.Sprintf("delete vcluster %s", name)); err != nil {
+		return err
+	}
+
+	return forgetVCluster(vclusters, name)
+}
+
+// forgetVCluster drops vcluster and its spec and node selector entries from
+// the kubefirst config once its directory is removed
+func forgetVCluster(vclusters []string, name string) error {
+	viper.Set("flags.vclusters", slices.DeleteFunc(vclusters, func(vcluster string) bool { return vcluster == name }))
+	viper.Set("flags.vcluster-spec", withoutVClusterEntries(viper.GetStringSlice("flags.vcluster-spec"), name, "="))
+	viper.Set("flags.vcluster-node-selector", withoutVClusterEntries(viper.GetStringSlice("flags.vcluster-node-selector"), name, ":"))
+	if err := viper.WriteConfig(); err != nil {
+		return fmt.Errorf("failed to record the deletion of vcluster %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// appSetVClusters returns the recorded vclusters once create generated them
+// with the vcluster ApplicationSet. The per-vcluster applications come from
+// the gitops template and are only changed by running create again
+func appSetVClusters() ([]string, error) {
+	if viper.GetString("flags.cluster-name") == "" {
+		return nil, errors.New("no cluster recorded in the kubefirst config, run harvester create first")
+	}
+	if !internalharvester.VClusterAppSetEnabled(viper.GetString("flags.stop-after"), viper.GetBool("flags.vcluster-appset")) {
+		return nil, errors.New("the cluster was created with --vcluster-appset=false, its vclusters are changed with harvester create --vclusters")
+	}
+
+	return viper.GetStringSlice("flags.vclusters"), nil
+}
+
+// withoutVClusterEntries drops the --vcluster-spec or
+// --vcluster-node-selector entries of vcluster, whose name ends at sep.
+// Specs joined by commas stay attached to the entry they follow
+func withoutVClusterEntries(entries []string, vcluster, sep string) []string {
+	var kept []string
+	dropping := false
+	for _, entry := range entries {
+		for _, token := range strings.Split(entry, ",") {
+			if name, _, ok := strings.Cut(token, sep); ok {
+				dropping = name == vcluster
+			}
+			if !dropping {
+				kept = append(kept, token)
+			}
+		}
+	}
+
+	return kept
+}
+
+// commitVClusterChange commits files to the recorded gitops repository
+func commitVClusterChange(cmd *cobra.Command, files map[string][]byte, message string) error {
+	ctx := cmd.Context()
+	stepper := step.NewStepFactory(cmd.ErrOrStderr())
+
+	kubeconfigPath, err := cmd.Flags().GetString("kubeconfig-path")
+	if err != nil {
+		return fmt.Errorf("failed to get kubeconfig-path flag: %w", err)
+	}
+
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return fmt.Errorf("failed to get proxy flag: %w", err)
+	}
+
+	stepper.NewProgressStep("Push vCluster Directory")
+
+	client, err := internalharvester.NewClient(kubeconfigPath, kubeContextFlag(cmd), proxy)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to create harvester client: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	gitopsRepo, err := recordedGitopsRepo(client)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to resolve gitops repository: %w", err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	sha, err := gitopsRepo.CommitFiles(ctx, files, message)
+	if err != nil {
+		wrerr := fmt.Errorf("failed to push %q: %w", message, err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	if err := recordGitopsSHA(ctx, client, message, sha, ""); err != nil {
+		wrerr := fmt.Errorf("failed to record %q: %w", message, err)
+		stepper.FailCurrentStep(wrerr)
+		return wrerr
+	}
+
+	stepper.CompleteCurrentStep()
+
+	return nil
+}
+
+// vclusterValues renders the chart values of vclusters from the
+// --vcluster-spec, --vcluster-default-spec and --vcluster-node-selector
+// entries, for the GPU nodes when gpu is set, with the syncer PVC of the
+// storage class of the vcluster in storage, the services replicated for
+// the connections and the trust bundle synced into the trust targets
+func vclusterValues(vclusters, specEntries []string, defaultSpec string, nodeSelectors []string, gpu bool, storage internalharvester.StorageClasses, connections []internalharvester.VClusterConnection, trust []internalharvester.TrustBundleTarget) (map[string]string, error) {
+	specs, err := internalharvester.ResolveVClusterSpecs(vclusters, specEntries, defaultSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vcluster spec: %w", err)
+	}
+	placements, err := internalharvester.ParseVClusterPlacements(vclusters, nodeSelectors)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vcluster node selector: %w", err)
+	}
+
+	values, err := internalharvester.VClusterHelmValues(specs, gpu, placements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster values: %w", err)
+	}
+
+	for _, vcluster := range vclusters {
+		rendered, err := internalharvester.VClusterConnectionValues([]byte(values[vcluster]), vcluster, connections)
+		if err != nil {
+			return nil, fmt.Errorf("vcluster %q: %w", vcluster, err)
+		}
+		if rendered, err = internalharvester.TrustBundleValues(rendered, vcluster, trust); err != nil {
+			return nil, fmt.Errorf("vcluster %q: %w", vcluster, err)
+		}
+		if class := storage.VCluster(vcluster); class != "" {
+			if rendered, err = internalharvester.VClusterStorageValues(rendered, class); err != nil {
+				return nil, fmt.Errorf("vcluster %q: %w", vcluster, err)
+			}
+		}
+		if len(rendered) > 0 {
+			values[vcluster] = string(rendered)
+		}
+	}
+
+	return values, nil
+}
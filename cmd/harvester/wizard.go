@@ -0,0 +1,271 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/atotto/clipboard"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/ui"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// shellSafe matches values that need no quoting on the command line
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9@%+=:,./_-]+$`)
+
+// runCreateWizard asks for the flags create cannot run without, one screen
+// at a time, and offers to run or copy the equivalent command. It reports
+// whether create should go on with the answers set on cmd
+func runCreateWizard(cmd *cobra.Command, fromConfig map[string]bool) (bool, error) {
+	ctx := cmd.Context()
+
+	proxy, _ := cmd.Flags().GetString("proxy")
+	httpClient, err := internalharvester.NewHTTPClient(proxy)
+	if err != nil {
+		return false, fmt.Errorf("invalid --proxy: %w", err)
+	}
+
+	questions := createWizardQuestions(httpClient)
+	defaults := map[string]string{}
+	for i, question := range questions {
+		flag := cmd.Flags().Lookup(question.Name)
+		defaults[question.Name] = flagString(flag)
+		questions[i].Default = defaults[question.Name]
+	}
+
+	render := func(answers map[string]string) string {
+		return wizardCommand(cmd, fromConfig, defaults, askedAnswers(questions, answers))
+	}
+
+	wizard, err := ui.RunWizard(ctx, ui.NewWizard(ctx, "kubefirst harvester create", questions, render), cmd.InOrStdin(), cmd.OutOrStdout())
+	if err != nil {
+		return false, err
+	}
+
+	answers := askedAnswers(questions, wizard.Answers())
+	switch wizard.Action() {
+	case ui.ActionRun:
+		for name, value := range answers {
+			if err := setFlag(cmd.Flags(), name, value); err != nil {
+				return false, fmt.Errorf("failed to set --%s: %w", name, err)
+			}
+		}
+		return true, nil
+	case ui.ActionCopy:
+		command := render(wizard.Answers())
+		if err := clipboard.WriteAll(command); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: failed to copy the command to the clipboard: %v\n", err)
+		} else {
+			fmt.Fprintln(cmd.ErrOrStderr(), "copied to the clipboard:")
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), command)
+		return false, nil
+	default:
+		return false, nil
+	}
+}
+
+func createWizardQuestions(httpClient *http.Client) []ui.Question {
+	provider := func(name string) func(map[string]string) bool {
+		return func(answers map[string]string) bool { return answers["git-provider"] != name }
+	}
+
+	return []ui.Question{
+		{
+			Name:    "git-provider",
+			Prompt:  "Which git provider hosts the gitops repository?",
+			Options: supportedGitProviders,
+		},
+		{
+			Name:   "github-org",
+			Prompt: "GitHub organization for the gitops repository",
+			Help:   "GITHUB_TOKEN is checked for its scopes and SSO authorization",
+			Skip:   provider("github"),
+			Validate: func(ctx context.Context, _ map[string]string, org string) error {
+				token, err := wizardToken(org, "GitHub organization", "GITHUB_TOKEN")
+				if err != nil {
+					return err
+				}
+				if err := internalharvester.CheckGitHubSSO(ctx, httpClient, internalharvester.GitHubAPIURL, org, token); err != nil {
+					return err
+				}
+				return internalharvester.CheckGitHubTokenScopes(ctx, httpClient, internalharvester.GitHubAPIURL, token)
+			},
+		},
+		{
+			Name:   "gitlab-group",
+			Prompt: "GitLab group for the gitops project",
+			Help:   "GITLAB_TOKEN is checked for its scopes and SSO authorization",
+			Skip:   provider("gitlab"),
+			Validate: func(ctx context.Context, _ map[string]string, group string) error {
+				token, err := wizardToken(group, "GitLab group", "GITLAB_TOKEN")
+				if err != nil {
+					return err
+				}
+				if err := internalharvester.CheckGitLabSSO(ctx, httpClient, internalharvester.GitLabAPIURL, group, token); err != nil {
+					return err
+				}
+				return internalharvester.CheckGitLabTokenScopes(ctx, httpClient, internalharvester.GitLabAPIURL, token)
+			},
+		},
+		{
+			Name:    "git-protocol",
+			Prompt:  "Git protocol to clone and push with",
+			Help:    "https uses the git provider token and needs no ssh keys",
+			Options: []string{"ssh", "https"},
+		},
+		{
+			Name:    "dns-provider",
+			Prompt:  "DNS provider of the domain",
+			Options: []string{"cloudflare"},
+			Validate: func(_ context.Context, _ map[string]string, _ string) error {
+				if os.Getenv("CF_API_TOKEN") == "" {
+					return errors.New("your CF_API_TOKEN is not set. Please set it and try again")
+				}
+				return nil
+			},
+		},
+		{
+			Name:   "domain-name",
+			Prompt: "Domain name of the cluster",
+			Help:   "e.g. example.com, the platform is served on its subdomains",
+			Validate: func(_ context.Context, _ map[string]string, domain string) error {
+				// a trailing dot is stripped like on the command line
+				return internalharvester.ValidateDomainName(strings.TrimSuffix(domain, "."))
+			},
+		},
+		{
+			Name:   "cluster-name",
+			Prompt: "Name of the cluster",
+			Validate: func(_ context.Context, _ map[string]string, name string) error {
+				return internalharvester.ValidateClusterName(name)
+			},
+		},
+		{
+			Name:   "alerts-email",
+			Prompt: "Email addresses for certificate and provisioning notifications",
+			Help:   "comma-separated, let's encrypt registers the first",
+			Validate: func(_ context.Context, _ map[string]string, value string) error {
+				_, err := internalharvester.ParseAlertsEmails(value)
+				return err
+			},
+		},
+		{
+			Name:   "kubeconfig-path",
+			Prompt: "Path to the Harvester kubeconfig",
+			Validate: func(_ context.Context, _ map[string]string, path string) error {
+				if _, err := os.Stat(os.ExpandEnv(path)); err != nil {
+					return fmt.Errorf("kubeconfig %q not found", path)
+				}
+				return nil
+			},
+		},
+		{
+			Name:     "vclusters",
+			Prompt:   "vCluster environments to create",
+			Help:     "comma-separated",
+			Validate: requiredAnswer("vclusters list"),
+		},
+	}
+}
+
+func requiredAnswer(what string) func(context.Context, map[string]string, string) error {
+	return func(_ context.Context, _ map[string]string, value string) error {
+		if value == "" {
+			return fmt.Errorf("the %s is required", what)
+		}
+		return nil
+	}
+}
+
+// wizardToken returns the token of tokenEnv once owner is given
+func wizardToken(owner, what, tokenEnv string) (string, error) {
+	if owner == "" {
+		return "", fmt.Errorf("the %s is required", what)
+	}
+
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return "", fmt.Errorf("your %s is not set. Please set it and try again", tokenEnv)
+	}
+
+	return token, nil
+}
+
+// askedAnswers drops the answers to questions the other answers skip, e.g.
+// the GitHub organization after switching to GitLab
+func askedAnswers(questions []ui.Question, answers map[string]string) map[string]string {
+	asked := map[string]string{}
+	for _, question := range questions {
+		if question.Skip != nil && question.Skip(answers) {
+			continue
+		}
+		if value, ok := answers[question.Name]; ok {
+			asked[question.Name] = value
+		}
+	}
+
+	return asked
+}
+
+// wizardCommand renders the create command equivalent to answers: the flags
+// given on the command line, the answers that differ from what the flags
+// resolved to before the wizard, never --interactive
+func wizardCommand(cmd *cobra.Command, fromConfig map[string]bool, defaults, answers map[string]string) string {
+	values := map[string]string{}
+	cmd.Flags().Visit(func(flag *pflag.Flag) {
+		if flag.Name != "interactive" && !fromConfig[flag.Name] {
+			values[flag.Name] = flagString(flag)
+		}
+	})
+	for name, value := range answers {
+		if value != defaults[name] {
+			values[name] = value
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	args := []string{"kubefirst harvester create"}
+	for _, name := range names {
+		args = append(args, fmt.Sprintf("--%s %s", name, shellQuote(values[name])))
+	}
+
+	return strings.Join(args, " \\\n  ")
+}
+
+func shellQuote(value string) string {
+	if shellSafe.MatchString(value) {
+		return value
+	}
+
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// setFlag sets the flag name of flags to value as if given on the command
+// line, replacing rather than appending to lists given there already
+func setFlag(flags *pflag.FlagSet, name, value string) error {
+	flag := flags.Lookup(name)
+	if list, ok := flag.Value.(pflag.SliceValue); ok && flag.Changed {
+		return list.Replace(strings.Split(value, ","))
+	}
+
+	return flags.Set(name, value)
+}
@@ -0,0 +1,122 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+
+	"github.com/konstructio/kubefirst/internal/cluster"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// managementCluster is the cluster of --mgmt-kubeconfig a workload cluster
+// is registered with
+type managementCluster struct {
+	client *internalharvester.Client
+	name   string
+	// registryPath is the registry directory of the management cluster,
+	// its root application deploys it from repoURL
+	registryPath string
+	repoURL      string
+}
+
+// resolveManagementCluster connects to the management cluster of
+// --mgmt-kubeconfig and ensures it is a kubefirst cluster other than the
+// one being created, whose root application deploys the gitops repository
+// of cliFlags
+func resolveManagementCluster(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, stepper step.Stepper) (*managementCluster, error) {
+	mgmtClient, err := internalharvester.NewClient(cliFlags.MgmtKubeconfig, "", cliFlags.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create management cluster client: %w", err)
+	}
+	mgmtClient.Retry = internalharvester.NewAPIRetry(cliFlags.APIRetryMax, stepper)
+
+	name, ok, err := mgmtClient.RecordedClusterName(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the cluster name of the management cluster: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("the cluster of %s was not created by kubefirst", cliFlags.MgmtKubeconfig)
+	}
+	if name == cliFlags.ClusterName {
+		return nil, fmt.Errorf("the cluster of %s is %s itself, pass the kubeconfig of its management cluster", cliFlags.MgmtKubeconfig, name)
+	}
+
+	if recorded, ok, err := client.RecordedClusterName(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read the cluster name of the workload cluster: %w", err)
+	} else if ok && recorded == name {
+		return nil, fmt.Errorf("--kubeconfig-path and %s both reach management cluster %s", cliFlags.MgmtKubeconfig, name)
+	}
+
+	registryPath := internalharvester.RegistryPath("", name)
+	root, err := mgmtClient.RootApplication(ctx, registryPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the root application of management cluster %s: %w", name, err)
+	}
+
+	gitopsRepo, err := clusterGitopsRepo(client, cliFlags)
+	if err != nil {
+		return nil, err
+	}
+	repoURL := root.Spec.GetSource().RepoURL
+	if !internalharvester.SameGitopsRepository(repoURL, gitopsRepo.URL) {
+		return nil, fmt.Errorf("management cluster %s deploys gitops repository %s, the workload cluster has to share it instead of %s", name, repoURL, gitopsRepo.URL)
+	}
+
+	return &managementCluster{
+		client:       mgmtClient,
+		name:         name,
+		registryPath: registryPath,
+		repoURL:      repoURL,
+	}, nil
+}
+
+// registerWorkloadCluster registers the cluster of client with the ArgoCD
+// of its management cluster and commits the ApplicationSet deploying the
+// workload directory of every workload cluster, starting the directory of
+// this one unless it already exists. Outside a dry run the parent is
+// recorded with the cluster records
+func registerWorkloadCluster(ctx context.Context, client *internalharvester.Client, cliFlags *types.CliFlags, commits *gitopsCommits, stepper step.Stepper) (string, error) {
+	mgmt, err := resolveManagementCluster(ctx, client, cliFlags, stepper)
+	if err != nil {
+		return "", err
+	}
+
+	if err := mgmt.client.RegisterWorkloadCluster(ctx, cliFlags.ClusterName, mgmt.name, client.RestConfig); err != nil {
+		return "", fmt.Errorf("failed to register with management cluster %s: %w", mgmt.name, err)
+	}
+
+	files, err := internalharvester.WorkloadAppSetFiles(mgmt.repoURL, mgmt.registryPath)
+	if err != nil {
+		return "", err
+	}
+	existing, err := commits.repo.ReadFiles(ctx, internalharvester.WorkloadGitopsPath(cliFlags.ClusterName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	if len(existing) == 0 {
+		maps.Copy(files, internalharvester.WorkloadDirectoryFiles(cliFlags.ClusterName))
+	}
+	if err := commits.add(ctx, files, "register workload cluster "+cliFlags.ClusterName); err != nil {
+		return "", fmt.Errorf("failed to commit workload cluster manifests: %w", err)
+	}
+
+	if !cliFlags.DryRun {
+		clusterClient := cluster.Client{}
+		if err := clusterClient.SetParentCluster(ctx, cliFlags.ClusterName, mgmt.name); err != nil {
+			return "", err
+		}
+	}
+
+	return mgmt.name, nil
+}
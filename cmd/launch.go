@@ -114,8 +114,11 @@ func launchListClusters() *cobra.Command {
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			stepper := step.NewStepFactory(cmd.ErrOrStderr())
 
-			clusters, err := cluster.GetClusters()
+			clusters, err := cluster.GetClusters(cmd.Context())
 			if err != nil {
+				if hint := cluster.Hint(err); hint != "" {
+					return fmt.Errorf("error getting clusters, %s: %w", hint, err)
+				}
 				return fmt.Errorf("error getting clusters: %w", err)
 			}
 
@@ -166,9 +169,12 @@ func launchDeleteCluster() *cobra.Command {
 
 			managedClusterName := args[0]
 
-			err := cluster.DeleteCluster(managedClusterName)
+			err := cluster.DeleteCluster(cmd.Context(), managedClusterName)
 			if err != nil {
 				wrerr := fmt.Errorf("failed to delete cluster: %w", err)
+				if hint := cluster.Hint(err); hint != "" {
+					wrerr = fmt.Errorf("failed to delete cluster %q, %s: %w", managedClusterName, hint, err)
+				}
 				stepper.FailCurrentStep(wrerr)
 				return wrerr
 			}
@@ -7,9 +7,11 @@ See the LICENSE file for more details.
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
+	"github.com/charmbracelet/lipgloss"
 	"github.com/konstructio/kubefirst-api/pkg/configs"
 	"github.com/konstructio/kubefirst/cmd/akamai"
 	"github.com/konstructio/kubefirst/cmd/aws"
@@ -22,7 +24,9 @@ import (
 	"github.com/konstructio/kubefirst/cmd/k3s"
 	"github.com/konstructio/kubefirst/cmd/vultr"
 	"github.com/konstructio/kubefirst/internal/common"
+	"github.com/konstructio/kubefirst/internal/readonly"
 	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/muesli/termenv"
 	"github.com/spf13/cobra"
 )
 
@@ -36,6 +40,26 @@ func Execute() {
 		open source application delivery platform in under an hour.
 		checkout the docs at https://kubefirst-pro.konstruct.io/docs/.`,
 		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			readOnly, err := cmd.Flags().GetBool("read-only")
+			if err != nil {
+				return fmt.Errorf("failed to get read-only flag: %w", err)
+			}
+			if readOnly {
+				readonly.SetEnabled(true)
+			}
+			if readonly.Enabled() && !enforcesReadOnly(cmd) {
+				return fmt.Errorf("read-only mode is only enforced by the harvester commands, %q would change state regardless", cmd.CommandPath())
+			}
+
+			noColor, err := cmd.Flags().GetBool("no-color")
+			if err != nil {
+				return fmt.Errorf("failed to get no-color flag: %w", err)
+			}
+			if noColor || os.Getenv(step.NoColorEnvVar) != "" {
+				step.SetNoColor(true)
+				lipgloss.SetColorProfile(termenv.Ascii)
+			}
+
 			// wire viper config for flags for all commands
 			return configs.InitializeViperConfig(cmd)
 		},
@@ -47,6 +71,9 @@ func Execute() {
 		SilenceUsage:  true,
 	}
 
+	rootCmd.PersistentFlags().Bool("read-only", false, "refuse every change to external or cluster state, only the harvester commands support it (also enabled by "+readonly.EnvVar+"=1)")
+	rootCmd.PersistentFlags().Bool("no-color", false, "print progress as plain lines without colors or spinner (also enabled by "+step.NoColorEnvVar+" and when stderr is not a terminal)")
+
 	output := rootCmd.ErrOrStderr()
 
 	rootCmd.AddCommand(
@@ -80,6 +107,33 @@ func Execute() {
 		fmt.Fprintln(output, step.EmojiError, "Error:", err)
 		fmt.Fprintln(output, "If a detailed error message was available, please make the necessary corrections before retrying.")
 		fmt.Fprintln(output, "You can re-run the last command to try the operation again.")
-		os.Exit(0)
+
+		// e.g. 130 for a run stopped by Ctrl-C
+		var exitCoder interface{ ExitCode() int }
+		if errors.As(err, &exitCoder) {
+			os.Exit(exitCoder.ExitCode())
+		}
+		os.Exit(1)
+	}
+}
+
+// readOnlyCommands change nothing, so read-only mode does not get in
+// their way
+var readOnlyCommands = map[string]bool{
+	"completion": true,
+	"help":       true,
+	"version":    true,
+}
+
+// enforcesReadOnly reports whether cmd honors read-only mode. Only the
+// clients of the harvester commands refuse mutations, the other providers
+// would ignore it
+func enforcesReadOnly(cmd *cobra.Command) bool {
+	for ; cmd.HasParent(); cmd = cmd.Parent() {
+		if !cmd.Parent().HasParent() {
+			return cmd.Name() == "harvester" || readOnlyCommands[cmd.Name()]
+		}
 	}
+
+	return true
 }
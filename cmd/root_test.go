@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnforcesReadOnly(t *testing.T) {
+	root := &cobra.Command{Use: "kubefirst"}
+	harvester := &cobra.Command{Use: "harvester"}
+	create := &cobra.Command{Use: "create"}
+	harvester.AddCommand(create)
+	aws := &cobra.Command{Use: "aws"}
+	awsCreate := &cobra.Command{Use: "create"}
+	aws.AddCommand(awsCreate)
+	version := &cobra.Command{Use: "version"}
+	root.AddCommand(harvester, aws, version)
+
+	assert.True(t, enforcesReadOnly(create))
+	assert.True(t, enforcesReadOnly(harvester))
+	assert.True(t, enforcesReadOnly(version))
+	assert.False(t, enforcesReadOnly(awsCreate))
+	assert.False(t, enforcesReadOnly(aws))
+}
@@ -61,6 +61,33 @@ func ReadActiveApplications(ctx context.Context) (apiTypes.GitopsCatalogApps, er
 	return out, nil
 }
 
+// InvalidApp is a --install-catalog-apps entry that cannot be installed.
+// Suggestion is the closest catalog app to an unknown name, if any is close
+type InvalidApp struct {
+	Name       string
+	Reason     string
+	Suggestion string
+}
+
+// InvalidAppsError lists every invalid entry of --install-catalog-apps, so
+// they can all be fixed before the next run
+type InvalidAppsError struct {
+	Apps []InvalidApp
+}
+
+func (e *InvalidAppsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d invalid catalog app(s):", len(e.Apps))
+	for _, app := range e.Apps {
+		fmt.Fprintf(&b, "\n  - %q: %s", app.Name, app.Reason)
+		if app.Suggestion != "" {
+			fmt.Fprintf(&b, ", did you mean %q?", app.Suggestion)
+		}
+	}
+
+	return b.String()
+}
+
 func ValidateCatalogApps(ctx context.Context, catalogApps string) (bool, []apiTypes.GitopsCatalogApp, error) {
 	items := strings.Split(catalogApps, ",")
 
@@ -75,18 +102,27 @@ func ValidateCatalogApps(ctx context.Context, catalogApps string) (bool, []apiTy
 		return false, gitopsCatalogapps, err
 	}
 
-	for _, app := range items {
+	known := make([]string, 0, len(apps.Apps))
+	for _, catalogApp := range apps.Apps {
+		known = append(known, catalogApp.Name)
+	}
+
+	var invalid []InvalidApp
+	for _, item := range items {
+		app := strings.TrimSpace(item)
+
 		found := false
 		for _, catalogApp := range apps.Apps {
 			if app == catalogApp.Name {
 				found = true
 
+				var missing []string
 				if catalogApp.SecretKeys != nil {
 					for _, secret := range catalogApp.SecretKeys {
 						secretValue := os.Getenv(secret.Env)
 
 						if secretValue == "" {
-							return false, gitopsCatalogapps, fmt.Errorf("your %q environment variable is not set for %q catalog application. Please set and try again", secret.Env, app)
+							missing = append(missing, secret.Env)
 						}
 
 						secret.Value = secretValue
@@ -97,25 +133,74 @@ func ValidateCatalogApps(ctx context.Context, catalogApps string) (bool, []apiTy
 					for _, config := range catalogApp.ConfigKeys {
 						configValue := os.Getenv(config.Env)
 						if configValue == "" {
-							return false, gitopsCatalogapps, fmt.Errorf("your %q environment variable is not set for %q catalog application. Please set and try again", config.Env, app)
+							missing = append(missing, config.Env)
 						}
 						config.Value = configValue
 					}
 				}
 
+				if len(missing) > 0 {
+					invalid = append(invalid, InvalidApp{
+						Name:   app,
+						Reason: fmt.Sprintf("environment variable(s) %s not set", strings.Join(missing, ", ")),
+					})
+					break
+				}
+
 				gitopsCatalogapps = append(gitopsCatalogapps, catalogApp)
 
 				break
 			}
 		}
 		if !found {
-			return false, gitopsCatalogapps, fmt.Errorf("catalog app is not supported: %q", app)
+			invalid = append(invalid, InvalidApp{Name: app, Reason: "not in the gitops catalog", Suggestion: suggestApp(app, known)})
 		}
 	}
 
+	if len(invalid) > 0 {
+		return false, gitopsCatalogapps, &InvalidAppsError{Apps: invalid}
+	}
+
 	return true, gitopsCatalogapps, nil
 }
 
+// suggestApp returns the known app closest to name, when it is close enough
+// to be a typo
+func suggestApp(name string, known []string) string {
+	suggestion, best := "", max(2, len(name)/3)+1
+	for _, app := range known {
+		if distance := editDistance(name, app); distance < best {
+			suggestion, best = app, distance
+		}
+	}
+
+	return suggestion
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	current := make([]int, len(rb)+1)
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			substitution := previous[j-1]
+			if ra[i-1] != rb[j-1] {
+				substitution++
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, substitution)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
 func (gh *GitHubClient) ReadGitopsCatalogRepoContents(ctx context.Context) ([]*git.RepositoryContent, error) {
 	_, directoryContent, _, err := gh.Client.Repositories.GetContents(
 		ctx,
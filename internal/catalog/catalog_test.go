@@ -0,0 +1,27 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestApp(t *testing.T) {
+	known := []string{"argo-workflows", "datadog", "grafana", "kyverno"}
+
+	assert.Equal(t, "grafana", suggestApp("grafanna", known))
+	assert.Equal(t, "kyverno", suggestApp("kyvrno", known))
+	assert.Empty(t, suggestApp("postgres", known))
+	assert.Equal(t, 3, editDistance("kitten", "sitting"))
+}
+
+func TestInvalidAppsError(t *testing.T) {
+	err := &InvalidAppsError{Apps: []InvalidApp{
+		{Name: "grafanna", Reason: "not in the gitops catalog", Suggestion: "grafana"},
+		{Name: "datadog", Reason: "environment variable(s) DD_API_KEY not set"},
+	}}
+
+	assert.Equal(t, "2 invalid catalog app(s):\n"+
+		`  - "grafanna": not in the gitops catalog, did you mean "grafana"?`+"\n"+
+		`  - "datadog": environment variable(s) DD_API_KEY not set`, err.Error())
+}
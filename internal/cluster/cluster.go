@@ -8,7 +8,9 @@ package cluster
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,6 +20,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"github.com/konstructio/kubefirst/internal/readonly"
 	"github.com/konstructio/kubefirst/internal/types"
 )
 
@@ -29,10 +32,103 @@ func GetConsoleIngressURL() string {
 	return "https://console.kubefirst.dev"
 }
 
+// The failures of the kubefirst-api cluster operations, matched with
+// errors.Is
+var (
+	ErrClusterNotFound      = errors.New("cluster not found")
+	ErrClusterAlreadyExists = errors.New("cluster already exists")
+	ErrClusterUnreachable   = errors.New("kubefirst-api unreachable")
+)
+
+// APIError is returned when kubefirst-api answers an operation with an
+// unexpected status. It wraps the failure the status stands for, if any
+type APIError struct {
+	Operation  string
+	StatusCode int
+	Status     string
+	Body       string
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	message := fmt.Sprintf("unable to %s: API returned unexpected status %q", e.Operation, e.Status)
+	if e.Err != nil {
+		message = fmt.Sprintf("unable to %s: %v (%s)", e.Operation, e.Err, e.Status)
+	}
+	if e.Body != "" {
+		message += ": " + e.Body
+	}
+
+	return message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary reports whether the operation is worth retrying, kubefirst-api
+// being unreachable or throttling
+func (e *APIError) Temporary() bool {
+	return errors.Is(e.Err, ErrClusterUnreachable) || e.StatusCode == http.StatusTooManyRequests
+}
+
+// newAPIError categorizes the status kubefirst-api answered operation with
+func newAPIError(operation string, res *http.Response, body []byte) *APIError {
+	apiErr := &APIError{Operation: operation, StatusCode: res.StatusCode, Status: res.Status, Body: strings.TrimSpace(string(body))}
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		apiErr.Err = ErrClusterNotFound
+	case http.StatusConflict:
+		apiErr.Err = ErrClusterAlreadyExists
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		apiErr.Err = ErrClusterUnreachable
+	}
+
+	return apiErr
+}
+
+// requestError wraps the error of a request kubefirst-api did not answer,
+// as unreachable unless ctx ended it
+func requestError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+
+	return fmt.Errorf("failed to execute request: %w: %w", ErrClusterUnreachable, err)
+}
+
+// Hint tells what to do about the failure err wraps, empty when it is none
+// of the typed errors of the cluster operations
+func Hint(err error) string {
+	switch {
+	case errors.Is(err, ErrClusterUnreachable):
+		return fmt.Sprintf("check that kubefirst-api is running and reachable through %s", GetConsoleIngressURL())
+	case errors.Is(err, ErrClusterNotFound):
+		return "kubefirst-api holds no cluster of that name, check the cluster name or create it first"
+	case errors.Is(err, ErrClusterAlreadyExists):
+		return "rerun with --resume-from to continue the existing cluster, --force to provision it again, or run destroy to remove it"
+	default:
+		return ""
+	}
+}
+
+// Client performs the cluster operations of kubefirst-api. Its errors wrap
+// ErrClusterNotFound, ErrClusterAlreadyExists or ErrClusterUnreachable when
+// the operation failed for one of those reasons
 type Client struct{}
 
-func (c *Client) GetCluster(clusterName string) (*apiTypes.Cluster, error) {
-	cluster, err := GetCluster(clusterName)
+// Ping checks that kubefirst-api answers, through the console proxy
+func (c *Client) Ping(ctx context.Context) error {
+	err := Ping(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reach kubefirst-api: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) GetCluster(ctx context.Context, clusterName string) (*apiTypes.Cluster, error) {
+	cluster, err := GetCluster(ctx, clusterName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get cluster: %w", err)
 	}
@@ -40,8 +136,8 @@ func (c *Client) GetCluster(clusterName string) (*apiTypes.Cluster, error) {
 	return &cluster, nil
 }
 
-func (c *Client) CreateCluster(cluster apiTypes.ClusterDefinition) error {
-	err := CreateCluster(cluster)
+func (c *Client) CreateCluster(ctx context.Context, cluster apiTypes.ClusterDefinition) error {
+	err := CreateCluster(ctx, cluster)
 	if err != nil {
 		return fmt.Errorf("failed to create cluster: %w", err)
 	}
@@ -49,8 +145,8 @@ func (c *Client) CreateCluster(cluster apiTypes.ClusterDefinition) error {
 	return nil
 }
 
-func (c *Client) ResetClusterProgress(clusterName string) error {
-	err := ResetClusterProgress(clusterName)
+func (c *Client) ResetClusterProgress(ctx context.Context, clusterName string) error {
+	err := ResetClusterProgress(ctx, clusterName)
 	if err != nil {
 		return fmt.Errorf("failed to reset cluster progress: %w", err)
 	}
@@ -58,7 +154,51 @@ func (c *Client) ResetClusterProgress(clusterName string) error {
 	return nil
 }
 
-func CreateCluster(cluster apiTypes.ClusterDefinition) error {
+func (c *Client) DeleteCluster(ctx context.Context, clusterName string) error {
+	err := DeleteCluster(ctx, clusterName)
+	if err != nil {
+		return fmt.Errorf("failed to delete cluster: %w", err)
+	}
+
+	return nil
+}
+
+func Ping(ctx context.Context) error {
+	customTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpClient := http.Client{Transport: customTransport}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/proxyHealth", GetConsoleIngressURL()), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		apiErr := newAPIError("check kubefirst-api health", res, body)
+		// any answer other than healthy means the api behind the proxy is not serving
+		apiErr.Err = ErrClusterUnreachable
+		return apiErr
+	}
+
+	return nil
+}
+
+func CreateCluster(ctx context.Context, cluster apiTypes.ClusterDefinition) error {
+	if err := readonly.Check(fmt.Sprintf("create cluster %q", cluster.ClusterName)); err != nil {
+		return err
+	}
+
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 	httpClient := http.Client{Transport: customTransport}
 
@@ -72,7 +212,7 @@ func CreateCluster(cluster apiTypes.ClusterDefinition) error {
 		return fmt.Errorf("failed to marshal request object: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/proxy", GetConsoleIngressURL()), bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/proxy", GetConsoleIngressURL()), bytes.NewReader(payload))
 	if err != nil {
 		log.Printf("error creating request: %s", err)
 		return fmt.Errorf("failed to create request: %w", err)
@@ -83,7 +223,7 @@ func CreateCluster(cluster apiTypes.ClusterDefinition) error {
 	res, err := httpClient.Do(req)
 	if err != nil {
 		log.Printf("error executing request: %s", err)
-		return fmt.Errorf("failed to execute request: %w", err)
+		return requestError(ctx, err)
 	}
 	defer res.Body.Close()
 
@@ -95,7 +235,7 @@ func CreateCluster(cluster apiTypes.ClusterDefinition) error {
 
 	if res.StatusCode != http.StatusAccepted {
 		log.Printf("unable to create cluster: %q %q", res.Status, body)
-		return fmt.Errorf("unable to create cluster: API returned unexpected status code %q: %s", res.Status, body)
+		return newAPIError(fmt.Sprintf("create cluster %q", cluster.ClusterName), res, body)
 	}
 
 	log.Printf("Created cluster: %q", string(body))
@@ -103,7 +243,11 @@ func CreateCluster(cluster apiTypes.ClusterDefinition) error {
 	return nil
 }
 
-func ResetClusterProgress(clusterName string) error {
+func ResetClusterProgress(ctx context.Context, clusterName string) error {
+	if err := readonly.Check(fmt.Sprintf("reset progress of cluster %q", clusterName)); err != nil {
+		return err
+	}
+
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 	httpClient := http.Client{Transport: customTransport}
 
@@ -116,7 +260,7 @@ func ResetClusterProgress(clusterName string) error {
 		return fmt.Errorf("failed to marshal request object: %w", err)
 	}
 
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/proxy", GetConsoleIngressURL()), bytes.NewReader(payload))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/api/proxy", GetConsoleIngressURL()), bytes.NewReader(payload))
 	if err != nil {
 		log.Printf("error creating request: %v", err)
 		return fmt.Errorf("failed to create request: %w", err)
@@ -127,13 +271,13 @@ func ResetClusterProgress(clusterName string) error {
 	res, err := httpClient.Do(req)
 	if err != nil {
 		log.Printf("error executing request: %v", err)
-		return fmt.Errorf("failed to execute request: %w", err)
+		return requestError(ctx, err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		log.Printf("unable to reset cluster progress: %q", res.Status)
-		return fmt.Errorf("unable to reset cluster progress: API returned unexpected status %q", res.Status)
+		return newAPIError(fmt.Sprintf("reset progress of cluster %q", clusterName), res, nil)
 	}
 
 	body, err := io.ReadAll(res.Body)
@@ -146,14 +290,12 @@ func ResetClusterProgress(clusterName string) error {
 	return nil
 }
 
-var ErrNotFound = fmt.Errorf("cluster not found")
-
-func GetCluster(clusterName string) (apiTypes.Cluster, error) {
+func GetCluster(ctx context.Context, clusterName string) (apiTypes.Cluster, error) {
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 	httpClient := http.Client{Transport: customTransport}
 
 	cluster := apiTypes.Cluster{}
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/proxy?url=/cluster/%s", GetConsoleIngressURL(), clusterName), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/proxy?url=/cluster/%s", GetConsoleIngressURL(), clusterName), nil)
 	if err != nil {
 		log.Printf("error creating request: %v", err)
 		return cluster, fmt.Errorf("failed to create request: %w", err)
@@ -164,18 +306,13 @@ func GetCluster(clusterName string) (apiTypes.Cluster, error) {
 	res, err := httpClient.Do(req)
 	if err != nil {
 		log.Printf("error executing request: %v", err)
-		return cluster, fmt.Errorf("failed to execute request: %w", err)
+		return cluster, requestError(ctx, err)
 	}
 	defer res.Body.Close()
 
-	switch res.StatusCode {
-	case http.StatusNotFound:
-		return cluster, ErrNotFound
-	case http.StatusOK:
-		// continue with the rest
-	default:
+	if res.StatusCode != http.StatusOK {
 		log.Printf("unable to get cluster: %q", res.Status)
-		return cluster, fmt.Errorf("unable to get cluster: %q", res.Status)
+		return cluster, newAPIError(fmt.Sprintf("get cluster %q", clusterName), res, nil)
 	}
 
 	body, err := io.ReadAll(res.Body)
@@ -193,12 +330,12 @@ func GetCluster(clusterName string) (apiTypes.Cluster, error) {
 	return cluster, nil
 }
 
-func GetClusters() ([]apiTypes.Cluster, error) {
+func GetClusters(ctx context.Context) ([]apiTypes.Cluster, error) {
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 	httpClient := http.Client{Transport: customTransport}
 
 	clusters := []apiTypes.Cluster{}
-	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/proxy?url=/cluster", GetConsoleIngressURL()), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/proxy?url=/cluster", GetConsoleIngressURL()), nil)
 	if err != nil {
 		log.Printf("error creating request: %v", err)
 		return clusters, fmt.Errorf("failed to create request: %w", err)
@@ -209,13 +346,13 @@ func GetClusters() ([]apiTypes.Cluster, error) {
 	res, err := httpClient.Do(req)
 	if err != nil {
 		log.Printf("error executing request: %v", err)
-		return clusters, fmt.Errorf("failed to execute request: %w", err)
+		return clusters, requestError(ctx, err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		log.Printf("unable to get clusters: %q", res.Status)
-		return clusters, fmt.Errorf("unable to get clusters: API returned unexpected status code %q", res.Status)
+		return clusters, newAPIError("list clusters", res, nil)
 	}
 
 	body, err := io.ReadAll(res.Body)
@@ -233,11 +370,15 @@ func GetClusters() ([]apiTypes.Cluster, error) {
 	return clusters, nil
 }
 
-func DeleteCluster(clusterName string) error {
+func DeleteCluster(ctx context.Context, clusterName string) error {
+	if err := readonly.Check(fmt.Sprintf("delete cluster %q", clusterName)); err != nil {
+		return err
+	}
+
 	customTransport := http.DefaultTransport.(*http.Transport).Clone()
 	httpClient := http.Client{Transport: customTransport}
 
-	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/api/proxy?url=/cluster/%s", GetConsoleIngressURL(), clusterName), nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("%s/api/proxy?url=/cluster/%s", GetConsoleIngressURL(), clusterName), nil)
 	if err != nil {
 		log.Printf("error creating request: %v", err)
 		return fmt.Errorf("failed to create request: %w", err)
@@ -248,13 +389,13 @@ func DeleteCluster(clusterName string) error {
 	res, err := httpClient.Do(req)
 	if err != nil {
 		log.Printf("error executing request: %v", err)
-		return fmt.Errorf("failed to execute request: %w", err)
+		return requestError(ctx, err)
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
 		log.Printf("unable to delete cluster: %q, continuing", res.Status)
-		return fmt.Errorf("unable to delete cluster: API returned unexpected status code %q", res.Status)
+		return newAPIError(fmt.Sprintf("delete cluster %q", clusterName), res, nil)
 	}
 
 	_, err = io.ReadAll(res.Body)
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func consoleURL(t *testing.T, url string) {
+	t.Helper()
+	t.Setenv("K1_LOCAL_DEBUG", "true")
+	t.Setenv("K1_CONSOLE_REMOTE_URL", url)
+}
+
+func TestClientErrors(t *testing.T) {
+	client := &Client{}
+	ctx := context.Background()
+
+	respond := func(code int) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(code)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		consoleURL(t, respond(http.StatusNotFound).URL)
+
+		_, err := client.GetCluster(ctx, "kubefirst")
+		require.ErrorIs(t, err, ErrClusterNotFound)
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	})
+
+	t.Run("already exists", func(t *testing.T) {
+		consoleURL(t, respond(http.StatusConflict).URL)
+
+		err := client.CreateCluster(ctx, apiTypes.ClusterDefinition{ClusterName: "kubefirst"})
+		require.ErrorIs(t, err, ErrClusterAlreadyExists)
+		assert.Contains(t, Hint(err), "--resume-from")
+	})
+
+	t.Run("other status", func(t *testing.T) {
+		consoleURL(t, respond(http.StatusBadRequest).URL)
+
+		err := client.DeleteCluster(ctx, "kubefirst")
+		var apiErr *APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.NoError(t, apiErr.Err)
+		assert.Empty(t, Hint(err))
+	})
+
+	t.Run("ping", func(t *testing.T) {
+		consoleURL(t, respond(http.StatusOK).URL)
+		require.NoError(t, client.Ping(ctx))
+
+		consoleURL(t, respond(http.StatusInternalServerError).URL)
+		require.ErrorIs(t, client.Ping(ctx), ErrClusterUnreachable)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+		listener.Close()
+		consoleURL(t, "http://"+address)
+
+		_, err = client.GetCluster(ctx, "kubefirst")
+		require.ErrorIs(t, err, ErrClusterUnreachable)
+		require.ErrorIs(t, client.Ping(ctx), ErrClusterUnreachable)
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err = client.GetCluster(canceled, "kubefirst")
+		require.ErrorIs(t, err, context.Canceled)
+		assert.NotErrorIs(t, err, ErrClusterUnreachable)
+	})
+}
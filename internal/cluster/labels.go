@@ -0,0 +1,115 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// LabeledCluster is a cluster record with the labels it was created with
+type LabeledCluster struct {
+	apiTypes.Cluster
+	Labels map[string]string
+	// Parent is the management cluster of a workload cluster
+	Parent string
+}
+
+// ValidateClusterLabels ensures every label is a valid Kubernetes label, so
+// selectors match them the way they match Kubernetes objects
+func ValidateClusterLabels(clusterLabels map[string]string) error {
+	var problems []string
+	for _, key := range sortedLabelKeys(clusterLabels) {
+		for _, problem := range validation.IsQualifiedName(key) {
+			problems = append(problems, fmt.Sprintf("key %q: %s", key, problem))
+		}
+		for _, problem := range validation.IsValidLabelValue(clusterLabels[key]) {
+			problems = append(problems, fmt.Sprintf("value %q of %q: %s", clusterLabels[key], key, problem))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// FormatClusterLabels renders clusterLabels as key=value pairs sorted by key
+func FormatClusterLabels(clusterLabels map[string]string) string {
+	return labels.Set(clusterLabels).String()
+}
+
+// SetClusterLabels records the labels of clusterName in its kubefirst-api
+// metadata secret, replacing the ones it had. Empty labels remove them
+func (c *Client) SetClusterLabels(ctx context.Context, clusterName string, clusterLabels map[string]string) error {
+	err := SetClusterLabels(ctx, clusterName, clusterLabels)
+	if err != nil {
+		return fmt.Errorf("failed to set cluster labels: %w", err)
+	}
+
+	return nil
+}
+
+// ListClusters returns the clusters whose labels match selector
+func (c *Client) ListClusters(ctx context.Context, selector labels.Selector) ([]LabeledCluster, error) {
+	clusters, err := ListClusters(ctx, selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list clusters: %w", err)
+	}
+
+	return clusters, nil
+}
+
+func SetClusterLabels(ctx context.Context, clusterName string, clusterLabels map[string]string) error {
+	metadata, exists, err := getClusterMetadata(ctx, clusterName)
+	if err != nil {
+		return err
+	}
+	if !exists && len(clusterLabels) == 0 {
+		return nil
+	}
+
+	metadata.Labels = clusterLabels
+	return putClusterMetadata(ctx, clusterName, metadata, exists)
+}
+
+func ListClusters(ctx context.Context, selector labels.Selector) ([]LabeledCluster, error) {
+	clusters, err := GetClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []LabeledCluster
+	for _, cluster := range clusters {
+		metadata, _, err := getClusterMetadata(ctx, cluster.ClusterName)
+		if err != nil {
+			return nil, err
+		}
+		if selector.Matches(labels.Set(metadata.Labels)) {
+			matching = append(matching, LabeledCluster{Cluster: cluster, Labels: metadata.Labels, Parent: metadata.Parent})
+		}
+	}
+
+	return matching, nil
+}
+
+func sortedLabelKeys(clusterLabels map[string]string) []string {
+	keys := make([]string, 0, len(clusterLabels))
+	for key := range clusterLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
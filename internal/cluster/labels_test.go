@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// fakeConsole serves the kubefirst-api proxy of the console for clusters,
+// keeping the cluster secrets written through it by url
+func fakeConsole(t *testing.T, clusters []apiTypes.Cluster) map[string]json.RawMessage {
+	t.Helper()
+
+	secrets := map[string]json.RawMessage{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			url := r.URL.Query().Get("url")
+			switch {
+			case url == "/cluster":
+				json.NewEncoder(w).Encode(clusters)
+			case strings.HasPrefix(url, "/secret/"):
+				if secret, ok := secrets[url]; ok {
+					w.Write(secret)
+				} else {
+					w.Write([]byte("{}"))
+				}
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+
+		var request struct {
+			URL  string          `json:"url"`
+			Body json.RawMessage `json:"body"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		_, exists := secrets[request.URL]
+		if (r.Method == http.MethodPost && exists) || (r.Method == http.MethodPut && !exists) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		secrets[request.URL] = request.Body
+	}))
+	t.Cleanup(server.Close)
+	consoleURL(t, server.URL)
+
+	return secrets
+}
+
+func TestClusterLabels(t *testing.T) {
+	ctx := context.Background()
+	secrets := fakeConsole(t, []apiTypes.Cluster{{ClusterName: "homelab"}, {ClusterName: "edge"}, {ClusterName: "lab"}})
+	client := &Client{}
+
+	require.NoError(t, client.SetClusterLabels(ctx, "homelab", map[string]string{"env": "prod", "kubefirst.konstruct.io/Team": "platform"}))
+	require.NoError(t, client.SetClusterLabels(ctx, "edge", map[string]string{"env": "dev"}))
+	require.NoError(t, client.SetClusterLabels(ctx, "edge", map[string]string{"env": "prod"}))
+	require.NoError(t, client.SetClusterLabels(ctx, "lab", nil))
+	assert.NotContains(t, secrets, "/secret/lab/kubefirst-metadata-lab")
+
+	selector, err := labels.Parse("env=prod")
+	require.NoError(t, err)
+	clusters, err := client.ListClusters(ctx, selector)
+	require.NoError(t, err)
+	require.Len(t, clusters, 2)
+	assert.Equal(t, "homelab", clusters[0].ClusterName)
+	assert.Equal(t, map[string]string{"env": "prod", "kubefirst.konstruct.io/Team": "platform"}, clusters[0].Labels)
+	assert.Equal(t, "edge", clusters[1].ClusterName)
+
+	require.NoError(t, client.SetClusterLabels(ctx, "edge", nil))
+	clusters, err = client.ListClusters(ctx, selector)
+	require.NoError(t, err)
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "homelab", clusters[0].ClusterName)
+
+	clusters, err = client.ListClusters(ctx, labels.Everything())
+	require.NoError(t, err)
+	assert.Len(t, clusters, 3)
+}
+
+func TestValidateClusterLabels(t *testing.T) {
+	require.NoError(t, ValidateClusterLabels(map[string]string{"env": "prod", "kubefirst.konstruct.io/team": "platform"}))
+
+	err := ValidateClusterLabels(map[string]string{"env": "prod!", "bad key": "x"})
+	require.ErrorContains(t, err, `key "bad key"`)
+	require.ErrorContains(t, err, `value "prod!" of "env"`)
+	assert.Equal(t, "env=prod,team=platform", FormatClusterLabels(map[string]string{"team": "platform", "env": "prod"}))
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/konstructio/kubefirst/internal/readonly"
+	"github.com/konstructio/kubefirst/internal/types"
+)
+
+// metadataSecretPrefix names the kubefirst-api cluster secret holding what
+// the cluster record has no field for. kubefirst-api keeps it next to the
+// record, so every CLI talking to the same kubefirst-api sees it
+const metadataSecretPrefix = "kubefirst-metadata-"
+
+// clusterMetadata is the content of the metadata secret of a cluster
+type clusterMetadata struct {
+	Labels map[string]string `json:"labels"`
+	// Parent is the management cluster a workload cluster was registered
+	// with
+	Parent string `json:"parent"`
+}
+
+// metadataURL is the kubefirst-api path of the metadata secret of
+// clusterName
+func metadataURL(clusterName string) string {
+	return fmt.Sprintf("/secret/%s/%s%s", clusterName, metadataSecretPrefix, clusterName)
+}
+
+// getClusterMetadata reads the metadata secret of clusterName, reporting
+// false when it has none. kubefirst-api answers a missing secret with an
+// empty object
+func getClusterMetadata(ctx context.Context, clusterName string) (clusterMetadata, bool, error) {
+	customTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpClient := http.Client{Transport: customTransport}
+
+	metadata := clusterMetadata{}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/api/proxy?url=%s", GetConsoleIngressURL(), metadataURL(clusterName)), nil)
+	if err != nil {
+		log.Printf("error creating request: %v", err)
+		return metadata, false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("error executing request: %v", err)
+		return metadata, false, requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		log.Printf("unable to read response body: %v", err)
+		return metadata, false, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		log.Printf("unable to get cluster metadata: %q", res.Status)
+		return metadata, false, newAPIError(fmt.Sprintf("get metadata of cluster %q", clusterName), res, body)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return metadata, false, fmt.Errorf("failed to unmarshal cluster metadata: %w", err)
+	}
+	if len(fields) == 0 {
+		return metadata, false, nil
+	}
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return metadata, false, fmt.Errorf("failed to unmarshal cluster metadata: %w", err)
+	}
+
+	return metadata, true, nil
+}
+
+// putClusterMetadata writes the metadata secret of clusterName, creating it
+// unless it exists
+func putClusterMetadata(ctx context.Context, clusterName string, metadata clusterMetadata, exists bool) error {
+	if err := readonly.Check(fmt.Sprintf("record metadata of cluster %q", clusterName)); err != nil {
+		return err
+	}
+
+	customTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpClient := http.Client{Transport: customTransport}
+
+	// empty labels are written too, the secret never ends up empty and is
+	// told apart from a missing one
+	if metadata.Labels == nil {
+		metadata.Labels = map[string]string{}
+	}
+	requestObject := types.ProxyClusterSecretRequest{
+		Body: metadata,
+		URL:  metadataURL(clusterName),
+	}
+
+	payload, err := json.Marshal(requestObject)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request object: %w", err)
+	}
+
+	method := http.MethodPost
+	if exists {
+		method = http.MethodPut
+	}
+	req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s/api/proxy", GetConsoleIngressURL()), bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("error creating request: %v", err)
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("error executing request: %v", err)
+		return requestError(ctx, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		log.Printf("unable to read response body: %v", err)
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		log.Printf("unable to record cluster metadata: %q %q", res.Status, body)
+		return newAPIError(fmt.Sprintf("record metadata of cluster %q", clusterName), res, body)
+	}
+
+	return nil
+}
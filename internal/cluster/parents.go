@@ -0,0 +1,90 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// SetParentCluster records parent in the kubefirst-api metadata secret of
+// clusterName as the management cluster it was registered with. An empty
+// parent removes it
+func (c *Client) SetParentCluster(ctx context.Context, clusterName, parent string) error {
+	err := SetParentCluster(ctx, clusterName, parent)
+	if err != nil {
+		return fmt.Errorf("failed to set parent cluster: %w", err)
+	}
+
+	return nil
+}
+
+// ParentCluster returns the management cluster of clusterName, false when
+// it is not a workload cluster
+func (c *Client) ParentCluster(ctx context.Context, clusterName string) (string, bool, error) {
+	parent, ok, err := ParentCluster(ctx, clusterName)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get parent cluster: %w", err)
+	}
+
+	return parent, ok, nil
+}
+
+// ChildClusters returns the workload clusters registered with parent,
+// sorted by name
+func (c *Client) ChildClusters(ctx context.Context, parent string) ([]string, error) {
+	children, err := ChildClusters(ctx, parent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list child clusters: %w", err)
+	}
+
+	return children, nil
+}
+
+func SetParentCluster(ctx context.Context, clusterName, parent string) error {
+	metadata, exists, err := getClusterMetadata(ctx, clusterName)
+	if err != nil {
+		return err
+	}
+	if !exists && parent == "" {
+		return nil
+	}
+
+	metadata.Parent = parent
+	return putClusterMetadata(ctx, clusterName, metadata, exists)
+}
+
+func ParentCluster(ctx context.Context, clusterName string) (string, bool, error) {
+	metadata, _, err := getClusterMetadata(ctx, clusterName)
+	if err != nil {
+		return "", false, err
+	}
+
+	return metadata.Parent, metadata.Parent != "", nil
+}
+
+func ChildClusters(ctx context.Context, parent string) ([]string, error) {
+	clusters, err := GetClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var children []string
+	for _, cluster := range clusters {
+		metadata, _, err := getClusterMetadata(ctx, cluster.ClusterName)
+		if err != nil {
+			return nil, err
+		}
+		if metadata.Parent == parent {
+			children = append(children, cluster.ClusterName)
+		}
+	}
+	sort.Strings(children)
+
+	return children, nil
+}
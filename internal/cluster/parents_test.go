@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParentClusters(t *testing.T) {
+	ctx := context.Background()
+	fakeConsole(t, []apiTypes.Cluster{{ClusterName: "homelab"}, {ClusterName: "edge"}, {ClusterName: "lab"}})
+	client := &Client{}
+
+	require.NoError(t, client.SetClusterLabels(ctx, "edge", map[string]string{"env": "prod"}))
+	require.NoError(t, client.SetParentCluster(ctx, "edge", "homelab"))
+	require.NoError(t, client.SetParentCluster(ctx, "lab", "homelab"))
+
+	parent, ok, err := client.ParentCluster(ctx, "edge")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "homelab", parent)
+
+	_, ok, err = client.ParentCluster(ctx, "homelab")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	children, err := client.ChildClusters(ctx, "homelab")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"edge", "lab"}, children)
+
+	clusters, err := client.ListClusters(ctx, labels.Everything())
+	require.NoError(t, err)
+	require.Len(t, clusters, 3)
+	assert.Equal(t, "homelab", clusters[1].Parent)
+	assert.Equal(t, map[string]string{"env": "prod"}, clusters[1].Labels)
+
+	require.NoError(t, client.SetParentCluster(ctx, "lab", ""))
+	children, err = client.ChildClusters(ctx, "homelab")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"edge"}, children)
+}
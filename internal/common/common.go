@@ -109,9 +109,12 @@ func GetRootCredentials(cmd *cobra.Command, _ []string) error {
 
 	clusterName := viper.GetString("flags.cluster-name")
 
-	cluster, err := cluster.GetCluster(clusterName)
+	clusterRecord, err := cluster.GetCluster(cmd.Context(), clusterName)
 	if err != nil {
 		wrerr := fmt.Errorf("failed to get cluster: %w", err)
+		if hint := cluster.Hint(err); hint != "" {
+			wrerr = fmt.Errorf("failed to get cluster %q, %s: %w", clusterName, hint, err)
+		}
 		stepper.FailCurrentStep(wrerr)
 		return wrerr
 	}
@@ -125,13 +128,13 @@ func GetRootCredentials(cmd *cobra.Command, _ []string) error {
 ### :bulb: Keep this data secure. These passwords can be used to access the following applications in your platform
 
 ## ArgoCD Admin Password
-##### ` + cluster.ArgoCDPassword + `
+##### ` + clusterRecord.ArgoCDPassword + `
 
 ## KBot User Password
-##### ` + cluster.VaultAuth.KbotPassword + `
+##### ` + clusterRecord.VaultAuth.KbotPassword + `
 
 ## Vault Root Token
-##### ` + cluster.VaultAuth.RootToken + `
+##### ` + clusterRecord.VaultAuth.RootToken + `
 `
 	stepper.InfoStep(step.EmojiBulb, progress.RenderMessage(header))
 
@@ -110,7 +110,7 @@ func InitializeGitProvider(p *GitInitParameters) error {
 	return nil
 }
 
-func ValidateGitCredentials(gitProviderFlag, githubOrgFlag, gitlabGroupFlag string) (types.GitAuth, error) {
+func ValidateGitCredentials(gitProviderFlag, githubOrgFlag, gitlabGroupFlag string, httpClient *http.Client) (types.GitAuth, error) {
 	gitAuth := types.GitAuth{}
 
 	switch gitProviderFlag {
@@ -130,7 +130,6 @@ func ValidateGitCredentials(gitProviderFlag, githubOrgFlag, gitlabGroupFlag stri
 			return gitAuth, fmt.Errorf("error verifying GitHub token permissions: %w", err)
 		}
 
-		httpClient := http.DefaultClient
 		gitHubService := services.NewGitHubService(httpClient)
 		gitHubHandler := handlers.NewGitHubHandler(gitHubService)
 
@@ -0,0 +1,238 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	ArgoCDNamespace = "argocd"
+
+	// DefaultPhaseTimeout is how long a provisioning phase may wait for its
+	// ArgoCD applications to become Healthy/Synced
+	DefaultPhaseTimeout = 20 * time.Minute
+
+	applicationPollInterval = 5 * time.Second
+)
+
+var (
+	ErrArgoCDUnreachable   = errors.New("argocd is not reachable")
+	ErrApplicationNotFound = errors.New("no matching argocd application found")
+)
+
+// ListApplications returns the ArgoCD applications whose name matches the
+// glob pattern, sorted by name
+func (c *Client) ListApplications(ctx context.Context, pattern string) ([]v1alpha1.Application, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid application pattern %q: %w", pattern, err)
+	}
+
+	apps, err := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if isConnectionError(err) || apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: %w", ErrArgoCDUnreachable, err)
+		}
+		return nil, fmt.Errorf("failed to list argocd applications: %w", err)
+	}
+
+	matched := []v1alpha1.Application{}
+	for _, app := range apps.Items {
+		if ok, _ := path.Match(pattern, app.Name); ok {
+			matched = append(matched, app)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("%w for pattern %q", ErrApplicationNotFound, pattern)
+	}
+
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+
+	return matched, nil
+}
+
+// SyncApplication requests a hard refresh of the application and, unless an
+// operation is already running, triggers a sync
+func (c *Client) SyncApplication(ctx context.Context, name string) error {
+	apps := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		app, err := apps.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if app.Annotations == nil {
+			app.Annotations = map[string]string{}
+		}
+		app.Annotations[v1alpha1.AnnotationKeyRefresh] = string(v1alpha1.RefreshTypeHard)
+
+		if app.Operation == nil {
+			app.Operation = &v1alpha1.Operation{
+				Sync:        &v1alpha1.SyncOperation{},
+				InitiatedBy: v1alpha1.OperationInitiator{Username: "kubefirst"},
+			}
+		}
+
+		_, err = apps.Update(ctx, app, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		if isConnectionError(err) {
+			return fmt.Errorf("%w: %w", ErrArgoCDUnreachable, err)
+		}
+		if apierrors.IsNotFound(err) {
+			return fmt.Errorf("%w: %q", ErrApplicationNotFound, name)
+		}
+		return fmt.Errorf("failed to sync application %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// WaitForApplications blocks until every named application is Synced and
+// Healthy, a sync operation fails, or the context is done
+func (c *Client) WaitForApplications(ctx context.Context, names []string) error {
+	pending := map[string]bool{}
+	for _, name := range names {
+		pending[name] = true
+	}
+
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		failures := []string{}
+
+		for name := range pending {
+			app, err := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				if isConnectionError(err) {
+					return fmt.Errorf("%w: %w", ErrArgoCDUnreachable, err)
+				}
+				return fmt.Errorf("failed to get application %q: %w", name, err)
+			}
+
+			if IsApplicationReady(app) {
+				delete(pending, name)
+				continue
+			}
+
+			if msg, failed := applicationSyncFailure(app); failed {
+				failures = append(failures, fmt.Sprintf("%s: %s", name, msg))
+			}
+		}
+
+		if len(failures) > 0 {
+			sort.Strings(failures)
+			return fmt.Errorf("sync failed for %d application(s): %s", len(failures), strings.Join(failures, "; "))
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %d application(s) to become Healthy/Synced: %w", len(pending), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForPhaseApplications blocks until the ArgoCD applications of target
+// exist and are all Synced and Healthy, a sync operation fails, or the
+// context is done. progress is told the ready and total number of
+// applications whenever they change
+func (c *Client) WaitForPhaseApplications(ctx context.Context, target PhaseTarget, progress func(ready, total int)) error {
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	lastReady, lastTotal := -1, -1
+	for {
+		apps, err := c.ListApplications(ctx, "*")
+		if err != nil && !errors.Is(err, ErrApplicationNotFound) {
+			return err
+		}
+
+		var pending, failures []string
+		total := 0
+		for i := range apps {
+			if !target.Matches(&apps[i]) {
+				continue
+			}
+
+			total++
+			if IsApplicationReady(&apps[i]) {
+				continue
+			}
+			pending = append(pending, apps[i].Name)
+			if msg, failed := applicationSyncFailure(&apps[i]); failed {
+				failures = append(failures, fmt.Sprintf("%s: %s", apps[i].Name, msg))
+			}
+		}
+
+		if len(failures) > 0 {
+			return fmt.Errorf("sync failed for %d application(s): %s", len(failures), strings.Join(failures, "; "))
+		}
+
+		ready := total - len(pending)
+		if progress != nil && (ready != lastReady || total != lastTotal) {
+			progress(ready, total)
+			lastReady, lastTotal = ready, total
+		}
+		// generated applications appear one by one
+		if total > 0 && total >= target.Expected && len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if total == 0 {
+				return fmt.Errorf("timed out waiting for %s to be created: %w", target, ctx.Err())
+			}
+			if total < target.Expected {
+				return fmt.Errorf("timed out waiting for %s to be created, %d of %d exist: %w", target, total, target.Expected, ctx.Err())
+			}
+			return fmt.Errorf("timed out waiting for %s to become Healthy/Synced: %w", strings.Join(pending, ", "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// IsApplicationReady reports whether the application is Synced and Healthy
+func IsApplicationReady(app *v1alpha1.Application) bool {
+	return app.Status.Sync.Status == v1alpha1.SyncStatusCodeSynced &&
+		app.Status.Health.Status == health.HealthStatusHealthy
+}
+
+func applicationSyncFailure(app *v1alpha1.Application) (string, bool) {
+	state := app.Status.OperationState
+	if state == nil || app.Operation != nil {
+		return "", false
+	}
+
+	if state.Phase == synccommon.OperationFailed || state.Phase == synccommon.OperationError {
+		return state.Message, true
+	}
+
+	return "", false
+}
@@ -0,0 +1,162 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	synccommon "github.com/argoproj/gitops-engine/pkg/sync/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newApplication(name string, sync v1alpha1.SyncStatusCode, healthStatus health.HealthStatusCode) *v1alpha1.Application {
+	return &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ArgoCDNamespace},
+		Status: v1alpha1.ApplicationStatus{
+			Sync:   v1alpha1.SyncStatus{Status: sync},
+			Health: v1alpha1.HealthStatus{Status: healthStatus},
+		},
+	}
+}
+
+func TestListApplications(t *testing.T) {
+	client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+		newApplication("vault", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy),
+		newApplication("vcluster-dev", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy),
+		newApplication("vcluster-prod", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusProgressing),
+	)}
+
+	t.Run("matches every application by default", func(t *testing.T) {
+		apps, err := client.ListApplications(context.Background(), "*")
+		require.NoError(t, err)
+		assert.Len(t, apps, 3)
+	})
+
+	t.Run("filters by glob", func(t *testing.T) {
+		apps, err := client.ListApplications(context.Background(), "vcluster-*")
+		require.NoError(t, err)
+		require.Len(t, apps, 2)
+		assert.Equal(t, "vcluster-dev", apps[0].Name)
+	})
+
+	t.Run("returns not found when nothing matches", func(t *testing.T) {
+		_, err := client.ListApplications(context.Background(), "istio*")
+		require.ErrorIs(t, err, ErrApplicationNotFound)
+	})
+}
+
+func TestSyncApplication(t *testing.T) {
+	client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+		newApplication("vault", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusHealthy),
+	)}
+
+	require.NoError(t, client.SyncApplication(context.Background(), "vault"))
+
+	app, err := client.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Get(context.Background(), "vault", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, string(v1alpha1.RefreshTypeHard), app.Annotations[v1alpha1.AnnotationKeyRefresh])
+	require.NotNil(t, app.Operation)
+	assert.NotNil(t, app.Operation.Sync)
+
+	err = client.SyncApplication(context.Background(), "missing")
+	require.ErrorIs(t, err, ErrApplicationNotFound)
+}
+
+func TestWaitForApplications(t *testing.T) {
+	t.Run("returns once all applications are ready", func(t *testing.T) {
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+			newApplication("vault", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy),
+		)}
+
+		require.NoError(t, client.WaitForApplications(context.Background(), []string{"vault"}))
+	})
+
+	t.Run("reports failed sync operations", func(t *testing.T) {
+		app := newApplication("vault", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusDegraded)
+		app.Status.OperationState = &v1alpha1.OperationState{Phase: synccommon.OperationFailed, Message: "boom"}
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(app)}
+
+		err := client.WaitForApplications(context.Background(), []string{"vault"})
+		require.ErrorContains(t, err, "vault: boom")
+	})
+
+	t.Run("honors the context deadline", func(t *testing.T) {
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+			newApplication("vault", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusProgressing),
+		)}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := client.WaitForApplications(ctx, []string{"vault"})
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestWaitForPhaseApplications(t *testing.T) {
+	target := PhaseTargets(PhaseVCluster, []string{"dev"}, false, false)
+
+	t.Run("returns once the phase applications are ready", func(t *testing.T) {
+		vcluster := newApplication("vcluster-dev", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy)
+		vcluster.Spec.Destination.Namespace = VClusterNamespace("dev")
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+			newApplication("platform-vcluster", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy),
+			vcluster,
+			newApplication("vault", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusProgressing),
+		)}
+
+		var progress []int
+		err := client.WaitForPhaseApplications(context.Background(), target, func(ready, total int) {
+			progress = append(progress, ready, total)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []int{2, 2}, progress)
+	})
+
+	t.Run("names the pending applications on timeout", func(t *testing.T) {
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+			newApplication("platform-vcluster", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusProgressing),
+		)}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := client.WaitForPhaseApplications(ctx, target, nil)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.ErrorContains(t, err, "platform-vcluster")
+	})
+
+	t.Run("waits for the applications to be created", func(t *testing.T) {
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset()}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := client.WaitForPhaseApplications(ctx, target, nil)
+		assert.ErrorContains(t, err, "to be created")
+	})
+
+	t.Run("waits for every application of the applicationset", func(t *testing.T) {
+		generated := func(vcluster string) *v1alpha1.Application {
+			app := newApplication(VClusterNamespace(vcluster), v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy)
+			app.OwnerReferences = []metav1.OwnerReference{{Kind: "ApplicationSet", Name: VClusterAppSet}}
+			return app
+		}
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(generated("dev"))}
+		appSetTarget := PhaseTargets(PhaseVCluster, []string{"dev", "prod"}, false, true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := client.WaitForPhaseApplications(ctx, appSetTarget, nil)
+		assert.ErrorContains(t, err, "1 of 2 exist")
+
+		client = &Client{ArgoCD: argocdfake.NewSimpleClientset(generated("dev"), generated("prod"))}
+		require.NoError(t, client.WaitForPhaseApplications(context.Background(), appSetTarget, nil))
+	})
+}
@@ -0,0 +1,296 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// Object stores Velero backs up to, as accepted by --backup-storage
+const (
+	BackupS3        = "s3"
+	BackupGCS       = "gcs"
+	BackupAzureBlob = "azure-blob"
+)
+
+var BackupStorages = []string{BackupS3, BackupGCS, BackupAzureBlob}
+
+const (
+	// VeleroCatalogApp is the gitops catalog application installing Velero
+	// with its node agent. It reads the plugin of the object store from
+	// VeleroProviderEnv
+	VeleroCatalogApp  = "velero"
+	VeleroNamespace   = "velero"
+	VeleroProviderEnv = "VELERO_PROVIDER"
+
+	// BackupStorageLocationName is the default location of Velero, the one
+	// backups without a storage location go to
+	BackupStorageLocationName = "default"
+	BackupScheduleName        = "kubefirst"
+	DefaultBackupTTL          = "720h"
+
+	veleroCredentialsSecret = "kubefirst-velero-credentials"
+	veleroCredentialsKey    = "cloud"
+)
+
+// backupEnv lists the environment variables every object store reads its
+// settings and credentials from, the ones the Velero plugins document
+var backupEnv = map[string][]string{
+	BackupS3:        {"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_REGION"},
+	BackupGCS:       {"GOOGLE_APPLICATION_CREDENTIALS"},
+	BackupAzureBlob: {"AZURE_SUBSCRIPTION_ID", "AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_RESOURCE_GROUP", "AZURE_STORAGE_ACCOUNT_ID"},
+}
+
+// veleroProviders are the Velero plugins of the object stores
+var veleroProviders = map[string]string{
+	BackupS3:        "aws",
+	BackupGCS:       "gcp",
+	BackupAzureBlob: "azure",
+}
+
+// BackupConfig is the object store Velero backs up to and the schedule it
+// backs up on. Credentials is the credentials file of the Velero plugin,
+// stored in a secret next to Velero; Config is written into the
+// BackupStorageLocation
+type BackupConfig struct {
+	Storage     string
+	Bucket      string
+	Prefix      string
+	Schedule    string
+	TTL         string
+	Config      map[string]string
+	Credentials string
+}
+
+// ValidateBackupStorage ensures storage is a supported object store
+func ValidateBackupStorage(storage string) error {
+	if slices.Contains(BackupStorages, storage) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown backup storage %q, must be one of %v", storage, BackupStorages)
+}
+
+// VeleroProvider returns the Velero plugin of storage
+func VeleroProvider(storage string) string {
+	return veleroProviders[storage]
+}
+
+// ValidateBackupSchedule checks schedule is a cron expression of five
+// fields: minute, hour, day of month, month and day of week
+func ValidateBackupSchedule(schedule string) error {
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return fmt.Errorf("%q is not a cron expression of 5 fields, e.g. \"0 2 * * *\"", schedule)
+	}
+
+	names := []string{"minute", "hour", "day of month", "month", "day of week"}
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	for i, field := range fields {
+		if err := validateCronField(field, bounds[i][0], bounds[i][1]); err != nil {
+			return fmt.Errorf("invalid %s %q in %q: %w", names[i], field, schedule, err)
+		}
+	}
+
+	return nil
+}
+
+func validateCronField(field string, low, high int) error {
+	for _, item := range strings.Split(field, ",") {
+		valueRange, step, stepped := strings.Cut(item, "/")
+		if stepped {
+			if n, err := strconv.Atoi(step); err != nil || n <= 0 {
+				return fmt.Errorf("step %q is not a positive number", step)
+			}
+		}
+		if valueRange == "*" {
+			continue
+		}
+
+		first, last, isRange := strings.Cut(valueRange, "-")
+		values := []string{first}
+		if isRange {
+			values = append(values, last)
+		}
+		parsed := make([]int, 0, len(values))
+		for _, value := range values {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < low || n > high {
+				return fmt.Errorf("%q is not a number from %d to %d", value, low, high)
+			}
+			parsed = append(parsed, n)
+		}
+		if isRange && parsed[0] > parsed[1] {
+			return fmt.Errorf("range %q ends before it starts", valueRange)
+		}
+	}
+
+	return nil
+}
+
+// ValidateBackupTTL checks ttl is a positive duration, such as 720h
+func ValidateBackupTTL(ttl string) error {
+	duration, err := time.ParseDuration(ttl)
+	if err != nil {
+		return fmt.Errorf("%q is not a duration, e.g. %s", ttl, DefaultBackupTTL)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("%q keeps no backup", ttl)
+	}
+
+	return nil
+}
+
+// BackupFromEnv reads the settings and credentials of storage from the
+// environment, naming every variable that is not set. AWS_ENDPOINT_URL
+// points the s3 storage at an S3 compatible store such as MinIO
+func BackupFromEnv(storage, bucket, prefix, schedule, ttl string) (BackupConfig, error) {
+	if err := ValidateBackupStorage(storage); err != nil {
+		return BackupConfig{}, err
+	}
+
+	var missing []string
+	env := map[string]string{}
+	for _, name := range backupEnv[storage] {
+		value := os.Getenv(name)
+		if value == "" {
+			missing = append(missing, name)
+		}
+		env[name] = value
+	}
+	if len(missing) > 0 {
+		return BackupConfig{}, fmt.Errorf("backup storage %s requires %s to be set", storage, strings.Join(missing, ", "))
+	}
+
+	config := BackupConfig{Storage: storage, Bucket: bucket, Prefix: strings.Trim(prefix, "/"), Schedule: schedule, TTL: ttl}
+	switch storage {
+	case BackupS3:
+		config.Config = map[string]string{"region": env["AWS_REGION"]}
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			config.Config["s3Url"] = endpoint
+			config.Config["s3ForcePathStyle"] = "true"
+		}
+		config.Credentials = fmt.Sprintf("[default]\naws_access_key_id=%s\naws_secret_access_key=%s\n", env["AWS_ACCESS_KEY_ID"], env["AWS_SECRET_ACCESS_KEY"])
+	case BackupGCS:
+		key, err := os.ReadFile(env["GOOGLE_APPLICATION_CREDENTIALS"])
+		if err != nil {
+			return BackupConfig{}, fmt.Errorf("unable to read GOOGLE_APPLICATION_CREDENTIALS file: %w", err)
+		}
+		config.Credentials = string(key)
+	case BackupAzureBlob:
+		config.Config = map[string]string{
+			"resourceGroup":  env["AZURE_RESOURCE_GROUP"],
+			"storageAccount": env["AZURE_STORAGE_ACCOUNT_ID"],
+			"subscriptionId": env["AZURE_SUBSCRIPTION_ID"],
+		}
+		var credentials strings.Builder
+		for _, name := range []string{"AZURE_SUBSCRIPTION_ID", "AZURE_TENANT_ID", "AZURE_CLIENT_ID", "AZURE_CLIENT_SECRET", "AZURE_RESOURCE_GROUP"} {
+			fmt.Fprintf(&credentials, "%s=%s\n", name, env[name])
+		}
+		credentials.WriteString("AZURE_CLOUD_NAME=AzurePublicCloud\n")
+		config.Credentials = credentials.String()
+	}
+
+	return config, nil
+}
+
+// BackupManifests renders the BackupStorageLocation of config, reading the
+// credentials ApplyBackupCredentials stores, and the Schedule backing up
+// every namespace with its volume data to it. They sync after the Velero
+// CRDs of the catalog app exist
+func BackupManifests(config BackupConfig) ([]byte, error) {
+	if err := ValidateBackupStorage(config.Storage); err != nil {
+		return nil, err
+	}
+
+	annotations := map[string]interface{}{
+		"argocd.argoproj.io/sync-wave":    "1",
+		"argocd.argoproj.io/sync-options": "SkipDryRunOnMissingResource=true",
+	}
+
+	objectStorage := map[string]interface{}{"bucket": config.Bucket}
+	if config.Prefix != "" {
+		objectStorage["prefix"] = config.Prefix
+	}
+	location := map[string]interface{}{
+		"provider":      VeleroProvider(config.Storage),
+		"default":       true,
+		"objectStorage": objectStorage,
+		"credential":    map[string]interface{}{"name": veleroCredentialsSecret, "key": veleroCredentialsKey},
+	}
+	if len(config.Config) > 0 {
+		location["config"] = config.Config
+	}
+
+	documents := []map[string]interface{}{
+		{
+			"apiVersion": "velero.io/v1",
+			"kind":       "BackupStorageLocation",
+			"metadata":   map[string]interface{}{"name": BackupStorageLocationName, "namespace": VeleroNamespace, "annotations": annotations},
+			"spec":       location,
+		},
+		{
+			"apiVersion": "velero.io/v1",
+			"kind":       "Schedule",
+			"metadata":   map[string]interface{}{"name": BackupScheduleName, "namespace": VeleroNamespace, "annotations": annotations},
+			"spec": map[string]interface{}{
+				"schedule":                   config.Schedule,
+				"useOwnerReferencesInBackup": false,
+				"template": map[string]interface{}{
+					"ttl":                      config.TTL,
+					"storageLocation":          BackupStorageLocationName,
+					"includedNamespaces":       []string{"*"},
+					"defaultVolumesToFsBackup": true,
+				},
+			},
+		},
+	}
+
+	var rendered []string
+	for _, document := range documents {
+		manifest, err := yaml.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render velero %s: %w", document["kind"], err)
+		}
+		rendered = append(rendered, string(manifest))
+	}
+
+	return []byte(strings.Join(rendered, "---\n")), nil
+}
+
+// ApplyBackupCredentials stores the credentials file of config where the
+// BackupStorageLocation reads it. It is kept out of the gitops repository
+func (c *Client) ApplyBackupCredentials(ctx context.Context, config BackupConfig) error {
+	// the namespace of Velero may not be synced yet
+	namespace := corev1apply.Namespace(VeleroNamespace)
+	if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, namespace, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return fmt.Errorf("failed to apply namespace %s: %w", VeleroNamespace, err)
+	}
+
+	secret := corev1apply.Secret(veleroCredentialsSecret, VeleroNamespace).
+		WithStringData(map[string]string{veleroCredentialsKey: config.Credentials})
+
+	_, err := c.Clientset.CoreV1().Secrets(VeleroNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", VeleroNamespace, veleroCredentialsSecret, err)
+	}
+
+	return nil
+}
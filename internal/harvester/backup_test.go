@@ -0,0 +1,85 @@
+package harvester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateBackupSchedule(t *testing.T) {
+	for _, schedule := range []string{"0 2 * * *", "*/15 * * * 1-5", "30 3 1,15 * 0"} {
+		require.NoError(t, ValidateBackupSchedule(schedule), schedule)
+	}
+
+	require.ErrorContains(t, ValidateBackupSchedule("@daily"), "is not a cron expression of 5 fields")
+	require.ErrorContains(t, ValidateBackupSchedule("0 24 * * *"), "invalid hour")
+	require.ErrorContains(t, ValidateBackupSchedule("0 2 * * 5-1"), "ends before it starts")
+	require.ErrorContains(t, ValidateBackupSchedule("*/0 * * * *"), "is not a positive number")
+
+	require.NoError(t, ValidateBackupTTL(DefaultBackupTTL))
+	require.Error(t, ValidateBackupTTL("30d"))
+}
+
+func TestBackupFromEnv(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err := BackupFromEnv(BackupS3, "backups", "", "0 2 * * *", DefaultBackupTTL)
+	require.ErrorContains(t, err, "AWS_ACCESS_KEY_ID")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ENDPOINT_URL", "https://minio.internal")
+	config, err := BackupFromEnv(BackupS3, "backups", "/homelab/", "0 2 * * *", DefaultBackupTTL)
+	require.NoError(t, err)
+	assert.Equal(t, "homelab", config.Prefix)
+	assert.Equal(t, map[string]string{"region": "us-east-1", "s3Url": "https://minio.internal", "s3ForcePathStyle": "true"}, config.Config)
+	assert.Contains(t, config.Credentials, "aws_secret_access_key=secret")
+
+	key := filepath.Join(t.TempDir(), "key.json")
+	require.NoError(t, os.WriteFile(key, []byte(`{"type":"service_account"}`), 0o600))
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", key)
+	config, err = BackupFromEnv(BackupGCS, "backups", "", "0 2 * * *", DefaultBackupTTL)
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"service_account"}`, config.Credentials)
+
+	_, err = BackupFromEnv("nfs", "backups", "", "0 2 * * *", DefaultBackupTTL)
+	require.ErrorContains(t, err, "unknown backup storage")
+}
+
+func TestBackupManifests(t *testing.T) {
+	manifests, err := BackupManifests(BackupConfig{
+		Storage:  BackupAzureBlob,
+		Bucket:   "velero",
+		Prefix:   "homelab",
+		Schedule: "0 2 * * *",
+		TTL:      "168h",
+		Config:   map[string]string{"resourceGroup": "backups"},
+	})
+	require.NoError(t, err)
+	documents, err := decodeDocuments(manifests)
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+
+	location, schedule := documents[0], documents[1]
+	assert.Equal(t, "azure", lookupValue(location, "spec", "provider"))
+	assert.Equal(t, "homelab", lookupValue(location, "spec", "objectStorage", "prefix"))
+	assert.Equal(t, veleroCredentialsSecret, lookupValue(location, "spec", "credential", "name"))
+	assert.Equal(t, "0 2 * * *", lookupValue(schedule, "spec", "schedule"))
+	assert.Equal(t, "168h", lookupValue(schedule, "spec", "template", "ttl"))
+	assert.Equal(t, true, lookupValue(schedule, "spec", "template", "defaultVolumesToFsBackup"))
+}
+
+func TestApplyBackupCredentials(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset()}
+	require.NoError(t, client.ApplyBackupCredentials(context.Background(), BackupConfig{Credentials: "[default]\n"}))
+
+	secret, err := client.Clientset.CoreV1().Secrets(VeleroNamespace).Get(context.Background(), veleroCredentialsSecret, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "[default]\n", secret.StringData[veleroCredentialsKey])
+}
@@ -0,0 +1,34 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import "time"
+
+// Budget holds the deadline of every wait of a provisioning run, so the
+// waits are bounded in one place rather than at each call. The deadlines
+// only shorten the context of the run, cancelling it still stops every wait
+type Budget struct {
+	ControlPlaneQuorum time.Duration
+	LBAllocation       time.Duration
+	DNSPropagation     time.Duration
+	PlatformHealth     time.Duration
+	GPUSmokeTest       time.Duration
+	VaultAuditRollout  time.Duration
+}
+
+// NewBudget returns the deadlines of a provisioning run whose final platform
+// verification gets verifyTimeout
+func NewBudget(verifyTimeout time.Duration) Budget {
+	return Budget{
+		ControlPlaneQuorum: DefaultPhaseTimeout,
+		LBAllocation:       DefaultLBAllocationTimeout,
+		DNSPropagation:     DefaultDNSPropagationTimeout,
+		PlatformHealth:     verifyTimeout,
+		GPUSmokeTest:       DefaultGPUSmokeTestTimeout,
+		VaultAuditRollout:  DefaultPhaseTimeout,
+	}
+}
@@ -0,0 +1,387 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const (
+	// BundleLockfile is the bundle entry recording every other entry
+	BundleLockfile = "bundle.lock"
+	// BundleCLI is the bundle entry holding the kubefirst binary
+	BundleCLI = "kubefirst"
+	// BundleRunScript is the bundle entry that runs create from the bundle
+	BundleRunScript = "run.sh"
+	// BundleGitopsDir holds the snapshot of the gitops template
+	BundleGitopsDir = "gitops/"
+)
+
+// BundleRunScriptContent runs create against the bundle it is extracted
+// from, passing its arguments through
+const BundleRunScriptContent = `#!/bin/sh
+set -eu
+dir="$(cd "$(dirname "$0")" && pwd)"
+exec "$dir/kubefirst" harvester create --from-bundle "$dir" "$@"
+`
+
+// BundleLock records the content of a bootstrap bundle
+type BundleLock struct {
+	CLIVersion           string       `json:"cliVersion"`
+	CreatedAt            time.Time    `json:"createdAt"`
+	GitopsTemplateURL    string       `json:"gitopsTemplateURL"`
+	GitopsTemplateBranch string       `json:"gitopsTemplateBranch"`
+	GitopsTemplateCommit string       `json:"gitopsTemplateCommit"`
+	Images               []string     `json:"images"`
+	Files                []BundleFile `json:"files"`
+	// LowBandwidth records a bundle built with --low-bandwidth, create
+	// --from-bundle honors it and pulls SlimImages in place of the images
+	// they replace
+	LowBandwidth bool              `json:"lowBandwidth,omitempty"`
+	SlimImages   map[string]string `json:"slimImages,omitempty"`
+}
+
+// BundleFile is an entry of a bootstrap bundle
+type BundleFile struct {
+	Path   string `json:"path"`
+	Mode   int64  `json:"mode"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BundleEntry is the content of a file written into a bootstrap bundle
+type BundleEntry struct {
+	Path    string
+	Mode    int64
+	Content []byte
+}
+
+// Bundle is a bootstrap bundle read back from disk. Only the gitops
+// template is kept in memory, the other entries are only hashed
+type Bundle struct {
+	Lock   BundleLock
+	Gitops map[string][]byte
+	files  map[string]BundleFile
+	locked bool
+}
+
+// SnapshotGitopsTemplate shallow clones the gitops template into memory,
+// returning its files keyed by path and the commit they are at
+func SnapshotGitopsTemplate(ctx context.Context, templateURL, branch, proxy string) (map[string][]byte, string, error) {
+	tlsOptions := currentTLSOptions()
+	options := &git.CloneOptions{
+		URL:             templateURL,
+		Depth:           1,
+		SingleBranch:    true,
+		ProxyOptions:    gitProxyOptions(proxy, templateURL),
+		CABundle:        tlsOptions.CABundle,
+		InsecureSkipTLS: tlsOptions.InsecureSkipVerify,
+	}
+	if branch != "" {
+		options.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	}
+
+	worktree := memfs.New()
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), worktree, options)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to clone gitops template %q: %w", templateURL, err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to resolve the commit of gitops template %q: %w", templateURL, err)
+	}
+
+	files := map[string][]byte{}
+	err = util.Walk(worktree, "/", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		content, err := util.ReadFile(worktree, name)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", name, err)
+		}
+		files[strings.TrimPrefix(name, "/")] = content
+		return nil
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read gitops template %q: %w", templateURL, err)
+	}
+
+	return files, head.Hash().String(), nil
+}
+
+var imageLine = regexp.MustCompile(`^\s*(?:-\s+)?image:\s*["']?([^\s"'#]+)`)
+
+// BundleImages returns the container images the manifests in files
+// reference, sorted. Images assembled from chart values are not covered
+func BundleImages(files map[string][]byte) []string {
+	seen := map[string]bool{}
+	for name, content := range files {
+		if path.Ext(name) != ".yaml" && path.Ext(name) != ".yml" {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if match := imageLine.FindStringSubmatch(line); match != nil && !strings.Contains(match[1], "<") {
+				seen[match[1]] = true
+			}
+		}
+	}
+
+	images := make([]string, 0, len(seen))
+	for image := range seen {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+
+	return images
+}
+
+// WriteBundle writes entries as a tar archive to w, preceded by the
+// lockfile recording them
+func WriteBundle(w io.Writer, lock BundleLock, entries []BundleEntry) error {
+	lock.Files = make([]BundleFile, 0, len(entries))
+	for _, entry := range entries {
+		sum := sha256.Sum256(entry.Content)
+		lock.Files = append(lock.Files, BundleFile{
+			Path:   entry.Path,
+			Mode:   entry.Mode,
+			Size:   int64(len(entry.Content)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	encoded, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle lockfile: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	entries = append([]BundleEntry{{Path: BundleLockfile, Mode: 0o644, Content: encoded}}, entries...)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.Path,
+			Mode:    entry.Mode,
+			Size:    int64(len(entry.Content)),
+			ModTime: lock.CreatedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write %q to bundle: %w", entry.Path, err)
+		}
+		if _, err := tw.Write(entry.Content); err != nil {
+			return fmt.Errorf("failed to write %q to bundle: %w", entry.Path, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+
+	return nil
+}
+
+// OpenBundle reads the bundle at bundlePath, either the archive written by
+// WriteBundle or the directory it was extracted to
+func OpenBundle(bundlePath string) (*Bundle, error) {
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+
+	if !info.IsDir() {
+		f, err := os.Open(bundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bundle: %w", err)
+		}
+		defer f.Close()
+
+		return ReadBundle(f)
+	}
+
+	bundle := newBundle()
+	err = filepath.WalkDir(bundlePath, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.Type().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(bundlePath, name)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return bundle.add(filepath.ToSlash(rel), int64(info.Mode().Perm()), f)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle %q: %w", bundlePath, err)
+	}
+
+	if err := bundle.checkLocked(); err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+// ReadBundle reads the archive written by WriteBundle
+func ReadBundle(r io.Reader) (*Bundle, error) {
+	bundle := newBundle()
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := bundle.add(header.Name, header.Mode, tr); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := bundle.checkLocked(); err != nil {
+		return nil, err
+	}
+
+	return bundle, nil
+}
+
+func newBundle() *Bundle {
+	return &Bundle{Gitops: map[string][]byte{}, files: map[string]BundleFile{}}
+}
+
+// add hashes the bundle entry name, keeping the lockfile and the gitops
+// template
+func (b *Bundle) add(name string, mode int64, r io.Reader) error {
+	hash := sha256.New()
+	var content io.Writer = hash
+	var kept bytes.Buffer
+	if name == BundleLockfile || strings.HasPrefix(name, BundleGitopsDir) {
+		content = io.MultiWriter(hash, &kept)
+	}
+
+	size, err := io.Copy(content, r)
+	if err != nil {
+		return fmt.Errorf("failed to read %q from bundle: %w", name, err)
+	}
+
+	switch {
+	case name == BundleLockfile:
+		if err := json.Unmarshal(kept.Bytes(), &b.Lock); err != nil {
+			return fmt.Errorf("failed to parse bundle lockfile: %w", err)
+		}
+		b.locked = true
+		return nil
+	case strings.HasPrefix(name, BundleGitopsDir):
+		b.Gitops[strings.TrimPrefix(name, BundleGitopsDir)] = kept.Bytes()
+	}
+
+	b.files[name] = BundleFile{
+		Path:   name,
+		Mode:   mode,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	return nil
+}
+
+func (b *Bundle) checkLocked() error {
+	if !b.locked {
+		return fmt.Errorf("bundle has no %s", BundleLockfile)
+	}
+
+	return nil
+}
+
+// Verify checks the bundle holds exactly the files its lockfile records,
+// including the CLI, the run script and a gitops template
+func (b *Bundle) Verify() error {
+	var errs []error
+
+	locked := map[string]bool{}
+	for _, file := range b.Lock.Files {
+		locked[file.Path] = true
+
+		actual, ok := b.files[file.Path]
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s is missing", file.Path))
+		case actual.SHA256 != file.SHA256 || actual.Size != file.Size:
+			errs = append(errs, fmt.Errorf("%s does not match its checksum", file.Path))
+		}
+	}
+
+	var extra []string
+	for name := range b.files {
+		if !locked[name] {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	for _, name := range extra {
+		errs = append(errs, fmt.Errorf("%s is not in the lockfile", name))
+	}
+
+	for _, required := range []string{BundleCLI, BundleRunScript} {
+		if !locked[required] {
+			errs = append(errs, fmt.Errorf("the lockfile does not record %s", required))
+		}
+	}
+	if len(b.Gitops) == 0 {
+		errs = append(errs, errors.New("the bundle has no gitops template"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// PathExists reports whether repoPath exists in the gitops template of the
+// bundle, matched like TemplatePathExists
+func (b *Bundle) PathExists(repoPath, clusterName string) bool {
+	return templateFilesContain(b.Gitops, repoPath, clusterName)
+}
@@ -0,0 +1,57 @@
+package harvester
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	lock := BundleLock{
+		CLIVersion:           "v2.8.0",
+		CreatedAt:            time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+		GitopsTemplateURL:    "https://github.com/konstructio/gitops-template.git",
+		GitopsTemplateCommit: "0123abc",
+	}
+	entries := []BundleEntry{
+		{Path: BundleCLI, Mode: 0o755, Content: []byte("binary")},
+		{Path: BundleRunScript, Mode: 0o755, Content: []byte(BundleRunScriptContent)},
+		{Path: BundleGitopsDir + "registry/<CLUSTER_NAME>/registry.yaml", Mode: 0o644, Content: []byte("kind: Application\n")},
+	}
+
+	var archive bytes.Buffer
+	require.NoError(t, WriteBundle(&archive, lock, entries))
+
+	bundle, err := ReadBundle(bytes.NewReader(archive.Bytes()))
+	require.NoError(t, err)
+	require.NoError(t, bundle.Verify())
+	assert.Equal(t, "0123abc", bundle.Lock.GitopsTemplateCommit)
+	assert.Len(t, bundle.Lock.Files, 3)
+	assert.True(t, bundle.PathExists("registry/kubefirst", "kubefirst"))
+	assert.False(t, bundle.PathExists("registry/other", "kubefirst"))
+
+	entries[0].Content = []byte("tampered")
+	var tampered bytes.Buffer
+	require.NoError(t, WriteBundle(&tampered, lock, entries[:2]))
+
+	bundle, err = ReadBundle(bytes.NewReader(tampered.Bytes()))
+	require.NoError(t, err)
+	bundle.Lock.Files[0].SHA256 = "0000"
+	err = bundle.Verify()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "kubefirst does not match its checksum")
+	assert.Contains(t, err.Error(), "no gitops template")
+}
+
+func TestBundleImages(t *testing.T) {
+	files := map[string][]byte{
+		"a/deployment.yaml": []byte("containers:\n  - name: app\n    image: ghcr.io/konstructio/app:v1 # pinned\n  - image: \"nginx:1.27\"\n"),
+		"b/values.yaml":     []byte("image: <IMAGE>\n"),
+		"README.md":         []byte("image: not-a-manifest\n"),
+	}
+
+	assert.Equal(t, []string{"ghcr.io/konstructio/app:v1", "nginx:1.27"}, BundleImages(files))
+}
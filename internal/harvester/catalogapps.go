@@ -0,0 +1,259 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// CatalogAppsSecretName is the state secret recording the install
+	// status of every catalog app, in the namespace of the gitops history
+	CatalogAppsSecretName = "kubefirst-catalog-apps"
+	catalogAppsKey        = "apps.json"
+)
+
+// Catalog app install statuses
+const (
+	CatalogAppPending = "pending"
+	CatalogAppHealthy = "healthy"
+	CatalogAppFailed  = "failed"
+)
+
+// OrderCatalogApps sorts names so that every app comes after the apps
+// dependsOn lists for it, apps free to go in any order going by name.
+// Dependencies that are not in names are ignored
+func OrderCatalogApps(names []string, dependsOn map[string][]string) ([]string, error) {
+	remaining := map[string][]string{}
+	for _, name := range names {
+		var deps []string
+		for _, dep := range dependsOn[name] {
+			if dep != name && slices.Contains(names, dep) && !slices.Contains(deps, dep) {
+				deps = append(deps, dep)
+			}
+		}
+		remaining[name] = deps
+	}
+
+	ordered := make([]string, 0, len(remaining))
+	for len(remaining) > 0 {
+		var ready []string
+		for name, deps := range remaining {
+			if !slices.ContainsFunc(deps, func(dep string) bool { _, ok := remaining[dep]; return ok }) {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			return nil, fmt.Errorf("catalog apps %s depend on each other", strings.Join(sortedKeys(remaining), ", "))
+		}
+
+		slices.Sort(ready)
+		for _, name := range ready {
+			delete(remaining, name)
+		}
+		ordered = append(ordered, ready...)
+	}
+
+	return ordered, nil
+}
+
+// CatalogAppRecord is the install status of a catalog app, Message telling
+// why it failed
+type CatalogAppRecord struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// CatalogAppsState lists the catalog apps in install order
+type CatalogAppsState []CatalogAppRecord
+
+// Record sets the status of the app of record, keeping its position. New
+// apps go last
+func (s CatalogAppsState) Record(record CatalogAppRecord) CatalogAppsState {
+	for i := range s {
+		if s[i].Name == record.Name {
+			s[i] = record
+			return s
+		}
+	}
+
+	return append(s, record)
+}
+
+// Status returns the recorded status of name, pending when none is recorded
+func (s CatalogAppsState) Status(name string) string {
+	for _, record := range s {
+		if record.Name == name {
+			return record.Status
+		}
+	}
+
+	return CatalogAppPending
+}
+
+// Remaining returns the apps of order that are not recorded as healthy,
+// the ones a rerun installs again
+func (s CatalogAppsState) Remaining(order []string) []string {
+	var remaining []string
+	for _, name := range order {
+		if s.Status(name) != CatalogAppHealthy {
+			remaining = append(remaining, name)
+		}
+	}
+
+	return remaining
+}
+
+// Select returns the records of the apps of order, in order, the apps
+// without one pending
+func (s CatalogAppsState) Select(order []string) CatalogAppsState {
+	selected := make(CatalogAppsState, 0, len(order))
+	for _, name := range order {
+		record := CatalogAppRecord{Name: name, Status: CatalogAppPending}
+		for _, recorded := range s {
+			if recorded.Name == name {
+				record = recorded
+			}
+		}
+		selected = append(selected, record)
+	}
+
+	return selected
+}
+
+// Render renders the status of every app as a table
+func (s CatalogAppsState) Render() string {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CATALOG APP\tSTATUS\tMESSAGE")
+	for _, record := range s {
+		message := record.Message
+		if message == "" {
+			message = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", record.Name, record.Status, message)
+	}
+	w.Flush()
+
+	return b.String()
+}
+
+// CatalogAppStatuses returns the live status of the ArgoCD application of
+// every app of names, in order: healthy once Synced and Healthy, failed
+// when its sync failed, pending otherwise, including when ArgoCD is not
+// installed yet
+func (c *Client) CatalogAppStatuses(ctx context.Context, names []string) (CatalogAppsState, error) {
+	now := time.Now().UTC()
+
+	state := make(CatalogAppsState, 0, len(names))
+	for _, name := range names {
+		record := CatalogAppRecord{Name: name, Status: CatalogAppPending, Time: now}
+
+		app, err := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Get(ctx, name, metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+		case err != nil && isConnectionError(err):
+			return nil, fmt.Errorf("%w: %w", ErrArgoCDUnreachable, err)
+		case err != nil:
+			return nil, fmt.Errorf("failed to get application %q: %w", name, err)
+		case IsApplicationReady(app):
+			record.Status = CatalogAppHealthy
+		default:
+			if message, failed := applicationSyncFailure(app); failed {
+				record.Status, record.Message = CatalogAppFailed, message
+			}
+		}
+		state = append(state, record)
+	}
+
+	return state, nil
+}
+
+// LoadCatalogAppsState reads the catalog app statuses from the state
+// secret, returning an empty state when none was recorded yet
+func (c *Client) LoadCatalogAppsState(ctx context.Context) (CatalogAppsState, error) {
+	secret, err := c.Clientset.CoreV1().Secrets(GitopsHistorySecretNamespace).Get(ctx, CatalogAppsSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", GitopsHistorySecretNamespace, CatalogAppsSecretName, err)
+	}
+
+	var state CatalogAppsState
+	if data := secret.Data[catalogAppsKey]; len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, fmt.Errorf("failed to parse %s in secret %s/%s: %w", catalogAppsKey, GitopsHistorySecretNamespace, CatalogAppsSecretName, err)
+		}
+	}
+
+	return state, nil
+}
+
+// SaveCatalogAppsState writes state to the state secret
+func (c *Client) SaveCatalogAppsState(ctx context.Context, state CatalogAppsState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode catalog app state: %w", err)
+	}
+
+	namespace := corev1apply.Namespace(GitopsHistorySecretNamespace)
+	if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, namespace, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return fmt.Errorf("failed to apply namespace %s: %w", GitopsHistorySecretNamespace, err)
+	}
+
+	secret := corev1apply.Secret(CatalogAppsSecretName, GitopsHistorySecretNamespace).
+		WithData(map[string][]byte{catalogAppsKey: data})
+
+	_, err = c.Clientset.CoreV1().Secrets(GitopsHistorySecretNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", GitopsHistorySecretNamespace, CatalogAppsSecretName, err)
+	}
+
+	return nil
+}
+
+// RecordCatalogApps records the live status of the apps of order in the
+// state secret, on top of the apps recorded by earlier runs, and returns
+// the records of order. Without ArgoCD the recorded statuses are kept
+func (c *Client) RecordCatalogApps(ctx context.Context, order []string) (CatalogAppsState, error) {
+	state, err := c.LoadCatalogAppsState(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	live, err := c.CatalogAppStatuses(ctx, order)
+	if err != nil && !errors.Is(err, ErrArgoCDUnreachable) {
+		return nil, err
+	}
+	for _, record := range live {
+		state = state.Record(record)
+	}
+
+	if err := c.SaveCatalogAppsState(ctx, state); err != nil {
+		return nil, err
+	}
+
+	return state.Select(order), nil
+}
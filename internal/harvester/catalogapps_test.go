@@ -0,0 +1,75 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOrderCatalogApps(t *testing.T) {
+	ordered, err := OrderCatalogApps(
+		[]string{"kyverno", "datadog", ExternalSecretsCatalogApp, "argo-workflows"},
+		map[string][]string{
+			"datadog":        {ExternalSecretsCatalogApp},
+			"argo-workflows": {ExternalSecretsCatalogApp, "cert-manager"},
+		},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ExternalSecretsCatalogApp, "kyverno", "argo-workflows", "datadog"}, ordered)
+
+	_, err = OrderCatalogApps([]string{"a", "b", "c"}, map[string][]string{"a": {"b"}, "b": {"a"}})
+	require.ErrorContains(t, err, "catalog apps a, b depend on each other")
+}
+
+func TestCatalogAppsState(t *testing.T) {
+	state := CatalogAppsState{}.
+		Record(CatalogAppRecord{Name: "datadog", Status: CatalogAppFailed, Message: "sync failed"}).
+		Record(CatalogAppRecord{Name: "kyverno", Status: CatalogAppHealthy}).
+		Record(CatalogAppRecord{Name: "datadog", Status: CatalogAppHealthy})
+
+	require.Len(t, state, 2)
+	assert.Equal(t, CatalogAppHealthy, state.Status("datadog"))
+	assert.Equal(t, CatalogAppPending, state.Status("grafana"))
+	assert.Equal(t, []string{"grafana"}, state.Remaining([]string{"kyverno", "grafana", "datadog"}))
+
+	selected := state.Select([]string{"grafana", "kyverno"})
+	assert.Equal(t, "CATALOG APP  STATUS   MESSAGE\ngrafana      pending  -\nkyverno      healthy  -\n", selected.Render())
+}
+
+func TestRecordCatalogApps(t *testing.T) {
+	failed := newApplication("datadog", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusDegraded)
+	failed.Status.OperationState = &v1alpha1.OperationState{Phase: "Failed", Message: "one or more objects failed to apply"}
+	client := &Client{
+		Clientset: fake.NewClientset(),
+		ArgoCD: argocdfake.NewSimpleClientset(
+			newApplication("kyverno", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy),
+			failed,
+		),
+	}
+	ctx := context.Background()
+
+	state, err := client.RecordCatalogApps(ctx, []string{"kyverno", "datadog", "grafana"})
+	require.NoError(t, err)
+	require.Len(t, state, 3)
+	assert.Equal(t, CatalogAppHealthy, state[0].Status)
+	assert.Equal(t, CatalogAppFailed, state[1].Status)
+	assert.Contains(t, state[1].Message, "failed to apply")
+	assert.Equal(t, CatalogAppPending, state[2].Status)
+
+	recorded, err := client.LoadCatalogAppsState(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, state, recorded)
+
+	state, err = client.RecordCatalogApps(ctx, []string{"kyverno"})
+	require.NoError(t, err)
+	assert.Len(t, state, 1)
+	recorded, err = client.LoadCatalogAppsState(ctx)
+	require.NoError(t, err)
+	assert.Len(t, recorded, 3)
+}
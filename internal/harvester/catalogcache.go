@@ -0,0 +1,86 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CatalogAppsCacheFile caches the names of the gitops catalog apps for the
+// completion of --install-catalog-apps
+const CatalogAppsCacheFile = "catalog-apps.json"
+
+// CatalogAppsCacheTTL is how long the cached names are completed before the
+// catalog is read again
+const CatalogAppsCacheTTL = 15 * time.Minute
+
+// catalogAppsCache is the content of CatalogAppsCacheFile
+type catalogAppsCache struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Apps      []string  `json:"apps"`
+}
+
+// DefaultCatalogAppsCachePath returns $HOME/.k1/harvester/catalog-apps.json,
+// shared by the profiles as the catalog is
+func DefaultCatalogAppsCachePath() (string, error) {
+	homePath, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	return filepath.Join(homePath, ".k1", "harvester", CatalogAppsCacheFile), nil
+}
+
+// CachedCatalogApps returns the catalog app names cached at path until they
+// are older than CatalogAppsCacheTTL at now, the names fetch returns
+// otherwise, cached for the next call. When fetch fails, offline for one,
+// the stale names are returned, if any
+func CachedCatalogApps(ctx context.Context, path string, now time.Time, fetch func(ctx context.Context) ([]string, error)) ([]string, error) {
+	var cache catalogAppsCache
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read catalog apps cache: %w", err)
+	default:
+		// a corrupt cache is read again from the catalog
+		if json.Unmarshal(data, &cache) != nil {
+			cache = catalogAppsCache{}
+		}
+	}
+	if cache.Apps != nil && now.Sub(cache.FetchedAt) < CatalogAppsCacheTTL {
+		return cache.Apps, nil
+	}
+
+	apps, err := fetch(ctx)
+	if err != nil {
+		if cache.Apps != nil {
+			return cache.Apps, nil
+		}
+		return nil, err
+	}
+
+	data, err = json.Marshal(catalogAppsCache{FetchedAt: now, Apps: apps})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode catalog apps cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create catalog apps cache directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write catalog apps cache: %w", err)
+	}
+
+	return apps, nil
+}
@@ -0,0 +1,52 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedCatalogApps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "harvester", CatalogAppsCacheFile)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	fetches := 0
+	fetch := func(context.Context) ([]string, error) {
+		fetches++
+		return []string{"datadog", "kyverno"}, nil
+	}
+	offline := func(context.Context) ([]string, error) {
+		return nil, fmt.Errorf("test error")
+	}
+
+	_, err := CachedCatalogApps(context.Background(), path, now, offline)
+	require.Error(t, err)
+
+	apps, err := CachedCatalogApps(context.Background(), path, now, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"datadog", "kyverno"}, apps)
+
+	apps, err = CachedCatalogApps(context.Background(), path, now.Add(CatalogAppsCacheTTL-time.Second), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"datadog", "kyverno"}, apps)
+	assert.Equal(t, 1, fetches, "read the catalog again while the cache is fresh")
+
+	apps, err = CachedCatalogApps(context.Background(), path, now.Add(time.Hour), offline)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"datadog", "kyverno"}, apps, "the stale cache is completed offline")
+
+	_, err = CachedCatalogApps(context.Background(), path, now.Add(time.Hour), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, fetches)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err = CachedCatalogApps(context.Background(), path, now.Add(time.Hour), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 3, fetches)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+
+	argocdapi "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned"
+	"github.com/konstructio/kubefirst/internal/readonly"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Client talks to the Harvester management cluster using its kubeconfig
+type Client struct {
+	Clientset  kubernetes.Interface
+	Dynamic    dynamic.Interface
+	ArgoCD     argocdapi.Interface
+	RestConfig *rest.Config
+	HTTPClient *http.Client
+	// Retry retries the git provider and Cloudflare API calls of the
+	// repositories and DNS zones the Client hands out
+	Retry APIRetry
+	// RegistryMirror is the --registry-mirror the workloads the Client
+	// creates pull their images from
+	RegistryMirror string
+	// LargeFiles bounds the files committed to the gitops repositories the
+	// Client hands out
+	LargeFiles LargeFilePolicy
+	// Manifests validates the manifests committed to the gitops
+	// repositories the Client hands out
+	Manifests ManifestValidation
+
+	proxy string
+}
+
+// NewClient builds a Client from kubeContext of the kubeconfig at
+// kubeconfigPath, or from its current-context when kubeContext is empty;
+// environment variables such as $HOME in the path are expanded. The
+// kubeconfig set with SetKubeconfig takes precedence over the path. Every
+// connection the Client opens is routed through proxy as described by
+// ProxyFunc, and every mutating request fails while read-only mode is
+// enabled. Mutating requests are exported to the ManifestExport of the
+// process and only dry-run in dry-run mode
+func NewClient(kubeconfigPath, kubeContext, proxy string) (*Client, error) {
+	loader, location := clientConfig(kubeconfigPath, kubeContext)
+
+	restConfig, err := loader.ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %q: %w", location, err)
+	}
+
+	restConfig.Proxy, err = ProxyFunc(proxy)
+	if err != nil {
+		return nil, err
+	}
+	restConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &exportTransport{next: rt}
+	})
+	restConfig.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return &readonly.RoundTripper{Next: rt}
+	})
+
+	httpClient, err := NewHTTPClient(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+
+	argocdClient, err := argocdapi.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArgoCD client: %w", err)
+	}
+
+	return &Client{
+		Clientset:  clientset,
+		Dynamic:    dynamicClient,
+		ArgoCD:     argocdClient,
+		RestConfig: restConfig,
+		HTTPClient: httpClient,
+		proxy:      proxy,
+	}, nil
+}
+
+// isConnectionError reports whether err was caused by the API server
+// being unreachable rather than by the request itself
+func isConnectionError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CloudflareAPIURL is the base url of the Cloudflare API
+const CloudflareAPIURL = "https://api.cloudflare.com/client/v4/"
+
+// CloudflareDNS updates the platform records in the Cloudflare zone of the
+// domain, authenticated with the CF_API_TOKEN kubefirst-api created them
+// with
+type CloudflareDNS struct {
+	Retry APIRetry
+
+	token      string
+	apiURL     string
+	httpClient *http.Client
+}
+
+// DNSRecord is an A record, or the CNAME of a Cloudflare Tunnel, in a
+// Cloudflare zone
+type DNSRecord struct {
+	ZoneID  string
+	ID      string
+	Type    string
+	Name    string
+	Content string
+	// Comment tags the records create manages, see ManagedRecordComment
+	Comment string
+}
+
+// NewCloudflareDNS returns a CloudflareDNS using token over httpClient
+func NewCloudflareDNS(token string, httpClient *http.Client) (*CloudflareDNS, error) {
+	if token == "" {
+		return nil, fmt.Errorf("your CF_API_TOKEN environment variable is not set. Please set and try again")
+	}
+
+	return &CloudflareDNS{token: token, apiURL: CloudflareAPIURL, httpClient: httpClient}, nil
+}
+
+type cloudflareResponse struct {
+	Success bool              `json:"success"`
+	Errors  []json.RawMessage `json:"errors"`
+	Result  json.RawMessage   `json:"result"`
+}
+
+// ARecord returns the A record for host, looking its zone up from the
+// longest matching parent domain
+func (d *CloudflareDNS) ARecord(ctx context.Context, host string) (*DNSRecord, error) {
+	zoneID, err := d.zoneID(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := d.aRecords(ctx, zoneID, url.Values{"name": {host}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the A record of %q: %w", host, err)
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no A record for %q in cloudflare", host)
+	}
+
+	return &records[0], nil
+}
+
+// UpdateARecord points record at address
+func (d *CloudflareDNS) UpdateARecord(ctx context.Context, record *DNSRecord, address string) error {
+	body := map[string]string{"content": address}
+	if err := d.request(ctx, "Cloudflare record update", http.MethodPatch, fmt.Sprintf("zones/%s/dns_records/%s", record.ZoneID, record.ID), body, nil); err != nil {
+		return fmt.Errorf("failed to point %q at %s: %w", record.Name, address, err)
+	}
+
+	return nil
+}
+
+// aRecords lists the A records of zoneID matching query
+func (d *CloudflareDNS) aRecords(ctx context.Context, zoneID string, query url.Values) ([]DNSRecord, error) {
+	query.Set("type", "A")
+
+	return d.records(ctx, zoneID, query)
+}
+
+// records lists the records of zoneID matching query, of every type unless
+// query sets one
+func (d *CloudflareDNS) records(ctx context.Context, zoneID string, query url.Values) ([]DNSRecord, error) {
+	var records []struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Name    string `json:"name"`
+		Content string `json:"content"`
+		Comment string `json:"comment"`
+	}
+	if err := d.request(ctx, "Cloudflare record lookup", http.MethodGet, fmt.Sprintf("zones/%s/dns_records?%s", zoneID, query.Encode()), nil, &records); err != nil {
+		return nil, err
+	}
+
+	found := make([]DNSRecord, 0, len(records))
+	for _, record := range records {
+		found = append(found, DNSRecord{ZoneID: zoneID, ID: record.ID, Type: record.Type, Name: record.Name, Content: record.Content, Comment: record.Comment})
+	}
+
+	return found, nil
+}
+
+// ZoneRecords lists the A records of the zones serving hosts
+func (d *CloudflareDNS) ZoneRecords(ctx context.Context, hosts []string) ([]DNSRecord, error) {
+	zones := map[string]bool{}
+	for _, host := range hosts {
+		zoneID, err := d.zoneID(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		zones[zoneID] = true
+	}
+
+	var records []DNSRecord
+	for _, zoneID := range sortedKeys(zones) {
+		zoneRecords, err := d.aRecords(ctx, zoneID, url.Values{"per_page": {"5000"}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the records of zone %s: %w", zoneID, err)
+		}
+		records = append(records, zoneRecords...)
+	}
+
+	return records, nil
+}
+
+func (d *CloudflareDNS) zoneID(ctx context.Context, host string) (string, error) {
+	labels := strings.Split(strings.TrimPrefix(strings.TrimSuffix(host, "."), "*."), ".")
+	for i := 0; i < len(labels)-1; i++ {
+		var zones []struct {
+			ID string `json:"id"`
+		}
+		query := url.Values{"name": {strings.Join(labels[i:], ".")}}
+		if err := d.request(ctx, "Cloudflare zone lookup", http.MethodGet, "zones?"+query.Encode(), nil, &zones); err != nil {
+			return "", fmt.Errorf("failed to look up the cloudflare zone of %q: %w", host, err)
+		}
+		if len(zones) > 0 {
+			return zones[0].ID, nil
+		}
+	}
+
+	return "", fmt.Errorf("no cloudflare zone found for %q", host)
+}
+
+// request calls the Cloudflare API endpoint at path, retrying transient
+// failures. Looking records up, updating and deleting them is idempotent,
+// creations go through DoUnlessExists
+func (d *CloudflareDNS) request(ctx context.Context, operation, method, path string, body, out interface{}) error {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to encode cloudflare request: %w", err)
+		}
+	}
+
+	return d.Retry.Do(ctx, operation, func(ctx context.Context) error {
+		return d.send(ctx, method, path, encoded, out)
+	})
+}
+
+func (d *CloudflareDNS) send(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, d.apiURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build cloudflare request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+d.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call cloudflare api: %w", err)
+	}
+	defer res.Body.Close()
+
+	// rate limits and outages may not carry a json body
+	if transientStatus(res.StatusCode) {
+		return newAPIError("cloudflare", res)
+	}
+
+	var decoded cloudflareResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&decoded); err != nil {
+		return fmt.Errorf("failed to decode cloudflare response with status %q: %w", res.Status, err)
+	}
+
+	if !decoded.Success {
+		messages := make([]string, 0, len(decoded.Errors))
+		for _, message := range decoded.Errors {
+			messages = append(messages, string(message))
+		}
+		return fmt.Errorf("cloudflare api returned %q: %s", res.Status, strings.Join(messages, ", "))
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(decoded.Result, out); err != nil {
+			return fmt.Errorf("failed to decode cloudflare result: %w", err)
+		}
+	}
+
+	return nil
+}
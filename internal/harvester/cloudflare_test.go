@@ -0,0 +1,49 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudflareDNS(t *testing.T) {
+	var patched map[string]string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		result := "[]"
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/zones" && r.URL.Query().Get("name") == "example.com":
+			result = `[{"id":"zone"}]`
+		case r.Method == http.MethodGet && r.URL.Path == "/zones/zone/dns_records":
+			require.Equal(t, "*.dev.example.com", r.URL.Query().Get("name"))
+			result = `[{"id":"record","name":"*.dev.example.com","content":"10.0.12.5"}]`
+		case r.Method == http.MethodPatch && r.URL.Path == "/zones/zone/dns_records/record":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&patched))
+			result = "{}"
+		}
+
+		w.Write([]byte(`{"success":true,"errors":[],"result":` + result + `}`))
+	}))
+	defer server.Close()
+
+	dns, err := NewCloudflareDNS("token", server.Client())
+	require.NoError(t, err)
+	dns.apiURL = server.URL + "/"
+
+	record, err := dns.ARecord(context.Background(), "*.dev.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, &DNSRecord{ZoneID: "zone", ID: "record", Name: "*.dev.example.com", Content: "10.0.12.5"}, record)
+
+	require.NoError(t, dns.UpdateARecord(context.Background(), record, "10.1.12.5"))
+	assert.Equal(t, map[string]string{"content": "10.1.12.5"}, patched)
+
+	_, err = NewCloudflareDNS("", server.Client())
+	require.ErrorContains(t, err, "CF_API_TOKEN")
+}
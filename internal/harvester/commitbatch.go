@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+)
+
+// CommitBatch accumulates the changes an invocation makes to the gitops
+// repository so they land as one commit, and ArgoCD syncs once rather than
+// after every change. With perChange every change is committed on its own
+type CommitBatch struct {
+	repo      *GitopsRepo
+	perChange bool
+	files     map[string][]byte
+	changes   []string
+}
+
+// GitopsCommit is a commit pushed by a CommitBatch along with the logical
+// changes it holds
+type GitopsCommit struct {
+	SHA     string
+	Message string
+	Changes []string
+}
+
+// NewCommitBatch returns a batch committing to repo
+func NewCommitBatch(repo *GitopsRepo, perChange bool) *CommitBatch {
+	return &CommitBatch{
+		repo:      repo,
+		perChange: perChange,
+		files:     map[string][]byte{},
+	}
+}
+
+// Add stages files under the change message, a later change of a path
+// overriding an earlier one. It returns the commit when the batch commits
+// every change on its own, nil when the change waits for Flush
+func (b *CommitBatch) Add(ctx context.Context, files map[string][]byte, message string) (*GitopsCommit, error) {
+	maps.Copy(b.files, files)
+	b.changes = append(b.changes, message)
+
+	if !b.perChange {
+		return nil, nil
+	}
+
+	return b.Flush(ctx)
+}
+
+// Pending returns the changes staged since the last commit
+func (b *CommitBatch) Pending() []string {
+	return b.changes
+}
+
+// Staged returns the files staged since the last commit, nil for the
+// deleted ones
+func (b *CommitBatch) Staged() map[string][]byte {
+	return maps.Clone(b.files)
+}
+
+// Flush commits the staged changes, nil when there are none
+func (b *CommitBatch) Flush(ctx context.Context) (*GitopsCommit, error) {
+	if len(b.changes) == 0 {
+		return nil, nil
+	}
+
+	message := BatchMessage(b.changes)
+	sha, err := b.repo.CommitFiles(ctx, b.files, message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit %s: %w", strings.Join(b.changes, ", "), err)
+	}
+
+	commit := &GitopsCommit{SHA: sha, Message: message, Changes: b.changes}
+	b.files = map[string][]byte{}
+	b.changes = nil
+
+	return commit, nil
+}
+
+// BatchMessage is the message of a commit holding changes: the change
+// itself when there is one, otherwise a summary line followed by a line per
+// change
+func BatchMessage(changes []string) string {
+	if len(changes) == 1 {
+		return changes[0]
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "apply %d kubefirst changes\n\n", len(changes))
+	for _, change := range changes {
+		fmt.Fprintf(&message, "- %s\n", change)
+	}
+
+	return message.String()
+}
@@ -0,0 +1,87 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitBatch(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("batches changes into one commit", func(t *testing.T) {
+		dir := newBareRepo(t, "registry/kubefirst/registry.yaml")
+		batch := NewCommitBatch(&GitopsRepo{URL: dir}, false)
+
+		commit, err := batch.Add(ctx, map[string][]byte{"registry/kubefirst/lb-ip-pool.yaml": []byte("a\n")}, "configure load balancer pool")
+		require.NoError(t, err)
+		assert.Nil(t, commit)
+		commit, err = batch.Add(ctx, map[string][]byte{"registry/kubefirst/lb-ip-pool.yaml": []byte("b\n"), "registry/kubefirst/argocd-sso.yaml": []byte("c\n")}, "configure ArgoCD sso")
+		require.NoError(t, err)
+		assert.Nil(t, commit)
+		assert.Len(t, batch.Pending(), 2)
+		assert.Equal(t, map[string][]byte{"registry/kubefirst/lb-ip-pool.yaml": []byte("b\n"), "registry/kubefirst/argocd-sso.yaml": []byte("c\n")}, batch.Staged())
+
+		commit, err = batch.Flush(ctx)
+		require.NoError(t, err)
+		require.NotNil(t, commit)
+		assert.Empty(t, batch.Staged())
+		assert.Equal(t, []string{"configure load balancer pool", "configure ArgoCD sso"}, commit.Changes)
+		assert.Equal(t, "apply 2 kubefirst changes\n\n- configure load balancer pool\n- configure ArgoCD sso\n", commit.Message)
+		assert.Equal(t, 2, commitCount(t, dir))
+
+		files, err := (&GitopsRepo{URL: dir}).ReadYAMLFiles(ctx, "registry/kubefirst")
+		require.NoError(t, err)
+		assert.Equal(t, "b\n", string(files["registry/kubefirst/lb-ip-pool.yaml"]))
+
+		commit, err = batch.Flush(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, commit)
+	})
+
+	t.Run("commits every change with perChange", func(t *testing.T) {
+		dir := newBareRepo(t, "registry/kubefirst/registry.yaml")
+		batch := NewCommitBatch(&GitopsRepo{URL: dir}, true)
+
+		for _, message := range []string{"configure load balancer pool", "configure ArgoCD sso"} {
+			commit, err := batch.Add(ctx, map[string][]byte{"registry/kubefirst/" + message + ".yaml": []byte("a\n")}, message)
+			require.NoError(t, err)
+			require.NotNil(t, commit)
+			assert.Equal(t, message, commit.Message)
+			assert.Equal(t, []string{message}, commit.Changes)
+		}
+		assert.Empty(t, batch.Pending())
+		assert.Equal(t, 3, commitCount(t, dir))
+	})
+}
+
+// newBareRepo returns a bare clone of a template repository, which
+// accepts pushes to its branch
+func newBareRepo(t *testing.T, files ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	_, err := git.PlainClone(dir, true, &git.CloneOptions{URL: newTemplateRepo(t, files...)})
+	require.NoError(t, err)
+
+	return dir
+}
+
+func commitCount(t *testing.T, dir string) int {
+	t.Helper()
+
+	repo, err := git.PlainOpen(dir)
+	require.NoError(t, err)
+	commits, err := repo.Log(&git.LogOptions{})
+	require.NoError(t, err)
+
+	count := 0
+	for _, err := commits.Next(); err == nil; _, err = commits.Next() {
+		count++
+	}
+
+	return count
+}
@@ -0,0 +1,182 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ConfigDifference is a setting two cluster configs disagree on and the
+// value of each side, empty on the side that does not have it
+type ConfigDifference struct {
+	Field  string `json:"field"`
+	AValue string `json:"a_value"`
+	BValue string `json:"b_value"`
+}
+
+// comparedSets are the list flags compared item by item, an item one side
+// has and the other lacks is a difference of its own
+var comparedSets = []string{"install-catalog-apps", "vclusters"}
+
+// comparedVersions are the components whose versions are compared
+var comparedVersions = []string{"istio", "kgateway"}
+
+// uncomparedFlags name the clusters or decide component versions, which are
+// compared on their own
+var uncomparedFlags = []string{"cluster-name", "istio-version", "components-version-file"}
+
+// CompareClusterConfigs returns what a and b differ in: the flags by name,
+// then the catalog apps and vClusters only one of them has, then the Istio
+// and Kgateway versions they provision. pinsA and pinsB are the versions
+// the --components-version-file of each pins, if any. Secrets are skipped
+func CompareClusterConfigs(a, b *ClusterConfig, pinsA, pinsB ComponentVersions) ([]ConfigDifference, error) {
+	valuesA, err := comparedValues(a)
+	if err != nil {
+		return nil, err
+	}
+	valuesB, err := comparedValues(b)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for name := range valuesA {
+		names[name] = true
+	}
+	for name := range valuesB {
+		names[name] = true
+	}
+
+	var differences []ConfigDifference
+	for _, name := range sortedKeys(names) {
+		if slices.Contains(uncomparedFlags, name) || slices.Contains(comparedSets, name) || slices.Contains(secretFlags, name) {
+			continue
+		}
+		if valuesA[name] != valuesB[name] {
+			differences = append(differences, ConfigDifference{Field: name, AValue: valuesA[name], BValue: valuesB[name]})
+		}
+	}
+
+	for _, name := range comparedSets {
+		differences = append(differences, setDifferences(name, valuesA[name], valuesB[name])...)
+	}
+
+	versionsA, versionsB := configVersions(valuesA, pinsA), configVersions(valuesB, pinsB)
+	for _, component := range comparedVersions {
+		if versionsA[component] != versionsB[component] {
+			differences = append(differences, ConfigDifference{Field: component + " version", AValue: versionsA[component], BValue: versionsB[component]})
+		}
+	}
+
+	return differences, nil
+}
+
+// comparedValues renders the flags of cfg as they are passed on the command
+// line, the ones cfg leaves out as an empty value of their own
+func comparedValues(cfg *ClusterConfig) (map[string]string, error) {
+	values := make(map[string]string, len(cfg.Flags))
+	for name, value := range cfg.Flags {
+		rendered, err := flagValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+		values[name] = rendered
+	}
+
+	return values, nil
+}
+
+// setDifferences returns a difference per comma separated item of a or b
+// the other lacks, sorted
+func setDifferences(field, a, b string) []ConfigDifference {
+	itemsA, itemsB := splitItems(a), splitItems(b)
+
+	var differences []ConfigDifference
+	for _, item := range sortedKeys(itemsA) {
+		if !itemsB[item] {
+			differences = append(differences, ConfigDifference{Field: field, AValue: item})
+		}
+	}
+	for _, item := range sortedKeys(itemsB) {
+		if !itemsA[item] {
+			differences = append(differences, ConfigDifference{Field: field, BValue: item})
+		}
+	}
+
+	return differences
+}
+
+func splitItems(value string) map[string]bool {
+	items := map[string]bool{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items[item] = true
+		}
+	}
+
+	return items
+}
+
+// configVersions returns the version of the comparedVersions a config
+// provisions, "not installed" for the ones it disables
+func configVersions(values map[string]string, pins ComponentVersions) ComponentVersions {
+	installed := map[string]string{}
+	if values["install-istio"] != "false" {
+		installed["istio"] = values["istio-version"]
+		if _, ok := values["istio-version"]; !ok {
+			installed["istio"] = LatestIstioVersion
+		}
+	}
+	if values["install-kgateway"] != "false" {
+		installed["kgateway"] = ""
+	}
+
+	versions := EffectiveComponentVersions(installed, pins)
+	for _, component := range comparedVersions {
+		if _, ok := versions[component]; !ok {
+			versions[component] = "not installed"
+		}
+	}
+
+	return versions
+}
+
+// RenderConfigDifferences renders differences as a unified diff of the
+// settings of nameA against nameB, coloring the removed and added lines
+// when color is set
+func RenderConfigDifferences(nameA, nameB string, differences []ConfigDifference, color bool) string {
+	if len(differences) == 0 {
+		return fmt.Sprintf("%s and %s have the same configuration\n", nameA, nameB)
+	}
+
+	paint := func(code, line string) string {
+		if !color {
+			return line
+		}
+		return "\x1b[" + code + "m" + line + "\x1b[0m"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n", paint("1", "--- "+nameA), paint("1", "+++ "+nameB))
+	field := ""
+	for _, difference := range differences {
+		if difference.Field != field {
+			field = difference.Field
+			fmt.Fprintln(&b, paint("36", "@@ "+field+" @@"))
+		}
+		if difference.AValue != "" {
+			fmt.Fprintln(&b, paint("31", "-"+difference.AValue))
+		}
+		if difference.BValue != "" {
+			fmt.Fprintln(&b, paint("32", "+"+difference.BValue))
+		}
+	}
+
+	return b.String()
+}
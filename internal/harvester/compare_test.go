@@ -0,0 +1,61 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareClusterConfigs(t *testing.T) {
+	staging, _ := NewClusterConfig(map[string]interface{}{
+		"cluster-name":         "staging",
+		"domain-name":          "staging.example.com",
+		"ha":                   false,
+		"install-catalog-apps": "datadog,kyverno",
+		"vclusters":            []interface{}{"dev", "test"},
+		"istio-version":        "1.23.2",
+		"unifi-password":       "hunter2",
+	})
+	prod, _ := NewClusterConfig(map[string]interface{}{
+		"cluster-name":         "prod",
+		"domain-name":          "example.com",
+		"ha":                   true,
+		"install-catalog-apps": "kyverno",
+		"vclusters":            []interface{}{"prod", "test"},
+		"istio-version":        "1.23.2",
+		"install-kgateway":     false,
+		"unifi-password":       "hunter3",
+		"registry-mirror":      "harbor.example.com/mirror",
+	})
+
+	differences, err := CompareClusterConfigs(staging, prod, nil, ComponentVersions{"istio": "1.24.0"})
+	require.NoError(t, err)
+	assert.Equal(t, []ConfigDifference{
+		{Field: "domain-name", AValue: "staging.example.com", BValue: "example.com"},
+		{Field: "ha", AValue: "false", BValue: "true"},
+		{Field: "install-kgateway", BValue: "false"},
+		{Field: "registry-mirror", BValue: "harbor.example.com/mirror"},
+		{Field: "install-catalog-apps", AValue: "datadog"},
+		{Field: "vclusters", AValue: "dev"},
+		{Field: "vclusters", BValue: "prod"},
+		{Field: "istio version", AValue: "1.23.2", BValue: "1.24.0"},
+		{Field: "kgateway version", AValue: TemplateComponentVersion, BValue: "not installed"},
+	}, differences)
+
+	assert.Equal(t, `--- staging
++++ prod
+@@ vclusters @@
+-dev
++prod
+@@ istio version @@
+-1.23.2
++1.24.0
+`, RenderConfigDifferences("staging", "prod", differences[5:8], false))
+	assert.Contains(t, RenderConfigDifferences("staging", "prod", differences[:1], true), "\x1b[31m-staging.example.com\x1b[0m\n\x1b[32m+example.com\x1b[0m\n")
+
+	differences, err = CompareClusterConfigs(staging, staging, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, differences)
+	assert.Equal(t, "staging and staging have the same configuration\n", RenderConfigDifferences("staging", "staging", differences, true))
+}
@@ -0,0 +1,135 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateComponentVersion is the version of a component the gitops
+// template decides
+const TemplateComponentVersion = "from the gitops template"
+
+// ComponentVersionFlags are the create flags setting the version of a
+// component, a --components-version-file entry overrides them
+var ComponentVersionFlags = map[string]string{
+	"gpu-driver":    "gpu-driver-version",
+	"istio":         "istio-version",
+	"kubefirst-pro": "kubefirst-pro-version",
+}
+
+// ComponentVersions maps the components kubefirst installs to their version
+type ComponentVersions map[string]string
+
+// VersionedComponents returns the components a --components-version-file
+// can pin: the ones with a version flag and the charts of PinnedCharts
+func VersionedComponents() []string {
+	components := make([]string, 0, len(ComponentVersionFlags)+len(PinnedCharts))
+	for component := range ComponentVersionFlags {
+		components = append(components, component)
+	}
+	for component := range PinnedCharts {
+		if _, ok := ComponentVersionFlags[component]; !ok {
+			components = append(components, component)
+		}
+	}
+	sort.Strings(components)
+
+	return components
+}
+
+// LoadComponentVersions reads the YAML mapping of component to version of
+// file, rejecting unknown components and versions that are not pinned
+func LoadComponentVersions(file string) (ComponentVersions, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read components version file %q: %w", file, err)
+	}
+
+	var versions ComponentVersions
+	if err := yaml.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("failed to parse components version file %q, expected a mapping of component to version: %w", file, err)
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("components version file %q pins no component", file)
+	}
+
+	known := VersionedComponents()
+	for _, component := range sortedKeys(versions) {
+		version := versions[component]
+		if _, ok := PinnedCharts[component]; !ok {
+			if _, ok := ComponentVersionFlags[component]; !ok {
+				return nil, fmt.Errorf("unknown component %q in %q, expected one of %s", component, file, strings.Join(known, ", "))
+			}
+		}
+
+		switch {
+		case version == "" || version == LatestIstioVersion:
+			return nil, fmt.Errorf("component %s in %q has to be pinned to a version, not %q", component, file, version)
+		case component == "gpu-driver":
+			if err := ValidateGPUDriverVersion(version); err != nil {
+				return nil, fmt.Errorf("component %s in %q: %w", component, file, err)
+			}
+		case component == "kubefirst-pro":
+		case !exactVersion.MatchString(version):
+			return nil, fmt.Errorf("component %s in %q: %q is not an exact version", component, file, version)
+		}
+	}
+
+	return versions, nil
+}
+
+// ChartPins returns the versions of the charts of the components without a
+// version flag, keyed by chart name as PinChartVersions expects
+func (v ComponentVersions) ChartPins() map[string]string {
+	pins := map[string]string{}
+	for component, version := range v {
+		if _, ok := ComponentVersionFlags[component]; ok {
+			continue
+		}
+		for _, chart := range PinnedCharts[component] {
+			pins[chart] = version
+		}
+	}
+
+	return pins
+}
+
+// EffectiveComponentVersions returns the version every installed component
+// is provisioned at. installed maps each to the version its flag resolved
+// to, empty when the gitops template decides, and pins overrides the
+// charts of the template
+func EffectiveComponentVersions(installed map[string]string, pins ComponentVersions) ComponentVersions {
+	versions := ComponentVersions{}
+	for component, version := range installed {
+		if pinned, ok := pins[component]; ok {
+			version = pinned
+		}
+		if version == "" {
+			version = TemplateComponentVersion
+		}
+		versions[component] = version
+	}
+
+	return versions
+}
+
+// String renders the versions as component version pairs ordered by
+// component
+func (v ComponentVersions) String() string {
+	pairs := make([]string, 0, len(v))
+	for _, component := range sortedKeys(v) {
+		pairs = append(pairs, component+" "+v[component])
+	}
+
+	return strings.Join(pairs, ", ")
+}
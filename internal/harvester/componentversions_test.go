@@ -0,0 +1,48 @@
+package harvester
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadComponentVersions(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		file := filepath.Join(t.TempDir(), "versions.yaml")
+		require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+		return file
+	}
+
+	versions, err := LoadComponentVersions(write(t, "istio: 1.23.2\nkgateway: v2.0.1\nvault: 0.28.1\nkubefirst-pro: 0.1.5\ngpu-driver: 550.127.05\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ComponentVersions{"istio": "1.23.2", "kgateway": "v2.0.1", "vault": "0.28.1", "kubefirst-pro": "0.1.5", "gpu-driver": "550.127.05"}, versions)
+	assert.Equal(t, map[string]string{"kgateway": "v2.0.1", "kgateway-crds": "v2.0.1", "vault": "0.28.1"}, versions.ChartPins())
+
+	for content, message := range map[string]string{
+		"istio: 1.23.2\nnginx: 1.0.0\n": `unknown component "nginx"`,
+		"istio: latest\n":               "has to be pinned to a version, not \"latest\"",
+		"vault: ~0.28.0\n":              `"~0.28.0" is not an exact version`,
+		"gpu-driver: a:b\n":             "component gpu-driver",
+		"- istio\n":                     "expected a mapping of component to version",
+		"":                              "pins no component",
+	} {
+		_, err := LoadComponentVersions(write(t, content))
+		assert.ErrorContains(t, err, message, content)
+	}
+	_, err = LoadComponentVersions(filepath.Join(t.TempDir(), "missing.yaml"))
+	require.Error(t, err)
+}
+
+func TestEffectiveComponentVersions(t *testing.T) {
+	versions := EffectiveComponentVersions(map[string]string{
+		"argocd": "",
+		"vault":  "",
+		"istio":  "1.23.2",
+	}, ComponentVersions{"vault": "0.28.1", "kgateway": "v2.0.1"})
+
+	assert.Equal(t, ComponentVersions{"argocd": TemplateComponentVersion, "vault": "0.28.1", "istio": "1.23.2"}, versions)
+	assert.Equal(t, "argocd from the gitops template, istio 1.23.2, vault 0.28.1", versions.String())
+}
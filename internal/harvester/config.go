@@ -0,0 +1,262 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ClusterConfigAPIVersion = "kubefirst.konstruct.io/v1alpha1"
+	ClusterConfigKind       = "HarvesterClusterConfig"
+
+	// SecretPlaceholder replaces secret flag values in exported configs.
+	// Imported placeholders are ignored, so the secret has to be passed as
+	// a flag instead
+	SecretPlaceholder = "<REDACTED>"
+)
+
+// secretFlags are the create flags whose values are never exported
+var secretFlags = []string{"oidc-client-secret", "unifi-password"}
+
+// ClusterConfig is a reproducible description of a Harvester cluster,
+// keyed by `harvester create` flag names
+type ClusterConfig struct {
+	APIVersion string                 `yaml:"apiVersion"`
+	Kind       string                 `yaml:"kind"`
+	Flags      map[string]interface{} `yaml:"flags"`
+
+	// Path and Lines locate the file and the line of every flag of a config
+	// read by LoadClusterConfig
+	Path  string         `yaml:"-"`
+	Lines map[string]int `yaml:"-"`
+}
+
+// NewClusterConfig wraps flags in a ClusterConfig, replacing secret values
+// with SecretPlaceholder. It returns the names of the redacted flags
+func NewClusterConfig(flags map[string]interface{}) (*ClusterConfig, []string) {
+	cfg := &ClusterConfig{
+		APIVersion: ClusterConfigAPIVersion,
+		Kind:       ClusterConfigKind,
+		Flags:      make(map[string]interface{}, len(flags)),
+	}
+
+	for name, value := range flags {
+		cfg.Flags[name] = value
+	}
+
+	var redacted []string
+	for _, name := range secretFlags {
+		if value, ok := cfg.Flags[name]; ok && fmt.Sprint(value) != "" {
+			cfg.Flags[name] = SecretPlaceholder
+			redacted = append(redacted, name)
+		}
+	}
+
+	return cfg, redacted
+}
+
+// LoadClusterConfig reads a ClusterConfig written by export-config,
+// rejecting keys it does not define
+func LoadClusterConfig(path string) (*ClusterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster config %q: %w", path, err)
+	}
+
+	var cfg ClusterConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse cluster config %q: %w", path, err)
+	}
+
+	if cfg.Kind != ClusterConfigKind || cfg.APIVersion != ClusterConfigAPIVersion {
+		return nil, fmt.Errorf("cluster config %q is a %s %s, expected %s %s", path, cfg.APIVersion, cfg.Kind, ClusterConfigAPIVersion, ClusterConfigKind)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse cluster config %q: %w", path, err)
+	}
+	cfg.Path = path
+	cfg.Lines = flagLines(&document)
+
+	return &cfg, nil
+}
+
+// flagLines returns the line of every key under flags of document
+func flagLines(document *yaml.Node) map[string]int {
+	lines := map[string]int{}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return lines
+	}
+
+	root := document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value != "flags" || root.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		flags := root.Content[i+1]
+		for j := 0; j+1 < len(flags.Content); j += 2 {
+			lines[flags.Content[j].Value] = flags.Content[j].Line
+		}
+	}
+
+	return lines
+}
+
+// MarshalClusterConfig renders cfg as YAML, commenting every flag with its
+// entry of provenances, if any, so the comments are dropped on import
+func MarshalClusterConfig(cfg *ClusterConfig, provenances map[string]string) ([]byte, error) {
+	var document yaml.Node
+	if err := document.Encode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to render cluster config: %w", err)
+	}
+
+	for i := 0; i+1 < len(document.Content); i += 2 {
+		if document.Content[i].Value != "flags" {
+			continue
+		}
+		flags := document.Content[i+1]
+		for j := 0; j+1 < len(flags.Content); j += 2 {
+			if provenance, ok := provenances[flags.Content[j].Value]; ok {
+				flags.Content[j].LineComment = provenance
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(&document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cluster config: %w", err)
+	}
+
+	return data, nil
+}
+
+// FlagValues renders every flag in the form its command line value is
+// written in: lists comma separated and maps as comma separated key=value
+// pairs. Secret placeholders are left out
+func (c *ClusterConfig) FlagValues() (map[string]string, error) {
+	values := make(map[string]string, len(c.Flags))
+	for name, value := range c.Flags {
+		if value == SecretPlaceholder {
+			continue
+		}
+
+		rendered, err := flagValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+		values[name] = rendered
+	}
+
+	return values, nil
+}
+
+func flagValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			rendered, err := flagValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, rendered)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		pairs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			rendered, err := flagValue(value[key])
+			if err != nil {
+				return "", err
+			}
+			pairs = append(pairs, fmt.Sprintf("%s=%s", key, rendered))
+		}
+		return strings.Join(pairs, ","), nil
+	case string, bool, int, int64, float64:
+		return fmt.Sprint(value), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", value)
+	}
+}
+
+// SuggestFlag returns the flag of known closest to the unknown name, so
+// typos such as gitProvdier point at git-provider, or "" when none is close
+func SuggestFlag(name string, known []string) string {
+	normalized := kebabCase(name)
+
+	suggestion, best := "", 3
+	for _, flag := range known {
+		if distance := editDistance(normalized, flag); distance < best {
+			suggestion, best = flag, distance
+		}
+	}
+
+	return suggestion
+}
+
+// kebabCase turns camelCase and snake_case keys into flag names
+func kebabCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_':
+			b.WriteRune('-')
+		case r >= 'A' && r <= 'Z':
+			if i > 0 {
+				b.WriteRune('-')
+			}
+			b.WriteRune(r + 'a' - 'A')
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,93 @@
+package harvester
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestClusterConfigRoundTrip(t *testing.T) {
+	cfg, redacted := NewClusterConfig(map[string]interface{}{
+		"cluster-name":       "kubefirst",
+		"ha":                 true,
+		"ha-node-count":      3,
+		"vclusters":          []string{"dev", "prod"},
+		"vcluster-istio":     map[string]bool{"prod": true, "dev": false},
+		"unifi-password":     "hunter2",
+		"oidc-client-secret": "",
+	})
+	assert.Equal(t, []string{"unifi-password"}, redacted)
+
+	data, err := yaml.Marshal(cfg)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "hunter2")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	loaded, err := LoadClusterConfig(path)
+	require.NoError(t, err)
+
+	values, err := loaded.FlagValues()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"cluster-name":       "kubefirst",
+		"ha":                 "true",
+		"ha-node-count":      "3",
+		"vclusters":          "dev,prod",
+		"vcluster-istio":     "dev=false,prod=true",
+		"oidc-client-secret": "",
+	}, values)
+}
+
+func TestLoadClusterConfigKind(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("apiVersion: v1\nkind: ConfigMap\n"), 0o600))
+
+	_, err := LoadClusterConfig(path)
+	require.ErrorContains(t, err, "expected "+ClusterConfigAPIVersion+" "+ClusterConfigKind)
+}
+
+func TestLoadClusterConfigUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("apiVersion: "+ClusterConfigAPIVersion+"\nkind: "+ClusterConfigKind+"\nflag:\n  cluster-name: kubefirst\n"), 0o600))
+
+	_, err := LoadClusterConfig(path)
+	require.ErrorContains(t, err, "field flag not found")
+
+	known := []string{"git-provider", "git-protocol", "gitops-repo", "domain-name"}
+	assert.Equal(t, "git-provider", SuggestFlag("gitProvdier", known))
+	assert.Equal(t, "domain-name", SuggestFlag("domain_name", known))
+	assert.Equal(t, "", SuggestFlag("vault-seed-file", known))
+}
+
+func TestMarshalClusterConfigProvenance(t *testing.T) {
+	cfg, _ := NewClusterConfig(map[string]interface{}{
+		"cluster-name": "kubefirst",
+		"domain-name":  "example.com",
+		"vclusters":    []string{"dev"},
+	})
+
+	data, err := MarshalClusterConfig(cfg, map[string]string{
+		"domain-name": "from config file cluster.yaml:14",
+		"vclusters":   "from the command line",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "apiVersion: "+ClusterConfigAPIVersion+"\nkind: "+ClusterConfigKind+"\nflags:\n"+
+		"    cluster-name: kubefirst\n"+
+		"    domain-name: example.com # from config file cluster.yaml:14\n"+
+		"    vclusters: # from the command line\n"+
+		"        - dev\n", string(data))
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	loaded, err := LoadClusterConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", loaded.Flags["domain-name"])
+	assert.Equal(t, map[string]int{"cluster-name": 4, "domain-name": 5, "vclusters": 6}, loaded.Lines)
+}
@@ -0,0 +1,285 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// connectVClusterPrefix prefixes the connect component of the API of a
+// vcluster, e.g. vcluster/dev
+const connectVClusterPrefix = "vcluster/"
+
+// connectReconnectDelay is how long PortForward waits before forwarding
+// again once the pod it forwarded to went away
+var connectReconnectDelay = 2 * time.Second
+
+// ConnectComponents are the components harvester connect forwards a local
+// port to, besides the API of a vcluster as vcluster/<name>
+var ConnectComponents = []string{"argocd", "vault", "grafana"}
+
+// ConnectTarget is the service harvester connect forwards a local port to
+type ConnectTarget struct {
+	Component string
+	Namespace string
+	Service   string
+	Port      int32
+	Scheme    string
+}
+
+// ResolveConnectTarget returns the service of component, one of
+// ConnectComponents or vcluster/<name>. Components the cluster was created
+// without, per vault, observability and vclusters, are an error
+func ResolveConnectTarget(component string, vault, observability bool, vclusters []string) (ConnectTarget, error) {
+	target := ConnectTarget{Component: component, Scheme: "http"}
+	switch component {
+	case "argocd":
+		target.Namespace, target.Service, target.Port = ArgoCDNamespace, argoCDServerDeployment, 80
+	case "vault":
+		if !vault {
+			return ConnectTarget{}, errors.New("the cluster was created without vault")
+		}
+		target.Namespace, target.Service, target.Port = vaultNamespace, "vault", 8200
+	case "grafana":
+		if !observability {
+			return ConnectTarget{}, errors.New("the cluster was created without --install-observability")
+		}
+		target.Namespace, target.Service, target.Port = ObservabilityNamespace, grafanaService, 80
+	default:
+		vcluster, ok := strings.CutPrefix(component, connectVClusterPrefix)
+		if !ok {
+			return ConnectTarget{}, fmt.Errorf("unknown component %q, expected one of %s or %s<name>", component, strings.Join(ConnectComponents, ", "), connectVClusterPrefix)
+		}
+		if !slices.Contains(vclusters, vcluster) {
+			return ConnectTarget{}, fmt.Errorf("vcluster %q does not exist, expected one of %v", vcluster, vclusters)
+		}
+		target.Namespace, target.Service, target.Port, target.Scheme = VClusterNamespace(vcluster), vcluster, 443, "https"
+	}
+
+	return target, nil
+}
+
+// URL returns the URL of the target forwarded to localPort
+func (t ConnectTarget) URL(localPort int) string {
+	return fmt.Sprintf("%s://localhost:%d", t.Scheme, localPort)
+}
+
+// Browsable reports whether the target serves a UI
+func (t ConnectTarget) Browsable() bool {
+	return !strings.HasPrefix(t.Component, connectVClusterPrefix)
+}
+
+// Hint returns how to log in to target with the credentials, empty when
+// the credentials do not hold the ones of target
+func (r *RootCredentials) Hint(target ConnectTarget, localPort int) string {
+	switch {
+	case target.Component == "argocd" && r.ArgoCDPassword != "":
+		return fmt.Sprintf("user admin, password %s", r.ArgoCDPassword)
+	case target.Component == "vault" && r.VaultRootToken != "":
+		return fmt.Sprintf("root token %s", r.VaultRootToken)
+	case target.Component == "grafana" && r.GrafanaPassword != "":
+		return fmt.Sprintf("user %s, password %s", grafanaAdminUser, r.GrafanaPassword)
+	case !target.Browsable():
+		vcluster := strings.TrimPrefix(target.Component, connectVClusterPrefix)
+		return fmt.Sprintf("kubeconfig in secret %s/vc-%s, with its server set to %s", target.Namespace, vcluster, target.URL(localPort))
+	}
+
+	return ""
+}
+
+// forwardLocal forwards a free local port to target in the background and
+// returns it once it listens. stop ends the port-forward
+func (c *Client) forwardLocal(ctx context.Context, target ConnectTarget) (int, func(), error) {
+	forwardCtx, stop := context.WithCancel(ctx)
+	ready, failed := make(chan int, 1), make(chan error, 1)
+	go func() {
+		failed <- c.PortForward(forwardCtx, target, 0, func(localPort int) { ready <- localPort }, func(error) {})
+	}()
+
+	select {
+	case localPort := <-ready:
+		return localPort, stop, nil
+	case err := <-failed:
+		stop()
+		if err == nil {
+			err = ctx.Err()
+		}
+		return 0, nil, err
+	}
+}
+
+// PortForward forwards localPort, a free port when 0, to a ready pod of the
+// service of target until ctx is done. ready is called with the local port
+// once it listens. When the pod goes away, e.g. restarts, lost is called
+// with the reason and the same local port is forwarded to a new pod of the
+// service. Only failing to forward at all is an error
+func (c *Client) PortForward(ctx context.Context, target ConnectTarget, localPort int, ready func(localPort int), lost func(err error)) error {
+	connected := false
+	for {
+		err := c.forwardOnce(ctx, target, &localPort, func() {
+			if !connected {
+				connected = true
+				ready(localPort)
+			}
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !connected {
+			return err
+		}
+		lost(err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(connectReconnectDelay):
+		}
+	}
+}
+
+// forwardOnce forwards localPort to a ready pod of target until the
+// connection to the pod is lost or ctx is done. A localPort of 0 is set to
+// the port picked
+func (c *Client) forwardOnce(ctx context.Context, target ConnectTarget, localPort *int, ready func()) error {
+	pod, podPort, err := c.ConnectPod(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	transport, upgrader, err := spdy.RoundTripperFor(c.RestConfig)
+	if err != nil {
+		return fmt.Errorf("failed to create port-forward transport: %w", err)
+	}
+	url := c.Clientset.CoreV1().RESTClient().Post().Namespace(target.Namespace).Resource("pods").Name(pod).SubResource("portforward").URL()
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+
+	stop, readyCh := make(chan struct{}), make(chan struct{})
+	forwarder, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("%d:%d", *localPort, podPort)}, stop, readyCh, io.Discard, io.Discard)
+	if err != nil {
+		return fmt.Errorf("failed to forward to pod %s/%s: %w", target.Namespace, pod, err)
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	defer wg.Wait()
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			close(stop)
+		case <-done:
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-readyCh:
+		case <-done:
+			return
+		}
+		if ports, err := forwarder.GetPorts(); err == nil && len(ports) > 0 {
+			*localPort = int(ports[0].Local)
+		}
+		ready()
+	}()
+
+	if err := forwarder.ForwardPorts(); err != nil {
+		return fmt.Errorf("port-forward to pod %s/%s: %w", target.Namespace, pod, err)
+	}
+
+	return portforward.ErrLostConnectionToPod
+}
+
+// ConnectPod returns a ready pod behind the service of target and the pod
+// port the service port of target maps to, resolving named target ports
+// from the container ports
+func (c *Client) ConnectPod(ctx context.Context, target ConnectTarget) (string, int, error) {
+	service, err := c.Clientset.CoreV1().Services(target.Namespace).Get(ctx, target.Service, metav1.GetOptions{})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read service %s/%s: %w", target.Namespace, target.Service, err)
+	}
+
+	var servicePort *corev1.ServicePort
+	for i := range service.Spec.Ports {
+		if service.Spec.Ports[i].Port == target.Port {
+			servicePort = &service.Spec.Ports[i]
+		}
+	}
+	if servicePort == nil {
+		return "", 0, fmt.Errorf("service %s/%s has no port %d", target.Namespace, target.Service, target.Port)
+	}
+	if len(service.Spec.Selector) == 0 {
+		return "", 0, fmt.Errorf("service %s/%s selects no pods", target.Namespace, target.Service)
+	}
+
+	pods, err := c.Clientset.CoreV1().Pods(target.Namespace).List(ctx, metav1.ListOptions{LabelSelector: labels.SelectorFromSet(service.Spec.Selector).String()})
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to list pods of service %s/%s: %w", target.Namespace, target.Service, err)
+	}
+
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp != nil || !podReady(&pod) {
+			continue
+		}
+		if port, ok := podPort(&pod, servicePort.TargetPort, target.Port); ok {
+			return pod.Name, port, nil
+		}
+	}
+
+	return "", 0, fmt.Errorf("no ready pod of service %s/%s", target.Namespace, target.Service)
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// podPort resolves the target port of a service port of pod, which
+// defaults to the service port itself
+func podPort(pod *corev1.Pod, targetPort intstr.IntOrString, servicePort int32) (int, bool) {
+	if targetPort.Type == intstr.Int {
+		if targetPort.IntVal == 0 {
+			return int(servicePort), true
+		}
+		return int(targetPort.IntVal), true
+	}
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == targetPort.StrVal {
+				return int(port.ContainerPort), true
+			}
+		}
+	}
+
+	return 0, false
+}
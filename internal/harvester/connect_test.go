@@ -0,0 +1,64 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveConnectTarget(t *testing.T) {
+	target, err := ResolveConnectTarget("vault", true, false, nil)
+	require.NoError(t, err)
+	assert.Equal(t, ConnectTarget{Component: "vault", Namespace: "vault", Service: "vault", Port: 8200, Scheme: "http"}, target)
+
+	target, err = ResolveConnectTarget("vcluster/dev", false, false, []string{"dev"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://localhost:8443", target.URL(8443))
+	assert.False(t, target.Browsable())
+	assert.Equal(t, "kubeconfig in secret vcluster-dev/vc-dev, with its server set to https://localhost:8443", (&RootCredentials{}).Hint(target, 8443))
+
+	_, err = ResolveConnectTarget("grafana", true, false, nil)
+	require.ErrorContains(t, err, "without --install-observability")
+	_, err = ResolveConnectTarget("vcluster/qa", true, true, []string{"dev"})
+	require.ErrorContains(t, err, `vcluster "qa" does not exist`)
+	_, err = ResolveConnectTarget("prometheus", true, true, nil)
+	require.ErrorContains(t, err, `unknown component "prometheus"`)
+}
+
+func TestConnectPod(t *testing.T) {
+	ready := corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}}
+	selector := map[string]string{"app.kubernetes.io/name": "argocd-server"}
+	client := &Client{Clientset: fake.NewClientset(
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "argocd-server", Namespace: ArgoCDNamespace},
+			Spec:       corev1.ServiceSpec{Selector: selector, Ports: []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("server")}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "argocd-server-starting", Namespace: ArgoCDNamespace, Labels: selector},
+			Status:     corev1.PodStatus{Phase: corev1.PodPending},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "argocd-server-ready", Namespace: ArgoCDNamespace, Labels: selector},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Ports: []corev1.ContainerPort{{Name: "server", ContainerPort: 8080}}}}},
+			Status:     ready,
+		},
+	)}
+	ctx := context.Background()
+
+	target, err := ResolveConnectTarget("argocd", false, false, nil)
+	require.NoError(t, err)
+	pod, port, err := client.ConnectPod(ctx, target)
+	require.NoError(t, err)
+	assert.Equal(t, "argocd-server-ready", pod)
+	assert.Equal(t, 8080, port)
+
+	target.Port = 443
+	_, _, err = client.ConnectPod(ctx, target)
+	require.ErrorContains(t, err, "service argocd/argocd-server has no port 443")
+}
@@ -0,0 +1,399 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Chart defaults of the values EstimateResources reads, for the gitops
+// templates leaving them unset
+var (
+	defaultVClusterQuota = [][2]string{{"requests.cpu", "10"}, {"requests.memory", "20Gi"}, {"requests.storage", "100Gi"}}
+	defaultVaultSize     = "10Gi"
+	defaultVaultReplicas = 3
+	defaultRedisHASize   = "10Gi"
+	defaultRedisReplicas = 3
+	defaultIstiodCPU     = "500m"
+	defaultIstiodMemory  = "2048Mi"
+)
+
+// ResourceEstimate is the projected consumption of a platform component,
+// CPU in vCPU, Memory and Storage in GiB
+type ResourceEstimate struct {
+	Component string
+	CPU       float64
+	Memory    float64
+	Storage   float64
+	// Assumed are the values the gitops template leaves to the chart
+	// defaults, which no create flag sets yet
+	Assumed []string
+}
+
+// CostEstimate is the projected consumption of the platform create
+// provisions
+type CostEstimate struct {
+	Resources []ResourceEstimate
+}
+
+// CostEstimateOptions are the create flags deciding what a CostEstimate
+// covers
+type CostEstimateOptions struct {
+	VClusters []string
+	// Vault is unset when --stop-after halts before its phase
+	Vault bool
+	Istio bool
+}
+
+// chartSource is a source of an ArgoCD application of the gitops template
+// deploying a chart, along with its helm values
+type chartSource struct {
+	application string
+	chart       string
+	values      map[string]interface{}
+}
+
+// EstimateResources sums the vcluster resource quotas, the Vault and ArgoCD
+// PVC sizes and the Istio control plane requests the ArgoCD applications
+// and ApplicationSets of the gitops template set, the template files keyed
+// by path
+func EstimateResources(files map[string][]byte, opts CostEstimateOptions) (CostEstimate, error) {
+	sources, err := templateChartSources(files)
+	if err != nil {
+		return CostEstimate{}, err
+	}
+
+	var estimate CostEstimate
+	add := func(resources ResourceEstimate, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to estimate %s: %w", resources.Component, err)
+		}
+		estimate.Resources = append(estimate.Resources, resources)
+		return nil
+	}
+
+	for _, vcluster := range opts.VClusters {
+		if err := add(estimateVCluster(vcluster, findChartSource(sources, "vcluster", vcluster, VClusterNamespace(vcluster)))); err != nil {
+			return CostEstimate{}, err
+		}
+	}
+	if opts.Vault {
+		if err := add(estimateVault(findChartSource(sources, "vault"))); err != nil {
+			return CostEstimate{}, err
+		}
+	}
+	if err := add(estimateArgoCD(findChartSource(sources, "argo-cd"))); err != nil {
+		return CostEstimate{}, err
+	}
+	if opts.Istio {
+		if err := add(estimateIstiod(findChartSource(sources, "istiod"))); err != nil {
+			return CostEstimate{}, err
+		}
+	}
+
+	return estimate, nil
+}
+
+func estimateVCluster(vcluster string, source *chartSource) (ResourceEstimate, error) {
+	estimate := ResourceEstimate{Component: "vcluster " + vcluster}
+	for _, quota := range defaultVClusterQuota {
+		value, err := chartQuantity(source, &estimate, quota[1], "policies", "resourceQuota", "quota", quota[0])
+		if err != nil {
+			return estimate, err
+		}
+		switch quota[0] {
+		case "requests.cpu":
+			estimate.CPU = value.AsApproximateFloat64()
+		case "requests.memory":
+			estimate.Memory = gibibytes(value)
+		case "requests.storage":
+			estimate.Storage = gibibytes(value)
+		}
+	}
+
+	return estimate, nil
+}
+
+func estimateVault(source *chartSource) (ResourceEstimate, error) {
+	estimate := ResourceEstimate{Component: "vault"}
+	size, err := chartQuantity(source, &estimate, defaultVaultSize, "server", "dataStorage", "size")
+	if err != nil {
+		return estimate, err
+	}
+	volumes := gibibytes(size)
+	if chartBool(source, "server", "auditStorage", "enabled") {
+		audit, err := chartQuantity(source, &estimate, defaultVaultSize, "server", "auditStorage", "size")
+		if err != nil {
+			return estimate, err
+		}
+		volumes += gibibytes(audit)
+	}
+
+	replicas := 1
+	if chartBool(source, "server", "ha", "enabled") {
+		if replicas, err = chartInt(source, &estimate, defaultVaultReplicas, "server", "ha", "replicas"); err != nil {
+			return estimate, err
+		}
+	}
+	estimate.Storage = volumes * float64(replicas)
+
+	return estimate, nil
+}
+
+// estimateArgoCD covers the PVCs of redis-ha, ArgoCD has none without it
+func estimateArgoCD(source *chartSource) (ResourceEstimate, error) {
+	estimate := ResourceEstimate{Component: "argocd"}
+	if source == nil {
+		estimate.Assumed = append(estimate.Assumed, "no argo-cd application in the gitops template, assumed without PVCs")
+		return estimate, nil
+	}
+	if !chartBool(source, "redis-ha", "enabled") {
+		return estimate, nil
+	}
+
+	size, err := chartQuantity(source, &estimate, defaultRedisHASize, "redis-ha", "persistentVolume", "size")
+	if err != nil {
+		return estimate, err
+	}
+	replicas, err := chartInt(source, &estimate, defaultRedisReplicas, "redis-ha", "replicas")
+	if err != nil {
+		return estimate, err
+	}
+	estimate.Storage = gibibytes(size) * float64(replicas)
+
+	return estimate, nil
+}
+
+func estimateIstiod(source *chartSource) (ResourceEstimate, error) {
+	estimate := ResourceEstimate{Component: "istiod"}
+	cpu, err := chartQuantity(source, &estimate, defaultIstiodCPU, "pilot", "resources", "requests", "cpu")
+	if err != nil {
+		return estimate, err
+	}
+	memory, err := chartQuantity(source, &estimate, defaultIstiodMemory, "pilot", "resources", "requests", "memory")
+	if err != nil {
+		return estimate, err
+	}
+	replicas, err := chartInt(source, &estimate, 1, "pilot", "replicaCount")
+	if err != nil {
+		return estimate, err
+	}
+	estimate.CPU = cpu.AsApproximateFloat64() * float64(replicas)
+	estimate.Memory = gibibytes(memory) * float64(replicas)
+
+	return estimate, nil
+}
+
+// chartQuantity returns the quantity at path of the values of source,
+// recording fallback in the assumptions of estimate when it is unset
+func chartQuantity(source *chartSource, estimate *ResourceEstimate, fallback string, path ...string) (resource.Quantity, error) {
+	value := chartValueAt(source, path...)
+	if value == nil {
+		estimate.Assumed = append(estimate.Assumed, fmt.Sprintf("%s %s", strings.Join(path, "."), fallback))
+		return resource.MustParse(fallback), nil
+	}
+
+	quantity, err := resource.ParseQuantity(fmt.Sprint(value))
+	if err != nil {
+		return resource.Quantity{}, fmt.Errorf("invalid %s of application %s: %w", strings.Join(path, "."), source.application, err)
+	}
+
+	return quantity, nil
+}
+
+// chartInt is chartQuantity for counts
+func chartInt(source *chartSource, estimate *ResourceEstimate, fallback int, path ...string) (int, error) {
+	value := chartValueAt(source, path...)
+	if value == nil {
+		estimate.Assumed = append(estimate.Assumed, fmt.Sprintf("%s %d", strings.Join(path, "."), fallback))
+		return fallback, nil
+	}
+
+	count, err := strconv.Atoi(fmt.Sprint(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s of application %s: %w", strings.Join(path, "."), source.application, err)
+	}
+
+	return count, nil
+}
+
+func chartBool(source *chartSource, path ...string) bool {
+	enabled, _ := chartValueAt(source, path...).(bool)
+	return enabled
+}
+
+func chartValueAt(source *chartSource, path ...string) interface{} {
+	if source == nil {
+		return nil
+	}
+
+	return lookupValue(source.values, path...)
+}
+
+func gibibytes(quantity resource.Quantity) float64 {
+	return quantity.AsApproximateFloat64() / (1 << 30)
+}
+
+// findChartSource returns the source deploying chart in the application
+// named one of names, or in any application without names. A single source
+// such as the template of an ApplicationSet covers every name
+func findChartSource(sources []chartSource, chart string, names ...string) *chartSource {
+	var found *chartSource
+	for i, source := range sources {
+		if source.chart != chart {
+			continue
+		}
+		for _, name := range names {
+			if source.application == name {
+				return &sources[i]
+			}
+		}
+		if found == nil {
+			found = &sources[i]
+		}
+	}
+
+	return found
+}
+
+// templateChartSources returns the chart sources of the ArgoCD
+// applications of files and of the templates of their ApplicationSets.
+// Files that are not YAML are skipped
+func templateChartSources(files map[string][]byte) ([]chartSource, error) {
+	var sources []chartSource
+	for _, name := range sortedKeys(files) {
+		if ext := path.Ext(name); ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		documents, err := decodeDocuments(files[name])
+		if err != nil {
+			continue
+		}
+
+		for _, document := range documents {
+			var application map[string]interface{}
+			switch document["kind"] {
+			case "Application":
+				application = document
+			case "ApplicationSet":
+				application, _ = lookupValue(document, "spec", "template").(map[string]interface{})
+			}
+			if application == nil {
+				continue
+			}
+
+			appName, _ := lookupValue(application, "metadata", "name").(string)
+			candidates := []interface{}{lookupValue(application, "spec", "source")}
+			if multiple, ok := lookupValue(application, "spec", "sources").([]interface{}); ok {
+				candidates = multiple
+			}
+			for _, candidate := range candidates {
+				source, ok := candidate.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				chart, _ := source["chart"].(string)
+				if chart == "" {
+					continue
+				}
+				values, err := helmValues(source)
+				if err != nil {
+					return nil, fmt.Errorf("%s: application %q: %w", name, appName, err)
+				}
+				sources = append(sources, chartSource{application: appName, chart: chart, values: values})
+			}
+		}
+	}
+
+	return sources, nil
+}
+
+// helmValues returns the values of an ArgoCD application source, embedded
+// as a string or as an object
+func helmValues(source map[string]interface{}) (map[string]interface{}, error) {
+	if embedded, ok := lookupValue(source, "helm", "values").(string); ok {
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(embedded), &values); err != nil {
+			return nil, fmt.Errorf("failed to parse helm values: %w", err)
+		}
+		return values, nil
+	}
+
+	values, _ := lookupValue(source, "helm", "valuesObject").(map[string]interface{})
+	return values, nil
+}
+
+// Total sums the resources of the estimate
+func (e CostEstimate) Total() ResourceEstimate {
+	total := ResourceEstimate{Component: "Total"}
+	for _, resources := range e.Resources {
+		total.CPU += resources.CPU
+		total.Memory += resources.Memory
+		total.Storage += resources.Storage
+	}
+
+	return total
+}
+
+// HourlyCost prices the vCPU and GiB of memory of the estimate per hour
+func (e CostEstimate) HourlyCost(cpuRate, memoryRate float64) float64 {
+	total := e.Total()
+	return total.CPU*cpuRate + total.Memory*memoryRate
+}
+
+// Render renders the estimate as a table, followed by its hourly cost when
+// a rate is set and the values assumed from the chart defaults
+func (e CostEstimate) Render(cpuRate, memoryRate float64) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "COMPONENT\tVCPU\tMEMORY (GiB)\tSTORAGE (GiB)")
+	for _, resources := range append(e.Resources, e.Total()) {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", resources.Component, amountCell(resources.CPU), amountCell(resources.Memory), amountCell(resources.Storage))
+	}
+	_ = w.Flush()
+
+	if cpuRate > 0 || memoryRate > 0 {
+		total := e.Total()
+		fmt.Fprintf(&b, "\nEstimated cost: %s per hour (%s vCPU at %s, %s GiB of memory at %s), storage not included\n",
+			formatAmount(e.HourlyCost(cpuRate, memoryRate)), formatAmount(total.CPU), formatAmount(cpuRate), formatAmount(total.Memory), formatAmount(memoryRate))
+	}
+
+	var assumed []string
+	for _, resources := range e.Resources {
+		if len(resources.Assumed) > 0 {
+			assumed = append(assumed, fmt.Sprintf("  %s: %s", resources.Component, strings.Join(resources.Assumed, ", ")))
+		}
+	}
+	if len(assumed) > 0 {
+		fmt.Fprintf(&b, "\nAssumed from the chart defaults, the gitops template does not set them and no flag configures them yet:\n%s\n", strings.Join(assumed, "\n"))
+	}
+
+	return b.String()
+}
+
+// formatAmount renders value with at most 3 decimals
+func formatAmount(value float64) string {
+	return strconv.FormatFloat(math.Round(value*1000)/1000, 'f', -1, 64)
+}
+
+// amountCell is formatAmount rendering nothing as -
+func amountCell(value float64) string {
+	if value == 0 {
+		return "-"
+	}
+
+	return formatAmount(value)
+}
@@ -0,0 +1,107 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimateResources(t *testing.T) {
+	files := map[string][]byte{
+		"registry/<CLUSTER_NAME>/vault.yaml": []byte(`apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: vault
+spec:
+  source:
+    chart: vault
+    helm:
+      values: |
+        server:
+          dataStorage:
+            size: 20Gi
+          auditStorage:
+            enabled: true
+            size: 5Gi
+`),
+		"registry/<CLUSTER_NAME>/argocd.yaml": []byte(`apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: argocd
+spec:
+  source:
+    chart: argo-cd
+    helm:
+      valuesObject:
+        redis-ha:
+          enabled: true
+          persistentVolume:
+            size: 2Gi
+`),
+		"registry/<CLUSTER_NAME>/vcluster-dev.yaml": []byte(`apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: vcluster-dev
+spec:
+  sources:
+  - chart: vcluster
+    helm:
+      valuesObject:
+        policies:
+          resourceQuota:
+            quota:
+              requests.cpu: 4
+              requests.memory: 8Gi
+              requests.storage: 50Gi
+  - ref: values
+`),
+		"registry/<CLUSTER_NAME>/README.md": []byte("kind: Application\n"),
+	}
+
+	estimate, err := EstimateResources(files, CostEstimateOptions{VClusters: []string{"dev", "prod"}, Vault: true, Istio: true})
+	require.NoError(t, err)
+	require.Len(t, estimate.Resources, 5)
+
+	dev, prod, vault, argocd, istiod := estimate.Resources[0], estimate.Resources[1], estimate.Resources[2], estimate.Resources[3], estimate.Resources[4]
+	assert.Equal(t, ResourceEstimate{Component: "vcluster dev", CPU: 4, Memory: 8, Storage: 50}, dev)
+	// prod has no application of its own, the one of dev is the closest
+	assert.Equal(t, 4.0, prod.CPU)
+	assert.Equal(t, 25.0, vault.Storage)
+	assert.Empty(t, vault.Assumed)
+	assert.Equal(t, 6.0, argocd.Storage)
+	assert.Equal(t, []string{"redis-ha.replicas 3"}, argocd.Assumed)
+	assert.Equal(t, 0.5, istiod.CPU)
+	assert.Equal(t, 2.0, istiod.Memory)
+	assert.Equal(t, []string{"pilot.resources.requests.cpu 500m", "pilot.resources.requests.memory 2048Mi", "pilot.replicaCount 1"}, istiod.Assumed)
+
+	assert.Equal(t, ResourceEstimate{Component: "Total", CPU: 8.5, Memory: 18, Storage: 131}, estimate.Total())
+	assert.InDelta(t, 0.516, estimate.HourlyCost(0.048, 0.006), 0.0001)
+
+	assert.Equal(t, `COMPONENT      VCPU  MEMORY (GiB)  STORAGE (GiB)
+vcluster dev   4     8             50
+vcluster prod  4     8             50
+vault          -     -             25
+argocd         -     -             6
+istiod         0.5   2             -
+Total          8.5   18            131
+
+Estimated cost: 0.516 per hour (8.5 vCPU at 0.048, 18 GiB of memory at 0.006), storage not included
+
+Assumed from the chart defaults, the gitops template does not set them and no flag configures them yet:
+  argocd: redis-ha.replicas 3
+  istiod: pilot.resources.requests.cpu 500m, pilot.resources.requests.memory 2048Mi, pilot.replicaCount 1
+`, estimate.Render(0.048, 0.006))
+
+	estimate, err = EstimateResources(map[string][]byte{}, CostEstimateOptions{VClusters: []string{"dev"}})
+	require.NoError(t, err)
+	require.Len(t, estimate.Resources, 2)
+	assert.Equal(t, ResourceEstimate{
+		Component: "vcluster dev", CPU: 10, Memory: 20, Storage: 100,
+		Assumed: []string{"policies.resourceQuota.quota.requests.cpu 10", "policies.resourceQuota.quota.requests.memory 20Gi", "policies.resourceQuota.quota.requests.storage 100Gi"},
+	}, estimate.Resources[0])
+	assert.NotContains(t, estimate.Render(0, 0), "Estimated cost")
+
+	_, err = EstimateResources(map[string][]byte{"vault.yaml": []byte("kind: Application\nmetadata:\n  name: vault\nspec:\n  source:\n    chart: vault\n    helm:\n      valuesObject:\n        server:\n          dataStorage:\n            size: big\n")}, CostEstimateOptions{Vault: true})
+	require.ErrorContains(t, err, "failed to estimate vault: invalid server.dataStorage.size of application vault")
+}
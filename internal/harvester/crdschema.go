@@ -0,0 +1,212 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// crdSchemaFiles are the schemas of the CRDs kubefirst renders before the
+// application installing them syncs, one file per chart
+//
+//go:embed crdschemas/*.yaml
+var crdSchemaFiles embed.FS
+
+// BundledSchema is the OpenAPI schema of a custom resource, bundled for
+// when its CRD is not installed yet
+type BundledSchema struct {
+	Chart string `yaml:"chart"`
+	// Version is the chart version the schema comes from, when kubefirst
+	// pins it
+	Version    string     `yaml:"version"`
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Schema     *crdSchema `yaml:"schema"`
+}
+
+// crdSchema is the subset of the structural schemas of CRDs the bundled
+// schemas use
+type crdSchema struct {
+	Type                  string                `yaml:"type"`
+	Properties            map[string]*crdSchema `yaml:"properties"`
+	Required              []string              `yaml:"required"`
+	Items                 *crdSchema            `yaml:"items"`
+	AdditionalProperties  *crdSchema            `yaml:"additionalProperties"`
+	Enum                  []string              `yaml:"enum"`
+	PreserveUnknownFields bool                  `yaml:"x-kubernetes-preserve-unknown-fields"`
+	IntOrString           bool                  `yaml:"x-kubernetes-int-or-string"`
+}
+
+var (
+	bundledSchemasOnce sync.Once
+	bundledSchemas     map[string]BundledSchema
+	bundledSchemasErr  error
+)
+
+// BundledSchemas returns the bundled schemas keyed by apiVersion and kind,
+// as "<apiVersion> <kind>"
+func BundledSchemas() (map[string]BundledSchema, error) {
+	bundledSchemasOnce.Do(func() {
+		bundledSchemas, bundledSchemasErr = loadBundledSchemas()
+	})
+
+	return bundledSchemas, bundledSchemasErr
+}
+
+func loadBundledSchemas() (map[string]BundledSchema, error) {
+	entries, err := crdSchemaFiles.ReadDir("crdschemas")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundled schemas: %w", err)
+	}
+
+	schemas := map[string]BundledSchema{}
+	for _, entry := range entries {
+		content, err := crdSchemaFiles.ReadFile("crdschemas/" + entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundled schemas: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			var schema BundledSchema
+			err := decoder.Decode(&schema)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid bundled schema %s: %w", entry.Name(), err)
+			}
+			schemas[schema.APIVersion+" "+schema.Kind] = schema
+		}
+	}
+
+	return schemas, nil
+}
+
+// Validate returns an error per field of obj its schema rejects, the
+// apiVersion, kind and metadata being left to the cluster
+func (s BundledSchema) Validate(obj *unstructured.Unstructured) error {
+	object := make(map[string]interface{}, len(obj.Object))
+	for key, value := range obj.Object {
+		if key != "apiVersion" && key != "kind" && key != "metadata" {
+			object[key] = value
+		}
+	}
+
+	return errors.Join(s.Schema.validate("", object)...)
+}
+
+func (s *crdSchema) validate(path string, value interface{}) []error {
+	if value == nil {
+		return nil
+	}
+	invalid := func(format string, args ...interface{}) []error {
+		return []error{fmt.Errorf("%s: %s", strings.TrimPrefix(path, "."), fmt.Sprintf(format, args...))}
+	}
+
+	if s.IntOrString {
+		switch value.(type) {
+		case string, int64, float64:
+			return nil
+		}
+		return invalid("Invalid value: %v: must be an integer or a string", value)
+	}
+
+	switch s.Type {
+	case "object":
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid("Invalid value: %v: must be an object", value)
+		}
+		return s.validateFields(path, fields)
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return invalid("Invalid value: %v: must be an array", value)
+		}
+		var errs []error
+		if s.Items != nil {
+			for i, item := range items {
+				errs = append(errs, s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		}
+		return errs
+	case "string":
+		text, ok := value.(string)
+		if !ok {
+			return invalid("Invalid value: %v: must be a string", value)
+		}
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, text) {
+			return invalid("Unsupported value: %q: supported values: %s", text, strings.Join(s.Enum, ", "))
+		}
+	case "integer":
+		switch number := value.(type) {
+		case int64:
+		case float64:
+			if number != math.Trunc(number) {
+				return invalid("Invalid value: %v: must be an integer", value)
+			}
+		default:
+			return invalid("Invalid value: %v: must be an integer", value)
+		}
+	case "number":
+		switch value.(type) {
+		case int64, float64:
+		default:
+			return invalid("Invalid value: %v: must be a number", value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return invalid("Invalid value: %v: must be a boolean", value)
+		}
+	}
+
+	return nil
+}
+
+func (s *crdSchema) validateFields(path string, fields map[string]interface{}) []error {
+	var errs []error
+	for _, name := range s.Required {
+		if _, ok := fields[name]; !ok {
+			errs = append(errs, fmt.Errorf("%s: Required value", strings.TrimPrefix(path+"."+name, ".")))
+		}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := path + "." + name
+		if property, ok := s.Properties[name]; ok {
+			errs = append(errs, property.validate(field, fields[name])...)
+			continue
+		}
+		if s.AdditionalProperties != nil {
+			errs = append(errs, s.AdditionalProperties.validate(field, fields[name])...)
+			continue
+		}
+		if !s.PreserveUnknownFields {
+			errs = append(errs, fmt.Errorf("%s: field not declared in schema", strings.TrimPrefix(field, ".")))
+		}
+	}
+
+	return errs
+}
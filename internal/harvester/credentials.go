@@ -0,0 +1,102 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// argoCDAdminSecret holds the initial ArgoCD admin password, ArgoCD deletes
+// it once the password is changed
+const argoCDAdminSecret = "argocd-initial-admin-secret"
+
+// RootCredentials are the platform admin credentials kubefirst stored in the
+// cluster, and how Vault gets unsealed
+type RootCredentials struct {
+	ArgoCDPassword  string
+	VaultRootToken  string
+	GrafanaPassword string
+	// VaultUnsealMode is the --vault-auto-unseal mode, "manual" when unset
+	VaultUnsealMode string
+	// VaultUnsealKeys tells where the static mode reads the unseal keys
+	VaultUnsealKeys string
+}
+
+// ReadRootCredentials reads the ArgoCD admin password, when vault is set
+// the Vault root token and when observability is set the Grafana admin
+// password from the cluster. An ArgoCD password that was already changed
+// is left empty
+func (c *Client) ReadRootCredentials(ctx context.Context, vault, observability bool, unsealMode string) (*RootCredentials, error) {
+	credentials := &RootCredentials{}
+
+	secret, err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Get(ctx, argoCDAdminSecret, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", ArgoCDNamespace, argoCDAdminSecret, err)
+	default:
+		credentials.ArgoCDPassword = string(secret.Data["password"])
+	}
+
+	if observability {
+		grafana, err := c.Clientset.CoreV1().Secrets(ObservabilityNamespace).Get(ctx, GrafanaAdminSecret, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read secret %s/%s: %w", ObservabilityNamespace, GrafanaAdminSecret, err)
+		}
+		credentials.GrafanaPassword = string(grafana.Data["admin-password"])
+	}
+
+	if !vault {
+		return credentials, nil
+	}
+
+	unseal, err := c.VaultUnsealSecret(ctx)
+	if err != nil {
+		return nil, err
+	}
+	credentials.VaultRootToken = string(unseal["root-token"])
+
+	credentials.VaultUnsealMode = unsealMode
+	if unsealMode == "" {
+		credentials.VaultUnsealMode = "manual"
+	}
+	if unsealMode == VaultUnsealStatic {
+		credentials.VaultUnsealKeys = VaultUnsealKeysLocation()
+	}
+
+	return credentials, nil
+}
+
+// Text renders the credentials for the terminal
+func (r *RootCredentials) Text() string {
+	var b strings.Builder
+
+	argoCDPassword := r.ArgoCDPassword
+	if argoCDPassword == "" {
+		argoCDPassword = fmt.Sprintf("(secret %s/%s is gone, the password was changed)", ArgoCDNamespace, argoCDAdminSecret)
+	}
+	fmt.Fprintf(&b, "ArgoCD admin password: %s\n", argoCDPassword)
+
+	if r.GrafanaPassword != "" {
+		fmt.Fprintf(&b, "Grafana admin password: %s (user %s)\n", r.GrafanaPassword, grafanaAdminUser)
+	}
+
+	if r.VaultRootToken != "" {
+		fmt.Fprintf(&b, "Vault root token: %s\n", r.VaultRootToken)
+		fmt.Fprintf(&b, "Vault unseal mode: %s\n", r.VaultUnsealMode)
+	}
+	if r.VaultUnsealKeys != "" {
+		fmt.Fprintf(&b, "Vault unseal keys: %s, anyone able to read it can unseal Vault\n", r.VaultUnsealKeys)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,31 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReadRootCredentials(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vaultSecretName, Namespace: vaultNamespace},
+		Data:       map[string][]byte{"root-token": []byte("hvs.root"), "root-unseal-key-0": []byte("key")},
+	})}
+
+	credentials, err := client.ReadRootCredentials(context.Background(), true, false, VaultUnsealStatic)
+	require.NoError(t, err)
+	assert.Empty(t, credentials.ArgoCDPassword)
+	assert.Equal(t, "hvs.root", credentials.VaultRootToken)
+	assert.Equal(t, "secret vault/vault-unseal-secret, keys root-unseal-key-*", credentials.VaultUnsealKeys)
+	assert.Contains(t, credentials.Text(), "Vault unseal mode: static")
+
+	credentials, err = client.ReadRootCredentials(context.Background(), true, false, "")
+	require.NoError(t, err)
+	assert.Equal(t, "manual", credentials.VaultUnsealMode)
+	assert.Empty(t, credentials.VaultUnsealKeys)
+}
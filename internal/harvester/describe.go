@@ -0,0 +1,384 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	istiodDeployment = "istiod"
+	nodeRolePrefix   = "node-role.kubernetes.io/"
+)
+
+var clusterIssuerGVR = schema.GroupVersionResource{Group: "cert-manager.io", Version: "v1", Resource: "clusterissuers"}
+
+// DescribeOptions is the recorded configuration a ClusterDescription
+// reports next to what it reads from the cluster
+type DescribeOptions struct {
+	ClusterName             string
+	DomainName              string
+	DNSProvider             string
+	ExtraDomains            []string
+	VClusters               []string
+	VClusterDomainMap       map[string]string
+	VClusterIngressWildcard bool
+	Vault                   bool
+}
+
+// DescribedNode is a node of the cluster
+type DescribedNode struct {
+	Name    string   `json:"name" yaml:"name"`
+	Roles   []string `json:"roles" yaml:"roles"`
+	Version string   `json:"version" yaml:"version"`
+	Ready   bool     `json:"ready" yaml:"ready"`
+}
+
+// DescribedApplication is an ArgoCD application and its state
+type DescribedApplication struct {
+	Name        string `json:"name" yaml:"name"`
+	Destination string `json:"destination" yaml:"destination"`
+	Revision    string `json:"revision,omitempty" yaml:"revision,omitempty"`
+	Sync        string `json:"sync" yaml:"sync"`
+	Health      string `json:"health" yaml:"health"`
+}
+
+// DescribedVCluster is a vcluster and the host namespace it runs in
+type DescribedVCluster struct {
+	Name      string `json:"name" yaml:"name"`
+	Namespace string `json:"namespace" yaml:"namespace"`
+	Domain    string `json:"domain" yaml:"domain"`
+	Present   bool   `json:"present" yaml:"present"`
+}
+
+// DescribedClusterIssuer is a cert-manager ClusterIssuer
+type DescribedClusterIssuer struct {
+	Name   string `json:"name" yaml:"name"`
+	Type   string `json:"type" yaml:"type"`
+	Server string `json:"server,omitempty" yaml:"server,omitempty"`
+	Ready  bool   `json:"ready" yaml:"ready"`
+}
+
+// DescribedDNS is the DNS zone configuration of the platform
+type DescribedDNS struct {
+	Provider     string   `json:"provider" yaml:"provider"`
+	Domain       string   `json:"domain" yaml:"domain"`
+	ExtraDomains []string `json:"extraDomains,omitempty" yaml:"extraDomains,omitempty"`
+	Hosts        []string `json:"hosts" yaml:"hosts"`
+}
+
+// ClusterDescription is everything describe reports about a provisioned
+// cluster. It holds no timestamps and sorts every list, so two
+// descriptions of an unchanged cluster are identical
+type ClusterDescription struct {
+	ClusterName       string                   `json:"clusterName" yaml:"clusterName"`
+	KubernetesVersion string                   `json:"kubernetesVersion" yaml:"kubernetesVersion"`
+	NodeCount         int                      `json:"nodeCount" yaml:"nodeCount"`
+	Nodes             []DescribedNode          `json:"nodes" yaml:"nodes"`
+	ArgoCDVersion     string                   `json:"argocdVersion,omitempty" yaml:"argocdVersion,omitempty"`
+	Applications      []DescribedApplication   `json:"applications" yaml:"applications"`
+	VClusters         []DescribedVCluster      `json:"vclusters" yaml:"vclusters"`
+	IstioVersion      string                   `json:"istioVersion,omitempty" yaml:"istioVersion,omitempty"`
+	ClusterIssuers    []DescribedClusterIssuer `json:"clusterIssuers" yaml:"clusterIssuers"`
+	DNS               DescribedDNS             `json:"dns" yaml:"dns"`
+	// Unavailable names the sections that could not be read and why
+	Unavailable map[string]string `json:"unavailable,omitempty" yaml:"unavailable,omitempty"`
+}
+
+// Describe reads the description of the cluster. Only an unreachable
+// Kubernetes API fails it; sections depending on components that are
+// missing or unhealthy are reported as unavailable instead
+func (c *Client) Describe(ctx context.Context, opts DescribeOptions) (*ClusterDescription, error) {
+	description := &ClusterDescription{
+		ClusterName:    opts.ClusterName,
+		Nodes:          []DescribedNode{},
+		Applications:   []DescribedApplication{},
+		VClusters:      []DescribedVCluster{},
+		ClusterIssuers: []DescribedClusterIssuer{},
+		DNS: DescribedDNS{
+			Provider:     opts.DNSProvider,
+			Domain:       opts.DomainName,
+			ExtraDomains: opts.ExtraDomains,
+			Hosts:        PlatformHosts(opts.DomainName, opts.Vault, opts.VClusters, opts.VClusterDomainMap, opts.ExtraDomains, opts.VClusterIngressWildcard),
+		},
+		Unavailable: map[string]string{},
+	}
+	sort.Strings(description.DNS.Hosts)
+
+	version, err := c.Clientset.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the kubernetes version: %w", err)
+	}
+	description.KubernetesVersion = version.GitVersion
+
+	if err := c.describeNodes(ctx, description); err != nil {
+		description.Unavailable["nodes"] = err.Error()
+	}
+	if err := c.describeApplications(ctx, description); err != nil {
+		description.Unavailable["applications"] = err.Error()
+	}
+	if err := c.describeVClusters(ctx, description, opts); err != nil {
+		description.Unavailable["vclusters"] = err.Error()
+	}
+	if err := c.describeIssuers(ctx, description); err != nil {
+		description.Unavailable["clusterIssuers"] = err.Error()
+	}
+
+	description.ArgoCDVersion, err = c.deploymentImageTag(ctx, ArgoCDNamespace, argoCDServerDeployment)
+	if err != nil {
+		description.Unavailable["argocdVersion"] = err.Error()
+	}
+	description.IstioVersion, err = c.deploymentImageTag(ctx, IstioNamespace, istiodDeployment)
+	if err != nil {
+		description.Unavailable["istioVersion"] = err.Error()
+	}
+
+	if len(description.Unavailable) == 0 {
+		description.Unavailable = nil
+	}
+
+	return description, nil
+}
+
+func (c *Client) describeNodes(ctx context.Context, description *ClusterDescription) error {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	for _, node := range nodes.Items {
+		described := DescribedNode{Name: node.Name, Roles: []string{}, Version: node.Status.NodeInfo.KubeletVersion}
+		for label := range node.Labels {
+			if role, ok := strings.CutPrefix(label, nodeRolePrefix); ok && role != "" {
+				described.Roles = append(described.Roles, role)
+			}
+		}
+		sort.Strings(described.Roles)
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				described.Ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+		description.Nodes = append(description.Nodes, described)
+	}
+	sort.Slice(description.Nodes, func(i, j int) bool { return description.Nodes[i].Name < description.Nodes[j].Name })
+	description.NodeCount = len(description.Nodes)
+
+	return nil
+}
+
+func (c *Client) describeApplications(ctx context.Context, description *ClusterDescription) error {
+	apps, err := c.ListApplications(ctx, "*")
+	if err != nil && !errors.Is(err, ErrApplicationNotFound) {
+		return err
+	}
+
+	for _, app := range apps {
+		described := DescribedApplication{
+			Name:        app.Name,
+			Destination: app.Spec.Destination.Namespace,
+			Revision:    app.Status.Sync.Revision,
+			Sync:        string(app.Status.Sync.Status),
+			Health:      string(app.Status.Health.Status),
+		}
+		description.Applications = append(description.Applications, described)
+	}
+
+	return nil
+}
+
+func (c *Client) describeVClusters(ctx context.Context, description *ClusterDescription, opts DescribeOptions) error {
+	vclusters, err := c.DescribeVClusters(ctx, opts.VClusters, opts.DomainName, opts.VClusterDomainMap)
+	if err != nil {
+		return err
+	}
+	description.VClusters = vclusters
+
+	return nil
+}
+
+// DescribeVClusters describes the vclusters sorted by name, noting whether
+// their host namespace exists
+func (c *Client) DescribeVClusters(ctx context.Context, vclusters []string, domainName string, domainMap map[string]string) ([]DescribedVCluster, error) {
+	vclusters = append([]string(nil), vclusters...)
+	sort.Strings(vclusters)
+
+	var described []DescribedVCluster
+	for _, vcluster := range vclusters {
+		namespace := VClusterNamespace(vcluster)
+		_, err := c.Clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get namespace %q of vcluster %q: %w", namespace, vcluster, err)
+		}
+
+		described = append(described, DescribedVCluster{
+			Name:      vcluster,
+			Namespace: namespace,
+			Domain:    VClusterDomain(vcluster, domainName, domainMap),
+			Present:   err == nil,
+		})
+	}
+
+	return described, nil
+}
+
+func (c *Client) describeIssuers(ctx context.Context, description *ClusterDescription) error {
+	issuers, err := c.Dynamic.Resource(clusterIssuerGVR).List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("cert-manager is not installed")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list cluster issuers: %w", err)
+	}
+
+	for _, issuer := range issuers.Items {
+		description.ClusterIssuers = append(description.ClusterIssuers, describeIssuer(issuer))
+	}
+	sort.Slice(description.ClusterIssuers, func(i, j int) bool {
+		return description.ClusterIssuers[i].Name < description.ClusterIssuers[j].Name
+	})
+
+	return nil
+}
+
+// describeIssuer reads the issuer type, the first key of its spec such as
+// acme or ca, and its Ready condition
+func describeIssuer(issuer unstructured.Unstructured) DescribedClusterIssuer {
+	described := DescribedClusterIssuer{Name: issuer.GetName()}
+
+	spec, _, _ := unstructured.NestedMap(issuer.Object, "spec")
+	if kinds := sortedKeys(spec); len(kinds) > 0 {
+		described.Type = kinds[0]
+	}
+	described.Server, _, _ = unstructured.NestedString(issuer.Object, "spec", "acme", "server")
+
+	conditions, _, _ := unstructured.NestedSlice(issuer.Object, "status", "conditions")
+	for _, condition := range conditions {
+		fields, ok := condition.(map[string]interface{})
+		if ok && fields["type"] == "Ready" {
+			described.Ready = fields["status"] == string(metav1.ConditionTrue)
+		}
+	}
+
+	return described
+}
+
+// deploymentImageTag returns the image tag of the first container of the
+// deployment, or an empty tag when it does not exist
+func (c *Client) deploymentImageTag(ctx context.Context, namespace, name string) (string, error) {
+	deployment, err := c.Clientset.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s/%s: %w", namespace, name, err)
+	}
+
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if tag := imageTag(container.Image); tag != "" {
+			return tag, nil
+		}
+	}
+
+	return "", nil
+}
+
+// imageTag returns the tag of image, ignoring a digest and registry ports
+func imageTag(image string) string {
+	image, _, _ = strings.Cut(image, "@")
+	if i := strings.LastIndex(image, ":"); i >= 0 && !strings.Contains(image[i:], "/") {
+		return image[i+1:]
+	}
+
+	return ""
+}
+
+// YAML renders the description as a YAML document
+func (d *ClusterDescription) YAML() ([]byte, error) {
+	data, err := yaml.Marshal(d)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cluster description: %w", err)
+	}
+
+	return data, nil
+}
+
+// Text renders the description as sections of human readable tables
+func (d *ClusterDescription) Text() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	section := func(title string) {
+		fmt.Fprintf(w, "\n%s\n", title)
+	}
+	orNone := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+
+	fmt.Fprintf(w, "Cluster:\t%s\n", d.ClusterName)
+	fmt.Fprintf(w, "Kubernetes:\t%s\n", d.KubernetesVersion)
+	fmt.Fprintf(w, "ArgoCD:\t%s\n", orNone(d.ArgoCDVersion))
+	fmt.Fprintf(w, "Istio:\t%s\n", orNone(d.IstioVersion))
+
+	section(fmt.Sprintf("Nodes (%d):", d.NodeCount))
+	fmt.Fprintln(w, "  NAME\tROLES\tVERSION\tREADY")
+	for _, node := range d.Nodes {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%t\n", node.Name, orNone(strings.Join(node.Roles, ",")), node.Version, node.Ready)
+	}
+
+	section(fmt.Sprintf("ArgoCD Applications (%d):", len(d.Applications)))
+	fmt.Fprintln(w, "  NAME\tDESTINATION\tSYNC\tHEALTH")
+	for _, app := range d.Applications {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", app.Name, orNone(app.Destination), orNone(app.Sync), orNone(app.Health))
+	}
+
+	section(fmt.Sprintf("vClusters (%d):", len(d.VClusters)))
+	fmt.Fprintln(w, "  NAME\tNAMESPACE\tDOMAIN\tPRESENT")
+	for _, vcluster := range d.VClusters {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%t\n", vcluster.Name, vcluster.Namespace, vcluster.Domain, vcluster.Present)
+	}
+
+	section(fmt.Sprintf("ClusterIssuers (%d):", len(d.ClusterIssuers)))
+	fmt.Fprintln(w, "  NAME\tTYPE\tSERVER\tREADY")
+	for _, issuer := range d.ClusterIssuers {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%t\n", issuer.Name, orNone(issuer.Type), orNone(issuer.Server), issuer.Ready)
+	}
+
+	section("DNS:")
+	fmt.Fprintf(w, "  Provider:\t%s\n", orNone(d.DNS.Provider))
+	fmt.Fprintf(w, "  Domain:\t%s\n", orNone(d.DNS.Domain))
+	if len(d.DNS.ExtraDomains) > 0 {
+		fmt.Fprintf(w, "  Extra domains:\t%s\n", strings.Join(d.DNS.ExtraDomains, ", "))
+	}
+	for _, host := range d.DNS.Hosts {
+		fmt.Fprintf(w, "  Host:\t%s\n", host)
+	}
+
+	if len(d.Unavailable) > 0 {
+		section("Unavailable:")
+		for _, name := range sortedKeys(d.Unavailable) {
+			fmt.Fprintf(w, "  %s:\t%s\n", name, d.Unavailable[name])
+		}
+	}
+	w.Flush()
+
+	return strings.TrimPrefix(b.String(), "\n")
+}
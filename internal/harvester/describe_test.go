@@ -0,0 +1,119 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newDescribeClient(dynamicObjects ...runtime.Object) *Client {
+	node := func(name string, ready corev1.ConditionStatus, roles ...string) *corev1.Node {
+		n := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+		for _, role := range roles {
+			n.Labels[nodeRolePrefix+role] = "true"
+		}
+		n.Status.NodeInfo.KubeletVersion = "v1.29.4+rke2r1"
+		n.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: ready}}
+		return n
+	}
+	istiod := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: istiodDeployment, Namespace: IstioNamespace},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "discovery", Image: "docker.io/istio/pilot:1.22.1"}},
+		}}},
+	}
+
+	vault := newDestinationApplication("vault", "vault")
+	vault.Status.Sync.Status = v1alpha1.SyncStatusCodeOutOfSync
+	vault.Status.Health.Status = health.HealthStatusDegraded
+
+	return &Client{
+		Clientset: fake.NewSimpleClientset(
+			node("worker-1", corev1.ConditionTrue),
+			node("cp-1", corev1.ConditionTrue, "control-plane", "etcd"),
+			istiod,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: VClusterNamespace("dev")}},
+		),
+		ArgoCD: argocdfake.NewSimpleClientset(vault, newDestinationApplication("registry", ArgoCDNamespace)),
+		Dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{clusterIssuerGVR: "ClusterIssuerList"},
+			dynamicObjects...,
+		),
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	issuer := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "ClusterIssuer",
+		"metadata":   map[string]interface{}{"name": "letsencrypt-prod"},
+		"spec":       map[string]interface{}{"acme": map[string]interface{}{"server": "https://acme-v02.api.letsencrypt.org/directory"}},
+		"status":     map[string]interface{}{"conditions": []interface{}{map[string]interface{}{"type": "Ready", "status": "True"}}},
+	}}
+	client := newDescribeClient(issuer)
+
+	description, err := client.Describe(context.Background(), DescribeOptions{
+		ClusterName: "demo",
+		DomainName:  "example.com",
+		DNSProvider: "cloudflare",
+		VClusters:   []string{"prod", "dev"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, description.NodeCount)
+	assert.Equal(t, DescribedNode{Name: "cp-1", Roles: []string{"control-plane", "etcd"}, Version: "v1.29.4+rke2r1", Ready: true}, description.Nodes[0])
+	assert.Equal(t, "1.22.1", description.IstioVersion)
+	assert.Empty(t, description.ArgoCDVersion)
+
+	// degraded applications are described, not failed
+	require.Len(t, description.Applications, 2)
+	assert.Equal(t, DescribedApplication{Name: "vault", Destination: "vault", Sync: "OutOfSync", Health: "Degraded"}, description.Applications[1])
+
+	assert.Equal(t, []DescribedVCluster{
+		{Name: "dev", Namespace: "vcluster-dev", Domain: "dev.example.com", Present: true},
+		{Name: "prod", Namespace: "vcluster-prod", Domain: "prod.example.com", Present: false},
+	}, description.VClusters)
+
+	assert.Equal(t, []DescribedClusterIssuer{
+		{Name: "letsencrypt-prod", Type: "acme", Server: "https://acme-v02.api.letsencrypt.org/directory", Ready: true},
+	}, description.ClusterIssuers)
+	assert.Equal(t, "cloudflare", description.DNS.Provider)
+	assert.Nil(t, description.Unavailable)
+
+	text := description.Text()
+	assert.Contains(t, text, "Nodes (2):")
+	assert.Contains(t, text, "letsencrypt-prod")
+
+	// two descriptions of the same cluster render identically
+	first, err := description.YAML()
+	require.NoError(t, err)
+	again, err := client.Describe(context.Background(), DescribeOptions{
+		ClusterName: "demo",
+		DomainName:  "example.com",
+		DNSProvider: "cloudflare",
+		VClusters:   []string{"dev", "prod"},
+	})
+	require.NoError(t, err)
+	second, err := again.YAML()
+	require.NoError(t, err)
+	assert.Equal(t, string(first), string(second))
+}
+
+func TestImageTag(t *testing.T) {
+	assert.Equal(t, "v2.11.3", imageTag("quay.io/argoproj/argocd:v2.11.3"))
+	assert.Equal(t, "1.22.1", imageTag("registry:5000/istio/pilot:1.22.1@sha256:abc"))
+	assert.Empty(t, imageTag("registry:5000/istio/pilot"))
+}
@@ -0,0 +1,258 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultDoHURL is the DNS-over-HTTPS resolver used when --dns-check-doh
+	// is set without a url
+	DefaultDoHURL = "https://cloudflare-dns.com/dns-query"
+
+	// DefaultDNSPropagationTimeout is how long the platform records get to
+	// become resolvable once ingress is provisioned
+	DefaultDNSPropagationTimeout = 10 * time.Minute
+
+	dnsPollInterval = 10 * time.Second
+
+	// dnsTransportFailureHint is the number of consecutive resolver failures
+	// after which the system resolver is assumed to be blocked or hijacked
+	dnsTransportFailureHint = 3
+
+	dohContentType = "application/dns-message"
+)
+
+// HostResolver resolves host names to addresses
+type HostResolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// NewHostResolver returns the system resolver, or a DNS-over-HTTPS resolver
+// querying dohURL through proxy as described by ProxyFunc when dohURL is set
+func NewHostResolver(dohURL, proxy string) (HostResolver, error) {
+	if dohURL == "" {
+		return systemResolver{}, nil
+	}
+
+	parsed, err := url.Parse(dohURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("DNS-over-HTTPS resolver %q must be an https url", dohURL)
+	}
+
+	httpClient, err := NewHTTPClient(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dohResolver{url: dohURL, httpClient: httpClient}, nil
+}
+
+type systemResolver struct{}
+
+func (systemResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+type dohResolver struct {
+	url        string
+	httpClient *http.Client
+}
+
+// LookupHost resolves the A and AAAA records of host with RFC 8484 GET
+// requests, which read-only mode allows
+func (r *dohResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	var addresses []string
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		found, err := r.lookup(ctx, host, qtype)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, found...)
+	}
+
+	if len(addresses) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: r.url, IsNotFound: true}
+	}
+
+	return addresses, nil
+}
+
+func (r *dohResolver) lookup(ctx context.Context, host string, qtype dnsmessage.Type) ([]string, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid host name %q: %w", host, err)
+	}
+
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode dns query for %q: %w", host, err)
+	}
+
+	endpoint, err := url.Parse(r.url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS-over-HTTPS resolver %q: %w", r.url, err)
+	}
+	params := endpoint.Query()
+	params.Set("dns", base64.RawURLEncoding.EncodeToString(query))
+	endpoint.RawQuery = params.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build dns query for %q: %w", host, err)
+	}
+	req.Header.Set("Accept", dohContentType)
+
+	res, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %q for %q: %w", r.url, host, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query %q for %q: unexpected status %q", r.url, host, res.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dns answer for %q: %w", host, err)
+	}
+
+	var answer dnsmessage.Message
+	if err := answer.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to decode dns answer for %q: %w", host, err)
+	}
+
+	switch answer.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	default:
+		return nil, &net.DNSError{Err: fmt.Sprintf("server answered %s", answer.RCode), Name: host, Server: r.url}
+	}
+
+	var addresses []string
+	for _, resource := range answer.Answers {
+		switch body := resource.Body.(type) {
+		case *dnsmessage.AResource:
+			addresses = append(addresses, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			addresses = append(addresses, net.IP(body.AAAA[:]).String())
+		}
+	}
+
+	return addresses, nil
+}
+
+// PropagationHosts returns the platform hosts that have to resolve publicly
+// before the platform is reachable
+func PropagationHosts(domainName string, vault bool) []string {
+	hosts := []string{fmt.Sprintf("argocd.%s", domainName)}
+	if vault {
+		hosts = append(hosts, fmt.Sprintf("vault.%s", domainName))
+	}
+
+	return hosts
+}
+
+// PlatformHosts returns PropagationHosts along with the IngressDomains and,
+// when wildcard ingress is enabled, the wildcard host of every vcluster
+func PlatformHosts(domainName string, vault bool, vclusters []string, domainMap map[string]string, extraDomains []string, wildcard bool) []string {
+	hosts := PropagationHosts(domainName, vault)
+	hosts = append(hosts, IngressDomainHosts(IngressDomains(domainName, extraDomains, vclusters, domainMap))...)
+	if wildcard {
+		for _, vcluster := range vclusters {
+			hosts = append(hosts, WildcardHostname(vcluster, domainName, domainMap))
+		}
+	}
+
+	return hosts
+}
+
+// WaitForDNSPropagation polls resolver until every host resolves. When the
+// system resolver keeps failing rather than answering, the error suggests
+// --dns-check-doh, as networks hijacking port 53 cause exactly that
+func WaitForDNSPropagation(ctx context.Context, resolver HostResolver, hosts []string) error {
+	ticker := time.NewTicker(dnsPollInterval)
+	defer ticker.Stop()
+
+	_, system := resolver.(systemResolver)
+	failures := map[string]int{}
+
+	for {
+		pending := map[string]error{}
+		for _, host := range hosts {
+			_, err := resolver.LookupHost(ctx, host)
+			if err == nil {
+				delete(failures, host)
+				continue
+			}
+			pending[host] = err
+
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && !dnsErr.IsNotFound {
+				failures[host]++
+			} else {
+				delete(failures, host)
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return dnsPropagationError(ctx, pending, system && blockedResolver(failures))
+		case <-ticker.C:
+		}
+	}
+}
+
+func blockedResolver(failures map[string]int) bool {
+	for _, count := range failures {
+		if count >= dnsTransportFailureHint {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dnsPropagationError(ctx context.Context, pending map[string]error, blocked bool) error {
+	hosts := make([]string, 0, len(pending))
+	for host := range pending {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	problems := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		problems = append(problems, fmt.Sprintf("%s: %v", host, pending[host]))
+	}
+
+	err := fmt.Errorf("dns records did not propagate: %s: %w", strings.Join(problems, "; "), ctx.Err())
+	if blocked {
+		err = fmt.Errorf("%w; the system resolver repeatedly failed over udp/53, if this network blocks or hijacks dns retry with --dns-check-doh", err)
+	}
+
+	return err
+}
@@ -0,0 +1,108 @@
+package harvester
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func newDoHServer(t *testing.T, records map[string][4]byte) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, dohContentType, r.Header.Get("Accept"))
+
+		raw, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		require.NoError(t, err)
+
+		var query dnsmessage.Message
+		require.NoError(t, query.Unpack(raw))
+		question := query.Questions[0]
+
+		answer := dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true, RCode: dnsmessage.RCodeNameError},
+			Questions: query.Questions,
+		}
+		if addr, ok := records[question.Name.String()]; ok {
+			answer.RCode = dnsmessage.RCodeSuccess
+			if question.Type == dnsmessage.TypeA {
+				answer.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+					Body:   &dnsmessage.AResource{A: addr},
+				}}
+			}
+		}
+
+		packed, err := answer.Pack()
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(packed)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestDoHResolver(t *testing.T) {
+	server := newDoHServer(t, map[string][4]byte{"argocd.example.com.": {10, 0, 12, 5}})
+	resolver := &dohResolver{url: server.URL + "/dns-query", httpClient: server.Client()}
+
+	addresses, err := resolver.LookupHost(context.Background(), "argocd.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.12.5"}, addresses)
+
+	_, err = resolver.LookupHost(context.Background(), "vault.example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+}
+
+func TestNewHostResolver(t *testing.T) {
+	resolver, err := NewHostResolver("", "")
+	require.NoError(t, err)
+	assert.IsType(t, systemResolver{}, resolver)
+
+	resolver, err = NewHostResolver(DefaultDoHURL, "")
+	require.NoError(t, err)
+	assert.IsType(t, &dohResolver{}, resolver)
+
+	_, err = NewHostResolver("http://dns.example.com/dns-query", "")
+	require.ErrorContains(t, err, "must be an https url")
+}
+
+func TestWaitForDNSPropagation(t *testing.T) {
+	server := newDoHServer(t, map[string][4]byte{"argocd.example.com.": {10, 0, 12, 5}})
+	resolver := &dohResolver{url: server.URL, httpClient: server.Client()}
+
+	require.NoError(t, WaitForDNSPropagation(context.Background(), resolver, []string{"argocd.example.com"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := WaitForDNSPropagation(ctx, resolver, PropagationHosts("example.com", true))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "vault.example.com")
+	assert.NotContains(t, err.Error(), "argocd.example.com")
+	assert.NotContains(t, err.Error(), "--dns-check-doh")
+}
+
+func TestDNSPropagationErrorHint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	failures := map[string]int{"argocd.example.com": dnsTransportFailureHint}
+	require.True(t, blockedResolver(failures))
+
+	pending := map[string]error{"argocd.example.com": &net.DNSError{Err: "i/o timeout", IsTimeout: true}}
+	assert.ErrorContains(t, dnsPropagationError(ctx, pending, blockedResolver(failures)), "retry with --dns-check-doh")
+	assert.NotContains(t, dnsPropagationError(ctx, pending, false).Error(), "--dns-check-doh")
+}
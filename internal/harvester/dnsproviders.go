@@ -0,0 +1,163 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	// DNSProviderCloudflare publishes the records in the Cloudflare zone of
+	// the domain with CF_API_TOKEN
+	DNSProviderCloudflare = "cloudflare"
+	// DNSProviderRoute53 publishes the records in the Route 53 hosted zone
+	// of --route53-hosted-zone-id with the AWS credentials of the environment
+	DNSProviderRoute53 = "route53"
+)
+
+// DNSProviders are the values --dns-provider lists
+var DNSProviders = []string{DNSProviderCloudflare, DNSProviderRoute53}
+
+// DNSProviderFlags are the settings each DNS provider needs next to
+// --dns-provider
+type DNSProviderFlags struct {
+	CloudflareAPIToken  string
+	Route53HostedZoneID string
+	// AWSCredentials are the credentials Route 53 is called with, nil when
+	// none could be loaded
+	AWSCredentials aws.CredentialsProvider
+}
+
+// ParseDNSProviders splits the comma separated --dns-provider into the
+// providers the records are published to, in the order given. The first
+// is the one kubefirst-api provisions the cluster with
+func ParseDNSProviders(value string) ([]string, error) {
+	var providers []string
+	for _, provider := range strings.Split(value, ",") {
+		provider = strings.TrimSpace(provider)
+		switch {
+		case provider == "":
+			return nil, fmt.Errorf("empty dns provider in %q", value)
+		case !slices.Contains(DNSProviders, provider):
+			return nil, fmt.Errorf("unknown dns provider %q, must be one of %v", provider, DNSProviders)
+		case slices.Contains(providers, provider):
+			return nil, fmt.Errorf("dns provider %q is listed twice", provider)
+		}
+		providers = append(providers, provider)
+	}
+
+	return providers, nil
+}
+
+// PrimaryDNSProvider returns the first provider of the --dns-provider
+// value, the one kubefirst-api is told about, failing like
+// ParseDNSProviders when the value is invalid
+func PrimaryDNSProvider(value string) (string, error) {
+	providers, err := ParseDNSProviders(value)
+	if err != nil {
+		return "", err
+	}
+
+	return providers[0], nil
+}
+
+// ValidateDNSProviders ensures every provider has the settings it needs,
+// validating each on its own and naming the provider in every error
+func ValidateDNSProviders(ctx context.Context, providers []string, flags DNSProviderFlags) error {
+	var errs []error
+	for _, provider := range providers {
+		var err error
+		switch provider {
+		case DNSProviderCloudflare:
+			if flags.CloudflareAPIToken == "" {
+				err = errors.New("your CF_API_TOKEN environment variable is not set")
+			}
+		case DNSProviderRoute53:
+			err = validateRoute53(ctx, flags)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", provider, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateRoute53(ctx context.Context, flags DNSProviderFlags) error {
+	var errs []error
+	if strings.TrimPrefix(flags.Route53HostedZoneID, "/hostedzone/") == "" {
+		errs = append(errs, errors.New(`required flag "route53-hosted-zone-id" not set`))
+	}
+	if flags.AWSCredentials == nil {
+		errs = append(errs, errors.New("no AWS credentials found"))
+	} else if _, err := flags.AWSCredentials.Retrieve(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("failed to retrieve AWS credentials: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// DNSRecordPublisher reconciles the platform records at a DNS provider,
+// see CloudflareDNS.ReconcileRecords
+type DNSRecordPublisher interface {
+	ReconcileRecords(ctx context.Context, desired map[string]string, comment string, knownZones []string, prune bool) (DNSReconcileResult, error)
+}
+
+// DNSProvider reads and changes the platform records at a DNS provider,
+// the records of a provider being those ReconcileRecords publishes there
+type DNSProvider interface {
+	DNSRecordPublisher
+	ZoneRecords(ctx context.Context, hosts []string) ([]DNSRecord, error)
+	ManagedRecords(ctx context.Context, hosts []string, comment string) ([]DNSRecord, error)
+	ARecord(ctx context.Context, host string) (*DNSRecord, error)
+	UpdateARecord(ctx context.Context, record *DNSRecord, address string) error
+	DeleteRecord(ctx context.Context, record DNSRecord) error
+}
+
+// DNSTarget is a provider the platform records are published to
+type DNSTarget struct {
+	Provider  string
+	Publisher DNSRecordPublisher
+	// KnownZones are the zones the last reconcile at the provider recorded
+	KnownZones []string
+}
+
+// PublishDNSRecords reconciles desired at every target at once, returning
+// the result of each by provider. Every target is waited for, the error
+// joins the failure of each provider that failed
+func PublishDNSRecords(ctx context.Context, targets []DNSTarget, desired map[string]string, comment string, prune bool) (map[string]DNSReconcileResult, error) {
+	results := make([]DNSReconcileResult, len(targets))
+	errs := make([]error, len(targets))
+
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := target.Publisher.ReconcileRecords(ctx, desired, comment, target.KnownZones, prune)
+			results[i] = result
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", target.Provider, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	byProvider := make(map[string]DNSReconcileResult, len(targets))
+	for i, target := range targets {
+		byProvider[target.Provider] = results[i]
+	}
+
+	return byProvider, errors.Join(errs...)
+}
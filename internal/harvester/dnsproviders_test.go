@@ -0,0 +1,70 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDNSProviders(t *testing.T) {
+	providers, err := ParseDNSProviders("cloudflare, route53")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cloudflare", "route53"}, providers)
+
+	primary, err := PrimaryDNSProvider("route53,cloudflare")
+	require.NoError(t, err)
+	assert.Equal(t, "route53", primary)
+	_, err = PrimaryDNSProvider(",cloudflare")
+	require.ErrorContains(t, err, "empty dns provider")
+
+	for value, expected := range map[string]string{
+		"cloudflare,":             `empty dns provider in "cloudflare,"`,
+		"cloudflare,digitalocean": `unknown dns provider "digitalocean"`,
+		"route53,route53":         `dns provider "route53" is listed twice`,
+	} {
+		_, err := ParseDNSProviders(value)
+		require.ErrorContains(t, err, expected, value)
+	}
+}
+
+func TestValidateDNSProviders(t *testing.T) {
+	failing := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{}, fmt.Errorf("test error")
+	})
+
+	err := ValidateDNSProviders(context.Background(), []string{"cloudflare", "route53"}, DNSProviderFlags{AWSCredentials: failing})
+	require.Error(t, err)
+	assert.Equal(t, "cloudflare: your CF_API_TOKEN environment variable is not set\n"+
+		"route53: required flag \"route53-hosted-zone-id\" not set\n"+
+		"failed to retrieve AWS credentials: test error", err.Error())
+
+	require.ErrorContains(t, ValidateDNSProviders(context.Background(), []string{"route53"}, DNSProviderFlags{Route53HostedZoneID: "Z0123"}), "route53: no AWS credentials found")
+	require.NoError(t, ValidateDNSProviders(context.Background(), []string{"cloudflare"}, DNSProviderFlags{CloudflareAPIToken: "token"}))
+}
+
+type fakePublisher struct {
+	result DNSReconcileResult
+	err    error
+}
+
+func (p fakePublisher) ReconcileRecords(context.Context, map[string]string, string, []string, bool) (DNSReconcileResult, error) {
+	return p.result, p.err
+}
+
+func TestPublishDNSRecords(t *testing.T) {
+	desired := map[string]string{"argocd.example.com": "10.0.12.5"}
+	cloudflare := DNSTarget{Provider: "cloudflare", Publisher: fakePublisher{result: DNSReconcileResult{Created: []string{"argocd.example.com"}}}}
+
+	results, err := PublishDNSRecords(context.Background(), []DNSTarget{cloudflare, {Provider: "route53", Publisher: fakePublisher{}}}, desired, "comment", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"argocd.example.com"}, results["cloudflare"].Created)
+	assert.Contains(t, results, "route53")
+
+	results, err = PublishDNSRecords(context.Background(), []DNSTarget{cloudflare, {Provider: "route53", Publisher: fakePublisher{err: fmt.Errorf("test error")}}}, desired, "comment", false)
+	require.EqualError(t, err, "route53: test error")
+	assert.Equal(t, []string{"argocd.example.com"}, results["cloudflare"].Created)
+}
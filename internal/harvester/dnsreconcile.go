@@ -0,0 +1,290 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManagedRecordComment is the comment the records create manages for
+// clusterName carry. Records without it were made by hand or by
+// external-dns and are never changed
+func ManagedRecordComment(clusterName string) string {
+	return "managed by kubefirst cluster " + clusterName
+}
+
+// DNSReconcileResult lists the hosts a reconcile changed
+type DNSReconcileResult struct {
+	Created []string
+	Updated []string
+	Deleted []string
+	// Unmanaged are desired hosts that have records without the managed
+	// comment, left as they are
+	Unmanaged []string
+	// Zones hold the managed records, the next reconcile prunes them
+	Zones []string
+}
+
+// Changed reports whether the reconcile changed any record
+func (r DNSReconcileResult) Changed() bool {
+	return len(r.Created)+len(r.Updated)+len(r.Deleted) > 0
+}
+
+// Summary renders the changes for the terminal
+func (r DNSReconcileResult) Summary() string {
+	var parts []string
+	for _, change := range []struct {
+		verb  string
+		hosts []string
+	}{{"created", r.Created}, {"updated", r.Updated}, {"deleted", r.Deleted}, {"left unmanaged", r.Unmanaged}} {
+		if len(change.hosts) > 0 {
+			parts = append(parts, fmt.Sprintf("%s %s", change.verb, strings.Join(change.hosts, ", ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "dns records up to date"
+	}
+
+	return "dns records " + strings.Join(parts, "; ")
+}
+
+// ReconcileRecords points the A record of every host of desired at its
+// address, creating the missing ones with comment. With prune, the records
+// carrying comment in the zones of desired and in knownZones that are no
+// longer desired are deleted
+func (d *CloudflareDNS) ReconcileRecords(ctx context.Context, desired map[string]string, comment string, knownZones []string, prune bool) (DNSReconcileResult, error) {
+	var result DNSReconcileResult
+	zones := map[string]bool{}
+	kept := map[string]bool{}
+
+	for _, host := range sortedKeys(desired) {
+		zoneID, err := d.zoneID(ctx, host)
+		if err != nil {
+			return result, err
+		}
+		zones[zoneID] = true
+
+		records, err := d.aRecords(ctx, zoneID, url.Values{"name": {host}})
+		if err != nil {
+			return result, fmt.Errorf("failed to look up the A record of %q: %w", host, err)
+		}
+
+		managed := slices.DeleteFunc(slices.Clone(records), func(record DNSRecord) bool {
+			return record.Comment != comment
+		})
+		switch {
+		case len(managed) > 0:
+			record := managed[0]
+			kept[record.ID] = true
+			if record.Content == desired[host] {
+				continue
+			}
+			if err := d.UpdateARecord(ctx, &record, desired[host]); err != nil {
+				return result, err
+			}
+			result.Updated = append(result.Updated, host)
+		case len(records) > 0:
+			result.Unmanaged = append(result.Unmanaged, host)
+		default:
+			id, err := d.createARecord(ctx, zoneID, host, desired[host], comment)
+			if err != nil {
+				return result, err
+			}
+			kept[id] = true
+			result.Created = append(result.Created, host)
+		}
+	}
+
+	if !prune {
+		for _, zoneID := range knownZones {
+			zones[zoneID] = true
+		}
+		result.Zones = sortedKeys(zones)
+
+		return result, nil
+	}
+
+	pruned := sortedKeys(zones)
+	for _, zoneID := range knownZones {
+		if !zones[zoneID] {
+			pruned = append(pruned, zoneID)
+		}
+	}
+	for _, zoneID := range pruned {
+		deleted, err := d.pruneZone(ctx, zoneID, comment, kept)
+		result.Deleted = append(result.Deleted, deleted...)
+		if err != nil {
+			return result, err
+		}
+	}
+	sort.Strings(result.Deleted)
+	// the zones no longer desired hold no managed records anymore
+	result.Zones = sortedKeys(zones)
+
+	return result, nil
+}
+
+// pruneZone deletes the records of zoneID carrying comment that are not
+// kept, including duplicates of the desired ones
+func (d *CloudflareDNS) pruneZone(ctx context.Context, zoneID, comment string, kept map[string]bool) ([]string, error) {
+	records, err := d.aRecords(ctx, zoneID, url.Values{"comment.exact": {comment}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the managed records of zone %s: %w", zoneID, err)
+	}
+
+	var deleted []string
+	for _, record := range records {
+		// the filter is exact, the check guards against it being ignored
+		if kept[record.ID] || record.Comment != comment {
+			continue
+		}
+		if err := d.DeleteRecord(ctx, record); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, record.Name)
+	}
+
+	return deleted, nil
+}
+
+// ManagedRecords returns the A records of hosts carrying comment, the ones
+// create made for them
+func (d *CloudflareDNS) ManagedRecords(ctx context.Context, hosts []string, comment string) ([]DNSRecord, error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+
+	records, err := d.ZoneRecords(ctx, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(records, func(record DNSRecord) bool {
+		return record.Comment != comment || !slices.Contains(hosts, record.Name)
+	}), nil
+}
+
+// DeleteRecord deletes record from its zone
+func (d *CloudflareDNS) DeleteRecord(ctx context.Context, record DNSRecord) error {
+	if err := d.request(ctx, "Cloudflare record deletion", http.MethodDelete, fmt.Sprintf("zones/%s/dns_records/%s", record.ZoneID, record.ID), nil, nil); err != nil {
+		return fmt.Errorf("failed to delete the %s record of %q: %w", recordType(record), record.Name, err)
+	}
+
+	return nil
+}
+
+func recordType(record DNSRecord) string {
+	if record.Type == "" {
+		return "A"
+	}
+
+	return record.Type
+}
+
+func (d *CloudflareDNS) createARecord(ctx context.Context, zoneID, host, address, comment string) (string, error) {
+	body := map[string]interface{}{
+		"type":    "A",
+		"name":    host,
+		"content": address,
+		"ttl":     1,
+		"proxied": false,
+		"comment": comment,
+	}
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cloudflare request: %w", err)
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	err = d.Retry.DoUnlessExists(ctx, "Cloudflare record creation", func(ctx context.Context) error {
+		return d.send(ctx, http.MethodPost, fmt.Sprintf("zones/%s/dns_records", zoneID), encoded, &created)
+	}, func(ctx context.Context) (bool, error) {
+		records, err := d.aRecords(ctx, zoneID, url.Values{"name": {host}, "comment.exact": {comment}})
+		if err != nil || len(records) == 0 {
+			return false, err
+		}
+		created.ID = records[0].ID
+		return true, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create the A record of %q: %w", host, err)
+	}
+
+	return created.ID, nil
+}
+
+// DesiredDNSRecords maps every host to the address of the ingress serving
+// it: the wildcard hosts to the wildcard Gateway, the other hosts to the
+// Ingress whose rules name them or else to the domains Gateway listening
+// for them
+func (c *Client) DesiredDNSRecords(ctx context.Context, hosts []string) (map[string]string, error) {
+	ingresses, err := c.Clientset.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+
+	addresses := map[string]string{}
+	for _, ingress := range ingresses.Items {
+		var address string
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			if lb.IP != "" {
+				address = lb.IP
+				break
+			}
+		}
+		for _, rule := range ingress.Spec.Rules {
+			if _, ok := addresses[rule.Host]; !ok && address != "" {
+				addresses[rule.Host] = address
+			}
+		}
+	}
+
+	desired := map[string]string{}
+	var gatewayHosts []string
+	gatewayRead := false
+	for _, host := range hosts {
+		if strings.HasPrefix(host, "*.") {
+			address, err := c.LoadBalancerAddress(ctx, WildcardGatewayNamespace, WildcardGatewayName)
+			if err != nil {
+				return nil, fmt.Errorf("no address for %q: %w", host, err)
+			}
+			desired[host] = address
+			continue
+		}
+
+		address, ok := addresses[host]
+		if !ok {
+			if !gatewayRead {
+				if gatewayHosts, err = c.domainsGatewayHosts(ctx); err != nil {
+					return nil, err
+				}
+				gatewayRead = true
+			}
+			if !slices.Contains(gatewayHosts, host) {
+				return nil, fmt.Errorf("no ingress with a load balancer address serves %q", host)
+			}
+			if address, err = c.LoadBalancerAddress(ctx, WildcardGatewayNamespace, DomainsGatewayName); err != nil {
+				return nil, fmt.Errorf("no address for %q: %w", host, err)
+			}
+		}
+		desired[host] = address
+	}
+
+	return desired, nil
+}
@@ -0,0 +1,187 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeZoneRecord struct {
+	ID      string `json:"id"`
+	Zone    string `json:"-"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Comment string `json:"comment"`
+}
+
+func fakeCloudflare(t *testing.T, records []*fakeZoneRecord) *CloudflareDNS {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := "{}"
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.URL.Path == "/zones":
+			result = "[]"
+			if name := r.URL.Query().Get("name"); name == "example.com" || name == "example.org" {
+				result = fmt.Sprintf(`[{"id":%q}]`, name)
+			}
+		case r.Method == http.MethodGet:
+			var matching []*fakeZoneRecord
+			for _, record := range records {
+				if record.Zone != parts[1] {
+					continue
+				}
+				if name := r.URL.Query().Get("name"); name != "" && record.Name != name {
+					continue
+				}
+				if comment := r.URL.Query().Get("comment.exact"); comment != "" && record.Comment != comment {
+					continue
+				}
+				matching = append(matching, record)
+			}
+			data, err := json.Marshal(matching)
+			require.NoError(t, err)
+			result = string(data)
+		case r.Method == http.MethodPost:
+			record := &fakeZoneRecord{ID: fmt.Sprintf("new-%d", len(records)), Zone: parts[1]}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(record))
+			records = append(records, record)
+			result = fmt.Sprintf(`{"id":%q}`, record.ID)
+		case r.Method == http.MethodPatch:
+			for _, record := range records {
+				if record.ID == parts[3] {
+					require.NoError(t, json.NewDecoder(r.Body).Decode(record))
+				}
+			}
+		case r.Method == http.MethodDelete:
+			for i, record := range records {
+				if record.ID == parts[3] {
+					records = append(records[:i], records[i+1:]...)
+					break
+				}
+			}
+		}
+
+		w.Write([]byte(`{"success":true,"errors":[],"result":` + result + `}`))
+	}))
+	t.Cleanup(server.Close)
+
+	dns, err := NewCloudflareDNS("token", server.Client())
+	require.NoError(t, err)
+	dns.apiURL = server.URL + "/"
+
+	return dns
+}
+
+func TestReconcileRecords(t *testing.T) {
+	comment := ManagedRecordComment("homelab")
+	records := []*fakeZoneRecord{
+		{ID: "argocd-old", Zone: "example.org", Name: "argocd.example.org", Content: "10.0.12.5", Comment: comment},
+		{ID: "vault-new", Zone: "example.com", Name: "vault.example.com", Content: "10.0.12.4", Comment: comment},
+		{ID: "hand-made", Zone: "example.org", Name: "www.example.org", Content: "10.0.12.9"},
+		{ID: "external-dns", Zone: "example.com", Name: "gitea.example.com", Content: "10.0.12.7"},
+	}
+	dns := fakeCloudflare(t, records)
+	desired := map[string]string{
+		"argocd.example.com": "10.0.12.5",
+		"vault.example.com":  "10.0.12.5",
+		"gitea.example.com":  "10.0.12.5",
+	}
+
+	t.Run("without prune stale records are kept", func(t *testing.T) {
+		result, err := dns.ReconcileRecords(context.Background(), desired, comment, []string{"example.org"}, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"argocd.example.com"}, result.Created)
+		assert.Equal(t, []string{"vault.example.com"}, result.Updated)
+		assert.Empty(t, result.Deleted)
+		assert.Equal(t, []string{"gitea.example.com"}, result.Unmanaged)
+		assert.Equal(t, []string{"example.com", "example.org"}, result.Zones)
+		assert.Equal(t, "dns records created argocd.example.com; updated vault.example.com; left unmanaged gitea.example.com", result.Summary())
+	})
+
+	t.Run("prune removes only stale managed records", func(t *testing.T) {
+		result, err := dns.ReconcileRecords(context.Background(), desired, comment, []string{"example.org"}, true)
+		require.NoError(t, err)
+		assert.Empty(t, result.Created)
+		assert.Empty(t, result.Updated)
+		assert.Equal(t, []string{"argocd.example.org"}, result.Deleted)
+		assert.Equal(t, []string{"example.com"}, result.Zones)
+
+		hand, err := dns.ARecord(context.Background(), "www.example.org")
+		require.NoError(t, err)
+		assert.Equal(t, "hand-made", hand.ID)
+		_, err = dns.ARecord(context.Background(), "argocd.example.org")
+		require.ErrorContains(t, err, "no A record")
+	})
+
+	t.Run("unchanged records are left alone", func(t *testing.T) {
+		result, err := dns.ReconcileRecords(context.Background(), desired, comment, []string{"example.com"}, true)
+		require.NoError(t, err)
+		assert.False(t, result.Changed())
+	})
+}
+
+func TestManagedRecords(t *testing.T) {
+	comment := ManagedRecordComment("homelab")
+	dns := fakeCloudflare(t, []*fakeZoneRecord{
+		{ID: "argocd", Zone: "example.com", Name: "argocd.example.com", Content: "10.0.12.5", Comment: comment},
+		{ID: "vault", Zone: "example.com", Name: "vault.example.com", Content: "10.0.12.5", Comment: comment},
+		{ID: "gitea", Zone: "example.com", Name: "gitea.example.com", Content: "10.0.12.7"},
+	})
+
+	records, err := dns.ManagedRecords(context.Background(), []string{"vault.example.com", "gitea.example.com"}, comment)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "vault", records[0].ID)
+
+	require.NoError(t, dns.DeleteRecord(context.Background(), records[0]))
+	records, err = dns.ManagedRecords(context.Background(), []string{"vault.example.com"}, comment)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestDesiredDNSRecords(t *testing.T) {
+	lb := func(ip string) corev1.LoadBalancerStatus {
+		return corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: ip}}}
+	}
+	client := &Client{Clientset: fake.NewClientset(
+		&networkingv1.Ingress{
+			ObjectMeta: metav1.ObjectMeta{Name: "argocd", Namespace: ArgoCDNamespace},
+			Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{Host: "argocd.example.com"}}},
+			Status:     networkingv1.IngressStatus{LoadBalancer: networkingv1.IngressLoadBalancerStatus{Ingress: []networkingv1.IngressLoadBalancerIngress{{IP: "10.0.12.5"}}}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: WildcardGatewayName, Namespace: WildcardGatewayNamespace},
+			Status:     corev1.ServiceStatus{LoadBalancer: lb("10.0.12.6")},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: DomainsGatewayName, Namespace: WildcardGatewayNamespace},
+			Status:     corev1.ServiceStatus{LoadBalancer: lb("10.0.12.7")},
+		},
+	)}
+	client.Dynamic = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{gatewayGVR: "GatewayList"})
+	// the fake tracker guesses "gatewaies" for objects passed to it
+	_, err := client.Dynamic.Resource(gatewayGVR).Namespace(WildcardGatewayNamespace).Create(context.Background(), DomainsGateway([]IngressDomain{{Domain: "apps.example.org", VCluster: "prod"}}), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	desired, err := client.DesiredDNSRecords(context.Background(), []string{"argocd.example.com", "*.dev.example.com", "apps.example.org"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"argocd.example.com": "10.0.12.5", "*.dev.example.com": "10.0.12.6", "apps.example.org": "10.0.12.7"}, desired)
+
+	_, err = client.DesiredDNSRecords(context.Background(), []string{"vault.example.com"})
+	require.ErrorContains(t, err, `serves "vault.example.com"`)
+}
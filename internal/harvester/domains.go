@@ -0,0 +1,189 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// maxClusterNameLength is the longest RFC 1123 label
+const maxClusterNameLength = 63
+
+// maxDomainNameLength is the longest domain name DNS resolves
+const maxDomainNameLength = 253
+
+// clusterNameSeparators are the runs of characters SuggestClusterName
+// replaces with a hyphen
+var clusterNameSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// ValidateClusterName ensures name is a valid RFC 1123 label, as it is used
+// in subdomains and Kubernetes resource names, naming the first violation
+// and the name SuggestClusterName makes of it
+func ValidateClusterName(name string) error {
+	if name == "" {
+		return errors.New("must not be empty")
+	}
+	if err := validateClusterName(name); err != nil {
+		if suggestion := SuggestClusterName(name); suggestion != "" {
+			return fmt.Errorf("%w, try %q", err, suggestion)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// SuggestClusterName slugifies name into a valid cluster name, lowercased
+// with every run of other characters replaced by a hyphen, or returns ""
+// when nothing of name is left
+func SuggestClusterName(name string) string {
+	slug := strings.Trim(clusterNameSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > maxClusterNameLength {
+		slug = strings.TrimRight(slug[:maxClusterNameLength], "-")
+	}
+
+	return slug
+}
+
+func validateClusterName(name string) error {
+	if len(name) > maxClusterNameLength {
+		return fmt.Errorf("%q is %d characters long, at most %d are allowed", name, len(name), maxClusterNameLength)
+	}
+
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+		case r >= 'A' && r <= 'Z':
+			return fmt.Errorf("%q contains uppercase %q at position %d, only lowercase letters are allowed", name, r, i+1)
+		default:
+			return fmt.Errorf("%q contains %q at position %d, only lowercase letters, digits and hyphens are allowed", name, r, i+1)
+		}
+	}
+
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("%q starts with a hyphen", name)
+	}
+	if strings.HasSuffix(name, "-") {
+		return fmt.Errorf("%q ends with a hyphen", name)
+	}
+
+	return nil
+}
+
+// ValidateDomainName ensures domain is a fully qualified domain name as the
+// platform subdomains are created under, without scheme, port, path or
+// trailing dot, naming the first violation
+func ValidateDomainName(domain string) error {
+	if domain == "" {
+		return errors.New("must not be empty")
+	}
+	if scheme, rest, ok := strings.Cut(domain, "://"); ok {
+		return fmt.Errorf("%q has a %s:// scheme, pass the domain alone such as %q", domain, scheme, strings.SplitN(rest, "/", 2)[0])
+	}
+	if strings.ContainsAny(domain, "/:") {
+		return fmt.Errorf("%q has a port or path, pass the domain alone such as example.com", domain)
+	}
+	if strings.HasSuffix(domain, ".") {
+		return fmt.Errorf("%q ends with a dot", domain)
+	}
+	if len(domain) > maxDomainNameLength {
+		return fmt.Errorf("%q is %d characters long, at most %d are allowed", domain, len(domain), maxDomainNameLength)
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("%q is not fully qualified, a domain such as example.com is expected", domain)
+	}
+	for _, label := range labels {
+		if errs := validation.IsDNS1123Label(label); len(errs) > 0 {
+			return fmt.Errorf("%q has an invalid label %q: %s", domain, label, strings.Join(errs, ", "))
+		}
+	}
+	if tld := labels[len(labels)-1]; strings.Trim(tld, "0123456789") == "" {
+		return fmt.Errorf("%q is an IP address, a domain such as example.com is expected", domain)
+	}
+
+	return nil
+}
+
+// ValidateVClusterDomainMap ensures every entry in the vcluster domain map
+// references a vcluster that will actually be created
+func ValidateVClusterDomainMap(vclusters []string, domainMap map[string]string) error {
+	names := make([]string, 0, len(domainMap))
+	for name := range domainMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !slices.Contains(vclusters, name) {
+			return fmt.Errorf("vcluster domain map entry %q does not match any vcluster in --vclusters %v", name, vclusters)
+		}
+
+		if strings.TrimSpace(domainMap[name]) == "" {
+			return fmt.Errorf("vcluster domain map entry %q has an empty domain", name)
+		}
+	}
+
+	return nil
+}
+
+// DomainMapString spells domainMap as --vcluster-domain-map takes it,
+// ordered by vcluster
+func DomainMapString(domainMap map[string]string) string {
+	entries := make([]string, 0, len(domainMap))
+	for _, vcluster := range sortedKeys(domainMap) {
+		entries = append(entries, vcluster+"="+domainMap[vcluster])
+	}
+
+	return strings.Join(entries, ",")
+}
+
+// Domains returns the deduplicated, sorted set of domains the ingress phase
+// needs DNS records and certificates for
+func Domains(domainName string, extraDomains []string, domainMap map[string]string) []string {
+	seen := map[string]bool{}
+	domains := []string{}
+
+	add := func(domain string) {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || seen[domain] {
+			return
+		}
+		seen[domain] = true
+		domains = append(domains, domain)
+	}
+
+	add(domainName)
+	for _, domain := range extraDomains {
+		add(domain)
+	}
+	for _, domain := range domainMap {
+		add(domain)
+	}
+
+	sort.Strings(domains)
+
+	return domains
+}
+
+// VClusterDomain returns the ingress domain for a vcluster, falling back to
+// the vcluster name as a subdomain of the primary domain
+func VClusterDomain(vcluster, domainName string, domainMap map[string]string) string {
+	if domain, ok := domainMap[vcluster]; ok && domain != "" {
+		return domain
+	}
+
+	return fmt.Sprintf("%s.%s", vcluster, domainName)
+}
@@ -0,0 +1,115 @@
+package harvester
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateClusterName(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{name: "default", value: "kubefirst"},
+		{name: "digits and hyphens", value: "site-01"},
+		{name: "empty", value: "", wantErr: "must not be empty"},
+		{name: "uppercase", value: "Kubefirst", wantErr: `"Kubefirst" contains uppercase 'K' at position 1, only lowercase letters are allowed`},
+		{name: "underscore", value: "my_cluster", wantErr: `"my_cluster" contains '_' at position 3, only lowercase letters, digits and hyphens are allowed`},
+		{name: "leading hyphen", value: "-kubefirst", wantErr: `"-kubefirst" starts with a hyphen`},
+		{name: "trailing hyphen", value: "kubefirst-", wantErr: `"kubefirst-" ends with a hyphen`},
+		{name: "too long", value: strings.Repeat("a", 64), wantErr: "is 64 characters long, at most 63 are allowed"},
+		{name: "suggestion", value: "My Cluster", wantErr: `"My Cluster" contains uppercase 'M' at position 1, only lowercase letters are allowed, try "my-cluster"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateClusterName(tt.value)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSuggestClusterName(t *testing.T) {
+	assert.Equal(t, "my-cluster", SuggestClusterName("My Cluster"))
+	assert.Equal(t, "site-01", SuggestClusterName("  _Site__01_ "))
+	assert.Equal(t, strings.Repeat("a", 62), SuggestClusterName(strings.Repeat("a", 62)+"-b"))
+	assert.Empty(t, SuggestClusterName("---"))
+}
+
+func TestValidateDomainName(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		wantErr string
+	}{
+		{name: "domain", value: "example.com"},
+		{name: "subdomain", value: "k1.lab.example.co.uk"},
+		{name: "empty", value: "", wantErr: "must not be empty"},
+		{name: "scheme", value: "https://example.com/", wantErr: `"https://example.com/" has a https:// scheme, pass the domain alone such as "example.com"`},
+		{name: "port", value: "example.com:443", wantErr: "has a port or path"},
+		{name: "trailing dot", value: "example.com.", wantErr: `"example.com." ends with a dot`},
+		{name: "single label", value: "localhost", wantErr: "is not fully qualified"},
+		{name: "uppercase", value: "Example.com", wantErr: `has an invalid label "Example"`},
+		{name: "empty label", value: "example..com", wantErr: `has an invalid label ""`},
+		{name: "ip address", value: "10.0.0.1", wantErr: "is an IP address"},
+		{name: "too long", value: strings.Repeat("a.", 127) + "com", wantErr: "is 257 characters long, at most 253 are allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDomainName(tt.value)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestValidateVClusterDomainMap(t *testing.T) {
+	vclusters := []string{"dev", "test", "prod"}
+
+	t.Run("accepts entries for known vclusters", func(t *testing.T) {
+		err := ValidateVClusterDomainMap(vclusters, map[string]string{
+			"dev":  "dev.example.org",
+			"prod": "apps.example.com",
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("rejects entries for unknown vclusters", func(t *testing.T) {
+		err := ValidateVClusterDomainMap(vclusters, map[string]string{"staging": "staging.example.org"})
+		require.ErrorContains(t, err, `"staging"`)
+	})
+
+	t.Run("rejects empty domains", func(t *testing.T) {
+		err := ValidateVClusterDomainMap(vclusters, map[string]string{"dev": " "})
+		require.ErrorContains(t, err, "empty domain")
+	})
+}
+
+func TestDomains(t *testing.T) {
+	domains := Domains(
+		"example.com",
+		[]string{"Example.org", "example.com"},
+		map[string]string{"dev": "dev.example.org", "prod": "example.org"},
+	)
+
+	assert.Equal(t, []string{"dev.example.org", "example.com", "example.org"}, domains)
+}
+
+func TestVClusterDomain(t *testing.T) {
+	domainMap := map[string]string{"prod": "apps.example.com"}
+
+	assert.Equal(t, "apps.example.com", VClusterDomain("prod", "example.com", domainMap))
+	assert.Equal(t, "dev.example.com", VClusterDomain("dev", "example.com", domainMap))
+}
@@ -0,0 +1,263 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/konstructio/kubefirst/internal/step"
+)
+
+// PhaseDurationsFile holds the durations of the phases of the latest
+// successful creates
+const PhaseDurationsFile = "phase-durations.json"
+
+// phaseDurationSamples is how many runs of each phase are kept
+const phaseDurationSamples = 5
+
+// PhaseDurations are the durations in seconds of the phases of the latest
+// successful creates, keyed by phase, oldest first
+type PhaseDurations struct {
+	Phases map[string][]float64 `json:"phases"`
+}
+
+// DefaultPhaseDurationsPath returns the PhaseDurationsFile of the active
+// profile, $HOME/.k1/harvester/phase-durations.json for the default one
+func DefaultPhaseDurationsPath() (string, error) {
+	if profile := ActiveProfile(); profile != DefaultProfile {
+		dir, err := ProfileDir(profile)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, PhaseDurationsFile), nil
+	}
+
+	homePath, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	return filepath.Join(homePath, ".k1", "harvester", PhaseDurationsFile), nil
+}
+
+// LoadPhaseDurations reads the durations at path, none when it does not
+// exist yet
+func LoadPhaseDurations(path string) (*PhaseDurations, error) {
+	durations := &PhaseDurations{Phases: map[string][]float64{}}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return durations, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read phase durations: %w", err)
+	}
+	if err := json.Unmarshal(data, durations); err != nil {
+		return nil, fmt.Errorf("invalid phase durations %s: %w", path, err)
+	}
+	if durations.Phases == nil {
+		durations.Phases = map[string][]float64{}
+	}
+
+	return durations, nil
+}
+
+// Save writes the durations to path
+func (d *PhaseDurations) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create phase durations directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode phase durations: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write phase durations: %w", err)
+	}
+
+	return nil
+}
+
+// Record adds the completed phases of a run, keeping the latest
+// phaseDurationSamples of each
+func (d *PhaseDurations) Record(phases []PhaseRecord) {
+	for _, phase := range phases {
+		if phase.Status != step.StatusComplete {
+			continue
+		}
+		samples := append(d.Phases[phase.Name], phase.Duration.Seconds())
+		d.Phases[phase.Name] = samples[max(len(samples)-phaseDurationSamples, 0):]
+	}
+}
+
+// Typical returns the mean duration of phase over the recorded runs
+func (d *PhaseDurations) Typical(phase string) (time.Duration, bool) {
+	samples := d.Phases[phase]
+	if len(samples) == 0 {
+		return 0, false
+	}
+
+	total := 0.0
+	for _, seconds := range samples {
+		total += seconds
+	}
+
+	return time.Duration(total / float64(len(samples)) * float64(time.Second)), true
+}
+
+// Weights returns weights, the expected durations of the phases of a plan,
+// with the typical duration of the phases run before
+func (d *PhaseDurations) Weights(weights map[string]time.Duration) map[string]time.Duration {
+	typical := make(map[string]time.Duration, len(weights))
+	for phase, weight := range weights {
+		if duration, ok := d.Typical(phase); ok {
+			weight = duration
+		}
+		typical[phase] = weight
+	}
+
+	return typical
+}
+
+// EstimateMinutes returns the sum of weights rounded up to whole minutes
+func EstimateMinutes(weights map[string]time.Duration) int {
+	var total time.Duration
+	for _, weight := range weights {
+		total += weight
+	}
+
+	return int(math.Ceil(total.Minutes()))
+}
+
+// Render renders the phases of a run with their duration
+// next to their typical one in d, when it has it, and the total
+func (d *PhaseDurations) Render(phases []PhaseRecord) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "PHASE\tDURATION\tTYPICAL")
+	var total time.Duration
+	for _, phase := range phases {
+		total += phase.Duration
+		duration := phase.Duration.Round(time.Second).String()
+		if phase.Status == step.StatusFailed {
+			duration += " (failed)"
+		}
+		typical := "-"
+		if duration, ok := d.Typical(phase.Name); ok {
+			typical = duration.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", phase.Name, duration, typical)
+	}
+	fmt.Fprintf(w, "Total\t%s\n", total.Round(time.Second))
+	_ = w.Flush()
+
+	return b.String()
+}
+
+// PhaseMetrics is the wall-clock breakdown of a run written to
+// --metrics-file, to find the phases worth tuning over many runs
+type PhaseMetrics struct {
+	ClusterName     string        `json:"clusterName"`
+	Succeeded       bool          `json:"succeeded"`
+	StartedAt       time.Time     `json:"startedAt"`
+	WallTimeSeconds float64       `json:"wallTimeSeconds"`
+	Phases          []PhaseMetric `json:"phases"`
+}
+
+// PhaseMetric is how long a phase took, its sub-steps included
+type PhaseMetric struct {
+	Name            string        `json:"name"`
+	Status          string        `json:"status"`
+	DurationSeconds float64       `json:"durationSeconds"`
+	SubSteps        []PhaseMetric `json:"subSteps,omitempty"`
+}
+
+// NewPhaseMetrics breaks the run of clusterName from startedAt until
+// finishedAt down into the steps of its plan. The steps a plan step spans,
+// such as the kubefirst-api install steps, are its sub-steps and the plan
+// step fails with any of them; the other steps stand on their own
+func NewPhaseMetrics(clusterName string, startedAt, finishedAt time.Time, succeeded bool, phases []PhaseRecord) *PhaseMetrics {
+	metrics := &PhaseMetrics{
+		ClusterName:     clusterName,
+		Succeeded:       succeeded,
+		StartedAt:       startedAt.UTC(),
+		WallTimeSeconds: metricSeconds(finishedAt.Sub(startedAt)),
+		Phases:          []PhaseMetric{},
+	}
+
+	planSteps := map[string]string{}
+	for planStep, runtime := range planStepRuntime {
+		for _, name := range runtime {
+			planSteps[name] = planStep
+		}
+	}
+
+	var durations []time.Duration
+	for _, phase := range phases {
+		metric := PhaseMetric{Name: phase.Name, Status: string(phase.Status), DurationSeconds: metricSeconds(phase.Duration)}
+
+		planStep, ok := planSteps[phase.Name]
+		if !ok {
+			metrics.Phases = append(metrics.Phases, metric)
+			durations = append(durations, phase.Duration)
+			continue
+		}
+
+		// the steps of a plan step run in a row, the same plan step seen
+		// again later is a run of it of its own
+		last := len(metrics.Phases) - 1
+		if last < 0 || metrics.Phases[last].Name != planStep || metrics.Phases[last].SubSteps == nil {
+			metrics.Phases = append(metrics.Phases, PhaseMetric{Name: planStep, Status: string(step.StatusComplete), SubSteps: []PhaseMetric{}})
+			durations = append(durations, 0)
+			last++
+		}
+
+		group := &metrics.Phases[last]
+		group.SubSteps = append(group.SubSteps, metric)
+		durations[last] += phase.Duration
+		group.DurationSeconds = metricSeconds(durations[last])
+		if phase.Status == step.StatusFailed {
+			group.Status = string(step.StatusFailed)
+		}
+	}
+
+	return metrics
+}
+
+// metricSeconds rounds d to the millisecond, in seconds
+func metricSeconds(d time.Duration) float64 {
+	return d.Round(time.Millisecond).Seconds()
+}
+
+// WritePhaseMetrics writes metrics to path as JSON
+func WritePhaseMetrics(path string, metrics *PhaseMetrics) error {
+	data, err := json.MarshalIndent(metrics, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode phase metrics: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create phase metrics directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write phase metrics: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package harvester
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPhaseDurations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "harvester", PhaseDurationsFile)
+
+	durations, err := LoadPhaseDurations(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"Install Vault": 2 * time.Minute}, durations.Weights(map[string]time.Duration{"Install Vault": 2 * time.Minute}))
+
+	for i := 1; i <= 6; i++ {
+		durations.Record([]PhaseRecord{
+			{Name: "Install Vault", Status: step.StatusComplete, Duration: time.Duration(i) * time.Minute},
+			{Name: "Final Check", Status: step.StatusFailed, Duration: time.Hour},
+		})
+	}
+	require.NoError(t, durations.Save(path))
+
+	loaded, err := LoadPhaseDurations(path)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{120, 180, 240, 300, 360}, loaded.Phases["Install Vault"])
+	_, found := loaded.Typical("Final Check")
+	assert.False(t, found, "failed phases are not recorded")
+
+	weights := loaded.Weights(map[string]time.Duration{"Install Vault": 2 * time.Minute, "Final Check": 3 * time.Minute})
+	assert.Equal(t, map[string]time.Duration{"Install Vault": 4 * time.Minute, "Final Check": 3 * time.Minute}, weights)
+	assert.Equal(t, 7, EstimateMinutes(weights))
+
+	table := loaded.Render([]PhaseRecord{
+		{Name: "Install Vault", Status: step.StatusComplete, Duration: 5*time.Minute + 400*time.Millisecond},
+		{Name: "Final Check", Status: step.StatusFailed, Duration: 90 * time.Second},
+	})
+	assert.Equal(t, "PHASE          DURATION        TYPICAL\nInstall Vault  5m0s            4m0s\nFinal Check    1m30s (failed)  -\nTotal          6m30s\n", table)
+}
+
+func TestPhaseMetrics(t *testing.T) {
+	startedAt := time.Date(2026, 10, 14, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	phases := []PhaseRecord{
+		{Name: "Validate Git Credentials", Status: step.StatusComplete, Duration: 1500 * time.Millisecond},
+		{Name: "Create Management Cluster", Status: step.StatusComplete, Duration: 10 * time.Second},
+		{Name: "Vault Initialized", Status: step.StatusComplete, Duration: time.Minute},
+		{Name: "Users Terraform Apply", Status: step.StatusFailed, Duration: 2 * time.Minute},
+		{Name: "Provision vCluster dev", Status: step.StatusComplete, Duration: 3 * time.Minute},
+	}
+
+	metrics := NewPhaseMetrics("kubefirst", startedAt, startedAt.Add(25*time.Minute), false, phases)
+	assert.Equal(t, &PhaseMetrics{
+		ClusterName:     "kubefirst",
+		StartedAt:       startedAt.UTC(),
+		WallTimeSeconds: 1500,
+		Phases: []PhaseMetric{
+			{Name: "Install ArgoCD and GitOps Repository", Status: "complete", DurationSeconds: 11.5, SubSteps: []PhaseMetric{
+				{Name: "Validate Git Credentials", Status: "complete", DurationSeconds: 1.5},
+				{Name: "Create Management Cluster", Status: "complete", DurationSeconds: 10},
+			}},
+			{Name: "Install Vault", Status: "failed", DurationSeconds: 180, SubSteps: []PhaseMetric{
+				{Name: "Vault Initialized", Status: "complete", DurationSeconds: 60},
+				{Name: "Users Terraform Apply", Status: "failed", DurationSeconds: 120},
+			}},
+			{Name: "Provision vCluster dev", Status: "complete", DurationSeconds: 180},
+		},
+	}, metrics)
+
+	path := filepath.Join(t.TempDir(), "metrics", "create.json")
+	require.NoError(t, WritePhaseMetrics(path, NewPhaseMetrics("kubefirst", startedAt, startedAt, true, phases[4:])))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"clusterName": "kubefirst", "succeeded": true, "startedAt": "2026-10-14T10:00:00Z", "wallTimeSeconds": 0, "phases": [{"name": "Provision vCluster dev", "status": "complete", "durationSeconds": 180}]}`, string(data))
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+)
+
+// Where the value of a create flag came from, as printed by --print-flags
+const (
+	FlagSourceDefault = "default"
+	FlagSourceFile    = "file"
+	FlagSourceEnv     = "env"
+	FlagSourceFlag    = "flag"
+)
+
+// Output formats of --print-flags
+const (
+	PrintFlagsTable = "table"
+	PrintFlagsJSON  = "json"
+)
+
+var PrintFlagsFormats = []string{PrintFlagsTable, PrintFlagsJSON}
+
+// maskedFlags are the flags whose values --print-flags never shows: the
+// secrets export-config redacts and the webhook urls, which embed tokens
+var maskedFlags = append([]string{"slack-webhook", "teams-webhook", "notify-webhook", "notify-webhook-url"}, secretFlags...)
+
+// maskedValue replaces the values of --print-flags that must not be shown
+const maskedValue = "********"
+
+// EffectiveFlag is the resolved value of a create setting and its source.
+// Environment variables create reads credentials from are listed under
+// their own name
+type EffectiveFlag struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Location is the file and line or the variable of the value
+	Location string `json:"location,omitempty"`
+}
+
+// NewEffectiveFlag masks value when name is a secret flag or an
+// environment variable holding a credential
+func NewEffectiveFlag(name, value, source string) EffectiveFlag {
+	if value != "" && (source == FlagSourceEnv || slices.Contains(maskedFlags, name)) {
+		value = maskedValue
+	}
+
+	return EffectiveFlag{Name: name, Value: value, Source: source}
+}
+
+// NewProvenanceFlag is the effective value of the create flag name, masked
+// when it is a secret, read from where provenance locates it
+func NewProvenanceFlag(name, value string, provenance FlagProvenance) EffectiveFlag {
+	if value != "" && slices.Contains(maskedFlags, name) {
+		value = maskedValue
+	}
+
+	return EffectiveFlag{Name: name, Value: value, Source: provenance.Source, Location: provenance.Location()}
+}
+
+// WriteEffectiveFlags writes flags in format, a table or JSON
+func WriteEffectiveFlags(w io.Writer, flags []EffectiveFlag, format string) error {
+	switch format {
+	case PrintFlagsTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tVALUE\tSOURCE")
+		for _, flag := range flags {
+			source := flag.Source
+			if flag.Location != "" {
+				source += " " + flag.Location
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", flag.Name, flag.Value, source)
+		}
+		return tw.Flush()
+	case PrintFlagsJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(flags); err != nil {
+			return fmt.Errorf("failed to render flags: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown --print-flags format %q, must be one of %v", format, PrintFlagsFormats)
+	}
+}
@@ -0,0 +1,36 @@
+package harvester
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteEffectiveFlags(t *testing.T) {
+	flags := []EffectiveFlag{
+		NewEffectiveFlag("domain-name", "example.com", FlagSourceFile),
+		NewEffectiveFlag("oidc-client-secret", "hunter2", FlagSourceFlag),
+		NewEffectiveFlag("slack-webhook", "", FlagSourceDefault),
+		NewEffectiveFlag("GITHUB_TOKEN", "ghp_secret", FlagSourceEnv),
+		NewProvenanceFlag("git-provider", "gitlab", FlagProvenance{Source: FlagSourceEnv, Env: "KUBEFIRST_HARVESTER_GIT_PROVIDER"}),
+		NewProvenanceFlag("unifi-password", "hunter2", FlagProvenance{Source: FlagSourceFile, File: "cluster.yaml", Line: 14}),
+	}
+
+	var table bytes.Buffer
+	require.NoError(t, WriteEffectiveFlags(&table, flags, PrintFlagsTable))
+	assert.Equal(t, "NAME                VALUE        SOURCE\n"+
+		"domain-name         example.com  file\n"+
+		"oidc-client-secret  ********     flag\n"+
+		"slack-webhook                    default\n"+
+		"GITHUB_TOKEN        ********     env\n"+
+		"git-provider        gitlab       env KUBEFIRST_HARVESTER_GIT_PROVIDER\n"+
+		"unifi-password      ********     file cluster.yaml:14\n", table.String())
+
+	var out bytes.Buffer
+	require.NoError(t, WriteEffectiveFlags(&out, flags[4:5], PrintFlagsJSON))
+	assert.JSONEq(t, `[{"name": "git-provider", "value": "gitlab", "source": "env", "location": "KUBEFIRST_HARVESTER_GIT_PROVIDER"}]`, out.String())
+
+	require.ErrorContains(t, WriteEffectiveFlags(&out, flags, "yaml"), `unknown --print-flags format "yaml"`)
+}
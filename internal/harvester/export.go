@@ -0,0 +1,357 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/konstructio/kubefirst/internal/readonly"
+	"gopkg.in/yaml.v3"
+)
+
+// manifestSecretPlaceholder replaces the secrets of exported manifests
+const manifestSecretPlaceholder = "<redacted>"
+
+// AppliedExportDir is the directory of a manifest export holding what is
+// applied to the cluster outside of the gitops repository
+const AppliedExportDir = "applied"
+
+// secretKeySuffixes mark the mapping keys whose values are secrets, matched
+// case-insensitively against the end of the key so references such as
+// secretName are kept
+var secretKeySuffixes = []string{"password", "secret", "token", "privatekey", "apikey", "accesskey", "secretkey"}
+
+// ManifestExport writes every manifest kubefirst commits to the gitops
+// repository or applies to the cluster under Dir, before it is pushed or
+// applied. Gitops files keep their repository path, applied objects go
+// under AppliedExportDir. Secrets are redacted unless IncludeSecrets is set
+type ManifestExport struct {
+	Dir            string
+	IncludeSecrets bool
+}
+
+var (
+	manifestExport atomic.Pointer[ManifestExport]
+	dryRun         atomic.Bool
+)
+
+// SetManifestExport makes the gitops repositories and clients of the
+// process export their manifests to export, nil stops exporting
+func SetManifestExport(export *ManifestExport) {
+	manifestExport.Store(export)
+}
+
+// SetDryRun turns dry-run mode on or off for the whole process: gitops
+// changes are exported but never pushed, and mutating requests to the
+// cluster are sent as server-side dry runs that persist nothing
+func SetDryRun(on bool) {
+	dryRun.Store(on)
+}
+
+// DryRun reports whether dry-run mode is on
+func DryRun() bool {
+	return dryRun.Load()
+}
+
+// exportGitopsFiles writes files, keyed by their repository path, to the
+// manifest export of the process, if any. Removed files are left out
+func exportGitopsFiles(files map[string][]byte) error {
+	export := manifestExport.Load()
+	if export == nil {
+		return nil
+	}
+
+	for name, content := range files {
+		if content == nil {
+			continue
+		}
+		if err := export.write(name, content); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write writes content at name under the export directory, redacted
+// unless the export includes secrets
+func (e *ManifestExport) write(name string, content []byte) error {
+	if !e.IncludeSecrets {
+		redacted, err := RedactManifest(content)
+		if err != nil {
+			return fmt.Errorf("failed to redact %s: %w", name, err)
+		}
+		content = redacted
+	}
+
+	target := filepath.Join(e.Dir, filepath.FromSlash(path.Clean("/"+name)))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create export directory: %w", err)
+	}
+	if err := os.WriteFile(target, content, 0o600); err != nil {
+		return fmt.Errorf("failed to export %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// AppliedManifestPath returns where a manifest export keeps the body of a
+// request to the Kubernetes API at apiPath, e.g.
+// applied/core/vault/secrets/vault-unseal.yaml for
+// /api/v1/namespaces/vault/secrets/vault-unseal. name names objects
+// created by a POST, whose path ends with their resource. Patches and
+// subresources get a suffix of their own, so the path only depends on the
+// object and the kind of request
+func AppliedManifestPath(apiPath, name, contentType string) string {
+	segments := strings.Split(strings.Trim(apiPath, "/"), "/")
+	group := "core"
+	switch {
+	case len(segments) >= 2 && segments[0] == "api":
+		segments = segments[2:]
+	case len(segments) >= 3 && segments[0] == "apis":
+		group = segments[1]
+		segments = segments[3:]
+	}
+
+	namespace := "_cluster"
+	if len(segments) > 2 && segments[0] == "namespaces" {
+		namespace = segments[1]
+		segments = segments[2:]
+	}
+
+	resource := "unknown"
+	if len(segments) > 0 {
+		resource, segments = segments[0], segments[1:]
+	}
+	if len(segments) > 0 {
+		name, segments = segments[0], segments[1:]
+	}
+	if name == "" {
+		name = "unnamed"
+	}
+	for _, subresource := range segments {
+		name += "." + subresource
+	}
+	if strings.Contains(contentType, "patch") && !strings.Contains(contentType, "apply-patch") {
+		name += ".patch"
+	}
+
+	return path.Join(AppliedExportDir, group, namespace, resource, name+".yaml")
+}
+
+// exportTransport writes the body of every mutating request to the
+// Kubernetes API to the manifest export of the process before sending it,
+// as a server-side dry run in dry-run mode
+type exportTransport struct {
+	next http.RoundTripper
+}
+
+func (t *exportTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// requests already sent as dry runs, such as the schema validation of
+	// manifests, change nothing to export
+	if readonly.Safe(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	if export := manifestExport.Load(); export != nil && req.Body != nil && req.Method != http.MethodDelete {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body.Close()
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+
+		content, name, err := appliedManifest(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s %s: %w", req.Method, req.URL.Path, err)
+		}
+		if err := export.write(AppliedManifestPath(req.URL.Path, name, req.Header.Get("Content-Type")), content); err != nil {
+			return nil, err
+		}
+	}
+
+	if DryRun() {
+		req = req.Clone(req.Context())
+		query := req.URL.Query()
+		query.Set("dryRun", "All")
+		req.URL.RawQuery = query.Encode()
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// appliedManifest renders the JSON or YAML body of a request as YAML with
+// sorted keys, so exports diff across runs, along with its metadata.name
+// when it is an object
+func appliedManifest(body []byte) ([]byte, string, error) {
+	var object interface{}
+	if err := yaml.Unmarshal(body, &object); err != nil {
+		return nil, "", err
+	}
+
+	name := ""
+	if fields, ok := object.(map[string]interface{}); ok {
+		if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+			name, _ = metadata["name"].(string)
+		}
+	}
+
+	content, err := yaml.Marshal(object)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return content, name, nil
+}
+
+// RedactManifest replaces the secrets of the YAML documents in content
+// with <redacted>: the data and stringData values of Secrets and the values
+// of keys such as password or clientSecret, including within Helm values
+// embedded as strings. Content that is not YAML is returned as is
+func RedactManifest(content []byte) ([]byte, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	var documents []*yaml.Node
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return content, nil
+		}
+		documents = append(documents, &document)
+	}
+
+	redacted := false
+	for _, document := range documents {
+		redacted = redactNode(document, isSecretDocument(document)) || redacted
+	}
+	if !redacted {
+		return content, nil
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func isSecretDocument(document *yaml.Node) bool {
+	if len(document.Content) == 0 {
+		return false
+	}
+
+	root := document.Content[0]
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "kind" && root.Content[i+1].Value == "Secret" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redactNode redacts the secrets under node, the data and stringData of a
+// Secret when secretDocument is set, reporting whether it replaced any
+func redactNode(node *yaml.Node, secretDocument bool) bool {
+	redacted := false
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			redacted = redactNode(child, secretDocument) || redacted
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			redacted = redactNode(child, false) || redacted
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			switch {
+			case secretDocument && (key == "data" || key == "stringData"):
+				redacted = redactAll(value) || redacted
+			case isSecretKey(key):
+				redacted = redactAll(value) || redacted
+			case key == "values" && value.Kind == yaml.ScalarNode:
+				redacted = redactEmbedded(value) || redacted
+			default:
+				redacted = redactNode(value, false) || redacted
+			}
+		}
+	}
+
+	return redacted
+}
+
+// redactAll replaces every scalar under node
+func redactAll(node *yaml.Node) bool {
+	if node.Kind == yaml.ScalarNode {
+		// flags such as automountServiceAccountToken are no secrets
+		if node.ShortTag() != "!!str" || node.Value == "" || node.Value == manifestSecretPlaceholder {
+			return false
+		}
+		node.Value = manifestSecretPlaceholder
+		node.Tag = "!!str"
+		node.Style = 0
+		return true
+	}
+
+	redacted := false
+	for i, child := range node.Content {
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		redacted = redactAll(child) || redacted
+	}
+
+	return redacted
+}
+
+// redactEmbedded redacts the Helm values YAML held by a string
+func redactEmbedded(node *yaml.Node) bool {
+	content, err := RedactManifest([]byte(node.Value))
+	if err != nil || string(content) == node.Value {
+		return false
+	}
+
+	node.Value = string(content)
+	return true
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, suffix := range secretKeySuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
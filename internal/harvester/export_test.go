@@ -0,0 +1,131 @@
+package harvester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactManifest(t *testing.T) {
+	t.Run("redacts secrets", func(t *testing.T) {
+		manifest := strings.Join([]string{
+			"apiVersion: v1",
+			"kind: Secret",
+			"metadata:",
+			"  name: vault-unseal",
+			"data:",
+			"  key: c2VjcmV0",
+			"---",
+			"kind: Application",
+			"spec:",
+			"  source:",
+			"    helm:",
+			"      values: |",
+			"        oidc:",
+			"          clientSecret: hunter2",
+			"          secretName: argocd-oidc",
+			"        automountServiceAccountToken: true",
+			"",
+		}, "\n")
+
+		redacted, err := RedactManifest([]byte(manifest))
+		require.NoError(t, err)
+		assert.Contains(t, string(redacted), "key: <redacted>")
+		assert.Contains(t, string(redacted), "clientSecret: <redacted>")
+		assert.Contains(t, string(redacted), "secretName: argocd-oidc")
+		assert.Contains(t, string(redacted), "automountServiceAccountToken: true")
+		assert.NotContains(t, string(redacted), "c2VjcmV0")
+		assert.NotContains(t, string(redacted), "hunter2")
+	})
+
+	t.Run("keeps manifests without secrets as they are", func(t *testing.T) {
+		manifest := "kind: ConfigMap # comment\ndata:\n    key: value\n"
+
+		redacted, err := RedactManifest([]byte(manifest))
+		require.NoError(t, err)
+		assert.Equal(t, manifest, string(redacted))
+	})
+}
+
+func TestAppliedManifestPath(t *testing.T) {
+	tests := []struct {
+		apiPath     string
+		name        string
+		contentType string
+		want        string
+	}{
+		{"/api/v1/namespaces/vault/secrets/vault-unseal", "", "application/apply-patch+yaml", "applied/core/vault/secrets/vault-unseal.yaml"},
+		{"/api/v1/namespaces/vault", "", "application/apply-patch+yaml", "applied/core/_cluster/namespaces/vault.yaml"},
+		{"/apis/batch/v1/namespaces/vault/cronjobs", "vault-unseal", "application/json", "applied/batch/vault/cronjobs/vault-unseal.yaml"},
+		{"/api/v1/namespaces/argocd/secrets/argocd-secret", "", "application/merge-patch+json", "applied/core/argocd/secrets/argocd-secret.patch.yaml"},
+		{"/apis/cert-manager.io/v1/clusterissuers/letsencrypt/status", "", "application/json", "applied/cert-manager.io/_cluster/clusterissuers/letsencrypt.status.yaml"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, AppliedManifestPath(tt.apiPath, tt.name, tt.contentType), tt.apiPath)
+	}
+}
+
+func TestExportTransport(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	SetManifestExport(&ManifestExport{Dir: dir})
+	SetDryRun(true)
+	t.Cleanup(func() {
+		SetManifestExport(nil)
+		SetDryRun(false)
+	})
+
+	client := &http.Client{Transport: &exportTransport{next: http.DefaultTransport}}
+	body := `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"oidc","namespace":"argocd"},"stringData":{"clientSecret":"hunter2"}}`
+	req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/v1/namespaces/argocd/secrets/oidc?fieldManager=kubefirst", strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/apply-patch+yaml")
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	res, err = client.Get(server.URL + "/api/v1/namespaces/argocd/secrets/oidc")
+	require.NoError(t, err)
+	res.Body.Close()
+
+	assert.Equal(t, []string{"dryRun=All&fieldManager=kubefirst", ""}, queries)
+	exported, err := os.ReadFile(filepath.Join(dir, "applied/core/argocd/secrets/oidc.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: oidc\n  namespace: argocd\nstringData:\n  clientSecret: <redacted>\n", string(exported))
+}
+
+func TestCommitFiles_DryRun(t *testing.T) {
+	repo := newBareRepo(t, "registry/kubefirst/registry.yaml")
+	dir := t.TempDir()
+	SetManifestExport(&ManifestExport{Dir: dir, IncludeSecrets: true})
+	SetDryRun(true)
+	t.Cleanup(func() {
+		SetManifestExport(nil)
+		SetDryRun(false)
+	})
+
+	sha, err := (&GitopsRepo{URL: repo}).CommitFiles(context.Background(), map[string][]byte{
+		"registry/kubefirst/argocd-sso.yaml": []byte("clientSecret: hunter2\n"),
+		"registry/kubefirst/registry.yaml":   nil,
+	}, "configure ArgoCD sso")
+	require.NoError(t, err)
+	assert.Empty(t, sha)
+	assert.Equal(t, 1, commitCount(t, repo))
+
+	exported, err := os.ReadFile(filepath.Join(dir, "registry/kubefirst/argocd-sso.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "clientSecret: hunter2\n", string(exported))
+	assert.NoFileExists(t, filepath.Join(dir, "registry/kubefirst/registry.yaml"))
+}
@@ -0,0 +1,361 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Kinds of exposure, in the order harvester exposure list prints them
+const (
+	ExposureLoadBalancer    = "LoadBalancer"
+	ExposureGatewayListener = "GatewayListener"
+	ExposureIngress         = "Ingress"
+	ExposureUniFiForward    = "UniFiForward"
+	ExposureDNSRecord       = "DNSRecord"
+)
+
+var exposureKinds = []string{ExposureLoadBalancer, ExposureGatewayListener, ExposureIngress, ExposureUniFiForward, ExposureDNSRecord}
+
+// Where an exposure is reachable from
+const (
+	ReachLAN      = "lan"
+	ReachInternet = "internet"
+)
+
+// Exposure is an address and port of the cluster reachable from outside
+// it. Kubernetes objects are expected when an ArgoCD application syncs
+// them, so the gitops repository declares them; port forwards and DNS
+// records when they point at an expected object, or DNS records when
+// create manages them
+type Exposure struct {
+	Kind       string `json:"kind" yaml:"kind"`
+	Name       string `json:"name" yaml:"name"`
+	Host       string `json:"host,omitempty" yaml:"host,omitempty"`
+	Address    string `json:"address" yaml:"address"`
+	Port       string `json:"port,omitempty" yaml:"port,omitempty"`
+	Protocol   string `json:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Reach      string `json:"reach" yaml:"-"`
+	Via        string `json:"via,omitempty" yaml:"-"`
+	Owner      string `json:"owner,omitempty" yaml:"-"`
+	Unexpected bool   `json:"unexpected" yaml:"-"`
+}
+
+// Key identifies the exposure in a baseline
+func (e Exposure) Key() string {
+	return strings.Join([]string{e.Kind, e.Name, e.Host, e.Address, e.Port, e.Protocol}, "|")
+}
+
+// ExposureInventory is every exposure of the cluster. Sources that could
+// not be queried are named in Errors instead of failing the inventory
+type ExposureInventory struct {
+	Exposures []Exposure `json:"exposures"`
+	Errors    []string   `json:"errors,omitempty"`
+}
+
+// KubernetesExposures lists the addresses held by LoadBalancer services,
+// the listeners of Gateways and the hosts of Ingresses
+func (c *Client) KubernetesExposures(ctx context.Context) ([]Exposure, error) {
+	services, err := c.Clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	var exposures []Exposure
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			for _, port := range service.Spec.Ports {
+				exposures = append(exposures, Exposure{
+					Kind:     ExposureLoadBalancer,
+					Name:     service.Namespace + "/" + service.Name,
+					Address:  ingress.IP,
+					Port:     strconv.Itoa(int(port.Port)),
+					Protocol: string(port.Protocol),
+					Owner:    applicationOwner(&service),
+				})
+			}
+		}
+	}
+
+	gateways, err := c.Dynamic.Resource(gatewayGVR).Namespace("").List(ctx, metav1.ListOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to list gateways: %w", err)
+	}
+	if err == nil {
+		for _, gateway := range gateways.Items {
+			exposures = append(exposures, gatewayExposures(gateway)...)
+		}
+	}
+
+	ingresses, err := c.Clientset.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+	for _, ingress := range ingresses.Items {
+		tlsHosts := map[string]bool{}
+		for _, tls := range ingress.Spec.TLS {
+			for _, host := range tls.Hosts {
+				tlsHosts[host] = true
+			}
+		}
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			for _, rule := range ingress.Spec.Rules {
+				port, protocol := "80", "HTTP"
+				if tlsHosts[rule.Host] {
+					port, protocol = "443", "HTTPS"
+				}
+				exposures = append(exposures, Exposure{
+					Kind:     ExposureIngress,
+					Name:     ingress.Namespace + "/" + ingress.Name,
+					Host:     rule.Host,
+					Address:  lb.IP,
+					Port:     port,
+					Protocol: protocol,
+					Owner:    applicationOwner(&ingress),
+				})
+			}
+		}
+	}
+
+	return exposures, nil
+}
+
+// gatewayExposures lists every listener of gateway on every address it was
+// given
+func gatewayExposures(gateway unstructured.Unstructured) []Exposure {
+	addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+
+	var exposures []Exposure
+	for _, address := range addresses {
+		value, _, _ := unstructured.NestedString(address.(map[string]interface{}), "value")
+		for _, listener := range listeners {
+			listener := listener.(map[string]interface{})
+			hostname, _, _ := unstructured.NestedString(listener, "hostname")
+			protocol, _, _ := unstructured.NestedString(listener, "protocol")
+			port, _, _ := unstructured.NestedInt64(listener, "port")
+			exposures = append(exposures, Exposure{
+				Kind:     ExposureGatewayListener,
+				Name:     fmt.Sprintf("%s/%s/%s", gateway.GetNamespace(), gateway.GetName(), listener["name"]),
+				Host:     hostname,
+				Address:  value,
+				Port:     strconv.FormatInt(port, 10),
+				Protocol: protocol,
+				Owner:    applicationOwner(&gateway),
+			})
+		}
+	}
+
+	return exposures
+}
+
+// CorrelateExposures joins the Kubernetes exposures with the port forwards
+// and DNS records pointing at their addresses. Forwards make what they
+// target reachable from the internet, forwards and records pointing
+// elsewhere are not tied to the cluster and left out
+func CorrelateExposures(kubernetes []Exposure, forwards []UniFiForward, records []DNSRecord, managedComment string) []Exposure {
+	addresses := map[string]bool{}
+	expected := map[string]bool{}
+	exposures := make([]Exposure, 0, len(kubernetes))
+	for _, exposure := range kubernetes {
+		exposure.Reach = addressReach(exposure.Address)
+		exposure.Unexpected = exposure.Owner == ""
+		addresses[exposure.Address] = true
+		if !exposure.Unexpected {
+			expected[exposure.Address] = true
+		}
+		exposures = append(exposures, exposure)
+	}
+
+	for _, forward := range forwards {
+		if !forward.Enabled || !addresses[forward.Fwd] {
+			continue
+		}
+		fwdPort := forward.FwdPort
+		if fwdPort == "" {
+			fwdPort = forward.DstPort
+		}
+
+		targeted := false
+		for i := range exposures[:len(kubernetes)] {
+			if exposures[i].Address == forward.Fwd && exposures[i].Port == fwdPort {
+				exposures[i].Reach = ReachInternet
+				exposures[i].Via = fmt.Sprintf("unifi forward %s from wan port %s", forward.Name, forward.DstPort)
+				targeted = targeted || !exposures[i].Unexpected
+			}
+		}
+
+		via := fmt.Sprintf("to %s:%s", forward.Fwd, fwdPort)
+		if forward.Src != "" && forward.Src != "any" {
+			via += " from " + forward.Src
+		}
+		exposures = append(exposures, Exposure{
+			Kind:       ExposureUniFiForward,
+			Name:       forward.Name,
+			Address:    forward.Fwd,
+			Port:       forward.DstPort,
+			Protocol:   strings.ToUpper(forward.Proto),
+			Reach:      ReachInternet,
+			Via:        via,
+			Unexpected: !targeted,
+		})
+	}
+
+	for _, record := range records {
+		managed := managedComment != "" && record.Comment == managedComment
+		if !managed && !addresses[record.Content] {
+			continue
+		}
+		exposures = append(exposures, Exposure{
+			Kind:       ExposureDNSRecord,
+			Name:       record.Name,
+			Host:       record.Name,
+			Address:    record.Content,
+			Reach:      addressReach(record.Content),
+			Unexpected: !managed && !expected[record.Content],
+		})
+	}
+
+	sortExposures(exposures)
+
+	return exposures
+}
+
+// addressReach is lan for private addresses and internet otherwise
+func addressReach(address string) string {
+	addr, err := netip.ParseAddr(address)
+	if err == nil && (addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast()) {
+		return ReachLAN
+	}
+
+	return ReachInternet
+}
+
+func sortExposures(exposures []Exposure) {
+	rank := map[string]int{}
+	for i, kind := range exposureKinds {
+		rank[kind] = i
+	}
+	sort.SliceStable(exposures, func(i, j int) bool {
+		if rank[exposures[i].Kind] != rank[exposures[j].Kind] {
+			return rank[exposures[i].Kind] < rank[exposures[j].Kind]
+		}
+		return exposures[i].Key() < exposures[j].Key()
+	})
+}
+
+// WriteTable writes the inventory as a table, marking unexpected exposures
+func (i *ExposureInventory) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tHOST\tADDRESS\tPORT\tREACH\tVIA\tOWNER\tEXPECTED")
+	for _, exposure := range i.Exposures {
+		expected := "yes"
+		if exposure.Unexpected {
+			expected = "NO"
+		}
+		port := exposure.Port
+		if exposure.Protocol != "" {
+			port += "/" + exposure.Protocol
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", exposure.Kind, exposure.Name, exposure.Host, exposure.Address, port, exposure.Reach, exposure.Via, exposure.Owner, expected)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, message := range i.Errors {
+		fmt.Fprintf(w, "warning: %s\n", message)
+	}
+
+	return nil
+}
+
+// ExposureBaseline is the approved exposure set of a cluster, pinned with
+// harvester exposure list --update-baseline
+type ExposureBaseline struct {
+	Exposures []Exposure `yaml:"exposures"`
+}
+
+// ReadExposureBaseline reads the baseline at path, which is empty when the
+// file does not exist yet
+func ReadExposureBaseline(path string) (*ExposureBaseline, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &ExposureBaseline{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read exposure baseline %q: %w", path, err)
+	}
+
+	var baseline ExposureBaseline
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&baseline); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse exposure baseline %q: %w", path, err)
+	}
+
+	return &baseline, nil
+}
+
+// WriteExposureBaseline pins exposures as the baseline at path
+func WriteExposureBaseline(path string, exposures []Exposure) error {
+	data, err := yaml.Marshal(ExposureBaseline{Exposures: exposures})
+	if err != nil {
+		return fmt.Errorf("failed to render exposure baseline: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write exposure baseline %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// Diff returns the exposures missing from the baseline and the baseline
+// entries that are no longer exposed
+func (b *ExposureBaseline) Diff(exposures []Exposure) (added, removed []Exposure) {
+	approved := map[string]bool{}
+	for _, exposure := range b.Exposures {
+		approved[exposure.Key()] = true
+	}
+
+	current := map[string]bool{}
+	for _, exposure := range exposures {
+		current[exposure.Key()] = true
+		if !approved[exposure.Key()] {
+			added = append(added, exposure)
+		}
+	}
+
+	for _, exposure := range b.Exposures {
+		if !current[exposure.Key()] {
+			removed = append(removed, exposure)
+		}
+	}
+
+	return added, removed
+}
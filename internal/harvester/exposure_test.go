@@ -0,0 +1,108 @@
+package harvester
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestExposures(t *testing.T) {
+	gateway := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata": map[string]interface{}{
+			"name":        WildcardGatewayName,
+			"namespace":   WildcardGatewayNamespace,
+			"annotations": map[string]interface{}{argoCDTrackingAnnotation: "kgateway:gateway.networking.k8s.io/Gateway:kgateway-system/vcluster-wildcard"},
+		},
+		"spec":   map[string]interface{}{"listeners": []interface{}{map[string]interface{}{"name": "https-dev", "hostname": "*.dev.example.com", "port": int64(443), "protocol": "HTTPS"}}},
+		"status": map[string]interface{}{"addresses": []interface{}{map[string]interface{}{"value": "10.0.0.20"}}},
+	}}
+	client := &Client{
+		Clientset: fake.NewClientset(
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "ingress-nginx-controller", Namespace: "ingress-nginx", Labels: map[string]string{argoCDInstanceLabel: "ingress-nginx"}},
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{{Port: 443, Protocol: corev1.ProtocolTCP}}},
+				Status:     corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "10.0.0.10"}}}},
+			},
+			&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "minecraft", Namespace: "games"},
+				Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{{Port: 25565, Protocol: corev1.ProtocolTCP}}},
+				Status:     corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "10.0.0.11"}}}},
+			},
+			&networkingv1.Ingress{
+				ObjectMeta: metav1.ObjectMeta{Name: "argocd-server", Namespace: ArgoCDNamespace, Labels: map[string]string{argoCDInstanceLabel: "argocd"}},
+				Spec: networkingv1.IngressSpec{
+					Rules: []networkingv1.IngressRule{{Host: "argocd.example.com"}},
+					TLS:   []networkingv1.IngressTLS{{Hosts: []string{"argocd.example.com"}}},
+				},
+				Status: networkingv1.IngressStatus{LoadBalancer: networkingv1.IngressLoadBalancerStatus{Ingress: []networkingv1.IngressLoadBalancerIngress{{IP: "10.0.0.10"}}}},
+			},
+		),
+		Dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{gatewayGVR: "GatewayList"}),
+	}
+	// the fake tracker guesses "gatewaies" for objects passed to it
+	_, err := client.Dynamic.Resource(gatewayGVR).Namespace(WildcardGatewayNamespace).Create(context.Background(), gateway, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	kubernetes, err := client.KubernetesExposures(context.Background())
+	require.NoError(t, err)
+	require.Len(t, kubernetes, 4)
+
+	forwards := []UniFiForward{
+		{Name: "https", Enabled: true, DstPort: "443", Fwd: "10.0.0.10", FwdPort: "443", Proto: "tcp"},
+		{Name: "minecraft", Enabled: true, DstPort: "25565", Fwd: "10.0.0.11", Proto: "tcp_udp"},
+		{Name: "nas", Enabled: true, DstPort: "5001", Fwd: "10.0.0.99", Proto: "tcp"},
+	}
+	records := []DNSRecord{
+		{Name: "argocd.example.com", Content: "203.0.113.7", Comment: ManagedRecordComment("kubefirst")},
+		{Name: "mc.example.com", Content: "10.0.0.11"},
+		{Name: "blog.example.com", Content: "198.51.100.1"},
+	}
+	exposures := CorrelateExposures(kubernetes, forwards, records, ManagedRecordComment("kubefirst"))
+
+	type row struct {
+		kind, name, reach string
+		unexpected        bool
+	}
+	var rows []row
+	for _, exposure := range exposures {
+		rows = append(rows, row{exposure.Kind, exposure.Name, exposure.Reach, exposure.Unexpected})
+	}
+	assert.Equal(t, []row{
+		{ExposureLoadBalancer, "games/minecraft", ReachInternet, true},
+		{ExposureLoadBalancer, "ingress-nginx/ingress-nginx-controller", ReachInternet, false},
+		{ExposureGatewayListener, "kgateway-system/vcluster-wildcard/https-dev", ReachLAN, false},
+		{ExposureIngress, "argocd/argocd-server", ReachInternet, false},
+		{ExposureUniFiForward, "https", ReachInternet, false},
+		{ExposureUniFiForward, "minecraft", ReachInternet, true},
+		{ExposureDNSRecord, "argocd.example.com", ReachInternet, false},
+		{ExposureDNSRecord, "mc.example.com", ReachLAN, true},
+	}, rows)
+	assert.Equal(t, "unifi forward https from wan port 443", exposures[1].Via)
+
+	path := filepath.Join(t.TempDir(), "baseline.yaml")
+	baseline, err := ReadExposureBaseline(path)
+	require.NoError(t, err)
+	added, _ := baseline.Diff(exposures)
+	assert.Len(t, added, len(exposures))
+
+	require.NoError(t, WriteExposureBaseline(path, exposures[1:]))
+	baseline, err = ReadExposureBaseline(path)
+	require.NoError(t, err)
+	added, removed := baseline.Diff(exposures[:len(exposures)-1])
+	assert.Equal(t, []Exposure{exposures[0]}, added)
+	assert.Equal(t, []string{exposures[len(exposures)-1].Key()}, []string{removed[0].Key()})
+}
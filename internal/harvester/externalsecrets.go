@@ -0,0 +1,211 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// Secret backends External Secrets Operator can read from, as accepted by
+// --external-secrets-backend
+const (
+	ExternalSecretsAWS   = "aws-secrets-manager"
+	ExternalSecretsGCP   = "gcp-secret-manager"
+	ExternalSecretsAzure = "azure-keyvault"
+)
+
+var ExternalSecretsBackends = []string{ExternalSecretsAWS, ExternalSecretsGCP, ExternalSecretsAzure}
+
+const (
+	// ExternalSecretsCatalogApp is the gitops catalog application installing
+	// External Secrets Operator
+	ExternalSecretsCatalogApp = "external-secrets-operator"
+	ExternalSecretsNamespace  = "external-secrets"
+	ClusterSecretStoreName    = "kubefirst"
+
+	externalSecretsCredentialsSecret = "kubefirst-secret-store-credentials"
+)
+
+// externalSecretsEnv lists the environment variables every backend reads
+// its settings and credentials from
+var externalSecretsEnv = map[string][]string{
+	ExternalSecretsAWS:   {"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_REGION"},
+	ExternalSecretsGCP:   {"GOOGLE_APPLICATION_CREDENTIALS", "GOOGLE_CLOUD_PROJECT"},
+	ExternalSecretsAzure: {"ARM_CLIENT_ID", "ARM_CLIENT_SECRET", "ARM_TENANT_ID", "AZURE_KEYVAULT_URL"},
+}
+
+// ExternalSecretsConfig is the secret backend the ClusterSecretStore points
+// at. Credentials are stored in a secret next to the operator, Settings
+// are written into the store itself
+type ExternalSecretsConfig struct {
+	Backend     string
+	Settings    map[string]string
+	Credentials map[string]string
+}
+
+// ValidateExternalSecretsBackend ensures backend is a supported backend
+func ValidateExternalSecretsBackend(backend string) error {
+	if slices.Contains(ExternalSecretsBackends, backend) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown backend %q, must be one of %v", backend, ExternalSecretsBackends)
+}
+
+// ExternalSecretsFromEnv reads the settings and credentials of backend from
+// the environment, naming every variable that is not set
+func ExternalSecretsFromEnv(backend string) (ExternalSecretsConfig, error) {
+	if err := ValidateExternalSecretsBackend(backend); err != nil {
+		return ExternalSecretsConfig{}, err
+	}
+
+	var missing []string
+	env := map[string]string{}
+	for _, name := range externalSecretsEnv[backend] {
+		value := os.Getenv(name)
+		if value == "" {
+			missing = append(missing, name)
+		}
+		env[name] = value
+	}
+	if len(missing) > 0 {
+		return ExternalSecretsConfig{}, fmt.Errorf("backend %s requires %s to be set", backend, strings.Join(missing, ", "))
+	}
+
+	config := ExternalSecretsConfig{Backend: backend}
+	switch backend {
+	case ExternalSecretsAWS:
+		config.Settings = map[string]string{"region": env["AWS_REGION"]}
+		config.Credentials = map[string]string{
+			"access-key-id":     env["AWS_ACCESS_KEY_ID"],
+			"secret-access-key": env["AWS_SECRET_ACCESS_KEY"],
+		}
+	case ExternalSecretsGCP:
+		key, err := os.ReadFile(env["GOOGLE_APPLICATION_CREDENTIALS"])
+		if err != nil {
+			return ExternalSecretsConfig{}, fmt.Errorf("unable to read GOOGLE_APPLICATION_CREDENTIALS file: %w", err)
+		}
+		config.Settings = map[string]string{"projectID": env["GOOGLE_CLOUD_PROJECT"]}
+		config.Credentials = map[string]string{"service-account-key": string(key)}
+	case ExternalSecretsAzure:
+		config.Settings = map[string]string{
+			"tenantId": env["ARM_TENANT_ID"],
+			"vaultUrl": env["AZURE_KEYVAULT_URL"],
+		}
+		config.Credentials = map[string]string{
+			"client-id":     env["ARM_CLIENT_ID"],
+			"client-secret": env["ARM_CLIENT_SECRET"],
+		}
+	}
+
+	return config, nil
+}
+
+// ClusterSecretStoreManifests renders the ClusterSecretStore reading from
+// the backend of config with the credentials ApplyExternalSecretsCredentials
+// stores
+func ClusterSecretStoreManifests(config ExternalSecretsConfig) ([]byte, error) {
+	ref := func(key string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":      externalSecretsCredentialsSecret,
+			"namespace": ExternalSecretsNamespace,
+			"key":       key,
+		}
+	}
+
+	var provider map[string]interface{}
+	switch config.Backend {
+	case ExternalSecretsAWS:
+		provider = map[string]interface{}{"aws": map[string]interface{}{
+			"service": "SecretsManager",
+			"region":  config.Settings["region"],
+			"auth": map[string]interface{}{"secretRef": map[string]interface{}{
+				"accessKeyIDSecretRef":     ref("access-key-id"),
+				"secretAccessKeySecretRef": ref("secret-access-key"),
+			}},
+		}}
+	case ExternalSecretsGCP:
+		provider = map[string]interface{}{"gcpsm": map[string]interface{}{
+			"projectID": config.Settings["projectID"],
+			"auth": map[string]interface{}{"secretRef": map[string]interface{}{
+				"secretAccessKeySecretRef": ref("service-account-key"),
+			}},
+		}}
+	case ExternalSecretsAzure:
+		provider = map[string]interface{}{"azurekv": map[string]interface{}{
+			"authType": "ServicePrincipal",
+			"tenantId": config.Settings["tenantId"],
+			"vaultUrl": config.Settings["vaultUrl"],
+			"authSecretRef": map[string]interface{}{
+				"clientId":     ref("client-id"),
+				"clientSecret": ref("client-secret"),
+			},
+		}}
+	default:
+		return nil, ValidateExternalSecretsBackend(config.Backend)
+	}
+
+	manifest, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "external-secrets.io/v1beta1",
+		"kind":       "ClusterSecretStore",
+		"metadata":   map[string]interface{}{"name": ClusterSecretStoreName},
+		"spec":       map[string]interface{}{"provider": provider},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cluster secret store: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// ApplyExternalSecretsCredentials stores the backend credentials of config
+// where the ClusterSecretStore reads them. They are kept out of the gitops
+// repository
+func (c *Client) ApplyExternalSecretsCredentials(ctx context.Context, config ExternalSecretsConfig) error {
+	// the operator's namespace may not be synced yet
+	namespace := corev1apply.Namespace(ExternalSecretsNamespace)
+	if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, namespace, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return fmt.Errorf("failed to apply namespace %s: %w", ExternalSecretsNamespace, err)
+	}
+
+	secret := corev1apply.Secret(externalSecretsCredentialsSecret, ExternalSecretsNamespace).
+		WithStringData(config.Credentials)
+
+	_, err := c.Clientset.CoreV1().Secrets(ExternalSecretsNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", ExternalSecretsNamespace, externalSecretsCredentialsSecret, err)
+	}
+
+	return nil
+}
+
+// WithCatalogApp adds app to the comma separated catalog apps unless it is
+// already listed
+func WithCatalogApp(catalogApps, app string) string {
+	var apps []string
+	for _, name := range strings.Split(catalogApps, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			apps = append(apps, name)
+		}
+	}
+	if !slices.Contains(apps, app) {
+		apps = append(apps, app)
+	}
+
+	return strings.Join(apps, ",")
+}
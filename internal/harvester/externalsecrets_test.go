@@ -0,0 +1,55 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalSecretsFromEnv(t *testing.T) {
+	t.Run("names the missing variables", func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIA")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+		t.Setenv("AWS_REGION", "")
+
+		_, err := ExternalSecretsFromEnv(ExternalSecretsAWS)
+		require.EqualError(t, err, "backend aws-secrets-manager requires AWS_SECRET_ACCESS_KEY, AWS_REGION to be set")
+	})
+
+	t.Run("rejects unknown backends", func(t *testing.T) {
+		_, err := ExternalSecretsFromEnv("vault")
+		require.ErrorContains(t, err, `unknown backend "vault"`)
+	})
+
+	t.Run("reads azure credentials", func(t *testing.T) {
+		t.Setenv("ARM_CLIENT_ID", "client")
+		t.Setenv("ARM_CLIENT_SECRET", "secret")
+		t.Setenv("ARM_TENANT_ID", "tenant")
+		t.Setenv("AZURE_KEYVAULT_URL", "https://kubefirst.vault.azure.net")
+
+		config, err := ExternalSecretsFromEnv(ExternalSecretsAzure)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"client-id": "client", "client-secret": "secret"}, config.Credentials)
+		assert.Equal(t, "tenant", config.Settings["tenantId"])
+	})
+}
+
+func TestClusterSecretStoreManifests(t *testing.T) {
+	manifest, err := ClusterSecretStoreManifests(ExternalSecretsConfig{
+		Backend:  ExternalSecretsAWS,
+		Settings: map[string]string{"region": "us-east-1"},
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, string(manifest), "kind: ClusterSecretStore")
+	assert.Contains(t, string(manifest), "service: SecretsManager")
+	assert.Contains(t, string(manifest), "region: us-east-1")
+	assert.Contains(t, string(manifest), "name: "+externalSecretsCredentialsSecret)
+}
+
+func TestWithCatalogApp(t *testing.T) {
+	assert.Equal(t, ExternalSecretsCatalogApp, WithCatalogApp("", ExternalSecretsCatalogApp))
+	assert.Equal(t, "kyverno,"+ExternalSecretsCatalogApp, WithCatalogApp("kyverno", ExternalSecretsCatalogApp))
+	assert.Equal(t, ExternalSecretsCatalogApp+",kyverno", WithCatalogApp(ExternalSecretsCatalogApp+", kyverno", ExternalSecretsCatalogApp))
+}
@@ -0,0 +1,161 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FlagValues holds create flags keyed by name with their command line value:
+// true or false for booleans, slices joined with commas. A missing flag is
+// unset
+type FlagValues map[string]string
+
+// set reports whether flag holds something other than its zero value
+func (v FlagValues) set(flag string) bool {
+	switch v[flag] {
+	case "", "false", "0":
+		return false
+	}
+
+	return true
+}
+
+// FlagCondition matches a flag that is set or, with a Value, that holds it
+type FlagCondition struct {
+	Flag  string
+	Value string
+}
+
+// FlagSet matches flag once it is set
+func FlagSet(flag string) FlagCondition {
+	return FlagCondition{Flag: flag}
+}
+
+// FlagIs matches flag while it holds value
+func FlagIs(flag, value string) FlagCondition {
+	return FlagCondition{Flag: flag, Value: value}
+}
+
+func (c FlagCondition) matches(values FlagValues) bool {
+	if c.Value == "" {
+		return values.set(c.Flag)
+	}
+
+	return values[c.Flag] == c.Value
+}
+
+// String spells the condition as it is passed on the command line
+func (c FlagCondition) String() string {
+	switch c.Value {
+	case "", "true":
+		return "--" + c.Flag
+	case "false":
+		return "--" + c.Flag + "=false"
+	}
+
+	return fmt.Sprintf("--%s %s", c.Flag, c.Value)
+}
+
+// Constraint is a rule between create flags: once When matches, every
+// condition of Requires has to match and none of Excludes may
+type Constraint struct {
+	When     FlagCondition
+	Requires []FlagCondition
+	Excludes []FlagCondition
+	// Reason is appended to the error of a violation
+	Reason string
+}
+
+// violations returns an error per condition of c values break
+func (c Constraint) violations(values FlagValues) []error {
+	if !c.When.matches(values) {
+		return nil
+	}
+
+	var errs []error
+	for _, required := range c.Requires {
+		if required.matches(values) {
+			continue
+		}
+		message := fmt.Sprintf("%s requires %s", c.When, required)
+		if required.Value != "" && values[required.Flag] != "" {
+			message += ", not " + values[required.Flag]
+		}
+		errs = append(errs, c.error(message))
+	}
+	for _, excluded := range c.Excludes {
+		if excluded.matches(values) {
+			errs = append(errs, c.error(fmt.Sprintf("%s cannot be combined with %s", c.When, excluded)))
+		}
+	}
+
+	return errs
+}
+
+func (c Constraint) error(message string) error {
+	if c.Reason != "" {
+		message += ": " + c.Reason
+	}
+
+	return errors.New(message)
+}
+
+// CheckFlagConstraints returns every violation of constraints by values,
+// joined, or nil
+func CheckFlagConstraints(constraints []Constraint, values FlagValues) error {
+	var errs []error
+	for _, constraint := range constraints {
+		errs = append(errs, constraint.violations(values)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// CostEstimateFlagConstraints are the constraints of the flags of
+// --cost-estimate, which prints its estimate instead of validating every
+// create flag
+var CostEstimateFlagConstraints = []Constraint{
+	{When: FlagSet("cost-estimate"), Requires: []FlagCondition{FlagSet("dry-run")}, Reason: "the estimate is printed instead of provisioning"},
+	{When: FlagSet("cost-rate-cpu"), Requires: []FlagCondition{FlagSet("cost-estimate")}},
+	{When: FlagSet("cost-rate-memory"), Requires: []FlagCondition{FlagSet("cost-estimate")}},
+}
+
+// CreateFlagConstraints are the mutual exclusions and co-dependencies of
+// the create flags. --vcluster-istio is set once a vcluster is in ambient
+// mode. Rules depending on the parsed value of a flag, such as the
+// vclusters named by --vcluster-istio, stay with the validation of that
+// flag
+var CreateFlagConstraints = append([]Constraint{
+	{When: FlagSet("github-org"), Requires: []FlagCondition{FlagIs("git-provider", "github")}, Reason: "the owner of another git provider is ignored"},
+	{When: FlagSet("gitlab-group"), Requires: []FlagCondition{FlagIs("git-provider", "gitlab")}, Reason: "the owner of another git provider is ignored"},
+	{When: FlagSet("github-app-id"), Requires: []FlagCondition{FlagIs("git-provider", "github"), FlagIs("git-protocol", "https")}, Reason: "the GitHub App authenticates with installation tokens over https"},
+	{When: FlagSet("dry-run"), Requires: []FlagCondition{FlagSet("export-manifests")}, Reason: "the manifests are rendered there"},
+	{When: FlagSet("export-include-secrets"), Requires: []FlagCondition{FlagSet("export-manifests")}},
+	{When: FlagSet("unifi-port-mapping"), Requires: []FlagCondition{FlagIs("ingress-mode", IngressModeUniFi)}},
+	{When: FlagSet("allow-vcluster-to-vcluster"), Requires: []FlagCondition{FlagSet("vcluster-network-isolation")}},
+	{When: FlagSet("extra-domains"), Requires: []FlagCondition{FlagSet("install-kgateway")}, Reason: "the domains are served by a Kgateway listener"},
+	{When: FlagSet("vcluster-domain-map"), Requires: []FlagCondition{FlagSet("install-kgateway")}, Reason: "the domains are served by a Kgateway listener"},
+	{When: FlagSet("vcluster-istio"), Requires: []FlagCondition{FlagSet("install-istio")}, Reason: "Istio must be installed on the host cluster for ambient mode"},
+	{When: FlagSet("vcluster-connect"), Requires: []FlagCondition{FlagSet("install-istio")}, Reason: "the connections are authorized by Istio ambient mode"},
+	{When: FlagIs("wait", "false"), Requires: []FlagCondition{FlagSet("stop-after")}, Reason: "use --skip-verify to skip the final verification of a full run"},
+	{When: FlagSet("external-secrets"), Requires: []FlagCondition{FlagSet("external-secrets-backend")}, Reason: "must be one of " + strings.Join(ExternalSecretsBackends, ", ")},
+	{When: FlagSet("external-secrets-backend"), Requires: []FlagCondition{FlagSet("external-secrets")}},
+	{When: FlagSet("backup-schedule"), Requires: []FlagCondition{FlagSet("backup-storage"), FlagSet("backup-bucket")}},
+	{When: FlagSet("backup-storage"), Requires: []FlagCondition{FlagSet("backup-schedule")}},
+	{When: FlagSet("backup-bucket"), Requires: []FlagCondition{FlagSet("backup-schedule")}},
+	{When: FlagSet("backup-prefix"), Requires: []FlagCondition{FlagSet("backup-schedule")}},
+	{When: FlagSet("trust-bundle"), Requires: []FlagCondition{FlagSet("ca-cert")}, Reason: "it distributes the --ca-cert certificates"},
+	{When: FlagSet("trust-bundle-target"), Requires: []FlagCondition{FlagSet("trust-bundle")}},
+	{When: FlagSet("trust-manager"), Requires: []FlagCondition{FlagSet("trust-bundle")}},
+	{When: FlagSet("trust-bundle-namespace-selector"), Requires: []FlagCondition{FlagSet("trust-bundle"), FlagSet("trust-manager")}},
+	{When: FlagSet("trust-bundle-probe-url"), Requires: []FlagCondition{FlagSet("trust-bundle")}},
+	{When: FlagIs("cluster-type", ClusterTypeWorkload), Requires: []FlagCondition{FlagSet("mgmt-kubeconfig")}, Reason: "the workload cluster is registered with the ArgoCD of that management cluster"},
+	{When: FlagSet("mgmt-kubeconfig"), Requires: []FlagCondition{FlagIs("cluster-type", ClusterTypeWorkload)}},
+}, CostEstimateFlagConstraints...)
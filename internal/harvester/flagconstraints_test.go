@@ -0,0 +1,67 @@
+package harvester
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conditionValue returns a value of flag matching c
+func conditionValue(c FlagCondition) string {
+	if c.Value == "" {
+		return "true"
+	}
+
+	return c.Value
+}
+
+func TestCreateFlagConstraints(t *testing.T) {
+	for _, constraint := range CreateFlagConstraints {
+		t.Run(constraint.When.String(), func(t *testing.T) {
+			satisfied := FlagValues{constraint.When.Flag: conditionValue(constraint.When)}
+			for _, required := range constraint.Requires {
+				satisfied[required.Flag] = conditionValue(required)
+			}
+			assert.Empty(t, constraint.violations(satisfied))
+
+			for _, required := range constraint.Requires {
+				values := FlagValues{}
+				for flag, value := range satisfied {
+					values[flag] = value
+				}
+				delete(values, required.Flag)
+
+				errs := constraint.violations(values)
+				require.Len(t, errs, 1)
+				assert.Contains(t, errs[0].Error(), constraint.When.String())
+				assert.Contains(t, errs[0].Error(), required.String())
+			}
+
+			for _, excluded := range constraint.Excludes {
+				values := FlagValues{constraint.When.Flag: conditionValue(constraint.When), excluded.Flag: conditionValue(excluded)}
+
+				errs := constraint.violations(values)
+				require.Len(t, errs, 1)
+				assert.Contains(t, errs[0].Error(), constraint.When.String())
+				assert.Contains(t, errs[0].Error(), excluded.String())
+			}
+		})
+	}
+}
+
+func TestCheckFlagConstraints(t *testing.T) {
+	err := CheckFlagConstraints(CreateFlagConstraints, FlagValues{"git-provider": "github", "github-org": "holybitsllc", "gitlab-group": "platform"})
+	require.EqualError(t, err, "--gitlab-group requires --git-provider gitlab, not github: the owner of another git provider is ignored")
+
+	err = CheckFlagConstraints(CreateFlagConstraints, FlagValues{"wait": "false", "external-secrets": "true"})
+	require.EqualError(t, err, "--wait=false requires --stop-after: use --skip-verify to skip the final verification of a full run\n"+
+		"--external-secrets requires --external-secrets-backend: must be one of "+strings.Join(ExternalSecretsBackends, ", "))
+
+	err = CheckFlagConstraints(CreateFlagConstraints, FlagValues{"install-istio": "false", "vcluster-istio": "dev,prod", "vcluster-connect": "dev->prod:auth"})
+	require.EqualError(t, err, "--vcluster-istio requires --install-istio: Istio must be installed on the host cluster for ambient mode\n"+
+		"--vcluster-connect requires --install-istio: the connections are authorized by Istio ambient mode")
+
+	require.NoError(t, CheckFlagConstraints(CreateFlagConstraints, FlagValues{"git-provider": "gitlab", "gitlab-group": "platform", "wait": "true", "dry-run": "false", "github-app-id": "0", "install-istio": "false", "vcluster-istio": ""}))
+}
@@ -0,0 +1,146 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+// githubAppJWTLifetime is how long the JWT the app authenticates with is
+// valid, GitHub accepts at most 10 minutes
+const githubAppJWTLifetime = 9 * time.Minute
+
+// GitHubApp authenticates to GitHub as the installation of a GitHub App on
+// an organization instead of with a user token
+type GitHubApp struct {
+	ID int64
+	// PrivateKeyPEM is the key as read, ArgoCD mints its own installation
+	// tokens with it
+	PrivateKeyPEM []byte
+
+	privateKey *rsa.PrivateKey
+	apiURL     string
+}
+
+// LoadGitHubApp reads the PEM private key of the app id from keyPath
+func LoadGitHubApp(id int64, keyPath string) (*GitHubApp, error) {
+	if id <= 0 {
+		return nil, fmt.Errorf("invalid github app id %d", id)
+	}
+
+	data, err := os.ReadFile(os.ExpandEnv(keyPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read github app key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("github app key %s is not PEM encoded", keyPath)
+	}
+
+	var key *rsa.PrivateKey
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		var parsed any
+		if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+			var ok bool
+			if key, ok = parsed.(*rsa.PrivateKey); !ok {
+				err = errors.New("not an RSA key")
+			}
+		}
+	default:
+		err = fmt.Errorf("unexpected PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid github app key %s: %w", keyPath, err)
+	}
+
+	return &GitHubApp{ID: id, PrivateKeyPEM: data, privateKey: key, apiURL: GitHubAPIURL}, nil
+}
+
+// InstallationToken returns the id of the installation of the app on org
+// and a token of that installation, valid for an hour
+func (a *GitHubApp) InstallationToken(ctx context.Context, httpClient *http.Client, org string) (int64, string, error) {
+	jwt, err := a.jwt(time.Now())
+	if err != nil {
+		return 0, "", err
+	}
+
+	next := http.DefaultTransport
+	if httpClient != nil && httpClient.Transport != nil {
+		next = httpClient.Transport
+	}
+	client := github.NewClient(&http.Client{Transport: &tokenTransport{
+		header: "Authorization",
+		value:  "Bearer " + jwt,
+		next:   next,
+	}})
+	client.BaseURL, _ = url.Parse(a.apiURL)
+
+	installation, _, err := client.Apps.FindOrganizationInstallation(ctx, org)
+	if err != nil {
+		return 0, "", fmt.Errorf("github app %d is not installed on %s: %w", a.ID, org, err)
+	}
+
+	token, _, err := client.Apps.CreateInstallationToken(ctx, installation.GetID(), nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to create a token of github app %d on %s: %w", a.ID, org, err)
+	}
+
+	return installation.GetID(), token.GetToken(), nil
+}
+
+// jwt signs the RS256 JWT the app authenticates as itself with. Its issue
+// time is backdated a minute for clock drift, as GitHub recommends
+func (a *GitHubApp) jwt(now time.Time) (string, error) {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(githubAppJWTLifetime).Unix(),
+		"iss": strconv.FormatInt(a.ID, 10),
+	})
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign github app jwt: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// ApplyArgoCDRepoGitHubApp stores app as the ArgoCD repository credential
+// for the https remote repoURL, ArgoCD mints the installation tokens itself
+func (c *Client) ApplyArgoCDRepoGitHubApp(ctx context.Context, repoURL string, app *GitHubApp, installationID int64) error {
+	return c.applyArgoCDRepo(ctx, map[string]string{
+		"type":                    "git",
+		"url":                     repoURL,
+		"githubAppID":             strconv.FormatInt(app.ID, 10),
+		"githubAppInstallationID": strconv.FormatInt(installationID, 10),
+		"githubAppPrivateKey":     string(app.PrivateKeyPEM),
+	})
+}
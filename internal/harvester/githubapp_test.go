@@ -0,0 +1,79 @@
+package harvester
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGitHubApp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "app.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0o600))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwt := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		parts := strings.Split(jwt, ".")
+		require.Len(t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/orgs/homelab/installation":
+			w.Write([]byte(`{"id":7}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/app/installations/7/access_tokens":
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token":"ghs_installation"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+		}
+	}))
+	defer server.Close()
+
+	app, err := LoadGitHubApp(42, keyPath)
+	require.NoError(t, err)
+	app.apiURL = server.URL + "/"
+
+	installationID, token, err := app.InstallationToken(context.Background(), server.Client(), "homelab")
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), installationID)
+	assert.Equal(t, "ghs_installation", token)
+
+	_, _, err = app.InstallationToken(context.Background(), server.Client(), "elsewhere")
+	require.ErrorContains(t, err, "github app 42 is not installed on elsewhere")
+
+	client := &Client{Clientset: fake.NewClientset()}
+	require.NoError(t, client.ApplyArgoCDRepoGitHubApp(context.Background(), "https://github.com/homelab/gitops.git", app, installationID))
+	secret, err := client.Clientset.CoreV1().Secrets(ArgoCDNamespace).Get(context.Background(), ArgoCDRepoSecretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "42", secret.StringData["githubAppID"])
+	assert.Equal(t, "7", secret.StringData["githubAppInstallationID"])
+	assert.Equal(t, string(keyPEM), secret.StringData["githubAppPrivateKey"])
+
+	require.NoError(t, client.DeleteArgoCDRepoSecret(context.Background()))
+	require.NoError(t, client.DeleteArgoCDRepoSecret(context.Background()))
+
+	_, err = LoadGitHubApp(0, keyPath)
+	require.Error(t, err)
+}
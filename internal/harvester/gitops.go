@@ -0,0 +1,387 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/konstructio/kubefirst/internal/readonly"
+)
+
+const (
+	kubefirstBotName  = "kubefirst-bot"
+	kubefirstBotEmail = "kubefirst-bot@kubefirst.com"
+)
+
+// GitopsRepo identifies the gitops repository kubefirst created for the
+// cluster along with the credentials used to push to it
+type GitopsRepo struct {
+	URL   string
+	Auth  *githttp.BasicAuth
+	Proxy transport.ProxyOptions
+	Retry APIRetry
+	// LargeFiles bounds the files CommitFiles commits
+	LargeFiles LargeFilePolicy
+	// Manifests validates the manifests CommitFiles commits
+	Manifests ManifestValidation
+
+	provider   string
+	owner      string
+	name       string
+	apiURL     string
+	httpClient *http.Client
+}
+
+// NewGitopsRepo resolves the https remote and token of the gitops repository
+// named repoName under owner, using GITHUB_TOKEN or GITLAB_TOKEN
+func (c *Client) NewGitopsRepo(gitProvider, owner, repoName string) (*GitopsRepo, error) {
+	var host, apiURL, tokenEnv, username string
+	switch gitProvider {
+	case "github":
+		host, apiURL, tokenEnv, username = "github.com", GitHubAPIURL, "GITHUB_TOKEN", kubefirstBotName
+	case "gitlab":
+		host, apiURL, tokenEnv, username = "gitlab.com", GitLabAPIURL, "GITLAB_TOKEN", "oauth2"
+	default:
+		return nil, fmt.Errorf("unsupported git provider %q", gitProvider)
+	}
+
+	token := os.Getenv(tokenEnv)
+	if token == "" {
+		return nil, fmt.Errorf("your %s is not set. Please set and try again", tokenEnv)
+	}
+
+	repoURL := fmt.Sprintf("https://%s/%s/%s.git", host, owner, repoName)
+
+	return &GitopsRepo{
+		URL:   repoURL,
+		Auth:  &githttp.BasicAuth{Username: username, Password: token},
+		Proxy: gitProxyOptions(c.proxy, repoURL),
+		Retry: c.Retry,
+
+		LargeFiles: c.LargeFiles,
+		Manifests:  c.Manifests,
+
+		provider:   gitProvider,
+		owner:      owner,
+		name:       repoName,
+		apiURL:     apiURL,
+		httpClient: c.HTTPClient,
+	}, nil
+}
+
+// CommitFiles writes files, keyed by their path in the repository, on top of
+// the default branch, pushes the result and returns the SHA of the new
+// commit. A nil content removes the file or directory at its path. Nothing
+// is pushed when the files already have the requested contents, in which
+// case the SHA of the current head is returned. Files are checked against
+// LargeFiles first, the ones it stores with Git LFS are uploaded and
+// committed as pointers, and their manifests are validated by Manifests. The files are exported to the ManifestExport of
+// the process before anything is pushed, and only exported in dry-run mode,
+// which returns an empty SHA
+func (r *GitopsRepo) CommitFiles(ctx context.Context, files map[string][]byte, message string) (string, error) {
+	if err := readonly.Check(fmt.Sprintf("push %q to gitops repository %q", message, r.URL)); err != nil {
+		return "", err
+	}
+
+	if _, err := r.LargeFiles.Check(files); err != nil {
+		return "", err
+	}
+	if err := r.Manifests.Check(ctx, files); err != nil {
+		return "", err
+	}
+
+	if err := exportGitopsFiles(files); err != nil {
+		return "", err
+	}
+	if DryRun() {
+		return "", nil
+	}
+
+	fs := memfs.New()
+	repo, err := r.clone(ctx, fs, 1)
+	if err != nil {
+		return "", err
+	}
+
+	attributes, err := util.ReadFile(fs, ".gitattributes")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+	if files, err = r.storeLFSFiles(ctx, files, attributes); err != nil {
+		return "", err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to open gitops worktree: %w", err)
+	}
+
+	for name, content := range files {
+		if content == nil {
+			if _, err := fs.Lstat(name); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if _, err := worktree.Remove(name); err != nil {
+				return "", fmt.Errorf("failed to remove %q: %w", name, err)
+			}
+			continue
+		}
+		if err := fs.MkdirAll(path.Dir(name), 0o755); err != nil {
+			return "", fmt.Errorf("failed to create directory for %q: %w", name, err)
+		}
+		if err := util.WriteFile(fs, name, content, 0o644); err != nil {
+			return "", fmt.Errorf("failed to write %q: %w", name, err)
+		}
+		if _, err := worktree.Add(name); err != nil {
+			return "", fmt.Errorf("failed to stage %q: %w", name, err)
+		}
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return "", fmt.Errorf("failed to read gitops worktree status: %w", err)
+	}
+	if status.IsClean() {
+		head, err := repo.Head()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve gitops head: %w", err)
+		}
+		return head.Hash().String(), nil
+	}
+
+	hash, err := worktree.Commit(message, &git.CommitOptions{Author: botSignature()})
+	if err != nil {
+		return "", fmt.Errorf("failed to commit to gitops repository: %w", err)
+	}
+
+	if err := r.push(ctx, repo); err != nil {
+		return "", err
+	}
+
+	return hash.String(), nil
+}
+
+// ReadYAMLFiles returns the yaml files of the default branch under dir,
+// keyed by their path in the repository
+func (r *GitopsRepo) ReadYAMLFiles(ctx context.Context, dir string) (map[string][]byte, error) {
+	return r.readFiles(ctx, dir, func(name string) bool {
+		return path.Ext(name) == ".yaml" || path.Ext(name) == ".yml"
+	})
+}
+
+// ReadFiles returns every file of the default branch under dir, keyed by
+// its path in the repository
+func (r *GitopsRepo) ReadFiles(ctx context.Context, dir string) (map[string][]byte, error) {
+	return r.readFiles(ctx, dir, func(string) bool { return true })
+}
+
+func (r *GitopsRepo) readFiles(ctx context.Context, dir string, match func(name string) bool) (map[string][]byte, error) {
+	fs := memfs.New()
+	if _, err := r.clone(ctx, fs, 1); err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	err := util.Walk(fs, dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !match(name) {
+			return nil
+		}
+
+		content, err := util.ReadFile(fs, name)
+		if err != nil {
+			return fmt.Errorf("failed to read %q: %w", name, err)
+		}
+		files[strings.TrimPrefix(name, "/")] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %q of gitops repository %q: %w", dir, r.URL, err)
+	}
+
+	return files, nil
+}
+
+// Head returns the SHA the default branch of the repository points at
+func (r *GitopsRepo) Head(ctx context.Context) (string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{r.URL},
+	})
+
+	tlsOptions := currentTLSOptions()
+	refs, err := remote.ListContext(ctx, &git.ListOptions{
+		Auth:            r.Auth,
+		ProxyOptions:    r.Proxy,
+		CABundle:        tlsOptions.CABundle,
+		InsecureSkipTLS: tlsOptions.InsecureSkipVerify,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list references of gitops repository %q: %w", r.URL, err)
+	}
+
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+
+	head, ok := byName[plumbing.HEAD]
+	for ok && head.Type() == plumbing.SymbolicReference {
+		head, ok = byName[head.Target()]
+	}
+	if !ok {
+		return "", fmt.Errorf("gitops repository %q has no default branch", r.URL)
+	}
+
+	return head.Hash().String(), nil
+}
+
+// RevertTo pushes a new commit on top of the default branch that restores
+// the tree of the commit sha, leaving the history in between intact, and
+// returns the SHA of that commit
+func (r *GitopsRepo) RevertTo(ctx context.Context, sha, message string) (string, error) {
+	if err := readonly.Check(fmt.Sprintf("revert gitops repository %q to %s", r.URL, sha)); err != nil {
+		return "", err
+	}
+
+	repo, err := r.clone(ctx, memfs.New(), 0)
+	if err != nil {
+		return "", err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve gitops head: %w", err)
+	}
+
+	target, err := repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return "", fmt.Errorf("commit %s not found in gitops repository: %w", sha, err)
+	}
+
+	commit := &object.Commit{
+		Author:       *botSignature(),
+		Committer:    *botSignature(),
+		Message:      message,
+		TreeHash:     target.TreeHash,
+		ParentHashes: []plumbing.Hash{head.Hash()},
+	}
+
+	encoded := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(encoded); err != nil {
+		return "", fmt.Errorf("failed to encode revert commit: %w", err)
+	}
+
+	hash, err := repo.Storer.SetEncodedObject(encoded)
+	if err != nil {
+		return "", fmt.Errorf("failed to store revert commit: %w", err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(head.Name(), hash)); err != nil {
+		return "", fmt.Errorf("failed to move %s to the revert commit: %w", head.Name().Short(), err)
+	}
+
+	if err := r.push(ctx, repo); err != nil {
+		return "", err
+	}
+
+	return hash.String(), nil
+}
+
+// clone clones the default branch into fs; a depth of 0 fetches the full
+// history
+func (r *GitopsRepo) clone(ctx context.Context, fs billy.Filesystem, depth int) (*git.Repository, error) {
+	var repo *git.Repository
+	err := r.Retry.Do(ctx, "gitops repository clone", func(ctx context.Context) error {
+		// a failed attempt may have checked out part of the worktree
+		if err := resetWorktree(fs); err != nil {
+			return err
+		}
+
+		var err error
+		tlsOptions := currentTLSOptions()
+		repo, err = git.CloneContext(ctx, memory.NewStorage(), fs, &git.CloneOptions{
+			URL:             r.URL,
+			Auth:            r.Auth,
+			Depth:           depth,
+			SingleBranch:    true,
+			ProxyOptions:    r.Proxy,
+			CABundle:        tlsOptions.CABundle,
+			InsecureSkipTLS: tlsOptions.InsecureSkipVerify,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone gitops repository %q: %w", r.URL, err)
+	}
+
+	return repo, nil
+}
+
+func resetWorktree(fs billy.Filesystem) error {
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		return fmt.Errorf("failed to reset the worktree: %w", err)
+	}
+	for _, entry := range entries {
+		if err := util.RemoveAll(fs, entry.Name()); err != nil {
+			return fmt.Errorf("failed to reset the worktree: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// push pushes the local commits of repo. Pushing the same commits again is
+// idempotent, so transient failures are retried
+func (r *GitopsRepo) push(ctx context.Context, repo *git.Repository) error {
+	err := r.Retry.Do(ctx, "gitops repository push", func(ctx context.Context) error {
+		tlsOptions := currentTLSOptions()
+		err := repo.PushContext(ctx, &git.PushOptions{
+			Auth:            r.Auth,
+			ProxyOptions:    r.Proxy,
+			CABundle:        tlsOptions.CABundle,
+			InsecureSkipTLS: tlsOptions.InsecureSkipVerify,
+		})
+		if errors.Is(err, git.NoErrAlreadyUpToDate) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push to gitops repository %q: %w", r.URL, err)
+	}
+
+	return nil
+}
+
+func botSignature() *object.Signature {
+	return &object.Signature{
+		Name:  kubefirstBotName,
+		Email: kubefirstBotEmail,
+		When:  time.Now(),
+	}
+}
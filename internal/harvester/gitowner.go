@@ -0,0 +1,41 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GitOwnerFlag returns the flag naming the owner of the gitops repository
+// with gitProvider
+func GitOwnerFlag(gitProvider string) string {
+	switch gitProvider {
+	case "gitlab":
+		return "gitlab-group"
+	default:
+		return "github-org"
+	}
+}
+
+// ValidateGitOwner ensures the owner flag of gitProvider is set, owners
+// holding the --github-org and --gitlab-group values. The
+// owner flags of other providers are rejected by CreateFlagConstraints
+func ValidateGitOwner(gitProvider string, owners map[string]string) error {
+	ownerFlag := GitOwnerFlag(gitProvider)
+	switch gitProvider {
+	case "github", "gitlab":
+	default:
+		return fmt.Errorf("unsupported --git-provider %q, must be one of github, gitlab", gitProvider)
+	}
+
+	if strings.TrimSpace(owners[ownerFlag]) == "" {
+		return fmt.Errorf("--git-provider %s requires --%s, the owner of the new gitops repository", gitProvider, ownerFlag)
+	}
+
+	return nil
+}
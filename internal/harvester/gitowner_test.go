@@ -0,0 +1,26 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateGitOwner(t *testing.T) {
+	t.Run("requires the owner of the provider", func(t *testing.T) {
+		err := ValidateGitOwner("gitlab", map[string]string{"github-org": "holybitsllc"})
+		require.EqualError(t, err, "--git-provider gitlab requires --gitlab-group, the owner of the new gitops repository")
+
+		err = ValidateGitOwner("github", map[string]string{"github-org": " "})
+		require.EqualError(t, err, "--git-provider github requires --github-org, the owner of the new gitops repository")
+	})
+
+	t.Run("accepts the owner of the provider", func(t *testing.T) {
+		require.NoError(t, ValidateGitOwner("gitlab", map[string]string{"gitlab-group": "platform"}))
+	})
+
+	t.Run("rejects unknown providers", func(t *testing.T) {
+		err := ValidateGitOwner("bitbucket", map[string]string{"github-org": "holybitsllc"})
+		require.ErrorContains(t, err, "unsupported --git-provider")
+	})
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+var (
+	// GitHubTokenScopes are the classic token scopes provisioning creates
+	// the gitops repositories, teams and deploy keys with
+	GitHubTokenScopes = []string{"repo", "workflow", "admin:org", "admin:public_key", "admin:repo_hook", "delete_repo"}
+	// GitLabTokenScopes are the personal access token scopes provisioning
+	// needs on the GitLab group
+	GitLabTokenScopes = []string{"api"}
+)
+
+// CheckGitHubTokenScopes fails when token is rejected by GitHub or lacks
+// one of GitHubTokenScopes. Fine-grained tokens carry no X-OAuth-Scopes
+// header and are left to the permission checks of provisioning
+func CheckGitHubTokenScopes(ctx context.Context, httpClient *http.Client, apiURL, token string) error {
+	res, err := tokenProbe(ctx, httpClient, apiURL+"user", "Authorization", "Bearer "+token)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GITHUB_TOKEN was rejected by GitHub: %s", res.Status)
+	}
+
+	header, ok := res.Header["X-Oauth-Scopes"]
+	if !ok {
+		return nil
+	}
+
+	return missingScopes("GITHUB_TOKEN", GitHubTokenScopes, strings.Split(strings.Join(header, ","), ","))
+}
+
+// CheckGitLabTokenScopes fails when token is rejected by GitLab or lacks
+// one of GitLabTokenScopes
+func CheckGitLabTokenScopes(ctx context.Context, httpClient *http.Client, apiURL, token string) error {
+	res, err := tokenProbe(ctx, httpClient, apiURL+"personal_access_tokens/self", "PRIVATE-TOKEN", token)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GITLAB_TOKEN was rejected by GitLab: %s", res.Status)
+	}
+
+	var self struct {
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 64<<10)).Decode(&self); err != nil {
+		return fmt.Errorf("failed to decode gitlab token: %w", err)
+	}
+
+	return missingScopes("GITLAB_TOKEN", GitLabTokenScopes, self.Scopes)
+}
+
+func missingScopes(tokenEnv string, required, granted []string) error {
+	grantedSet := map[string]bool{}
+	for _, scope := range granted {
+		grantedSet[strings.TrimSpace(scope)] = true
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if !grantedSet[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing the scopes %s", tokenEnv, strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func tokenProbe(ctx context.Context, httpClient *http.Client, endpoint, header, value string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token check request: %w", err)
+	}
+	req.Header.Set(header, value)
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check token: %w", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,36 @@
+package harvester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckTokenScopes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/user" && r.Header.Get("Authorization") == "Bearer classic":
+			w.Header().Set("X-OAuth-Scopes", "repo, workflow, admin:org, admin:public_key, admin:repo_hook, delete_repo")
+		case r.URL.Path == "/user" && r.Header.Get("Authorization") == "Bearer narrow":
+			w.Header().Set("X-OAuth-Scopes", "repo, workflow")
+		case r.URL.Path == "/user" && r.Header.Get("Authorization") == "Bearer fine-grained":
+		case r.URL.Path == "/personal_access_tokens/self" && r.Header.Get("PRIVATE-TOKEN") == "read":
+			w.Write([]byte(`{"scopes": ["read_api"]}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	require.NoError(t, CheckGitHubTokenScopes(ctx, server.Client(), server.URL+"/", "classic"))
+	require.NoError(t, CheckGitHubTokenScopes(ctx, server.Client(), server.URL+"/", "fine-grained"))
+	assert.EqualError(t, CheckGitHubTokenScopes(ctx, server.Client(), server.URL+"/", "narrow"), "GITHUB_TOKEN is missing the scopes admin:org, admin:public_key, admin:repo_hook, delete_repo")
+	assert.ErrorContains(t, CheckGitHubTokenScopes(ctx, server.Client(), server.URL+"/", "revoked"), "rejected")
+
+	assert.EqualError(t, CheckGitLabTokenScopes(ctx, server.Client(), server.URL+"/", "read"), "GITLAB_TOKEN is missing the scopes api")
+}
@@ -0,0 +1,265 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// GPUOperatorCatalogApp is the gitops catalog application installing
+	// the NVIDIA GPU Operator. It reads its driver image tag from
+	// GPUDriverVersionEnv
+	GPUOperatorCatalogApp   = "nvidia-gpu-operator"
+	GPUOperatorNamespace    = "gpu-operator"
+	GPUDriverVersionEnv     = "GPU_DRIVER_VERSION"
+	DefaultGPUDriverVersion = "550.127.05"
+
+	// GPUTaintKey taints the --gpu-nodes so only workloads asking for a GPU
+	// are scheduled on them
+	GPUTaintKey   = "nvidia.com/gpu"
+	GPUTaintValue = "present"
+	GPUSmokeTest  = "kubefirst-gpu-smoke-test"
+
+	// DefaultGPUSmokeTestTimeout leaves the operator time to build the
+	// driver and register the GPUs before the smoke test is scheduled
+	DefaultGPUSmokeTestTimeout = 15 * time.Minute
+
+	gpuResource       corev1.ResourceName = "nvidia.com/gpu"
+	gpuSmokeTestImage                     = "nvcr.io/nvidia/cuda:12.4.1-base-ubuntu22.04"
+	gpuSmokeTestLogs                      = 20
+)
+
+// imageTagGrammar is the grammar of a container image tag
+var imageTagGrammar = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// ValidateGPUDriverVersion ensures version can tag the driver image
+func ValidateGPUDriverVersion(version string) error {
+	if !imageTagGrammar.MatchString(version) {
+		return fmt.Errorf("%q is not a valid image tag", version)
+	}
+
+	return nil
+}
+
+// ResolveGPUNodes returns the names of the Harvester nodes entries select,
+// sorted. Each entry is a node name or, when it holds an operator, a label
+// selector such as nvidia.com/gpu.present=true. A name that does not exist
+// or a selector that matches no node is an error
+func (c *Client) ResolveGPUNodes(ctx context.Context, entries []string) ([]string, error) {
+	var nodes []string
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		if !strings.ContainsAny(entry, "=!") {
+			if _, err := c.Clientset.CoreV1().Nodes().Get(ctx, entry, metav1.GetOptions{}); err != nil {
+				if apierrors.IsNotFound(err) {
+					return nil, fmt.Errorf("node %q does not exist in the Harvester cluster", entry)
+				}
+				return nil, fmt.Errorf("failed to read node %s: %w", entry, err)
+			}
+			nodes = append(nodes, entry)
+			continue
+		}
+
+		selector, err := labels.Parse(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node selector %q: %w", entry, err)
+		}
+		list, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes matching %s: %w", entry, err)
+		}
+		if len(list.Items) == 0 {
+			return nil, fmt.Errorf("no node of the Harvester cluster matches %q", entry)
+		}
+		for _, node := range list.Items {
+			nodes = append(nodes, node.Name)
+		}
+	}
+
+	slices.Sort(nodes)
+	return slices.Compact(nodes), nil
+}
+
+// TaintGPUNodes taints nodes with nvidia.com/gpu=present:NoSchedule,
+// replacing an existing taint of that key
+func (c *Client) TaintGPUNodes(ctx context.Context, nodes []string) error {
+	taint := corev1.Taint{Key: GPUTaintKey, Value: GPUTaintValue, Effect: corev1.TaintEffectNoSchedule}
+
+	for _, name := range nodes {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err := c.Clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			taints := slices.DeleteFunc(node.Spec.Taints, func(existing corev1.Taint) bool {
+				return existing.Key == GPUTaintKey
+			})
+			node.Spec.Taints = append(taints, taint)
+
+			_, err = c.Clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{FieldManager: fieldManager})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to taint node %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// GPUVClusterValues adds to the chart values of a vcluster the virtual
+// scheduler, which sees the host nodes and their GPU taint, and the
+// toleration of that taint on the pods it syncs to the host
+func GPUVClusterValues(values []byte) ([]byte, error) {
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(values, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse vcluster values: %w", err)
+	}
+
+	setValue(merged, true, "controlPlane", "advanced", "virtualScheduler", "enabled")
+	setValue(merged, true, "sync", "fromHost", "nodes", "enabled")
+	setValue(merged, map[string]interface{}{"all": true}, "sync", "fromHost", "nodes", "selector")
+	setValue(merged, []interface{}{fmt.Sprintf("%s=%s:%s", GPUTaintKey, GPUTaintValue, corev1.TaintEffectNoSchedule)}, "sync", "toHost", "pods", "enforceTolerations")
+
+	rendered, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster values: %w", err)
+	}
+
+	return rendered, nil
+}
+
+// RunGPUSmokeTest runs nvidia-smi in a Job requesting a GPU of the tainted
+// nodes and waits for it to finish. A failed Job is an error carrying the
+// end of its logs, a Job never scheduled fails once ctx is done
+func (c *Client) RunGPUSmokeTest(ctx context.Context) error {
+	jobs := c.Clientset.BatchV1().Jobs(GPUOperatorNamespace)
+
+	background := metav1.DeletePropagationBackground
+	if err := jobs.Delete(ctx, GPUSmokeTest, metav1.DeleteOptions{PropagationPolicy: &background}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete previous job %s/%s: %w", GPUOperatorNamespace, GPUSmokeTest, err)
+	}
+
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: GPUSmokeTest, Namespace: GPUOperatorNamespace},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Tolerations: []corev1.Toleration{{
+						Key:      GPUTaintKey,
+						Operator: corev1.TolerationOpEqual,
+						Value:    GPUTaintValue,
+						Effect:   corev1.TaintEffectNoSchedule,
+					}},
+					Containers: []corev1.Container{{
+						Name:    "nvidia-smi",
+						Image:   MirrorImage(gpuSmokeTestImage, c.RegistryMirror),
+						Command: []string{"nvidia-smi"},
+						Resources: corev1.ResourceRequirements{
+							Limits: corev1.ResourceList{gpuResource: resource.MustParse("1")},
+						},
+					}},
+				},
+			},
+		},
+	}
+
+	ticker := time.NewTicker(nodePollInterval)
+	defer ticker.Stop()
+
+	created := false
+	for {
+		if !created {
+			_, err := jobs.Create(ctx, job, metav1.CreateOptions{FieldManager: fieldManager})
+			// the previous job may still be deleting
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				return fmt.Errorf("failed to create job %s/%s: %w", GPUOperatorNamespace, GPUSmokeTest, err)
+			}
+			created = err == nil
+		}
+
+		if created {
+			current, err := jobs.Get(ctx, GPUSmokeTest, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to read job %s/%s: %w", GPUOperatorNamespace, GPUSmokeTest, err)
+			}
+			if current.Status.Succeeded > 0 {
+				return nil
+			}
+			if current.Status.Failed > 0 {
+				return fmt.Errorf("GPU smoke test job %s/%s exited non-zero:\n%s", GPUOperatorNamespace, GPUSmokeTest, c.jobLogs(ctx, GPUSmokeTest))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("GPU smoke test job %s/%s did not complete, check that the GPU operator registered the GPUs of the tainted nodes: %w", GPUOperatorNamespace, GPUSmokeTest, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// jobLogs returns the last lines logged by the pods of the Job name in the
+// GPU operator namespace, or why they could not be read
+func (c *Client) jobLogs(ctx context.Context, name string) string {
+	return jobPodLogs(ctx, c.Clientset, GPUOperatorNamespace, name)
+}
+
+// jobPodLogs returns the last lines logged by the pods of the Job name in
+// namespace of the cluster of clientset, or why they could not be read
+func jobPodLogs(ctx context.Context, clientset kubernetes.Interface, namespace, name string) string {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + name})
+	if err != nil {
+		return fmt.Sprintf("failed to list its pods: %v", err)
+	}
+
+	var b strings.Builder
+	tail := int64(gpuSmokeTestLogs)
+	for _, pod := range pods.Items {
+		stream, err := clientset.CoreV1().Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{TailLines: &tail}).Stream(ctx)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: failed to read logs: %v\n", pod.Name, err)
+			continue
+		}
+		logs, err := io.ReadAll(stream)
+		stream.Close()
+		if err != nil {
+			fmt.Fprintf(&b, "%s: failed to read logs: %v\n", pod.Name, err)
+			continue
+		}
+		fmt.Fprintf(&b, "%s:\n%s\n", pod.Name, strings.TrimRight(string(logs), "\n"))
+	}
+	if b.Len() == 0 {
+		return "no pod logs"
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,101 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestGPUNodes(t *testing.T) {
+	clientset := fake.NewClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-1", Labels: map[string]string{"nvidia.com/gpu.present": "true"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "gpu-2", Labels: map[string]string{"nvidia.com/gpu.present": "true"}}},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "cpu-1"},
+			Spec:       corev1.NodeSpec{Taints: []corev1.Taint{{Key: GPUTaintKey, Value: "absent", Effect: corev1.TaintEffectNoExecute}, {Key: "other", Effect: corev1.TaintEffectNoSchedule}}},
+		},
+	)
+	client := &Client{Clientset: clientset}
+	ctx := context.Background()
+
+	nodes, err := client.ResolveGPUNodes(ctx, []string{"nvidia.com/gpu.present=true", "cpu-1", "gpu-1"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cpu-1", "gpu-1", "gpu-2"}, nodes)
+
+	_, err = client.ResolveGPUNodes(ctx, []string{"gpu-3"})
+	require.ErrorContains(t, err, `node "gpu-3" does not exist`)
+	_, err = client.ResolveGPUNodes(ctx, []string{"gpu=none"})
+	require.ErrorContains(t, err, `no node of the Harvester cluster matches "gpu=none"`)
+
+	require.NoError(t, client.TaintGPUNodes(ctx, nodes))
+	node, err := clientset.CoreV1().Nodes().Get(ctx, "cpu-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []corev1.Taint{
+		{Key: "other", Effect: corev1.TaintEffectNoSchedule},
+		{Key: GPUTaintKey, Value: GPUTaintValue, Effect: corev1.TaintEffectNoSchedule},
+	}, node.Spec.Taints)
+
+	require.NoError(t, ValidateGPUDriverVersion(DefaultGPUDriverVersion))
+	require.Error(t, ValidateGPUDriverVersion("550:latest"))
+}
+
+func TestGPUVClusterValues(t *testing.T) {
+	rendered, err := GPUVClusterValues([]byte("controlPlane:\n  distro:\n    k8s:\n      version: v1.29\n"))
+	require.NoError(t, err)
+
+	var values map[string]any
+	require.NoError(t, yaml.Unmarshal(rendered, &values))
+	assert.Equal(t, map[string]any{
+		"controlPlane": map[string]any{
+			"distro":   map[string]any{"k8s": map[string]any{"version": "v1.29"}},
+			"advanced": map[string]any{"virtualScheduler": map[string]any{"enabled": true}},
+		},
+		"sync": map[string]any{
+			"fromHost": map[string]any{"nodes": map[string]any{"enabled": true, "selector": map[string]any{"all": true}}},
+			"toHost":   map[string]any{"pods": map[string]any{"enforceTolerations": []any{"nvidia.com/gpu=present:NoSchedule"}}},
+		},
+	}, values)
+
+	helmValues, err := VClusterHelmValues(map[string]VClusterSpec{"dev": {}}, true, nil)
+	require.NoError(t, err)
+	assert.Contains(t, helmValues["dev"], "virtualScheduler")
+}
+
+func TestRunGPUSmokeTest(t *testing.T) {
+	for name, status := range map[string]batchv1.JobStatus{
+		"succeeded": {Succeeded: 1},
+		"failed":    {Failed: 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clientset := fake.NewClientset()
+			clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+				job.Status = status
+				return false, nil, nil
+			})
+			client := &Client{Clientset: clientset}
+
+			err := client.RunGPUSmokeTest(context.Background())
+			if status.Failed > 0 {
+				require.ErrorContains(t, err, "exited non-zero")
+				return
+			}
+			require.NoError(t, err)
+
+			job, err := clientset.BatchV1().Jobs(GPUOperatorNamespace).Get(context.Background(), GPUSmokeTest, metav1.GetOptions{})
+			require.NoError(t, err)
+			container := job.Spec.Template.Spec.Containers[0]
+			assert.Equal(t, "1", container.Resources.Limits.Name(gpuResource, "").String())
+			assert.Equal(t, GPUTaintKey, job.Spec.Template.Spec.Tolerations[0].Key)
+		})
+	}
+}
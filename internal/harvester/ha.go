@@ -0,0 +1,118 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultHANodeCount is the number of control plane nodes, and etcd
+	// members, --ha expects the cluster to run
+	DefaultHANodeCount = 3
+
+	controlPlaneLabel = "node-role.kubernetes.io/control-plane"
+	nodePollInterval  = 5 * time.Second
+)
+
+// ValidateHANodeCount ensures an HA control plane has an odd number of at
+// least three members so etcd keeps quorum through the loss of a node
+func ValidateHANodeCount(nodeCount int) error {
+	if nodeCount < 3 {
+		return fmt.Errorf("an HA control plane needs at least 3 nodes, got %d", nodeCount)
+	}
+	if nodeCount%2 == 0 {
+		return fmt.Errorf("an HA control plane needs an odd number of nodes for etcd quorum, got %d", nodeCount)
+	}
+
+	return nil
+}
+
+// Quorum returns the number of control plane members that must be Ready for
+// etcd to accept writes
+func Quorum(nodeCount int) int {
+	return nodeCount/2 + 1
+}
+
+// CheckHAHosts fails when the Harvester cluster has fewer schedulable hosts
+// than nodeCount, since control plane members are spread one per host
+func (c *Client) CheckHAHosts(ctx context.Context, nodeCount int) error {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list harvester hosts: %w", err)
+	}
+
+	available := 0
+	for _, node := range nodes.Items {
+		if !node.Spec.Unschedulable && isNodeReady(&node) {
+			available++
+		}
+	}
+
+	if available < nodeCount {
+		return fmt.Errorf("an HA control plane of %d nodes needs %d Harvester hosts, but only %d are ready and schedulable", nodeCount, nodeCount, available)
+	}
+
+	return nil
+}
+
+// ReadyControlPlaneNodes returns how many control plane nodes are Ready
+func (c *Client) ReadyControlPlaneNodes(ctx context.Context) (int, error) {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: controlPlaneLabel})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list control plane nodes: %w", err)
+	}
+
+	ready := 0
+	for _, node := range nodes.Items {
+		if isNodeReady(&node) {
+			ready++
+		}
+	}
+
+	return ready, nil
+}
+
+// WaitForControlPlaneQuorum blocks until a quorum of the nodeCount control
+// plane members is Ready or ctx is done
+func (c *Client) WaitForControlPlaneQuorum(ctx context.Context, nodeCount int) error {
+	quorum := Quorum(nodeCount)
+
+	ticker := time.NewTicker(nodePollInterval)
+	defer ticker.Stop()
+
+	for {
+		ready, err := c.ReadyControlPlaneNodes(ctx)
+		if err != nil {
+			return err
+		}
+		if ready >= quorum {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("only %d of the %d control plane nodes needed for quorum are Ready: %w", ready, quorum, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newNode(name string, ready, controlPlane bool) *corev1.Node {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{}}}
+	if controlPlane {
+		node.Labels[controlPlaneLabel] = "true"
+	}
+
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+
+	return node
+}
+
+func TestValidateHANodeCount(t *testing.T) {
+	require.NoError(t, ValidateHANodeCount(3))
+	require.NoError(t, ValidateHANodeCount(5))
+	require.ErrorContains(t, ValidateHANodeCount(1), "at least 3")
+	require.ErrorContains(t, ValidateHANodeCount(4), "odd number")
+}
+
+func TestCheckHAHosts(t *testing.T) {
+	unschedulable := newNode("host-3", true, false)
+	unschedulable.Spec.Unschedulable = true
+
+	client := &Client{Clientset: fake.NewSimpleClientset(
+		newNode("host-1", true, true),
+		newNode("host-2", true, false),
+		unschedulable,
+		newNode("host-4", false, false),
+	)}
+
+	require.NoError(t, client.CheckHAHosts(context.Background(), 1))
+
+	err := client.CheckHAHosts(context.Background(), 3)
+	require.ErrorContains(t, err, "only 2 are ready and schedulable")
+}
+
+func TestWaitForControlPlaneQuorum(t *testing.T) {
+	objects := []runtime.Object{
+		newNode("cp-1", true, true),
+		newNode("cp-2", true, true),
+		newNode("cp-3", false, true),
+		newNode("worker-1", true, false),
+	}
+	client := &Client{Clientset: fake.NewSimpleClientset(objects...)}
+
+	t.Run("returns once a quorum is ready", func(t *testing.T) {
+		require.NoError(t, client.WaitForControlPlaneQuorum(context.Background(), 3))
+	})
+
+	t.Run("fails when the context ends first", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := client.WaitForControlPlaneQuorum(ctx, 5)
+		require.ErrorIs(t, err, context.Canceled)
+		assert.ErrorContains(t, err, "only 2 of the 3")
+	})
+}
@@ -0,0 +1,195 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DefaultDegradedGracePeriod = 5 * time.Minute
+	DefaultMaxHealthFlaps      = 5
+)
+
+// StuckApplicationError describes an ArgoCD application that is not going to
+// converge on its own
+type StuckApplicationError struct {
+	Application        string
+	Reason             string
+	DegradedResources  []string
+	TerminationReasons []string
+}
+
+func (e *StuckApplicationError) Error() string {
+	msg := fmt.Sprintf("argocd application %q is stuck: %s", e.Application, e.Reason)
+	if len(e.DegradedResources) > 0 {
+		msg += fmt.Sprintf("; degraded resources: %s", strings.Join(e.DegradedResources, ", "))
+	}
+	if len(e.TerminationReasons) > 0 {
+		msg += fmt.Sprintf("; containers: %s", strings.Join(e.TerminationReasons, ", "))
+	}
+	return msg
+}
+
+type appHealth struct {
+	status        health.HealthStatusCode
+	degradedSince time.Time
+	flaps         int
+}
+
+// HealthTracker follows the health transitions of every ArgoCD application
+// across polls so that crash-looping or flapping applications fail fast
+// instead of burning the whole provisioning timeout
+type HealthTracker struct {
+	client       *Client
+	gracePeriod  time.Duration
+	maxFlaps     int
+	onTransition func(message string)
+	onProgress   func(ready, total int)
+	apps         map[string]*appHealth
+	now          func() time.Time
+}
+
+// NewHealthTracker creates a HealthTracker. onTransition, when set, is called
+// with a human readable message every time an application changes health
+func NewHealthTracker(client *Client, gracePeriod time.Duration, maxFlaps int, onTransition func(message string)) *HealthTracker {
+	return &HealthTracker{
+		client:       client,
+		gracePeriod:  gracePeriod,
+		maxFlaps:     maxFlaps,
+		onTransition: onTransition,
+		apps:         map[string]*appHealth{},
+		now:          time.Now,
+	}
+}
+
+// SetProgressFunc makes Check call fn with how many of the applications are
+// Healthy/Synced
+func (h *HealthTracker) SetProgressFunc(fn func(ready, total int)) {
+	h.onProgress = fn
+}
+
+// Check observes the current health of every ArgoCD application and returns
+// a *StuckApplicationError for the first one that has been Degraded longer
+// than the grace period or has flapped into Degraded too often. ArgoCD not
+// being installed yet is not an error.
+func (h *HealthTracker) Check(ctx context.Context) error {
+	apps, err := h.client.ListApplications(ctx, "*")
+	if err != nil {
+		if errors.Is(err, ErrArgoCDUnreachable) || errors.Is(err, ErrApplicationNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to check argocd application health: %w", err)
+	}
+
+	now := h.now()
+
+	if h.onProgress != nil && len(apps) > 0 {
+		ready := 0
+		for i := range apps {
+			if IsApplicationReady(&apps[i]) {
+				ready++
+			}
+		}
+		h.onProgress(ready, len(apps))
+	}
+
+	for i := range apps {
+		app := &apps[i]
+		current := app.Status.Health.Status
+
+		tracked, ok := h.apps[app.Name]
+		if !ok {
+			tracked = &appHealth{status: current}
+			if current == health.HealthStatusDegraded {
+				tracked.degradedSince = now
+			}
+			h.apps[app.Name] = tracked
+			continue
+		}
+
+		if tracked.status != current {
+			if h.onTransition != nil {
+				h.onTransition(fmt.Sprintf("%s: %s -> %s", app.Name, tracked.status, current))
+			}
+
+			if current == health.HealthStatusDegraded {
+				tracked.degradedSince = now
+				tracked.flaps++
+			}
+			tracked.status = current
+		}
+
+		var reason string
+		switch {
+		case current == health.HealthStatusDegraded && now.Sub(tracked.degradedSince) > h.gracePeriod:
+			reason = fmt.Sprintf("degraded for more than %s", h.gracePeriod)
+		case h.maxFlaps > 0 && tracked.flaps > h.maxFlaps:
+			reason = fmt.Sprintf("became degraded %d times", tracked.flaps)
+		default:
+			continue
+		}
+
+		return h.diagnose(ctx, app, reason)
+	}
+
+	return nil
+}
+
+// diagnose collects the degraded resources of app and the termination
+// reasons of unhealthy containers in their namespaces
+func (h *HealthTracker) diagnose(ctx context.Context, app *v1alpha1.Application, reason string) error {
+	stuck := &StuckApplicationError{Application: app.Name, Reason: reason}
+
+	namespaces := map[string]bool{}
+	for _, resource := range app.Status.Resources {
+		if resource.Health == nil || resource.Health.Status != health.HealthStatusDegraded {
+			continue
+		}
+
+		stuck.DegradedResources = append(stuck.DegradedResources, fmt.Sprintf("%s %s/%s", resource.Kind, resource.Namespace, resource.Name))
+		if resource.Namespace != "" {
+			namespaces[resource.Namespace] = true
+		}
+	}
+
+	if h.client.Clientset == nil {
+		return stuck
+	}
+
+	for namespace := range namespaces {
+		pods, err := h.client.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			continue
+		}
+
+		for _, pod := range pods.Items {
+			for _, status := range pod.Status.ContainerStatuses {
+				switch {
+				case status.State.Waiting != nil && status.State.Waiting.Reason != "":
+					stuck.TerminationReasons = append(stuck.TerminationReasons, fmt.Sprintf("%s/%s %s", pod.Name, status.Name, status.State.Waiting.Reason))
+				case status.LastTerminationState.Terminated != nil:
+					terminated := status.LastTerminationState.Terminated
+					stuck.TerminationReasons = append(stuck.TerminationReasons, fmt.Sprintf("%s/%s %s (exit code %d)", pod.Name, status.Name, terminated.Reason, terminated.ExitCode))
+				}
+			}
+		}
+	}
+
+	sort.Strings(stuck.TerminationReasons)
+
+	return stuck
+}
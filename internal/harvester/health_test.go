@@ -0,0 +1,108 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func setApplicationHealth(t *testing.T, client *Client, name string, status health.HealthStatusCode) {
+	t.Helper()
+
+	apps := client.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+	app, err := apps.Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(t, err)
+
+	app.Status.Health.Status = status
+	_, err = apps.Update(context.Background(), app, metav1.UpdateOptions{})
+	require.NoError(t, err)
+}
+
+func TestHealthTracker(t *testing.T) {
+	t.Run("fails once an application stays degraded past the grace period", func(t *testing.T) {
+		app := newApplication("vault", v1alpha1.SyncStatusCodeSynced, health.HealthStatusDegraded)
+		app.Status.Resources = []v1alpha1.ResourceStatus{{
+			Kind:      "StatefulSet",
+			Namespace: "vault",
+			Name:      "vault",
+			Health:    &v1alpha1.HealthStatus{Status: health.HealthStatusDegraded},
+		}}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vault-0", Namespace: "vault"},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "vault",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+			}}},
+		}
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(app), Clientset: fake.NewSimpleClientset(pod)}
+
+		now := time.Now()
+		tracker := NewHealthTracker(client, time.Minute, DefaultMaxHealthFlaps, nil)
+		tracker.now = func() time.Time { return now }
+
+		require.NoError(t, tracker.Check(context.Background()))
+
+		now = now.Add(2 * time.Minute)
+		err := tracker.Check(context.Background())
+
+		var stuck *StuckApplicationError
+		require.True(t, errors.As(err, &stuck))
+		assert.Equal(t, "vault", stuck.Application)
+		assert.Equal(t, []string{"StatefulSet vault/vault"}, stuck.DegradedResources)
+		assert.Equal(t, []string{"vault-0/vault CrashLoopBackOff"}, stuck.TerminationReasons)
+	})
+
+	t.Run("fails once an application flaps too often", func(t *testing.T) {
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+			newApplication("kgateway", v1alpha1.SyncStatusCodeSynced, health.HealthStatusProgressing),
+		)}
+
+		transitions := []string{}
+		tracker := NewHealthTracker(client, time.Hour, 1, func(message string) { transitions = append(transitions, message) })
+
+		require.NoError(t, tracker.Check(context.Background()))
+
+		setApplicationHealth(t, client, "kgateway", health.HealthStatusDegraded)
+		require.NoError(t, tracker.Check(context.Background()))
+
+		setApplicationHealth(t, client, "kgateway", health.HealthStatusProgressing)
+		require.NoError(t, tracker.Check(context.Background()))
+
+		setApplicationHealth(t, client, "kgateway", health.HealthStatusDegraded)
+		err := tracker.Check(context.Background())
+		require.ErrorContains(t, err, "became degraded 2 times")
+		assert.Len(t, transitions, 3)
+	})
+
+	t.Run("reports the applications Healthy/Synced", func(t *testing.T) {
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+			newApplication("argocd", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy),
+			newApplication("vault", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusProgressing),
+		)}
+		tracker := NewHealthTracker(client, time.Minute, DefaultMaxHealthFlaps, nil)
+
+		var ready, total int
+		tracker.SetProgressFunc(func(r, t int) { ready, total = r, t })
+
+		require.NoError(t, tracker.Check(context.Background()))
+		assert.Equal(t, 1, ready)
+		assert.Equal(t, 2, total)
+	})
+
+	t.Run("ignores a missing argocd", func(t *testing.T) {
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset()}
+		tracker := NewHealthTracker(client, time.Minute, DefaultMaxHealthFlaps, nil)
+
+		require.NoError(t, tracker.Check(context.Background()))
+	})
+}
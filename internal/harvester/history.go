@@ -0,0 +1,150 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// GitopsHistorySecretName is the state secret recording the gitops
+	// commit every kubefirst operation produced
+	GitopsHistorySecretName      = "kubefirst-gitops-history"
+	GitopsHistorySecretNamespace = "kubefirst"
+	gitopsHistoryKey             = "history.json"
+
+	// RollbackPrevious selects the commit recorded before the latest one
+	RollbackPrevious = "previous"
+
+	minSHAPrefix = 7
+)
+
+// ErrIrreversible is returned when a rollback would cross an operation that
+// cannot be undone by reverting the gitops repository
+var ErrIrreversible = errors.New("rollback crosses an irreversible operation")
+
+// GitopsRecord is the gitops commit an operation produced. Irreversible
+// explains why the cluster cannot be rolled back past the operation, e.g. a
+// Vault storage layout migration, and is empty for ordinary operations
+type GitopsRecord struct {
+	Operation    string    `json:"operation"`
+	SHA          string    `json:"sha"`
+	Time         time.Time `json:"time"`
+	Irreversible string    `json:"irreversible,omitempty"`
+	// Changes lists the logical changes of a commit batching several
+	Changes []string `json:"changes,omitempty"`
+}
+
+// GitopsHistory lists records oldest first
+type GitopsHistory []GitopsRecord
+
+// Append adds record unless it repeats the SHA of the latest record, which
+// happens when an operation had nothing to commit
+func (h GitopsHistory) Append(record GitopsRecord) GitopsHistory {
+	if len(h) > 0 && h[len(h)-1].SHA == record.SHA && record.Irreversible == "" {
+		return h
+	}
+
+	return append(h, record)
+}
+
+// RollbackTarget resolves to, either RollbackPrevious or a SHA of at least
+// seven characters, against the recorded history. It refuses targets older
+// than an irreversible operation
+func (h GitopsHistory) RollbackTarget(to string) (GitopsRecord, error) {
+	if len(h) == 0 {
+		return GitopsRecord{}, errors.New("no gitops history recorded for this cluster")
+	}
+
+	index := -1
+	switch {
+	case to == RollbackPrevious:
+		if len(h) < 2 {
+			return GitopsRecord{}, fmt.Errorf("only one gitops commit recorded (%s by %s), nothing to roll back to", shortSHA(h[0].SHA), h[0].Operation)
+		}
+		index = len(h) - 2
+	case len(to) < minSHAPrefix:
+		return GitopsRecord{}, fmt.Errorf("commit %q is too short, use %q or at least %d characters of a recorded SHA", to, RollbackPrevious, minSHAPrefix)
+	default:
+		for i := len(h) - 1; i >= 0; i-- {
+			if strings.HasPrefix(h[i].SHA, strings.ToLower(to)) {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return GitopsRecord{}, fmt.Errorf("commit %q is not in the recorded gitops history", to)
+		}
+	}
+
+	for _, record := range h[index+1:] {
+		if record.Irreversible != "" {
+			return GitopsRecord{}, fmt.Errorf("%w: %s at %s cannot be undone: %s", ErrIrreversible, record.Operation, shortSHA(record.SHA), record.Irreversible)
+		}
+	}
+
+	return h[index], nil
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > minSHAPrefix {
+		return sha[:minSHAPrefix]
+	}
+
+	return sha
+}
+
+// LoadGitopsHistory reads the history from the state secret, returning an
+// empty history when none was recorded yet
+func (c *Client) LoadGitopsHistory(ctx context.Context) (GitopsHistory, error) {
+	secret, err := c.Clientset.CoreV1().Secrets(GitopsHistorySecretNamespace).Get(ctx, GitopsHistorySecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", GitopsHistorySecretNamespace, GitopsHistorySecretName, err)
+	}
+
+	var history GitopsHistory
+	if data := secret.Data[gitopsHistoryKey]; len(data) > 0 {
+		if err := json.Unmarshal(data, &history); err != nil {
+			return nil, fmt.Errorf("failed to parse %s in secret %s/%s: %w", gitopsHistoryKey, GitopsHistorySecretNamespace, GitopsHistorySecretName, err)
+		}
+	}
+
+	return history, nil
+}
+
+// SaveGitopsHistory writes history to the state secret
+func (c *Client) SaveGitopsHistory(ctx context.Context, history GitopsHistory) error {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to encode gitops history: %w", err)
+	}
+
+	secret := corev1apply.Secret(GitopsHistorySecretName, GitopsHistorySecretNamespace).
+		WithData(map[string][]byte{gitopsHistoryKey: data})
+
+	_, err = c.Clientset.CoreV1().Secrets(GitopsHistorySecretNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", GitopsHistorySecretNamespace, GitopsHistorySecretName, err)
+	}
+
+	return nil
+}
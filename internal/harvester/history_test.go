@@ -0,0 +1,77 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGitopsHistoryAppend(t *testing.T) {
+	history := GitopsHistory{}.
+		Append(GitopsRecord{Operation: "provision", SHA: "aaaaaaaa"}).
+		Append(GitopsRecord{Operation: "lb-pool", SHA: "aaaaaaaa"}).
+		Append(GitopsRecord{Operation: "sso", SHA: "bbbbbbbb"})
+
+	require.Len(t, history, 2)
+	assert.Equal(t, "provision", history[0].Operation)
+	assert.Equal(t, "sso", history[1].Operation)
+}
+
+func TestRollbackTarget(t *testing.T) {
+	history := GitopsHistory{
+		{Operation: "provision", SHA: "1111111111111111111111111111111111111111"},
+		{Operation: "vault-migration", SHA: "2222222222222222222222222222222222222222", Irreversible: "vault storage layout changed"},
+		{Operation: "lb-pool", SHA: "3333333333333333333333333333333333333333"},
+		{Operation: "sso", SHA: "4444444444444444444444444444444444444444"},
+	}
+
+	tests := []struct {
+		name    string
+		history GitopsHistory
+		to      string
+		want    string
+		wantErr string
+	}{
+		{name: "previous", history: history, to: RollbackPrevious, want: "lb-pool"},
+		{name: "sha prefix", history: history, to: "2222222", want: "vault-migration"},
+		{name: "across irreversible", history: history, to: "1111111", wantErr: "vault-migration at 2222222 cannot be undone: vault storage layout changed"},
+		{name: "short sha", history: history, to: "333", wantErr: "too short"},
+		{name: "unknown sha", history: history, to: "5555555", wantErr: "not in the recorded gitops history"},
+		{name: "single record", history: history[:1], to: RollbackPrevious, wantErr: "nothing to roll back to"},
+		{name: "empty", to: RollbackPrevious, wantErr: "no gitops history"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			record, err := tt.history.RollbackTarget(tt.to)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, record.Operation)
+		})
+	}
+
+	_, err := history.RollbackTarget("1111111")
+	require.ErrorIs(t, err, ErrIrreversible)
+}
+
+func TestGitopsHistoryRoundTrip(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset()}
+
+	history, err := client.LoadGitopsHistory(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	recorded := GitopsHistory{{Operation: "provision", SHA: "aaaaaaaa", Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}}
+	require.NoError(t, client.SaveGitopsHistory(context.Background(), recorded))
+
+	history, err = client.LoadGitopsHistory(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, recorded, history)
+}
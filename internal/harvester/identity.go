@@ -0,0 +1,81 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// IdentityFlags are the create flags naming the cluster, its domain, where
+// its alerts go and the gitops repository it starts from
+type IdentityFlags struct {
+	ClusterName string
+	DomainName  string
+	AlertsEmail string
+	GitProvider string
+	// Owners holds the --github-org and --gitlab-group values
+	Owners map[string]string
+	// GitopsTemplateURL is left empty when the gitops template is not
+	// cloned from it
+	GitopsTemplateURL string
+	// Provenance describes where the value of every flag came from, as
+	// FlagProvenances does, for the errors to name it
+	Provenance map[string]string
+}
+
+// Normalize trims the flags of f and strips the trailing dot of the domain
+// name, returning a warning for every change the user should know of
+func (f *IdentityFlags) Normalize() []string {
+	f.ClusterName = strings.TrimSpace(f.ClusterName)
+	f.AlertsEmail = strings.TrimSpace(f.AlertsEmail)
+	f.GitopsTemplateURL = strings.TrimSpace(f.GitopsTemplateURL)
+
+	var warnings []string
+	domain := strings.TrimSpace(f.DomainName)
+	if trimmed := strings.TrimSuffix(domain, "."); trimmed != domain && trimmed != "" {
+		warnings = append(warnings, fmt.Sprintf("--domain-name %q ends with a dot, using %s", domain, trimmed))
+		domain = trimmed
+	}
+	f.DomainName = domain
+
+	return warnings
+}
+
+// Validate returns every violation of the flags of f, joined, or nil. The
+// owner flags of other git providers are rejected by CreateFlagConstraints
+func (f IdentityFlags) Validate() error {
+	var errs []error
+	if err := ValidateClusterName(f.ClusterName); err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s: %w", describeFlag(f.Provenance, "cluster-name"), err))
+	}
+
+	if f.DomainName == "" {
+		errs = append(errs, errors.New(`required flag "domain-name" not set`))
+	} else if err := ValidateDomainName(f.DomainName); err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s: %w", describeFlag(f.Provenance, "domain-name"), err))
+	}
+
+	if f.AlertsEmail == "" {
+		errs = append(errs, errors.New(`required flag "alerts-email" not set`))
+	} else if _, err := ParseAlertsEmails(f.AlertsEmail); err != nil {
+		errs = append(errs, fmt.Errorf("invalid %s: %w", describeFlag(f.Provenance, "alerts-email"), err))
+	}
+
+	if err := ValidateGitOwner(f.GitProvider, f.Owners); err != nil {
+		errs = append(errs, err)
+	}
+
+	if f.GitopsTemplateURL != "" {
+		if err := ValidateGitopsTemplateURL(f.GitopsTemplateURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %w", describeFlag(f.Provenance, "gitops-template-url"), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,52 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityFlags(t *testing.T) {
+	t.Run("normalizes the flags", func(t *testing.T) {
+		flags := IdentityFlags{
+			ClusterName:       " kubefirst ",
+			DomainName:        "example.com.",
+			AlertsEmail:       "ops@example.com ",
+			GitProvider:       "github",
+			Owners:            map[string]string{"github-org": "holybitsllc"},
+			GitopsTemplateURL: "https://github.com/konstructio/gitops-template.git",
+		}
+
+		assert.Equal(t, []string{`--domain-name "example.com." ends with a dot, using example.com`}, flags.Normalize())
+		assert.Equal(t, "kubefirst", flags.ClusterName)
+		assert.Equal(t, "example.com", flags.DomainName)
+		assert.Equal(t, "ops@example.com", flags.AlertsEmail)
+		require.NoError(t, flags.Validate())
+		assert.Empty(t, flags.Normalize())
+	})
+
+	t.Run("reports every violation together", func(t *testing.T) {
+		flags := IdentityFlags{
+			ClusterName:       "My Cluster",
+			DomainName:        "https://example.com",
+			AlertsEmail:       "ops",
+			GitProvider:       "gitlab",
+			Owners:            map[string]string{"github-org": "holybitsllc"},
+			GitopsTemplateURL: "github.com/konstructio/gitops-template",
+		}
+		flags.Normalize()
+
+		require.EqualError(t, flags.Validate(), `invalid --cluster-name: "My Cluster" contains uppercase 'M' at position 1, only lowercase letters are allowed, try "my-cluster"
+invalid --domain-name: "https://example.com" has a https:// scheme, pass the domain alone such as "example.com"
+invalid --alerts-email: "ops" is not a valid email address
+--git-provider gitlab requires --gitlab-group, the owner of the new gitops repository
+invalid --gitops-template-url: "github.com/konstructio/gitops-template" has no scheme, an https or git url such as https://github.com/konstructio/gitops-template.git is expected`)
+	})
+
+	t.Run("requires the domain name and alerts email", func(t *testing.T) {
+		flags := IdentityFlags{ClusterName: "kubefirst", GitProvider: "github", Owners: map[string]string{"github-org": "holybitsllc"}}
+
+		require.EqualError(t, flags.Validate(), "required flag \"domain-name\" not set\nrequired flag \"alerts-email\" not set")
+	})
+}
@@ -0,0 +1,199 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DomainsGatewayName is the Gateway in the host cluster terminating TLS for
+// the --extra-domains and the domains of --vcluster-domain-map, one HTTPS
+// listener named after each domain
+const DomainsGatewayName = "kubefirst-domains"
+
+// IngressDomain is a domain served by a listener of the domains gateway.
+// The listener of a domain VCluster is mapped to only accepts routes from
+// its host namespace, the one of an extra domain from any namespace
+type IngressDomain struct {
+	Domain   string
+	VCluster string
+}
+
+// IngressDomains returns the domains of Domains besides domainName, which
+// the platform ingresses serve, with the vcluster of vclusters each is
+// mapped to in domainMap, if any. Mapped vclusters missing from vclusters
+// are left out
+func IngressDomains(domainName string, extraDomains, vclusters []string, domainMap map[string]string) []IngressDomain {
+	mapped := map[string]string{}
+	owners := map[string]string{}
+	for _, vcluster := range vclusters {
+		if domain, ok := domainMap[vcluster]; ok {
+			mapped[vcluster] = domain
+			owners[strings.ToLower(strings.TrimSpace(domain))] = vcluster
+		}
+	}
+
+	primary := strings.ToLower(strings.TrimSpace(domainName))
+	var domains []IngressDomain
+	for _, domain := range Domains(domainName, extraDomains, mapped) {
+		if domain != primary {
+			domains = append(domains, IngressDomain{Domain: domain, VCluster: owners[domain]})
+		}
+	}
+
+	return domains
+}
+
+// IngressDomainHosts returns the domain of every ingress domain
+func IngressDomainHosts(domains []IngressDomain) []string {
+	hosts := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		hosts = append(hosts, domain.Domain)
+	}
+
+	return hosts
+}
+
+func domainCertificateName(domain string) string {
+	return strings.ReplaceAll(domain, ".", "-") + "-tls"
+}
+
+// DomainCertificate renders the cert-manager Certificate backing the
+// listener of domain on the domains gateway
+func DomainCertificate(domain IngressDomain) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":      domainCertificateName(domain.Domain),
+			"namespace": WildcardGatewayNamespace,
+		},
+		"spec": map[string]interface{}{
+			"secretName": domainCertificateName(domain.Domain),
+			"dnsNames":   []interface{}{domain.Domain},
+			"issuerRef": map[string]interface{}{
+				"kind": "ClusterIssuer",
+				"name": WildcardClusterIssuer,
+			},
+		},
+	}}
+}
+
+// DomainsGateway renders the Gateway with one HTTPS listener per domain
+func DomainsGateway(domains []IngressDomain) *unstructured.Unstructured {
+	listeners := make([]interface{}, 0, len(domains))
+	for _, domain := range domains {
+		namespaces := map[string]interface{}{"from": "All"}
+		if domain.VCluster != "" {
+			namespaces = map[string]interface{}{
+				"from": "Selector",
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						"kubernetes.io/metadata.name": VClusterNamespace(domain.VCluster),
+					},
+				},
+			}
+		}
+
+		listeners = append(listeners, map[string]interface{}{
+			"name":     domain.Domain,
+			"hostname": domain.Domain,
+			"port":     int64(443),
+			"protocol": "HTTPS",
+			"tls": map[string]interface{}{
+				"mode": "Terminate",
+				"certificateRefs": []interface{}{
+					map[string]interface{}{"name": domainCertificateName(domain.Domain)},
+				},
+			},
+			"allowedRoutes": map[string]interface{}{"namespaces": namespaces},
+		})
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata": map[string]interface{}{
+			"name":      DomainsGatewayName,
+			"namespace": WildcardGatewayNamespace,
+			"annotations": map[string]interface{}{
+				// picked up by external-dns to publish the domain records
+				"external-dns.alpha.kubernetes.io/hostname": strings.Join(IngressDomainHosts(domains), ","),
+			},
+		},
+		"spec": map[string]interface{}{
+			"gatewayClassName": WildcardGatewayClass,
+			"listeners":        listeners,
+		},
+	}}
+}
+
+// ApplyIngressDomains creates the certificate of every domain and the
+// domains gateway serving them, then waits for the gateway to be given a
+// load balancer address for their DNS records to point at
+func (c *Client) ApplyIngressDomains(ctx context.Context, domains []IngressDomain) error {
+	for _, domain := range domains {
+		if err := c.applyObject(ctx, certificateGVR, DomainCertificate(domain)); err != nil {
+			return fmt.Errorf("failed to create certificate for domain %q: %w", domain.Domain, err)
+		}
+	}
+
+	if err := c.applyObject(ctx, gatewayGVR, DomainsGateway(domains)); err != nil {
+		return fmt.Errorf("failed to create domains gateway: %w", err)
+	}
+
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := c.LoadBalancerAddress(ctx, WildcardGatewayNamespace, DomainsGatewayName)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the domains gateway has no load balancer address: %v: %w", err, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// domainsGatewayHosts returns the hostnames the listeners of the domains
+// gateway serve, none when it does not exist
+func (c *Client) domainsGatewayHosts(ctx context.Context) ([]string, error) {
+	gateway, err := c.Dynamic.Resource(gatewayGVR).Namespace(WildcardGatewayNamespace).Get(ctx, DomainsGatewayName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read domains gateway: %w", err)
+	}
+
+	listeners, _, err := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the listeners of the domains gateway: %w", err)
+	}
+
+	var hosts []string
+	for _, listener := range listeners {
+		if listener, ok := listener.(map[string]interface{}); ok {
+			if host, ok := listener["hostname"].(string); ok {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return hosts, nil
+}
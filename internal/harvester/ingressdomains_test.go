@@ -0,0 +1,67 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestIngressDomains(t *testing.T) {
+	domains := IngressDomains(
+		"example.com",
+		[]string{"Example.org", "example.com"},
+		[]string{"dev", "prod"},
+		map[string]string{"dev": "dev.example.org", "prod": "example.com", "staging": "staging.example.org"},
+	)
+
+	assert.Equal(t, []IngressDomain{{Domain: "dev.example.org", VCluster: "dev"}, {Domain: "example.org"}}, domains)
+}
+
+func TestPlatformHosts(t *testing.T) {
+	domainMap := map[string]string{"prod": "apps.example.org"}
+
+	t.Run("mapped vcluster domain without wildcard", func(t *testing.T) {
+		hosts := PlatformHosts("example.com", true, []string{"dev", "prod"}, domainMap, nil, false)
+		assert.Equal(t, []string{"argocd.example.com", "vault.example.com", "apps.example.org"}, hosts)
+	})
+
+	t.Run("extra domains and wildcards", func(t *testing.T) {
+		hosts := PlatformHosts("example.com", false, []string{"dev", "prod"}, domainMap, []string{"example.net"}, true)
+		assert.Equal(t, []string{"argocd.example.com", "apps.example.org", "example.net", "*.dev.example.com", "*.apps.example.org"}, hosts)
+	})
+
+	t.Run("vclusters not provisioned", func(t *testing.T) {
+		hosts := PlatformHosts("example.com", false, nil, domainMap, nil, false)
+		assert.Equal(t, []string{"argocd.example.com"}, hosts)
+	})
+}
+
+func TestDomainsGateway(t *testing.T) {
+	gateway := DomainsGateway([]IngressDomain{{Domain: "apps.example.org", VCluster: "prod"}, {Domain: "example.net"}})
+
+	listeners, found, err := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Len(t, listeners, 2)
+
+	prod := listeners[0].(map[string]interface{})
+	assert.Equal(t, "apps.example.org", prod["hostname"])
+	namespace, _, _ := unstructured.NestedString(prod, "allowedRoutes", "namespaces", "selector", "matchLabels", "kubernetes.io/metadata.name")
+	assert.Equal(t, "vcluster-prod", namespace)
+	secret, _, _ := unstructured.NestedSlice(prod, "tls", "certificateRefs")
+	assert.Equal(t, "apps-example-org-tls", secret[0].(map[string]interface{})["name"])
+
+	extra := listeners[1].(map[string]interface{})
+	from, _, _ := unstructured.NestedString(extra, "allowedRoutes", "namespaces", "from")
+	assert.Equal(t, "All", from)
+
+	assert.Equal(t, "apps.example.org,example.net", gateway.GetAnnotations()["external-dns.alpha.kubernetes.io/hostname"])
+
+	cert := DomainCertificate(IngressDomain{Domain: "example.net"})
+	dnsNames, _, err := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.net"}, dnsNames)
+	assert.Equal(t, "example-net-tls", cert.GetName())
+}
@@ -0,0 +1,156 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// InterruptedExitCode is the exit code of a run stopped by Ctrl-C, the one
+// shells report for a process ended by SIGINT
+const InterruptedExitCode = 130
+
+// ResumeInterrupted is the --resume-from value continuing the create an
+// interrupt stopped, from wherever it stopped
+const ResumeInterrupted = "interrupted"
+
+// ErrInterrupted is the cause of the context of a run being canceled at
+// the step boundary following an interrupt
+var ErrInterrupted = errors.New("interrupted")
+
+// InterruptedError is returned by a run an interrupt stopped before Step.
+// Err is the error the run stopped with
+type InterruptedError struct {
+	Step string
+	Err  error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("interrupted before step %q", e.Step)
+}
+
+func (e *InterruptedError) Unwrap() []error {
+	return []error{ErrInterrupted, e.Err}
+}
+
+// ExitCode is the exit code of the command returning e
+func (e *InterruptedError) ExitCode() int {
+	return InterruptedExitCode
+}
+
+// InterruptState records the create an interrupt stopped, for create
+// --resume-from interrupted and destroy to pick it up
+type InterruptState struct {
+	// Step is the step the run stopped before, in Phase
+	Step  string `json:"step"`
+	Phase string `json:"phase,omitempty"`
+	// Resources are the external resources the run had already created
+	Resources []string  `json:"resources,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ParseInterruptState parses the recorded state of an interrupted create,
+// an empty value being none
+func ParseInterruptState(value string) (InterruptState, bool, error) {
+	if value == "" {
+		return InterruptState{}, false, nil
+	}
+
+	var state InterruptState
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		return InterruptState{}, false, fmt.Errorf("invalid interrupt state: %w", err)
+	}
+
+	return state, true, nil
+}
+
+// String encodes the state for the kubefirst config
+func (s InterruptState) String() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// Interrupt stops a run at the first step boundary after an interrupt,
+// letting the step running finish, and exits at once on a second one
+type Interrupt struct {
+	mu        sync.Mutex
+	step      string
+	requested bool
+	stopped   bool
+	cancel    context.CancelCauseFunc
+
+	// Notify reports the interrupt and what happens next
+	Notify func(message string)
+	// Exit ends the process on a second interrupt
+	Exit func(code int)
+}
+
+// NewInterrupt returns an Interrupt and the context of the run it stops,
+// canceled with ErrInterrupted at the boundary
+func NewInterrupt(ctx context.Context) (*Interrupt, context.Context) {
+	ctx, cancel := context.WithCancelCause(ctx)
+
+	return &Interrupt{cancel: cancel, Notify: func(string) {}, Exit: os.Exit}, ctx
+}
+
+// Watch handles the interrupts received on signals until it is closed
+func (i *Interrupt) Watch(signals <-chan os.Signal) {
+	for range signals {
+		i.interrupt()
+	}
+}
+
+func (i *Interrupt) interrupt() {
+	i.mu.Lock()
+	first := !i.requested
+	i.requested = true
+	step := i.step
+	i.mu.Unlock()
+
+	if !first {
+		i.Notify("Interrupted again, exiting now")
+		i.Exit(InterruptedExitCode)
+		return
+	}
+
+	if step == "" {
+		i.Notify("Interrupt received, stopping before the first step…; press Ctrl-C again to exit now")
+		return
+	}
+	i.Notify(fmt.Sprintf("Interrupt received, stopping after current step %q…; press Ctrl-C again to exit now", step))
+}
+
+// Gate is called before each step starts. Once interrupted it cancels the
+// run, stepName being where it stopped
+func (i *Interrupt) Gate(stepName string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.stopped {
+		return
+	}
+	i.step = stepName
+	if i.requested {
+		i.stopped = true
+		i.cancel(ErrInterrupted)
+	}
+}
+
+// Interrupted reports whether the run was interrupted, and the step it
+// stopped before or was running
+func (i *Interrupt) Interrupted() (string, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.step, i.requested
+}
@@ -0,0 +1,70 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterrupt(t *testing.T) {
+	interrupt, ctx := NewInterrupt(context.Background())
+	var messages []string
+	interrupt.Notify = func(message string) { messages = append(messages, message) }
+	exited := 0
+	interrupt.Exit = func(code int) { exited = code }
+
+	signals := make(chan os.Signal)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		interrupt.Watch(signals)
+	}()
+
+	interrupt.Gate("Install Vault")
+	signals <- os.Interrupt
+	require.Eventually(t, func() bool {
+		_, interrupted := interrupt.Interrupted()
+		return interrupted
+	}, time.Second, time.Millisecond)
+	require.NoError(t, ctx.Err(), "the running step finishes")
+
+	interrupt.Gate("Configure Backups")
+	require.ErrorIs(t, context.Cause(ctx), ErrInterrupted)
+	interrupt.Gate("Write Installation Report")
+	step, _ := interrupt.Interrupted()
+	assert.Equal(t, "Configure Backups", step)
+
+	signals <- os.Interrupt
+	close(signals)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("interrupts not handled")
+	}
+	assert.Equal(t, InterruptedExitCode, exited)
+	assert.Equal(t, []string{`Interrupt received, stopping after current step "Install Vault"…; press Ctrl-C again to exit now`, "Interrupted again, exiting now"}, messages)
+}
+
+func TestInterruptState(t *testing.T) {
+	_, found, err := ParseInterruptState("")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	recorded := InterruptState{Step: "Configure Backups", Resources: []string{"the ArgoCD deploy key 7"}, Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	state, found, err := ParseInterruptState(recorded.String())
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, recorded, state)
+
+	err = error(&InterruptedError{Step: "Configure Backups", Err: context.Canceled})
+	assert.ErrorIs(t, err, ErrInterrupted)
+	assert.ErrorIs(t, err, context.Canceled)
+	var exitCoder interface{ ExitCode() int }
+	require.True(t, errors.As(err, &exitCoder))
+	assert.Equal(t, InterruptedExitCode, exitCoder.ExitCode())
+}
@@ -0,0 +1,180 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	istioDataplaneModeLabel = "istio.io/dataplane-mode"
+	istioDataplaneAmbient   = "ambient"
+
+	// LatestIstioVersion resolves to the newest Istio release supporting
+	// the Kubernetes version of the cluster
+	LatestIstioVersion = "latest"
+)
+
+// IstioRelease is an Istio minor release, its newest patch and the
+// Kubernetes minor versions it supports
+type IstioRelease struct {
+	Version       string
+	MinKubernetes string
+	MaxKubernetes string
+}
+
+// IstioReleases are the supported Istio releases, newest first, per
+// https://istio.io/latest/docs/releases/supported-releases/
+var IstioReleases = []IstioRelease{
+	{Version: "1.26.2", MinKubernetes: "1.29", MaxKubernetes: "1.33"},
+	{Version: "1.25.3", MinKubernetes: "1.29", MaxKubernetes: "1.32"},
+	{Version: "1.24.6", MinKubernetes: "1.28", MaxKubernetes: "1.31"},
+	{Version: "1.23.6", MinKubernetes: "1.27", MaxKubernetes: "1.30"},
+	{Version: "1.22.8", MinKubernetes: "1.27", MaxKubernetes: "1.30"},
+}
+
+// supports reports whether the release supports kubernetes, compared by
+// minor version
+func (r IstioRelease) supports(kubernetes *semver.Version) bool {
+	minor, _ := semver.NewVersion(fmt.Sprintf("%d.%d", kubernetes.Major(), kubernetes.Minor()))
+	return !minor.LessThan(semver.MustParse(r.MinKubernetes)) && !minor.GreaterThan(semver.MustParse(r.MaxKubernetes))
+}
+
+// IstioMatrix describes the Kubernetes versions every release of
+// IstioReleases supports
+func IstioMatrix() string {
+	entries := make([]string, 0, len(IstioReleases))
+	for _, release := range IstioReleases {
+		entries = append(entries, fmt.Sprintf("%s (kubernetes %s-%s)", release.Version, release.MinKubernetes, release.MaxKubernetes))
+	}
+
+	return strings.Join(entries, ", ")
+}
+
+// ResolveIstioVersion returns the Istio version to install on a cluster
+// running kubernetesVersion, e.g. v1.30.5+rke2r1. LatestIstioVersion
+// resolves to the newest release of IstioReleases supporting it, other
+// versions have to be supported by their release. Versions of releases
+// missing from IstioReleases are returned as is, known reports whether the
+// check applied
+func ResolveIstioVersion(version, kubernetesVersion string) (resolved string, known bool, err error) {
+	kubernetes, err := semver.NewVersion(kubernetesVersion)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to parse the kubernetes version %q: %w", kubernetesVersion, err)
+	}
+
+	if version == LatestIstioVersion {
+		for _, release := range IstioReleases {
+			if release.supports(kubernetes) {
+				return release.Version, true, nil
+			}
+		}
+		return "", false, fmt.Errorf("no Istio release supports kubernetes %s, supported releases: %s", kubernetesVersion, IstioMatrix())
+	}
+
+	wanted, err := semver.NewVersion(version)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid Istio version %q: %w", version, err)
+	}
+	for _, release := range IstioReleases {
+		current := semver.MustParse(release.Version)
+		if current.Major() != wanted.Major() || current.Minor() != wanted.Minor() {
+			continue
+		}
+		if !release.supports(kubernetes) {
+			return "", true, fmt.Errorf("version %s of Istio does not support kubernetes %s, supported releases: %s", version, kubernetesVersion, IstioMatrix())
+		}
+		return version, true, nil
+	}
+
+	return version, false, nil
+}
+
+// ResolveIstioVersion resolves version, like ResolveIstioVersion, against
+// the Kubernetes version of the cluster
+func (c *Client) ResolveIstioVersion(version string) (resolved string, known bool, err error) {
+	serverVersion, err := c.Clientset.Discovery().ServerVersion()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read the kubernetes version: %w", err)
+	}
+
+	return ResolveIstioVersion(version, serverVersion.GitVersion)
+}
+
+// ValidateVClusterIstio ensures every entry in the per-vcluster Istio map
+// references a vcluster that will be created. CreateFlagConstraints
+// requires Istio for the AmbientVClusters
+func ValidateVClusterIstio(vclusters []string, vclusterIstio map[string]bool) error {
+	for _, name := range sortedKeys(vclusterIstio) {
+		if !slices.Contains(vclusters, name) {
+			return fmt.Errorf("vcluster istio entry %q does not match any vcluster in --vclusters %v", name, vclusters)
+		}
+	}
+
+	return nil
+}
+
+// AmbientVClusters returns the vclusters of the per-vcluster Istio map in
+// ambient mode, sorted
+func AmbientVClusters(vclusterIstio map[string]bool) []string {
+	var ambient []string
+	for _, name := range sortedKeys(vclusterIstio) {
+		if vclusterIstio[name] {
+			ambient = append(ambient, name)
+		}
+	}
+
+	return ambient
+}
+
+// ApplyVClusterIstio labels the host namespace of each vcluster in
+// vclusterIstio for Istio ambient mode, or removes the label when ambient
+// mode is disabled. Vclusters that are not in the map are left untouched
+func (c *Client) ApplyVClusterIstio(ctx context.Context, vclusterIstio map[string]bool) error {
+	for _, vcluster := range sortedKeys(vclusterIstio) {
+		var value interface{}
+		if vclusterIstio[vcluster] {
+			value = istioDataplaneAmbient
+		}
+
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]interface{}{istioDataplaneModeLabel: value},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to build namespace patch for vcluster %q: %w", vcluster, err)
+		}
+
+		namespace := VClusterNamespace(vcluster)
+		_, err = c.Clientset.CoreV1().Namespaces().Patch(ctx, namespace, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+		if err != nil {
+			return fmt.Errorf("failed to label namespace %q of vcluster %q: %w", namespace, vcluster, err)
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,85 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestValidateVClusterIstio(t *testing.T) {
+	vclusters := []string{"dev", "test", "prod"}
+
+	t.Run("accepts entries for known vclusters", func(t *testing.T) {
+		err := ValidateVClusterIstio(vclusters, map[string]bool{"dev": false, "prod": true})
+		require.NoError(t, err)
+	})
+
+	t.Run("rejects entries for unknown vclusters", func(t *testing.T) {
+		err := ValidateVClusterIstio(vclusters, map[string]bool{"staging": true})
+		require.ErrorContains(t, err, `"staging"`)
+	})
+
+	t.Run("only ambient vclusters need istio", func(t *testing.T) {
+		assert.Equal(t, []string{"prod", "test"}, AmbientVClusters(map[string]bool{"dev": false, "test": true, "prod": true}))
+		assert.Empty(t, AmbientVClusters(map[string]bool{"dev": false}))
+	})
+}
+
+func TestApplyVClusterIstio(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "vcluster-dev", Labels: map[string]string{istioDataplaneModeLabel: istioDataplaneAmbient}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "vcluster-prod"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "vcluster-test", Labels: map[string]string{istioDataplaneModeLabel: istioDataplaneAmbient}}},
+	)
+	client := &Client{Clientset: clientset}
+
+	err := client.ApplyVClusterIstio(context.Background(), map[string]bool{"dev": false, "prod": true})
+	require.NoError(t, err)
+
+	labels := func(name string) map[string]string {
+		namespace, err := clientset.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return namespace.Labels
+	}
+
+	assert.NotContains(t, labels("vcluster-dev"), istioDataplaneModeLabel)
+	assert.Equal(t, istioDataplaneAmbient, labels("vcluster-prod")[istioDataplaneModeLabel])
+	assert.Equal(t, istioDataplaneAmbient, labels("vcluster-test")[istioDataplaneModeLabel])
+}
+
+func TestResolveIstioVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		version    string
+		kubernetes string
+		want       string
+		wantKnown  bool
+		wantErr    string
+	}{
+		{name: "latest picks the newest release", version: LatestIstioVersion, kubernetes: "v1.33.1+rke2r1", want: "1.26.2", wantKnown: true},
+		{name: "latest skips releases dropping the kubernetes version", version: LatestIstioVersion, kubernetes: "v1.28.9+rke2r1", want: "1.24.6", wantKnown: true},
+		{name: "latest without a compatible release", version: LatestIstioVersion, kubernetes: "v1.25.16", wantErr: "no Istio release supports kubernetes v1.25.16, supported releases: 1.26.2 (kubernetes 1.29-1.33)"},
+		{name: "pinned compatible", version: "1.24.1", kubernetes: "v1.30.5+rke2r1", want: "1.24.1", wantKnown: true},
+		{name: "pinned incompatible", version: "1.26.0", kubernetes: "v1.28.9", wantErr: "version 1.26.0 of Istio does not support kubernetes v1.28.9"},
+		{name: "pinned unknown release", version: "1.30.0", kubernetes: "v1.30.5", want: "1.30.0"},
+		{name: "invalid", version: "stable", kubernetes: "v1.30.5", wantErr: `invalid Istio version "stable"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, known, err := ResolveIstioVersion(tt.version, tt.kubernetes)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantKnown, known)
+		})
+	}
+}
@@ -0,0 +1,211 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ErrAmbiguousContext is returned by SelectContext when no context is
+// requested and the kubeconfig holds several
+var ErrAmbiguousContext = errors.New("kubeconfig has multiple contexts")
+
+// KubeconfigEnv holds a kubeconfig to use instead of the file at
+// --kubeconfig-path
+const KubeconfigEnv = "KUBEFIRST_KUBECONFIG"
+
+// defaultKubeconfigSecretKey is the key of the kubeconfig in the secrets
+// --kubeconfig-from-secret reads when it names none
+const defaultKubeconfigSecretKey = "kubeconfig"
+
+// memoryKubeconfig is a kubeconfig set with SetKubeconfig, along with where
+// it was read from
+type memoryKubeconfig struct {
+	config *clientcmdapi.Config
+	source string
+}
+
+var kubeconfigOverride atomic.Pointer[memoryKubeconfig]
+
+// SetKubeconfig makes every client and kubeconfig lookup of the process use
+// the kubeconfig in content, read from source, instead of the file at their
+// kubeconfig path, so it never has to be written to disk. content has to be
+// a valid kubeconfig, nil goes back to the files
+func SetKubeconfig(content []byte, source string) error {
+	if content == nil {
+		kubeconfigOverride.Store(nil)
+		return nil
+	}
+
+	config, err := ParseKubeconfig(content)
+	if err != nil {
+		return fmt.Errorf("kubeconfig from %s: %w", source, err)
+	}
+	kubeconfigOverride.Store(&memoryKubeconfig{config: config, source: source})
+
+	return nil
+}
+
+// ParseKubeconfig parses content as a kubeconfig and validates it
+func ParseKubeconfig(content []byte) (*clientcmdapi.Config, error) {
+	config, err := clientcmd.Load(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	if err := clientcmd.Validate(*config); err != nil {
+		return nil, fmt.Errorf("invalid kubeconfig: %w", err)
+	}
+
+	return config, nil
+}
+
+// KubeconfigLocation returns where the kubeconfig of kubeconfigPath is read
+// from: the source given to SetKubeconfig or the expanded path
+func KubeconfigLocation(kubeconfigPath string) string {
+	_, location := clientConfig(kubeconfigPath, "")
+	return location
+}
+
+// clientConfig returns the client config of kubeContext, the current context
+// when empty, of the kubeconfig set with SetKubeconfig, or else of the file
+// at kubeconfigPath, along with where the kubeconfig is read from
+func clientConfig(kubeconfigPath, kubeContext string) (clientcmd.ClientConfig, string) {
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	if memory := kubeconfigOverride.Load(); memory != nil {
+		return clientcmd.NewNonInteractiveClientConfig(*memory.config, kubeContext, overrides, nil), memory.source
+	}
+
+	path := os.ExpandEnv(kubeconfigPath)
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(&clientcmd.ClientConfigLoadingRules{ExplicitPath: path}, overrides), path
+}
+
+// ReadKubeconfigSecret returns the kubeconfig held by the secret ref, as
+// namespace/name[:key], of the cluster kubefirst runs in: its in-cluster
+// config, or the default kubeconfig loading rules outside of a pod. The key
+// defaults to kubeconfig. Requests are routed through proxy as described by
+// ProxyFunc
+func ReadKubeconfigSecret(ctx context.Context, ref, proxy string) ([]byte, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			clientcmd.NewDefaultClientConfigLoadingRules(),
+			&clientcmd.ConfigOverrides{},
+		).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the config of the current cluster: %w", err)
+		}
+	}
+	restConfig.Proxy, err = ProxyFunc(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+
+	return kubeconfigFromSecret(ctx, clientset, ref)
+}
+
+// kubeconfigFromSecret returns the kubeconfig held by the secret ref, as
+// namespace/name[:key]
+func kubeconfigFromSecret(ctx context.Context, clientset kubernetes.Interface, ref string) ([]byte, error) {
+	secretRef, key, ok := strings.Cut(ref, ":")
+	if !ok {
+		key = defaultKubeconfigSecretKey
+	}
+	namespace, name, ok := strings.Cut(secretRef, "/")
+	if !ok || namespace == "" || name == "" || key == "" {
+		return nil, fmt.Errorf("invalid secret %q, expected namespace/name[:key]", ref)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", namespace, name, err)
+	}
+
+	content, ok := secret.Data[key]
+	if !ok || len(content) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has no %q key", namespace, name, key)
+	}
+
+	return content, nil
+}
+
+// KubeconfigContexts returns the sorted context names of the kubeconfig at
+// kubeconfigPath
+func KubeconfigContexts(kubeconfigPath string) ([]string, error) {
+	loader, location := clientConfig(kubeconfigPath, "")
+
+	config, err := loader.RawConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kubeconfig %q: %w", location, err)
+	}
+
+	contexts := make([]string, 0, len(config.Contexts))
+	for name := range config.Contexts {
+		contexts = append(contexts, name)
+	}
+	sort.Strings(contexts)
+
+	return contexts, nil
+}
+
+// SelectContext returns the context of the kubeconfig at kubeconfigPath to
+// use: requested when set, which has to exist, or the only context. Without
+// a requested context it wraps ErrAmbiguousContext, naming the contexts to
+// choose from, rather than silently using the current-context
+func SelectContext(kubeconfigPath, requested string) (string, error) {
+	contexts, err := KubeconfigContexts(kubeconfigPath)
+	if err != nil {
+		return "", err
+	}
+
+	if requested != "" {
+		for _, name := range contexts {
+			if name == requested {
+				return requested, nil
+			}
+		}
+		return "", fmt.Errorf("context %q not found in kubeconfig %q, expected one of: %s", requested, KubeconfigLocation(kubeconfigPath), strings.Join(contexts, ", "))
+	}
+
+	switch len(contexts) {
+	case 0:
+		return "", fmt.Errorf("kubeconfig %q has no contexts", KubeconfigLocation(kubeconfigPath))
+	case 1:
+		return contexts[0], nil
+	default:
+		return "", fmt.Errorf("%w, select one with --kubeconfig-context: %s", ErrAmbiguousContext, strings.Join(contexts, ", "))
+	}
+}
+
+// KubeconfigServer returns the API server of kubeContext in the kubeconfig
+// at kubeconfigPath, the current context when kubeContext is empty
+func KubeconfigServer(kubeconfigPath, kubeContext string) (string, error) {
+	loader, location := clientConfig(kubeconfigPath, kubeContext)
+
+	config, err := loader.ClientConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load kubeconfig %q: %w", location, err)
+	}
+
+	return config.Host, nil
+}
@@ -0,0 +1,108 @@
+package harvester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func writeKubeconfig(t *testing.T, contexts ...string) string {
+	t.Helper()
+
+	kubeconfig := "apiVersion: v1\nkind: Config\nclusters:\n- name: harvester\n  cluster:\n    server: https://harvester.example.com:6443\nusers:\n- name: admin\n  user:\n    token: secret\ncontexts:\n"
+	for _, name := range contexts {
+		kubeconfig += "- name: " + name + "\n  context:\n    cluster: harvester\n    user: admin\n"
+	}
+	if len(contexts) > 0 {
+		kubeconfig += "current-context: " + contexts[0] + "\n"
+	}
+
+	path := filepath.Join(t.TempDir(), "harvester.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(kubeconfig), 0o600))
+
+	return path
+}
+
+func TestSelectContext(t *testing.T) {
+	t.Run("single context is used", func(t *testing.T) {
+		selected, err := SelectContext(writeKubeconfig(t, "harvester-mgmt"), "")
+		require.NoError(t, err)
+		assert.Equal(t, "harvester-mgmt", selected)
+	})
+
+	t.Run("multiple contexts are ambiguous", func(t *testing.T) {
+		_, err := SelectContext(writeKubeconfig(t, "rancher", "harvester-mgmt", "guest"), "")
+		require.ErrorIs(t, err, ErrAmbiguousContext)
+		assert.Contains(t, err.Error(), "guest, harvester-mgmt, rancher")
+	})
+
+	t.Run("requested context is selected", func(t *testing.T) {
+		selected, err := SelectContext(writeKubeconfig(t, "rancher", "harvester-mgmt"), "harvester-mgmt")
+		require.NoError(t, err)
+		assert.Equal(t, "harvester-mgmt", selected)
+	})
+
+	t.Run("unknown context is rejected", func(t *testing.T) {
+		_, err := SelectContext(writeKubeconfig(t, "rancher", "harvester-mgmt"), "guest")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrAmbiguousContext)
+		assert.Contains(t, err.Error(), "expected one of: harvester-mgmt, rancher")
+	})
+}
+
+func TestNewClientContext(t *testing.T) {
+	path := writeKubeconfig(t, "rancher", "harvester-mgmt")
+
+	_, err := NewClient(path, "harvester-mgmt", "")
+	require.NoError(t, err)
+
+	_, err = NewClient(path, "guest", "")
+	require.Error(t, err)
+}
+
+func TestSetKubeconfig(t *testing.T) {
+	content, err := os.ReadFile(writeKubeconfig(t, "rancher", "harvester-mgmt"))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, SetKubeconfig(nil, "")) })
+
+	require.ErrorContains(t, SetKubeconfig([]byte("current-context: missing\n"), "KUBEFIRST_KUBECONFIG"), "kubeconfig from KUBEFIRST_KUBECONFIG: invalid kubeconfig")
+	require.NoError(t, SetKubeconfig(content, "secret ci/harvester"))
+
+	// the path is ignored in favor of the kubeconfig in memory
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+	assert.Equal(t, "secret ci/harvester", KubeconfigLocation(missing))
+	contexts, err := KubeconfigContexts(missing)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"harvester-mgmt", "rancher"}, contexts)
+	server, err := KubeconfigServer(missing, "harvester-mgmt")
+	require.NoError(t, err)
+	assert.Equal(t, "https://harvester.example.com:6443", server)
+	_, err = NewClient(missing, "guest", "")
+	require.ErrorContains(t, err, `failed to load kubeconfig "secret ci/harvester"`)
+}
+
+func TestKubeconfigFromSecret(t *testing.T) {
+	clientset := fake.NewClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "harvester", Namespace: "ci"}, Data: map[string][]byte{"kubeconfig": []byte("config"), "value": []byte("other")}},
+	)
+	ctx := context.Background()
+
+	content, err := kubeconfigFromSecret(ctx, clientset, "ci/harvester")
+	require.NoError(t, err)
+	assert.Equal(t, "config", string(content))
+	content, err = kubeconfigFromSecret(ctx, clientset, "ci/harvester:value")
+	require.NoError(t, err)
+	assert.Equal(t, "other", string(content))
+
+	_, err = kubeconfigFromSecret(ctx, clientset, "ci/harvester:token")
+	require.ErrorContains(t, err, `secret ci/harvester has no "token" key`)
+	_, err = kubeconfigFromSecret(ctx, clientset, "harvester")
+	require.ErrorContains(t, err, "expected namespace/name[:key]")
+}
@@ -0,0 +1,356 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// DefaultLargeFileWarnSize is the size above which a file committed to
+	// the gitops repository is reported, ArgoCD repo-server clones the whole
+	// repository on every manifest generation
+	DefaultLargeFileWarnSize = "10Mi"
+	// DefaultLargeFileMaxSize is the size above which a file is refused,
+	// GitHub rejects pushes of larger files
+	DefaultLargeFileMaxSize = "100Mi"
+
+	lfsMediaType   = "application/vnd.git-lfs+json"
+	lfsPointerSpec = "https://git-lfs.github.com/spec/v1"
+)
+
+// argoCDManifestExtensions are the files ArgoCD generates the manifests of
+// directory applications from
+var argoCDManifestExtensions = []string{".yaml", ".yml", ".json", ".jsonnet"}
+
+// LargeFilePolicy bounds the size of the files committed to the gitops
+// repository. Files matching LFSPatterns are stored with Git LFS and only
+// their pointer is committed. The zero value checks nothing
+type LargeFilePolicy struct {
+	WarnSize int64
+	MaxSize  int64
+	// AllowLarge commits files above MaxSize anyway
+	AllowLarge  bool
+	LFSPatterns []string
+	// OnWarning is told about every file above WarnSize
+	OnWarning func(message string)
+}
+
+// LargeFile is a file above the warning size of a LargeFilePolicy
+type LargeFile struct {
+	Path string
+	Size int64
+	// LFS is set when the file is stored with Git LFS
+	LFS bool
+}
+
+func (f LargeFile) String() string {
+	description := fmt.Sprintf("%s (%s)", f.Path, humanize.IBytes(uint64(f.Size)))
+	if f.LFS {
+		description += " stored with git lfs"
+	}
+
+	return description
+}
+
+// LargeFilesError names the files above the maximum size of the policy
+type LargeFilesError struct {
+	Files   []LargeFile
+	MaxSize int64
+}
+
+func (e *LargeFilesError) Error() string {
+	files := make([]string, 0, len(e.Files))
+	for _, file := range e.Files {
+		files = append(files, file.String())
+	}
+
+	return fmt.Sprintf("%d file(s) above the %s limit of the gitops repository: %s; store them with --git-lfs-patterns or pass --allow-large-files", len(e.Files), humanize.IBytes(uint64(e.MaxSize)), strings.Join(files, ", "))
+}
+
+// NewLargeFilePolicy parses the warning and maximum sizes, given as
+// Kubernetes quantities such as 10Mi, and validates the LFS patterns
+func NewLargeFilePolicy(warnSize, maxSize string, allowLarge bool, lfsPatterns []string) (LargeFilePolicy, error) {
+	warn, err := resource.ParseQuantity(warnSize)
+	if err != nil {
+		return LargeFilePolicy{}, fmt.Errorf("invalid warning size %q: %w", warnSize, err)
+	}
+	limit, err := resource.ParseQuantity(maxSize)
+	if err != nil {
+		return LargeFilePolicy{}, fmt.Errorf("invalid maximum size %q: %w", maxSize, err)
+	}
+	if warn.Value() > limit.Value() {
+		return LargeFilePolicy{}, fmt.Errorf("warning size %s is above the maximum size %s", warnSize, maxSize)
+	}
+
+	if err := ValidateLFSPatterns(lfsPatterns); err != nil {
+		return LargeFilePolicy{}, err
+	}
+
+	return LargeFilePolicy{WarnSize: warn.Value(), MaxSize: limit.Value(), AllowLarge: allowLarge, LFSPatterns: lfsPatterns}, nil
+}
+
+// ValidateLFSPatterns ensures every pattern is a valid gitattributes glob
+// that cannot match the files ArgoCD generates manifests from: the
+// repository credential ArgoCD holds does not enable LFS, so it would
+// render the pointer of the file instead of its content
+func ValidateLFSPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" || strings.ContainsAny(pattern, " \t") {
+			return fmt.Errorf("invalid git lfs pattern %q, patterns cannot be empty or contain whitespace", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid git lfs pattern %q: %w", pattern, err)
+		}
+		for _, extension := range argoCDManifestExtensions {
+			if matched, _ := path.Match(path.Base(pattern), "values"+extension); matched {
+				return fmt.Errorf("git lfs pattern %q matches %s files ArgoCD generates manifests from, store only other files such as *.tgz with git lfs", pattern, extension)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Tracked reports whether name, a path in the repository, is stored with
+// Git LFS. Like in gitattributes, patterns without a slash match the base
+// name in any directory
+func (p LargeFilePolicy) Tracked(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	for _, pattern := range p.LFSPatterns {
+		subject := path.Base(name)
+		if strings.Contains(pattern, "/") {
+			pattern, subject = strings.TrimPrefix(pattern, "/"), name
+		}
+		if matched, _ := path.Match(pattern, subject); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check returns the files above the warning size, telling OnWarning about
+// each, and a LargeFilesError when files not stored with LFS are above the
+// maximum size and large files are not allowed
+func (p LargeFilePolicy) Check(files map[string][]byte) ([]LargeFile, error) {
+	var large, refused []LargeFile
+	for _, name := range sortedKeys(files) {
+		file := LargeFile{Path: name, Size: int64(len(files[name])), LFS: p.Tracked(name)}
+		if p.WarnSize > 0 && file.Size > p.WarnSize {
+			large = append(large, file)
+			if p.OnWarning != nil {
+				p.OnWarning(fmt.Sprintf("%s is above %s, ArgoCD repo-server clones it on every manifest generation", file, humanize.IBytes(uint64(p.WarnSize))))
+			}
+		}
+		if p.MaxSize > 0 && file.Size > p.MaxSize && !file.LFS && !p.AllowLarge {
+			refused = append(refused, file)
+		}
+	}
+
+	if len(refused) > 0 {
+		return large, &LargeFilesError{Files: refused, MaxSize: p.MaxSize}
+	}
+
+	return large, nil
+}
+
+// lfsAttributes adds the LFS patterns missing from the gitattributes file
+// existing, which may be empty
+func lfsAttributes(existing []byte, patterns []string) []byte {
+	present := map[string]bool{}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.Contains(line, "filter=lfs") {
+			present[fields[0]] = true
+		}
+	}
+
+	attributes := bytes.TrimRight(existing, "\n")
+	if len(attributes) > 0 {
+		attributes = append(attributes, '\n')
+	}
+	for _, pattern := range patterns {
+		if !present[pattern] {
+			attributes = append(attributes, fmt.Sprintf("%s filter=lfs diff=lfs merge=lfs -text\n", pattern)...)
+			present[pattern] = true
+		}
+	}
+
+	return attributes
+}
+
+// lfsPointer returns the pointer committed in place of content and the oid
+// it is uploaded under
+func lfsPointer(content []byte) ([]byte, string) {
+	sum := sha256.Sum256(content)
+	oid := hex.EncodeToString(sum[:])
+
+	return []byte(fmt.Sprintf("version %s\noid sha256:%s\nsize %d\n", lfsPointerSpec, oid, len(content))), oid
+}
+
+type lfsObject struct {
+	OID     string               `json:"oid"`
+	Size    int64                `json:"size"`
+	Actions map[string]lfsAction `json:"actions,omitempty"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// uploadLFSObjects uploads objects, keyed by oid, through the LFS batch
+// API of the repository. Objects the server already has come back without
+// an upload action and are skipped
+func (r *GitopsRepo) uploadLFSObjects(ctx context.Context, objects map[string][]byte) error {
+	request := struct {
+		Operation string      `json:"operation"`
+		Transfers []string    `json:"transfers"`
+		Objects   []lfsObject `json:"objects"`
+	}{Operation: "upload", Transfers: []string{"basic"}}
+	for _, oid := range sortedKeys(objects) {
+		request.Objects = append(request.Objects, lfsObject{OID: oid, Size: int64(len(objects[oid]))})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode git lfs batch request: %w", err)
+	}
+
+	var response struct {
+		Objects []lfsObject `json:"objects"`
+	}
+	err = r.Retry.Do(ctx, "git lfs batch", func(ctx context.Context) error {
+		return r.lfsRequest(ctx, http.MethodPost, strings.TrimSuffix(r.URL, "/")+"/info/lfs/objects/batch", nil, body, &response, true)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start git lfs upload to %q, the git provider may not serve git lfs: %w", r.URL, err)
+	}
+
+	for _, object := range response.Objects {
+		if object.Error != nil {
+			return fmt.Errorf("git lfs refused object %s: %d %s", object.OID, object.Error.Code, object.Error.Message)
+		}
+		content, ok := objects[object.OID]
+		if !ok {
+			return fmt.Errorf("git lfs answered for unknown object %s", object.OID)
+		}
+
+		// uploading and verifying the same object again is idempotent
+		if upload, ok := object.Actions["upload"]; ok {
+			err := r.Retry.Do(ctx, "git lfs upload", func(ctx context.Context) error {
+				return r.lfsRequest(ctx, http.MethodPut, upload.Href, upload.Header, content, nil, false)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to upload git lfs object %s: %w", object.OID, err)
+			}
+		}
+		if verify, ok := object.Actions["verify"]; ok {
+			identity, _ := json.Marshal(lfsObject{OID: object.OID, Size: object.Size})
+			err := r.Retry.Do(ctx, "git lfs verify", func(ctx context.Context) error {
+				return r.lfsRequest(ctx, http.MethodPost, verify.Href, verify.Header, identity, nil, false)
+			})
+			if err != nil {
+				return fmt.Errorf("failed to verify git lfs object %s: %w", object.OID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// lfsRequest calls url with header. Only the batch endpoint takes the
+// repository credentials, set with batch, the actions it returns carry
+// their own
+func (r *GitopsRepo) lfsRequest(ctx context.Context, method, url string, header map[string]string, body []byte, out interface{}, batch bool) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build git lfs request: %w", err)
+	}
+	switch {
+	case batch:
+		req.SetBasicAuth(r.Auth.Username, r.Auth.Password)
+		req.Header.Set("Accept", lfsMediaType)
+		req.Header.Set("Content-Type", lfsMediaType)
+	case method == http.MethodPut:
+		req.Header.Set("Content-Type", "application/octet-stream")
+	default:
+		req.Header.Set("Content-Type", lfsMediaType)
+	}
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+
+	res, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call git lfs: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		if transientStatus(res.StatusCode) {
+			return newAPIError("git lfs", res)
+		}
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		return fmt.Errorf("git lfs returned %q: %s", res.Status, strings.TrimSpace(string(message)))
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode git lfs response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// storeLFSFiles replaces the files of files tracked by the policy with their
+// LFS pointer, uploading their content, and returns the gitattributes
+// tracking the patterns on top of existing. Files are returned unchanged
+// when the policy stores nothing with LFS
+func (r *GitopsRepo) storeLFSFiles(ctx context.Context, files map[string][]byte, existing []byte) (map[string][]byte, error) {
+	if len(r.LargeFiles.LFSPatterns) == 0 {
+		return files, nil
+	}
+
+	stored := make(map[string][]byte, len(files)+1)
+	objects := map[string][]byte{}
+	for name, content := range files {
+		if content == nil || !r.LargeFiles.Tracked(name) {
+			stored[name] = content
+			continue
+		}
+		pointer, oid := lfsPointer(content)
+		stored[name] = pointer
+		objects[oid] = content
+	}
+	if len(objects) == 0 {
+		return files, nil
+	}
+
+	if err := r.uploadLFSObjects(ctx, objects); err != nil {
+		return nil, err
+	}
+	stored[".gitattributes"] = lfsAttributes(existing, r.LargeFiles.LFSPatterns)
+
+	return stored, nil
+}
@@ -0,0 +1,128 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLargeFilePolicy(t *testing.T) {
+	policy, err := NewLargeFilePolicy("1Ki", "4Ki", false, []string{"*.tgz", "bundles/*.tar"})
+	require.NoError(t, err)
+
+	var warnings []string
+	policy.OnWarning = func(message string) { warnings = append(warnings, message) }
+
+	files := map[string][]byte{
+		"registry/values.yaml":   make([]byte, 512),
+		"registry/big.yaml":      make([]byte, 2048),
+		"registry/huge.yaml":     make([]byte, 8192),
+		"charts/platform.tgz":    make([]byte, 8192),
+		"bundles/images.tar":     make([]byte, 8192),
+		"registry/images.tar":    make([]byte, 16),
+		"registry/nested/x.json": make([]byte, 16),
+	}
+
+	large, err := policy.Check(files)
+	var largeErr *LargeFilesError
+	require.ErrorAs(t, err, &largeErr)
+	assert.Equal(t, []LargeFile{{Path: "registry/huge.yaml", Size: 8192}}, largeErr.Files)
+	assert.ErrorContains(t, err, "1 file(s) above the 4.0 KiB limit of the gitops repository: registry/huge.yaml (8.0 KiB)")
+	assert.Len(t, large, 4)
+	assert.Len(t, warnings, 4)
+	assert.Contains(t, warnings[1], "charts/platform.tgz (8.0 KiB) stored with git lfs")
+
+	assert.False(t, policy.Tracked("registry/images.tar"))
+	assert.True(t, policy.Tracked("/bundles/images.tar"))
+
+	policy.AllowLarge = true
+	_, err = policy.Check(files)
+	require.NoError(t, err)
+
+	_, err = LargeFilePolicy{}.Check(files)
+	require.NoError(t, err)
+
+	_, err = NewLargeFilePolicy("10Mi", "1Mi", false, nil)
+	require.ErrorContains(t, err, "above the maximum size")
+
+	for _, pattern := range []string{"*.yaml", "values.*", "registry/*", "[", ""} {
+		_, err := NewLargeFilePolicy(DefaultLargeFileWarnSize, DefaultLargeFileMaxSize, false, []string{pattern})
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestLFSAttributes(t *testing.T) {
+	assert.Equal(t, "*.tgz filter=lfs diff=lfs merge=lfs -text\n", string(lfsAttributes(nil, []string{"*.tgz"})))
+	assert.Equal(t,
+		"*.sh text eol=lf\n*.tgz filter=lfs diff=lfs merge=lfs -text\n*.tar filter=lfs diff=lfs merge=lfs -text\n",
+		string(lfsAttributes([]byte("*.sh text eol=lf\n*.tgz filter=lfs diff=lfs merge=lfs -text\n"), []string{"*.tgz", "*.tar"})),
+	)
+}
+
+func TestStoreLFSFiles(t *testing.T) {
+	content := []byte("chart archive")
+	pointer, oid := lfsPointer(content)
+
+	var uploaded []byte
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/org/gitops.git/info/lfs/objects/batch":
+			username, password, _ := r.BasicAuth()
+			require.Equal(t, "bot:token", username+":"+password)
+			require.Equal(t, lfsMediaType, r.Header.Get("Content-Type"))
+
+			var request struct {
+				Operation string      `json:"operation"`
+				Objects   []lfsObject `json:"objects"`
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			require.Equal(t, "upload", request.Operation)
+			require.Equal(t, []lfsObject{{OID: oid, Size: int64(len(content))}}, request.Objects)
+
+			json.NewEncoder(w).Encode(map[string]any{"objects": []lfsObject{{
+				OID:     oid,
+				Size:    int64(len(content)),
+				Actions: map[string]lfsAction{"upload": {Href: server.URL + "/upload/" + oid, Header: map[string]string{"Authorization": "Bearer upload"}}},
+			}}})
+		case "/upload/" + oid:
+			require.Equal(t, "Bearer upload", r.Header.Get("Authorization"))
+			uploaded, _ = io.ReadAll(r.Body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	repo := &GitopsRepo{
+		URL:        server.URL + "/org/gitops.git",
+		Auth:       &githttp.BasicAuth{Username: "bot", Password: "token"},
+		LargeFiles: LargeFilePolicy{LFSPatterns: []string{"*.tgz"}},
+		httpClient: server.Client(),
+	}
+
+	stored, err := repo.storeLFSFiles(context.Background(), map[string][]byte{
+		"charts/platform.tgz":  content,
+		"registry/values.yaml": []byte("a: b"),
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, content, uploaded)
+	assert.Equal(t, map[string][]byte{
+		"charts/platform.tgz":  pointer,
+		"registry/values.yaml": []byte("a: b"),
+		".gitattributes":       []byte("*.tgz filter=lfs diff=lfs merge=lfs -text\n"),
+	}, stored)
+	assert.True(t, strings.HasPrefix(string(pointer), "version https://git-lfs.github.com/spec/v1\noid sha256:"+oid))
+
+	repo.URL = server.URL + "/org/missing.git"
+	_, err = repo.storeLFSFiles(context.Background(), map[string][]byte{"charts/platform.tgz": content}, nil)
+	require.ErrorContains(t, err, "may not serve git lfs")
+}
@@ -0,0 +1,441 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// LBPoolName is the default name of the pool of the unnamed ranges
+	LBPoolName      = "kubefirst"
+	LBPoolNamespace = "metallb-system"
+
+	// LBManagementPool and LBTenantPool are the pool names the platform and
+	// the vcluster services request when a pool of that name is configured
+	LBManagementPool = "management"
+	LBTenantPool     = "tenant"
+
+	// LBPoolAnnotation makes MetalLB allocate the address of a service from
+	// the named pool
+	LBPoolAnnotation = "metallb.universe.tf/address-pool"
+
+	// DefaultLBAllocationTimeout is how long LoadBalancer services get to
+	// receive an address once ingress is provisioned
+	DefaultLBAllocationTimeout = 5 * time.Minute
+)
+
+var ipAddressPoolGVR = schema.GroupVersionResource{Group: "metallb.io", Version: "v1beta1", Resource: "ipaddresspools"}
+
+// IPRange is an inclusive range of load balancer addresses
+type IPRange struct {
+	Start netip.Addr
+	End   netip.Addr
+
+	raw string
+}
+
+// ParseIPRange parses either a CIDR (10.0.12.0/24) or an inclusive address
+// range (10.0.12.10-10.0.12.50)
+func ParseIPRange(value string) (IPRange, error) {
+	value = strings.TrimSpace(value)
+
+	if start, end, ok := strings.Cut(value, "-"); ok {
+		startAddr, err := netip.ParseAddr(strings.TrimSpace(start))
+		if err != nil {
+			return IPRange{}, fmt.Errorf("invalid range start in %q: %w", value, err)
+		}
+		endAddr, err := netip.ParseAddr(strings.TrimSpace(end))
+		if err != nil {
+			return IPRange{}, fmt.Errorf("invalid range end in %q: %w", value, err)
+		}
+		if startAddr.Is4() != endAddr.Is4() {
+			return IPRange{}, fmt.Errorf("range %q mixes IPv4 and IPv6 addresses", value)
+		}
+		if endAddr.Less(startAddr) {
+			return IPRange{}, fmt.Errorf("range %q ends before it starts", value)
+		}
+		return IPRange{Start: startAddr, End: endAddr, raw: startAddr.String() + "-" + endAddr.String()}, nil
+	}
+
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return IPRange{}, fmt.Errorf("%q is neither a CIDR nor a start-end range: %w", value, err)
+	}
+	prefix = prefix.Masked()
+
+	return IPRange{Start: prefix.Addr(), End: lastAddr(prefix), raw: prefix.String()}, nil
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 1 << (7 - bit%8)
+	}
+
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// Contains reports whether addr lies inside the range
+func (r IPRange) Contains(addr netip.Addr) bool {
+	return r.Start.Compare(addr) <= 0 && addr.Compare(r.End) <= 0
+}
+
+// Overlaps reports whether the range shares an address with other
+func (r IPRange) Overlaps(other IPRange) bool {
+	return r.Start.Compare(other.End) <= 0 && other.Start.Compare(r.End) <= 0
+}
+
+func (r IPRange) String() string {
+	return r.raw
+}
+
+// LBPool is a MetalLB address pool handing out addresses from its ranges
+type LBPool struct {
+	Name   string
+	Ranges []IPRange
+}
+
+// Contains reports whether addr lies inside one of the ranges of the pool
+func (p LBPool) Contains(addr netip.Addr) bool {
+	for _, ipRange := range p.Ranges {
+		if ipRange.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p LBPool) String() string {
+	ranges := make([]string, 0, len(p.Ranges))
+	for _, ipRange := range p.Ranges {
+		ranges = append(ranges, ipRange.String())
+	}
+	return strings.Join(ranges, ",")
+}
+
+// LBPools are the address pools of the platform
+type LBPools []LBPool
+
+// Contains reports whether addr lies inside one of the pools
+func (p LBPools) Contains(addr netip.Addr) bool {
+	for _, pool := range p {
+		if pool.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pool returns the pool called name
+func (p LBPools) Pool(name string) (LBPool, bool) {
+	for _, pool := range p {
+		if pool.Name == name {
+			return pool, true
+		}
+	}
+	return LBPool{}, false
+}
+
+func (p LBPools) String() string {
+	ranges := make([]string, 0, len(p))
+	for _, pool := range p {
+		ranges = append(ranges, pool.String())
+	}
+	return strings.Join(ranges, ",")
+}
+
+// ParseLBPools parses the unnamed ranges of --lb-ip-range into the pool
+// named poolName, LBPoolName when empty, and the <name>:<range> entries of
+// --lb-ip-range-name into the pool of that name, in the order the pools
+// first appear. Ranges overlapping each other are refused, MetalLB would
+// hand their addresses out twice
+func ParseLBPools(poolName string, ranges, namedRanges []string) (LBPools, error) {
+	if poolName == "" {
+		poolName = LBPoolName
+	}
+	if errs := validation.IsDNS1123Label(poolName); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid pool name %q: %s", poolName, strings.Join(errs, ", "))
+	}
+
+	var pools LBPools
+	var parsed []IPRange
+	add := func(name, value string) error {
+		ipRange, err := ParseIPRange(value)
+		if err != nil {
+			return err
+		}
+		for _, other := range parsed {
+			if ipRange.Overlaps(other) {
+				return fmt.Errorf("range %s overlaps range %s", ipRange, other)
+			}
+		}
+		parsed = append(parsed, ipRange)
+
+		for i := range pools {
+			if pools[i].Name == name {
+				pools[i].Ranges = append(pools[i].Ranges, ipRange)
+				return nil
+			}
+		}
+		pools = append(pools, LBPool{Name: name, Ranges: []IPRange{ipRange}})
+		return nil
+	}
+
+	for _, value := range ranges {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		if err := add(poolName, value); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range namedRanges {
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a <name>:<range> pair", entry)
+		}
+		if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid pool name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if err := add(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pools) == 0 {
+		return nil, fmt.Errorf("no load balancer range given")
+	}
+
+	return pools, nil
+}
+
+// LBPoolConflicts returns the MetalLB address pools outside pools handing
+// out addresses of their ranges, each as "<namespace>/<name> (<range>)
+// overlaps <range>". The pools of the same name in LBPoolNamespace are the
+// ones a previous run committed and are not conflicts. None are returned
+// when MetalLB is not installed yet
+func (c *Client) LBPoolConflicts(ctx context.Context, pools LBPools) ([]string, error) {
+	existing, err := c.Dynamic.Resource(ipAddressPoolGVR).Namespace("").List(ctx, metav1.ListOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list MetalLB address pools: %w", err)
+	}
+
+	var conflicts []string
+	for _, pool := range existing.Items {
+		if _, ours := pools.Pool(pool.GetName()); ours && pool.GetNamespace() == LBPoolNamespace {
+			continue
+		}
+
+		addresses, _, _ := unstructured.NestedStringSlice(pool.Object, "spec", "addresses")
+		for _, address := range addresses {
+			ipRange, err := ParseIPRange(address)
+			if err != nil {
+				continue
+			}
+			for _, ours := range pools {
+				for _, other := range ours.Ranges {
+					if ipRange.Overlaps(other) {
+						conflicts = append(conflicts, fmt.Sprintf("%s/%s (%s) overlaps %s", pool.GetNamespace(), pool.GetName(), ipRange, other))
+					}
+				}
+			}
+		}
+	}
+
+	sort.Strings(conflicts)
+
+	return conflicts, nil
+}
+
+// LBPoolManifests renders a MetalLB IPAddressPool and L2Advertisement for
+// each of pools
+func LBPoolManifests(pools LBPools) ([]byte, error) {
+	var objects []map[string]interface{}
+	for _, pool := range pools {
+		metadata := map[string]interface{}{
+			"name":      pool.Name,
+			"namespace": LBPoolNamespace,
+		}
+
+		addresses := make([]string, 0, len(pool.Ranges))
+		for _, ipRange := range pool.Ranges {
+			addresses = append(addresses, ipRange.String())
+		}
+
+		objects = append(objects,
+			map[string]interface{}{
+				"apiVersion": "metallb.io/v1beta1",
+				"kind":       "IPAddressPool",
+				"metadata":   metadata,
+				"spec": map[string]interface{}{
+					"addresses": addresses,
+				},
+			},
+			map[string]interface{}{
+				"apiVersion": "metallb.io/v1beta1",
+				"kind":       "L2Advertisement",
+				"metadata":   metadata,
+				"spec": map[string]interface{}{
+					"ipAddressPools": []string{pool.Name},
+				},
+			},
+		)
+	}
+
+	var manifests []string
+	for _, object := range objects {
+		manifest, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render load balancer pool: %w", err)
+		}
+		manifests = append(manifests, string(manifest))
+	}
+
+	return []byte(strings.Join(manifests, "---\n")), nil
+}
+
+// LBPoolAssignments maps the namespaces whose LoadBalancer services request
+// a pool to its name: the ArgoCD and ingress namespaces request the
+// LBManagementPool and the vcluster namespaces the LBTenantPool, each only
+// when a pool of that name exists
+func LBPoolAssignments(pools LBPools, vclusters []string) map[string]string {
+	assignments := map[string]string{}
+	if _, ok := pools.Pool(LBManagementPool); ok {
+		for _, namespace := range []string{ArgoCDNamespace, IstioNamespace, WildcardGatewayNamespace} {
+			assignments[namespace] = LBManagementPool
+		}
+	}
+	if _, ok := pools.Pool(LBTenantPool); ok {
+		for _, vcluster := range vclusters {
+			assignments[VClusterNamespace(vcluster)] = LBTenantPool
+		}
+	}
+	return assignments
+}
+
+// AssignLBPools annotates the LoadBalancer services of each namespace of
+// assignments to request its pool from MetalLB
+func (c *Client) AssignLBPools(ctx context.Context, assignments map[string]string) error {
+	for _, namespace := range sortedKeys(assignments) {
+		services, err := c.Clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list services in %q: %w", namespace, err)
+		}
+
+		pool := assignments[namespace]
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{LBPoolAnnotation: pool},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to render pool annotation: %w", err)
+		}
+
+		for _, service := range services.Items {
+			if service.Spec.Type != corev1.ServiceTypeLoadBalancer || service.Annotations[LBPoolAnnotation] == pool {
+				continue
+			}
+			if _, err := c.Clientset.CoreV1().Services(namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager}); err != nil {
+				return fmt.Errorf("failed to assign service %s/%s to pool %s: %w", namespace, service.Name, pool, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// VerifyLBAllocation waits until every LoadBalancer service, including the
+// ones kgateway creates for Gateways, has an address inside pools, inside
+// the pool it requests if it requests one. On timeout the error names each
+// service with its assigned address or none
+func (c *Client) VerifyLBAllocation(ctx context.Context, pools LBPools) error {
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	var problems []string
+	for {
+		current, err := c.lbAllocationProblems(ctx, pools)
+		switch {
+		case err == nil:
+			problems = current
+			if len(problems) == 0 {
+				return nil
+			}
+		case ctx.Err() == nil:
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("load balancer addresses not allocated from %s: %s: %w", pools, strings.Join(problems, "; "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) lbAllocationProblems(ctx context.Context, pools LBPools) ([]string, error) {
+	services, err := c.Clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	var problems []string
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+
+		name := fmt.Sprintf("service %s/%s", service.Namespace, service.Name)
+
+		requested, requests := pools.Pool(service.Annotations[LBPoolAnnotation])
+
+		var addresses []string
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP == "" {
+				continue
+			}
+			addresses = append(addresses, ingress.IP)
+
+			addr, err := netip.ParseAddr(ingress.IP)
+			switch {
+			case err != nil || !pools.Contains(addr):
+				problems = append(problems, fmt.Sprintf("%s got %s outside the range", name, ingress.IP))
+			case requests && !requested.Contains(addr):
+				problems = append(problems, fmt.Sprintf("%s got %s outside its pool %s", name, ingress.IP, requested.Name))
+			}
+		}
+
+		if len(addresses) == 0 {
+			problems = append(problems, fmt.Sprintf("%s got none", name))
+		}
+	}
+
+	sort.Strings(problems)
+
+	return problems, nil
+}
@@ -0,0 +1,222 @@
+package harvester
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseIPRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		start   string
+		end     string
+		wantErr string
+	}{
+		{name: "cidr", value: "10.0.12.0/24", start: "10.0.12.0", end: "10.0.12.255"},
+		{name: "unmasked cidr", value: "10.0.12.7/30", start: "10.0.12.4", end: "10.0.12.7"},
+		{name: "range", value: "10.0.12.10-10.0.12.50", start: "10.0.12.10", end: "10.0.12.50"},
+		{name: "invalid prefix length", value: "10.0.12.0/42", wantErr: "neither a CIDR nor a start-end range"},
+		{name: "reversed range", value: "10.0.12.50-10.0.12.10", wantErr: "ends before it starts"},
+		{name: "mixed families", value: "10.0.12.10-fd00::1", wantErr: "mixes IPv4 and IPv6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipRange, err := ParseIPRange(tt.value)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.start, ipRange.Start.String())
+			assert.Equal(t, tt.end, ipRange.End.String())
+		})
+	}
+}
+
+func TestIPRangeContains(t *testing.T) {
+	ipRange, err := ParseIPRange("10.0.12.10-10.0.12.50")
+	require.NoError(t, err)
+
+	assert.True(t, ipRange.Contains(netip.MustParseAddr("10.0.12.10")))
+	assert.True(t, ipRange.Contains(netip.MustParseAddr("10.0.12.50")))
+	assert.False(t, ipRange.Contains(netip.MustParseAddr("10.0.12.51")))
+}
+
+func newLoadBalancerService(namespace, name string, ips ...string) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+	}
+	for _, ip := range ips {
+		service.Status.LoadBalancer.Ingress = append(service.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+	}
+
+	return service
+}
+
+func TestParseLBPools(t *testing.T) {
+	pools, err := ParseLBPools(LBPoolName, []string{"10.0.12.0/24", "10.0.14.10-10.0.14.50"}, []string{"management:10.0.13.0/28", "tenant:10.0.13.16/28", "tenant:10.0.15.0/24"})
+	require.NoError(t, err)
+	require.Len(t, pools, 3)
+	assert.Equal(t, LBPoolName, pools[0].Name)
+	assert.Equal(t, "10.0.12.0/24,10.0.14.10-10.0.14.50", pools[0].String())
+	assert.Equal(t, "10.0.13.16/28,10.0.15.0/24", pools[2].String())
+
+	for _, tt := range []struct {
+		ranges, named []string
+		wantErr       string
+	}{
+		{ranges: []string{"10.0.12.0/24", "10.0.12.128/25"}, wantErr: "overlaps range 10.0.12.0/24"},
+		{named: []string{"management:10.0.12.0/24", "tenant:10.0.12.200-10.0.13.10"}, wantErr: "overlaps"},
+		{named: []string{"10.0.12.0/24"}, wantErr: "not a <name>:<range> pair"},
+		{named: []string{"Tenant:10.0.12.0/24"}, wantErr: "invalid pool name"},
+		{wantErr: "no load balancer range"},
+	} {
+		_, err := ParseLBPools(LBPoolName, tt.ranges, tt.named)
+		assert.ErrorContains(t, err, tt.wantErr)
+	}
+
+	pools, err = ParseLBPools("", []string{"10.0.12.0/24"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, LBPoolName, pools[0].Name)
+	pools, err = ParseLBPools("homelab", []string{"10.0.12.0/24"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "homelab", pools[0].Name)
+	_, err = ParseLBPools("Homelab", []string{"10.0.12.0/24"}, nil)
+	assert.ErrorContains(t, err, "invalid pool name")
+}
+
+func TestLBPoolConflicts(t *testing.T) {
+	pools, err := ParseLBPools("homelab", []string{"10.0.12.0/24"}, []string{"tenant:10.0.13.0/24"})
+	require.NoError(t, err)
+
+	newPool := func(namespace, name string, addresses ...interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "metallb.io/v1beta1",
+			"kind":       "IPAddressPool",
+			"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+			"spec":       map[string]interface{}{"addresses": addresses},
+		}}
+	}
+	client := &Client{Dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ipAddressPoolGVR: "IPAddressPoolList"},
+		// the pool a previous run committed
+		newPool(LBPoolNamespace, "homelab", "10.0.12.0/24"),
+		newPool(LBPoolNamespace, "servers", "10.0.12.200-10.0.12.210", "10.0.20.0/24"),
+		newPool("metallb", "tenant", "10.0.13.128/25"),
+		newPool(LBPoolNamespace, "storage", "10.0.14.0/24"),
+	)}
+
+	conflicts, err := client.LBPoolConflicts(context.Background(), pools)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"metallb-system/servers (10.0.12.200-10.0.12.210) overlaps 10.0.12.0/24",
+		"metallb/tenant (10.0.13.128/25) overlaps 10.0.13.0/24",
+	}, conflicts)
+
+	// MetalLB is not installed yet
+	dynamic := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ipAddressPoolGVR: "IPAddressPoolList"})
+	dynamic.PrependReactor("list", "ipaddresspools", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewNotFound(ipAddressPoolGVR.GroupResource(), "")
+	})
+	client = &Client{Dynamic: dynamic}
+	conflicts, err = client.LBPoolConflicts(context.Background(), pools)
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
+
+func TestLBPoolManifests(t *testing.T) {
+	pools, err := ParseLBPools(LBPoolName, nil, []string{"management:10.0.13.0/28", "tenant:10.0.13.16/28"})
+	require.NoError(t, err)
+
+	manifests, err := LBPoolManifests(pools)
+	require.NoError(t, err)
+
+	documents := strings.Split(string(manifests), "---\n")
+	require.Len(t, documents, 4)
+	assert.Contains(t, documents[2], "kind: IPAddressPool")
+	assert.Contains(t, documents[2], "name: tenant")
+	assert.Contains(t, documents[2], "- 10.0.13.16/28")
+	assert.Contains(t, documents[3], "kind: L2Advertisement")
+	assert.Contains(t, documents[3], "- tenant")
+}
+
+func TestAssignLBPools(t *testing.T) {
+	pools, err := ParseLBPools(LBPoolName, []string{"10.0.12.0/24"}, []string{"tenant:10.0.13.0/24"})
+	require.NoError(t, err)
+
+	// there is no management pool, the platform services are left alone
+	assignments := LBPoolAssignments(pools, []string{"dev"})
+	assert.Equal(t, map[string]string{VClusterNamespace("dev"): LBTenantPool}, assignments)
+
+	client := &Client{Clientset: fake.NewSimpleClientset(
+		newLoadBalancerService(VClusterNamespace("dev"), "ingress", "10.0.12.30"),
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: VClusterNamespace("dev")}},
+	)}
+	require.NoError(t, client.AssignLBPools(context.Background(), assignments))
+
+	service, err := client.Clientset.CoreV1().Services(VClusterNamespace("dev")).Get(context.Background(), "ingress", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, LBTenantPool, service.Annotations[LBPoolAnnotation])
+
+	// the address is still the one of the default pool
+	err = client.VerifyLBAllocation(canceledContext(), pools)
+	assert.ErrorContains(t, err, "service vcluster-dev/ingress got 10.0.12.30 outside its pool tenant")
+
+	service, err = client.Clientset.CoreV1().Services(VClusterNamespace("dev")).Get(context.Background(), "api", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, service.Annotations)
+}
+
+func canceledContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}
+
+func TestVerifyLBAllocation(t *testing.T) {
+	pools, err := ParseLBPools(LBPoolName, []string{"10.0.12.0/24"}, nil)
+	require.NoError(t, err)
+
+	t.Run("accepts addresses inside the range", func(t *testing.T) {
+		client := &Client{Clientset: fake.NewSimpleClientset(
+			newLoadBalancerService("kgateway-system", "http", "10.0.12.20"),
+			&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "vault"}},
+		)}
+
+		require.NoError(t, client.VerifyLBAllocation(context.Background(), pools))
+	})
+
+	t.Run("names missing and out of range addresses", func(t *testing.T) {
+		client := &Client{Clientset: fake.NewSimpleClientset(
+			newLoadBalancerService("kgateway-system", "http"),
+			newLoadBalancerService("kgateway-system", "vcluster-wildcard", "192.168.1.20"),
+		)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := client.VerifyLBAllocation(ctx, pools)
+		require.ErrorIs(t, err, context.Canceled)
+		assert.ErrorContains(t, err, "service kgateway-system/http got none")
+		assert.ErrorContains(t, err, "service kgateway-system/vcluster-wildcard got 192.168.1.20 outside the range")
+	})
+}
@@ -0,0 +1,216 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+const (
+	// LoggingApplication is the ArgoCD application installing Loki and the
+	// Promtail DaemonSet into ObservabilityNamespace
+	LoggingApplication = "loki-stack"
+	LokiStackVersion   = "2.10.2"
+
+	// DefaultLoggingRetention is how long Loki keeps logs by default
+	DefaultLoggingRetention = "7d"
+
+	// HostEnvironment is the environment label of the logs of the pods of
+	// the host cluster, the vcluster workloads being labelled with their
+	// vcluster
+	HostEnvironment = "management"
+
+	lokiStackRepo = "https://grafana.github.io/helm-charts"
+	lokiService   = "loki"
+	lokiPort      = 3100
+)
+
+var retentionPattern = regexp.MustCompile(`^([0-9]+)([hdw])$`)
+
+// LoggingEnabled reports whether the logging stack is installed: it was
+// asked for with --logging and --stop-after does not halt before the
+// observability phase
+func LoggingEnabled(stopAfter string, logging bool) bool {
+	return logging && PhaseEnabled(stopAfter, PhaseObservability)
+}
+
+// GrafanaEnabled reports whether a Grafana is installed, the one of
+// kube-prometheus-stack or the standalone one of the logging stack
+func GrafanaEnabled(stopAfter string, observability, logging bool) bool {
+	return ObservabilityEnabled(stopAfter, observability) || LoggingEnabled(stopAfter, logging)
+}
+
+// ParseLoggingRetention parses a --logging-retention such as 7d, 2w or
+// 168h. Loki deletes logs by daily index, the retention is a whole number
+// of days
+func ParseLoggingRetention(retention string) (time.Duration, error) {
+	match := retentionPattern.FindStringSubmatch(retention)
+	if match == nil {
+		return 0, fmt.Errorf("%q is not a number of hours, days or weeks such as 168h, 7d or 2w", retention)
+	}
+
+	count, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid retention %q: %w", retention, err)
+	}
+	unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[match[2]]
+
+	duration := time.Duration(count) * unit
+	if duration < 24*time.Hour || duration%(24*time.Hour) != 0 {
+		return 0, fmt.Errorf("retention %q is not a whole number of days", retention)
+	}
+
+	return duration, nil
+}
+
+// LokiURL is the address Grafana queries Loki on
+func LokiURL() string {
+	return fmt.Sprintf("http://%s.%s.svc:%d", lokiService, ObservabilityNamespace, lokiPort)
+}
+
+// lokiDataSource is the Grafana datasource of LokiURL
+func lokiDataSource() map[string]interface{} {
+	return map[string]interface{}{
+		"name":   "Loki",
+		"type":   "loki",
+		"uid":    "loki",
+		"access": "proxy",
+		"url":    LokiURL(),
+	}
+}
+
+// LoggingOptions are the cluster the logs are labelled with, how long they
+// are kept, and whether the logging stack brings its own Grafana
+type LoggingOptions struct {
+	ClusterName string
+	Retention   time.Duration
+	// Grafana installs a Grafana scoped to the logs on GrafanaHost, for
+	// when kube-prometheus-stack does not bring one
+	Grafana    bool
+	DomainName string
+	Ingress    PlatformIngress
+}
+
+// LoggingManifests renders the ArgoCD application installing Loki with
+// the retention of opts and Promtail on every node. Promtail labels every
+// log stream with cluster, the kubefirst cluster, and environment, the
+// vcluster the pod was synced from or HostEnvironment, so one query spans
+// the vclusters. The logs of the vcluster workloads also carry the virtual
+// namespace as vcluster_namespace
+func LoggingManifests(opts LoggingOptions) ([]byte, error) {
+	manifests, err := encodeDocuments([]map[string]interface{}{lokiStackApplication(opts)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render logging manifests: %w", err)
+	}
+
+	return manifests, nil
+}
+
+func lokiStackApplication(opts LoggingOptions) map[string]interface{} {
+	retention := fmt.Sprintf("%dh", int(opts.Retention.Hours()))
+
+	relabel := []interface{}{
+		map[string]interface{}{"target_label": "cluster", "replacement": opts.ClusterName},
+		map[string]interface{}{"target_label": "environment", "replacement": HostEnvironment},
+		map[string]interface{}{
+			"source_labels": []string{"__meta_kubernetes_namespace"},
+			"regex":         VClusterNamespace("(.+)"),
+			"target_label":  "environment",
+			"replacement":   "$1",
+		},
+		// the syncer records the virtual namespace of the pods it syncs
+		map[string]interface{}{
+			"source_labels": []string{"__meta_kubernetes_pod_label_vcluster_loft_sh_namespace"},
+			"regex":         "(.+)",
+			"target_label":  "vcluster_namespace",
+		},
+	}
+
+	values := map[string]interface{}{
+		"loki": map[string]interface{}{
+			"fullnameOverride": lokiService,
+			"config": map[string]interface{}{
+				"compactor": map[string]interface{}{
+					"retention_enabled":      true,
+					"shared_store":           "filesystem",
+					"working_directory":      "/data/loki/compactor",
+					"compaction_interval":    "10m",
+					"retention_delete_delay": "2h",
+				},
+				"limits_config": map[string]interface{}{"retention_period": retention},
+			},
+			"persistence": map[string]interface{}{"enabled": true, "size": "20Gi"},
+		},
+		"promtail": map[string]interface{}{
+			"enabled": true,
+			// vcluster workloads run as pods of the host cluster, scraping
+			// every node covers them
+			"tolerations": []interface{}{map[string]interface{}{"operator": "Exists"}},
+			"config": map[string]interface{}{
+				"clients":  []interface{}{map[string]interface{}{"url": LokiURL() + "/loki/api/v1/push"}},
+				"snippets": map[string]interface{}{"extraRelabelConfigs": relabel},
+			},
+		},
+		"grafana": map[string]interface{}{"enabled": false},
+	}
+
+	if opts.Grafana {
+		host := GrafanaHost(opts.DomainName)
+		ingress := map[string]interface{}{
+			"enabled":     true,
+			"annotations": map[string]string{certManagerIssuerKey: opts.Ingress.Issuer},
+			"hosts":       []string{host},
+			"tls":         []interface{}{map[string]interface{}{"secretName": grafanaTLSSecret, "hosts": []string{host}}},
+		}
+		if opts.Ingress.Class != "" {
+			ingress["ingressClassName"] = opts.Ingress.Class
+		}
+
+		values["grafana"] = map[string]interface{}{
+			"enabled":          true,
+			"fullnameOverride": grafanaService,
+			"admin": map[string]interface{}{
+				"existingSecret": GrafanaAdminSecret,
+				"userKey":        "admin-user",
+				"passwordKey":    "admin-password",
+			},
+			"ingress": ingress,
+			// scoped to the logs, Loki is its only datasource
+			"sidecar":     map[string]interface{}{"datasources": map[string]interface{}{"enabled": false}},
+			"datasources": map[string]interface{}{"datasources.yaml": map[string]interface{}{"apiVersion": 1, "datasources": []interface{}{lokiDataSource()}}},
+		}
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata": map[string]interface{}{
+			"name":      LoggingApplication,
+			"namespace": ArgoCDNamespace,
+		},
+		"spec": map[string]interface{}{
+			"project": "default",
+			"source": map[string]interface{}{
+				"repoURL":        lokiStackRepo,
+				"chart":          LoggingApplication,
+				"targetRevision": LokiStackVersion,
+				"helm":           map[string]interface{}{"valuesObject": values},
+			},
+			"destination": map[string]interface{}{
+				"server":    "https://kubernetes.default.svc",
+				"namespace": ObservabilityNamespace,
+			},
+			"syncPolicy": map[string]interface{}{
+				"automated":   map[string]interface{}{"prune": true, "selfHeal": true},
+				"syncOptions": []string{"CreateNamespace=true", "ServerSideApply=true"},
+			},
+		},
+	}
+}
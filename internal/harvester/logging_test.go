@@ -0,0 +1,67 @@
+package harvester
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoggingRetention(t *testing.T) {
+	for retention, expected := range map[string]time.Duration{
+		"7d":   7 * 24 * time.Hour,
+		"2w":   14 * 24 * time.Hour,
+		"168h": 7 * 24 * time.Hour,
+	} {
+		duration, err := ParseLoggingRetention(retention)
+		require.NoError(t, err, retention)
+		assert.Equal(t, expected, duration, retention)
+	}
+
+	_, err := ParseLoggingRetention("7 days")
+	require.ErrorContains(t, err, "is not a number of hours, days or weeks")
+	_, err = ParseLoggingRetention("36h")
+	require.ErrorContains(t, err, "is not a whole number of days")
+}
+
+func TestLoggingManifests(t *testing.T) {
+	render := func(opts LoggingOptions) map[string]interface{} {
+		manifests, err := LoggingManifests(opts)
+		require.NoError(t, err)
+		documents, err := decodeDocuments(manifests)
+		require.NoError(t, err)
+		require.Len(t, documents, 1)
+		return lookupValue(documents[0], "spec", "source", "helm", "valuesObject").(map[string]interface{})
+	}
+
+	values := render(LoggingOptions{ClusterName: "homelab", Retention: 7 * 24 * time.Hour})
+	assert.Equal(t, "168h", lookupValue(values, "loki", "config", "limits_config", "retention_period"))
+	assert.Equal(t, map[string]interface{}{"enabled": false}, values["grafana"])
+
+	relabel := lookupValue(values, "promtail", "config", "snippets", "extraRelabelConfigs").([]interface{})
+	assert.Contains(t, relabel, map[string]interface{}{"target_label": "cluster", "replacement": "homelab"})
+	assert.Contains(t, relabel, map[string]interface{}{
+		"source_labels": []interface{}{"__meta_kubernetes_namespace"},
+		"regex":         "vcluster-(.+)",
+		"target_label":  "environment",
+		"replacement":   "$1",
+	})
+
+	values = render(LoggingOptions{ClusterName: "homelab", Retention: 24 * time.Hour, Grafana: true, DomainName: "example.com", Ingress: PlatformIngress{Issuer: WildcardClusterIssuer}})
+	grafana := values["grafana"].(map[string]interface{})
+	assert.Equal(t, true, grafana["enabled"])
+	assert.Equal(t, []interface{}{"grafana.example.com"}, lookupValue(grafana, "ingress", "hosts"))
+	datasource := lookupValue(grafana, "datasources", "datasources.yaml", "datasources").([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, LokiURL(), datasource["url"])
+}
+
+func TestObservabilityLokiDataSource(t *testing.T) {
+	manifests, err := ObservabilityManifests(ObservabilityOptions{DomainName: "example.com", Loki: true})
+	require.NoError(t, err)
+	documents, err := decodeDocuments(manifests)
+	require.NoError(t, err)
+
+	datasources := lookupValue(documents[0], "spec", "source", "helm", "valuesObject", "grafana", "additionalDataSources").([]interface{})
+	assert.Equal(t, "loki", datasources[0].(map[string]interface{})["type"])
+}
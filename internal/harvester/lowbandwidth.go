@@ -0,0 +1,329 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
+)
+
+// LowBandwidthOptionalFlags are the create flags of the optional components
+// --low-bandwidth turns off unless they are set explicitly
+var LowBandwidthOptionalFlags = []string{"install-kubefirst-pro", "install-istio", "install-kgateway"}
+
+// SlimTagSuffixes are the tag suffixes of the slim variants of an image,
+// in order of preference
+var SlimTagSuffixes = []string{"-slim", "-distroless"}
+
+// lowBandwidthCharts are the chart values --low-bandwidth sets to run the
+// platform charts with a single replica, by chart name
+var lowBandwidthCharts = map[string][]chartValue{
+	"argo-cd": {
+		{path: []string{"redis-ha", "enabled"}, value: false},
+		{path: []string{"controller", "replicas"}, value: 1},
+		{path: []string{"server", "replicas"}, value: 1},
+		{path: []string{"repoServer", "replicas"}, value: 1},
+		{path: []string{"applicationSet", "replicas"}, value: 1},
+	},
+	"cert-manager": {
+		{path: []string{"replicaCount"}, value: 1},
+		{path: []string{"webhook", "replicaCount"}, value: 1},
+		{path: []string{"cainjector", "replicaCount"}, value: 1},
+	},
+	"istiod": {
+		{path: []string{"pilot", "replicaCount"}, value: 1},
+		{path: []string{"pilot", "autoscaleEnabled"}, value: false},
+	},
+	"kgateway": {{path: []string{"controller", "replicaCount"}, value: 1}},
+	"vault":    {{path: []string{"server", "ha", "replicas"}, value: 1}},
+}
+
+// manifestListMediaType is the docker counterpart of an OCI image index
+const manifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+// ImageLayers looks up the layers of container images
+type ImageLayers interface {
+	// Layers returns the blobs pulling image downloads
+	Layers(ctx context.Context, image string) ([]ocispec.Descriptor, error)
+}
+
+// registryImageLayers reads image manifests from their registries, or from
+// the registry mirror they are pulled through
+type registryImageLayers struct {
+	httpClient *http.Client
+	mirror     string
+}
+
+// NewRegistryImageLayers returns ImageLayers reading the manifests of the
+// linux/amd64 images from their registries over httpClient, authenticated
+// with the docker credentials of OCICredential, through mirror when it is
+// set
+func NewRegistryImageLayers(httpClient *http.Client, mirror string) ImageLayers {
+	return &registryImageLayers{httpClient: httpClient, mirror: mirror}
+}
+
+func (r *registryImageLayers) Layers(ctx context.Context, image string) ([]ocispec.Descriptor, error) {
+	ref, err := registry.ParseReference(qualifiedImage(MirrorImage(image, r.mirror)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %q: %w", image, err)
+	}
+	host := ref.Registry
+	if host == dockerHub {
+		host = "registry-1." + dockerHub
+	}
+
+	repo, err := remote.NewRepository(host + "/" + ref.Repository)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %q: %w", image, err)
+	}
+	repo.Client = &auth.Client{
+		Client: r.httpClient,
+		Cache:  auth.NewCache(),
+		Credential: func(ctx context.Context, _ string) (auth.Credential, error) {
+			return OCICredential(ctx, ref)
+		},
+	}
+
+	desc, manifest, err := fetchManifest(ctx, repo, ref.Reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest of %s: %w", image, err)
+	}
+	if desc.MediaType == ocispec.MediaTypeImageIndex || desc.MediaType == manifestListMediaType {
+		var index ocispec.Index
+		if err := json.Unmarshal(manifest, &index); err != nil {
+			return nil, fmt.Errorf("failed to parse manifest list of %s: %w", image, err)
+		}
+		platform, err := platformManifest(index)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", image, err)
+		}
+		if _, manifest, err = fetchManifest(ctx, repo, platform.Digest.String()); err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest of %s: %w", image, err)
+		}
+	}
+
+	var parsed ocispec.Manifest
+	if err := json.Unmarshal(manifest, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %w", image, err)
+	}
+
+	return append([]ocispec.Descriptor{parsed.Config}, parsed.Layers...), nil
+}
+
+// fetchManifest fetches the manifest reference resolves to in repo
+func fetchManifest(ctx context.Context, repo *remote.Repository, reference string) (ocispec.Descriptor, []byte, error) {
+	desc, reader, err := repo.FetchReference(ctx, reference)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	defer reader.Close()
+
+	manifest, err := content.ReadAll(reader, desc)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+
+	return desc, manifest, nil
+}
+
+// platformManifest picks the linux/amd64 manifest of index, what the
+// Harvester nodes pull
+func platformManifest(index ocispec.Index) (ocispec.Descriptor, error) {
+	for _, manifest := range index.Manifests {
+		if manifest.Platform != nil && manifest.Platform.OS == "linux" && manifest.Platform.Architecture == "amd64" {
+			return manifest, nil
+		}
+	}
+
+	return ocispec.Descriptor{}, errors.New("no linux/amd64 image in the manifest list")
+}
+
+// DownloadImage is an image of a DownloadEstimate
+type DownloadImage struct {
+	Image string
+	// Pull is the image pulled in place of Image, its slim variant when
+	// there is one
+	Pull string
+	// Size is the compressed size of the layers of Pull not already
+	// counted for an earlier image
+	Size int64
+	// Err is why the size of Pull is unknown
+	Err error
+}
+
+// DownloadEstimate is the data the nodes download to pull the images of
+// the platform
+type DownloadEstimate struct {
+	Images []DownloadImage
+	Total  int64
+}
+
+// EstimateDownload sums the compressed layers of images, the layers shared
+// between images counting once. With slim, every image is replaced by the
+// first variant of SlimTagSuffixes its registry has
+func EstimateDownload(ctx context.Context, layers ImageLayers, images []string, slim bool) DownloadEstimate {
+	var estimate DownloadEstimate
+	counted := map[string]bool{}
+	for _, image := range images {
+		download := DownloadImage{Image: image, Pull: image}
+
+		var blobs []ocispec.Descriptor
+		found := false
+		if slim {
+			for _, variant := range slimVariants(image) {
+				var err error
+				if blobs, err = layers.Layers(ctx, variant); err == nil {
+					download.Pull, found = variant, true
+					break
+				}
+			}
+		}
+		if !found {
+			var err error
+			if blobs, err = layers.Layers(ctx, image); err != nil {
+				download.Err = err
+			}
+		}
+
+		for _, blob := range blobs {
+			if !counted[blob.Digest.String()] {
+				counted[blob.Digest.String()] = true
+				download.Size += blob.Size
+			}
+		}
+		estimate.Total += download.Size
+		estimate.Images = append(estimate.Images, download)
+	}
+
+	return estimate
+}
+
+// slimVariants returns the references of the slim variants of image, none
+// for images pinned by digest, without a tag or already slim
+func slimVariants(image string) []string {
+	if strings.Contains(image, "@") {
+		return nil
+	}
+	slash := strings.LastIndex(image, "/")
+	colon := strings.LastIndex(image, ":")
+	if colon <= slash {
+		return nil
+	}
+
+	variants := make([]string, 0, len(SlimTagSuffixes))
+	for _, suffix := range SlimTagSuffixes {
+		if strings.HasSuffix(image, suffix) {
+			return nil
+		}
+		variants = append(variants, image+suffix)
+	}
+
+	return variants
+}
+
+// SlimImages returns the images of e replaced by a slim variant, keyed by
+// the image they replace
+func (e DownloadEstimate) SlimImages() map[string]string {
+	slim := map[string]string{}
+	for _, image := range e.Images {
+		if image.Pull != image.Image {
+			slim[image.Image] = image.Pull
+		}
+	}
+
+	return slim
+}
+
+// Render prints the images with their download size and the total
+func (e DownloadEstimate) Render() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "IMAGE\tDOWNLOAD")
+	unknown := 0
+	for _, image := range e.Images {
+		name := image.Pull
+		if image.Pull != image.Image {
+			name = fmt.Sprintf("%s (slim variant of %s)", image.Pull, image.Image)
+		}
+		size := humanize.IBytes(uint64(image.Size))
+		if image.Err != nil {
+			size = "unknown"
+			unknown++
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, size)
+	}
+	fmt.Fprintf(w, "Total\t%s\n", humanize.IBytes(uint64(e.Total)))
+	w.Flush()
+
+	b.WriteString("\nThe images the charts assemble from their values are not counted")
+	if unknown > 0 {
+		fmt.Fprintf(&b, ", nor %d image(s) whose registry could not be read", unknown)
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// SlimImageFiles replaces the images of the manifests in files, keyed by
+// their path in the gitops repository, with their variant in slim, leaving
+// the rest of each file untouched. Only the files that changed are returned
+func SlimImageFiles(files map[string][]byte, slim map[string]string) map[string][]byte {
+	changed := map[string][]byte{}
+	if len(slim) == 0 {
+		return changed
+	}
+
+	for name, content := range files {
+		if path.Ext(name) != ".yaml" && path.Ext(name) != ".yml" {
+			continue
+		}
+
+		lines := strings.Split(string(content), "\n")
+		modified := false
+		for i, line := range lines {
+			match := imageLine.FindStringSubmatchIndex(line)
+			if match == nil {
+				continue
+			}
+			variant, ok := slim[line[match[2]:match[3]]]
+			if !ok {
+				continue
+			}
+			lines[i] = line[:match[2]] + variant + line[match[3]:]
+			modified = true
+		}
+
+		if modified {
+			changed[name] = []byte(strings.Join(lines, "\n"))
+		}
+	}
+
+	return changed
+}
+
+// LowBandwidthFiles sets the replica counts of the platform charts in the
+// helm values of the ArgoCD applications of files, keyed by their path in
+// the gitops repository, to a single replica. Only the files that changed
+// are returned
+func LowBandwidthFiles(files map[string][]byte) (map[string][]byte, error) {
+	return patchChartValues(files, func(_, chart string) []chartValue {
+		return lowBandwidthCharts[chart]
+	})
+}
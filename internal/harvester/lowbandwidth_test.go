@@ -0,0 +1,103 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeImageLayers serves the layers of the images it knows, shared layers
+// named alike
+type fakeImageLayers map[string][]string
+
+func (f fakeImageLayers) Layers(_ context.Context, image string) ([]ocispec.Descriptor, error) {
+	layers, ok := f[image]
+	if !ok {
+		return nil, fmt.Errorf("test error")
+	}
+
+	descriptors := make([]ocispec.Descriptor, 0, len(layers))
+	for _, layer := range layers {
+		descriptors = append(descriptors, ocispec.Descriptor{Digest: digest.FromString(layer), Size: int64(len(layer)) << 20})
+	}
+	return descriptors, nil
+}
+
+func TestEstimateDownload(t *testing.T) {
+	layers := fakeImageLayers{
+		"ghcr.io/kubefirst/api:v1.0.0":            {"base", "api-full-layer"},
+		"ghcr.io/kubefirst/api:v1.0.0-slim":       {"base", "api"},
+		"docker.io/library/redis:7.2":             {"base", "redis-layer"},
+		"docker.io/library/redis:7.2-distroless":  {"redis"},
+		"quay.io/argoproj/argocd@sha256:abc":      {"argocd-layer"},
+		"quay.io/argoproj/argocd@sha256:abc-slim": {"never"},
+	}
+	images := []string{"docker.io/library/redis:7.2", "ghcr.io/kubefirst/api:v1.0.0", "quay.io/argoproj/argocd@sha256:abc", "registry.internal/missing:1"}
+
+	estimate := EstimateDownload(context.Background(), layers, images, true)
+	require.Len(t, estimate.Images, 4)
+	assert.Equal(t, DownloadImage{Image: "docker.io/library/redis:7.2", Pull: "docker.io/library/redis:7.2-distroless", Size: 5 << 20}, estimate.Images[0])
+	assert.Equal(t, DownloadImage{Image: "ghcr.io/kubefirst/api:v1.0.0", Pull: "ghcr.io/kubefirst/api:v1.0.0-slim", Size: 7 << 20}, estimate.Images[1])
+	assert.Equal(t, "quay.io/argoproj/argocd@sha256:abc", estimate.Images[2].Pull)
+	require.Error(t, estimate.Images[3].Err)
+	assert.Equal(t, int64(24<<20), estimate.Total)
+	assert.Equal(t, map[string]string{
+		"docker.io/library/redis:7.2":  "docker.io/library/redis:7.2-distroless",
+		"ghcr.io/kubefirst/api:v1.0.0": "ghcr.io/kubefirst/api:v1.0.0-slim",
+	}, estimate.SlimImages())
+
+	assert.Equal(t, `IMAGE                                                                                 DOWNLOAD
+docker.io/library/redis:7.2-distroless (slim variant of docker.io/library/redis:7.2)  5.0 MiB
+ghcr.io/kubefirst/api:v1.0.0-slim (slim variant of ghcr.io/kubefirst/api:v1.0.0)      7.0 MiB
+quay.io/argoproj/argocd@sha256:abc                                                    12 MiB
+registry.internal/missing:1                                                           unknown
+Total                                                                                 24 MiB
+
+The images the charts assemble from their values are not counted, nor 1 image(s) whose registry could not be read
+`, estimate.Render())
+
+	// without slim the base layer is shared between redis and api
+	estimate = EstimateDownload(context.Background(), layers, images[:2], false)
+	assert.Empty(t, estimate.SlimImages())
+	assert.Equal(t, int64(29<<20), estimate.Total)
+}
+
+func TestSlimImageFiles(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kubefirst/api.yaml":    []byte("spec:\n  containers:\n  - name: api\n    image: \"ghcr.io/kubefirst/api:v1.0.0\" # pinned\n  - image: docker.io/library/redis:7.2\n"),
+		"registry/kubefirst/argocd.yaml": []byte("image: quay.io/argoproj/argocd:v2.12.0\n"),
+		"registry/kubefirst/README.md":   []byte("image: ghcr.io/kubefirst/api:v1.0.0\n"),
+	}
+
+	changed := SlimImageFiles(files, map[string]string{
+		"ghcr.io/kubefirst/api:v1.0.0": "ghcr.io/kubefirst/api:v1.0.0-slim",
+		"docker.io/library/redis:7.2":  "docker.io/library/redis:7.2-distroless",
+	})
+	assert.Equal(t, map[string][]byte{
+		"registry/kubefirst/api.yaml": []byte("spec:\n  containers:\n  - name: api\n    image: \"ghcr.io/kubefirst/api:v1.0.0-slim\" # pinned\n  - image: docker.io/library/redis:7.2-distroless\n"),
+	}, changed)
+	assert.Empty(t, SlimImageFiles(files, nil))
+}
+
+func TestLowBandwidthFiles(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kubefirst/argocd.yaml": []byte("kind: Application\nmetadata:\n  name: argocd\nspec:\n  source:\n    chart: argo-cd\n    helm:\n      values: |\n        redis-ha:\n          enabled: true\n"),
+		"registry/kubefirst/vault.yaml":  []byte("kind: Application\nmetadata:\n  name: vault\nspec:\n  source:\n    chart: vault\n"),
+		"registry/kubefirst/other.yaml":  []byte("kind: Application\nmetadata:\n  name: other\nspec:\n  source:\n    chart: other\n"),
+	}
+
+	changed, err := LowBandwidthFiles(files)
+	require.NoError(t, err)
+	require.Len(t, changed, 2)
+	assert.Contains(t, string(changed["registry/kubefirst/argocd.yaml"]), "        redis-ha:\n          enabled: false\n")
+	assert.Contains(t, string(changed["registry/kubefirst/argocd.yaml"]), "        repoServer:\n          replicas: 1\n")
+
+	documents, err := decodeDocuments(changed["registry/kubefirst/vault.yaml"])
+	require.NoError(t, err)
+	assert.Equal(t, 1, lookupValue(documents[0], "spec", "source", "helm", "valuesObject", "server", "ha", "replicas"))
+}
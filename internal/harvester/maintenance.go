@@ -0,0 +1,429 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// harvesterMaintainStatusAnnotation is set by Harvester on the nodes it
+// puts into maintenance mode, while it drains them and once it has
+const harvesterMaintainStatusAnnotation = "harvesterhci.io/maintain-status"
+
+// Impacts of draining a node on a platform component
+const (
+	// MaintenanceReduced components keep ready replicas on other nodes
+	MaintenanceReduced = "reduced"
+	// MaintenanceMovable components only run on the node, prepare --migrate
+	// moves them off it first
+	MaintenanceMovable = "movable"
+	// MaintenanceDisrupted components only run on the node and go down with
+	// the drain
+	MaintenanceDisrupted = "disrupted"
+)
+
+// MaintenanceWorkload is a platform workload maintenance checks, Movable
+// telling whether its pods may be rescheduled onto another node without
+// losing it, as Vault is when it cannot unseal itself
+type MaintenanceWorkload struct {
+	Workload
+	Movable bool
+}
+
+// MaintenanceWorkloads returns the platform workloads running as Deployments
+// or StatefulSets: ArgoCD, components, whose StatefulSets are movable but
+// Vault without autoUnseal, and the control plane of every vcluster
+func MaintenanceWorkloads(components []PlatformComponent, vclusters []string, vaultAutoUnseal string) []MaintenanceWorkload {
+	workloads := []MaintenanceWorkload{
+		{Workload: Workload{Kind: "Deployment", Namespace: ArgoCDNamespace, Name: argoCDServerDeployment}, Movable: true},
+		{Workload: Workload{Kind: "StatefulSet", Namespace: ArgoCDNamespace, Name: "argocd-application-controller"}, Movable: true},
+	}
+	for _, component := range components {
+		movable := !(component.Namespace == vaultNamespace && component.Name == "vault" && vaultAutoUnseal == "")
+		workloads = append(workloads, MaintenanceWorkload{Workload: Workload(component), Movable: movable})
+	}
+	for _, vcluster := range vclusters {
+		workloads = append(workloads, MaintenanceWorkload{Workload: Workload{Kind: "StatefulSet", Namespace: VClusterNamespace(vcluster), Name: vcluster}, Movable: true})
+	}
+
+	return workloads
+}
+
+// MaintenanceComponent is where the ready pods of a workload run relative
+// to the node going into maintenance
+type MaintenanceComponent struct {
+	MaintenanceWorkload
+	OnNode    int
+	Elsewhere int
+	Impact    string
+}
+
+// Action tells what prepare --migrate does about the component
+func (c MaintenanceComponent) Action() string {
+	switch {
+	case c.Impact == MaintenanceReduced:
+		return "none, replicas keep serving from other nodes"
+	case c.Impact == MaintenanceDisrupted && c.Kind == "StatefulSet" && c.Namespace == vaultNamespace:
+		return "none, Vault comes back sealed: unseal it once rescheduled"
+	case c.Impact == MaintenanceDisrupted:
+		return "none"
+	case c.Kind == "Deployment":
+		return "restart, surging a replica onto another node first"
+	case strings.HasPrefix(c.Namespace, "vcluster-"):
+		return "reschedule the vcluster control plane, its API is down while it moves"
+	default:
+		return "reschedule onto another node, down while it moves"
+	}
+}
+
+// MaintenanceReport lists the platform components running on a node, as
+// prepare prints them
+type MaintenanceReport struct {
+	Node        string
+	Cordoned    bool
+	Maintenance string
+	Components  []MaintenanceComponent
+}
+
+// Singletons returns the components that only run on the node
+func (r *MaintenanceReport) Singletons() []MaintenanceComponent {
+	var singletons []MaintenanceComponent
+	for _, component := range r.Components {
+		if component.Impact != MaintenanceReduced {
+			singletons = append(singletons, component)
+		}
+	}
+
+	return singletons
+}
+
+// Render renders the report as a table
+func (r *MaintenanceReport) Render() string {
+	if len(r.Components) == 0 {
+		return fmt.Sprintf("No platform component runs on node %s, it can be drained\n", r.Node)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMPONENT\tON NODE\tELSEWHERE\tIMPACT\tACTION")
+	for _, component := range r.Components {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", component.Workload, component.OnNode, component.Elsewhere, component.Impact, component.Action())
+	}
+	w.Flush()
+
+	return b.String()
+}
+
+// unschedulableReason tells why node takes no new pods, empty when it does
+func unschedulableReason(node *corev1.Node) string {
+	if status := node.Annotations[harvesterMaintainStatusAnnotation]; status != "" {
+		return fmt.Sprintf("in Harvester maintenance mode (%s)", status)
+	}
+	if node.Spec.Unschedulable {
+		return "cordoned"
+	}
+
+	return ""
+}
+
+// MaintenanceImpact reports the workloads with ready pods on node and what
+// draining it does to them. Workloads that are not installed are skipped
+func (c *Client) MaintenanceImpact(ctx context.Context, node string, workloads []MaintenanceWorkload) (*MaintenanceReport, error) {
+	target, err := c.Clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node %s: %w", node, err)
+	}
+
+	report := &MaintenanceReport{
+		Node:        node,
+		Cordoned:    target.Spec.Unschedulable,
+		Maintenance: target.Annotations[harvesterMaintainStatusAnnotation],
+	}
+	for _, workload := range workloads {
+		pods, found, err := c.workloadPods(ctx, workload.Workload)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		component := MaintenanceComponent{MaintenanceWorkload: workload}
+		for i := range pods {
+			switch {
+			case pods[i].Spec.NodeName == node:
+				component.OnNode++
+			case podReady(&pods[i]):
+				component.Elsewhere++
+			}
+		}
+		if component.OnNode == 0 {
+			continue
+		}
+
+		switch {
+		case component.Elsewhere > 0:
+			component.Impact = MaintenanceReduced
+		case workload.Movable:
+			component.Impact = MaintenanceMovable
+		default:
+			component.Impact = MaintenanceDisrupted
+		}
+		report.Components = append(report.Components, component)
+	}
+
+	return report, nil
+}
+
+// SingletonRisk is a workload whose ready pods all run on a node taking no
+// new pods, which a drain of the node takes down
+type SingletonRisk struct {
+	Workload Workload
+	Node     string
+	Reason   string
+}
+
+func (r SingletonRisk) String() string {
+	return fmt.Sprintf("%s only runs on node %s, which is %s", r.Workload, r.Node, r.Reason)
+}
+
+// SingletonRisks returns the workloads only running on cordoned or draining
+// nodes, for status to warn about
+func (c *Client) SingletonRisks(ctx context.Context, workloads []MaintenanceWorkload) ([]SingletonRisk, error) {
+	nodes, err := c.Clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	unschedulable := map[string]string{}
+	for i := range nodes.Items {
+		if reason := unschedulableReason(&nodes.Items[i]); reason != "" {
+			unschedulable[nodes.Items[i].Name] = reason
+		}
+	}
+	if len(unschedulable) == 0 {
+		return nil, nil
+	}
+
+	var risks []SingletonRisk
+	for _, workload := range workloads {
+		pods, found, err := c.workloadPods(ctx, workload.Workload)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+
+		var on []string
+		for i := range pods {
+			if podReady(&pods[i]) && !slices.Contains(on, pods[i].Spec.NodeName) {
+				on = append(on, pods[i].Spec.NodeName)
+			}
+		}
+		if len(on) != 1 {
+			continue
+		}
+		if reason, ok := unschedulable[on[0]]; ok {
+			risks = append(risks, SingletonRisk{Workload: workload.Workload, Node: on[0], Reason: reason})
+		}
+	}
+
+	return risks, nil
+}
+
+// workloadPods returns the pods of workload that are not terminating,
+// reporting false when the workload does not exist
+func (c *Client) workloadPods(ctx context.Context, workload Workload) ([]corev1.Pod, bool, error) {
+	var selector *metav1.LabelSelector
+	switch workload.Kind {
+	case "Deployment":
+		deployment, err := c.Clientset.AppsV1().Deployments(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read %s: %w", workload, err)
+		}
+		selector = deployment.Spec.Selector
+	case "StatefulSet":
+		statefulSet, err := c.Clientset.AppsV1().StatefulSets(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read %s: %w", workload, err)
+		}
+		selector = statefulSet.Spec.Selector
+	default:
+		return nil, false, fmt.Errorf("unsupported workload kind %q", workload.Kind)
+	}
+
+	podSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil || podSelector.Empty() {
+		podSelector = labels.Nothing()
+	}
+	pods, err := c.Clientset.CoreV1().Pods(workload.Namespace).List(ctx, metav1.ListOptions{LabelSelector: podSelector.String()})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list the pods of %s: %w", workload, err)
+	}
+
+	var live []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.DeletionTimestamp == nil {
+			live = append(live, pod)
+		}
+	}
+
+	return live, true, nil
+}
+
+// SetNodeSchedulable cordons node, or uncordons it, reporting whether it
+// changed
+func (c *Client) SetNodeSchedulable(ctx context.Context, node string, schedulable bool) (bool, error) {
+	current, err := c.Clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to get node %s: %w", node, err)
+	}
+	if current.Spec.Unschedulable == !schedulable {
+		return false, nil
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{"spec": map[string]bool{"unschedulable": !schedulable}})
+	if err != nil {
+		return false, fmt.Errorf("failed to build node patch: %w", err)
+	}
+	if _, err := c.Clientset.CoreV1().Nodes().Patch(ctx, node, types.StrategicMergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager}); err != nil {
+		return false, fmt.Errorf("failed to patch node %s: %w", node, err)
+	}
+
+	return true, nil
+}
+
+// MoveOffNode moves the pods of a movable component off node, which has to
+// be cordoned so they do not come back: a Deployment is restarted, surging
+// its replacement elsewhere before the pod on node stops, the pods of a
+// StatefulSet are deleted for their controller to reschedule them. It
+// returns once no pod runs on node and one is ready elsewhere
+func (c *Client) MoveOffNode(ctx context.Context, component MaintenanceComponent, node string) error {
+	pods, _, err := c.workloadPods(ctx, component.Workload)
+	if err != nil {
+		return err
+	}
+
+	if component.Kind == "Deployment" {
+		if err := c.RestartWorkloads(ctx, []Workload{component.Workload}); err != nil {
+			return err
+		}
+	} else {
+		for _, pod := range pods {
+			if pod.Spec.NodeName != node {
+				continue
+			}
+			if err := c.Clientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete pod %s/%s: %w", pod.Namespace, pod.Name, err)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(nodePollInterval)
+	defer ticker.Stop()
+
+	for {
+		pods, _, err := c.workloadPods(ctx, component.Workload)
+		if err != nil {
+			return err
+		}
+		onNode, elsewhere := 0, 0
+		for i := range pods {
+			switch {
+			case pods[i].Spec.NodeName == node:
+				onNode++
+			case podReady(&pods[i]):
+				elsewhere++
+			}
+		}
+		if onNode == 0 && elsewhere > 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to move off node %s: %w", component.Workload, node, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// MaintenanceState is what prepare changed for the maintenance of Node,
+// for complete to reverse
+type MaintenanceState struct {
+	Node string `json:"node"`
+	// Cordoned is set when prepare cordoned the node, a node already
+	// cordoned being left so by complete
+	Cordoned bool      `json:"cordoned"`
+	Moved    []string  `json:"moved,omitempty"`
+	Prepared time.Time `json:"prepared"`
+}
+
+// MaintenanceStates are the nodes prepared for maintenance
+type MaintenanceStates []MaintenanceState
+
+// ParseMaintenanceStates parses the recorded maintenance states, an empty
+// value being none
+func ParseMaintenanceStates(value string) (MaintenanceStates, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var states MaintenanceStates
+	if err := json.Unmarshal([]byte(value), &states); err != nil {
+		return nil, fmt.Errorf("invalid maintenance state: %w", err)
+	}
+
+	return states, nil
+}
+
+// String encodes the states for the kubefirst config
+func (s MaintenanceStates) String() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// Find returns the state of node
+func (s MaintenanceStates) Find(node string) (MaintenanceState, bool) {
+	for _, state := range s {
+		if state.Node == node {
+			return state, true
+		}
+	}
+
+	return MaintenanceState{}, false
+}
+
+// Record sets the state of its node
+func (s MaintenanceStates) Record(state MaintenanceState) MaintenanceStates {
+	s = s.Remove(state.Node)
+	return append(s, state)
+}
+
+// Remove drops the state of node
+func (s MaintenanceStates) Remove(node string) MaintenanceStates {
+	return slices.DeleteFunc(slices.Clone(s), func(state MaintenanceState) bool { return state.Node == node })
+}
@@ -0,0 +1,133 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func maintenanceObjects(node1Cordoned bool) []runtime.Object {
+	host1 := newNode("host-1", true, true)
+	host1.Spec.Unschedulable = node1Cordoned
+	selector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+	pod := func(namespace, name, app, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: map[string]string{"app": app}},
+			Spec:       corev1.PodSpec{NodeName: node},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}},
+		}
+	}
+
+	return []runtime.Object{
+		host1,
+		newNode("host-2", true, false),
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: ArgoCDNamespace, Name: argoCDServerDeployment}, Spec: appsv1.DeploymentSpec{Selector: selector("argocd-server")}},
+		pod(ArgoCDNamespace, "argocd-server-a", "argocd-server", "host-1"),
+		pod(ArgoCDNamespace, "argocd-server-b", "argocd-server", "host-2"),
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: vaultNamespace, Name: "vault"}, Spec: appsv1.StatefulSetSpec{Selector: selector("vault")}},
+		pod(vaultNamespace, "vault-0", "vault", "host-1"),
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: VClusterNamespace("dev"), Name: "dev"}, Spec: appsv1.StatefulSetSpec{Selector: selector("vcluster")}},
+		pod(VClusterNamespace("dev"), "dev-0", "vcluster", "host-1"),
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Namespace: VClusterNamespace("prod"), Name: "prod"}, Spec: appsv1.StatefulSetSpec{Selector: selector("vcluster")}},
+		pod(VClusterNamespace("prod"), "prod-0", "vcluster", "host-2"),
+	}
+}
+
+func TestMaintenanceImpact(t *testing.T) {
+	client := &Client{Clientset: fake.NewSimpleClientset(maintenanceObjects(false)...)}
+	components := PlatformComponents(false, false, true, false)
+
+	report, err := client.MaintenanceImpact(context.Background(), "host-1", MaintenanceWorkloads(components, []string{"dev", "prod"}, ""))
+	require.NoError(t, err)
+
+	impacts := map[string]string{}
+	for _, component := range report.Components {
+		impacts[component.Workload.String()] = component.Impact
+	}
+	assert.Equal(t, map[string]string{
+		"deployment argocd/argocd-server": MaintenanceReduced,
+		"statefulset vault/vault":         MaintenanceDisrupted,
+		"statefulset vcluster-dev/dev":    MaintenanceMovable,
+	}, impacts)
+	assert.Len(t, report.Singletons(), 2)
+	assert.Contains(t, report.Render(), "unseal it once rescheduled")
+
+	report, err = client.MaintenanceImpact(context.Background(), "host-1", MaintenanceWorkloads(components, nil, VaultUnsealStatic))
+	require.NoError(t, err)
+	require.Len(t, report.Singletons(), 1)
+	assert.Equal(t, MaintenanceMovable, report.Singletons()[0].Impact)
+
+	_, err = client.MaintenanceImpact(context.Background(), "host-9", nil)
+	require.ErrorContains(t, err, "failed to get node host-9")
+}
+
+func TestSingletonRisks(t *testing.T) {
+	workloads := MaintenanceWorkloads(PlatformComponents(false, false, true, false), []string{"dev", "prod"}, "")
+
+	client := &Client{Clientset: fake.NewSimpleClientset(maintenanceObjects(false)...)}
+	risks, err := client.SingletonRisks(context.Background(), workloads)
+	require.NoError(t, err)
+	assert.Empty(t, risks)
+
+	client = &Client{Clientset: fake.NewSimpleClientset(maintenanceObjects(true)...)}
+	risks, err = client.SingletonRisks(context.Background(), workloads)
+	require.NoError(t, err)
+	require.Len(t, risks, 2)
+	assert.Equal(t, "statefulset vault/vault only runs on node host-1, which is cordoned", risks[0].String())
+	assert.Equal(t, "dev", risks[1].Workload.Name)
+}
+
+func TestSetNodeSchedulable(t *testing.T) {
+	client := &Client{Clientset: fake.NewSimpleClientset(maintenanceObjects(false)...)}
+	ctx := context.Background()
+
+	changed, err := client.SetNodeSchedulable(ctx, "host-1", false)
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	node, err := client.Clientset.CoreV1().Nodes().Get(ctx, "host-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.True(t, node.Spec.Unschedulable)
+
+	changed, err = client.SetNodeSchedulable(ctx, "host-1", false)
+	require.NoError(t, err)
+	assert.False(t, changed)
+
+	changed, err = client.SetNodeSchedulable(ctx, "host-1", true)
+	require.NoError(t, err)
+	assert.True(t, changed)
+}
+
+func TestMaintenanceStates(t *testing.T) {
+	states, err := ParseMaintenanceStates("")
+	require.NoError(t, err)
+	assert.Empty(t, states)
+
+	states = states.Record(MaintenanceState{Node: "host-1", Cordoned: true})
+	states = states.Record(MaintenanceState{Node: "host-2"})
+	states = states.Record(MaintenanceState{Node: "host-1", Moved: []string{"statefulset vcluster-dev/dev"}})
+
+	parsed, err := ParseMaintenanceStates(states.String())
+	require.NoError(t, err)
+	state, found := parsed.Find("host-1")
+	require.True(t, found)
+	assert.Equal(t, []string{"statefulset vcluster-dev/dev"}, state.Moved)
+	assert.False(t, state.Cordoned)
+
+	parsed = parsed.Remove("host-1")
+	_, found = parsed.Find("host-1")
+	assert.False(t, found)
+	assert.Len(t, parsed, 1)
+
+	_, err = ParseMaintenanceStates("{")
+	require.Error(t, err)
+}
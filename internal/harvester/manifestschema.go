@@ -0,0 +1,280 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ErrSchemaUnavailable is returned by a ManifestValidator that has no
+// schema to validate an object against. The object is skipped
+var ErrSchemaUnavailable = errors.New("no schema available")
+
+// clientSideGroups are the API groups of the documents read by tools, never
+// applied to a cluster
+var clientSideGroups = map[string]bool{
+	"kustomize.config.k8s.io": true,
+	"config.kubernetes.io":    true,
+}
+
+// ManifestValidator validates an object against the schema of its kind
+type ManifestValidator interface {
+	ValidateManifest(ctx context.Context, obj *unstructured.Unstructured) error
+}
+
+// ManifestValidation validates the manifests committed to the gitops
+// repository with Validator. The zero value checks nothing
+type ManifestValidation struct {
+	Validator ManifestValidator
+	// OnSkip is told about every document that is not validated
+	OnSkip func(message string)
+}
+
+// ManifestError is a document of a file rejected by its schema
+type ManifestError struct {
+	File string
+	// Line is where the document starts in File
+	Line int
+	Kind string
+	Name string
+	Err  error
+}
+
+func (e *ManifestError) Error() string {
+	return fmt.Sprintf("%s:%d: %s %s: %v", e.File, e.Line, e.Kind, e.Name, e.Err)
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// manifestDocument is an object of a manifest file
+type manifestDocument struct {
+	Line   int
+	Object *unstructured.Unstructured
+}
+
+// Check validates every document of the YAML files of files, keyed by their
+// path in the repository, returning a ManifestError per invalid document,
+// joined. Documents without an apiVersion and kind, such as Helm values, are
+// not manifests, files that do not parse as YAML, such as Helm templates,
+// and objects with ErrSchemaUnavailable are skipped with a notice
+func (v ManifestValidation) Check(ctx context.Context, files map[string][]byte) error {
+	if v.Validator == nil {
+		return nil
+	}
+	skip := func(format string, args ...interface{}) {
+		if v.OnSkip != nil {
+			v.OnSkip(fmt.Sprintf(format, args...))
+		}
+	}
+
+	var errs []error
+	for _, name := range sortedKeys(files) {
+		content := files[name]
+		if content == nil || (path.Ext(name) != ".yaml" && path.Ext(name) != ".yml") {
+			continue
+		}
+
+		documents, err := manifestDocuments(content)
+		if err != nil {
+			skip("%s not validated, it is not plain YAML: %v", name, err)
+			continue
+		}
+		for _, document := range documents {
+			obj := document.Object
+			err := v.Validator.ValidateManifest(ctx, obj)
+			if errors.Is(err, ErrSchemaUnavailable) {
+				skip("%s:%d: %s %s not validated: %v", name, document.Line, obj.GetKind(), obj.GetName(), err)
+				continue
+			}
+			if err != nil {
+				errs = append(errs, &ManifestError{File: name, Line: document.Line, Kind: obj.GetKind(), Name: obj.GetName(), Err: err})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("rendered manifests failed schema validation:\n%w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+// manifestDocuments returns the objects of the documents of content along
+// with the line they start at
+func manifestDocuments(content []byte) ([]manifestDocument, error) {
+	var documents []manifestDocument
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(node.Content) == 0 || node.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+
+		var fields map[string]interface{}
+		if err := node.Decode(&fields); err != nil {
+			return nil, err
+		}
+		apiVersion, _ := fields["apiVersion"].(string)
+		kind, _ := fields["kind"].(string)
+		if apiVersion == "" || kind == "" {
+			continue
+		}
+		if group, _ := schema.ParseGroupVersion(apiVersion); clientSideGroups[group.Group] {
+			continue
+		}
+
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.Content[0].Line, err)
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(data); err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.Content[0].Line, err)
+		}
+		documents = append(documents, manifestDocument{Line: node.Content[0].Line, Object: obj})
+	}
+}
+
+// clusterManifestValidator validates manifests with server-side dry runs
+// against the cluster of client, and against the bundled schemas for the
+// kinds the cluster does not serve yet
+type clusterManifestValidator struct {
+	client *Client
+	// resources caches the discovery of the group versions, nil when the
+	// cluster does not serve one
+	resources map[string]*metav1.APIResourceList
+}
+
+// ManifestValidator returns a ManifestValidator checking manifests against
+// the schemas of the cluster. The kinds whose CRDs are not installed yet
+// are checked against their BundledSchemas when there is one
+func (c *Client) ManifestValidator() ManifestValidator {
+	return &clusterManifestValidator{client: c, resources: map[string]*metav1.APIResourceList{}}
+}
+
+func (v *clusterManifestValidator) ValidateManifest(ctx context.Context, obj *unstructured.Unstructured) error {
+	resource, err := v.resource(obj.GetAPIVersion(), obj.GetKind())
+	if err != nil {
+		return err
+	}
+	if resource == nil {
+		schemas, err := BundledSchemas()
+		if err != nil {
+			return err
+		}
+		bundled, ok := schemas[obj.GetAPIVersion()+" "+obj.GetKind()]
+		if !ok {
+			return fmt.Errorf("%w: the CRD of %s is not installed yet", ErrSchemaUnavailable, obj.GetAPIVersion())
+		}
+		return bundled.Validate(obj)
+	}
+	if obj.GetName() == "" {
+		return fmt.Errorf("%w: it has no name to dry-run", ErrSchemaUnavailable)
+	}
+
+	gv, err := schema.ParseGroupVersion(obj.GetAPIVersion())
+	if err != nil {
+		return err
+	}
+	gvr := gv.WithResource(resource.Name)
+	namespace := ""
+	if resource.Namespaced {
+		namespace = obj.GetNamespace()
+		if namespace == "" {
+			namespace = metav1.NamespaceDefault
+		}
+	}
+
+	err = v.dryRun(ctx, gvr, namespace, obj)
+	if apierrors.IsNotFound(err) && resource.Namespaced && namespace != metav1.NamespaceDefault {
+		// the namespace is created by the sync of the object
+		err = v.dryRun(ctx, gvr, metav1.NamespaceDefault, obj)
+	}
+	switch {
+	case err == nil:
+		return nil
+	case apierrors.IsInvalid(err), apierrors.IsBadRequest(err):
+		return err
+	}
+
+	return fmt.Errorf("%w: dry run failed: %v", ErrSchemaUnavailable, err)
+}
+
+// resource returns the resource serving kind in apiVersion, nil when the
+// cluster serves none
+func (v *clusterManifestValidator) resource(apiVersion, kind string) (*metav1.APIResource, error) {
+	list, cached := v.resources[apiVersion]
+	if !cached {
+		var err error
+		list, err = v.client.Clientset.Discovery().ServerResourcesForGroupVersion(apiVersion)
+		if apierrors.IsNotFound(err) {
+			list, err = nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to discover %s: %v", ErrSchemaUnavailable, apiVersion, err)
+		}
+		v.resources[apiVersion] = list
+	}
+	if list == nil {
+		return nil, nil
+	}
+
+	for i, resource := range list.APIResources {
+		// subresources such as deployments/scale share the kind
+		if resource.Kind == kind && !strings.Contains(resource.Name, "/") {
+			return &list.APIResources[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// dryRun server-side applies obj in namespace with strict field validation,
+// persisting nothing
+func (v *clusterManifestValidator) dryRun(ctx context.Context, gvr schema.GroupVersionResource, namespace string, obj *unstructured.Unstructured) error {
+	obj = obj.DeepCopy()
+	if namespace != "" {
+		obj.SetNamespace(namespace)
+	}
+	data, err := obj.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode %s %s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+
+	force := true
+	_, err = v.client.Dynamic.Resource(gvr).Namespace(namespace).Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+		DryRun:          []string{metav1.DryRunAll},
+		Force:           &force,
+		FieldManager:    fieldManager,
+		FieldValidation: metav1.FieldValidationStrict,
+	})
+
+	return err
+}
@@ -0,0 +1,130 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestManifestDocuments(t *testing.T) {
+	documents, err := manifestDocuments([]byte("# values\nreplicas: 1\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\n- item\n---\n\napiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\n---\napiVersion: v1\nkind: Secret\nmetadata:\n  name: b\n"))
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	assert.Equal(t, 4, documents[0].Line)
+	assert.Equal(t, "a", documents[0].Object.GetName())
+	assert.Equal(t, 15, documents[1].Line)
+	assert.Equal(t, "Secret", documents[1].Object.GetKind())
+
+	_, err = manifestDocuments([]byte("metadata:\n  name: {{ .Values.name }}\n"))
+	require.Error(t, err)
+}
+
+func TestBundledSchemas(t *testing.T) {
+	schemas, err := BundledSchemas()
+	require.NoError(t, err)
+	assert.Equal(t, TrustManagerVersion, schemas["trust.cert-manager.io/v1alpha1 Bundle"].Version)
+	assert.Equal(t, KubePrometheusStackVersion, schemas["monitoring.coreos.com/v1 ServiceMonitor"].Version)
+
+	// the CRDs of every kind are missing, the bundled schemas are used
+	validation := ManifestValidation{Validator: (&Client{Clientset: fake.NewClientset()}).ManifestValidator()}
+	var skipped []string
+	validation.OnSkip = func(message string) { skipped = append(skipped, message) }
+
+	backup, err := BackupManifests(BackupConfig{Storage: BackupAzureBlob, Bucket: "velero", Schedule: "0 2 * * *", TTL: "168h", Config: map[string]string{"resourceGroup": "backups"}})
+	require.NoError(t, err)
+	pools, err := ParseLBPools(LBPoolName, nil, []string{"management:10.0.13.0/28"})
+	require.NoError(t, err)
+	lbPools, err := LBPoolManifests(pools)
+	require.NoError(t, err)
+	store, err := ClusterSecretStoreManifests(ExternalSecretsConfig{Backend: ExternalSecretsAWS, Settings: map[string]string{"region": "us-east-1"}})
+	require.NoError(t, err)
+	trust, err := TrustManagerManifests(nil)
+	require.NoError(t, err)
+	observability, err := ObservabilityManifests(ObservabilityOptions{DomainName: "example.com", Vault: true, Loki: true})
+	require.NoError(t, err)
+
+	require.NoError(t, validation.Check(context.Background(), map[string][]byte{
+		"backup.yaml":        backup,
+		"lb-pools.yaml":      lbPools,
+		"secret-store.yaml":  store,
+		"trust-manager.yaml": trust,
+		"observability.yaml": observability,
+	}))
+	for _, message := range skipped {
+		assert.Contains(t, message, "the CRD of v1 is not installed yet")
+	}
+
+	err = validation.Check(context.Background(), map[string][]byte{
+		"registry/backup.yaml": []byte("apiVersion: velero.io/v1\nkind: Schedule\nmetadata:\n  name: daily\nspec:\n  schedule: 0 2 * * *\n  paused: \"no\"\n  prune: true\n"),
+		"registry/pool.yaml":   []byte("apiVersion: metallb.io/v1beta1\nkind: IPAddressPool\nmetadata:\n  name: servers\nspec:\n  addresses: [10.0.12.0/24]\n"),
+	})
+	require.EqualError(t, err, "rendered manifests failed schema validation:\n"+
+		"registry/backup.yaml:1: Schedule daily: spec.template: Required value\n"+
+		"spec.paused: Invalid value: no: must be a boolean\n"+
+		"spec.prune: field not declared in schema")
+}
+
+func TestManifestValidationCheck(t *testing.T) {
+	applicationsGVR := schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+	clientset := fake.NewClientset()
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "argoproj.io/v1alpha1",
+		APIResources: []metav1.APIResource{{Name: "applications", Kind: "Application", Namespaced: true}, {Name: "applications/status", Kind: "Application"}},
+	}}
+	dynamic := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	var namespaces []string
+	dynamic.PrependReactor("patch", "applications", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		namespaces = append(namespaces, patch.GetNamespace())
+		switch patch.GetName() {
+		case "broken":
+			return true, nil, apierrors.NewInvalid(schema.GroupKind{Group: "argoproj.io", Kind: "Application"}, "broken", field.ErrorList{field.Required(field.NewPath("spec", "destination"), "")})
+		case "forbidden":
+			return true, nil, apierrors.NewForbidden(applicationsGVR.GroupResource(), "forbidden", fmt.Errorf("test error"))
+		}
+		return true, nil, nil
+	})
+
+	var skipped []string
+	validation := ManifestValidation{
+		Validator: (&Client{Clientset: clientset, Dynamic: dynamic}).ManifestValidator(),
+		OnSkip:    func(message string) { skipped = append(skipped, message) },
+	}
+
+	application := "apiVersion: argoproj.io/v1alpha1\nkind: Application\nmetadata:\n  name: %s\n  namespace: argocd\nspec:\n  project: default\n"
+	err := validation.Check(context.Background(), map[string][]byte{
+		"registry/apps.yaml":             []byte(fmt.Sprintf(application, "vault") + "---\n" + fmt.Sprintf(application, "broken")),
+		"registry/forbidden.yaml":        []byte(fmt.Sprintf(application, "forbidden")),
+		"registry/values.yaml":           []byte("server:\n  replicas: 3\n"),
+		"registry/chart/templates/a.yml": []byte("metadata:\n  name: {{ .Values.name }}\n"),
+		"registry/widget.yaml":           []byte("apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"),
+		"registry/README.md":             []byte("apiVersion: nope\n"),
+		"registry/removed.yaml":          nil,
+	})
+	require.Error(t, err)
+	var manifestErr *ManifestError
+	require.ErrorAs(t, err, &manifestErr)
+	assert.Equal(t, "registry/apps.yaml", manifestErr.File)
+	assert.Equal(t, 9, manifestErr.Line)
+	assert.Contains(t, err.Error(), "registry/apps.yaml:9: Application broken: ")
+	assert.Contains(t, err.Error(), "spec.destination: Required value")
+	assert.Equal(t, []string{"argocd", "argocd", "argocd"}, namespaces)
+
+	require.Len(t, skipped, 3)
+	assert.Contains(t, skipped[0], "registry/chart/templates/a.yml not validated, it is not plain YAML")
+	assert.Contains(t, skipped[1], "registry/forbidden.yaml:1: Application forbidden not validated: no schema available: dry run failed")
+	assert.Equal(t, "registry/widget.yaml:1: Widget w not validated: no schema available: the CRD of example.com/v1 is not installed yet", skipped[2])
+
+	require.NoError(t, ManifestValidation{}.Check(context.Background(), map[string][]byte{"a.yaml": []byte("kind: [\n")}))
+}
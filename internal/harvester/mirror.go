@@ -0,0 +1,190 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Images are pulled from a --registry-mirror under the registry they were
+// published to, e.g. with the mirror harbor.example.com/mirror
+//
+//	docker.io/istio/proxyv2       → harbor.example.com/mirror/docker.io/istio/proxyv2
+//	hashicorp/vault               → harbor.example.com/mirror/docker.io/hashicorp/vault
+//	ghcr.io/kgateway-dev/kgateway → harbor.example.com/mirror/ghcr.io/kgateway-dev/kgateway
+const dockerHub = "docker.io"
+
+// containerdRegistrySetting is the Harvester setting holding the registry
+// mirrors of the containerd of every node
+const containerdRegistrySetting = "containerd-registry"
+
+var harvesterSettingGVR = schema.GroupVersionResource{Group: "harvesterhci.io", Version: "v1beta1", Resource: "settings"}
+
+// MirroredRegistries are the public registries the nodes pull through a
+// --registry-mirror, whatever registry the image references of the
+// components kubefirst-api installs name
+var MirroredRegistries = []string{dockerHub, "ghcr.io", "quay.io", "registry.k8s.io"}
+
+// containerdRegistry is the value of the containerd-registry setting, the
+// registries.yaml of RKE2 as JSON
+type containerdRegistry struct {
+	Mirrors map[string]containerdMirror `json:"Mirrors"`
+	Configs map[string]json.RawMessage  `json:"Configs,omitempty"`
+}
+
+type containerdMirror struct {
+	Endpoints []string          `json:"Endpoints"`
+	Rewrites  map[string]string `json:"Rewrites,omitempty"`
+}
+
+// ValidateRegistryMirror ensures mirror is a registry host, optionally with
+// a port and a path prefix, without a scheme or a tag
+func ValidateRegistryMirror(mirror string) error {
+	if strings.Contains(mirror, "://") {
+		return fmt.Errorf("%q must be a registry host and path like harbor.example.com/mirror, without a scheme", mirror)
+	}
+
+	host, prefix, found := strings.Cut(strings.TrimSuffix(mirror, "/"), "/")
+	parsed, err := url.Parse("https://" + host)
+	if err != nil || parsed.Host != host || parsed.Hostname() == "" {
+		return fmt.Errorf("%q does not start with a registry host", mirror)
+	}
+	if strings.ContainsAny(prefix, ":@") || (found && slices.Contains(strings.Split(prefix, "/"), "")) {
+		return fmt.Errorf("%q must not hold a tag, a digest or empty path segments", mirror)
+	}
+
+	return nil
+}
+
+// MirrorImage returns the reference image is pulled as from mirror. Images
+// already on the mirror and any image without a mirror are left unchanged
+func MirrorImage(image, mirror string) string {
+	mirror = strings.TrimSuffix(mirror, "/")
+	if mirror == "" || strings.HasPrefix(image, mirror+"/") {
+		return image
+	}
+
+	return mirror + "/" + qualifiedImage(image)
+}
+
+// qualifiedImage prefixes image with the registry it is pulled from when it
+// is implicitly on Docker Hub
+func qualifiedImage(image string) string {
+	registry, repository, found := strings.Cut(image, "/")
+	switch {
+	case !found:
+		return dockerHub + "/library/" + image
+	case registry == "index.docker.io" || registry == "registry-1.docker.io":
+		return dockerHub + "/" + repository
+	case !strings.ContainsAny(registry, ".:") && registry != "localhost":
+		return dockerHub + "/" + image
+	default:
+		return image
+	}
+}
+
+// CheckRegistryMirror verifies the registry API of mirror answers. Anonymous
+// pulls may be refused, so an authentication challenge counts as reachable
+func CheckRegistryMirror(ctx context.Context, httpClient *http.Client, mirror string) error {
+	host, _, _ := strings.Cut(mirror, "/")
+	endpoint := fmt.Sprintf("https://%s/v2/", host)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request for %q: %w", endpoint, err)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("registry mirror %q is not reachable: %w", host, err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("registry mirror %q answered %s on %s, it does not look like a container registry", host, res.Status, endpoint)
+	}
+
+	return nil
+}
+
+// mirrorRegistry returns the mirror of source in the containerd-registry
+// setting: the host of mirror as endpoint, rewriting the repositories to
+// the path MirrorImage pulls them from
+func mirrorRegistry(source, mirror string) containerdMirror {
+	host, prefix, _ := strings.Cut(strings.TrimSuffix(mirror, "/"), "/")
+	repository := source + "/$1"
+	if prefix != "" {
+		repository = prefix + "/" + repository
+	}
+
+	return containerdMirror{
+		Endpoints: []string{"https://" + host},
+		Rewrites:  map[string]string{"^(.*)$": repository},
+	}
+}
+
+// ApplyContainerdRegistry points the containerd of the Harvester nodes at
+// mirror for every registry of MirroredRegistries, so the images of the
+// platform are pulled through it without rewriting their references. The
+// mirrors and configs of other registries already in the setting are kept
+func (c *Client) ApplyContainerdRegistry(ctx context.Context, mirror string) error {
+	settings := c.Dynamic.Resource(harvesterSettingGVR)
+
+	setting, err := settings.Get(ctx, containerdRegistrySetting, metav1.GetOptions{})
+	exists := err == nil
+	switch {
+	case apierrors.IsNotFound(err):
+		setting = &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": harvesterSettingGVR.GroupVersion().String(),
+			"kind":       "Setting",
+			"metadata":   map[string]interface{}{"name": containerdRegistrySetting},
+		}}
+	case err != nil:
+		return fmt.Errorf("failed to get setting %s: %w", containerdRegistrySetting, err)
+	}
+
+	registry := containerdRegistry{}
+	if value, _, _ := unstructured.NestedString(setting.Object, "value"); value != "" {
+		if err := json.Unmarshal([]byte(value), &registry); err != nil {
+			return fmt.Errorf("invalid setting %s: %w", containerdRegistrySetting, err)
+		}
+	}
+	if registry.Mirrors == nil {
+		registry.Mirrors = map[string]containerdMirror{}
+	}
+	for _, source := range MirroredRegistries {
+		registry.Mirrors[source] = mirrorRegistry(source, mirror)
+	}
+
+	value, err := json.Marshal(registry)
+	if err != nil {
+		return fmt.Errorf("failed to encode setting %s: %w", containerdRegistrySetting, err)
+	}
+	setting.Object["value"] = string(value)
+
+	if exists {
+		_, err = settings.Update(ctx, setting, metav1.UpdateOptions{FieldManager: fieldManager})
+	} else {
+		_, err = settings.Create(ctx, setting, metav1.CreateOptions{FieldManager: fieldManager})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write setting %s: %w", containerdRegistrySetting, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func TestMirrorImage(t *testing.T) {
+	mirror := "harbor.example.com/mirror"
+
+	for image, expected := range map[string]string{
+		"nginx:1.27":                          "harbor.example.com/mirror/docker.io/library/nginx:1.27",
+		"hashicorp/vault:1.17":                "harbor.example.com/mirror/docker.io/hashicorp/vault:1.17",
+		"docker.io/istio/proxyv2:1.24":        "harbor.example.com/mirror/docker.io/istio/proxyv2:1.24",
+		"index.docker.io/istio/pilot":         "harbor.example.com/mirror/docker.io/istio/pilot",
+		"ghcr.io/kgateway-dev/kgateway:v2":    "harbor.example.com/mirror/ghcr.io/kgateway-dev/kgateway:v2",
+		"harbor.example.com/mirror/ghcr.io/x": "harbor.example.com/mirror/ghcr.io/x",
+	} {
+		assert.Equal(t, expected, MirrorImage(image, mirror), image)
+	}
+
+	assert.Equal(t, "nginx", MirrorImage("nginx", ""))
+}
+
+func TestValidateRegistryMirror(t *testing.T) {
+	for _, mirror := range []string{"harbor.example.com", "harbor.example.com:8443/mirror", "10.0.0.5/proxy/"} {
+		assert.NoError(t, ValidateRegistryMirror(mirror), mirror)
+	}
+	for _, mirror := range []string{"https://harbor.example.com", "", "/mirror", "harbor.example.com/mirror:latest", "harbor.example.com//mirror"} {
+		assert.Error(t, ValidateRegistryMirror(mirror), mirror)
+	}
+}
+
+func TestCheckRegistryMirror(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	require.NoError(t, CheckRegistryMirror(context.Background(), server.Client(), host+"/mirror"))
+
+	server.Config.Handler = http.NotFoundHandler()
+	assert.ErrorContains(t, CheckRegistryMirror(context.Background(), server.Client(), host), "does not look like a container registry")
+}
+
+func TestApplyContainerdRegistry(t *testing.T) {
+	setting := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "harvesterhci.io/v1beta1",
+		"kind":       "Setting",
+		"metadata":   map[string]interface{}{"name": "containerd-registry"},
+		"value":      `{"Mirrors":{"registry.internal":{"Endpoints":["https://registry.internal"]}},"Configs":{"registry.internal":{"TLS":{"InsecureSkipVerify":true}}}}`,
+	}}
+	client := &Client{Dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), setting)}
+
+	require.NoError(t, client.ApplyContainerdRegistry(context.Background(), "harbor.example.com:8443/mirror"))
+
+	applied, err := client.Dynamic.Resource(harvesterSettingGVR).Get(context.Background(), "containerd-registry", metav1.GetOptions{})
+	require.NoError(t, err)
+	value, _, _ := unstructured.NestedString(applied.Object, "value")
+
+	var registry containerdRegistry
+	require.NoError(t, json.Unmarshal([]byte(value), &registry))
+	assert.Equal(t, containerdMirror{
+		Endpoints: []string{"https://harbor.example.com:8443"},
+		Rewrites:  map[string]string{"^(.*)$": "mirror/ghcr.io/$1"},
+	}, registry.Mirrors["ghcr.io"])
+	assert.Equal(t, "mirror/docker.io/$1", registry.Mirrors["docker.io"].Rewrites["^(.*)$"])
+	assert.Equal(t, []string{"https://registry.internal"}, registry.Mirrors["registry.internal"].Endpoints)
+	assert.Contains(t, registry.Configs, "registry.internal")
+
+	// a cluster without the setting gets it created
+	client = &Client{Dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())}
+	require.NoError(t, client.ApplyContainerdRegistry(context.Background(), "harbor.example.com"))
+	applied, err = client.Dynamic.Resource(harvesterSettingGVR).Get(context.Background(), "containerd-registry", metav1.GetOptions{})
+	require.NoError(t, err)
+	value, _, _ = unstructured.NestedString(applied.Object, "value")
+	assert.Contains(t, value, `"^(.*)$":"quay.io/$1"`)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	metav1apply "k8s.io/client-go/applyconfigurations/meta/v1"
+	networkingv1apply "k8s.io/client-go/applyconfigurations/networking/v1"
+)
+
+const (
+	// IstioNamespace is the namespace of the Istio control plane
+	IstioNamespace = "istio-system"
+
+	vclusterIsolationPolicy = "kubefirst-vcluster-isolation"
+	namespaceNameLabel      = "kubernetes.io/metadata.name"
+)
+
+// VClusterAllow permits traffic from the vcluster Source into the vcluster
+// Destination despite network isolation
+type VClusterAllow struct {
+	Source      string
+	Destination string
+}
+
+// ParseVClusterAllows parses the src:dst pairs of --allow-vcluster-to-vcluster,
+// both of which must be vclusters that will be created
+func ParseVClusterAllows(vclusters, pairs []string) ([]VClusterAllow, error) {
+	allows := make([]VClusterAllow, 0, len(pairs))
+	for _, pair := range pairs {
+		source, destination, ok := strings.Cut(pair, ":")
+		if !ok || source == "" || destination == "" {
+			return nil, fmt.Errorf("%q is not a src:dst pair", pair)
+		}
+		for _, vcluster := range []string{source, destination} {
+			if !slices.Contains(vclusters, vcluster) {
+				return nil, fmt.Errorf("%q in %q does not match any vcluster in --vclusters %v", vcluster, pair, vclusters)
+			}
+		}
+		if source == destination {
+			return nil, fmt.Errorf("%q allows a vcluster to itself, which isolation never blocks", pair)
+		}
+
+		allows = append(allows, VClusterAllow{Source: source, Destination: destination})
+	}
+
+	return allows, nil
+}
+
+func vclusterAllowPolicy(source string) string {
+	return "kubefirst-allow-from-vcluster-" + source
+}
+
+func namespaceSelector(namespaces ...string) *networkingv1apply.NetworkPolicyPeerApplyConfiguration {
+	return networkingv1apply.NetworkPolicyPeer().WithNamespaceSelector(
+		metav1apply.LabelSelector().WithMatchExpressions(
+			metav1apply.LabelSelectorRequirement().
+				WithKey(namespaceNameLabel).
+				WithOperator(metav1.LabelSelectorOpIn).
+				WithValues(namespaces...),
+		),
+	)
+}
+
+// VClusterNetworkPolicies renders the policies isolating the host namespace
+// of every vcluster. Ingress from the other vcluster namespaces is denied,
+// while the vcluster's own namespace, Istio, ArgoCD and the remaining host
+// namespaces such as the ingress gateway are still allowed. Each allow adds
+// a policy in the destination namespace admitting the source namespace
+func VClusterNetworkPolicies(vclusters []string, allows []VClusterAllow) []*networkingv1apply.NetworkPolicyApplyConfiguration {
+	namespaces := make([]string, 0, len(vclusters))
+	for _, vcluster := range vclusters {
+		namespaces = append(namespaces, VClusterNamespace(vcluster))
+	}
+
+	policies := make([]*networkingv1apply.NetworkPolicyApplyConfiguration, 0, len(vclusters)+len(allows))
+	for _, vcluster := range vclusters {
+		namespace := VClusterNamespace(vcluster)
+		others := slices.DeleteFunc(slices.Clone(namespaces), func(name string) bool { return name == namespace })
+
+		// every host namespace but the other vclusters, e.g. the ingress
+		// gateway, keeps reaching the vcluster
+		hostSelector := metav1apply.LabelSelector()
+		if len(others) > 0 {
+			hostSelector.WithMatchExpressions(metav1apply.LabelSelectorRequirement().
+				WithKey(namespaceNameLabel).
+				WithOperator(metav1.LabelSelectorOpNotIn).
+				WithValues(others...))
+		}
+		host := networkingv1apply.NetworkPolicyPeer().WithNamespaceSelector(hostSelector)
+
+		policies = append(policies, networkingv1apply.NetworkPolicy(vclusterIsolationPolicy, namespace).
+			WithSpec(networkingv1apply.NetworkPolicySpec().
+				WithPodSelector(metav1apply.LabelSelector()).
+				WithPolicyTypes(networkingv1.PolicyTypeIngress).
+				WithIngress(
+					networkingv1apply.NetworkPolicyIngressRule().WithFrom(
+						networkingv1apply.NetworkPolicyPeer().WithPodSelector(metav1apply.LabelSelector()),
+						namespaceSelector(IstioNamespace, ArgoCDNamespace),
+					),
+					networkingv1apply.NetworkPolicyIngressRule().WithFrom(host),
+				),
+			))
+	}
+
+	for _, allow := range allows {
+		policies = append(policies, networkingv1apply.NetworkPolicy(vclusterAllowPolicy(allow.Source), VClusterNamespace(allow.Destination)).
+			WithSpec(networkingv1apply.NetworkPolicySpec().
+				WithPodSelector(metav1apply.LabelSelector()).
+				WithPolicyTypes(networkingv1.PolicyTypeIngress).
+				WithIngress(networkingv1apply.NetworkPolicyIngressRule().WithFrom(
+					namespaceSelector(VClusterNamespace(allow.Source)),
+				)),
+			))
+	}
+
+	return policies
+}
+
+// ApplyVClusterNetworkPolicies applies the isolation and allow policies of
+// the vclusters once their host namespaces exist, reading every policy back
+// to confirm it was created
+func (c *Client) ApplyVClusterNetworkPolicies(ctx context.Context, vclusters []string, allows []VClusterAllow) error {
+	for _, vcluster := range vclusters {
+		namespace := VClusterNamespace(vcluster)
+		if _, err := c.Clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("failed to get namespace %q of vcluster %q: %w", namespace, vcluster, err)
+		}
+	}
+
+	for _, policy := range VClusterNetworkPolicies(vclusters, allows) {
+		name, namespace := *policy.Name, *policy.Namespace
+
+		_, err := c.Clientset.NetworkingV1().NetworkPolicies(namespace).Apply(ctx, policy, metav1.ApplyOptions{
+			FieldManager: fieldManager,
+			Force:        true,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply network policy %s/%s: %w", namespace, name, err)
+		}
+
+		if _, err := c.Clientset.NetworkingV1().NetworkPolicies(namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("network policy %s/%s was not created: %w", namespace, name, err)
+		}
+	}
+
+	return nil
+}
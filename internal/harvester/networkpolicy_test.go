@@ -0,0 +1,50 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseVClusterAllows(t *testing.T) {
+	vclusters := []string{"dev", "test", "prod"}
+
+	allows, err := ParseVClusterAllows(vclusters, []string{"dev:test", "test:prod"})
+	require.NoError(t, err)
+	assert.Equal(t, []VClusterAllow{{Source: "dev", Destination: "test"}, {Source: "test", Destination: "prod"}}, allows)
+
+	for _, pair := range []string{"dev", "dev:", "dev:staging", "dev:dev"} {
+		_, err := ParseVClusterAllows(vclusters, []string{pair})
+		assert.Error(t, err, pair)
+	}
+}
+
+func TestApplyVClusterNetworkPolicies(t *testing.T) {
+	clientset := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "vcluster-dev"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "vcluster-prod"}},
+	)
+	client := &Client{Clientset: clientset}
+
+	err := client.ApplyVClusterNetworkPolicies(context.Background(), []string{"dev", "prod"}, []VClusterAllow{{Source: "dev", Destination: "prod"}})
+	require.NoError(t, err)
+
+	isolation, err := clientset.NetworkingV1().NetworkPolicies("vcluster-dev").Get(context.Background(), vclusterIsolationPolicy, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Len(t, isolation.Spec.Ingress, 2)
+	assert.Equal(t, []string{IstioNamespace, ArgoCDNamespace}, isolation.Spec.Ingress[0].From[1].NamespaceSelector.MatchExpressions[0].Values)
+	assert.Equal(t, metav1.LabelSelectorOpNotIn, isolation.Spec.Ingress[1].From[0].NamespaceSelector.MatchExpressions[0].Operator)
+	assert.Equal(t, []string{"vcluster-prod"}, isolation.Spec.Ingress[1].From[0].NamespaceSelector.MatchExpressions[0].Values)
+
+	allow, err := clientset.NetworkingV1().NetworkPolicies("vcluster-prod").Get(context.Background(), vclusterAllowPolicy("dev"), metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vcluster-dev"}, allow.Spec.Ingress[0].From[0].NamespaceSelector.MatchExpressions[0].Values)
+
+	err = client.ApplyVClusterNetworkPolicies(context.Background(), []string{"test"}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,518 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/konstructio/kubefirst/internal/step"
+)
+
+// NotificationTimeout bounds every webhook POST, so an unreachable chat
+// service never holds up the CLI
+const NotificationTimeout = 10 * time.Second
+
+// Payload formats of the --notify-webhook-url hook, as accepted by
+// --notify-format
+const (
+	NotifyFormatSlack   = "slack"
+	NotifyFormatDiscord = "discord"
+	NotifyFormatGeneric = "generic"
+)
+
+var NotifyFormats = []string{NotifyFormatSlack, NotifyFormatDiscord, NotifyFormatGeneric}
+
+// Events the --notify-webhook-url hook receives, as accepted by --notify-on
+const (
+	NotifyOnFailure = "failure"
+	NotifyOnAll     = "all"
+)
+
+var NotifyOnValues = []string{NotifyOnFailure, NotifyOnAll}
+
+// PhaseRecord is a finished provisioning phase
+type PhaseRecord struct {
+	Name     string
+	Status   step.StepStatus
+	Duration time.Duration
+}
+
+// PhaseTracker turns step events into the phases of a provisioning run
+type PhaseTracker struct {
+	mu      sync.Mutex
+	started map[string]time.Time
+	phases  []PhaseRecord
+	percent int
+}
+
+// NewPhaseTracker returns an empty PhaseTracker
+func NewPhaseTracker() *PhaseTracker {
+	return &PhaseTracker{started: map[string]time.Time{}}
+}
+
+// Observe records event, returning the phase it finished if it finished one
+func (t *PhaseTracker) Observe(event step.StepEvent) (PhaseRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.percent = max(t.percent, event.Percent)
+
+	switch event.Status {
+	case step.StatusRunning:
+		t.started[event.Phase] = event.Timestamp
+		return PhaseRecord{}, false
+	case step.StatusComplete, step.StatusFailed:
+		record := PhaseRecord{Name: event.Phase, Status: event.Status}
+		if started, ok := t.started[event.Phase]; ok {
+			record.Duration = event.Timestamp.Sub(started)
+			delete(t.started, event.Phase)
+		}
+		t.phases = append(t.phases, record)
+		return record, true
+	default:
+		return PhaseRecord{}, false
+	}
+}
+
+// Phases returns the finished phases in the order they finished
+func (t *PhaseTracker) Phases() []PhaseRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]PhaseRecord(nil), t.phases...)
+}
+
+// Percent returns the percent-complete of the latest event
+func (t *PhaseTracker) Percent() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.percent
+}
+
+// Notification is a provisioning event posted to chat webhooks. Start
+// notifications announce the run, final notifications report its outcome
+// and the others the phase that just finished
+type Notification struct {
+	ClusterName string
+	Start       bool
+	Final       bool
+	Succeeded   bool
+	Phase       string
+	Phases      []PhaseRecord
+	ArgoCDURL   string
+	Error       string
+	// Endpoints are the platform urls reported once the run succeeded
+	Endpoints []string
+	// AlertsEmails are the recipients of the alerts of the cluster, passed
+	// on to generic webhooks for routing
+	AlertsEmails []string
+	// Percent is the percent-complete of the run, passed on to generic
+	// webhooks
+	Percent int
+}
+
+func (n Notification) title() string {
+	switch {
+	case n.Start:
+		return fmt.Sprintf("kubefirst cluster %s started provisioning", n.ClusterName)
+	case !n.Final:
+		return fmt.Sprintf("kubefirst cluster %s finished %s", n.ClusterName, n.Phase)
+	case n.Succeeded:
+		return fmt.Sprintf("kubefirst cluster %s provisioned", n.ClusterName)
+	default:
+		return fmt.Sprintf("kubefirst cluster %s failed to provision", n.ClusterName)
+	}
+}
+
+func (p PhaseRecord) summary() string {
+	marker := step.EmojiCheck
+	if p.Status == step.StatusFailed {
+		marker = step.EmojiError
+	}
+
+	return fmt.Sprintf("%s %s", marker, p.Duration.Round(time.Second))
+}
+
+// SlackPayload renders n as a Slack Block Kit message
+func SlackPayload(n Notification) ([]byte, error) {
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": n.title()},
+		},
+	}
+
+	if len(n.Phases) > 0 {
+		lines := make([]string, 0, len(n.Phases))
+		for _, phase := range n.Phases {
+			lines = append(lines, fmt.Sprintf("• *%s* %s", phase.Name, phase.summary()))
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": strings.Join(lines, "\n")},
+		})
+	}
+
+	if n.Error != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": fmt.Sprintf("```%s```", n.Error)},
+		})
+	}
+
+	if n.Final && n.Succeeded && n.ArgoCDURL != "" {
+		blocks = append(blocks, map[string]interface{}{
+			"type": "actions",
+			"elements": []interface{}{map[string]interface{}{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": "Open ArgoCD"},
+				"url":  n.ArgoCDURL,
+			}},
+		})
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"text": n.title(), "blocks": blocks})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render slack message: %w", err)
+	}
+
+	return payload, nil
+}
+
+// TeamsPayload renders n as a Microsoft Teams message with an Adaptive Card
+func TeamsPayload(n Notification) ([]byte, error) {
+	body := []interface{}{
+		map[string]interface{}{"type": "TextBlock", "size": "Medium", "weight": "Bolder", "wrap": true, "text": n.title()},
+	}
+
+	if len(n.Phases) > 0 {
+		facts := make([]interface{}, 0, len(n.Phases))
+		for _, phase := range n.Phases {
+			facts = append(facts, map[string]interface{}{"title": phase.Name, "value": phase.summary()})
+		}
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+
+	if n.Error != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "wrap": true, "color": "Attention", "text": n.Error})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body":    body,
+	}
+	if n.Final && n.Succeeded && n.ArgoCDURL != "" {
+		card["actions"] = []interface{}{map[string]interface{}{"type": "Action.OpenUrl", "title": "Open ArgoCD", "url": n.ArgoCDURL}}
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{map[string]interface{}{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render teams message: %w", err)
+	}
+
+	return payload, nil
+}
+
+// DiscordPayload renders n as a Discord message with an embed
+func DiscordPayload(n Notification) ([]byte, error) {
+	embed := map[string]interface{}{"title": n.title(), "color": 0x2eb67d}
+	if n.Error != "" || (n.Final && !n.Succeeded) {
+		embed["color"] = 0xe01e5a
+	}
+
+	var fields []interface{}
+	for _, phase := range n.Phases {
+		fields = append(fields, map[string]interface{}{"name": phase.Name, "value": phase.summary(), "inline": true})
+	}
+	if n.Final && n.Succeeded && len(n.Endpoints) > 0 {
+		fields = append(fields, map[string]interface{}{"name": "Endpoints", "value": strings.Join(n.Endpoints, "\n")})
+	}
+	if len(fields) > 0 {
+		embed["fields"] = fields
+	}
+	if n.Error != "" {
+		embed["description"] = fmt.Sprintf("```%s```", n.Error)
+	}
+	if n.Final && n.Succeeded && n.ArgoCDURL != "" {
+		embed["url"] = n.ArgoCDURL
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"content": n.title(), "embeds": []interface{}{embed}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render discord message: %w", err)
+	}
+
+	return payload, nil
+}
+
+// WebhookPayload renders n as the plain JSON document posted to generic
+// notification webhooks
+func WebhookPayload(n Notification) ([]byte, error) {
+	event, status := "final", "failed"
+	switch {
+	case n.Start:
+		event, status = "start", "started"
+	case !n.Final:
+		event = "phase"
+		if len(n.Phases) > 0 && n.Phases[0].Status == step.StatusComplete {
+			status = "succeeded"
+		}
+	case n.Succeeded:
+		status = "succeeded"
+	}
+
+	phases := make([]interface{}, 0, len(n.Phases))
+	for _, phase := range n.Phases {
+		phases = append(phases, map[string]interface{}{
+			"name":             phase.Name,
+			"status":           phase.Status,
+			"duration_seconds": int(phase.Duration.Round(time.Second).Seconds()),
+		})
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":         event,
+		"cluster_name":  n.ClusterName,
+		"phase":         n.Phase,
+		"status":        status,
+		"phases":        phases,
+		"argocd_url":    n.ArgoCDURL,
+		"error":         n.Error,
+		"alerts_emails": n.AlertsEmails,
+		"endpoints":     n.Endpoints,
+		"percent":       n.Percent,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render webhook payload: %w", err)
+	}
+
+	return payload, nil
+}
+
+// ParseAlertsEmails splits a comma-separated list of alerts email addresses,
+// validating each of them
+func ParseAlertsEmails(value string) ([]string, error) {
+	var emails []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return nil, fmt.Errorf("%q has an empty email address", value)
+		}
+
+		address, err := mail.ParseAddress(entry)
+		if err != nil || address.Address != entry {
+			return nil, fmt.Errorf("%q is not a valid email address", entry)
+		}
+		emails = append(emails, entry)
+	}
+
+	return emails, nil
+}
+
+// DetectNotifyFormat returns the payload format webhookURL expects: Slack
+// and Discord webhooks are recognized by their host, anything else gets
+// the generic JSON document
+func DetectNotifyFormat(webhookURL string) string {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil {
+		return NotifyFormatGeneric
+	}
+
+	switch host := parsed.Hostname(); {
+	case host == "hooks.slack.com":
+		return NotifyFormatSlack
+	case (host == "discord.com" || host == "discordapp.com") && strings.HasPrefix(parsed.Path, "/api/webhooks/"):
+		return NotifyFormatDiscord
+	default:
+		return NotifyFormatGeneric
+	}
+}
+
+// ValidateNotifyFormat ensures format is empty, to detect it from the url,
+// or a known payload format
+func ValidateNotifyFormat(format string) error {
+	if format == "" || slices.Contains(NotifyFormats, format) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown format %q, must be one of %v", format, NotifyFormats)
+}
+
+// ValidateNotifyOn ensures notifyOn selects known events
+func ValidateNotifyOn(notifyOn string) error {
+	if slices.Contains(NotifyOnValues, notifyOn) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown value %q, must be one of %v", notifyOn, NotifyOnValues)
+}
+
+// NotifyOnSelects reports whether the hook notified on notifyOn receives n:
+// every event with all, only the failed outcome with failure
+func NotifyOnSelects(notifyOn string, n Notification) bool {
+	if notifyOn == NotifyOnAll {
+		return true
+	}
+
+	return n.Final && !n.Succeeded
+}
+
+// ValidateWebhookURL ensures a chat webhook is an https url, as both Slack
+// and Teams webhook urls carry their credential
+func ValidateWebhookURL(webhookURL string) error {
+	parsed, err := url.Parse(webhookURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("webhook %q must be an https url", webhookURL)
+	}
+
+	return nil
+}
+
+type webhook struct {
+	name    string
+	url     string
+	payload func(Notification) ([]byte, error)
+	// finalOnly webhooks are only posted the outcome of the run
+	finalOnly bool
+}
+
+// Notifier posts notifications to the configured chat webhooks
+type Notifier struct {
+	webhooks   []webhook
+	httpClient *http.Client
+}
+
+// NewNotifier returns a Notifier for the Slack, Teams and generic webhooks
+// that are set, routed through proxy as described by ProxyFunc
+func NewNotifier(slackWebhook, teamsWebhook, genericWebhook, proxy string) (*Notifier, error) {
+	httpClient, err := NewHTTPClient(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &Notifier{httpClient: httpClient}
+	if slackWebhook != "" {
+		notifier.webhooks = append(notifier.webhooks, webhook{name: "slack", url: slackWebhook, payload: SlackPayload})
+	}
+	if teamsWebhook != "" {
+		notifier.webhooks = append(notifier.webhooks, webhook{name: "teams", url: teamsWebhook, payload: TeamsPayload})
+	}
+	if genericWebhook != "" {
+		notifier.webhooks = append(notifier.webhooks, webhook{name: "generic", url: genericWebhook, payload: WebhookPayload, finalOnly: true})
+	}
+
+	return notifier, nil
+}
+
+// NewHookNotifier returns a Notifier for the --notify-webhook-url hook,
+// posting payloads in format or the one DetectNotifyFormat finds when it is
+// empty
+func NewHookNotifier(webhookURL, format, proxy string) (*Notifier, error) {
+	httpClient, err := NewHTTPClient(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	notifier := &Notifier{httpClient: httpClient}
+	if webhookURL == "" {
+		return notifier, nil
+	}
+
+	if format == "" {
+		format = DetectNotifyFormat(webhookURL)
+	}
+	payload := WebhookPayload
+	switch format {
+	case NotifyFormatSlack:
+		payload = SlackPayload
+	case NotifyFormatDiscord:
+		payload = DiscordPayload
+	}
+	notifier.webhooks = append(notifier.webhooks, webhook{name: format, url: webhookURL, payload: payload})
+
+	return notifier, nil
+}
+
+// Enabled reports whether any webhook is configured
+func (n *Notifier) Enabled() bool {
+	return n != nil && len(n.webhooks) > 0
+}
+
+// Send posts notification to every webhook concurrently, each bounded by
+// NotificationTimeout. Generic webhooks only receive final notifications
+func (n *Notifier) Send(ctx context.Context, notification Notification) error {
+	if !n.Enabled() {
+		return nil
+	}
+
+	errs := make([]error, len(n.webhooks))
+
+	var wg sync.WaitGroup
+	for i, hook := range n.webhooks {
+		if hook.finalOnly && !notification.Final {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = n.post(ctx, hook, notification)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) post(ctx context.Context, hook webhook, notification Notification) error {
+	payload, err := hook.payload(notification)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, NotificationTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to build %s notification: %w", hook.name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post %s notification: %w", hook.name, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("%s webhook returned %q: %s", hook.name, res.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
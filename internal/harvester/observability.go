@@ -0,0 +1,403 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// ObservabilityApplication is the ArgoCD application installing
+	// kube-prometheus-stack, Grafana included, into ObservabilityNamespace
+	ObservabilityApplication   = "kube-prometheus-stack"
+	ObservabilityNamespace     = "monitoring"
+	KubePrometheusStackVersion = "65.1.1"
+
+	// GrafanaAdminSecret holds the Grafana admin password, kept out of the
+	// gitops repository
+	GrafanaAdminSecret = "grafana-admin"
+
+	kubePrometheusStackRepo = "https://prometheus-community.github.io/helm-charts"
+	grafanaService          = "grafana"
+	grafanaTLSSecret        = "grafana-tls"
+	grafanaAdminUser        = "admin"
+	grafanaDashboardLabel   = "grafana_dashboard"
+	prometheusService       = "kube-prometheus-stack-prometheus"
+	prometheusPort          = "9090"
+	certManagerIssuerKey    = "cert-manager.io/cluster-issuer"
+)
+
+// ObservabilityEnabled reports whether the observability phase runs: it was
+// asked for with --install-observability and --stop-after does not halt
+// before it
+func ObservabilityEnabled(stopAfter string, install bool) bool {
+	return install && PhaseEnabled(stopAfter, PhaseObservability)
+}
+
+// GrafanaHost is the hostname Grafana is served on
+func GrafanaHost(domainName string) string {
+	return "grafana." + domainName
+}
+
+// ObservabilityOptions are the platform components the observability phase
+// monitors and how Grafana is exposed
+type ObservabilityOptions struct {
+	DomainName string
+	VClusters  []string
+	Istio      bool
+	Kgateway   bool
+	Vault      bool
+	// Loki adds the Loki of the logging stack to the Grafana datasources
+	Loki bool
+	// Ingress is the ingress of the platform, Grafana is served the same way
+	Ingress PlatformIngress
+}
+
+// PlatformIngress is the ingress class and cert-manager issuer the ArgoCD
+// ingress of the platform uses
+type PlatformIngress struct {
+	Class  string
+	Issuer string
+}
+
+// ReadPlatformIngress returns the ingress class and issuer of the ingress
+// serving argocd.<domainName>, an issuer it does not name defaults to the
+// platform issuer
+func (c *Client) ReadPlatformIngress(ctx context.Context, domainName string) (PlatformIngress, error) {
+	ingresses, err := c.Clientset.NetworkingV1().Ingresses("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return PlatformIngress{}, fmt.Errorf("failed to list ingresses: %w", err)
+	}
+
+	host := fmt.Sprintf("argocd.%s", domainName)
+	for _, ingress := range ingresses.Items {
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != host {
+				continue
+			}
+
+			platform := PlatformIngress{Issuer: ingress.Annotations[certManagerIssuerKey]}
+			if ingress.Spec.IngressClassName != nil {
+				platform.Class = *ingress.Spec.IngressClassName
+			}
+			if platform.Issuer == "" {
+				platform.Issuer = WildcardClusterIssuer
+			}
+			return platform, nil
+		}
+	}
+
+	return PlatformIngress{}, fmt.Errorf("no ingress serves %q", host)
+}
+
+// ObservabilityManifests renders the ArgoCD application installing
+// kube-prometheus-stack with Grafana on GrafanaHost, the ServiceMonitors of
+// the platform components of opts and the platform dashboards
+func ObservabilityManifests(opts ObservabilityOptions) ([]byte, error) {
+	objects := []map[string]interface{}{kubePrometheusStackApplication(opts)}
+	objects = append(objects, platformServiceMonitors(opts)...)
+
+	dashboards := []struct {
+		name  string
+		model map[string]interface{}
+	}{
+		{"argocd-sync-status", argoCDSyncDashboard()},
+		{"vcluster-resource-usage", vclusterUsageDashboard()},
+	}
+	for _, dashboard := range dashboards {
+		content, err := json.MarshalIndent(dashboard.model, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to render dashboard %s: %w", dashboard.name, err)
+		}
+		objects = append(objects, map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":      "dashboard-" + dashboard.name,
+				"namespace": ObservabilityNamespace,
+				"labels":    map[string]string{grafanaDashboardLabel: "1"},
+			},
+			"data": map[string]string{dashboard.name + ".json": string(content)},
+		})
+	}
+
+	var manifests []string
+	for _, object := range objects {
+		manifest, err := yaml.Marshal(object)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render observability manifests: %w", err)
+		}
+		manifests = append(manifests, string(manifest))
+	}
+
+	return []byte(strings.Join(manifests, "---\n")), nil
+}
+
+func kubePrometheusStackApplication(opts ObservabilityOptions) map[string]interface{} {
+	host := GrafanaHost(opts.DomainName)
+
+	ingress := map[string]interface{}{
+		"enabled":     true,
+		"annotations": map[string]string{certManagerIssuerKey: opts.Ingress.Issuer},
+		"hosts":       []string{host},
+		"tls":         []interface{}{map[string]interface{}{"secretName": grafanaTLSSecret, "hosts": []string{host}}},
+	}
+	if opts.Ingress.Class != "" {
+		ingress["ingressClassName"] = opts.Ingress.Class
+	}
+
+	grafana := map[string]interface{}{
+		"fullnameOverride": grafanaService,
+		"admin": map[string]interface{}{
+			"existingSecret": GrafanaAdminSecret,
+			"userKey":        "admin-user",
+			"passwordKey":    "admin-password",
+		},
+		"ingress": ingress,
+		"sidecar": map[string]interface{}{
+			"dashboards": map[string]interface{}{"enabled": true, "label": grafanaDashboardLabel, "searchNamespace": ObservabilityNamespace},
+		},
+	}
+	if opts.Loki {
+		grafana["additionalDataSources"] = []interface{}{lokiDataSource()}
+	}
+
+	values := map[string]interface{}{
+		"fullnameOverride": ObservabilityApplication,
+		"grafana":          grafana,
+		"prometheus": map[string]interface{}{
+			"prometheusSpec": map[string]interface{}{
+				// select the ServiceMonitors of the platform, not only the chart's
+				"serviceMonitorSelectorNilUsesHelmValues": false,
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata": map[string]interface{}{
+			"name":      ObservabilityApplication,
+			"namespace": ArgoCDNamespace,
+			"annotations": map[string]string{
+				"argocd.argoproj.io/sync-wave": "-1",
+			},
+		},
+		"spec": map[string]interface{}{
+			"project": "default",
+			"source": map[string]interface{}{
+				"repoURL":        kubePrometheusStackRepo,
+				"chart":          ObservabilityApplication,
+				"targetRevision": KubePrometheusStackVersion,
+				"helm":           map[string]interface{}{"valuesObject": values},
+			},
+			"destination": map[string]interface{}{
+				"server":    "https://kubernetes.default.svc",
+				"namespace": ObservabilityNamespace,
+			},
+			"syncPolicy": map[string]interface{}{
+				"automated":   map[string]interface{}{"prune": true, "selfHeal": true},
+				"syncOptions": []string{"CreateNamespace=true", "ServerSideApply=true"},
+			},
+		},
+	}
+}
+
+// platformServiceMonitors renders a ServiceMonitor per platform component
+// of opts. They sync before the chart installs their CRD, so the dry run is
+// skipped until it does
+func platformServiceMonitors(opts ObservabilityOptions) []map[string]interface{} {
+	monitor := func(name string, namespaces []string, labels map[string]string, endpoint map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "ServiceMonitor",
+			"metadata": map[string]interface{}{
+				"name":        name,
+				"namespace":   ObservabilityNamespace,
+				"annotations": map[string]string{"argocd.argoproj.io/sync-options": "SkipDryRunOnMissingResource=true"},
+			},
+			"spec": map[string]interface{}{
+				"namespaceSelector": map[string]interface{}{"matchNames": namespaces},
+				"selector":          map[string]interface{}{"matchLabels": labels},
+				"endpoints":         []interface{}{endpoint},
+			},
+		}
+	}
+
+	monitors := []map[string]interface{}{
+		monitor("argocd", []string{ArgoCDNamespace}, map[string]string{"app.kubernetes.io/part-of": "argocd"}, map[string]interface{}{"port": "metrics"}),
+	}
+	if opts.Vault {
+		monitors = append(monitors, monitor("vault", []string{vaultNamespace}, map[string]string{"app.kubernetes.io/name": "vault", "vault-active": "true"}, map[string]interface{}{
+			"port":   "http",
+			"path":   "/v1/sys/metrics",
+			"params": map[string][]string{"format": {"prometheus"}},
+		}))
+	}
+	if opts.Istio {
+		monitors = append(monitors, monitor("istiod", []string{IstioNamespace}, map[string]string{"app": "istiod"}, map[string]interface{}{"port": "http-monitoring"}))
+	}
+	if opts.Kgateway {
+		monitors = append(monitors, monitor("kgateway", []string{WildcardGatewayNamespace}, map[string]string{"app.kubernetes.io/name": "kgateway"}, map[string]interface{}{"port": "metrics"}))
+	}
+	if len(opts.VClusters) > 0 {
+		namespaces := make([]string, 0, len(opts.VClusters))
+		for _, vcluster := range opts.VClusters {
+			namespaces = append(namespaces, VClusterNamespace(vcluster))
+		}
+		monitors = append(monitors, monitor("vcluster-syncers", namespaces, map[string]string{"app": "vcluster"}, map[string]interface{}{
+			"port":      "https",
+			"path":      "/metrics",
+			"scheme":    "https",
+			"tlsConfig": map[string]interface{}{"insecureSkipVerify": true},
+		}))
+	}
+
+	return monitors
+}
+
+func argoCDSyncDashboard() map[string]interface{} {
+	return grafanaDashboard("ArgoCD Sync Status", []map[string]interface{}{
+		grafanaPanel("Applications by sync status", "stat", `sum by (sync_status) (argocd_app_info)`, "{{sync_status}}"),
+		grafanaPanel("Applications by health status", "stat", `sum by (health_status) (argocd_app_info)`, "{{health_status}}"),
+		grafanaPanel("Applications out of sync", "table", `argocd_app_info{sync_status!="Synced"}`, "{{name}}"),
+		grafanaPanel("Sync operations", "timeseries", `sum by (phase) (increase(argocd_app_sync_total[5m]))`, "{{phase}}"),
+	})
+}
+
+func vclusterUsageDashboard() map[string]interface{} {
+	return grafanaDashboard("vCluster Resource Usage", []map[string]interface{}{
+		grafanaPanel("CPU cores", "timeseries", `sum by (namespace) (rate(container_cpu_usage_seconds_total{namespace=~"vcluster-.+",container!=""}[5m]))`, "{{namespace}}"),
+		grafanaPanel("Memory working set", "timeseries", `sum by (namespace) (container_memory_working_set_bytes{namespace=~"vcluster-.+",container!=""})`, "{{namespace}}"),
+		grafanaPanel("Pods", "timeseries", `count by (namespace) (kube_pod_info{namespace=~"vcluster-.+"})`, "{{namespace}}"),
+		grafanaPanel("Persistent volume usage", "timeseries", `sum by (namespace) (kubelet_volume_stats_used_bytes{namespace=~"vcluster-.+"})`, "{{namespace}}"),
+	})
+}
+
+func grafanaDashboard(title string, panels []map[string]interface{}) map[string]interface{} {
+	for i, panel := range panels {
+		panel["id"] = i + 1
+		panel["gridPos"] = map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8}
+	}
+
+	return map[string]interface{}{
+		"title":         title,
+		"uid":           strings.ReplaceAll(strings.ToLower(title), " ", "-"),
+		"schemaVersion": 39,
+		"refresh":       "1m",
+		"time":          map[string]string{"from": "now-6h", "to": "now"},
+		"panels":        panels,
+	}
+}
+
+func grafanaPanel(title, kind, expr, legend string) map[string]interface{} {
+	return map[string]interface{}{
+		"title":      title,
+		"type":       kind,
+		"datasource": map[string]string{"type": "prometheus", "uid": "prometheus"},
+		"targets":    []interface{}{map[string]interface{}{"refId": "A", "expr": expr, "legendFormat": legend}},
+	}
+}
+
+// ApplyGrafanaAdminSecret stores a generated Grafana admin password in
+// GrafanaAdminSecret. An existing password is kept, reruns do not rotate
+// it under the running Grafana
+func (c *Client) ApplyGrafanaAdminSecret(ctx context.Context) error {
+	_, err := c.Clientset.CoreV1().Secrets(ObservabilityNamespace).Get(ctx, GrafanaAdminSecret, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to read secret %s/%s: %w", ObservabilityNamespace, GrafanaAdminSecret, err)
+	}
+
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		return fmt.Errorf("failed to generate grafana admin password: %w", err)
+	}
+
+	// the chart's namespace may not be synced yet
+	namespace := corev1apply.Namespace(ObservabilityNamespace)
+	if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, namespace, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return fmt.Errorf("failed to apply namespace %s: %w", ObservabilityNamespace, err)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: GrafanaAdminSecret, Namespace: ObservabilityNamespace},
+		StringData: map[string]string{
+			"admin-user":     grafanaAdminUser,
+			"admin-password": hex.EncodeToString(password),
+		},
+	}
+	if _, err := c.Clientset.CoreV1().Secrets(ObservabilityNamespace).Create(ctx, secret, metav1.CreateOptions{FieldManager: fieldManager}); err != nil {
+		return fmt.Errorf("failed to create secret %s/%s: %w", ObservabilityNamespace, GrafanaAdminSecret, err)
+	}
+
+	return nil
+}
+
+// PrometheusTarget is a scrape target of Prometheus and whether its last
+// scrape succeeded
+type PrometheusTarget struct {
+	Job       string
+	ScrapeURL string
+	Health    string
+	LastError string
+}
+
+// Up reports whether the last scrape of the target succeeded
+func (t PrometheusTarget) Up() bool {
+	return t.Health == "up"
+}
+
+// PrometheusTargets lists the active scrape targets of the Prometheus of
+// the observability phase, through the API server service proxy
+func (c *Client) PrometheusTargets(ctx context.Context) ([]PrometheusTarget, error) {
+	raw, err := c.Clientset.CoreV1().Services(ObservabilityNamespace).
+		ProxyGet("http", prometheusService, prometheusPort, "api/v1/targets", map[string]string{"state": "active"}).
+		DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prometheus %s/%s: %w", ObservabilityNamespace, prometheusService, err)
+	}
+
+	var response struct {
+		Data struct {
+			ActiveTargets []struct {
+				Labels    map[string]string `json:"labels"`
+				ScrapeURL string            `json:"scrapeUrl"`
+				Health    string            `json:"health"`
+				LastError string            `json:"lastError"`
+			} `json:"activeTargets"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(raw, &response); err != nil {
+		return nil, fmt.Errorf("failed to decode prometheus targets: %w", err)
+	}
+
+	targets := make([]PrometheusTarget, 0, len(response.Data.ActiveTargets))
+	for _, target := range response.Data.ActiveTargets {
+		targets = append(targets, PrometheusTarget{
+			Job:       target.Labels["job"],
+			ScrapeURL: target.ScrapeURL,
+			Health:    target.Health,
+			LastError: target.LastError,
+		})
+	}
+
+	return targets, nil
+}
@@ -0,0 +1,119 @@
+package harvester
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type rawResponse string
+
+func (r rawResponse) DoRaw(context.Context) ([]byte, error) { return []byte(r), nil }
+
+func (r rawResponse) Stream(context.Context) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(string(r))), nil
+}
+
+func TestObservabilityManifests(t *testing.T) {
+	class := "nginx"
+	client := &Client{Clientset: fake.NewClientset(&networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "argocd-server", Namespace: ArgoCDNamespace},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: &class,
+			Rules:            []networkingv1.IngressRule{{Host: "argocd.example.com"}},
+		},
+	})}
+
+	ingress, err := client.ReadPlatformIngress(context.Background(), "example.com")
+	require.NoError(t, err)
+	assert.Equal(t, PlatformIngress{Class: "nginx", Issuer: WildcardClusterIssuer}, ingress)
+	_, err = client.ReadPlatformIngress(context.Background(), "other.com")
+	require.ErrorContains(t, err, `no ingress serves "argocd.other.com"`)
+
+	manifests, err := ObservabilityManifests(ObservabilityOptions{DomainName: "example.com", VClusters: []string{"dev"}, Vault: true, Ingress: ingress})
+	require.NoError(t, err)
+
+	var kinds []string
+	var grafana map[string]interface{}
+	for _, document := range strings.Split(string(manifests), "---\n") {
+		var object map[string]interface{}
+		require.NoError(t, yaml.Unmarshal([]byte(document), &object))
+		metadata := object["metadata"].(map[string]interface{})
+		kinds = append(kinds, object["kind"].(string)+" "+metadata["name"].(string))
+		if object["kind"] == "Application" {
+			grafana = lookupValue(object, "spec", "source", "helm", "valuesObject", "grafana").(map[string]interface{})
+		}
+	}
+	assert.Equal(t, []string{
+		"Application kube-prometheus-stack",
+		"ServiceMonitor argocd",
+		"ServiceMonitor vault",
+		"ServiceMonitor vcluster-syncers",
+		"ConfigMap dashboard-argocd-sync-status",
+		"ConfigMap dashboard-vcluster-resource-usage",
+	}, kinds)
+	assert.Equal(t, "nginx", lookupValue(grafana, "ingress", "ingressClassName"))
+	assert.Equal(t, []interface{}{"grafana.example.com"}, lookupValue(grafana, "ingress", "hosts"))
+	assert.Equal(t, GrafanaAdminSecret, lookupValue(grafana, "admin", "existingSecret"))
+}
+
+func TestGrafanaAdminSecret(t *testing.T) {
+	clientset := fake.NewClientset()
+	client := &Client{Clientset: clientset}
+	ctx := context.Background()
+
+	require.NoError(t, client.ApplyGrafanaAdminSecret(ctx))
+	secret, err := clientset.CoreV1().Secrets(ObservabilityNamespace).Get(ctx, GrafanaAdminSecret, metav1.GetOptions{})
+	require.NoError(t, err)
+	password := secret.StringData["admin-password"]
+	assert.Len(t, password, 32)
+
+	require.NoError(t, client.ApplyGrafanaAdminSecret(ctx))
+	secret, err = clientset.CoreV1().Secrets(ObservabilityNamespace).Get(ctx, GrafanaAdminSecret, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, password, secret.StringData["admin-password"])
+
+	_, err = clientset.CoreV1().Secrets(ObservabilityNamespace).Update(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: GrafanaAdminSecret, Namespace: ObservabilityNamespace},
+		Data:       map[string][]byte{"admin-password": []byte("grafana")},
+	}, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	credentials, err := client.ReadRootCredentials(ctx, false, true, "")
+	require.NoError(t, err)
+	assert.Contains(t, credentials.Text(), "Grafana admin password: grafana (user admin)")
+}
+
+func TestPrometheusTargets(t *testing.T) {
+	clientset := fake.NewClientset()
+	clientset.PrependProxyReactor("services", func(action k8stesting.Action) (bool, restclient.ResponseWrapper, error) {
+		proxy := action.(k8stesting.ProxyGetAction)
+		assert.Equal(t, prometheusService, proxy.GetName())
+		assert.Equal(t, "api/v1/targets", proxy.GetPath())
+		return true, rawResponse(`{"status": "success", "data": {"activeTargets": [
+			{"labels": {"job": "argocd-metrics"}, "scrapeUrl": "http://10.0.0.1:8082/metrics", "health": "up"},
+			{"labels": {"job": "vault"}, "scrapeUrl": "http://10.0.0.2:8200/v1/sys/metrics", "health": "down", "lastError": "403 Forbidden"}
+		]}}`), nil
+	})
+	client := &Client{Clientset: clientset}
+
+	targets, err := client.PrometheusTargets(context.Background())
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.True(t, targets[0].Up())
+	assert.Equal(t, PrometheusTarget{Job: "vault", ScrapeURL: "http://10.0.0.2:8200/v1/sys/metrics", Health: "down", LastError: "403 Forbidden"}, targets[1])
+
+	assert.True(t, ObservabilityEnabled("", true))
+	assert.False(t, ObservabilityEnabled("sso", true))
+	assert.False(t, ObservabilityEnabled("", false))
+}
@@ -0,0 +1,33 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote/auth"
+	"oras.land/oras-go/v2/registry/remote/credentials"
+)
+
+// OCICredential returns the credential of the registry of ref from the
+// docker config, ~/.docker/config.json or $DOCKER_CONFIG, including its
+// credential helpers. Registries without one get an empty credential
+func OCICredential(ctx context.Context, ref registry.Reference) (auth.Credential, error) {
+	store, err := credentials.NewStoreFromDocker(credentials.StoreOptions{})
+	if err != nil {
+		return auth.EmptyCredential, fmt.Errorf("failed to read docker credentials: %w", err)
+	}
+
+	credential, err := credentials.Credential(store)(ctx, ref.Registry)
+	if err != nil {
+		return auth.EmptyCredential, fmt.Errorf("failed to get docker credentials for %s: %w", ref.Registry, err)
+	}
+
+	return credential, nil
+}
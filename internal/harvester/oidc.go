@@ -0,0 +1,191 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// ArgoCDOIDCSecretName holds the OIDC client secret so it never has to be
+	// committed to the gitops repository; oidc.config references it by name
+	ArgoCDOIDCSecretName = "argocd-oidc"
+	argoCDOIDCSecretKey  = "clientSecret"
+
+	discoveryPath = "/.well-known/openid-configuration"
+)
+
+// OIDCConfig describes the external identity provider ArgoCD and Vault are
+// wired to during the sso phase
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	AdminGroup   string
+}
+
+// Enabled reports whether an identity provider was configured at all
+func (c OIDCConfig) Enabled() bool {
+	return c.IssuerURL != "" || c.ClientID != "" || c.ClientSecret != "" || c.AdminGroup != ""
+}
+
+// Validate ensures a configured identity provider has everything the sso
+// phase needs
+func (c OIDCConfig) Validate() error {
+	var missing []string
+	if c.IssuerURL == "" {
+		missing = append(missing, "--oidc-issuer-url")
+	}
+	if c.ClientID == "" {
+		missing = append(missing, "--oidc-client-id")
+	}
+	if c.ClientSecret == "" {
+		missing = append(missing, "--oidc-client-secret")
+	}
+	if c.AdminGroup == "" {
+		missing = append(missing, "--oidc-admin-group")
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required flags: %s", strings.Join(missing, ", "))
+	}
+
+	if !strings.HasPrefix(c.IssuerURL, "https://") {
+		return fmt.Errorf("issuer url %q must use https", c.IssuerURL)
+	}
+
+	return nil
+}
+
+// DiscoveryDocument holds the fields of an OpenID Provider configuration
+// the sso phase relies on
+type DiscoveryDocument struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// FetchDiscoveryDocument retrieves the OpenID Provider configuration of
+// issuerURL and checks it actually describes that issuer
+func FetchDiscoveryDocument(ctx context.Context, httpClient *http.Client, issuerURL string) (*DiscoveryDocument, error) {
+	issuer := strings.TrimSuffix(issuerURL, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+discoveryPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build discovery request: %w", err)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch discovery document from %q: %w", issuer, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch discovery document from %q: unexpected status %q", issuer, res.Status)
+	}
+
+	var document DiscoveryDocument
+	if err := json.NewDecoder(res.Body).Decode(&document); err != nil {
+		return nil, fmt.Errorf("failed to decode discovery document from %q: %w", issuer, err)
+	}
+
+	if strings.TrimSuffix(document.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovery document issuer %q does not match %q", document.Issuer, issuerURL)
+	}
+	if document.AuthorizationEndpoint == "" || document.TokenEndpoint == "" {
+		return nil, errors.New("discovery document is missing the authorization or token endpoint")
+	}
+
+	return &document, nil
+}
+
+type argoCDOIDCConfig struct {
+	Name            string   `yaml:"name"`
+	Issuer          string   `yaml:"issuer"`
+	ClientID        string   `yaml:"clientID"`
+	ClientSecret    string   `yaml:"clientSecret"`
+	RequestedScopes []string `yaml:"requestedScopes"`
+}
+
+// ArgoCDSSOManifests renders the argocd-cm and argocd-rbac-cm ConfigMaps
+// that point ArgoCD at the identity provider and grant the admin group
+// role:admin. The client secret is referenced from ArgoCDOIDCSecretName
+func ArgoCDSSOManifests(cfg OIDCConfig, domainName string) ([]byte, error) {
+	oidcConfig, err := yaml.Marshal(argoCDOIDCConfig{
+		Name:            "SSO",
+		Issuer:          cfg.IssuerURL,
+		ClientID:        cfg.ClientID,
+		ClientSecret:    fmt.Sprintf("$%s:%s", ArgoCDOIDCSecretName, argoCDOIDCSecretKey),
+		RequestedScopes: []string{"openid", "profile", "email", "groups"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render oidc.config: %w", err)
+	}
+
+	configMaps := []map[string]interface{}{
+		argoCDConfigMap("argocd-cm", map[string]string{
+			"url":         fmt.Sprintf("https://argocd.%s", domainName),
+			"oidc.config": string(oidcConfig),
+		}),
+		argoCDConfigMap("argocd-rbac-cm", map[string]string{
+			"policy.csv": fmt.Sprintf("g, %s, role:admin\n", cfg.AdminGroup),
+			"scopes":     "[groups]",
+		}),
+	}
+
+	var manifests []string
+	for _, configMap := range configMaps {
+		manifest, err := yaml.Marshal(configMap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render ArgoCD sso manifests: %w", err)
+		}
+		manifests = append(manifests, string(manifest))
+	}
+
+	return []byte(strings.Join(manifests, "---\n")), nil
+}
+
+func argoCDConfigMap(name string, data map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": ArgoCDNamespace,
+			"labels":    map[string]string{"app.kubernetes.io/part-of": "argocd"},
+		},
+		"data": data,
+	}
+}
+
+// ApplyArgoCDOIDCSecret stores the OIDC client secret where oidc.config
+// expects it. ArgoCD only resolves secrets labelled as part of argocd
+func (c *Client) ApplyArgoCDOIDCSecret(ctx context.Context, clientSecret string) error {
+	secret := corev1apply.Secret(ArgoCDOIDCSecretName, ArgoCDNamespace).
+		WithLabels(map[string]string{"app.kubernetes.io/part-of": "argocd"}).
+		WithStringData(map[string]string{argoCDOIDCSecretKey: clientSecret})
+
+	_, err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", ArgoCDNamespace, ArgoCDOIDCSecretName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestOIDCConfigValidate(t *testing.T) {
+	valid := OIDCConfig{
+		IssuerURL:    "https://auth.example.com/application/o/kubefirst/",
+		ClientID:     "kubefirst",
+		ClientSecret: "s3cr3t",
+		AdminGroup:   "platform-admins",
+	}
+
+	t.Run("accepts a complete configuration", func(t *testing.T) {
+		require.NoError(t, valid.Validate())
+	})
+
+	t.Run("names every missing flag", func(t *testing.T) {
+		err := OIDCConfig{IssuerURL: valid.IssuerURL}.Validate()
+		require.ErrorContains(t, err, "--oidc-client-id, --oidc-client-secret, --oidc-admin-group")
+	})
+
+	t.Run("rejects plain http issuers", func(t *testing.T) {
+		cfg := valid
+		cfg.IssuerURL = "http://auth.example.com"
+		require.ErrorContains(t, cfg.Validate(), "must use https")
+	})
+
+	t.Run("is disabled without any flags", func(t *testing.T) {
+		assert.False(t, OIDCConfig{}.Enabled())
+	})
+}
+
+func TestResolveSecret(t *testing.T) {
+	t.Run("returns literal values unchanged", func(t *testing.T) {
+		secret, err := ResolveSecret("s3cr3t")
+		require.NoError(t, err)
+		assert.Equal(t, "s3cr3t", secret)
+	})
+
+	t.Run("reads environment variables", func(t *testing.T) {
+		t.Setenv("KUBEFIRST_TEST_SECRET", "from-env")
+
+		secret, err := ResolveSecret("env:KUBEFIRST_TEST_SECRET")
+		require.NoError(t, err)
+		assert.Equal(t, "from-env", secret)
+	})
+
+	t.Run("fails on unset environment variables", func(t *testing.T) {
+		_, err := ResolveSecret("env:KUBEFIRST_TEST_SECRET_UNSET")
+		require.ErrorContains(t, err, "KUBEFIRST_TEST_SECRET_UNSET")
+	})
+
+	t.Run("reads files without the trailing newline", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "secret")
+		require.NoError(t, os.WriteFile(path, []byte("from-file\n"), 0o600))
+
+		secret, err := ResolveSecret("file:" + path)
+		require.NoError(t, err)
+		assert.Equal(t, "from-file", secret)
+	})
+}
+
+func newDiscoveryServer(t *testing.T, issuer func(serverURL string) string) *httptest.Server {
+	t.Helper()
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != discoveryPath {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(DiscoveryDocument{
+			Issuer:                issuer(server.URL),
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JWKSURI:               server.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestFetchDiscoveryDocument(t *testing.T) {
+	t.Run("returns the document of the issuer", func(t *testing.T) {
+		server := newDiscoveryServer(t, func(serverURL string) string { return serverURL + "/" })
+
+		document, err := FetchDiscoveryDocument(context.Background(), server.Client(), server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, server.URL+"/token", document.TokenEndpoint)
+	})
+
+	t.Run("rejects documents describing another issuer", func(t *testing.T) {
+		server := newDiscoveryServer(t, func(string) string { return "https://other.example.com" })
+
+		_, err := FetchDiscoveryDocument(context.Background(), server.Client(), server.URL)
+		require.ErrorContains(t, err, "does not match")
+	})
+
+	t.Run("fails when the issuer has no discovery document", func(t *testing.T) {
+		server := newDiscoveryServer(t, func(serverURL string) string { return serverURL })
+
+		_, err := FetchDiscoveryDocument(context.Background(), server.Client(), server.URL+"/missing")
+		require.ErrorContains(t, err, "unexpected status")
+	})
+}
+
+func TestArgoCDSSOManifests(t *testing.T) {
+	cfg := OIDCConfig{
+		IssuerURL:    "https://auth.example.com/application/o/kubefirst/",
+		ClientID:     "kubefirst",
+		ClientSecret: "s3cr3t",
+		AdminGroup:   "platform-admins",
+	}
+
+	manifests, err := ArgoCDSSOManifests(cfg, "example.com")
+	require.NoError(t, err)
+	assert.NotContains(t, string(manifests), "s3cr3t")
+
+	decoder := yaml.NewDecoder(bytes.NewReader(manifests))
+
+	var argocdCM, rbacCM struct {
+		Metadata struct {
+			Name      string `yaml:"name"`
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+		Data map[string]string `yaml:"data"`
+	}
+	require.NoError(t, decoder.Decode(&argocdCM))
+	require.NoError(t, decoder.Decode(&rbacCM))
+
+	assert.Equal(t, "argocd-cm", argocdCM.Metadata.Name)
+	assert.Equal(t, ArgoCDNamespace, argocdCM.Metadata.Namespace)
+	assert.Equal(t, "https://argocd.example.com", argocdCM.Data["url"])
+
+	var oidcConfig argoCDOIDCConfig
+	require.NoError(t, yaml.Unmarshal([]byte(argocdCM.Data["oidc.config"]), &oidcConfig))
+	assert.Equal(t, cfg.IssuerURL, oidcConfig.Issuer)
+	assert.Equal(t, "$argocd-oidc:clientSecret", oidcConfig.ClientSecret)
+
+	assert.Equal(t, "argocd-rbac-cm", rbacCM.Metadata.Name)
+	assert.Equal(t, "g, platform-admins, role:admin\n", rbacCM.Data["policy.csv"])
+}
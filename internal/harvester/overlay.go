@@ -0,0 +1,311 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OverlayDeleteFile lists, one per line, the paths of the gitops
+// repository an overlay removes. Blank lines and lines starting with # are
+// ignored
+const OverlayDeleteFile = ".delete"
+
+// GitopsOverlay is a local directory merged over the gitops repository:
+// YAML files deep-merge onto the file at the same path, other files are
+// written verbatim and the paths of OverlayDeleteFile are removed
+type GitopsOverlay struct {
+	Dir    string
+	Files  map[string][]byte
+	Delete []string
+	// Hash is the SHA-256 of the paths and contents of the overlay,
+	// OverlayDeleteFile included
+	Hash string
+}
+
+// LoadGitopsOverlay reads the overlay in dir. Hidden directories such as a
+// .git are skipped
+func LoadGitopsOverlay(dir string) (*GitopsOverlay, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlay directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("overlay %s is not a directory", dir)
+	}
+
+	overlay := &GitopsOverlay{Dir: dir, Files: map[string][]byte{}}
+	hash := sha256.New()
+	err = filepath.WalkDir(dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if entry.IsDir() {
+			if rel != "." && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		// WalkDir walks in lexical order, the hash is stable
+		fmt.Fprintf(hash, "%s\x00%d\x00", rel, len(content))
+		hash.Write(content)
+
+		if rel == OverlayDeleteFile {
+			overlay.Delete, err = parseOverlayDeletes(content)
+			return err
+		}
+		overlay.Files[rel] = content
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlay %s: %w", dir, err)
+	}
+	overlay.Hash = hex.EncodeToString(hash.Sum(nil))
+
+	for _, name := range overlay.Delete {
+		if _, ok := overlay.Files[name]; ok {
+			return nil, fmt.Errorf("overlay %s both adds and deletes %s", dir, name)
+		}
+	}
+
+	return overlay, nil
+}
+
+func parseOverlayDeletes(content []byte) ([]string, error) {
+	var deletes []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name := strings.Trim(path.Clean("/"+line), "/")
+		if name == "" {
+			return nil, fmt.Errorf("%s: %q removes the whole repository", OverlayDeleteFile, line)
+		}
+		deletes = append(deletes, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", OverlayDeleteFile, err)
+	}
+
+	return deletes, nil
+}
+
+// OverlayResult is what applying an overlay changes in the gitops
+// repository
+type OverlayResult struct {
+	// Files are the changed files keyed by their repository path, a nil
+	// content removing the path, as GitopsRepo.CommitFiles takes them
+	Files   map[string][]byte
+	Merged  []string
+	Added   []string
+	Deleted []string
+}
+
+// Summary describes the result in a line
+func (r OverlayResult) Summary() string {
+	var parts []string
+	if len(r.Merged) > 0 {
+		parts = append(parts, fmt.Sprintf("merged %s", strings.Join(r.Merged, ", ")))
+	}
+	if len(r.Added) > 0 {
+		parts = append(parts, fmt.Sprintf("added %s", strings.Join(r.Added, ", ")))
+	}
+	if len(r.Deleted) > 0 {
+		parts = append(parts, fmt.Sprintf("deleted %s", strings.Join(r.Deleted, ", ")))
+	}
+	if len(parts) == 0 {
+		return "overlay changes nothing"
+	}
+
+	return "overlay " + strings.Join(parts, "; ")
+}
+
+// Apply merges the overlay over repository, every file of the gitops
+// repository keyed by its path. Deleted paths that hold nothing are
+// skipped, a directory is deleted with every file under it
+func (o *GitopsOverlay) Apply(repository map[string][]byte) (OverlayResult, error) {
+	result := OverlayResult{Files: map[string][]byte{}}
+
+	names := make([]string, 0, len(o.Files))
+	for name := range o.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		content := o.Files[name]
+		base, exists := repository[name]
+		if !exists {
+			result.Files[name] = content
+			result.Added = append(result.Added, name)
+			continue
+		}
+		if ext := path.Ext(name); ext != ".yaml" && ext != ".yml" {
+			result.Files[name] = content
+			result.Merged = append(result.Merged, name)
+			continue
+		}
+
+		merged, err := MergeYAML(base, content)
+		if err != nil {
+			return OverlayResult{}, fmt.Errorf("failed to merge overlay %s: %w", name, err)
+		}
+		if !bytes.Equal(merged, base) {
+			result.Files[name] = merged
+			result.Merged = append(result.Merged, name)
+		}
+	}
+
+	for _, name := range o.Delete {
+		found := false
+		for existing := range repository {
+			if existing == name || strings.HasPrefix(existing, name+"/") {
+				found = true
+				break
+			}
+		}
+		if found {
+			result.Files[name] = nil
+			result.Deleted = append(result.Deleted, name)
+		}
+	}
+
+	return result, nil
+}
+
+// MergeYAML deep-merges the documents of overlay onto the documents of
+// base, the n-th onto the n-th: mappings merge key by key, any other value
+// of overlay replaces the one of base. The comments and key order of base
+// are kept
+func MergeYAML(base, overlay []byte) ([]byte, error) {
+	baseDocs, err := decodeYAMLDocuments(base)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base yaml: %w", err)
+	}
+	overlayDocs, err := decodeYAMLDocuments(overlay)
+	if err != nil {
+		return nil, fmt.Errorf("invalid overlay yaml: %w", err)
+	}
+	if len(overlayDocs) > len(baseDocs) {
+		return nil, fmt.Errorf("overlay has %d documents, the file has %d", len(overlayDocs), len(baseDocs))
+	}
+
+	for i, doc := range overlayDocs {
+		mergeYAMLNodes(baseDocs[i], doc)
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, doc := range baseDocs {
+		if err := encoder.Encode(doc); err != nil {
+			return nil, fmt.Errorf("failed to render merged yaml: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to render merged yaml: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decodeYAMLDocuments(content []byte) ([]*yaml.Node, error) {
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+}
+
+// mergeYAMLNodes merges overlay into base in place
+func mergeYAMLNodes(base, overlay *yaml.Node) {
+	if base.Kind == yaml.DocumentNode && overlay.Kind == yaml.DocumentNode && len(base.Content) == 1 && len(overlay.Content) == 1 {
+		mergeYAMLNodes(base.Content[0], overlay.Content[0])
+		return
+	}
+	if base.Kind != yaml.MappingNode || overlay.Kind != yaml.MappingNode {
+		*base = *overlay
+		return
+	}
+
+	for i := 0; i+1 < len(overlay.Content); i += 2 {
+		key, value := overlay.Content[i], overlay.Content[i+1]
+		found := false
+		for j := 0; j+1 < len(base.Content); j += 2 {
+			if base.Content[j].Value == key.Value {
+				mergeYAMLNodes(base.Content[j+1], value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			base.Content = append(base.Content, key, value)
+		}
+	}
+}
+
+// OverlayRecord is the overlay create merged, as the kubefirst config
+// records it
+type OverlayRecord struct {
+	Dir  string `json:"dir"`
+	Hash string `json:"hash"`
+}
+
+// ParseOverlayRecord parses the recorded overlay, an empty value being none
+func ParseOverlayRecord(value string) (OverlayRecord, bool, error) {
+	if value == "" {
+		return OverlayRecord{}, false, nil
+	}
+
+	var record OverlayRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return OverlayRecord{}, false, fmt.Errorf("invalid gitops overlay record: %w", err)
+	}
+
+	return record, true, nil
+}
+
+// String encodes the record for the kubefirst config
+func (r OverlayRecord) String() string {
+	data, _ := json.Marshal(r)
+	return string(data)
+}
@@ -0,0 +1,95 @@
+package harvester
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeOverlay(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(target), 0o755))
+		require.NoError(t, os.WriteFile(target, []byte(content), 0o644))
+	}
+
+	return dir
+}
+
+func TestMergeYAML(t *testing.T) {
+	base := "# values of the chart\nreplicas: 1\nserver:\n  image: vault:1.0\n  extraArgs: [a, b]\n---\nkind: ConfigMap\n"
+	overlay := "server:\n  extraArgs: [c]\n  resources:\n    limits:\n      memory: 1Gi\nreplicas: 3\n"
+
+	merged, err := MergeYAML([]byte(base), []byte(overlay))
+	require.NoError(t, err)
+	assert.Equal(t, "# values of the chart\nreplicas: 3\nserver:\n  image: vault:1.0\n  extraArgs: [c]\n  resources:\n    limits:\n      memory: 1Gi\n---\nkind: ConfigMap\n", string(merged))
+
+	_, err = MergeYAML([]byte("a: 1\n"), []byte("a: 2\n---\nb: 3\n"))
+	require.ErrorContains(t, err, "overlay has 2 documents, the file has 1")
+
+	_, err = MergeYAML([]byte("a: 1\n"), []byte("a: [\n"))
+	require.ErrorContains(t, err, "invalid overlay yaml")
+}
+
+func TestGitopsOverlay(t *testing.T) {
+	dir := writeOverlay(t, map[string]string{
+		"registry/k1/vault.yaml":       "spec:\n  source:\n    helm:\n      values: |\n        server: {}\n",
+		"registry/k1/extra-app.yaml":   "kind: Application\n",
+		"registry/k1/README.md":        "ours\n",
+		"registry/k1/same.yaml":        "a: 1\n",
+		".delete":                      "# upstream samples\nregistry/k1/metaphor\n\nregistry/k1/missing.yaml\n",
+		".git/config":                  "ignored\n",
+		"registry/k1/.hidden/out.yaml": "ignored: true\n",
+	})
+
+	overlay, err := LoadGitopsOverlay(dir)
+	require.NoError(t, err)
+	assert.Len(t, overlay.Files, 4)
+	assert.Equal(t, []string{"registry/k1/metaphor", "registry/k1/missing.yaml"}, overlay.Delete)
+	assert.Len(t, overlay.Hash, 64)
+
+	again, err := LoadGitopsOverlay(dir)
+	require.NoError(t, err)
+	assert.Equal(t, overlay.Hash, again.Hash)
+
+	result, err := overlay.Apply(map[string][]byte{
+		"registry/k1/vault.yaml":               []byte("metadata:\n  name: vault\nspec:\n  project: default\n"),
+		"registry/k1/README.md":                []byte("upstream\n"),
+		"registry/k1/same.yaml":                []byte("a: 1\n"),
+		"registry/k1/metaphor/deployment.yaml": []byte("kind: Deployment\n"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry/k1/README.md", "registry/k1/vault.yaml"}, result.Merged)
+	assert.Equal(t, []string{"registry/k1/extra-app.yaml"}, result.Added)
+	assert.Equal(t, []string{"registry/k1/metaphor"}, result.Deleted)
+	assert.Nil(t, result.Files["registry/k1/metaphor"])
+	assert.Contains(t, result.Files, "registry/k1/metaphor")
+	assert.NotContains(t, result.Files, "registry/k1/same.yaml")
+	assert.Equal(t, "metadata:\n  name: vault\nspec:\n  project: default\n  source:\n    helm:\n      values: |\n        server: {}\n", string(result.Files["registry/k1/vault.yaml"]))
+	assert.Equal(t, "overlay merged registry/k1/README.md, registry/k1/vault.yaml; added registry/k1/extra-app.yaml; deleted registry/k1/metaphor", result.Summary())
+
+	_, err = LoadGitopsOverlay(writeOverlay(t, map[string]string{"a.yaml": "a: 1\n", ".delete": "a.yaml\n"}))
+	require.ErrorContains(t, err, "both adds and deletes a.yaml")
+
+	_, err = LoadGitopsOverlay(writeOverlay(t, map[string]string{".delete": "/\n"}))
+	require.ErrorContains(t, err, "removes the whole repository")
+
+	_, err = LoadGitopsOverlay(filepath.Join(dir, "registry/k1/README.md"))
+	require.ErrorContains(t, err, "is not a directory")
+}
+
+func TestOverlayRecord(t *testing.T) {
+	_, found, err := ParseOverlayRecord("")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	record, found, err := ParseOverlayRecord(OverlayRecord{Dir: "/overlay", Hash: "abc"}.String())
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, OverlayRecord{Dir: "/overlay", Hash: "abc"}, record)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+)
+
+// Provisioning phases in the order they run, as accepted by --stop-after
+const (
+	PhaseArgoCD   = "argocd"
+	PhaseIngress  = "ingress"
+	PhaseVCluster = "vcluster"
+	PhaseVault    = "vault"
+	PhaseSSO      = "sso"
+	// PhaseObservability only runs with --install-observability
+	PhaseObservability = "observability"
+)
+
+var Phases = []string{PhaseArgoCD, PhaseIngress, PhaseVCluster, PhaseVault, PhaseSSO, PhaseObservability}
+
+// ValidateStopAfter ensures stopAfter is empty or a known phase
+func ValidateStopAfter(stopAfter string) error {
+	if stopAfter == "" || slices.Contains(Phases, stopAfter) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown phase %q, must be one of %v", stopAfter, Phases)
+}
+
+// PhaseEnabled reports whether phase runs when provisioning halts after
+// stopAfter; an empty stopAfter runs every phase
+func PhaseEnabled(stopAfter, phase string) bool {
+	if stopAfter == "" {
+		return true
+	}
+
+	stop := slices.Index(Phases, stopAfter)
+	current := slices.Index(Phases, phase)
+	if stop < 0 || current < 0 {
+		return true
+	}
+
+	return current <= stop
+}
+
+// VaultEnabled reports whether Vault is installed, which is whenever its
+// phase runs: kubefirst-api installs it even when External Secrets Operator
+// reads from another secret store next to it
+func VaultEnabled(stopAfter string) bool {
+	return PhaseEnabled(stopAfter, PhaseVault)
+}
+
+// PhaseTarget selects the ArgoCD applications of a phase, by name, by the
+// namespace they deploy into or by the ApplicationSet generating them.
+// Expected is the number of applications the phase has once every
+// generated one exists
+type PhaseTarget struct {
+	Applications    []string
+	Namespaces      []string
+	ApplicationSets []string
+	Expected        int
+}
+
+// Matches reports whether app belongs to the phase
+func (t PhaseTarget) Matches(app *v1alpha1.Application) bool {
+	return slices.Contains(t.Applications, app.Name) || slices.Contains(t.Namespaces, app.Spec.Destination.Namespace) || slices.Contains(t.ApplicationSets, generatingAppSet(app))
+}
+
+// PhaseTargets returns the applications --wait blocks on when provisioning
+// halts after phase. With vclusterAppSet the vcluster applications are the
+// ones of the ApplicationSet, one per vcluster
+func PhaseTargets(phase string, vclusters []string, externalSecrets, vclusterAppSet bool) PhaseTarget {
+	switch phase {
+	case PhaseArgoCD:
+		return PhaseTarget{Applications: []string{"registry"}}
+	case PhaseIngress:
+		return PhaseTarget{Namespaces: []string{IstioNamespace, WildcardGatewayNamespace}}
+	case PhaseVCluster:
+		target := PhaseTarget{Applications: []string{"platform-vcluster"}}
+		if vclusterAppSet {
+			target = PhaseTarget{ApplicationSets: []string{VClusterAppSet}, Expected: len(vclusters)}
+		}
+		for _, vcluster := range vclusters {
+			target.Namespaces = append(target.Namespaces, VClusterNamespace(vcluster))
+		}
+		return target
+	case PhaseVault:
+		target := PhaseTarget{Applications: []string{"vault"}, Namespaces: []string{vaultNamespace}}
+		if externalSecrets {
+			target.Applications = append(target.Applications, ExternalSecretsCatalogApp)
+			target.Namespaces = append(target.Namespaces, ExternalSecretsNamespace)
+		}
+		return target
+	case PhaseObservability:
+		return PhaseTarget{Applications: []string{ObservabilityApplication}, Namespaces: []string{ObservabilityNamespace}}
+	default:
+		// SSO only reconfigures ArgoCD
+		return PhaseTarget{Namespaces: []string{ArgoCDNamespace}}
+	}
+}
+
+// String describes the target in errors
+func (t PhaseTarget) String() string {
+	var parts []string
+	if len(t.Applications) > 0 {
+		parts = append(parts, "applications "+strings.Join(t.Applications, ", "))
+	}
+	if len(t.Namespaces) > 0 {
+		parts = append(parts, "applications deploying into "+strings.Join(t.Namespaces, ", "))
+	}
+	if len(t.ApplicationSets) > 0 {
+		parts = append(parts, "applications generated by "+strings.Join(t.ApplicationSets, ", "))
+	}
+
+	return strings.Join(parts, " or ")
+}
@@ -0,0 +1,312 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PinnedCharts are the helm charts harvester pin locks, by the component
+// they belong to
+var PinnedCharts = map[string][]string{
+	"argocd":       {"argo-cd"},
+	"cert-manager": {"cert-manager", "cert-manager-crds"},
+	"istio":        {"base", "istiod", "cni", "ztunnel"},
+	"kgateway":     {"kgateway", "kgateway-crds"},
+	"vault":        {"vault"},
+}
+
+var exactVersion = regexp.MustCompile(`^v?\d+\.\d+\.\d+$`)
+
+// DeployedChartVersions returns the chart version every ArgoCD application
+// deployed of the charts in PinnedCharts, preferring the revision ArgoCD
+// last synced over the requested targetRevision
+func (c *Client) DeployedChartVersions(ctx context.Context) (map[string]string, error) {
+	pinned := map[string]bool{}
+	for _, charts := range PinnedCharts {
+		for _, chart := range charts {
+			pinned[chart] = true
+		}
+	}
+
+	apps, err := c.ListApplications(ctx, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	versions := map[string]string{}
+	for _, app := range apps {
+		revisions := app.Status.Sync.Revisions
+		if len(revisions) == 0 && app.Status.Sync.Revision != "" {
+			revisions = []string{app.Status.Sync.Revision}
+		}
+
+		for i, source := range app.Spec.GetSources() {
+			if !pinned[source.Chart] {
+				continue
+			}
+
+			version := source.TargetRevision
+			if i < len(revisions) && exactVersion.MatchString(revisions[i]) {
+				version = revisions[i]
+			}
+			versions[source.Chart] = version
+		}
+	}
+
+	return versions, nil
+}
+
+// PinVersion returns the chart version harvester pin writes for the deployed
+// version: the version itself, or with unpin a range accepting its patch
+// releases
+func PinVersion(deployed string, unpin bool) (string, bool) {
+	if !exactVersion.MatchString(deployed) {
+		return "", false
+	}
+	if unpin {
+		return "~" + strings.TrimPrefix(deployed, "v"), true
+	}
+
+	return deployed, true
+}
+
+// PinChartVersions rewrites the chart versions in files, keyed by their path
+// in the gitops repository, to the versions in pins keyed by chart name. It
+// covers the targetRevision of ArgoCD application sources and the version of
+// Chart.yaml dependencies, leaving the rest of each file untouched, and
+// returns only the files that changed
+func PinChartVersions(files map[string][]byte, pins map[string]string) map[string][]byte {
+	return pinChartSources(files, pins, "targetRevision", "version")
+}
+
+// PinChartRepositories rewrites the repoURL of ArgoCD application sources
+// and the repository of Chart.yaml dependencies in files to the helm
+// repositories in repositories keyed by chart name, as PinChartVersions
+// does for versions
+func PinChartRepositories(files map[string][]byte, repositories map[string]string) map[string][]byte {
+	return pinChartSources(files, repositories, "repoURL", "repository")
+}
+
+// pinChartSources sets the applicationKey of ArgoCD application sources and
+// the dependencyKey of Chart.yaml dependencies naming a chart of values
+func pinChartSources(files map[string][]byte, values map[string]string, applicationKey, dependencyKey string) map[string][]byte {
+	changed := map[string][]byte{}
+
+	for name, content := range files {
+		key, sibling := "chart", applicationKey
+		if path.Base(name) == "Chart.yaml" {
+			key, sibling = "name", dependencyKey
+		}
+
+		lines := strings.Split(string(content), "\n")
+		modified := false
+		for i, line := range lines {
+			column, value, ok := yamlKey(line, key)
+			if !ok || (key == "name" && column == 0) {
+				continue
+			}
+			pin, ok := values[value]
+			if !ok {
+				continue
+			}
+			if setSibling(lines, i, column, sibling, pin) {
+				modified = true
+			}
+		}
+
+		if modified {
+			changed[name] = []byte(strings.Join(lines, "\n"))
+		}
+	}
+
+	return changed
+}
+
+// yamlKey returns the column and scalar value of line when it sets key,
+// including as the first key of a sequence item
+func yamlKey(line, key string) (int, string, bool) {
+	column := keyColumn(line)
+
+	value, ok := strings.CutPrefix(line[column:], key+":")
+	if !ok {
+		return 0, "", false
+	}
+	value, _ = splitComment(value)
+
+	return column, strings.Trim(strings.TrimSpace(value), `"'`), true
+}
+
+// keyColumn returns the column the content of line starts at behind its
+// indentation and sequence item markers
+func keyColumn(line string) int {
+	trimmed := strings.TrimLeft(line, " ")
+	for strings.HasPrefix(trimmed, "- ") {
+		trimmed = strings.TrimLeft(trimmed[2:], " ")
+	}
+
+	return len(line) - len(trimmed)
+}
+
+// splitComment splits a trailing comment off a yaml value
+func splitComment(value string) (string, string) {
+	if i := strings.Index(value, " #"); i >= 0 {
+		return value[:i], value[i:]
+	}
+
+	return value, ""
+}
+
+// setSibling sets key in the mapping the line at belongs to, whose keys
+// start at column, returning whether the value changed
+func setSibling(lines []string, at, column int, key, value string) bool {
+	set := func(i int) bool {
+		_, current, _ := yamlKey(lines[i], key)
+		if current == value {
+			return false
+		}
+		_, comment := splitComment(lines[i][column:])
+		lines[i] = fmt.Sprintf("%s%s: %s%s", lines[i][:column], key, quoteVersion(lines[i], value), comment)
+		return true
+	}
+	isKey := func(i int) bool {
+		keyColumn, _, ok := yamlKey(lines[i], key)
+		return ok && keyColumn == column
+	}
+
+	for i := at + 1; i < len(lines); i++ {
+		indent, _, item, ok := lineLayout(lines[i])
+		if !ok {
+			continue
+		}
+		if indent < column {
+			break
+		}
+		if indent == column && !item && isKey(i) {
+			return set(i)
+		}
+	}
+
+	if _, _, item, _ := lineLayout(lines[at]); item {
+		return false
+	}
+	for i := at - 1; i >= 0; i-- {
+		indent, keyColumn, item, ok := lineLayout(lines[i])
+		if !ok {
+			continue
+		}
+		if item && keyColumn == column {
+			return isKey(i) && set(i)
+		}
+		if indent < column {
+			break
+		}
+		if indent == column && !item && isKey(i) {
+			return set(i)
+		}
+	}
+
+	return false
+}
+
+// lineLayout returns the indentation of line and the column its key starts
+// at, which differ for sequence items. Blank lines and comments are not
+// ok, document separators end every mapping
+func lineLayout(line string) (int, int, bool, bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return 0, 0, false, false
+	}
+	if strings.HasPrefix(trimmed, "---") {
+		return -1, -1, false, true
+	}
+
+	return len(line) - len(trimmed), keyColumn(line), strings.HasPrefix(trimmed, "- "), true
+}
+
+// quoteVersion quotes value the way the line it replaces was quoted
+func quoteVersion(line, value string) string {
+	_, raw, _ := strings.Cut(line, ":")
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return `"` + value + `"`
+	case strings.HasPrefix(raw, `'`):
+		return `'` + value + `'`
+	case strings.HasPrefix(value, "~"):
+		return `"` + value + `"`
+	default:
+		return value
+	}
+}
+
+// DiffFiles renders the changes from before to after as unified diffs,
+// ordered by path. PinChartVersions only ever replaces lines, so the
+// files are compared line by line rather than searched for moved lines
+func DiffFiles(before, after map[string][]byte) string {
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const context = 2
+
+	var b strings.Builder
+	for _, name := range names {
+		old := strings.Split(string(before[name]), "\n")
+		updated := strings.Split(string(after[name]), "\n")
+		if len(old) != len(updated) {
+			fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n@@ rewritten @@\n", name, name)
+			continue
+		}
+
+		fmt.Fprintf(&b, "--- a/%s\n+++ b/%s\n", name, name)
+		for i := 0; i < len(old); {
+			if old[i] == updated[i] {
+				i++
+				continue
+			}
+
+			start, end := max(i-context, 0), i
+			for end < len(old) && (old[end] != updated[end] || hasChange(old, updated, end, context)) {
+				end++
+			}
+			end = min(end+context, len(old))
+
+			fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", start+1, end-start, start+1, end-start)
+			for j := start; j < end; j++ {
+				if old[j] == updated[j] {
+					fmt.Fprintf(&b, " %s\n", old[j])
+					continue
+				}
+				fmt.Fprintf(&b, "-%s\n", old[j])
+				fmt.Fprintf(&b, "+%s\n", updated[j])
+			}
+			i = end
+		}
+	}
+
+	return b.String()
+}
+
+// hasChange reports whether a line within the context after i differs, so
+// the hunk continues through it
+func hasChange(old, updated []string, i, context int) bool {
+	for j := i + 1; j <= i+2*context && j < len(old); j++ {
+		if old[j] != updated[j] {
+			return true
+		}
+	}
+
+	return false
+}
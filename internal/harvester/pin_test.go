@@ -0,0 +1,101 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const vaultApplication = `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: vault
+spec:
+  source:
+    repoURL: https://helm.releases.hashicorp.com
+    targetRevision: 0.28.1 # renovate bumps this
+    chart: vault
+    helm:
+      values: |
+        server:
+          ha:
+            enabled: true
+  destination:
+    namespace: vault
+`
+
+const istioApplication = `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: istio
+spec:
+  sources:
+  - chart: base
+    repoURL: https://istio-release.storage.googleapis.com/charts
+    targetRevision: "1.24.2"
+  - repoURL: https://istio-release.storage.googleapis.com/charts
+    chart: istiod
+    targetRevision: 1.24.2
+`
+
+const umbrellaChart = `apiVersion: v2
+name: cert-manager
+version: 1.0.0
+dependencies:
+  - name: cert-manager
+    version: 1.16.2
+    repository: https://charts.jetstack.io
+`
+
+func TestPinChartVersions(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kubefirst/vault.yaml":              []byte(vaultApplication),
+		"registry/kubefirst/istio.yaml":              []byte(istioApplication),
+		"registry/kubefirst/cert-manager/Chart.yaml": []byte(umbrellaChart),
+		"registry/kubefirst/metaphor.yaml":           []byte("kind: Application\nspec:\n  source:\n    path: charts/metaphor\n"),
+	}
+
+	t.Run("pin", func(t *testing.T) {
+		changed := PinChartVersions(files, map[string]string{
+			"vault":        "0.28.0",
+			"base":         "1.24.1",
+			"istiod":       "1.24.1",
+			"cert-manager": "1.16.2",
+		})
+
+		require.Len(t, changed, 2)
+		assert.Contains(t, string(changed["registry/kubefirst/vault.yaml"]), "    targetRevision: 0.28.0 # renovate bumps this\n    chart: vault\n")
+		assert.Contains(t, string(changed["registry/kubefirst/istio.yaml"]), "  - chart: base\n    repoURL: https://istio-release.storage.googleapis.com/charts\n    targetRevision: \"1.24.1\"\n")
+		assert.Contains(t, string(changed["registry/kubefirst/istio.yaml"]), "    chart: istiod\n    targetRevision: 1.24.1\n")
+	})
+
+	t.Run("unpin", func(t *testing.T) {
+		version, ok := PinVersion("1.16.2", true)
+		require.True(t, ok)
+
+		changed := PinChartVersions(files, map[string]string{"cert-manager": version})
+
+		require.Len(t, changed, 1)
+		chart := string(changed["registry/kubefirst/cert-manager/Chart.yaml"])
+		assert.Contains(t, chart, "name: cert-manager\nversion: 1.0.0\n")
+		assert.Contains(t, chart, "  - name: cert-manager\n    version: \"~1.16.2\"\n")
+	})
+}
+
+func TestPinVersion(t *testing.T) {
+	version, ok := PinVersion("v1.24.2", false)
+	assert.True(t, ok)
+	assert.Equal(t, "v1.24.2", version)
+
+	_, ok = PinVersion("1.24.*", false)
+	assert.False(t, ok)
+}
+
+func TestDiffFiles(t *testing.T) {
+	diff := DiffFiles(
+		map[string][]byte{"vault.yaml": []byte("kind: Application\nspec:\n  source:\n    chart: vault\n    targetRevision: 0.28.1\n")},
+		map[string][]byte{"vault.yaml": []byte("kind: Application\nspec:\n  source:\n    chart: vault\n    targetRevision: 0.28.0\n")},
+	)
+	assert.Equal(t, "--- a/vault.yaml\n+++ b/vault.yaml\n@@ -3,4 +3,4 @@\n   source:\n     chart: vault\n-    targetRevision: 0.28.1\n+    targetRevision: 0.28.0\n \n", diff)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/semver/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The categories of the errors Ping returns, matched with errors.Is
+var (
+	ErrClusterUnreachable  = errors.New("network unreachable")
+	ErrClusterTLS          = errors.New("TLS failure")
+	ErrClusterUnauthorized = errors.New("unauthorized")
+	ErrClusterIncompatible = errors.New("API incompatible")
+)
+
+// PingError is returned by Ping when the cluster at Host cannot be used.
+// Category is one of the Ping error categories and Err the failure of the
+// request
+type PingError struct {
+	Host     string
+	Category error
+	Err      error
+}
+
+func (e *PingError) Error() string {
+	return fmt.Sprintf("cannot use the cluster at %s, %s: %v", e.Host, e.Category, e.Err)
+}
+
+func (e *PingError) Unwrap() []error {
+	return []error{e.Category, e.Err}
+}
+
+// Hint tells what to check for the category of the error
+func (e *PingError) Hint() string {
+	switch e.Category {
+	case ErrClusterTLS:
+		return "check the certificate-authority-data of the kubeconfig cluster, or the proxy intercepting the connection"
+	case ErrClusterUnauthorized:
+		return "check the credentials of the kubeconfig user, they may have expired or lack cluster-admin"
+	case ErrClusterIncompatible:
+		return "check that the kubeconfig server is the Kubernetes API of the Harvester cluster"
+	default:
+		return "check the kubeconfig server address, the network, the VPN and --proxy"
+	}
+}
+
+// Ping makes a minimal authenticated request to the cluster, listing a
+// namespace, and reads its version. A failure is returned as a PingError
+// telling whether the cluster could not be reached, failed the TLS
+// handshake, refused the credentials or is not a Kubernetes API
+func (c *Client) Ping(ctx context.Context) error {
+	host := ""
+	if c.RestConfig != nil {
+		host = c.RestConfig.Host
+	}
+
+	if _, err := c.Clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{Limit: 1}); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("failed to reach the cluster at %s: %w", host, err)
+		}
+		return &PingError{Host: host, Category: pingCategory(err), Err: err}
+	}
+
+	version, err := c.Clientset.Discovery().ServerVersion()
+	if err != nil {
+		return &PingError{Host: host, Category: pingCategory(err), Err: fmt.Errorf("failed to read the kubernetes version: %w", err)}
+	}
+	if _, err := semver.NewVersion(version.GitVersion); err != nil {
+		return &PingError{Host: host, Category: ErrClusterIncompatible, Err: fmt.Errorf("unknown kubernetes version %q", version.GitVersion)}
+	}
+
+	return nil
+}
+
+// pingCategory tells which of the Ping error categories err falls in. A
+// server answering other than as a Kubernetes API is incompatible
+func pingCategory(err error) error {
+	var (
+		unknownAuthority *x509.UnknownAuthorityError
+		hostname         x509.HostnameError
+		invalid          x509.CertificateInvalidError
+		verification     *tls.CertificateVerificationError
+		recordHeader     tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(err, &unknownAuthority), errors.As(err, &hostname), errors.As(err, &invalid),
+		errors.As(err, &verification), errors.As(err, &recordHeader):
+		return ErrClusterTLS
+	case apierrors.IsUnauthorized(err), apierrors.IsForbidden(err):
+		return ErrClusterUnauthorized
+	case isConnectionError(err), apierrors.IsServiceUnavailable(err), apierrors.IsTimeout(err),
+		apierrors.IsServerTimeout(err), apierrors.IsInternalError(err):
+		return ErrClusterUnreachable
+	default:
+		return ErrClusterIncompatible
+	}
+}
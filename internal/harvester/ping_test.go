@@ -0,0 +1,91 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPing(t *testing.T) {
+	kubernetes := func(version string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Path == "/version" {
+				fmt.Fprintf(w, `{"major":"1","minor":"30","gitVersion":%q}`, version)
+				return
+			}
+			fmt.Fprint(w, `{"kind":"NamespaceList","apiVersion":"v1","items":[]}`)
+		}
+	}
+	status := func(code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(code)
+			fmt.Fprintf(w, `{"kind":"Status","apiVersion":"v1","status":"Failure","code":%d}`, code)
+		}
+	}
+
+	ping := func(t *testing.T, server string) error {
+		t.Helper()
+		client, err := NewClient(newKubeconfig(t, server), "", "")
+		require.NoError(t, err)
+		return client.Ping(context.Background())
+	}
+
+	t.Run("reachable", func(t *testing.T) {
+		server := httptest.NewServer(kubernetes("v1.30.4+rke2r1"))
+		defer server.Close()
+
+		require.NoError(t, ping(t, server.URL))
+	})
+
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		tls      bool
+		category error
+	}{
+		{name: "unauthorized", handler: status(http.StatusUnauthorized), category: ErrClusterUnauthorized},
+		{name: "forbidden", handler: status(http.StatusForbidden), category: ErrClusterUnauthorized},
+		{name: "unknown certificate authority", handler: kubernetes("v1.30.4"), tls: true, category: ErrClusterTLS},
+		{name: "not a kubernetes api", handler: http.NotFoundHandler(), category: ErrClusterIncompatible},
+		{name: "unknown version", handler: kubernetes("harvester"), category: ErrClusterIncompatible},
+		{name: "unavailable", handler: status(http.StatusServiceUnavailable), category: ErrClusterUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewUnstartedServer(tt.handler)
+			if tt.tls {
+				server.StartTLS()
+			} else {
+				server.Start()
+			}
+			defer server.Close()
+
+			err := ping(t, server.URL)
+			require.ErrorIs(t, err, tt.category)
+			var pingErr *PingError
+			require.ErrorAs(t, err, &pingErr)
+			assert.Equal(t, server.URL, pingErr.Host)
+		})
+	}
+
+	t.Run("unreachable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		address := listener.Addr().String()
+		listener.Close()
+
+		err = ping(t, "http://"+address)
+		require.ErrorIs(t, err, ErrClusterUnreachable)
+		var pingErr *PingError
+		require.ErrorAs(t, err, &pingErr)
+		assert.Contains(t, pingErr.Hint(), "--proxy")
+	})
+}
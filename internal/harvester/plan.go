@@ -0,0 +1,255 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// PlanOptions are the create flags that decide which steps of a
+// provisioning run happen
+type PlanOptions struct {
+	StopAfter                string
+	HA                       bool
+	GPU                      bool
+	InstallIstio             bool
+	InstallKgateway          bool
+	IngressDomains           bool
+	VClusters                []string
+	VClusterIstio            bool
+	VClusterIngressWildcard  bool
+	VClusterNetworkIsolation bool
+	VClusterAppSet           bool
+	TrustBundle              bool
+	TrustBundleProbe         bool
+	RegistryMirror           bool
+	SSO                      bool
+	Observability            bool
+	Logging                  bool
+	ExternalSecrets          bool
+	Backup                   bool
+	VaultAutoUnseal          string
+	VaultSeed                bool
+	VaultTeamPolicies        bool
+	SkipVerify               bool
+	Wait                     bool
+}
+
+// PlanStep is a step of a provisioning run, with the reason it is skipped
+// when it is
+type PlanStep struct {
+	Name       string
+	Phase      string
+	Estimate   time.Duration
+	SkipReason string
+}
+
+// Plan is the ordered list of steps a provisioning run performs
+type Plan []PlanStep
+
+// BuildPlan returns the steps create runs with opts, estimating each from
+// typical runs on Harvester
+func BuildPlan(opts PlanOptions) Plan {
+	var plan Plan
+	add := func(name, phase string, estimate time.Duration, skipReason string) {
+		if skipReason == "" && phase != "" && !PhaseEnabled(opts.StopAfter, phase) {
+			skipReason = fmt.Sprintf("--stop-after=%s", opts.StopAfter)
+		}
+		plan = append(plan, PlanStep{Name: name, Phase: phase, Estimate: estimate, SkipReason: skipReason})
+	}
+	unless := func(enabled bool, reason string) string {
+		if enabled {
+			return ""
+		}
+		return reason
+	}
+
+	add("Validate Configuration", "", time.Minute, "")
+	add("Verify Control Plane Quorum", "", 2*time.Minute, unless(opts.HA, "--ha is not set"))
+	add("Configure Registry Mirror", "", time.Minute, unless(opts.RegistryMirror, "--registry-mirror is not set"))
+	add("Taint GPU Nodes", "", time.Minute, unless(opts.GPU, "--gpu-nodes is not set"))
+	add("Install ArgoCD and GitOps Repository", PhaseArgoCD, 8*time.Minute, "")
+	add("Configure Ingress and Load Balancers", PhaseIngress, 3*time.Minute, "")
+	add("Install Istio", PhaseIngress, 2*time.Minute, unless(opts.InstallIstio, "--install-istio=false"))
+	add("Install Kgateway", PhaseIngress, time.Minute, unless(opts.InstallKgateway, "--install-kgateway=false"))
+	add("Configure Ingress Domains", PhaseIngress, time.Minute, unless(opts.IngressDomains, "--extra-domains and --vcluster-domain-map are not set"))
+
+	if len(opts.VClusters) == 0 {
+		add("Provision vClusters", PhaseVCluster, 0, "--vclusters is empty")
+	}
+	for _, vcluster := range opts.VClusters {
+		add(fmt.Sprintf("Provision vCluster %s", vcluster), PhaseVCluster, 2*time.Minute, "")
+	}
+	add("Configure vCluster ApplicationSet", PhaseVCluster, time.Minute, unless(opts.VClusterAppSet, "--vcluster-appset=false"))
+	valuesReason := unless(!opts.VClusterAppSet, "the ApplicationSet directories hold the values")
+	if valuesReason == "" {
+		valuesReason = unless(len(opts.VClusters) > 0, "--vclusters is empty")
+	}
+	add("Configure vCluster Values", PhaseVCluster, time.Minute, valuesReason)
+	add("Distribute Trust Bundle", PhaseVCluster, time.Minute, unless(opts.TrustBundle, "--trust-bundle is not set"))
+	add("Configure vCluster Istio Ambient Mode", PhaseVCluster, time.Minute, unless(opts.VClusterIstio, "--vcluster-istio is not set"))
+	add("Configure vCluster Wildcard Ingress", PhaseVCluster, time.Minute, unless(opts.VClusterIngressWildcard, "--vcluster-ingress-wildcard is not set"))
+	add("Apply vCluster Network Policies", PhaseVCluster, time.Minute, unless(opts.VClusterNetworkIsolation, "--vcluster-network-isolation is not set"))
+
+	add("Install Vault", PhaseVault, 2*time.Minute, "")
+	add("Configure Vault Auto-Unseal", PhaseVault, time.Minute, unless(opts.VaultAutoUnseal == VaultUnsealStatic, "--vault-auto-unseal is not static"))
+	add("Seed Vault Secrets", PhaseVault, time.Minute, unless(opts.VaultSeed, "--vault-seed-file is not set"))
+	add("Configure Vault Team Policies", PhaseVault, time.Minute, unless(opts.VaultTeamPolicies, "--vault-team-policies is not set"))
+	add("Configure External Secrets", PhaseVault, time.Minute, unless(opts.ExternalSecrets, "--external-secrets is not set"))
+	add("Configure Backups", "", time.Minute, unless(opts.Backup, "--backup-schedule is not set"))
+	add("Configure SSO", PhaseSSO, time.Minute, unless(opts.SSO, "no --oidc-* flags are set"))
+	add("Install Observability", PhaseObservability, 3*time.Minute, unless(opts.Observability, "--install-observability is not set"))
+	add("Install Logging", PhaseObservability, 3*time.Minute, unless(opts.Logging, "--logging is not set"))
+	waitReason := unless(opts.StopAfter != "", "--stop-after is not set")
+	if waitReason == "" {
+		waitReason = unless(opts.Wait, "--wait=false")
+	}
+	add("Wait for Phase Applications", "", 2*time.Minute, waitReason)
+	verifyReason := unless(!opts.SkipVerify, "--skip-verify")
+	if verifyReason == "" && opts.StopAfter != "" {
+		verifyReason = unless(opts.Wait, "--wait=false")
+	}
+	add("Verify Platform Health", "", 2*time.Minute, verifyReason)
+	trustReason := unless(opts.TrustBundleProbe, "--trust-bundle-probe-url is not set")
+	if trustReason == "" {
+		trustReason = verifyReason
+	}
+	add("Verify Trust Bundle", PhaseVCluster, time.Minute, trustReason)
+	wildcardReason := unless(opts.VClusterIngressWildcard, "--vcluster-ingress-wildcard is not set")
+	if wildcardReason == "" {
+		wildcardReason = verifyReason
+	}
+	add("Verify vCluster Wildcard Ingress", PhaseVCluster, time.Minute, wildcardReason)
+	gpuReason := unless(opts.GPU, "--gpu-nodes is not set")
+	if gpuReason == "" {
+		gpuReason = unless(opts.StopAfter == "", "--stop-after is set")
+	}
+	add("Run GPU Smoke Test", "", 5*time.Minute, gpuReason)
+
+	return plan
+}
+
+// CompletedPhase returns the last phase whose steps, like those of every
+// phase before it, all run before stepName: where --stop-after would have
+// left the cluster had the run stopped there. It is empty when no phase
+// completed
+func (p Plan) CompletedPhase(stepName string) string {
+	index := slices.IndexFunc(p, func(step PlanStep) bool { return step.Name == stepName })
+	if index < 0 {
+		return ""
+	}
+
+	completed := ""
+	for _, phase := range Phases {
+		ran := false
+		for i, step := range p {
+			if step.Phase != phase || step.SkipReason != "" {
+				continue
+			}
+			if i >= index {
+				return completed
+			}
+			ran = true
+		}
+		if ran {
+			completed = phase
+		}
+	}
+
+	return completed
+}
+
+// Estimate sums the estimates of the steps that are not skipped
+func (p Plan) Estimate() time.Duration {
+	var total time.Duration
+	for _, step := range p {
+		if step.SkipReason == "" {
+			total += step.Estimate
+		}
+	}
+
+	return total
+}
+
+// planStepRuntime lists the steps a run renders for the plan steps that
+// span several of them, the other plan steps render under their own name
+var planStepRuntime = map[string][]string{
+	"Install ArgoCD and GitOps Repository": {
+		"Run Pre-flight Checks", "Initialize Configuration", "Validate Git Credentials", "Validate Harvester Cluster",
+		"Create Management Cluster", "Install Tools", "Domain Liveness", "KBot Setup", "Git Init", "GitOps Ready",
+		"Git Terraform Apply", "GitOps Pushed", "Cloud Terraform Apply", "Cluster Secrets Created",
+		"ArgoCD Install", "ArgoCD Initialize", "Record GitOps Commit",
+	},
+	"Configure Ingress and Load Balancers": {"Configure Load Balancer Pool", "Verify Load Balancer Allocation", "Reconcile DNS Records", "Verify DNS Propagation"},
+	"Install Vault":                        {"Vault Initialized", "Vault Terraform Apply", "Users Terraform Apply"},
+}
+
+// Weights returns the expected duration of every step the run renders, as
+// consumed by step.NewProgress. The estimate of a plan step spanning
+// several steps is split between them, and the applications kubefirst-api
+// waits on in its final check carry the estimates of the components they
+// install
+func (p Plan) Weights() map[string]time.Duration {
+	weights := map[string]time.Duration{}
+	for _, step := range p {
+		if step.SkipReason != "" {
+			continue
+		}
+
+		switch runtime, ok := planStepRuntime[step.Name]; {
+		case ok:
+			for _, name := range runtime {
+				weights[name] += step.Estimate / time.Duration(len(runtime))
+			}
+		case step.Phase == PhaseIngress || step.Phase == PhaseVCluster && strings.HasPrefix(step.Name, "Provision vCluster "):
+			weights["Final Check"] += step.Estimate
+		default:
+			weights[step.Name] += step.Estimate
+		}
+	}
+
+	return weights
+}
+
+// EstimateMinutes returns Estimate rounded up to whole minutes
+func (p Plan) EstimateMinutes() int {
+	return int(math.Ceil(p.Estimate().Minutes()))
+}
+
+// Render lists the steps of the plan as a table followed by its estimate
+func (p Plan) Render() string {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tPHASE\tESTIMATE")
+	for _, step := range p {
+		phase := step.Phase
+		if phase == "" {
+			phase = "-"
+		}
+
+		estimate := step.Estimate.String()
+		if step.Estimate%time.Minute == 0 {
+			estimate = fmt.Sprintf("%dm", int(step.Estimate.Minutes()))
+		}
+		if step.SkipReason != "" {
+			estimate = fmt.Sprintf("skipped (%s)", step.SkipReason)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", step.Name, phase, estimate)
+	}
+	w.Flush()
+
+	fmt.Fprintf(&b, "\nEstimated time: %d minutes\n", p.EstimateMinutes())
+
+	return b.String()
+}
@@ -0,0 +1,154 @@
+package harvester
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func skipped(plan Plan) map[string]string {
+	reasons := map[string]string{}
+	for _, step := range plan {
+		if step.SkipReason != "" {
+			reasons[step.Name] = step.SkipReason
+		}
+	}
+
+	return reasons
+}
+
+func TestBuildPlan(t *testing.T) {
+	defaults := PlanOptions{InstallIstio: true, InstallKgateway: true, VClusters: []string{"dev", "test", "prod"}, VClusterAppSet: true}
+
+	t.Run("defaults", func(t *testing.T) {
+		plan := BuildPlan(defaults)
+
+		assert.Equal(t, 26, plan.EstimateMinutes())
+		assert.Equal(t, map[string]string{
+			"Verify Control Plane Quorum":           "--ha is not set",
+			"Taint GPU Nodes":                       "--gpu-nodes is not set",
+			"Run GPU Smoke Test":                    "--gpu-nodes is not set",
+			"Configure vCluster Istio Ambient Mode": "--vcluster-istio is not set",
+			"Configure vCluster Wildcard Ingress":   "--vcluster-ingress-wildcard is not set",
+			"Apply vCluster Network Policies":       "--vcluster-network-isolation is not set",
+			"Configure Registry Mirror":             "--registry-mirror is not set",
+			"Configure vCluster Values":             "the ApplicationSet directories hold the values",
+			"Wait for Phase Applications":           "--stop-after is not set",
+			"Configure SSO":                         "no --oidc-* flags are set",
+			"Install Observability":                 "--install-observability is not set",
+			"Install Logging":                       "--logging is not set",
+			"Distribute Trust Bundle":               "--trust-bundle is not set",
+			"Verify Trust Bundle":                   "--trust-bundle-probe-url is not set",
+			"Verify vCluster Wildcard Ingress":      "--vcluster-ingress-wildcard is not set",
+			"Configure External Secrets":            "--external-secrets is not set",
+			"Configure Ingress Domains":             "--extra-domains and --vcluster-domain-map are not set",
+			"Configure Backups":                     "--backup-schedule is not set",
+			"Configure Vault Auto-Unseal":           "--vault-auto-unseal is not static",
+			"Seed Vault Secrets":                    "--vault-seed-file is not set",
+			"Configure Vault Team Policies":         "--vault-team-policies is not set",
+		}, skipped(plan))
+	})
+
+	t.Run("vcluster applicationset can be turned off", func(t *testing.T) {
+		opts := defaults
+		opts.VClusterAppSet = false
+
+		reasons := skipped(BuildPlan(opts))
+		assert.Equal(t, "--vcluster-appset=false", reasons["Configure vCluster ApplicationSet"])
+		assert.NotContains(t, reasons, "Configure vCluster Values")
+	})
+
+	t.Run("external secrets run next to vault", func(t *testing.T) {
+		opts := defaults
+		opts.ExternalSecrets = true
+		opts.VaultSeed = true
+
+		reasons := skipped(BuildPlan(opts))
+		assert.NotContains(t, reasons, "Install Vault")
+		assert.NotContains(t, reasons, "Seed Vault Secrets")
+		assert.NotContains(t, reasons, "Configure External Secrets")
+
+		target := PhaseTargets(PhaseVault, nil, true, false)
+		assert.Equal(t, []string{"vault", ExternalSecretsCatalogApp}, target.Applications)
+	})
+
+	t.Run("disabled istio is skipped", func(t *testing.T) {
+		opts := defaults
+		opts.InstallIstio = false
+
+		plan := BuildPlan(opts)
+		assert.Equal(t, "--install-istio=false", skipped(plan)["Install Istio"])
+		assert.Equal(t, 24, plan.EstimateMinutes())
+	})
+
+	t.Run("stop-after skips the later phases", func(t *testing.T) {
+		opts := defaults
+		opts.StopAfter = PhaseIngress
+		opts.SSO = true
+
+		reasons := skipped(BuildPlan(opts))
+		assert.Equal(t, "--stop-after=ingress", reasons["Provision vCluster dev"])
+		assert.Equal(t, "--stop-after=ingress", reasons["Install Vault"])
+		assert.Equal(t, "--stop-after=ingress", reasons["Configure SSO"])
+		assert.NotContains(t, reasons, "Install Istio")
+	})
+
+	t.Run("stop-after without wait skips the waits", func(t *testing.T) {
+		opts := defaults
+		opts.StopAfter = PhaseVCluster
+
+		reasons := skipped(BuildPlan(opts))
+		assert.Equal(t, "--wait=false", reasons["Wait for Phase Applications"])
+		assert.Equal(t, "--wait=false", reasons["Verify Platform Health"])
+
+		opts.Wait = true
+		reasons = skipped(BuildPlan(opts))
+		assert.NotContains(t, reasons, "Wait for Phase Applications")
+		assert.NotContains(t, reasons, "Verify Platform Health")
+	})
+
+	t.Run("wildcard ingress is verified with the platform", func(t *testing.T) {
+		opts := defaults
+		opts.VClusterIngressWildcard = true
+
+		reasons := skipped(BuildPlan(opts))
+		assert.NotContains(t, reasons, "Configure vCluster Wildcard Ingress")
+		assert.NotContains(t, reasons, "Verify vCluster Wildcard Ingress")
+
+		opts.SkipVerify = true
+		assert.Equal(t, "--skip-verify", skipped(BuildPlan(opts))["Verify vCluster Wildcard Ingress"])
+	})
+
+	t.Run("weights cover the estimate", func(t *testing.T) {
+		plan := BuildPlan(defaults)
+		weights := plan.Weights()
+
+		var total time.Duration
+		for _, weight := range weights {
+			total += weight
+		}
+		assert.InDelta(t, plan.Estimate(), total, float64(time.Second))
+		assert.Equal(t, 45*time.Second, weights["Configure Load Balancer Pool"])
+		assert.Equal(t, 9*time.Minute, weights["Final Check"])
+		assert.NotContains(t, weights, "Verify Control Plane Quorum")
+	})
+
+	t.Run("render lists skipped steps", func(t *testing.T) {
+		rendered := BuildPlan(PlanOptions{}).Render()
+
+		assert.Contains(t, rendered, "skipped (--install-istio=false)")
+		assert.Contains(t, rendered, "skipped (--vclusters is empty)")
+		assert.Contains(t, rendered, "Estimated time: 16 minutes")
+	})
+}
+
+func TestPlanCompletedPhase(t *testing.T) {
+	plan := BuildPlan(PlanOptions{VClusters: []string{"dev"}, InstallIstio: true})
+
+	assert.Empty(t, plan.CompletedPhase("Install ArgoCD and GitOps Repository"))
+	assert.Equal(t, PhaseArgoCD, plan.CompletedPhase("Install Istio"))
+	assert.Equal(t, PhaseVCluster, plan.CompletedPhase("Install Vault"))
+	assert.Equal(t, PhaseVault, plan.CompletedPhase("Verify Platform Health"))
+	assert.Empty(t, plan.CompletedPhase("Unknown Step"))
+}
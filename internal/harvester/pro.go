@@ -0,0 +1,152 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultKubefirstProChartURL is the helm repository kubefirst pro is
+	// published to
+	DefaultKubefirstProChartURL = "https://charts.konstruct.io"
+	KubefirstProChart           = "kubefirst-pro"
+
+	// LatestKubefirstProVersion resolves to the newest stable release
+	LatestKubefirstProVersion = "latest"
+)
+
+type chartIndex struct {
+	Entries map[string][]chartVersion `yaml:"entries"`
+}
+
+type chartVersion struct {
+	Version    string   `yaml:"version"`
+	URLs       []string `yaml:"urls"`
+	Deprecated bool     `yaml:"deprecated"`
+}
+
+// ResolveKubefirstProVersion looks version up in the index of the helm
+// repository at chartURL and returns the concrete chart version to pin.
+// LatestKubefirstProVersion resolves to the highest stable release; any
+// other version has to be published and installable as is
+func ResolveKubefirstProVersion(ctx context.Context, httpClient *http.Client, chartURL, version string) (string, error) {
+	indexURL := strings.TrimSuffix(chartURL, "/") + "/index.yaml"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, indexURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build chart index request: %w", err)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch chart index %q: %w", indexURL, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch chart index %q: unexpected status %q", indexURL, res.Status)
+	}
+
+	var index chartIndex
+	if err := yaml.NewDecoder(res.Body).Decode(&index); err != nil {
+		return "", fmt.Errorf("failed to decode chart index %q: %w", indexURL, err)
+	}
+
+	versions := index.Entries[KubefirstProChart]
+	if len(versions) == 0 {
+		return "", fmt.Errorf("chart %q is not published in %q", KubefirstProChart, chartURL)
+	}
+
+	if version == LatestKubefirstProVersion {
+		return latestChartVersion(versions, chartURL)
+	}
+
+	want := strings.TrimPrefix(version, "v")
+	for _, candidate := range versions {
+		if strings.TrimPrefix(candidate.Version, "v") != want {
+			continue
+		}
+		if candidate.Deprecated {
+			return "", fmt.Errorf("%s %s is deprecated in %q", KubefirstProChart, version, chartURL)
+		}
+		if len(candidate.URLs) == 0 {
+			return "", fmt.Errorf("%s %s has no download url in %q", KubefirstProChart, version, chartURL)
+		}
+		return candidate.Version, nil
+	}
+
+	return "", fmt.Errorf("%s %s is not published in %q", KubefirstProChart, version, chartURL)
+}
+
+func latestChartVersion(versions []chartVersion, chartURL string) (string, error) {
+	var latest *semver.Version
+	var pin string
+	for _, candidate := range versions {
+		if candidate.Deprecated || len(candidate.URLs) == 0 {
+			continue
+		}
+
+		parsed, err := semver.NewVersion(candidate.Version)
+		if err != nil || parsed.Prerelease() != "" {
+			continue
+		}
+
+		if latest == nil || parsed.GreaterThan(latest) {
+			latest, pin = parsed, candidate.Version
+		}
+	}
+
+	if latest == nil {
+		return "", fmt.Errorf("no stable installable %s release in %q", KubefirstProChart, chartURL)
+	}
+
+	return pin, nil
+}
+
+// PinKubefirstPro pins the kubefirst pro chart of the ArgoCD applications in
+// files to version from the helm repository at chartURL, returning the
+// files that changed. The gitops template installing no kubefirst pro
+// chart is an error, the pin would otherwise be lost silently
+func PinKubefirstPro(files map[string][]byte, version, chartURL string) (map[string][]byte, error) {
+	installed := false
+	for name, content := range files {
+		if path.Base(name) == "Chart.yaml" {
+			continue
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if _, chart, ok := yamlKey(line, "chart"); ok && chart == KubefirstProChart {
+				installed = true
+			}
+		}
+	}
+	if !installed {
+		return nil, fmt.Errorf("no ArgoCD application of the gitops repository installs the %s chart", KubefirstProChart)
+	}
+
+	changed := PinChartVersions(files, map[string]string{KubefirstProChart: version})
+
+	pinned := make(map[string][]byte, len(files))
+	for name, content := range files {
+		pinned[name] = content
+	}
+	for name, content := range changed {
+		pinned[name] = content
+	}
+	for name, content := range PinChartRepositories(pinned, map[string]string{KubefirstProChart: chartURL}) {
+		changed[name] = content
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,87 @@
+package harvester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testChartIndex = `apiVersion: v1
+entries:
+  kubefirst-pro:
+  - version: 0.4.0-rc.1
+    urls: [kubefirst-pro-0.4.0-rc.1.tgz]
+  - version: 0.3.2
+    urls: [kubefirst-pro-0.3.2.tgz]
+  - version: 0.3.10
+    urls: [kubefirst-pro-0.3.10.tgz]
+  - version: 0.3.11
+    urls: [kubefirst-pro-0.3.11.tgz]
+    deprecated: true
+  - version: 0.3.12
+`
+
+func TestResolveKubefirstProVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testChartIndex))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		version string
+		want    string
+		wantErr string
+	}{
+		{name: "latest skips prereleases and uninstallable", version: LatestKubefirstProVersion, want: "0.3.10"},
+		{name: "pinned", version: "0.3.2", want: "0.3.2"},
+		{name: "pinned with v prefix", version: "v0.3.2", want: "0.3.2"},
+		{name: "pinned prerelease", version: "0.4.0-rc.1", want: "0.4.0-rc.1"},
+		{name: "deprecated", version: "0.3.11", wantErr: "deprecated"},
+		{name: "no download url", version: "0.3.12", wantErr: "no download url"},
+		{name: "unpublished", version: "9.9.9", wantErr: "not published"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveKubefirstProVersion(context.Background(), server.Client(), server.URL+"/", tt.version)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := ResolveKubefirstProVersion(context.Background(), server.Client(), server.URL+"/missing", "latest")
+	require.ErrorContains(t, err, "unexpected status")
+}
+
+func TestPinKubefirstPro(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kubefirst/kubefirst-pro.yaml": []byte("kind: Application\nspec:\n  source:\n    repoURL: https://charts.konstruct.io\n    chart: kubefirst-pro\n    targetRevision: 0.3.10\n"),
+		"registry/kubefirst/vault.yaml":         []byte(vaultApplication),
+	}
+
+	changed, err := PinKubefirstPro(files, "0.3.2", "https://charts.internal.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"registry/kubefirst/kubefirst-pro.yaml": []byte("kind: Application\nspec:\n  source:\n    repoURL: https://charts.internal.example.com\n    chart: kubefirst-pro\n    targetRevision: 0.3.2\n"),
+	}, changed)
+
+	changed, err = PinKubefirstPro(files, "0.3.10", DefaultKubefirstProChartURL)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	_, err = PinKubefirstPro(map[string][]byte{"registry/kubefirst/vault.yaml": []byte(vaultApplication)}, "0.3.2", DefaultKubefirstProChartURL)
+	require.ErrorContains(t, err, "installs the kubefirst-pro chart")
+}
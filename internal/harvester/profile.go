@@ -0,0 +1,279 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// DefaultProfile is the profile used without --profile. It keeps the
+// kubefirst config and report directory of a single cluster machine, so
+// the clusters created before profiles stay where they are
+const DefaultProfile = "default"
+
+const (
+	profilesDir           = "harvester-profiles"
+	profileConfigFile     = "kubefirst.yaml"
+	profileKubeconfigFile = "kubeconfig"
+
+	// ClusterIdentityConfigMapName is the ConfigMap create records the
+	// cluster name in, for destroy to check it is run against the cluster
+	// its profile created
+	ClusterIdentityConfigMapName = "kubefirst-cluster"
+)
+
+var activeProfile atomic.Value
+
+// SetProfile makes name the profile of the whole process
+func SetProfile(name string) {
+	activeProfile.Store(name)
+}
+
+// ActiveProfile returns the profile SetProfile selected, DefaultProfile
+// when none was
+func ActiveProfile() string {
+	if name, ok := activeProfile.Load().(string); ok && name != "" {
+		return name
+	}
+
+	return DefaultProfile
+}
+
+// ValidateProfileName ensures name is a valid RFC 1123 label, as it names
+// the directory of the profile
+func ValidateProfileName(name string) error {
+	if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+		return fmt.Errorf("invalid profile name %q: %s", name, strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// ProfileDir returns the directory holding the kubefirst config, reports
+// and cached kubeconfig of profile,
+// $HOME/.k1/harvester-profiles/<profile>
+func ProfileDir(profile string) (string, error) {
+	homePath, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	return filepath.Join(homePath, ".k1", profilesDir, profile), nil
+}
+
+// ProfileConfigFile returns the kubefirst config of profile, $HOME/.kubefirst
+// for the default one
+func ProfileConfigFile(profile string) (string, error) {
+	if profile == DefaultProfile {
+		homePath, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get user home directory: %w", err)
+		}
+		return filepath.Join(homePath, ".kubefirst"), nil
+	}
+
+	dir, err := ProfileDir(profile)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, profileConfigFile), nil
+}
+
+// ProfileKubeconfig returns where create caches the kubeconfig of profile,
+// empty for the default one which reads --kubeconfig-path every run
+func ProfileKubeconfig(profile string) (string, error) {
+	if profile == DefaultProfile {
+		return "", nil
+	}
+
+	dir, err := ProfileDir(profile)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, profileKubeconfigFile), nil
+}
+
+// ProfileHealth is the platform health status last found for a profile
+type ProfileHealth struct {
+	Ready   int       `json:"ready"`
+	Total   int       `json:"total"`
+	Checked time.Time `json:"checked"`
+}
+
+// ParseProfileHealth parses the recorded health of a profile, an empty
+// value being none
+func ParseProfileHealth(value string) (ProfileHealth, bool, error) {
+	if value == "" {
+		return ProfileHealth{}, false, nil
+	}
+
+	var health ProfileHealth
+	if err := json.Unmarshal([]byte(value), &health); err != nil {
+		return ProfileHealth{}, false, fmt.Errorf("invalid profile health: %w", err)
+	}
+
+	return health, true, nil
+}
+
+// String encodes the health for the kubefirst config
+func (h ProfileHealth) String() string {
+	data, _ := json.Marshal(h)
+	return string(data)
+}
+
+// Summary renders the health for the terminal
+func (h ProfileHealth) Summary() string {
+	return fmt.Sprintf("%d/%d Healthy/Synced at %s", h.Ready, h.Total, h.Checked.Format(time.RFC3339))
+}
+
+// Profile is a profile found on this machine, as its kubefirst config
+// records it
+type Profile struct {
+	Name        string
+	ClusterName string
+	Domain      string
+	// Health is nil until status checked the platform of the profile
+	Health *ProfileHealth
+}
+
+// profileConfig is the part of a kubefirst config ListProfiles reads
+type profileConfig struct {
+	Flags struct {
+		ClusterName string `yaml:"cluster-name"`
+		DomainName  string `yaml:"domain-name"`
+	} `yaml:"flags"`
+	Harvester struct {
+		Health string `yaml:"health"`
+	} `yaml:"harvester"`
+}
+
+// ListProfiles returns the profiles with a kubefirst config on this
+// machine, the default one first when it recorded a Harvester cluster
+func ListProfiles() ([]Profile, error) {
+	defaultConfig, err := ProfileConfigFile(DefaultProfile)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ProfileDir("")
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the profiles in %s: %w", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && ValidateProfileName(entry.Name()) == nil && entry.Name() != DefaultProfile {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var profiles []Profile
+	defaultProfile, found, err := readProfile(DefaultProfile, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	if found && defaultProfile.ClusterName != "" {
+		profiles = append(profiles, defaultProfile)
+	}
+	for _, name := range names {
+		profile, found, err := readProfile(name, filepath.Join(dir, name, profileConfigFile))
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			profiles = append(profiles, profile)
+		}
+	}
+
+	return profiles, nil
+}
+
+func readProfile(name, configFile string) (Profile, bool, error) {
+	data, err := os.ReadFile(configFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return Profile{}, false, nil
+	}
+	if err != nil {
+		return Profile{}, false, fmt.Errorf("failed to read the kubefirst config of profile %s: %w", name, err)
+	}
+
+	var config profileConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return Profile{}, false, fmt.Errorf("invalid kubefirst config of profile %s: %w", name, err)
+	}
+
+	profile := Profile{Name: name, ClusterName: config.Flags.ClusterName, Domain: config.Flags.DomainName}
+	health, found, err := ParseProfileHealth(config.Harvester.Health)
+	if err != nil {
+		return Profile{}, false, fmt.Errorf("profile %s: %w", name, err)
+	}
+	if found {
+		profile.Health = &health
+	}
+
+	return profile, true, nil
+}
+
+// RecordClusterName records the name of the kubefirst cluster on the
+// Harvester cluster, creating the kubefirst namespace when kubefirst-api
+// did not yet
+func (c *Client) RecordClusterName(ctx context.Context, clusterName string) error {
+	configMap := corev1apply.ConfigMap(ClusterIdentityConfigMapName, GitopsHistorySecretNamespace).
+		WithData(map[string]string{"clusterName": clusterName})
+	options := metav1.ApplyOptions{FieldManager: fieldManager, Force: true}
+
+	_, err := c.Clientset.CoreV1().ConfigMaps(GitopsHistorySecretNamespace).Apply(ctx, configMap, options)
+	if apierrors.IsNotFound(err) {
+		if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, corev1apply.Namespace(GitopsHistorySecretNamespace), options); err != nil {
+			return fmt.Errorf("failed to apply namespace %s: %w", GitopsHistorySecretNamespace, err)
+		}
+		_, err = c.Clientset.CoreV1().ConfigMaps(GitopsHistorySecretNamespace).Apply(ctx, configMap, options)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply configmap %s/%s: %w", GitopsHistorySecretNamespace, ClusterIdentityConfigMapName, err)
+	}
+
+	return nil
+}
+
+// RecordedClusterName returns the kubefirst cluster name create recorded
+// on the Harvester cluster, reporting false for the clusters created before
+// it was recorded
+func (c *Client) RecordedClusterName(ctx context.Context) (string, bool, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(GitopsHistorySecretNamespace).Get(ctx, ClusterIdentityConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read configmap %s/%s: %w", GitopsHistorySecretNamespace, ClusterIdentityConfigMapName, err)
+	}
+
+	clusterName := configMap.Data["clusterName"]
+	return clusterName, clusterName != "", nil
+}
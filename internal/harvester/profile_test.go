@@ -0,0 +1,61 @@
+package harvester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListProfiles(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	profiles, err := ListProfiles()
+	require.NoError(t, err)
+	assert.Empty(t, profiles)
+
+	checked := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	health := ProfileHealth{Ready: 12, Total: 14, Checked: checked}
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".kubefirst"), []byte("flags:\n  cluster-name: home\n  domain-name: home.example.com\n"), 0o600))
+	office, err := ProfileConfigFile("office")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Dir(office), 0o700))
+	require.NoError(t, os.WriteFile(office, []byte("flags:\n  cluster-name: office\n  domain-name: office.example.com\nharvester:\n  health: '"+health.String()+"'\n"), 0o600))
+
+	profiles, err = ListProfiles()
+	require.NoError(t, err)
+	assert.Equal(t, []Profile{
+		{Name: DefaultProfile, ClusterName: "home", Domain: "home.example.com"},
+		{Name: "office", ClusterName: "office", Domain: "office.example.com", Health: &health},
+	}, profiles)
+	assert.Equal(t, "12/14 Healthy/Synced at 2026-10-01T12:00:00Z", health.Summary())
+
+	SetProfile("office")
+	defer SetProfile(DefaultProfile)
+	dir, err := DefaultReportDir("office")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, ".k1", "harvester-profiles", "office", "office"), dir)
+
+	require.Error(t, ValidateProfileName("Office"))
+}
+
+func TestRecordClusterName(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset()}
+
+	_, found, err := client.RecordedClusterName(context.Background())
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	require.NoError(t, client.RecordClusterName(context.Background(), "office"))
+
+	clusterName, found, err := client.RecordedClusterName(context.Background())
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "office", clusterName)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// ProgressConfigMapName is the ConfigMap a create run records its
+// percent-complete in, for the status command and the kubefirst console to
+// show the number the CLI renders
+const ProgressConfigMapName = "kubefirst-progress"
+
+// ProvisionProgress is how far along a create run is
+type ProvisionProgress struct {
+	Percent   int
+	Step      string
+	Status    string
+	UpdatedAt time.Time
+}
+
+// RecordProgress writes progress to the progress ConfigMap, creating the
+// kubefirst namespace when kubefirst-api did not yet
+func (c *Client) RecordProgress(ctx context.Context, progress ProvisionProgress) error {
+	configMap := corev1apply.ConfigMap(ProgressConfigMapName, GitopsHistorySecretNamespace).
+		WithData(map[string]string{
+			"percent":   strconv.Itoa(progress.Percent),
+			"step":      progress.Step,
+			"status":    progress.Status,
+			"updatedAt": progress.UpdatedAt.UTC().Format(time.RFC3339),
+		})
+	options := metav1.ApplyOptions{FieldManager: fieldManager, Force: true}
+
+	_, err := c.Clientset.CoreV1().ConfigMaps(GitopsHistorySecretNamespace).Apply(ctx, configMap, options)
+	if apierrors.IsNotFound(err) {
+		if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, corev1apply.Namespace(GitopsHistorySecretNamespace), options); err != nil {
+			return fmt.Errorf("failed to apply namespace %s: %w", GitopsHistorySecretNamespace, err)
+		}
+		_, err = c.Clientset.CoreV1().ConfigMaps(GitopsHistorySecretNamespace).Apply(ctx, configMap, options)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply configmap %s/%s: %w", GitopsHistorySecretNamespace, ProgressConfigMapName, err)
+	}
+
+	return nil
+}
+
+// ReadProgress reads the progress ConfigMap, returning nil when no create
+// run recorded one
+func (c *Client) ReadProgress(ctx context.Context) (*ProvisionProgress, error) {
+	configMap, err := c.Clientset.CoreV1().ConfigMaps(GitopsHistorySecretNamespace).Get(ctx, ProgressConfigMapName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configmap %s/%s: %w", GitopsHistorySecretNamespace, ProgressConfigMapName, err)
+	}
+
+	percent, err := strconv.Atoi(configMap.Data["percent"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid percent in configmap %s/%s: %w", GitopsHistorySecretNamespace, ProgressConfigMapName, err)
+	}
+	// a missing or malformed time is left zero
+	updatedAt, _ := time.Parse(time.RFC3339, configMap.Data["updatedAt"])
+
+	return &ProvisionProgress{
+		Percent:   percent,
+		Step:      configMap.Data["step"],
+		Status:    configMap.Data["status"],
+		UpdatedAt: updatedAt,
+	}, nil
+}
+
+// Summary renders progress for the terminal
+func (p *ProvisionProgress) Summary() string {
+	summary := fmt.Sprintf("provisioning %d%% complete, %s %s", p.Percent, p.Step, p.Status)
+	if !p.UpdatedAt.IsZero() {
+		summary += fmt.Sprintf(" at %s", p.UpdatedAt.Format(time.RFC3339))
+	}
+
+	return summary
+}
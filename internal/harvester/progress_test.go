@@ -0,0 +1,27 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecordProgress(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset()}
+
+	progress, err := client.ReadProgress(context.Background())
+	require.NoError(t, err)
+	assert.Nil(t, progress)
+
+	updatedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, client.RecordProgress(context.Background(), ProvisionProgress{Percent: 42, Step: "ArgoCD Initialize", Status: "running", UpdatedAt: updatedAt}))
+
+	progress, err = client.ReadProgress(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, &ProvisionProgress{Percent: 42, Step: "ArgoCD Initialize", Status: "running", UpdatedAt: updatedAt}, progress)
+	assert.Equal(t, "provisioning 42% complete, ArgoCD Initialize running at 2026-10-01T12:00:00Z", progress.Summary())
+}
@@ -0,0 +1,491 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// The stages of vcluster promote, in the order they run. A rerun skips the
+// stages the recorded Promotion completed
+const (
+	PromoteStageSecrets      = "secrets"
+	PromoteStageData         = "data"
+	PromoteStageRepoint      = "repoint"
+	PromoteStageVerify       = "verify"
+	PromoteStageDecommission = "decommission"
+)
+
+var PromoteStages = []string{PromoteStageSecrets, PromoteStageData, PromoteStageRepoint, PromoteStageVerify, PromoteStageDecommission}
+
+const (
+	// vclusterObjectName and vclusterObjectNamespace are the annotations
+	// vcluster keeps the name and namespace of a synced object inside the
+	// vcluster in
+	vclusterObjectName      = "vcluster.loft.sh/object-name"
+	vclusterObjectNamespace = "vcluster.loft.sh/object-namespace"
+	// externalSecretHash is the annotation External Secrets Operator sets on
+	// the secrets it creates
+	externalSecretHash = "reconcile.external-secrets.io/data-hash"
+
+	argoCDClusterSecret = "cluster"
+	promoteReportPrefix = "promote-"
+)
+
+var (
+	veleroBackupGVR  = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "backups"}
+	veleroRestoreGVR = schema.GroupVersionResource{Group: "velero.io", Version: "v1", Resource: "restores"}
+)
+
+// Promotion records a vcluster promote run: the applications it moves
+// and where it got to, so a rerun resumes at the first stage not completed
+type Promotion struct {
+	VCluster string `json:"vcluster"`
+	Target   string `json:"target"`
+	// Applications are the ArgoCD applications deploying into the vcluster
+	// when the promotion started
+	Applications []string `json:"applications"`
+	Completed    []string `json:"completed,omitempty"`
+	// Backup is the Velero backup of the volumes of the vcluster, when the
+	// data stage ran
+	Backup string `json:"backup,omitempty"`
+	// Moved and FollowUps are what the stages moved and what they left to
+	// do by hand, for the migration report
+	Moved     []string  `json:"moved,omitempty"`
+	FollowUps []string  `json:"followUps,omitempty"`
+	Started   time.Time `json:"started"`
+	Finished  time.Time `json:"finished,omitempty"`
+}
+
+// ParsePromotion parses the recorded state of a promotion, an empty value
+// being none
+func ParsePromotion(value string) (*Promotion, bool, error) {
+	if value == "" {
+		return nil, false, nil
+	}
+
+	var promotion Promotion
+	if err := json.Unmarshal([]byte(value), &promotion); err != nil {
+		return nil, false, fmt.Errorf("invalid promotion state: %w", err)
+	}
+
+	return &promotion, true, nil
+}
+
+// String encodes the promotion for the kubefirst config
+func (p *Promotion) String() string {
+	data, _ := json.Marshal(p)
+	return string(data)
+}
+
+// Done reports whether stage was completed
+func (p *Promotion) Done(stage string) bool {
+	return slices.Contains(p.Completed, stage)
+}
+
+// Complete records stage as completed, along with what it moved and left
+// to do by hand
+func (p *Promotion) Complete(stage string, moved, followUps []string) {
+	if !p.Done(stage) {
+		p.Completed = append(p.Completed, stage)
+	}
+	p.Moved = append(p.Moved, moved...)
+	p.FollowUps = append(p.FollowUps, followUps...)
+}
+
+// Markdown renders the migration report of the promotion
+func (p *Promotion) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# kubefirst vcluster promotion: %s to %s\n\n", p.VCluster, p.Target)
+	fmt.Fprintf(&b, "Started %s\n\n", p.Started.Format(time.RFC3339))
+	if !p.Finished.IsZero() {
+		fmt.Fprintf(&b, "Finished %s\n\n", p.Finished.Format(time.RFC3339))
+	}
+
+	b.WriteString("## Stages\n\n| Stage | Status |\n| --- | --- |\n")
+	for _, stage := range PromoteStages {
+		status := "pending"
+		if p.Done(stage) {
+			status = "completed"
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", stage, status)
+	}
+
+	b.WriteString("\n## Moved\n\n")
+	writeList(&b, p.Moved)
+	b.WriteString("\n## Manual follow-up\n\n")
+	writeList(&b, p.FollowUps)
+
+	return b.String()
+}
+
+func writeList(b *strings.Builder, items []string) {
+	if len(items) == 0 {
+		b.WriteString("None\n")
+		return
+	}
+	for _, item := range items {
+		fmt.Fprintf(b, "- %s\n", item)
+	}
+}
+
+// WritePromotionReport writes the migration report of promotion into dir as
+// promote-<vcluster>.md and .json and returns the path of the Markdown file
+func WritePromotionReport(dir string, promotion *Promotion) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create report directory %q: %w", dir, err)
+	}
+
+	data, err := json.MarshalIndent(promotion, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render promotion report: %w", err)
+	}
+
+	name := promoteReportPrefix + promotion.VCluster
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write promotion report: %w", err)
+	}
+
+	markdownPath := filepath.Join(dir, name+".md")
+	if err := os.WriteFile(markdownPath, []byte(promotion.Markdown()), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write promotion report: %w", err)
+	}
+
+	return markdownPath, nil
+}
+
+// ArgoCDClusterServer returns the API server address of the cluster ArgoCD
+// has registered under name
+func (c *Client) ArgoCDClusterServer(ctx context.Context, name string) (string, bool, error) {
+	secrets, err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: argoCDSecretTypeLabel + "=" + argoCDClusterSecret,
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list the argocd clusters: %w", err)
+	}
+
+	for _, secret := range secrets.Items {
+		if string(secret.Data["name"]) == name {
+			return string(secret.Data["server"]), true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// VClusterApplications returns the names of the ArgoCD applications
+// deploying into vcluster, addressed by its ArgoCD cluster name or its
+// server
+func (c *Client) VClusterApplications(ctx context.Context, vcluster, server string) ([]string, error) {
+	apps, err := c.ListApplications(ctx, "*")
+	if errors.Is(err, ErrApplicationNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, app := range apps {
+		destination := app.Spec.Destination
+		if destination.Name == vcluster || (server != "" && destination.Server == server) {
+			names = append(names, app.Name)
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// RepointApplications rewrites the destinations of the ArgoCD applications
+// and ApplicationSet templates in files, keyed by their path in the gitops
+// repository, from vcluster, addressed by name or server, to the cluster
+// ArgoCD registered as target. The rest of each file is left untouched and
+// only the files that changed are returned
+func RepointApplications(files map[string][]byte, vcluster, server, target string) map[string][]byte {
+	changed := map[string][]byte{}
+
+	for name, content := range files {
+		lines := strings.Split(string(content), "\n")
+		modified := false
+		for i, line := range lines {
+			column, value, ok := yamlKey(line, "name")
+			matches := ok && value == vcluster
+			if !matches && server != "" {
+				column, value, ok = yamlKey(line, "server")
+				matches = ok && value == server
+			}
+			if !matches || !inDestination(lines, i, column) {
+				continue
+			}
+
+			_, comment := splitComment(line[column:])
+			lines[i] = fmt.Sprintf("%sname: %s%s", line[:column], target, comment)
+			modified = true
+		}
+
+		if modified {
+			changed[name] = []byte(strings.Join(lines, "\n"))
+		}
+	}
+
+	return changed
+}
+
+// inDestination reports whether the key of the line at, starting at
+// column, belongs to a destination mapping
+func inDestination(lines []string, at, column int) bool {
+	for i := at - 1; i >= 0; i-- {
+		indent, _, _, ok := lineLayout(lines[i])
+		if !ok || indent >= column {
+			continue
+		}
+		_, _, ok = yamlKey(lines[i], "destination")
+		return ok
+	}
+
+	return false
+}
+
+// VClusterSecrets sorts the secrets synced out of vcluster into the ones
+// External Secrets Operator creates from Vault, which the target cluster
+// reads from the same paths, and the ones created inside the vcluster,
+// which have to be recreated on the target. Both are named
+// <namespace>/<name> as inside the vcluster
+func (c *Client) VClusterSecrets(ctx context.Context, vcluster string) (vault, raw []string, err error) {
+	secrets, err := c.Clientset.CoreV1().Secrets(VClusterNamespace(vcluster)).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the secrets of vcluster %q: %w", vcluster, err)
+	}
+
+	for _, secret := range secrets.Items {
+		name, synced := secret.Annotations[vclusterObjectName]
+		if !synced || secret.Type == corev1.SecretTypeServiceAccountToken || secret.Type == "helm.sh/release.v1" {
+			continue
+		}
+
+		name = secret.Annotations[vclusterObjectNamespace] + "/" + name
+		if _, ok := secret.Annotations[externalSecretHash]; ok {
+			vault = append(vault, name)
+		} else {
+			raw = append(raw, name)
+		}
+	}
+	sort.Strings(vault)
+	sort.Strings(raw)
+
+	return vault, raw, nil
+}
+
+// PromotedVaultMount is the Kubernetes auth method of Vault the workloads
+// of target log in through
+func PromotedVaultMount(target string) string {
+	return VaultKubernetesMount + "-" + target
+}
+
+// GrantPromotedSecrets lets the workloads of target read the Vault paths of
+// vcluster, where their secrets stay, with a policy bound to a role of the
+// Kubernetes auth method of target. The role is only written when that
+// auth method exists, written reports whether it was
+func GrantPromotedSecrets(ctx context.Context, vaultClient *vaultapi.Client, vcluster, target string) (policy string, written bool, err error) {
+	policy = "workload-" + target + "-" + vcluster + "-read"
+	policies := &VaultPolicies{Policies: map[string][]VaultPolicyRule{
+		policy: {
+			{Path: fmt.Sprintf("%s/data/vclusters/%s/*", vaultSeedMount, vcluster), Capabilities: []string{"read"}},
+			{Path: fmt.Sprintf("%s/metadata/vclusters/%s/*", vaultSeedMount, vcluster), Capabilities: []string{"list"}},
+		},
+	}}
+	if err := vaultClient.Sys().PutPolicyWithContext(ctx, policy, policies.HCL(policy)); err != nil {
+		return "", false, fmt.Errorf("failed to write vault policy %q: %w", policy, err)
+	}
+
+	mounts, err := vaultClient.Sys().ListAuthWithContext(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list vault auth methods: %w", err)
+	}
+	mount := PromotedVaultMount(target)
+	if _, ok := mounts[mount+"/"]; !ok {
+		return policy, false, nil
+	}
+
+	if _, err := vaultClient.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/role/%s", mount, vcluster), map[string]interface{}{
+		"bound_service_account_names":      []string{"*"},
+		"bound_service_account_namespaces": []string{"*"},
+		"token_policies":                   []string{policy},
+		"token_ttl":                        vaultRoleTokenTTL,
+	}); err != nil {
+		return "", false, fmt.Errorf("failed to write vault role %q of %s: %w", vcluster, mount, err)
+	}
+
+	return policy, true, nil
+}
+
+// VClusterClaims returns the persistent volume claims synced out of
+// vcluster, by their host name to their <namespace>/<name> inside it
+func (c *Client) VClusterClaims(ctx context.Context, vcluster string) (map[string]string, error) {
+	claims, err := c.Clientset.CoreV1().PersistentVolumeClaims(VClusterNamespace(vcluster)).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the volume claims of vcluster %q: %w", vcluster, err)
+	}
+
+	names := map[string]string{}
+	for _, claim := range claims.Items {
+		if name, ok := claim.Annotations[vclusterObjectName]; ok {
+			names[claim.Name] = claim.Annotations[vclusterObjectNamespace] + "/" + name
+		}
+	}
+
+	return names, nil
+}
+
+// BackupVCluster backs the host namespace of vcluster up with Velero as
+// backup, the volume data included, and waits for the backup to complete.
+// A backup of that name already there is waited for rather than created
+// again
+func (c *Client) BackupVCluster(ctx context.Context, vcluster, backup string) error {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "velero.io/v1",
+		"kind":       "Backup",
+		"metadata":   map[string]interface{}{"name": backup, "namespace": VeleroNamespace},
+		"spec": map[string]interface{}{
+			"includedNamespaces":       []interface{}{VClusterNamespace(vcluster)},
+			"defaultVolumesToFsBackup": true,
+		},
+	}}
+	if err := c.createVeleroObject(ctx, veleroBackupGVR, object); err != nil {
+		return err
+	}
+
+	return c.waitForVelero(ctx, veleroBackupGVR, backup)
+}
+
+// RestoreVolumes restores the volume claims of backup with Velero, from
+// the namespace they were backed up in to namespace, and waits for the
+// restore to complete. The Velero of the cluster has to read the storage
+// location the backup was written to
+func (c *Client) RestoreVolumes(ctx context.Context, backup, from, namespace string) error {
+	object := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "velero.io/v1",
+		"kind":       "Restore",
+		"metadata":   map[string]interface{}{"name": backup, "namespace": VeleroNamespace},
+		"spec": map[string]interface{}{
+			"backupName":             backup,
+			"includedResources":      []interface{}{"persistentvolumeclaims", "persistentvolumes"},
+			"namespaceMapping":       map[string]interface{}{from: namespace},
+			"restorePVs":             true,
+			"existingResourcePolicy": "none",
+		},
+	}}
+	if err := c.createVeleroObject(ctx, veleroRestoreGVR, object); err != nil {
+		return err
+	}
+
+	return c.waitForVelero(ctx, veleroRestoreGVR, backup)
+}
+
+func (c *Client) createVeleroObject(ctx context.Context, gvr schema.GroupVersionResource, object *unstructured.Unstructured) error {
+	_, err := c.Dynamic.Resource(gvr).Namespace(VeleroNamespace).Create(ctx, object, metav1.CreateOptions{FieldManager: fieldManager})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create velero %s %q: %w", object.GetKind(), object.GetName(), err)
+	}
+
+	return nil
+}
+
+// waitForVelero waits for the Velero backup or restore name to complete,
+// failing on the phases it ends in otherwise
+func (c *Client) waitForVelero(ctx context.Context, gvr schema.GroupVersionResource, name string) error {
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	phase := ""
+	for {
+		object, err := c.Dynamic.Resource(gvr).Namespace(VeleroNamespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get velero %s %q: %w", gvr.Resource, name, err)
+		}
+
+		phase, _, _ = unstructured.NestedString(object.Object, "status", "phase")
+		switch phase {
+		case "Completed":
+			return nil
+		case "Failed", "PartiallyFailed", "FailedValidation":
+			errs, _, _ := unstructured.NestedStringSlice(object.Object, "status", "validationErrors")
+			if reason, _, _ := unstructured.NestedString(object.Object, "status", "failureReason"); reason != "" {
+				errs = append(errs, reason)
+			}
+			return fmt.Errorf("velero %s %q %s: %s", gvr.Resource, name, phase, strings.Join(errs, "; "))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("velero %s %q still %q: %w", gvr.Resource, name, phase, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForPromotedApplications waits until every named application deploys
+// to the cluster ArgoCD registered as target, at server, and is Synced and
+// Healthy there. On timeout the error names the applications still
+// deploying elsewhere or not ready
+func (c *Client) WaitForPromotedApplications(ctx context.Context, names []string, target, server string) error {
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var pending []string
+		for _, name := range names {
+			app, err := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to get application %q: %w", name, err)
+			}
+
+			destination := app.Spec.Destination
+			switch {
+			case destination.Name != target && (server == "" || destination.Server != server):
+				pending = append(pending, fmt.Sprintf("%s still deploys to %s", name, orDefault(destination.Name, destination.Server)))
+			case !IsApplicationReady(app):
+				pending = append(pending, fmt.Sprintf("%s is %s/%s", name, app.Status.Sync.Status, app.Status.Health.Status))
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("applications not Healthy on %s: %s: %w", target, strings.Join(pending, "; "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,208 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const environmentApplication = `apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: dev-metaphor
+spec:
+  source:
+    path: charts/metaphor
+  destination:
+    name: dev # the vcluster
+    namespace: metaphor
+`
+
+const environmentAppSet = `apiVersion: argoproj.io/v1alpha1
+kind: ApplicationSet
+metadata:
+  name: dev-apps
+spec:
+  template:
+    metadata:
+      name: dev
+    spec:
+      destination:
+        server: https://dev.vcluster-dev.svc:443
+        namespace: apps
+`
+
+func TestRepointApplications(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kubefirst/dev/metaphor.yaml": []byte(environmentApplication),
+		"registry/kubefirst/dev/apps.yaml":     []byte(environmentAppSet),
+		// the application of the vcluster itself stays on the host
+		"registry/kubefirst/vclusters-appset.yaml": []byte("kind: Application\nmetadata:\n  name: dev\nspec:\n  destination:\n    server: https://kubernetes.default.svc\n"),
+	}
+
+	changed := RepointApplications(files, "dev", "https://dev.vcluster-dev.svc:443", "workload-1")
+	require.Len(t, changed, 2)
+	assert.Equal(t, strings.Replace(environmentApplication, "name: dev # the vcluster", "name: workload-1 # the vcluster", 1), string(changed["registry/kubefirst/dev/metaphor.yaml"]))
+	assert.Contains(t, string(changed["registry/kubefirst/dev/apps.yaml"]), "      name: dev\n")
+	assert.Contains(t, string(changed["registry/kubefirst/dev/apps.yaml"]), "      destination:\n        name: workload-1\n        namespace: apps\n")
+
+	assert.Empty(t, RepointApplications(changed, "dev", "https://dev.vcluster-dev.svc:443", "workload-1"))
+}
+
+func TestVClusterApplications(t *testing.T) {
+	app := func(name, destinationName string, ready bool) *v1alpha1.Application {
+		sync, healthStatus := v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy
+		if !ready {
+			sync, healthStatus = v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusProgressing
+		}
+		application := newApplication(name, sync, healthStatus)
+		application.Spec.Destination = v1alpha1.ApplicationDestination{Name: destinationName}
+		return application
+	}
+	client := &Client{
+		ArgoCD: argocdfake.NewSimpleClientset(
+			app("dev-metaphor", "dev", true),
+			app("dev-api", "workload-1", true),
+			app("prod-metaphor", "prod", true),
+			app("dev-worker", "workload-1", false),
+		),
+		Clientset: fake.NewSimpleClientset(&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cluster-workload-1", Namespace: ArgoCDNamespace, Labels: map[string]string{argoCDSecretTypeLabel: argoCDClusterSecret}},
+			Data:       map[string][]byte{"name": []byte("workload-1"), "server": []byte("https://10.0.20.5:6443")},
+		}),
+	}
+
+	names, err := client.VClusterApplications(context.Background(), "dev", "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"dev-metaphor"}, names)
+
+	server, found, err := client.ArgoCDClusterServer(context.Background(), "workload-1")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "https://10.0.20.5:6443", server)
+	_, found, err = client.ArgoCDClusterServer(context.Background(), "workload-2")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	err = client.WaitForPromotedApplications(canceledContext(), []string{"dev-metaphor", "dev-api", "dev-worker"}, "workload-1", server)
+	assert.ErrorContains(t, err, "dev-metaphor still deploys to dev; dev-worker is OutOfSync/Progressing")
+	require.NoError(t, client.WaitForPromotedApplications(context.Background(), []string{"dev-api"}, "workload-1", server))
+}
+
+func TestVClusterSecrets(t *testing.T) {
+	synced := func(name, namespace string, annotations map[string]string, secretType corev1.SecretType) *corev1.Secret {
+		annotations[vclusterObjectName] = name
+		annotations[vclusterObjectNamespace] = namespace
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name + "-x-" + namespace + "-x-dev", Namespace: VClusterNamespace("dev"), Annotations: annotations},
+			Type:       secretType,
+		}
+	}
+	client := &Client{Clientset: fake.NewSimpleClientset(
+		synced("db", "apps", map[string]string{externalSecretHash: "1"}, corev1.SecretTypeOpaque),
+		synced("tls", "apps", map[string]string{}, corev1.SecretTypeTLS),
+		synced("default-token", "apps", map[string]string{}, corev1.SecretTypeServiceAccountToken),
+		// the secrets of the vcluster itself are not synced out of it
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "vc-dev", Namespace: VClusterNamespace("dev")}},
+	)}
+
+	vault, raw, err := client.VClusterSecrets(context.Background(), "dev")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apps/db"}, vault)
+	assert.Equal(t, []string{"apps/tls"}, raw)
+}
+
+func TestGrantPromotedSecrets(t *testing.T) {
+	written := map[string]map[string]interface{}{}
+	var mounts map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/sys/auth":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": mounts})
+		case r.Method == http.MethodPut:
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			written[r.URL.Path] = body
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	vaultClient, err := vaultapi.NewClient(&vaultapi.Config{Address: server.URL})
+	require.NoError(t, err)
+
+	mounts = map[string]interface{}{"kubernetes/": map[string]interface{}{"type": "kubernetes"}}
+	policy, role, err := GrantPromotedSecrets(context.Background(), vaultClient, "dev", "workload-1")
+	require.NoError(t, err)
+	assert.Equal(t, "workload-workload-1-dev-read", policy)
+	assert.False(t, role, "the workload cluster has no auth method yet")
+	assert.Contains(t, written["/v1/sys/policies/acl/"+policy]["policy"], `path "secret/data/vclusters/dev/*"`)
+
+	mounts[PromotedVaultMount("workload-1")+"/"] = map[string]interface{}{"type": "kubernetes"}
+	_, role, err = GrantPromotedSecrets(context.Background(), vaultClient, "dev", "workload-1")
+	require.NoError(t, err)
+	assert.True(t, role)
+	assert.Equal(t, []interface{}{policy}, written["/v1/auth/kubernetes-workload-1/role/dev"]["token_policies"])
+}
+
+func TestPromotion(t *testing.T) {
+	promotion := &Promotion{VCluster: "dev", Target: "workload-1", Applications: []string{"dev-metaphor"}, Started: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	promotion.Complete(PromoteStageSecrets, []string{"apps/db reads vclusters/dev from Vault"}, []string{"recreate apps/tls"})
+
+	parsed, found, err := ParsePromotion(promotion.String())
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, promotion, parsed)
+	assert.True(t, parsed.Done(PromoteStageSecrets))
+	assert.False(t, parsed.Done(PromoteStageData))
+
+	dir := t.TempDir()
+	path, err := WritePromotionReport(dir, parsed)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "promote-dev.md"), path)
+	markdown, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(markdown), "| secrets | completed |\n| data | pending |")
+	assert.Contains(t, string(markdown), "## Manual follow-up\n\n- recreate apps/tls\n")
+}
+
+func TestBackupVCluster(t *testing.T) {
+	velero := func(kind, name, phase string, status map[string]interface{}) *unstructured.Unstructured {
+		status["phase"] = phase
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "velero.io/v1",
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"name": name, "namespace": VeleroNamespace},
+			"status":     status,
+		}}
+	}
+	client := &Client{Dynamic: dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(),
+		// a rerun finds the backup of the previous one
+		velero("Backup", "promote-dev", "Completed", map[string]interface{}{}),
+		velero("Restore", "promote-dev", "FailedValidation", map[string]interface{}{"validationErrors": []interface{}{"backup not found"}}),
+	)}
+
+	require.NoError(t, client.BackupVCluster(context.Background(), "dev", "promote-dev"))
+	err := client.RestoreVolumes(context.Background(), "promote-dev", VClusterNamespace("dev"), "dev")
+	assert.ErrorContains(t, err, `velero restores "promote-dev" FailedValidation: backup not found`)
+}
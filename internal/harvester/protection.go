@@ -0,0 +1,362 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"golang.org/x/crypto/ssh"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// GitopsBranch is the default branch kubefirst creates the gitops
+	// repository with
+	GitopsBranch = "main"
+
+	// ArgoCDRepoSecretName is the ArgoCD repository secret holding the
+	// gitops deploy key, or the https credential with --git-protocol https.
+	// ArgoCD prefers it over the owner wide credential template because it
+	// matches the repository url exactly
+	ArgoCDRepoSecretName = "kubefirst-gitops-deploy-key"
+
+	argoCDSecretTypeLabel = "argocd.argoproj.io/secret-type"
+
+	// gitlabMaintainerLevel is the GitLab access level of maintainers,
+	// which the token protecting the branch has at least
+	gitlabMaintainerLevel = 40
+)
+
+// SSHURL returns the ssh remote of the repository, the form ArgoCD clones
+// it with when authenticating with a deploy key
+func (r *GitopsRepo) SSHURL() string {
+	host := "github.com"
+	switch r.provider {
+	case "gitlab":
+		host = "gitlab.com"
+	}
+
+	return fmt.Sprintf("git@%s:%s/%s.git", host, r.owner, r.name)
+}
+
+// ProtectBranch requires changes to branch to go through a pull or merge
+// request and forbids force pushes. The token kubefirst pushes with may
+// still push directly: on GitHub as a repository admin and on GitLab as a
+// maintainer
+func (r *GitopsRepo) ProtectBranch(ctx context.Context, branch string) error {
+	switch r.provider {
+	case "github":
+		err := r.Retry.Do(ctx, "github branch protection", func(ctx context.Context) error {
+			_, _, err := r.githubClient().Repositories.UpdateBranchProtection(ctx, r.owner, r.name, branch, &github.ProtectionRequest{
+				RequiredPullRequestReviews: &github.PullRequestReviewsEnforcementRequest{
+					RequiredApprovingReviewCount: 1,
+				},
+				AllowForcePushes: github.Bool(false),
+				AllowDeletions:   github.Bool(false),
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to protect branch %q of %s/%s: %w", branch, r.owner, r.name, err)
+		}
+	case "gitlab":
+		// GitLab only allows changing the access levels of a protected
+		// branch by protecting it again
+		protectedBranch := fmt.Sprintf("protected_branches/%s", url.PathEscape(branch))
+		if err := r.gitlabRequest(ctx, http.MethodDelete, protectedBranch, nil, nil); err != nil && !isNotFound(err) {
+			return fmt.Errorf("failed to unprotect branch %q of %s/%s: %w", branch, r.owner, r.name, err)
+		}
+
+		// the branch was unprotected above, protecting it is idempotent
+		err := r.Retry.Do(ctx, "gitlab branch protection", func(ctx context.Context) error {
+			return r.gitlabRequest(ctx, http.MethodPost, "protected_branches", map[string]interface{}{
+				"name":               branch,
+				"push_access_level":  gitlabMaintainerLevel,
+				"merge_access_level": gitlabMaintainerLevel,
+				"allow_force_push":   false,
+			}, nil)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to protect branch %q of %s/%s: %w", branch, r.owner, r.name, err)
+		}
+	default:
+		return fmt.Errorf("unsupported git provider %q", r.provider)
+	}
+
+	return nil
+}
+
+// AddDeployKey registers publicKey as a deploy key of the repository, read
+// only unless write is set, and returns its provider id
+func (r *GitopsRepo) AddDeployKey(ctx context.Context, title, publicKey string, write bool) (int64, error) {
+	switch r.provider {
+	case "github":
+		key, _, err := r.githubClient().Repositories.CreateKey(ctx, r.owner, r.name, &github.Key{
+			Title:    github.String(title),
+			Key:      github.String(publicKey),
+			ReadOnly: github.Bool(!write),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to add deploy key to %s/%s: %w", r.owner, r.name, err)
+		}
+		return key.GetID(), nil
+	case "gitlab":
+		var key struct {
+			ID int64 `json:"id"`
+		}
+		err := r.gitlabRequest(ctx, http.MethodPost, "deploy_keys", map[string]interface{}{
+			"title":    title,
+			"key":      publicKey,
+			"can_push": write,
+		}, &key)
+		if err != nil {
+			return 0, fmt.Errorf("failed to add deploy key to %s/%s: %w", r.owner, r.name, err)
+		}
+		return key.ID, nil
+	default:
+		return 0, fmt.Errorf("unsupported git provider %q", r.provider)
+	}
+}
+
+// DeleteDeployKey removes the deploy key id from the repository. A key that
+// is already gone is not an error
+func (r *GitopsRepo) DeleteDeployKey(ctx context.Context, id int64) error {
+	var err error
+	switch r.provider {
+	case "github":
+		err = r.Retry.Do(ctx, "github deploy key delete", func(ctx context.Context) error {
+			res, err := r.githubClient().Repositories.DeleteKey(ctx, r.owner, r.name, id)
+			if res != nil && res.StatusCode == http.StatusNotFound {
+				return nil
+			}
+			return err
+		})
+	case "gitlab":
+		err = r.gitlabRequest(ctx, http.MethodDelete, fmt.Sprintf("deploy_keys/%d", id), nil, nil)
+		if isNotFound(err) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported git provider %q", r.provider)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete deploy key %d from %s/%s: %w", id, r.owner, r.name, err)
+	}
+
+	return nil
+}
+
+func (r *GitopsRepo) githubClient() *github.Client {
+	client := github.NewClient(&http.Client{Transport: &tokenTransport{
+		header: "Authorization",
+		value:  "Bearer " + r.Auth.Password,
+		next:   r.transport(),
+	}})
+	client.BaseURL, _ = url.Parse(r.apiURL)
+
+	return client
+}
+
+func (r *GitopsRepo) transport() http.RoundTripper {
+	if r.httpClient != nil && r.httpClient.Transport != nil {
+		return r.httpClient.Transport
+	}
+
+	return http.DefaultTransport
+}
+
+// apiError is an error response of the GitLab or Cloudflare API
+type apiError struct {
+	provider   string
+	status     int
+	body       string
+	retryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s api returned %d: %s", e.provider, e.status, e.body)
+}
+
+func isNotFound(err error) bool {
+	var apiErr *apiError
+	return errors.As(err, &apiErr) && apiErr.status == http.StatusNotFound
+}
+
+// gitlabRequest calls the GitLab API endpoint at path below the project,
+// encoding body and decoding the response into out when they are set
+func (r *GitopsRepo) gitlabRequest(ctx context.Context, method, path string, body, out interface{}) error {
+	project := url.PathEscape(r.owner + "/" + r.name)
+	endpoint := fmt.Sprintf("%sprojects/%s/%s", r.apiURL, project, path)
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode gitlab request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to build gitlab request: %w", err)
+	}
+	req.Header.Set("PRIVATE-TOKEN", r.Auth.Password)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return r.do(req, "gitlab", out)
+}
+
+// do sends req to the provider API, decoding the response into out when it
+// is set. Requests other than POST are idempotent and retried on transient
+// failures, callers retry their POST requests themselves
+func (r *GitopsRepo) do(req *http.Request, provider string, out interface{}) error {
+	send := func(ctx context.Context) error {
+		attempt := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf("failed to rewind %s request: %w", provider, err)
+			}
+			attempt.Body = body
+		}
+
+		res, err := (&http.Client{Transport: r.transport()}).Do(attempt)
+		if err != nil {
+			return fmt.Errorf("failed to call %s api: %w", provider, err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode >= http.StatusBadRequest {
+			return newAPIError(provider, res)
+		}
+
+		if out != nil {
+			if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+				return fmt.Errorf("failed to decode %s response: %w", provider, err)
+			}
+		}
+
+		return nil
+	}
+
+	if req.Method == http.MethodPost {
+		return send(req.Context())
+	}
+
+	return r.Retry.Do(req.Context(), fmt.Sprintf("%s %s %s", provider, req.Method, req.URL.Path), send)
+}
+
+func newAPIError(provider string, res *http.Response) *apiError {
+	message, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+
+	return &apiError{
+		provider:   provider,
+		status:     res.StatusCode,
+		body:       strings.TrimSpace(string(message)),
+		retryAfter: parseRetryAfter(res.Header),
+	}
+}
+
+type tokenTransport struct {
+	header string
+	value  string
+	next   http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(t.header, t.value)
+
+	return t.next.RoundTrip(req)
+}
+
+// GenerateDeployKey creates an ed25519 key pair, returning the public half
+// in authorized_keys format and the private half as an OpenSSH PEM block
+func GenerateDeployKey() (string, []byte, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate deploy key: %w", err)
+	}
+
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode deploy key: %w", err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(privateKey, "")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode deploy key: %w", err)
+	}
+
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey))), pem.EncodeToMemory(block), nil
+}
+
+// ApplyArgoCDRepoSecret stores the deploy key privateKey as the ArgoCD
+// repository credential for repoURL
+func (c *Client) ApplyArgoCDRepoSecret(ctx context.Context, repoURL string, privateKey []byte) error {
+	return c.applyArgoCDRepo(ctx, map[string]string{
+		"type":          "git",
+		"url":           repoURL,
+		"sshPrivateKey": string(privateKey),
+	})
+}
+
+// ApplyArgoCDRepoToken stores username and token as the ArgoCD repository
+// credential for the https remote repoURL
+func (c *Client) ApplyArgoCDRepoToken(ctx context.Context, repoURL, username, token string) error {
+	return c.applyArgoCDRepo(ctx, map[string]string{
+		"type":     "git",
+		"url":      repoURL,
+		"username": username,
+		"password": token,
+	})
+}
+
+// DeleteArgoCDRepoSecret removes the ArgoCD repository credential, which
+// may already be gone
+func (c *Client) DeleteArgoCDRepoSecret(ctx context.Context) error {
+	err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Delete(ctx, ArgoCDRepoSecretName, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s/%s: %w", ArgoCDNamespace, ArgoCDRepoSecretName, err)
+	}
+
+	return nil
+}
+
+func (c *Client) applyArgoCDRepo(ctx context.Context, data map[string]string) error {
+	secret := corev1apply.Secret(ArgoCDRepoSecretName, ArgoCDNamespace).
+		WithLabels(map[string]string{argoCDSecretTypeLabel: "repository"}).
+		WithStringData(data)
+
+	_, err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", ArgoCDNamespace, ArgoCDRepoSecretName, err)
+	}
+
+	return nil
+}
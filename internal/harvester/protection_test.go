@@ -0,0 +1,157 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type recordedRequest struct {
+	method string
+	path   string
+	token  string
+	body   map[string]interface{}
+}
+
+func newProviderServer(t *testing.T, handler func(w http.ResponseWriter, r *http.Request)) (*httptest.Server, *[]recordedRequest) {
+	t.Helper()
+
+	var requests []recordedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request := recordedRequest{method: r.Method, path: r.URL.EscapedPath(), token: r.Header.Get("Authorization") + r.Header.Get("PRIVATE-TOKEN")}
+		json.NewDecoder(r.Body).Decode(&request.body)
+		requests = append(requests, request)
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestProtectionGitHub(t *testing.T) {
+	server, requests := newProviderServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost:
+			w.Write([]byte(`{"id": 42}`))
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})
+
+	repo := &GitopsRepo{
+		Auth:     &githttp.BasicAuth{Password: "token"},
+		provider: "github",
+		owner:    "holybits",
+		name:     "gitops",
+		apiURL:   server.URL + "/",
+	}
+	assert.Equal(t, "git@github.com:holybits/gitops.git", repo.SSHURL())
+
+	require.NoError(t, repo.ProtectBranch(context.Background(), GitopsBranch))
+
+	id, err := repo.AddDeployKey(context.Background(), "argocd", "ssh-ed25519 AAAA", false)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), id)
+
+	require.NoError(t, repo.DeleteDeployKey(context.Background(), 42), "an already removed key is not an error")
+
+	require.Len(t, *requests, 3)
+	protect, key := (*requests)[0], (*requests)[1]
+
+	assert.Equal(t, "/repos/holybits/gitops/branches/main/protection", protect.path)
+	assert.Equal(t, "Bearer token", protect.token)
+	assert.Equal(t, false, protect.body["allow_force_pushes"])
+	assert.NotNil(t, protect.body["required_pull_request_reviews"])
+
+	assert.Equal(t, "/repos/holybits/gitops/keys", key.path)
+	assert.Equal(t, true, key.body["read_only"])
+}
+
+func TestProtectionGitLab(t *testing.T) {
+	server, requests := newProviderServer(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/projects/holybits/gitops/deploy_keys":
+			w.Write([]byte(`{"id": 7}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})
+
+	repo := &GitopsRepo{
+		Auth:     &githttp.BasicAuth{Password: "token"},
+		provider: "gitlab",
+		owner:    "holybits",
+		name:     "gitops",
+		apiURL:   server.URL + "/",
+	}
+
+	require.NoError(t, repo.ProtectBranch(context.Background(), GitopsBranch))
+
+	id, err := repo.AddDeployKey(context.Background(), "argocd", "ssh-ed25519 AAAA", true)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), id)
+
+	require.Len(t, *requests, 3)
+	unprotect, protect, key := (*requests)[0], (*requests)[1], (*requests)[2]
+
+	assert.Equal(t, http.MethodDelete, unprotect.method)
+	assert.Equal(t, "/projects/holybits%2Fgitops/protected_branches/main", unprotect.path)
+	assert.Equal(t, "token", protect.token)
+	assert.Equal(t, float64(gitlabMaintainerLevel), protect.body["push_access_level"])
+	assert.Equal(t, false, protect.body["allow_force_push"])
+	assert.Equal(t, true, key.body["can_push"])
+}
+
+// pushAllowed evaluates a GitLab protection rule the way GitLab does for a
+// push with the access level
+func pushAllowed(rule map[string]interface{}, level float64) bool {
+	required, _ := rule["push_access_level"].(float64)
+	return required > 0 && level >= required
+}
+
+func TestProtectBranchKeepsKubefirstPushes(t *testing.T) {
+	server, requests := newProviderServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	repo := &GitopsRepo{
+		Auth:     &githttp.BasicAuth{Password: "token"},
+		provider: "gitlab",
+		owner:    "holybits",
+		name:     "gitops",
+		apiURL:   server.URL + "/",
+	}
+
+	require.NoError(t, repo.ProtectBranch(context.Background(), GitopsBranch))
+	protect := (*requests)[len(*requests)-1]
+	require.Equal(t, http.MethodPost, protect.method)
+
+	assert.True(t, pushAllowed(protect.body, gitlabMaintainerLevel), "kubefirst pushes after protecting the branch")
+	assert.False(t, pushAllowed(protect.body, 30), "developers go through merge requests")
+}
+
+func TestGenerateDeployKey(t *testing.T) {
+	publicKey, privateKey, err := GenerateDeployKey()
+	require.NoError(t, err)
+
+	signer, err := ssh.ParsePrivateKey(privateKey)
+	require.NoError(t, err)
+
+	parsed, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
+	require.NoError(t, err)
+	assert.Equal(t, parsed.Marshal(), signer.PublicKey().Marshal())
+}
@@ -0,0 +1,140 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// FlagEnvPrefix prefixes the environment variables create flags are read
+// from, e.g. KUBEFIRST_HARVESTER_DOMAIN_NAME for --domain-name
+const FlagEnvPrefix = "KUBEFIRST_HARVESTER_"
+
+// flagProvenanceAnnotation records on a flag the provenance of a value
+// set from the environment or the --from-config file
+const flagProvenanceAnnotation = "kubefirst_harvester_provenance"
+
+// FlagProvenance is where the effective value of a create flag came from
+type FlagProvenance struct {
+	Source string
+	// File and Line locate a value of a FlagSourceFile
+	File string
+	Line int
+	// Env names the variable of a value of a FlagSourceEnv
+	Env string
+}
+
+// String describes the provenance the way validation errors name it, e.g.
+// "from config file cluster.yaml:14"
+func (p FlagProvenance) String() string {
+	switch p.Source {
+	case FlagSourceFlag:
+		return "from the command line"
+	case FlagSourceEnv:
+		return "from env " + p.Env
+	case FlagSourceFile:
+		if p.Line > 0 {
+			return fmt.Sprintf("from config file %s:%d", p.File, p.Line)
+		}
+		return "from config file " + p.File
+	default:
+		return "by default"
+	}
+}
+
+// Location is the file and line or the variable the value was read from,
+// empty for the command line and the defaults
+func (p FlagProvenance) Location() string {
+	switch p.Source {
+	case FlagSourceEnv:
+		return p.Env
+	case FlagSourceFile:
+		if p.Line > 0 {
+			return fmt.Sprintf("%s:%d", p.File, p.Line)
+		}
+		return p.File
+	default:
+		return ""
+	}
+}
+
+// FlagEnvVar returns the environment variable flag is read from
+func FlagEnvVar(flag string) string {
+	return FlagEnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// ResolveFlagProvenance returns where the value of flag comes from, by
+// precedence: the command line when changed, then its FlagEnvVar as
+// lookupEnv finds it, then cfg, which may be nil, then the default.
+// Secret placeholders in cfg are not values
+func ResolveFlagProvenance(flag string, changed bool, lookupEnv func(string) (string, bool), cfg *ClusterConfig) FlagProvenance {
+	if changed {
+		return FlagProvenance{Source: FlagSourceFlag}
+	}
+
+	env := FlagEnvVar(flag)
+	if _, ok := lookupEnv(env); ok {
+		return FlagProvenance{Source: FlagSourceEnv, Env: env}
+	}
+
+	if cfg != nil {
+		if value, ok := cfg.Flags[flag]; ok && value != SecretPlaceholder {
+			return FlagProvenance{Source: FlagSourceFile, File: cfg.Path, Line: cfg.Lines[flag]}
+		}
+	}
+
+	return FlagProvenance{Source: FlagSourceDefault}
+}
+
+// SetFlagProvenance records p on flag, for FlagProvenanceOf to return once
+// the value of the environment or the config file is set
+func SetFlagProvenance(flag *pflag.Flag, p FlagProvenance) {
+	if flag.Annotations == nil {
+		flag.Annotations = map[string][]string{}
+	}
+	flag.Annotations[flagProvenanceAnnotation] = []string{p.Source, p.File, strconv.Itoa(p.Line), p.Env}
+}
+
+// FlagProvenanceOf returns the provenance recorded on flag, the command
+// line when it was changed otherwise and the default when it was not
+func FlagProvenanceOf(flag *pflag.Flag) FlagProvenance {
+	if recorded := flag.Annotations[flagProvenanceAnnotation]; len(recorded) == 4 {
+		line, _ := strconv.Atoi(recorded[2])
+		return FlagProvenance{Source: recorded[0], File: recorded[1], Line: line, Env: recorded[3]}
+	}
+
+	if flag.Changed {
+		return FlagProvenance{Source: FlagSourceFlag}
+	}
+
+	return FlagProvenance{Source: FlagSourceDefault}
+}
+
+// FlagProvenances describes the provenance of every flag of flags, keyed by
+// name, as create records it next to the flag values
+func FlagProvenances(flags *pflag.FlagSet) map[string]string {
+	provenances := map[string]string{}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		provenances[flag.Name] = FlagProvenanceOf(flag).String()
+	})
+
+	return provenances
+}
+
+// describeFlag names flag in a validation error along with the provenance
+// of its value, when provenances records it
+func describeFlag(provenances map[string]string, flag string) string {
+	if provenance, ok := provenances[flag]; ok {
+		return fmt.Sprintf("--%s %s", flag, provenance)
+	}
+
+	return "--" + flag
+}
@@ -0,0 +1,76 @@
+package harvester
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveFlagProvenance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("apiVersion: "+ClusterConfigAPIVersion+"\nkind: "+ClusterConfigKind+"\nflags:\n  domain-name: example.com\n  git-provider: gitlab\n  unifi-password: "+SecretPlaceholder+"\n"), 0o600))
+	cfg, err := LoadClusterConfig(path)
+	require.NoError(t, err)
+
+	env := map[string]string{"KUBEFIRST_HARVESTER_GIT_PROVIDER": "github", "KUBEFIRST_HARVESTER_CLUSTER_NAME": "kubefirst"}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	file := FlagProvenance{Source: FlagSourceFile, File: path, Line: 4}
+	tests := []struct {
+		flag     string
+		changed  bool
+		expected FlagProvenance
+	}{
+		{flag: "domain-name", expected: file},
+		{flag: "domain-name", changed: true, expected: FlagProvenance{Source: FlagSourceFlag}},
+		{flag: "git-provider", expected: FlagProvenance{Source: FlagSourceEnv, Env: "KUBEFIRST_HARVESTER_GIT_PROVIDER"}},
+		{flag: "git-provider", changed: true, expected: FlagProvenance{Source: FlagSourceFlag}},
+		{flag: "cluster-name", expected: FlagProvenance{Source: FlagSourceEnv, Env: "KUBEFIRST_HARVESTER_CLUSTER_NAME"}},
+		{flag: "unifi-password", expected: FlagProvenance{Source: FlagSourceDefault}},
+		{flag: "alerts-email", expected: FlagProvenance{Source: FlagSourceDefault}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, ResolveFlagProvenance(tt.flag, tt.changed, lookupEnv, cfg), "%s changed=%t", tt.flag, tt.changed)
+	}
+
+	assert.Equal(t, FlagProvenance{Source: FlagSourceDefault}, ResolveFlagProvenance("domain-name", false, lookupEnv, nil))
+	assert.Equal(t, "from config file "+path+":4", file.String())
+	assert.Equal(t, "from env KUBEFIRST_HARVESTER_GIT_PROVIDER", ResolveFlagProvenance("git-provider", false, lookupEnv, cfg).String())
+	assert.Equal(t, "from the command line", FlagProvenance{Source: FlagSourceFlag}.String())
+	assert.Equal(t, "by default", FlagProvenance{Source: FlagSourceDefault}.String())
+}
+
+func TestFlagProvenanceOf(t *testing.T) {
+	flags := pflag.NewFlagSet("create", pflag.ContinueOnError)
+	flags.String("domain-name", "", "")
+	flags.String("cluster-name", "kubefirst", "")
+	flags.String("git-provider", "github", "")
+	require.NoError(t, flags.Parse([]string{"--git-provider", "gitlab"}))
+
+	require.NoError(t, flags.Set("domain-name", "example.com"))
+	SetFlagProvenance(flags.Lookup("domain-name"), FlagProvenance{Source: FlagSourceFile, File: "cluster.yaml", Line: 14})
+
+	assert.Equal(t, FlagProvenance{Source: FlagSourceFile, File: "cluster.yaml", Line: 14}, FlagProvenanceOf(flags.Lookup("domain-name")))
+	assert.Equal(t, map[string]string{
+		"cluster-name": "by default",
+		"domain-name":  "from config file cluster.yaml:14",
+		"git-provider": "from the command line",
+	}, FlagProvenances(flags))
+
+	identity := IdentityFlags{
+		ClusterName: "kubefirst",
+		DomainName:  "example",
+		AlertsEmail: "ops@example.com",
+		GitProvider: "gitlab",
+		Owners:      map[string]string{"gitlab-group": "holybitsllc"},
+		Provenance:  FlagProvenances(flags),
+	}
+	require.ErrorContains(t, identity.Validate(), "invalid --domain-name from config file cluster.yaml:14: ")
+}
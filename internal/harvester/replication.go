@@ -0,0 +1,324 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	batchv1apply "k8s.io/client-go/applyconfigurations/batch/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// SitePrimary and SiteStandby name the two management clusters of a
+	// replicated platform
+	SitePrimary = "primary"
+	SiteStandby = "standby"
+
+	// DefaultReplicationSchedule is how often the standby restores a Vault
+	// snapshot of the primary
+	DefaultReplicationSchedule = "*/15 * * * *"
+
+	// VaultReplicationName names the CronJob and Secret replicating Vault onto
+	// the standby
+	VaultReplicationName = "vault-replication"
+
+	vaultReplicationImage  = "curlimages/curl:8.11.1"
+	vaultStandbyAddress    = "http://vault.vault.svc:8200"
+	primaryUnsealKeyPrefix = "primary-"
+)
+
+// ErrRootApplicationNotFound is returned when no ArgoCD application deploys
+// the registry path of the cluster
+var ErrRootApplicationNotFound = errors.New("no argocd application deploys the registry path")
+
+// vaultReplicationScript copies a raft snapshot of the primary Vault onto
+// the standby. The first restore is authorized by the standby's own root
+// token, every later one by the primary's, which the restore brought along
+const vaultReplicationScript = `set -eu
+curl -sf -H "X-Vault-Token: ${PRIMARY_TOKEN}" "${PRIMARY_ADDR}/v1/sys/storage/raft/snapshot" -o /tmp/vault.snap
+for token in "${PRIMARY_TOKEN}" "${STANDBY_TOKEN}"; do
+  if curl -sf -X POST -H "X-Vault-Token: ${token}" --data-binary @/tmp/vault.snap "${STANDBY_ADDR}/v1/sys/storage/raft/snapshot-force"; then
+    exit 0
+  fi
+done
+echo "failed to restore the primary snapshot on the standby" >&2
+exit 1
+`
+
+// ReplicatedRecord is a platform DNS record and the LoadBalancer service
+// whose address it points at on the active site
+type ReplicatedRecord struct {
+	Host      string `json:"host"`
+	Namespace string `json:"namespace"`
+	Service   string `json:"service"`
+}
+
+// ReplicationState tracks a warm-standby management cluster
+type ReplicationState struct {
+	StandbyKubeconfig string             `json:"standbyKubeconfig"`
+	ActiveSite        string             `json:"activeSite"`
+	Schedule          string             `json:"schedule"`
+	Records           []ReplicatedRecord `json:"records"`
+	ConfiguredAt      time.Time          `json:"configuredAt"`
+	FailedOverAt      time.Time          `json:"failedOverAt,omitempty"`
+}
+
+// RootApplication returns the ArgoCD application deploying registryPath of
+// the gitops repository, the app-of-apps of the platform
+func (c *Client) RootApplication(ctx context.Context, registryPath string) (*v1alpha1.Application, error) {
+	apps, err := c.ListApplications(ctx, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range apps {
+		for _, source := range apps[i].Spec.GetSources() {
+			if path.Clean(source.Path) == path.Clean(registryPath) {
+				return &apps[i], nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("%w %q", ErrRootApplicationNotFound, registryPath)
+}
+
+// ApplyRootApplication creates or updates the app-of-apps on this cluster
+// with the spec of app, so it deploys the same registry from the same
+// repository and branch
+func (c *Client) ApplyRootApplication(ctx context.Context, app *v1alpha1.Application) error {
+	apps := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+
+	existing, err := apps.Get(ctx, app.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		root := &v1alpha1.Application{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        app.Name,
+				Namespace:   ArgoCDNamespace,
+				Labels:      app.Labels,
+				Annotations: app.Annotations,
+				Finalizers:  app.Finalizers,
+			},
+			Spec: *app.Spec.DeepCopy(),
+		}
+		if _, err := apps.Create(ctx, root, metav1.CreateOptions{FieldManager: fieldManager}); err != nil {
+			return fmt.Errorf("failed to create application %q: %w", app.Name, err)
+		}
+	case err != nil:
+		if isConnectionError(err) {
+			return fmt.Errorf("%w: %w", ErrArgoCDUnreachable, err)
+		}
+		return fmt.Errorf("failed to get application %q: %w", app.Name, err)
+	default:
+		existing.Spec = *app.Spec.DeepCopy()
+		if _, err := apps.Update(ctx, existing, metav1.UpdateOptions{FieldManager: fieldManager}); err != nil {
+			return fmt.Errorf("failed to update application %q: %w", app.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// CopyArgoCDRepositories applies the ArgoCD repository credentials and
+// credential templates of from to this cluster, so its ArgoCD can clone the
+// same gitops repository
+func (c *Client) CopyArgoCDRepositories(ctx context.Context, from *Client) error {
+	secrets, err := from.Clientset.CoreV1().Secrets(ArgoCDNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: argoCDSecretTypeLabel + " in (repository,repo-creds)",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list argocd repository secrets: %w", err)
+	}
+
+	for _, secret := range secrets.Items {
+		copied := corev1apply.Secret(secret.Name, ArgoCDNamespace).
+			WithLabels(secret.Labels).
+			WithData(secret.Data)
+		if _, err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Apply(ctx, copied, metav1.ApplyOptions{
+			FieldManager: fieldManager,
+			Force:        true,
+		}); err != nil {
+			return fmt.Errorf("failed to apply secret %s/%s: %w", ArgoCDNamespace, secret.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// VaultUnsealSecret returns the unseal keys and root token kubefirst stored
+// when Vault was initialized
+func (c *Client) VaultUnsealSecret(ctx context.Context) (map[string][]byte, error) {
+	secret, err := c.Clientset.CoreV1().Secrets(vaultNamespace).Get(ctx, vaultSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", vaultNamespace, vaultSecretName, err)
+	}
+
+	if len(secret.Data["root-token"]) == 0 {
+		return nil, fmt.Errorf("secret %s/%s has no root-token", vaultNamespace, vaultSecretName)
+	}
+
+	return secret.Data, nil
+}
+
+// ApplyVaultReplication runs a CronJob on this standby cluster restoring a
+// raft snapshot of the Vault at primaryAddr on schedule. primaryUnseal, the
+// unseal secret of the primary, is kept next to it since the restored data
+// can only be unsealed with the primary's keys
+func (c *Client) ApplyVaultReplication(ctx context.Context, primaryAddr, schedule string, primaryUnseal map[string][]byte) error {
+	data := make(map[string][]byte, len(primaryUnseal))
+	for key, value := range primaryUnseal {
+		data[primaryUnsealKeyPrefix+key] = value
+	}
+
+	secret := corev1apply.Secret(VaultReplicationName, vaultNamespace).WithData(data)
+	if _, err := c.Clientset.CoreV1().Secrets(vaultNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	}); err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", vaultNamespace, VaultReplicationName, err)
+	}
+
+	secretEnv := func(name, secretName, key string) *corev1apply.EnvVarApplyConfiguration {
+		return corev1apply.EnvVar().WithName(name).WithValueFrom(corev1apply.EnvVarSource().
+			WithSecretKeyRef(corev1apply.SecretKeySelector().WithName(secretName).WithKey(key)))
+	}
+
+	container := corev1apply.Container().
+		WithName("replicate").
+		WithImage(MirrorImage(vaultReplicationImage, c.RegistryMirror)).
+		WithCommand("/bin/sh", "-c", vaultReplicationScript).
+		WithEnv(
+			corev1apply.EnvVar().WithName("PRIMARY_ADDR").WithValue(primaryAddr),
+			corev1apply.EnvVar().WithName("STANDBY_ADDR").WithValue(vaultStandbyAddress),
+			secretEnv("PRIMARY_TOKEN", VaultReplicationName, primaryUnsealKeyPrefix+"root-token"),
+			secretEnv("STANDBY_TOKEN", vaultSecretName, "root-token"),
+		)
+
+	cronJob := batchv1apply.CronJob(VaultReplicationName, vaultNamespace).
+		WithSpec(batchv1apply.CronJobSpec().
+			WithSchedule(schedule).
+			WithSuspend(false).
+			WithConcurrencyPolicy(batchv1.ForbidConcurrent).
+			WithJobTemplate(batchv1apply.JobTemplateSpec().
+				WithSpec(batchv1apply.JobSpec().
+					WithBackoffLimit(2).
+					WithTemplate(corev1apply.PodTemplateSpec().
+						WithSpec(corev1apply.PodSpec().
+							WithRestartPolicy(corev1.RestartPolicyNever).
+							WithContainers(container))))))
+
+	if _, err := c.Clientset.BatchV1().CronJobs(vaultNamespace).Apply(ctx, cronJob, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	}); err != nil {
+		return fmt.Errorf("failed to apply cronjob %s/%s: %w", vaultNamespace, VaultReplicationName, err)
+	}
+
+	return nil
+}
+
+// LastVaultReplication returns when the replication CronJob last completed,
+// or the zero time if it never did
+func (c *Client) LastVaultReplication(ctx context.Context) (time.Time, error) {
+	cronJob, err := c.Clientset.BatchV1().CronJobs(vaultNamespace).Get(ctx, VaultReplicationName, metav1.GetOptions{})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read cronjob %s/%s: %w", vaultNamespace, VaultReplicationName, err)
+	}
+
+	if cronJob.Status.LastSuccessfulTime == nil {
+		return time.Time{}, nil
+	}
+
+	return cronJob.Status.LastSuccessfulTime.Time, nil
+}
+
+// PromoteVault stops replication onto this standby and replaces its Vault
+// unseal secret with the primary's, whose keys seal the replicated data
+func (c *Client) PromoteVault(ctx context.Context) error {
+	suspend := []byte(`{"spec":{"suspend":true}}`)
+	if _, err := c.Clientset.BatchV1().CronJobs(vaultNamespace).Patch(ctx, VaultReplicationName, types.MergePatchType, suspend, metav1.PatchOptions{
+		FieldManager: fieldManager,
+	}); err != nil {
+		return fmt.Errorf("failed to suspend cronjob %s/%s: %w", vaultNamespace, VaultReplicationName, err)
+	}
+
+	replication, err := c.Clientset.CoreV1().Secrets(vaultNamespace).Get(ctx, VaultReplicationName, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read secret %s/%s: %w", vaultNamespace, VaultReplicationName, err)
+	}
+
+	data := map[string][]byte{}
+	for key, value := range replication.Data {
+		if name, ok := strings.CutPrefix(key, primaryUnsealKeyPrefix); ok {
+			data[name] = value
+		}
+	}
+	if len(data["root-token"]) == 0 {
+		return fmt.Errorf("secret %s/%s holds no primary root-token", vaultNamespace, VaultReplicationName)
+	}
+
+	secret := corev1apply.Secret(vaultSecretName, vaultNamespace).WithData(data)
+	if _, err := c.Clientset.CoreV1().Secrets(vaultNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	}); err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", vaultNamespace, vaultSecretName, err)
+	}
+
+	return nil
+}
+
+// LoadBalancerServiceFor returns the namespace and name of the LoadBalancer
+// service that was allocated address
+func (c *Client) LoadBalancerServiceFor(ctx context.Context, address string) (string, string, error) {
+	services, err := c.Clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to list services: %w", err)
+	}
+
+	for _, service := range services.Items {
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			continue
+		}
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if ingress.IP == address {
+				return service.Namespace, service.Name, nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("no LoadBalancer service was allocated %s", address)
+}
+
+// LoadBalancerAddress returns the address allocated to the LoadBalancer
+// service namespace/name
+func (c *Client) LoadBalancerAddress(ctx context.Context, namespace, name string) (string, error) {
+	service, err := c.Clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to read service %s/%s: %w", namespace, name, err)
+	}
+
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			return ingress.IP, nil
+		}
+	}
+
+	return "", fmt.Errorf("service %s/%s has no LoadBalancer address", namespace, name)
+}
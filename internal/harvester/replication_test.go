@@ -0,0 +1,82 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRootApplication(t *testing.T) {
+	registry := newApplication("registry", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy)
+	registry.Spec.Source = &v1alpha1.ApplicationSource{RepoURL: "git@github.com:acme/harvester-argo.git", Path: "registry/kubefirst", TargetRevision: "main"}
+
+	primary := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+		newApplication("vault", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy),
+		registry,
+	)}
+
+	root, err := primary.RootApplication(context.Background(), "registry/kubefirst/")
+	require.NoError(t, err)
+	assert.Equal(t, "registry", root.Name)
+
+	_, err = primary.RootApplication(context.Background(), "registry/other")
+	require.ErrorIs(t, err, ErrRootApplicationNotFound)
+
+	standby := &Client{ArgoCD: argocdfake.NewSimpleClientset()}
+	require.NoError(t, standby.ApplyRootApplication(context.Background(), root))
+	require.NoError(t, standby.ApplyRootApplication(context.Background(), root))
+
+	copied, err := standby.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Get(context.Background(), "registry", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, root.Spec, copied.Spec)
+}
+
+func TestVaultReplication(t *testing.T) {
+	ctx := context.Background()
+	standby := &Client{Clientset: fake.NewClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: vaultSecretName, Namespace: vaultNamespace},
+		Data:       map[string][]byte{"root-token": []byte("standby-token")},
+	})}
+
+	primaryUnseal := map[string][]byte{"root-token": []byte("primary-token"), "unseal-key-0": []byte("key")}
+	require.NoError(t, standby.ApplyVaultReplication(ctx, "https://vault.example.com", DefaultReplicationSchedule, primaryUnseal))
+
+	last, err := standby.LastVaultReplication(ctx)
+	require.NoError(t, err)
+	assert.True(t, last.IsZero())
+
+	cronJobs := standby.Clientset.BatchV1().CronJobs(vaultNamespace)
+	cronJob, err := cronJobs.Get(ctx, VaultReplicationName, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultReplicationSchedule, cronJob.Spec.Schedule)
+
+	completed := metav1.NewTime(time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC))
+	cronJob.Status.LastSuccessfulTime = &completed
+	_, err = cronJobs.UpdateStatus(ctx, cronJob, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	last, err = standby.LastVaultReplication(ctx)
+	require.NoError(t, err)
+	assert.True(t, completed.Time.Equal(last))
+
+	require.NoError(t, standby.PromoteVault(ctx))
+
+	cronJob, err = cronJobs.Get(ctx, VaultReplicationName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NotNil(t, cronJob.Spec.Suspend)
+	assert.True(t, *cronJob.Spec.Suspend)
+	assert.Equal(t, DefaultReplicationSchedule, cronJob.Spec.Schedule)
+
+	promoted, err := standby.VaultUnsealSecret(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, primaryUnseal, promoted)
+}
@@ -0,0 +1,274 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	reportMarkdownFile = "report.md"
+	reportJSONFile     = "report.json"
+
+	argoCDServerDeployment = "argocd-server"
+)
+
+// ReportOptions describes the cluster an InstallationReport is built for
+type ReportOptions struct {
+	ClusterName             string
+	DomainName              string
+	GitopsRepoURL           string
+	KubeconfigPath          string
+	VClusters               []string
+	VClusterDomainMap       map[string]string
+	ExtraDomains            []string
+	VClusterIngressWildcard bool
+	Vault                   bool
+	VaultAutoUnseal         string
+	IstioVersion            string
+}
+
+// ReportEndpoint is a URL the platform serves
+type ReportEndpoint struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// ReportDNSRecord is a platform host and the addresses it resolved to when
+// the report was built
+type ReportDNSRecord struct {
+	Host      string   `json:"host"`
+	Addresses []string `json:"addresses,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// ReportCredential tells how to fetch a platform credential
+type ReportCredential struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+// InstallationReport summarizes a provisioned Harvester cluster
+type InstallationReport struct {
+	ClusterName   string             `json:"clusterName"`
+	GeneratedAt   time.Time          `json:"generatedAt"`
+	GitopsRepoURL string             `json:"gitopsRepoURL"`
+	Endpoints     []ReportEndpoint   `json:"endpoints"`
+	Versions      map[string]string  `json:"versions"`
+	DNSRecords    []ReportDNSRecord  `json:"dnsRecords"`
+	Credentials   []ReportCredential `json:"credentials"`
+}
+
+// DefaultReportDir returns the directory the report of clusterName is
+// written to when --report-path is not set, in the directory of the
+// active profile unless that is the default one
+func DefaultReportDir(clusterName string) (string, error) {
+	if profile := ActiveProfile(); profile != DefaultProfile {
+		dir, err := ProfileDir(profile)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dir, clusterName), nil
+	}
+
+	homePath, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	return filepath.Join(homePath, ".k1", "harvester", clusterName), nil
+}
+
+// BuildInstallationReport assembles the report for opts, reading component
+// versions from the live cluster and resolving the platform hosts with
+// resolver
+func (c *Client) BuildInstallationReport(ctx context.Context, resolver HostResolver, opts ReportOptions) (*InstallationReport, error) {
+	report := &InstallationReport{
+		ClusterName:   opts.ClusterName,
+		GeneratedAt:   time.Now().UTC(),
+		GitopsRepoURL: strings.TrimSuffix(opts.GitopsRepoURL, ".git"),
+		Versions:      map[string]string{},
+	}
+
+	report.Endpoints = append(report.Endpoints, ReportEndpoint{Name: "ArgoCD", URL: fmt.Sprintf("https://argocd.%s", opts.DomainName)})
+	if opts.Vault {
+		report.Endpoints = append(report.Endpoints, ReportEndpoint{Name: "Vault", URL: fmt.Sprintf("https://vault.%s", opts.DomainName)})
+	}
+
+	for _, vcluster := range opts.VClusters {
+		report.Endpoints = append(report.Endpoints, ReportEndpoint{
+			Name: fmt.Sprintf("vCluster %s", vcluster),
+			URL:  fmt.Sprintf("https://%s", WildcardHostname(vcluster, opts.DomainName, opts.VClusterDomainMap)),
+		})
+	}
+
+	for _, host := range PlatformHosts(opts.DomainName, opts.Vault, opts.VClusters, opts.VClusterDomainMap, opts.ExtraDomains, opts.VClusterIngressWildcard) {
+		record := ReportDNSRecord{Host: host}
+		addresses, err := resolver.LookupHost(ctx, host)
+		if err != nil {
+			record.Error = err.Error()
+		}
+		sort.Strings(addresses)
+		record.Addresses = addresses
+		report.DNSRecords = append(report.DNSRecords, record)
+	}
+
+	if err := c.reportVersions(ctx, report.Versions); err != nil {
+		return nil, err
+	}
+	if _, ok := report.Versions["istio"]; !ok && opts.IstioVersion != "" {
+		report.Versions["istio"] = opts.IstioVersion
+	}
+
+	report.Credentials = reportCredentials(opts.KubeconfigPath, opts.Vault, opts.VaultAutoUnseal)
+
+	return report, nil
+}
+
+// reportCredentials tells where the platform credentials are stored, never
+// their values
+func reportCredentials(kubeconfigPath string, vault bool, vaultAutoUnseal string) []ReportCredential {
+	kubectl := "kubectl"
+	if kubeconfigPath != "" {
+		kubectl = fmt.Sprintf("kubectl --kubeconfig %s", kubeconfigPath)
+	}
+	credentials := []ReportCredential{{
+		Name:    "ArgoCD admin password",
+		Command: fmt.Sprintf("%s -n %s get secret %s -o jsonpath='{.data.password}' | base64 -d", kubectl, ArgoCDNamespace, argoCDAdminSecret),
+	}}
+	if vault {
+		credentials = append(credentials, ReportCredential{
+			Name:    "Vault root token",
+			Command: fmt.Sprintf("%s -n %s get secret %s -o jsonpath='{.data.root-token}' | base64 -d", kubectl, vaultNamespace, vaultSecretName),
+		})
+	}
+	if vault && vaultAutoUnseal == VaultUnsealStatic {
+		credentials = append(credentials, ReportCredential{
+			Name:    "Vault unseal keys (static auto-unseal)",
+			Command: fmt.Sprintf(`%s -n %s get secret %s -o go-template='{{range $k, $v := .data}}{{$k}}: {{$v | base64decode}}{{"\n"}}{{end}}' | grep root-unseal-key-`, kubectl, vaultNamespace, vaultSecretName),
+		})
+	}
+
+	return credentials
+}
+
+// reportVersions records the ArgoCD version from its server image and the
+// chart version of every helm chart deployed by an ArgoCD application
+func (c *Client) reportVersions(ctx context.Context, versions map[string]string) error {
+	server, err := c.Clientset.AppsV1().Deployments(ArgoCDNamespace).Get(ctx, argoCDServerDeployment, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("failed to read %s/%s: %w", ArgoCDNamespace, argoCDServerDeployment, err)
+	default:
+		for _, container := range server.Spec.Template.Spec.Containers {
+			if tag := imageTag(container.Image); tag != "" {
+				versions["argocd"] = tag
+				break
+			}
+		}
+	}
+
+	apps, err := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list argocd applications: %w", err)
+	}
+
+	for _, app := range apps.Items {
+		for _, source := range app.Spec.GetSources() {
+			if source.Chart != "" && source.TargetRevision != "" {
+				versions[source.Chart] = source.TargetRevision
+			}
+		}
+	}
+
+	return nil
+}
+
+// Markdown renders the report as a Markdown document
+func (r *InstallationReport) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# kubefirst installation report: %s\n\n", r.ClusterName)
+	fmt.Fprintf(&b, "Generated %s\n\n", r.GeneratedAt.Format(time.RFC3339))
+
+	b.WriteString("## Endpoints\n\n| Name | URL |\n| --- | --- |\n")
+	for _, endpoint := range r.Endpoints {
+		fmt.Fprintf(&b, "| %s | %s |\n", endpoint.Name, endpoint.URL)
+	}
+	fmt.Fprintf(&b, "| GitOps repository | %s |\n\n", r.GitopsRepoURL)
+
+	b.WriteString("## Component versions\n\n| Component | Version |\n| --- | --- |\n")
+	for _, name := range sortedKeys(r.Versions) {
+		fmt.Fprintf(&b, "| %s | %s |\n", name, r.Versions[name])
+	}
+
+	b.WriteString("\n## DNS records\n\n| Host | Addresses |\n| --- | --- |\n")
+	for _, record := range r.DNSRecords {
+		addresses := strings.Join(record.Addresses, ", ")
+		if record.Error != "" {
+			addresses = fmt.Sprintf("unresolved: %s", record.Error)
+		}
+		fmt.Fprintf(&b, "| %s | %s |\n", record.Host, addresses)
+	}
+
+	b.WriteString("\n## Credentials\n\n")
+	for _, credential := range r.Credentials {
+		fmt.Fprintf(&b, "%s:\n\n```sh\n%s\n```\n\n", credential.Name, credential.Command)
+	}
+
+	return b.String()
+}
+
+// Summary renders the endpoints of the report as a short table
+func (r *InstallationReport) Summary() string {
+	var b strings.Builder
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	for _, endpoint := range r.Endpoints {
+		fmt.Fprintf(w, "%s\t%s\n", endpoint.Name, endpoint.URL)
+	}
+	fmt.Fprintf(w, "GitOps repository\t%s\n", r.GitopsRepoURL)
+	w.Flush()
+
+	return b.String()
+}
+
+// WriteInstallationReport writes the report as report.md and report.json
+// into dir and returns the path of the Markdown file
+func WriteInstallationReport(dir string, report *InstallationReport) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create report directory %q: %w", dir, err)
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render report: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, reportJSONFile), append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+
+	markdownPath := filepath.Join(dir, reportMarkdownFile)
+	if err := os.WriteFile(markdownPath, []byte(report.Markdown()), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write report: %w", err)
+	}
+
+	return markdownPath, nil
+}
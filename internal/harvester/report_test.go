@@ -0,0 +1,81 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type staticResolver map[string][]string
+
+func (r staticResolver) LookupHost(_ context.Context, host string) ([]string, error) {
+	if addresses, ok := r[host]; ok {
+		return addresses, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestInstallationReport(t *testing.T) {
+	vault := newApplication("vault", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy)
+	vault.Spec.Source = &v1alpha1.ApplicationSource{Chart: "vault", TargetRevision: "0.28.1"}
+
+	client := &Client{
+		ArgoCD: argocdfake.NewSimpleClientset(vault),
+		Clientset: fake.NewSimpleClientset(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: argoCDServerDeployment, Namespace: ArgoCDNamespace},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "server", Image: "quay.io/argoproj/argocd:v2.13.1"}},
+			}}},
+		}),
+	}
+
+	report, err := client.BuildInstallationReport(context.Background(), staticResolver{"argocd.example.com": {"10.0.12.5"}}, ReportOptions{
+		ClusterName:   "kubefirst",
+		DomainName:    "example.com",
+		GitopsRepoURL: "https://github.com/acme/harvester-argo.git",
+		VClusters:     []string{"dev"},
+		Vault:         true,
+		IstioVersion:  "1.24.2",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "https://github.com/acme/harvester-argo", report.GitopsRepoURL)
+	assert.Equal(t, []ReportEndpoint{
+		{Name: "ArgoCD", URL: "https://argocd.example.com"},
+		{Name: "Vault", URL: "https://vault.example.com"},
+		{Name: "vCluster dev", URL: "https://*.dev.example.com"},
+	}, report.Endpoints)
+	assert.Equal(t, map[string]string{"argocd": "v2.13.1", "vault": "0.28.1", "istio": "1.24.2"}, report.Versions)
+	require.Len(t, report.DNSRecords, 2)
+	assert.Equal(t, []string{"10.0.12.5"}, report.DNSRecords[0].Addresses)
+	assert.Contains(t, report.DNSRecords[1].Error, "no such host")
+
+	dir := filepath.Join(t.TempDir(), "report")
+	markdownPath, err := WriteInstallationReport(dir, report)
+	require.NoError(t, err)
+
+	markdown, err := os.ReadFile(markdownPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(markdown), "| vault | 0.28.1 |")
+	assert.Contains(t, string(markdown), "vault-unseal-secret")
+
+	data, err := os.ReadFile(filepath.Join(dir, reportJSONFile))
+	require.NoError(t, err)
+
+	var decoded InstallationReport
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, report.Endpoints, decoded.Endpoints)
+}
@@ -0,0 +1,239 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/google/go-github/v52/github"
+	"github.com/konstructio/kubefirst/internal/step"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// DefaultAPIRetryMax is the number of times a transient API failure is
+	// retried before the run is aborted
+	DefaultAPIRetryMax = 5
+	// DefaultPhaseRetryCount is the number of times a provisioning phase
+	// failing transiently is retried before the run is aborted
+	DefaultPhaseRetryCount = 3
+	// DefaultPhaseRetryBackoff is the delay before the first retry of a
+	// provisioning phase, doubling with every other
+	DefaultPhaseRetryBackoff = 30 * time.Second
+
+	defaultRetryBaseDelay = time.Second
+	defaultRetryMaxDelay  = 30 * time.Second
+	// maxRetryAfter bounds how long a Retry-After header may stall a run
+	maxRetryAfter = 2 * time.Minute
+)
+
+// APIRetry retries git provider and Cloudflare API calls failing
+// with a 429, a 5xx or a network error, backing off exponentially with
+// jitter unless the response asks for a delay with Retry-After. The zero
+// value calls every operation once
+type APIRetry struct {
+	// Max is the number of retries after the first attempt
+	Max int
+	// OnRetry is told about every retry before it is attempted
+	OnRetry func(message string)
+
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+// NewAPIRetry retries transient API failures up to max times, noting every
+// retry under the current step of stepper
+func NewAPIRetry(max int, stepper step.Stepper) APIRetry {
+	return APIRetry{
+		Max: max,
+		OnRetry: func(message string) {
+			stepper.InfoStep(step.EmojiAlarm, message)
+		},
+	}
+}
+
+// NewPhaseRetry retries a provisioning phase failing transiently up to
+// count times, backing off exponentially with jitter from backoff, warning
+// of every retry under the current step of stepper
+func NewPhaseRetry(count int, backoff time.Duration, stepper step.Stepper) APIRetry {
+	return APIRetry{
+		Max: count,
+		OnRetry: func(message string) {
+			stepper.InfoStep(step.EmojiWarning, message)
+		},
+		baseDelay: backoff,
+		maxDelay:  backoff << min(count, 16),
+	}
+}
+
+// Do runs the idempotent operation fn, named by operation in retry
+// messages, until it succeeds, fails permanently or runs out of retries
+func (a APIRetry) Do(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	return a.do(ctx, operation, fn, nil)
+}
+
+// DoUnlessExists runs the operation fn creating a resource, which is not
+// idempotent. Before every retry exists checks whether the failed attempt
+// created the resource anyway, in which case it is not created twice
+func (a APIRetry) DoUnlessExists(ctx context.Context, operation string, fn func(ctx context.Context) error, exists func(ctx context.Context) (bool, error)) error {
+	return a.do(ctx, operation, fn, exists)
+}
+
+func (a APIRetry) do(ctx context.Context, operation string, fn func(ctx context.Context) error, exists func(ctx context.Context) (bool, error)) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		retryAfter, transient := transientError(err)
+		if !transient || attempt > a.Max || ctx.Err() != nil {
+			return err
+		}
+
+		delay := a.backoff(attempt)
+		if retryAfter > 0 {
+			delay = min(retryAfter, maxRetryAfter)
+		}
+		if a.OnRetry != nil {
+			a.OnRetry(fmt.Sprintf("retrying %s, attempt %d/%d in %s: %v", operation, attempt, a.Max, delay.Round(time.Second), err))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		if exists != nil {
+			created, checkErr := exists(ctx)
+			if checkErr != nil {
+				return errors.Join(err, fmt.Errorf("failed to check whether %s succeeded: %w", operation, checkErr))
+			}
+			if created {
+				return nil
+			}
+		}
+	}
+}
+
+// backoff returns the delay before retry attempt, doubling from the base
+// delay up to the max delay with jitter of up to half of it
+func (a APIRetry) backoff(attempt int) time.Duration {
+	base, ceiling := a.baseDelay, a.maxDelay
+	if base == 0 {
+		base = defaultRetryBaseDelay
+	}
+	if ceiling == 0 {
+		ceiling = defaultRetryMaxDelay
+	}
+
+	delay := ceiling
+	if attempt < 32 && base<<(attempt-1) < ceiling {
+		delay = base << (attempt - 1)
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// transientError reports whether err is worth retrying, along with the
+// delay the API asked for, if any
+func transientError(err error) (time.Duration, bool) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr.retryAfter, transientStatus(apiErr.status)
+	}
+
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return time.Until(rateLimitErr.Rate.Reset.Time), true
+	}
+
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		return abuseErr.GetRetryAfter(), true
+	}
+
+	var githubErr *github.ErrorResponse
+	if errors.As(err, &githubErr) && githubErr.Response != nil {
+		return parseRetryAfter(githubErr.Response.Header), transientStatus(githubErr.Response.StatusCode)
+	}
+
+	var gitErr *githttp.Err
+	if errors.As(err, &gitErr) && gitErr.Response != nil {
+		return parseRetryAfter(gitErr.Response.Header), transientStatus(gitErr.StatusCode())
+	}
+
+	// the Kubernetes API server throttling or briefly unavailable
+	if apierrors.IsTooManyRequests(err) || apierrors.IsServiceUnavailable(err) || apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) {
+		seconds, _ := apierrors.SuggestsClientDelay(err)
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	// errors of other APIs telling they are worth retrying, such as
+	// kubefirst-api being unreachable
+	var temporary interface{ Temporary() bool }
+	if errors.As(err, &temporary) && temporary.Temporary() {
+		return 0, true
+	}
+
+	// dropped and timed out connections are transient, certificate or
+	// read-only mode errors are not
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return 0, true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+		return 0, true
+	}
+	for _, connErr := range []error{syscall.ECONNRESET, syscall.ECONNREFUSED, io.EOF, io.ErrUnexpectedEOF} {
+		if errors.Is(err, connErr) {
+			return 0, true
+		}
+	}
+
+	return 0, false
+}
+
+func transientStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// parseRetryAfter reads a Retry-After header holding either seconds or an
+// HTTP date
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+
+	return 0
+}
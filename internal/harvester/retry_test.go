@@ -0,0 +1,152 @@
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestAPIRetry(t *testing.T) {
+	var messages []string
+	retry := APIRetry{
+		Max:       3,
+		OnRetry:   func(message string) { messages = append(messages, message) },
+		baseDelay: time.Millisecond,
+		maxDelay:  time.Millisecond,
+	}
+
+	t.Run("retries transient failures", func(t *testing.T) {
+		messages = nil
+		calls := 0
+		err := retry.Do(context.Background(), "Cloudflare record update", func(context.Context) error {
+			calls++
+			if calls < 3 {
+				return &apiError{provider: "cloudflare", status: http.StatusBadGateway}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, calls)
+		require.Len(t, messages, 2)
+		assert.Contains(t, messages[1], "retrying Cloudflare record update, attempt 2/3")
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		calls := 0
+		err := retry.Do(context.Background(), "gitops repository push", func(context.Context) error {
+			calls++
+			return &apiError{provider: "gitlab", status: http.StatusTooManyRequests}
+		})
+		require.Error(t, err)
+		assert.Equal(t, 4, calls)
+	})
+
+	t.Run("does not retry permanent failures", func(t *testing.T) {
+		calls := 0
+		err := retry.Do(context.Background(), "gitlab GET", func(context.Context) error {
+			calls++
+			return &apiError{provider: "gitlab", status: http.StatusForbidden}
+		})
+		require.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("checks for the resource before creating it again", func(t *testing.T) {
+		calls := 0
+		err := retry.DoUnlessExists(context.Background(), "cloudflare record create", func(context.Context) error {
+			calls++
+			return errors.Join(errors.New("failed to call cloudflare api"), &apiError{provider: "cloudflare", status: http.StatusBadGateway})
+		}, func(context.Context) (bool, error) {
+			return true, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("zero value calls once", func(t *testing.T) {
+		calls := 0
+		err := APIRetry{}.Do(context.Background(), "gitlab GET", func(context.Context) error {
+			calls++
+			return &apiError{provider: "gitlab", status: http.StatusServiceUnavailable}
+		})
+		require.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+}
+
+func TestTransientError(t *testing.T) {
+	retryAfter, transient := transientError(&apiError{status: http.StatusTooManyRequests, retryAfter: 7 * time.Second})
+	assert.True(t, transient)
+	assert.Equal(t, 7*time.Second, retryAfter)
+
+	res := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"3"}}}
+	retryAfter, transient = transientError(&githttp.Err{Response: res})
+	assert.True(t, transient)
+	assert.Equal(t, 3*time.Second, retryAfter)
+
+	retryAfter, transient = transientError(fmt.Errorf("failed to watch applications: %w", apierrors.NewTooManyRequests("throttled", 4)))
+	assert.True(t, transient)
+	assert.Equal(t, 4*time.Second, retryAfter)
+	_, transient = transientError(apierrors.NewServiceUnavailable("apiserver restarting"))
+	assert.True(t, transient)
+	_, transient = transientError(fmt.Errorf("failed to create cluster: %w", temporaryError{}))
+	assert.True(t, transient)
+
+	_, transient = transientError(context.Canceled)
+	assert.False(t, transient)
+	_, transient = transientError(apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, "cloudflare-credentials"))
+	assert.False(t, transient)
+	_, transient = transientError(errors.New("repository not found"))
+	assert.False(t, transient)
+}
+
+// temporaryError tells it is worth retrying, like the kubefirst-api errors
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "test error" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestPhaseRetry(t *testing.T) {
+	var out bytes.Buffer
+	retry := NewPhaseRetry(2, time.Millisecond, step.NewStepFactory(&out))
+	assert.Equal(t, 4*time.Millisecond, retry.maxDelay)
+
+	t.Run("warns of every retry of a transient failure", func(t *testing.T) {
+		calls := 0
+		err := retry.Do(context.Background(), `phase "Create Management Cluster"`, func(context.Context) error {
+			calls++
+			if calls < 3 {
+				return fmt.Errorf("failed to provision management cluster: %w", apierrors.NewServiceUnavailable("apiserver restarting"))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, calls)
+		assert.Contains(t, out.String(), `retrying phase "Create Management Cluster", attempt 1/2`)
+		assert.Contains(t, out.String(), "attempt 2/2")
+		assert.Contains(t, out.String(), "apiserver restarting")
+	})
+
+	t.Run("fails configuration errors at once", func(t *testing.T) {
+		out.Reset()
+		calls := 0
+		err := retry.Do(context.Background(), `phase "Validate Git Credentials"`, func(context.Context) error {
+			calls++
+			return fmt.Errorf("invalid cidr %q", "10.0.0.0/33")
+		})
+		require.EqualError(t, err, `invalid cidr "10.0.0.0/33"`)
+		assert.Equal(t, 1, calls)
+		assert.Empty(t, out.String())
+	})
+}
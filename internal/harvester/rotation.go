@@ -0,0 +1,696 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"golang.org/x/crypto/bcrypt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// NewCredentialEnvPrefix prefixes the environment variable a replacement
+	// credential is read from, NEW_GITHUB_TOKEN replaces GITHUB_TOKEN
+	NewCredentialEnvPrefix = "NEW_"
+
+	// DefaultCredentialVerifyTimeout bounds how long rotate-credentials
+	// waits for the restarted workloads and the checks of a credential
+	DefaultCredentialVerifyTimeout = 5 * time.Minute
+
+	restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+	externalSecretKind    = "ExternalSecret"
+	argoCDLoginTimeout    = 30 * time.Second
+)
+
+// CredentialTargets are the credentials rotate-credentials rotates, in the
+// order it rotates them
+var CredentialTargets = []string{"git", "cloudflare", "unifi", "argocd-admin", "kbot-ssh"}
+
+// ErrTokenNotRotatable is returned when the git provider has no API
+// rotating the token it is called with
+var ErrTokenNotRotatable = errors.New("the git provider cannot rotate its tokens through the API")
+
+// ResolveCredentialTargets returns the targets named in args, or all of
+// them with all, in the order of CredentialTargets
+func ResolveCredentialTargets(args []string, all bool) ([]string, error) {
+	if all && len(args) > 0 {
+		return nil, errors.New("--all rotates every credential, name no target along with it")
+	}
+	if all {
+		return CredentialTargets, nil
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("name the credentials to rotate, one of %s, or --all", strings.Join(CredentialTargets, ", "))
+	}
+
+	named := map[string]bool{}
+	for _, arg := range args {
+		if !slices.Contains(CredentialTargets, arg) {
+			return nil, fmt.Errorf("unknown credential %q, expected one of %s", arg, strings.Join(CredentialTargets, ", "))
+		}
+		named[arg] = true
+	}
+
+	var targets []string
+	for _, target := range CredentialTargets {
+		if named[target] {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+// GitTokenEnv returns the environment variable NewGitopsRepo reads the
+// token of gitProvider from
+func GitTokenEnv(gitProvider string) string {
+	switch gitProvider {
+	case "gitlab":
+		return "GITLAB_TOKEN"
+	default:
+		return "GITHUB_TOKEN"
+	}
+}
+
+// CredentialReplacement is a credential value and the value replacing it
+type CredentialReplacement struct {
+	Old string
+	New string
+}
+
+// SecretMatch is a secret embedding a credential under Keys. Secrets an
+// ExternalSecret owns are written by its controller and name it
+type SecretMatch struct {
+	Namespace      string
+	Name           string
+	Keys           []string
+	ExternalSecret string
+}
+
+func (m SecretMatch) String() string {
+	return m.Namespace + "/" + m.Name
+}
+
+// Workload is a Deployment, StatefulSet or DaemonSet
+type Workload struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (w Workload) String() string {
+	return fmt.Sprintf("%s %s/%s", strings.ToLower(w.Kind), w.Namespace, w.Name)
+}
+
+// CredentialRotation is what rotating one credential touches, listed by
+// rotate-credentials --dry-run
+type CredentialRotation struct {
+	Target    string
+	Action    string
+	Secrets   []SecretMatch
+	Files     []string
+	Workloads []Workload
+}
+
+// WriteRotationTable writes the rotations as a table of what each changes
+func WriteRotationTable(w io.Writer, rotations []CredentialRotation) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tKIND\tNAME\tACTION")
+	for _, rotation := range rotations {
+		fmt.Fprintf(tw, "%s\tcredential\t-\t%s\n", rotation.Target, rotation.Action)
+		for _, secret := range rotation.Secrets {
+			action := "replace " + strings.Join(secret.Keys, ", ")
+			if secret.ExternalSecret != "" {
+				action = fmt.Sprintf("skip, written by ExternalSecret %s", secret.ExternalSecret)
+			}
+			fmt.Fprintf(tw, "%s\tsecret\t%s\t%s\n", rotation.Target, secret, action)
+		}
+		for _, file := range rotation.Files {
+			fmt.Fprintf(tw, "%s\tgitops file\t%s\treplace and commit\n", rotation.Target, file)
+		}
+		for _, workload := range rotation.Workloads {
+			fmt.Fprintf(tw, "%s\t%s\t%s/%s\trestart\n", rotation.Target, strings.ToLower(workload.Kind), workload.Namespace, workload.Name)
+		}
+	}
+
+	return tw.Flush()
+}
+
+// replaceCredentials returns content with every old value of replacements
+// replaced by its new one, surrounding whitespace of both ignored so keys
+// stored with and without a trailing newline match
+func replaceCredentials(content []byte, replacements []CredentialReplacement) []byte {
+	for _, replacement := range replacements {
+		old := strings.TrimSpace(replacement.Old)
+		if old == "" {
+			continue
+		}
+		content = bytes.ReplaceAll(content, []byte(old), []byte(strings.TrimSpace(replacement.New)))
+	}
+
+	return content
+}
+
+// embedsCredential reports whether content holds one of values
+func embedsCredential(content []byte, values []string) bool {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" && bytes.Contains(content, []byte(value)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FilesEmbedding returns the paths of files holding one of values, sorted
+func FilesEmbedding(files map[string][]byte, values []string) []string {
+	var paths []string
+	for path, content := range files {
+		if embedsCredential(content, values) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// ReplaceInFiles returns the files whose content changes with
+// replacements, with the new content
+func ReplaceInFiles(files map[string][]byte, replacements []CredentialReplacement) map[string][]byte {
+	changed := map[string][]byte{}
+	for path, content := range files {
+		if replaced := replaceCredentials(content, replacements); !bytes.Equal(replaced, content) {
+			changed[path] = replaced
+		}
+	}
+
+	return changed
+}
+
+// FindSecrets returns the secrets of every namespace with a key holding one
+// of values. Service account tokens and Helm releases never embed a
+// credential of kubefirst and are not read
+func (c *Client) FindSecrets(ctx context.Context, values []string) ([]SecretMatch, error) {
+	secrets, err := c.Clientset.CoreV1().Secrets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+
+	var matches []SecretMatch
+	for _, secret := range secrets.Items {
+		if secret.Type == corev1.SecretTypeServiceAccountToken || secret.Type == "helm.sh/release.v1" {
+			continue
+		}
+
+		match := SecretMatch{Namespace: secret.Namespace, Name: secret.Name}
+		for key, value := range secret.Data {
+			if embedsCredential(value, values) {
+				match.Keys = append(match.Keys, key)
+			}
+		}
+		if len(match.Keys) == 0 {
+			continue
+		}
+		sort.Strings(match.Keys)
+
+		for _, owner := range secret.OwnerReferences {
+			if owner.Kind == externalSecretKind {
+				match.ExternalSecret = owner.Name
+			}
+		}
+		matches = append(matches, match)
+	}
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].String() < matches[j].String() })
+
+	return matches, nil
+}
+
+// ReplaceInSecrets applies replacements to the matched keys of secrets.
+// Secrets an ExternalSecret owns are left alone, its controller would write
+// the old value back; their source has to change instead
+func (c *Client) ReplaceInSecrets(ctx context.Context, matches []SecretMatch, replacements []CredentialReplacement) error {
+	for _, match := range matches {
+		if match.ExternalSecret != "" {
+			continue
+		}
+
+		secrets := c.Clientset.CoreV1().Secrets(match.Namespace)
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			secret, err := secrets.Get(ctx, match.Name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			for _, key := range match.Keys {
+				if value, ok := secret.Data[key]; ok {
+					secret.Data[key] = replaceCredentials(value, replacements)
+				}
+			}
+
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{FieldManager: fieldManager})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update secret %s: %w", match, err)
+		}
+	}
+
+	return nil
+}
+
+// WorkloadsUsingSecrets returns the workloads whose pods read one of the
+// secrets through a volume or environment variable, and so only see a new
+// value once restarted
+func (c *Client) WorkloadsUsingSecrets(ctx context.Context, matches []SecretMatch) ([]Workload, error) {
+	names := map[string]map[string]bool{}
+	for _, match := range matches {
+		if names[match.Namespace] == nil {
+			names[match.Namespace] = map[string]bool{}
+		}
+		names[match.Namespace][match.Name] = true
+	}
+
+	var workloads []Workload
+	for namespace, secrets := range names {
+		deployments, err := c.Clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list deployments of namespace %s: %w", namespace, err)
+		}
+		for _, deployment := range deployments.Items {
+			if podSpecUsesSecrets(&deployment.Spec.Template.Spec, secrets) {
+				workloads = append(workloads, Workload{Kind: "Deployment", Namespace: namespace, Name: deployment.Name})
+			}
+		}
+
+		statefulSets, err := c.Clientset.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list statefulsets of namespace %s: %w", namespace, err)
+		}
+		for _, statefulSet := range statefulSets.Items {
+			if podSpecUsesSecrets(&statefulSet.Spec.Template.Spec, secrets) {
+				workloads = append(workloads, Workload{Kind: "StatefulSet", Namespace: namespace, Name: statefulSet.Name})
+			}
+		}
+
+		daemonSets, err := c.Clientset.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list daemonsets of namespace %s: %w", namespace, err)
+		}
+		for _, daemonSet := range daemonSets.Items {
+			if podSpecUsesSecrets(&daemonSet.Spec.Template.Spec, secrets) {
+				workloads = append(workloads, Workload{Kind: "DaemonSet", Namespace: namespace, Name: daemonSet.Name})
+			}
+		}
+	}
+
+	sort.Slice(workloads, func(i, j int) bool { return workloads[i].String() < workloads[j].String() })
+
+	return workloads, nil
+}
+
+func podSpecUsesSecrets(spec *corev1.PodSpec, secrets map[string]bool) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && secrets[volume.Secret.SecretName] {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && secrets[source.Secret.Name] {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, container := range append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...) {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && secrets[env.ValueFrom.SecretKeyRef.Name] {
+				return true
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && secrets[envFrom.SecretRef.Name] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RestartWorkloads rolls the pods of workloads the way kubectl rollout
+// restart does, by stamping their pod template
+func (c *Client) RestartWorkloads(ctx context.Context, workloads []Workload) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{restartedAtAnnotation: time.Now().UTC().Format(time.RFC3339)},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build restart patch: %w", err)
+	}
+
+	apps := c.Clientset.AppsV1()
+	for _, workload := range workloads {
+		options := metav1.PatchOptions{FieldManager: fieldManager}
+		switch workload.Kind {
+		case "Deployment":
+			_, err = apps.Deployments(workload.Namespace).Patch(ctx, workload.Name, types.StrategicMergePatchType, patch, options)
+		case "StatefulSet":
+			_, err = apps.StatefulSets(workload.Namespace).Patch(ctx, workload.Name, types.StrategicMergePatchType, patch, options)
+		case "DaemonSet":
+			_, err = apps.DaemonSets(workload.Namespace).Patch(ctx, workload.Name, types.StrategicMergePatchType, patch, options)
+		default:
+			err = fmt.Errorf("unsupported workload kind %q", workload.Kind)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restart %s: %w", workload, err)
+		}
+	}
+
+	return nil
+}
+
+// WaitForWorkloads blocks until every workload has rolled out its current
+// pod template, or the context is done
+func (c *Client) WaitForWorkloads(ctx context.Context, workloads []Workload) error {
+	ticker := time.NewTicker(nodePollInterval)
+	defer ticker.Stop()
+
+	for {
+		var pending []string
+		for _, workload := range workloads {
+			done, err := c.rolledOut(ctx, workload)
+			if err != nil {
+				return err
+			}
+			if !done {
+				pending = append(pending, workload.String())
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to roll out: %w", strings.Join(pending, ", "), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) rolledOut(ctx context.Context, workload Workload) (bool, error) {
+	apps := c.Clientset.AppsV1()
+
+	var err error
+	done := false
+	switch workload.Kind {
+	case "Deployment":
+		var deployment *appsv1.Deployment
+		if deployment, err = apps.Deployments(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{}); err == nil {
+			desired := int32(1)
+			if deployment.Spec.Replicas != nil {
+				desired = *deployment.Spec.Replicas
+			}
+			status := deployment.Status
+			done = status.ObservedGeneration >= deployment.Generation && status.UpdatedReplicas == desired && status.AvailableReplicas == desired && status.Replicas == desired
+		}
+	case "StatefulSet":
+		var statefulSet *appsv1.StatefulSet
+		if statefulSet, err = apps.StatefulSets(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{}); err == nil {
+			desired := int32(1)
+			if statefulSet.Spec.Replicas != nil {
+				desired = *statefulSet.Spec.Replicas
+			}
+			status := statefulSet.Status
+			done = status.ObservedGeneration >= statefulSet.Generation && status.UpdatedReplicas == desired && status.ReadyReplicas == desired && status.CurrentRevision == status.UpdateRevision
+		}
+	case "DaemonSet":
+		var daemonSet *appsv1.DaemonSet
+		if daemonSet, err = apps.DaemonSets(workload.Namespace).Get(ctx, workload.Name, metav1.GetOptions{}); err == nil {
+			status := daemonSet.Status
+			done = status.ObservedGeneration >= daemonSet.Generation && status.UpdatedNumberScheduled == status.DesiredNumberScheduled && status.NumberAvailable == status.DesiredNumberScheduled
+		}
+	default:
+		return false, fmt.Errorf("unsupported workload kind %q", workload.Kind)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", workload, err)
+	}
+
+	return done, nil
+}
+
+// GenerateArgoCDAdminPassword returns a random password for the ArgoCD
+// admin user
+func GenerateArgoCDAdminPassword() (string, error) {
+	password := make([]byte, 24)
+	if _, err := rand.Read(password); err != nil {
+		return "", fmt.Errorf("failed to generate argocd admin password: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(password), nil
+}
+
+// SetArgoCDAdminPassword stores the bcrypt hash of password as the ArgoCD
+// admin password. Bumping its mtime ends the sessions of the old one
+func (c *Client) SetArgoCDAdminPassword(ctx context.Context, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash argocd admin password: %w", err)
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"stringData": map[string]string{
+			"admin.password":      string(hash),
+			"admin.passwordMtime": time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to build argocd-secret patch: %w", err)
+	}
+
+	_, err = c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Patch(ctx, "argocd-secret", types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to set the admin password of ArgoCD: %w", err)
+	}
+
+	return nil
+}
+
+// ArgoCDAdminPassword returns the admin password argocd-initial-admin-secret
+// still holds, empty once it is deleted
+func (c *Client) ArgoCDAdminPassword(ctx context.Context) (string, error) {
+	secret, err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Get(ctx, argoCDAdminSecret, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s/%s: %w", ArgoCDNamespace, argoCDAdminSecret, err)
+	}
+
+	return string(secret.Data["password"]), nil
+}
+
+// ArgoCDRepoSSHKey returns the private key of the ArgoCD repository secret
+// of the gitops repository, empty when it holds none
+func (c *Client) ArgoCDRepoSSHKey(ctx context.Context) (string, error) {
+	secret, err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Get(ctx, ArgoCDRepoSecretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret %s/%s: %w", ArgoCDNamespace, ArgoCDRepoSecretName, err)
+	}
+
+	return string(secret.Data["sshPrivateKey"]), nil
+}
+
+// VerifyArgoCDLogin logs in to the ArgoCD API as admin with password
+// through a port-forward to argocd-server
+func (c *Client) VerifyArgoCDLogin(ctx context.Context, password string) error {
+	target, err := ResolveConnectTarget("argocd", false, false, nil)
+	if err != nil {
+		return err
+	}
+	localPort, stop, err := c.forwardLocal(ctx, target)
+	if err != nil {
+		return fmt.Errorf("failed to forward to argocd-server: %w", err)
+	}
+	defer stop()
+
+	body, err := json.Marshal(map[string]string{"username": "admin", "password": password})
+	if err != nil {
+		return fmt.Errorf("failed to encode argocd login: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL(localPort)+"/api/v1/session", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build argocd login: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := (&http.Client{Timeout: argoCDLoginTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to log in to argocd: %w", err)
+	}
+	defer res.Body.Close()
+
+	var session struct {
+		Token string `json:"token"`
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to log in to argocd as admin: %s", res.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&session); err != nil || session.Token == "" {
+		return errors.New("argocd accepted the admin login but returned no session")
+	}
+
+	return nil
+}
+
+// VerifyRepoFetch hard-refreshes the application and fails when ArgoCD
+// cannot fetch its repository afterwards
+func (c *Client) VerifyRepoFetch(ctx context.Context, name string) error {
+	apps := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		app, err := apps.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if app.Annotations == nil {
+			app.Annotations = map[string]string{}
+		}
+		app.Annotations[v1alpha1.AnnotationKeyRefresh] = string(v1alpha1.RefreshTypeHard)
+
+		_, err = apps.Update(ctx, app, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh application %q: %w", name, err)
+	}
+
+	ticker := time.NewTicker(nodePollInterval)
+	defer ticker.Stop()
+
+	for {
+		// ArgoCD drops the annotation once the refresh is done
+		app, err := apps.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to read application %q: %w", name, err)
+		}
+		if _, refreshing := app.Annotations[v1alpha1.AnnotationKeyRefresh]; !refreshing {
+			for _, condition := range app.Status.Conditions {
+				if condition.Type == v1alpha1.ApplicationConditionComparisonError {
+					return fmt.Errorf("argocd cannot fetch the repository of application %q: %s", name, condition.Message)
+				}
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for argocd to refresh application %q: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// RollToken replaces the token at Cloudflare and returns the new value, the
+// current one stops working. Only user tokens allowed to edit themselves
+// can be rolled
+func (d *CloudflareDNS) RollToken(ctx context.Context) (string, error) {
+	var verified struct {
+		ID string `json:"id"`
+	}
+	if err := d.request(ctx, "Cloudflare token verify", http.MethodGet, "user/tokens/verify", nil, &verified); err != nil {
+		return "", fmt.Errorf("failed to look up the cloudflare token: %w", err)
+	}
+
+	// rolling is not idempotent, a retry would roll the new token again
+	var token string
+	if err := d.send(ctx, http.MethodPut, fmt.Sprintf("user/tokens/%s/value", verified.ID), []byte("{}"), &token); err != nil {
+		return "", fmt.Errorf("failed to roll the cloudflare token: %w", err)
+	}
+
+	return token, nil
+}
+
+// Zones returns the names of the zones the token can list, as external-dns
+// does before managing records
+func (d *CloudflareDNS) Zones(ctx context.Context) ([]string, error) {
+	var zones []struct {
+		Name string `json:"name"`
+	}
+	if err := d.request(ctx, "Cloudflare zone list", http.MethodGet, "zones", nil, &zones); err != nil {
+		return nil, fmt.Errorf("failed to list cloudflare zones: %w", err)
+	}
+
+	names := make([]string, 0, len(zones))
+	for _, zone := range zones {
+		names = append(names, zone.Name)
+	}
+
+	return names, nil
+}
+
+// RotateToken rotates the token of the repository at the git provider,
+// switches the repository onto the new one and returns it. Only GitLab
+// rotates tokens through its API, other providers return
+// ErrTokenNotRotatable
+func (r *GitopsRepo) RotateToken(ctx context.Context) (string, error) {
+	if r.provider != "gitlab" {
+		return "", fmt.Errorf("%w: %s", ErrTokenNotRotatable, r.provider)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.apiURL+"personal_access_tokens/self/rotate", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build gitlab request: %w", err)
+	}
+	req.Header.Set("PRIVATE-TOKEN", r.Auth.Password)
+
+	var rotated struct {
+		Token string `json:"token"`
+	}
+	if err := r.do(req, "gitlab", &rotated); err != nil {
+		return "", fmt.Errorf("failed to rotate the gitlab token: %w", err)
+	}
+	if rotated.Token == "" {
+		return "", errors.New("gitlab rotated the token but returned no new one")
+	}
+	r.Auth.Password = rotated.Token
+
+	return rotated.Token, nil
+}
@@ -0,0 +1,159 @@
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestResolveCredentialTargets(t *testing.T) {
+	targets, err := ResolveCredentialTargets([]string{"kbot-ssh", "git"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"git", "kbot-ssh"}, targets)
+
+	targets, err = ResolveCredentialTargets(nil, true)
+	require.NoError(t, err)
+	assert.Equal(t, CredentialTargets, targets)
+
+	_, err = ResolveCredentialTargets(nil, false)
+	require.ErrorContains(t, err, "or --all")
+	_, err = ResolveCredentialTargets([]string{"git"}, true)
+	require.Error(t, err)
+	_, err = ResolveCredentialTargets([]string{"vault"}, false)
+	require.ErrorContains(t, err, `unknown credential "vault"`)
+}
+
+func TestReplaceInFiles(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kbot.yaml":   []byte("publicKey: ssh-ed25519 OLD\n"),
+		"registry/argocd.yaml": []byte("kind: Application\n"),
+	}
+
+	assert.Equal(t, []string{"registry/kbot.yaml"}, FilesEmbedding(files, []string{"ssh-ed25519 OLD\n", ""}))
+	assert.Equal(t, map[string][]byte{"registry/kbot.yaml": []byte("publicKey: ssh-ed25519 NEW\n")}, ReplaceInFiles(files, []CredentialReplacement{{Old: "ssh-ed25519 OLD\n", New: "ssh-ed25519 NEW\n"}}))
+}
+
+func TestReplaceInSecrets(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset(
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "external-dns", Name: "cloudflare"}, Data: map[string][]byte{"token": []byte("old-token"), "zone": []byte("example.com")}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "atlantis", Name: "atlantis-secrets", OwnerReferences: []metav1.OwnerReference{{Kind: "ExternalSecret", Name: "atlantis-secrets"}}},
+			Data:       map[string][]byte{"CF_API_TOKEN": []byte("old-token")},
+		},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "unrelated"}, Data: map[string][]byte{"token": []byte("other")}},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "external-dns", Name: "external-dns"},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Env: []corev1.EnvVar{{Name: "CF_API_TOKEN", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "cloudflare"}, Key: "token"}}}},
+			}}}}},
+		},
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "external-dns", Name: "other"}},
+	)}
+	ctx := context.Background()
+
+	matches, err := client.FindSecrets(ctx, []string{"old-token"})
+	require.NoError(t, err)
+	assert.Equal(t, []SecretMatch{
+		{Namespace: "atlantis", Name: "atlantis-secrets", Keys: []string{"CF_API_TOKEN"}, ExternalSecret: "atlantis-secrets"},
+		{Namespace: "external-dns", Name: "cloudflare", Keys: []string{"token"}},
+	}, matches)
+
+	workloads, err := client.WorkloadsUsingSecrets(ctx, matches)
+	require.NoError(t, err)
+	assert.Equal(t, []Workload{{Kind: "Deployment", Namespace: "external-dns", Name: "external-dns"}}, workloads)
+
+	require.NoError(t, client.ReplaceInSecrets(ctx, matches, []CredentialReplacement{{Old: "old-token", New: "new-token"}}))
+	secret, err := client.Clientset.CoreV1().Secrets("external-dns").Get(ctx, "cloudflare", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "new-token", string(secret.Data["token"]))
+	owned, err := client.Clientset.CoreV1().Secrets("atlantis").Get(ctx, "atlantis-secrets", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "old-token", string(owned.Data["CF_API_TOKEN"]), "secrets of an ExternalSecret are left to its controller")
+
+	require.NoError(t, client.RestartWorkloads(ctx, workloads))
+	deployment, err := client.Clientset.AppsV1().Deployments("external-dns").Get(ctx, "external-dns", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, deployment.Spec.Template.Annotations[restartedAtAnnotation])
+}
+
+func TestSetArgoCDAdminPassword(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset(&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: ArgoCDNamespace, Name: "argocd-secret"}})}
+
+	password, err := GenerateArgoCDAdminPassword()
+	require.NoError(t, err)
+	require.NoError(t, client.SetArgoCDAdminPassword(context.Background(), password))
+
+	secret, err := client.Clientset.CoreV1().Secrets(ArgoCDNamespace).Get(context.Background(), "argocd-secret", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.NoError(t, bcrypt.CompareHashAndPassword([]byte(secret.StringData["admin.password"]), []byte(password)))
+	assert.NotEmpty(t, secret.StringData["admin.passwordMtime"])
+}
+
+func TestRollCloudflareToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := `[{"name":"example.com"}]`
+		switch {
+		case r.URL.Path == "/user/tokens/verify":
+			result = `{"id":"abc","status":"active"}`
+		case r.Method == http.MethodPut && r.URL.Path == "/user/tokens/abc/value":
+			result = `"rolled"`
+		}
+		w.Write([]byte(`{"success":true,"errors":[],"result":` + result + `}`))
+	}))
+	defer server.Close()
+
+	dns, err := NewCloudflareDNS("token", server.Client())
+	require.NoError(t, err)
+	dns.apiURL = server.URL + "/"
+
+	token, err := dns.RollToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "rolled", token)
+
+	zones, err := dns.Zones(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com"}, zones)
+}
+
+func TestRotateGitToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/personal_access_tokens/self/rotate", r.URL.Path)
+		require.Equal(t, "old", r.Header.Get("PRIVATE-TOKEN"))
+		w.Write([]byte(`{"id":7,"token":"glpat-new"}`))
+	}))
+	defer server.Close()
+
+	repo := &GitopsRepo{Auth: &githttp.BasicAuth{Password: "old"}, provider: "gitlab", apiURL: server.URL + "/"}
+	token, err := repo.RotateToken(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "glpat-new", token)
+	assert.Equal(t, "glpat-new", repo.Auth.Password)
+
+	_, err = (&GitopsRepo{Auth: &githttp.BasicAuth{}, provider: "github"}).RotateToken(context.Background())
+	require.ErrorIs(t, err, ErrTokenNotRotatable)
+}
+
+func TestWriteRotationTable(t *testing.T) {
+	var out bytes.Buffer
+	require.NoError(t, WriteRotationTable(&out, []CredentialRotation{{
+		Target:    "cloudflare",
+		Action:    "roll the cloudflare token",
+		Secrets:   []SecretMatch{{Namespace: "external-dns", Name: "cloudflare", Keys: []string{"token"}}},
+		Files:     []string{"registry/dns.yaml"},
+		Workloads: []Workload{{Kind: "Deployment", Namespace: "external-dns", Name: "external-dns"}},
+	}}))
+
+	assert.Contains(t, out.String(), "secret       external-dns/cloudflare")
+	assert.Contains(t, out.String(), "deployment   external-dns/external-dns  restart")
+}
@@ -0,0 +1,465 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// Route53APIURL is the base url of the Route 53 API
+const Route53APIURL = "https://route53.amazonaws.com/2013-04-01/"
+
+const (
+	// route53Region signs the requests, Route 53 is a global service served
+	// from us-east-1
+	route53Region = "us-east-1"
+	route53XMLNS  = "https://route53.amazonaws.com/doc/2013-04-01/"
+	route53TTL    = 300
+)
+
+// Route53DNS updates the platform records in a Route 53 hosted zone,
+// authenticated with the AWS credentials of the environment. Route 53
+// records carry no comment, so the records create manages are marked by a
+// TXT record of the same name holding the ManagedRecordComment
+type Route53DNS struct {
+	Retry APIRetry
+
+	hostedZoneID string
+	credentials  aws.CredentialsProvider
+	signer       *v4.Signer
+	apiURL       string
+	httpClient   *http.Client
+}
+
+// NewRoute53DNS returns a Route53DNS of the hosted zone hostedZoneID using
+// credentials over httpClient
+func NewRoute53DNS(hostedZoneID string, credentials aws.CredentialsProvider, httpClient *http.Client) (*Route53DNS, error) {
+	hostedZoneID = strings.TrimPrefix(hostedZoneID, "/hostedzone/")
+	if hostedZoneID == "" {
+		return nil, errors.New("the route53 hosted zone id is not set, pass --route53-hosted-zone-id")
+	}
+	if credentials == nil {
+		return nil, errors.New("no AWS credentials to call route53 with")
+	}
+
+	return &Route53DNS{
+		hostedZoneID: hostedZoneID,
+		credentials:  credentials,
+		signer:       v4.NewSigner(),
+		apiURL:       Route53APIURL,
+		httpClient:   httpClient,
+	}, nil
+}
+
+type route53Record struct {
+	Value string `xml:"Value"`
+}
+
+type route53RecordSet struct {
+	Name    string          `xml:"Name"`
+	Type    string          `xml:"Type"`
+	TTL     int             `xml:"TTL,omitempty"`
+	Records []route53Record `xml:"ResourceRecords>ResourceRecord,omitempty"`
+}
+
+type route53Change struct {
+	Action    string           `xml:"Action"`
+	RecordSet route53RecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Comment string          `xml:"ChangeBatch>Comment,omitempty"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53ListResponse struct {
+	RecordSets     []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+	IsTruncated    bool               `xml:"IsTruncated"`
+	NextRecordName string             `xml:"NextRecordName"`
+	NextRecordType string             `xml:"NextRecordType"`
+}
+
+type route53ErrorResponse struct {
+	Errors []struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+}
+
+// route53Name returns the name Route 53 lists the record of host under:
+// lower case, fully qualified and with a wildcard escaped
+func route53Name(host string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSuffix(host, ".")), "*", `\052`) + "."
+}
+
+// route53Host is the host of a record set name as route53Name renders it
+func route53Host(name string) string {
+	return strings.ReplaceAll(strings.TrimSuffix(name, "."), `\052`, "*")
+}
+
+// ReconcileRecords points the A record of every host of desired at its
+// address, creating the missing ones along with the TXT record marking them
+// as managed under comment. With prune, the managed records of the hosted
+// zone that are no longer desired are deleted. Records of a name without
+// the marker are left as they are. knownZones are not used, the records
+// are only ever in the one hosted zone
+func (d *Route53DNS) ReconcileRecords(ctx context.Context, desired map[string]string, comment string, _ []string, prune bool) (DNSReconcileResult, error) {
+	result := DNSReconcileResult{Zones: []string{d.hostedZoneID}}
+
+	sets, err := d.recordSets(ctx)
+	if err != nil {
+		return result, err
+	}
+	marker := strconv.Quote(comment)
+	byName := map[string][]route53RecordSet{}
+	for _, set := range sets {
+		byName[set.Name] = append(byName[set.Name], set)
+	}
+
+	var upserts []route53Change
+	for _, host := range sortedKeys(desired) {
+		name := route53Name(host)
+		address := route53RecordSet{Name: name, Type: "A", TTL: route53TTL, Records: []route53Record{{Value: desired[host]}}}
+
+		existing := byName[name]
+		switch {
+		case len(existing) == 0:
+			upserts = append(upserts,
+				route53Change{Action: "UPSERT", RecordSet: address},
+				route53Change{Action: "UPSERT", RecordSet: route53RecordSet{Name: name, Type: "TXT", TTL: route53TTL, Records: []route53Record{{Value: marker}}}},
+			)
+			result.Created = append(result.Created, host)
+		case !route53Managed(existing, marker):
+			result.Unmanaged = append(result.Unmanaged, host)
+		default:
+			current := route53Set(existing, "A")
+			if current != nil && len(current.Records) == 1 && current.Records[0].Value == desired[host] {
+				continue
+			}
+			upserts = append(upserts, route53Change{Action: "UPSERT", RecordSet: address})
+			if current == nil {
+				result.Created = append(result.Created, host)
+			} else {
+				result.Updated = append(result.Updated, host)
+			}
+		}
+	}
+
+	if len(upserts) > 0 {
+		// upserts may be repeated, a retry cannot create a record twice
+		if err := d.Retry.Do(ctx, "route53 change records", func(ctx context.Context) error {
+			return d.changeRecordSets(ctx, comment, upserts)
+		}); err != nil {
+			return DNSReconcileResult{Zones: result.Zones}, err
+		}
+	}
+
+	if !prune {
+		return result, nil
+	}
+
+	var deletes []route53Change
+	var deleted []string
+	for _, name := range sortedKeys(byName) {
+		host := route53Host(name)
+		if _, ok := desired[host]; ok || !route53Managed(byName[name], marker) {
+			continue
+		}
+		for _, set := range byName[name] {
+			if set.Type == "A" || set.Type == "TXT" {
+				deletes = append(deletes, route53Change{Action: "DELETE", RecordSet: set})
+			}
+		}
+		deleted = append(deleted, host)
+	}
+	if len(deletes) == 0 {
+		return result, nil
+	}
+
+	// a delete that went through fails when repeated, so a retry first
+	// checks whether the records are still there
+	err = d.Retry.DoUnlessExists(ctx, "route53 delete records", func(ctx context.Context) error {
+		return d.changeRecordSets(ctx, comment, deletes)
+	}, func(ctx context.Context) (bool, error) {
+		sets, err := d.recordSets(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, set := range sets {
+			if set.Type == "TXT" && slices.Contains(deleted, route53Host(set.Name)) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Deleted = deleted
+
+	return result, nil
+}
+
+// ZoneRecords lists the A records of the hosted zone, the one zone serving
+// every host. The records of a name marked as managed carry the comment of
+// their TXT record
+func (d *Route53DNS) ZoneRecords(ctx context.Context, _ []string) ([]DNSRecord, error) {
+	sets, err := d.recordSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string][]route53RecordSet{}
+	for _, set := range sets {
+		byName[set.Name] = append(byName[set.Name], set)
+	}
+
+	var records []DNSRecord
+	for _, name := range sortedKeys(byName) {
+		if record := d.aRecord(byName[name]); record != nil {
+			records = append(records, *record)
+		}
+	}
+
+	return records, nil
+}
+
+// ManagedRecords returns the A records of hosts marked as managed under
+// comment, the ones create made for them
+func (d *Route53DNS) ManagedRecords(ctx context.Context, hosts []string, comment string) ([]DNSRecord, error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+
+	records, err := d.ZoneRecords(ctx, hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(records, func(record DNSRecord) bool {
+		return record.Comment != comment || !slices.Contains(hosts, record.Name)
+	}), nil
+}
+
+// ARecord returns the A record of host in the hosted zone
+func (d *Route53DNS) ARecord(ctx context.Context, host string) (*DNSRecord, error) {
+	records, err := d.ZoneRecords(ctx, []string{host})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the A record of %q: %w", host, err)
+	}
+
+	index := slices.IndexFunc(records, func(record DNSRecord) bool { return record.Name == route53Host(route53Name(host)) })
+	if index < 0 {
+		return nil, fmt.Errorf("no A record for %q in route53 hosted zone %s", host, d.hostedZoneID)
+	}
+
+	return &records[index], nil
+}
+
+// UpdateARecord points record at address, leaving its marker as it is
+func (d *Route53DNS) UpdateARecord(ctx context.Context, record *DNSRecord, address string) error {
+	upsert := route53Change{Action: "UPSERT", RecordSet: route53RecordSet{Name: route53Name(record.Name), Type: "A", TTL: route53TTL, Records: []route53Record{{Value: address}}}}
+
+	// an upsert may be repeated
+	if err := d.Retry.Do(ctx, "route53 record update", func(ctx context.Context) error {
+		return d.changeRecordSets(ctx, record.Comment, []route53Change{upsert})
+	}); err != nil {
+		return fmt.Errorf("failed to point %q at %s: %w", record.Name, address, err)
+	}
+
+	return nil
+}
+
+// DeleteRecord deletes the A record of record along with the TXT record
+// marking it as managed
+func (d *Route53DNS) DeleteRecord(ctx context.Context, record DNSRecord) error {
+	sets, err := d.recordSets(ctx)
+	if err != nil {
+		return err
+	}
+
+	name := route53Name(record.Name)
+	var deletes []route53Change
+	for _, set := range sets {
+		if set.Name == name && (set.Type == "A" || set.Type == "TXT") {
+			deletes = append(deletes, route53Change{Action: "DELETE", RecordSet: set})
+		}
+	}
+	if len(deletes) == 0 {
+		return nil
+	}
+
+	// a delete that went through fails when repeated, so a retry first
+	// checks whether the record is still there
+	err = d.Retry.DoUnlessExists(ctx, "route53 delete record", func(ctx context.Context) error {
+		return d.changeRecordSets(ctx, record.Comment, deletes)
+	}, func(ctx context.Context) (bool, error) {
+		sets, err := d.recordSets(ctx)
+		if err != nil {
+			return false, err
+		}
+		return !slices.ContainsFunc(sets, func(set route53RecordSet) bool { return set.Name == name && set.Type == "A" }), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the A record of %q: %w", record.Name, err)
+	}
+
+	return nil
+}
+
+// aRecord returns the A record of the record sets of a name, nil when it
+// has none. Its comment is the one of the TXT record of the name
+func (d *Route53DNS) aRecord(sets []route53RecordSet) *DNSRecord {
+	address := route53Set(sets, "A")
+	if address == nil || len(address.Records) == 0 {
+		return nil
+	}
+
+	record := &DNSRecord{
+		ZoneID:  d.hostedZoneID,
+		ID:      address.Name,
+		Type:    "A",
+		Name:    route53Host(address.Name),
+		Content: address.Records[0].Value,
+	}
+	if txt := route53Set(sets, "TXT"); txt != nil && len(txt.Records) > 0 {
+		if comment, err := strconv.Unquote(txt.Records[0].Value); err == nil {
+			record.Comment = comment
+		}
+	}
+
+	return record
+}
+
+// route53Managed reports whether the record sets of a name hold the TXT
+// record marking them as managed
+func route53Managed(sets []route53RecordSet, marker string) bool {
+	txt := route53Set(sets, "TXT")
+	if txt == nil {
+		return false
+	}
+
+	return slices.ContainsFunc(txt.Records, func(record route53Record) bool {
+		return record.Value == marker
+	})
+}
+
+func route53Set(sets []route53RecordSet, recordType string) *route53RecordSet {
+	for i := range sets {
+		if sets[i].Type == recordType {
+			return &sets[i]
+		}
+	}
+
+	return nil
+}
+
+// recordSets lists the record sets of the hosted zone, page by page
+func (d *Route53DNS) recordSets(ctx context.Context) ([]route53RecordSet, error) {
+	var sets []route53RecordSet
+	query := url.Values{}
+	for {
+		var page route53ListResponse
+		path := "hostedzone/" + d.hostedZoneID + "/rrset?" + query.Encode()
+		if err := d.Retry.Do(ctx, "route53 list records", func(ctx context.Context) error {
+			return d.send(ctx, http.MethodGet, path, nil, &page)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to list the records of hosted zone %s: %w", d.hostedZoneID, err)
+		}
+		sets = append(sets, page.RecordSets...)
+
+		if !page.IsTruncated {
+			return sets, nil
+		}
+		query = url.Values{"name": {page.NextRecordName}, "type": {page.NextRecordType}}
+	}
+}
+
+func (d *Route53DNS) changeRecordSets(ctx context.Context, comment string, changes []route53Change) error {
+	body, err := xml.Marshal(route53ChangeRequest{XMLNS: route53XMLNS, Comment: comment, Changes: changes})
+	if err != nil {
+		return fmt.Errorf("failed to encode route53 request: %w", err)
+	}
+
+	if err := d.send(ctx, http.MethodPost, "hostedzone/"+d.hostedZoneID+"/rrset", append([]byte(xml.Header), body...), nil); err != nil {
+		return fmt.Errorf("failed to change the records of hosted zone %s: %w", d.hostedZoneID, err)
+	}
+
+	return nil
+}
+
+func (d *Route53DNS) send(ctx context.Context, method, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, d.apiURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build route53 request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "text/xml")
+	}
+
+	credentials, err := d.credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := d.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), "route53", route53Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign route53 request: %w", err)
+	}
+
+	res, err := d.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call route53 api: %w", err)
+	}
+	defer res.Body.Close()
+
+	// throttling and outages may not carry an xml body
+	if transientStatus(res.StatusCode) {
+		return newAPIError("route53", res)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, 8<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read route53 response: %w", err)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		var decoded route53ErrorResponse
+		if err := xml.Unmarshal(data, &decoded); err != nil || len(decoded.Errors) == 0 {
+			return fmt.Errorf("route53 api returned %q: %s", res.Status, strings.TrimSpace(string(data[:min(len(data), 1024)])))
+		}
+		messages := make([]string, 0, len(decoded.Errors))
+		for _, e := range decoded.Errors {
+			messages = append(messages, e.Code+": "+e.Message)
+		}
+		return fmt.Errorf("route53 api returned %q: %s", res.Status, strings.Join(messages, ", "))
+	}
+
+	if out != nil {
+		if err := xml.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("failed to decode route53 response: %w", err)
+		}
+	}
+
+	return nil
+}
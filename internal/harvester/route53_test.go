@@ -0,0 +1,120 @@
+package harvester
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeRoute53(t *testing.T, sets []route53RecordSet) (*Route53DNS, *[]route53RecordSet) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/hostedzone/Z0123/rrset", r.URL.Path)
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+
+		if r.Method == http.MethodPost {
+			var change route53ChangeRequest
+			require.NoError(t, xml.NewDecoder(r.Body).Decode(&change))
+			for _, c := range change.Changes {
+				sets = slices.DeleteFunc(sets, func(set route53RecordSet) bool {
+					return set.Name == c.RecordSet.Name && set.Type == c.RecordSet.Type
+				})
+				if c.Action == "UPSERT" {
+					sets = append(sets, c.RecordSet)
+				}
+			}
+			w.Write([]byte(`<ChangeResourceRecordSetsResponse/>`))
+			return
+		}
+
+		data, err := xml.Marshal(struct {
+			XMLName xml.Name           `xml:"ListResourceRecordSetsResponse"`
+			Sets    []route53RecordSet `xml:"ResourceRecordSets>ResourceRecordSet"`
+		}{Sets: sets})
+		require.NoError(t, err)
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	credentials := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, nil
+	})
+	dns, err := NewRoute53DNS("/hostedzone/Z0123", credentials, server.Client())
+	require.NoError(t, err)
+	dns.apiURL = server.URL + "/"
+
+	return dns, &sets
+}
+
+func TestRoute53ReconcileRecords(t *testing.T) {
+	comment := ManagedRecordComment("homelab")
+	marker := []route53Record{{Value: `"` + comment + `"`}}
+	dns, sets := fakeRoute53(t, []route53RecordSet{
+		{Name: "vault.example.com.", Type: "A", TTL: 300, Records: []route53Record{{Value: "10.0.12.4"}}},
+		{Name: "vault.example.com.", Type: "TXT", TTL: 300, Records: marker},
+		{Name: "argocd.example.org.", Type: "A", TTL: 300, Records: []route53Record{{Value: "10.0.12.5"}}},
+		{Name: "argocd.example.org.", Type: "TXT", TTL: 300, Records: marker},
+		{Name: "gitea.example.com.", Type: "A", TTL: 60, Records: []route53Record{{Value: "10.0.12.7"}}},
+	})
+	desired := map[string]string{
+		"argocd.example.com": "10.0.12.5",
+		"vault.example.com":  "10.0.12.5",
+		"gitea.example.com":  "10.0.12.5",
+		"*.dev.example.com":  "10.0.12.6",
+	}
+
+	result, err := dns.ReconcileRecords(context.Background(), desired, comment, nil, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.dev.example.com", "argocd.example.com"}, result.Created)
+	assert.Equal(t, []string{"vault.example.com"}, result.Updated)
+	assert.Equal(t, []string{"gitea.example.com"}, result.Unmanaged)
+	assert.Equal(t, []string{"Z0123"}, result.Zones)
+	assert.Contains(t, *sets, route53RecordSet{Name: `\052.dev.example.com.`, Type: "TXT", TTL: 300, Records: marker})
+
+	result, err = dns.ReconcileRecords(context.Background(), desired, comment, nil, true)
+	require.NoError(t, err)
+	assert.Empty(t, result.Created)
+	assert.Empty(t, result.Updated)
+	assert.Equal(t, []string{"argocd.example.org"}, result.Deleted)
+	assert.Contains(t, *sets, route53RecordSet{Name: "gitea.example.com.", Type: "A", TTL: 60, Records: []route53Record{{Value: "10.0.12.7"}}})
+	assert.False(t, slices.ContainsFunc(*sets, func(set route53RecordSet) bool { return set.Name == "argocd.example.org." }))
+
+	result, err = dns.ReconcileRecords(context.Background(), desired, comment, nil, true)
+	require.NoError(t, err)
+	assert.False(t, result.Changed())
+}
+
+func TestRoute53Records(t *testing.T) {
+	comment := ManagedRecordComment("homelab")
+	marker := []route53Record{{Value: `"` + comment + `"`}}
+	dns, sets := fakeRoute53(t, []route53RecordSet{
+		{Name: "argocd.example.com.", Type: "A", TTL: 300, Records: []route53Record{{Value: "10.0.12.5"}}},
+		{Name: "argocd.example.com.", Type: "TXT", TTL: 300, Records: marker},
+		{Name: "gitea.example.com.", Type: "A", TTL: 60, Records: []route53Record{{Value: "10.0.12.7"}}},
+	})
+	assert.Implements(t, (*DNSProvider)(nil), dns)
+
+	managed, err := dns.ManagedRecords(context.Background(), []string{"argocd.example.com", "gitea.example.com"}, comment)
+	require.NoError(t, err)
+	require.Len(t, managed, 1)
+	assert.Equal(t, DNSRecord{ZoneID: "Z0123", ID: "argocd.example.com.", Type: "A", Name: "argocd.example.com", Content: "10.0.12.5", Comment: comment}, managed[0])
+
+	record, err := dns.ARecord(context.Background(), "Gitea.example.com")
+	require.NoError(t, err)
+	require.NoError(t, dns.UpdateARecord(context.Background(), record, "10.0.13.7"))
+	assert.Contains(t, *sets, route53RecordSet{Name: "gitea.example.com.", Type: "A", TTL: 300, Records: []route53Record{{Value: "10.0.13.7"}}})
+
+	_, err = dns.ARecord(context.Background(), "vault.example.com")
+	require.ErrorContains(t, err, `no A record for "vault.example.com"`)
+
+	require.NoError(t, dns.DeleteRecord(context.Background(), managed[0]))
+	assert.False(t, slices.ContainsFunc(*sets, func(set route53RecordSet) bool { return set.Name == "argocd.example.com." }))
+	assert.Contains(t, *sets, route53RecordSet{Name: "gitea.example.com.", Type: "A", TTL: 300, Records: []route53Record{{Value: "10.0.13.7"}}})
+}
@@ -0,0 +1,58 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+)
+
+// urlCredentials matches the user info of urls in free text
+var urlCredentials = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/\s@]+@`)
+
+// RedactSecrets replaces every secret in text, and the credentials of any
+// url it contains, with SecretPlaceholder so text can leave the machine
+func RedactSecrets(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			text = strings.ReplaceAll(text, secret, SecretPlaceholder)
+		}
+	}
+
+	return urlCredentials.ReplaceAllString(text, "${1}"+SecretPlaceholder+"@")
+}
+
+// ResolveSecret returns the secret referenced by value so secrets can be kept
+// out of shell history: env:NAME reads the environment variable NAME,
+// file:PATH reads the file at PATH and anything else is used as is
+func ResolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimPrefix(value, secretEnvPrefix)
+		secret, ok := os.LookupEnv(name)
+		if !ok || secret == "" {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, secretFilePrefix):
+		path := os.ExpandEnv(strings.TrimPrefix(value, secretFilePrefix))
+		secret, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %q: %w", path, err)
+		}
+		return strings.TrimSpace(string(secret)), nil
+	default:
+		return value, nil
+	}
+}
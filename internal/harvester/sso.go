@@ -0,0 +1,145 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// GitHubAPIURL and GitLabAPIURL are the APIs of the public instances
+	GitHubAPIURL = "https://api.github.com/"
+	GitLabAPIURL = "https://gitlab.com/api/v4/"
+
+	// githubSSOHeader is set by GitHub on responses to tokens that are not
+	// authorized for the SAML SSO of an organization, as
+	// "required; url=<authorization url>"
+	githubSSOHeader = "X-GitHub-SSO"
+)
+
+// SSOAuthorizationError reports a token that the SAML SSO enforced by a
+// GitHub organization or GitLab group has not authorized. Once the token is
+// authorized at URL the same command can be run again
+type SSOAuthorizationError struct {
+	Provider string
+	Owner    string
+	TokenEnv string
+	URL      string
+}
+
+func (e *SSOAuthorizationError) Error() string {
+	return fmt.Sprintf("%s enforces SAML SSO and %s is not authorized for it: authorize the token at %s, then run the same command again", e.Owner, e.TokenEnv, e.URL)
+}
+
+// CheckGitSSO runs CheckGitHubSSO or CheckGitLabSSO for the owner of the
+// gitops repository with gitProvider
+func CheckGitSSO(ctx context.Context, httpClient *http.Client, gitProvider, owner, token string) error {
+	switch gitProvider {
+	case "github":
+		return CheckGitHubSSO(ctx, httpClient, GitHubAPIURL, owner, token)
+	case "gitlab":
+		return CheckGitLabSSO(ctx, httpClient, GitLabAPIURL, owner, token)
+	default:
+		return nil
+	}
+}
+
+// CheckGitHubSSO requests the organization org with token and returns an
+// SSOAuthorizationError naming the authorization URL GitHub hands out when
+// the organization enforces SAML SSO the token was not authorized for.
+// GitHub answers those requests with a 404, which would otherwise read as a
+// missing organization. Other responses are left to the token validation
+func CheckGitHubSSO(ctx context.Context, httpClient *http.Client, apiURL, org, token string) error {
+	res, err := ssoProbe(ctx, httpClient, apiURL+"orgs/"+url.PathEscape(org), "Authorization", "Bearer "+token)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if authorizationURL, ok := githubSSOURL(res.Header.Get(githubSSOHeader)); ok {
+		return &SSOAuthorizationError{Provider: "github", Owner: fmt.Sprintf("GitHub organization %q", org), TokenEnv: "GITHUB_TOKEN", URL: authorizationURL}
+	}
+
+	return nil
+}
+
+// githubSSOURL extracts the authorization URL of an X-GitHub-SSO header
+func githubSSOURL(header string) (string, bool) {
+	directive, params, _ := strings.Cut(header, ";")
+	if strings.TrimSpace(directive) != "required" {
+		return "", false
+	}
+
+	for _, param := range strings.Split(params, ";") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(param), "url="); ok && value != "" {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// CheckGitLabSSO requests the group with token and returns an
+// SSOAuthorizationError naming the SSO sign-in URL of the group when it
+// refuses the token because its SAML SSO is enforced and the token owner
+// has no active SSO session. Other responses are left to the token
+// validation
+func CheckGitLabSSO(ctx context.Context, httpClient *http.Client, apiURL, group, token string) error {
+	res, err := ssoProbe(ctx, httpClient, apiURL+"groups/"+url.PathEscape(group), "PRIVATE-TOKEN", token)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden && res.StatusCode != http.StatusNotFound {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("failed to read gitlab response: %w", err)
+	}
+	var response struct {
+		Message interface{} `json:"message"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	message := strings.ToLower(fmt.Sprint(response.Message))
+	if !strings.Contains(message, "sso") && !strings.Contains(message, "saml") {
+		return nil
+	}
+
+	webURL := strings.TrimSuffix(strings.TrimSuffix(apiURL, "/"), "/api/v4")
+	return &SSOAuthorizationError{
+		Provider: "gitlab",
+		Owner:    fmt.Sprintf("GitLab group %q", group),
+		TokenEnv: "GITLAB_TOKEN",
+		URL:      fmt.Sprintf("%s/groups/%s/-/saml/sso", webURL, group),
+	}
+}
+
+func ssoProbe(ctx context.Context, httpClient *http.Client, endpoint, header, value string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build sso check request: %w", err)
+	}
+	req.Header.Set(header, value)
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check sso authorization: %w", err)
+	}
+
+	return res, nil
+}
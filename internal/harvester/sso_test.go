@@ -0,0 +1,66 @@
+package harvester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckGitHubSSO(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/orgs/holybits" && r.Header.Get("Authorization") == "Bearer unauthorized" {
+			w.Header().Set(githubSSOHeader, "required; url=https://github.com/orgs/holybits/sso?authorization_request=abc")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"login": "holybits"}`))
+	}))
+	defer server.Close()
+
+	require.NoError(t, CheckGitHubSSO(context.Background(), server.Client(), server.URL+"/", "holybits", "authorized"))
+
+	err := CheckGitHubSSO(context.Background(), server.Client(), server.URL+"/", "holybits", "unauthorized")
+	var ssoErr *SSOAuthorizationError
+	require.ErrorAs(t, err, &ssoErr)
+	assert.Equal(t, "https://github.com/orgs/holybits/sso?authorization_request=abc", ssoErr.URL)
+	assert.ErrorContains(t, err, "GITHUB_TOKEN is not authorized")
+}
+
+func TestGitHubSSOURL(t *testing.T) {
+	authorizationURL, ok := githubSSOURL("required; url=https://github.com/orgs/holybits/sso?authorization_request=abc")
+	assert.True(t, ok)
+	assert.Equal(t, "https://github.com/orgs/holybits/sso?authorization_request=abc", authorizationURL)
+
+	// listing endpoints only note the organizations they left out
+	_, ok = githubSSOURL("partial-results; organizations=21955855,20582480")
+	assert.False(t, ok)
+}
+
+func TestCheckGitLabSSO(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/groups/holybits%2Fplatform":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message": "403 Forbidden - Group SAML SSO session expired"}`))
+		case "/api/v4/groups/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "404 Group Not Found"}`))
+		default:
+			w.Write([]byte(`{"id": 1}`))
+		}
+	}))
+	defer server.Close()
+	apiURL := server.URL + "/api/v4/"
+
+	require.NoError(t, CheckGitLabSSO(context.Background(), server.Client(), apiURL, "holybits", "token"))
+	require.NoError(t, CheckGitLabSSO(context.Background(), server.Client(), apiURL, "missing", "token"))
+
+	err := CheckGitLabSSO(context.Background(), server.Client(), apiURL, "holybits/platform", "token")
+	var ssoErr *SSOAuthorizationError
+	require.ErrorAs(t, err, &ssoErr)
+	assert.Equal(t, server.URL+"/groups/holybits/platform/-/saml/sso", ssoErr.URL)
+}
@@ -0,0 +1,324 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// StorageClassCharts are the chart values setting the storage class of the
+// PVCs of the platform charts, by chart name
+var StorageClassCharts = map[string][][]string{
+	"argo-cd": {{"redis-ha", "persistentVolume", "storageClass"}},
+	"vault": {
+		{"server", "dataStorage", "storageClass"},
+		{"server", "auditStorage", "storageClass"},
+	},
+	"vcluster": {vclusterStorageClassValue},
+}
+
+// vclusterStorageClassValue is the chart value setting the storage class of
+// the vcluster syncer PVC
+var vclusterStorageClassValue = []string{"controlPlane", "statefulSet", "persistence", "volumeClaim", "storageClass"}
+
+// StorageClasses are the storage classes the platform PVCs are created
+// with: Default for the platform charts and the vclusters missing from
+// VClusters. An empty class keeps the default of the cluster
+type StorageClasses struct {
+	Default   string
+	VClusters map[string]string
+}
+
+// IsZero reports whether the storage classes keep the cluster default
+// everywhere
+func (s StorageClasses) IsZero() bool {
+	return s.Default == "" && len(s.VClusters) == 0
+}
+
+// VCluster returns the storage class of the syncer PVC of vcluster
+func (s StorageClasses) VCluster(vcluster string) string {
+	if class, ok := s.VClusters[vcluster]; ok {
+		return class
+	}
+
+	return s.Default
+}
+
+// Names returns the distinct storage classes set, sorted
+func (s StorageClasses) Names() []string {
+	var names []string
+	for _, class := range append([]string{s.Default}, mapValues(s.VClusters)...) {
+		if class != "" && !slices.Contains(names, class) {
+			names = append(names, class)
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// ValidateVClusterStorageClasses ensures every --vcluster-storage-class
+// entry references a vcluster that will actually be created
+func ValidateVClusterStorageClasses(vclusters []string, classes map[string]string) error {
+	for _, name := range sortedKeys(classes) {
+		if !slices.Contains(vclusters, name) {
+			return fmt.Errorf("vcluster storage class entry %q does not match any vcluster in --vclusters %v", name, vclusters)
+		}
+		if strings.TrimSpace(classes[name]) == "" {
+			return fmt.Errorf("vcluster storage class entry %q has an empty storage class", name)
+		}
+	}
+
+	return nil
+}
+
+// VerifyStorageClasses fails when a storage class of classes does not exist
+// in the cluster, listing the ones that do
+func (c *Client) VerifyStorageClasses(ctx context.Context, classes StorageClasses) error {
+	names := classes.Names()
+	if len(names) == 0 {
+		return nil
+	}
+
+	list, err := c.Clientset.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list storage classes: %w", err)
+	}
+
+	available := make([]string, 0, len(list.Items))
+	for _, class := range list.Items {
+		available = append(available, class.Name)
+	}
+	slices.Sort(available)
+
+	for _, name := range names {
+		if !slices.Contains(available, name) {
+			return fmt.Errorf("storage class %q does not exist in the cluster, available storage classes: %v", name, available)
+		}
+	}
+
+	return nil
+}
+
+// VClusterStorageValues adds the storage class of the syncer PVC to the
+// chart values of a vcluster
+func VClusterStorageValues(values []byte, class string) ([]byte, error) {
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(values, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse vcluster values: %w", err)
+	}
+
+	setValue(merged, class, vclusterStorageClassValue...)
+
+	rendered, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster values: %w", err)
+	}
+
+	return rendered, nil
+}
+
+// StorageClassFiles sets the storage classes in the helm values of the
+// ArgoCD applications of files, keyed by their path in the gitops
+// repository, deploying a chart of StorageClassCharts. vcluster
+// applications get the class of the vcluster they are named after. Only the
+// files that changed are returned
+func StorageClassFiles(files map[string][]byte, classes StorageClasses) (map[string][]byte, error) {
+	if classes.IsZero() {
+		return map[string][]byte{}, nil
+	}
+
+	return patchChartValues(files, func(application, chart string) []chartValue {
+		paths, ok := StorageClassCharts[chart]
+		if !ok {
+			return nil
+		}
+
+		class := classes.Default
+		if chart == "vcluster" {
+			class = classes.VCluster(application)
+		}
+		if class == "" {
+			return nil
+		}
+
+		values := make([]chartValue, 0, len(paths))
+		for _, path := range paths {
+			values = append(values, chartValue{path: path, value: class})
+		}
+		return values
+	})
+}
+
+// chartValue is a helm value to set in the sources of ArgoCD applications
+type chartValue struct {
+	path  []string
+	value interface{}
+}
+
+// patchChartValues sets in the helm values of the ArgoCD applications of
+// files, keyed by their path in the gitops repository, the values returned
+// for the application name and the chart of each of its sources. Values
+// embedded as a string are rewritten in place, other sources get
+// helm.valuesObject. Only the files that changed are returned
+func patchChartValues(files map[string][]byte, values func(application, chart string) []chartValue) (map[string][]byte, error) {
+	changed := map[string][]byte{}
+	for _, name := range sortedKeys(files) {
+		documents, err := decodeDocuments(files[name])
+		if err != nil {
+			// files that are not YAML are none of ours
+			continue
+		}
+
+		patched := false
+		for _, document := range documents {
+			ok, err := patchApplicationValues(document, values)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			patched = ok || patched
+		}
+		if !patched {
+			continue
+		}
+
+		content, err := encodeDocuments(documents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		changed[name] = content
+	}
+
+	return changed, nil
+}
+
+// patchApplicationValues sets the values of the sources of an ArgoCD
+// application, reporting whether it set any
+func patchApplicationValues(document map[string]interface{}, values func(application, chart string) []chartValue) (bool, error) {
+	if document["kind"] != "Application" {
+		return false, nil
+	}
+	spec, ok := document["spec"].(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+
+	sources := []interface{}{spec["source"]}
+	if multiple, ok := spec["sources"].([]interface{}); ok {
+		sources = multiple
+	}
+
+	name, _ := lookupValue(document, "metadata", "name").(string)
+	patched := false
+	for _, source := range sources {
+		source, ok := source.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		chart, _ := source["chart"].(string)
+		set := values(name, chart)
+		if len(set) == 0 {
+			continue
+		}
+
+		if err := setHelmValues(source, set); err != nil {
+			return false, fmt.Errorf("application %q: %w", name, err)
+		}
+		patched = true
+	}
+
+	return patched, nil
+}
+
+// setHelmValues sets values in the helm values of an ArgoCD application
+// source
+func setHelmValues(source map[string]interface{}, set []chartValue) error {
+	helm, ok := source["helm"].(map[string]interface{})
+	if !ok {
+		helm = map[string]interface{}{}
+		source["helm"] = helm
+	}
+
+	if embedded, ok := helm["values"].(string); ok {
+		values := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(embedded), &values); err != nil {
+			return fmt.Errorf("failed to parse helm values: %w", err)
+		}
+		for _, v := range set {
+			setValue(values, v.value, v.path...)
+		}
+		rendered, err := encodeDocuments([]map[string]interface{}{values})
+		if err != nil {
+			return fmt.Errorf("failed to render helm values: %w", err)
+		}
+		helm["values"] = string(rendered)
+		return nil
+	}
+
+	values, ok := helm["valuesObject"].(map[string]interface{})
+	if !ok {
+		values = map[string]interface{}{}
+		helm["valuesObject"] = values
+	}
+	for _, v := range set {
+		setValue(values, v.value, v.path...)
+	}
+
+	return nil
+}
+
+func decodeDocuments(content []byte) ([]map[string]interface{}, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	var documents []map[string]interface{}
+	for {
+		var document map[string]interface{}
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if document != nil {
+			documents = append(documents, document)
+		}
+	}
+}
+
+func encodeDocuments(documents []map[string]interface{}) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func mapValues(m map[string]string) []string {
+	values := make([]string, 0, len(m))
+	for _, key := range sortedKeys(m) {
+		values = append(values, m[key])
+	}
+
+	return values
+}
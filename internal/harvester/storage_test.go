@@ -0,0 +1,95 @@
+package harvester
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVerifyStorageClasses(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset(
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "longhorn"}},
+		&storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "harvester-longhorn"}},
+	)}
+	ctx := context.Background()
+
+	require.NoError(t, client.VerifyStorageClasses(ctx, StorageClasses{}))
+	require.NoError(t, client.VerifyStorageClasses(ctx, StorageClasses{Default: "longhorn", VClusters: map[string]string{"dev": "harvester-longhorn"}}))
+
+	err := client.VerifyStorageClasses(ctx, StorageClasses{Default: "longhorn", VClusters: map[string]string{"dev": "ceph"}})
+	require.ErrorContains(t, err, `storage class "ceph" does not exist in the cluster, available storage classes: [harvester-longhorn longhorn]`)
+}
+
+func TestValidateVClusterStorageClasses(t *testing.T) {
+	require.NoError(t, ValidateVClusterStorageClasses([]string{"dev", "prod"}, map[string]string{"dev": "ceph"}))
+	require.ErrorContains(t, ValidateVClusterStorageClasses([]string{"dev"}, map[string]string{"qa": "ceph"}), `entry "qa" does not match any vcluster`)
+	require.ErrorContains(t, ValidateVClusterStorageClasses([]string{"dev"}, map[string]string{"dev": " "}), "empty storage class")
+}
+
+func TestStorageClassFiles(t *testing.T) {
+	vault := strings.Join([]string{
+		"apiVersion: argoproj.io/v1alpha1",
+		"kind: Application",
+		"metadata:",
+		"  name: vault",
+		"spec:",
+		"  source:",
+		"    chart: vault",
+		"    helm:",
+		"      values: |",
+		"        server:",
+		"          dataStorage:",
+		"            size: 10Gi",
+		"",
+	}, "\n")
+	vclusters := strings.Join([]string{
+		"kind: Application",
+		"metadata:",
+		"  name: dev",
+		"spec:",
+		"  source:",
+		"    chart: vcluster",
+		"---",
+		"kind: Application",
+		"metadata:",
+		"  name: prod",
+		"spec:",
+		"  sources:",
+		"  - chart: vcluster",
+		"",
+	}, "\n")
+	files := map[string][]byte{
+		"registry/kubefirst/vault.yaml":        []byte(vault),
+		"registry/kubefirst/vclusters.yaml":    []byte(vclusters),
+		"registry/kubefirst/cert-manager.yaml": []byte("kind: Application\nspec:\n  source:\n    chart: cert-manager\n"),
+	}
+
+	changed, err := StorageClassFiles(files, StorageClasses{Default: "longhorn", VClusters: map[string]string{"prod": "ceph"}})
+	require.NoError(t, err)
+	require.Len(t, changed, 2)
+	assert.Contains(t, string(changed["registry/kubefirst/vault.yaml"]), "            size: 10Gi\n            storageClass: longhorn\n")
+	assert.Contains(t, string(changed["registry/kubefirst/vault.yaml"]), "          auditStorage:\n            storageClass: longhorn\n")
+
+	documents, err := decodeDocuments(changed["registry/kubefirst/vclusters.yaml"])
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	assert.Equal(t, "longhorn", lookupValue(documents[0], append([]string{"spec", "source", "helm", "valuesObject"}, vclusterStorageClassValue...)...))
+	prod := documents[1]["spec"].(map[string]interface{})["sources"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "ceph", lookupValue(prod, append([]string{"helm", "valuesObject"}, vclusterStorageClassValue...)...))
+
+	changed, err = StorageClassFiles(files, StorageClasses{})
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+}
+
+func TestVClusterStorageValues(t *testing.T) {
+	values, err := VClusterStorageValues([]byte("controlPlane:\n  distro:\n    k8s:\n      enabled: true\n"), "ceph")
+	require.NoError(t, err)
+	assert.Equal(t, "controlPlane:\n    distro:\n        k8s:\n            enabled: true\n    statefulSet:\n        persistence:\n            volumeClaim:\n                storageClass: ceph\n", string(values))
+}
@@ -0,0 +1,183 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/konstructio/kubefirst/internal/step"
+)
+
+// SummaryOptions describes the cluster a ProvisionSummary is written for
+type SummaryOptions struct {
+	ClusterName     string
+	GitProvider     string
+	GitOwner        string
+	KubeconfigPath  string
+	Vault           bool
+	VaultAutoUnseal string
+}
+
+// SummaryApplication is the state of an ArgoCD application when the summary
+// was written
+type SummaryApplication struct {
+	Name   string `json:"name"`
+	Health string `json:"health"`
+	Sync   string `json:"sync"`
+}
+
+// ProvisionSummary is the outcome of a create run as written to
+// --summary-file. It is written whether the run succeeded or not, StoppedAt
+// naming the phase that failed
+type ProvisionSummary struct {
+	ClusterName  string               `json:"clusterName"`
+	GitProvider  string               `json:"gitProvider"`
+	GitOwner     string               `json:"gitOwner"`
+	Succeeded    bool                 `json:"succeeded"`
+	StoppedAt    string               `json:"stoppedAt,omitempty"`
+	Error        string               `json:"error,omitempty"`
+	StartedAt    time.Time            `json:"startedAt"`
+	FinishedAt   time.Time            `json:"finishedAt"`
+	Phases       []UsagePhase         `json:"phases"`
+	VClusters    []string             `json:"vclusters"`
+	Applications []SummaryApplication `json:"applications"`
+	Credentials  []ReportCredential   `json:"credentials"`
+	QueryError   string               `json:"queryError,omitempty"`
+}
+
+// NewProvisionSummary summarizes a run from startedAt until now that ended
+// with runErr
+func NewProvisionSummary(opts SummaryOptions, startedAt time.Time, phases []PhaseRecord, runErr error) *ProvisionSummary {
+	summary := &ProvisionSummary{
+		ClusterName:  opts.ClusterName,
+		GitProvider:  opts.GitProvider,
+		GitOwner:     opts.GitOwner,
+		Succeeded:    runErr == nil,
+		StartedAt:    startedAt.UTC(),
+		FinishedAt:   time.Now().UTC(),
+		Phases:       []UsagePhase{},
+		VClusters:    []string{},
+		Applications: []SummaryApplication{},
+		Credentials:  reportCredentials(opts.KubeconfigPath, opts.Vault, opts.VaultAutoUnseal),
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+
+	for _, phase := range phases {
+		summary.Phases = append(summary.Phases, UsagePhase{
+			Name:            phase.Name,
+			Status:          string(phase.Status),
+			DurationSeconds: phase.Duration.Round(time.Second).Seconds(),
+		})
+		if phase.Status == step.StatusFailed {
+			summary.StoppedAt = phase.Name
+		}
+	}
+
+	return summary
+}
+
+// QuerySummary fills in the vclusters whose host namespace exists and the
+// state of the ArgoCD applications of summary from the live cluster
+func (c *Client) QuerySummary(ctx context.Context, summary *ProvisionSummary, vclusters []string) error {
+	described, err := c.DescribeVClusters(ctx, vclusters, "", nil)
+	if err != nil {
+		return err
+	}
+	for _, vcluster := range described {
+		if vcluster.Present {
+			summary.VClusters = append(summary.VClusters, vcluster.Name)
+		}
+	}
+
+	apps, err := c.ListApplications(ctx, "*")
+	if err != nil && !errors.Is(err, ErrApplicationNotFound) {
+		return err
+	}
+	for _, app := range apps {
+		summary.Applications = append(summary.Applications, SummaryApplication{
+			Name:   app.Name,
+			Health: string(app.Status.Health.Status),
+			Sync:   string(app.Status.Sync.Status),
+		})
+	}
+
+	return nil
+}
+
+// Markdown renders the summary as a Markdown document
+func (s *ProvisionSummary) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# kubefirst provisioning summary: %s\n\n", s.ClusterName)
+	switch {
+	case s.Succeeded:
+		b.WriteString("Provisioning succeeded.\n\n")
+	case s.StoppedAt != "":
+		fmt.Fprintf(&b, "Provisioning failed at %s: %s\n\n", s.StoppedAt, s.Error)
+	default:
+		fmt.Fprintf(&b, "Provisioning failed: %s\n\n", s.Error)
+	}
+	fmt.Fprintf(&b, "Started %s, finished %s\n\n", s.StartedAt.Format(time.RFC3339), s.FinishedAt.Format(time.RFC3339))
+	fmt.Fprintf(&b, "Git provider: %s, owner: %s\n\n", s.GitProvider, s.GitOwner)
+
+	b.WriteString("## Phases\n\n| Phase | Status | Duration |\n| --- | --- | --- |\n")
+	for _, phase := range s.Phases {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", phase.Name, phase.Status, formatSeconds(phase.DurationSeconds))
+	}
+
+	b.WriteString("\n## vClusters\n\n")
+	for _, vcluster := range s.VClusters {
+		fmt.Fprintf(&b, "- %s\n", vcluster)
+	}
+
+	b.WriteString("\n## ArgoCD applications\n\n| Application | Health | Sync |\n| --- | --- | --- |\n")
+	for _, app := range s.Applications {
+		fmt.Fprintf(&b, "| %s | %s | %s |\n", app.Name, app.Health, app.Sync)
+	}
+	if s.QueryError != "" {
+		fmt.Fprintf(&b, "\nThe cluster could not be queried: %s\n", s.QueryError)
+	}
+
+	b.WriteString("\n## Credentials\n\n")
+	for _, credential := range s.Credentials {
+		fmt.Fprintf(&b, "%s:\n\n```sh\n%s\n```\n\n", credential.Name, credential.Command)
+	}
+
+	return b.String()
+}
+
+// WriteProvisionSummary writes summary to path, as Markdown when path ends
+// in .md and JSON otherwise
+func WriteProvisionSummary(path string, summary *ProvisionSummary) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory of summary file %q: %w", path, err)
+	}
+
+	data := []byte(summary.Markdown())
+	if !strings.EqualFold(filepath.Ext(path), ".md") {
+		rendered, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to render provisioning summary: %w", err)
+		}
+		data = append(rendered, '\n')
+	}
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write summary file %q: %w", path, err)
+	}
+
+	return nil
+}
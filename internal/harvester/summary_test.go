@@ -0,0 +1,55 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestProvisionSummary(t *testing.T) {
+	client := &Client{
+		Clientset: fake.NewClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: VClusterNamespace("dev")}}),
+		ArgoCD:    argocdfake.NewSimpleClientset(newApplication("vault", v1alpha1.SyncStatusCodeSynced, health.HealthStatusProgressing)),
+	}
+
+	phases := []PhaseRecord{
+		{Name: "Install ArgoCD", Status: step.StatusComplete, Duration: 90 * time.Second},
+		{Name: "Install Vault", Status: step.StatusFailed, Duration: 10 * time.Minute},
+	}
+	summary := NewProvisionSummary(SummaryOptions{ClusterName: "kubefirst", GitProvider: "github", GitOwner: "org", Vault: true}, time.Now(), phases, errors.New("vault never became ready"))
+	require.NoError(t, client.QuerySummary(context.Background(), summary, []string{"ml", "dev"}))
+
+	assert.False(t, summary.Succeeded)
+	assert.Equal(t, "Install Vault", summary.StoppedAt)
+	assert.Equal(t, []string{"dev"}, summary.VClusters)
+	assert.Equal(t, []SummaryApplication{{Name: "vault", Health: "Progressing", Sync: "Synced"}}, summary.Applications)
+	assert.Equal(t, []string{"ArgoCD admin password", "Vault root token"}, []string{summary.Credentials[0].Name, summary.Credentials[1].Name})
+
+	dir := t.TempDir()
+	require.NoError(t, WriteProvisionSummary(filepath.Join(dir, "summary.json"), summary))
+	data, err := os.ReadFile(filepath.Join(dir, "summary.json"))
+	require.NoError(t, err)
+	var written ProvisionSummary
+	require.NoError(t, json.Unmarshal(data, &written))
+	assert.Equal(t, summary.Phases, written.Phases)
+
+	require.NoError(t, WriteProvisionSummary(filepath.Join(dir, "summary.md"), summary))
+	data, err = os.ReadFile(filepath.Join(dir, "summary.md"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Provisioning failed at Install Vault: vault never became ready")
+	assert.Contains(t, string(data), "| Install ArgoCD | complete | 1m30s |")
+}
@@ -0,0 +1,615 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// DefaultFinalizerTimeout is how long an object may stay deleting during
+	// destroy before the finalizer watchdog reports it
+	DefaultFinalizerTimeout = 5 * time.Minute
+
+	argoCDResourcesFinalizer = "resources-finalizer.argocd.argoproj.io"
+	argoCDTrackingAnnotation = "argocd.argoproj.io/tracking-id"
+	argoCDInstanceLabel      = "app.kubernetes.io/instance"
+)
+
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// finalizerControllers names the controller expected to clear the
+// finalizers destroy commonly waits on
+var finalizerControllers = map[string]string{
+	argoCDResourcesFinalizer:                      "the ArgoCD application controller once it pruned the resources of the application",
+	string(corev1.FinalizerKubernetes):            "the namespace controller once every object in the namespace is deleted",
+	"kubernetes.io/pvc-protection":                "the kube-controller-manager once no pod uses the claim",
+	"kubernetes.io/pv-protection":                 "the kube-controller-manager once the volume is released",
+	"service.kubernetes.io/load-balancer-cleanup": "the load balancer controller once it released the address",
+	"customresourcecleanup.apiextensions.k8s.io":  "the apiextensions controller once every custom resource of the definition is deleted",
+	metav1.FinalizerDeleteDependents:              "the garbage collector once the dependents are deleted",
+	metav1.FinalizerOrphanDependents:              "the garbage collector once the dependents are orphaned",
+}
+
+// FinalizerController names the controller expected to clear finalizer
+func FinalizerController(finalizer string) string {
+	if controller, ok := finalizerControllers[finalizer]; ok {
+		return controller
+	}
+	if domain, _, ok := strings.Cut(finalizer, "/"); ok {
+		return fmt.Sprintf("the controller of %s", domain)
+	}
+
+	return "the controller that added it"
+}
+
+// KubefirstFinalizer reports whether finalizer on an object of kind is one
+// kubefirst added, the only finalizers --force-finalizers strips: the
+// ArgoCD resources finalizer of its applications and its own
+func KubefirstFinalizer(kind, finalizer string) bool {
+	if kind == "Application" && finalizer == argoCDResourcesFinalizer {
+		return true
+	}
+	domain, _, _ := strings.Cut(finalizer, "/")
+
+	return domain == "kubefirst.io" || strings.HasSuffix(domain, ".kubefirst.io")
+}
+
+// TeardownScope is what destroy removes: the ArgoCD applications on the
+// cluster and the namespaces they deploy into, ArgoCD's own last. Owners
+// are only set for the scope of a single phase
+type TeardownScope struct {
+	Applications []string
+	Namespaces   []string
+	Owners       []string
+	// ApplicationSets generate applications of the scope and are deleted
+	// first, they would recreate them otherwise
+	ApplicationSets []string
+}
+
+// TeardownScope lists the applications and namespaces destroy removes.
+// Namespaces Kubernetes, Harvester and the kubefirst history live in are
+// never part of it
+func (c *Client) TeardownScope(ctx context.Context) (TeardownScope, error) {
+	apps, err := c.ListApplications(ctx, "*")
+	if err != nil && !errors.Is(err, ErrApplicationNotFound) {
+		return TeardownScope{}, err
+	}
+
+	var scope TeardownScope
+	seen := map[string]bool{ArgoCDNamespace: true}
+	for _, app := range apps {
+		scope.Applications = append(scope.Applications, app.Name)
+		if appSet := generatingAppSet(&app); appSet != "" && !slices.Contains(scope.ApplicationSets, appSet) {
+			scope.ApplicationSets = append(scope.ApplicationSets, appSet)
+		}
+
+		namespace := app.Spec.Destination.Namespace
+		if seen[namespace] || protectedNamespace(namespace) {
+			continue
+		}
+		seen[namespace] = true
+		scope.Namespaces = append(scope.Namespaces, namespace)
+	}
+	sort.Strings(scope.Namespaces)
+	scope.Namespaces = append(scope.Namespaces, ArgoCDNamespace)
+
+	return scope, nil
+}
+
+func protectedNamespace(namespace string) bool {
+	switch {
+	case namespace == "", namespace == "default", namespace == GitopsHistorySecretNamespace, namespace == "longhorn-system":
+		return true
+	case strings.HasPrefix(namespace, "kube-"), strings.HasPrefix(namespace, "harvester-"), strings.HasPrefix(namespace, "cattle-"):
+		return true
+	default:
+		return false
+	}
+}
+
+// FinalizerWatchdog reports, once, every object destroy waits on that
+// stays deleting for longer than Timeout, naming its finalizers and the
+// controller expected to clear each. With Force it then strips the
+// finalizers kubefirst added, never third-party ones
+type FinalizerWatchdog struct {
+	Timeout time.Duration
+	Force   bool
+	OnStuck func(message string)
+
+	now      func() time.Time
+	reported map[string]bool
+}
+
+// NewFinalizerWatchdog returns a FinalizerWatchdog calling onStuck for
+// every object deleting for longer than timeout
+func NewFinalizerWatchdog(timeout time.Duration, force bool, onStuck func(message string)) *FinalizerWatchdog {
+	return &FinalizerWatchdog{
+		Timeout:  timeout,
+		Force:    force,
+		OnStuck:  onStuck,
+		now:      time.Now,
+		reported: map[string]bool{},
+	}
+}
+
+// deletingObject is an object destroy waits on. finalizers holds every
+// finalizer blocking it, including the spec finalizers of namespaces, and
+// patch applies a merge patch to the object
+type deletingObject struct {
+	kind       string
+	object     metav1.Object
+	finalizers []string
+	detail     string
+	patch      func(ctx context.Context, patch []byte) error
+}
+
+func newDeletingObject(kind string, object metav1.Object, patch func(ctx context.Context, patch []byte) error) deletingObject {
+	return deletingObject{kind: kind, object: object, finalizers: object.GetFinalizers(), patch: patch}
+}
+
+func (o deletingObject) String() string {
+	if o.object.GetNamespace() == "" {
+		return fmt.Sprintf("%s %s", o.kind, o.object.GetName())
+	}
+
+	return fmt.Sprintf("%s %s/%s", o.kind, o.object.GetNamespace(), o.object.GetName())
+}
+
+// StuckMessage describes object stuck deleting for age on finalizers
+func StuckMessage(object string, age time.Duration, finalizers []string) string {
+	reasons := make([]string, 0, len(finalizers))
+	for _, finalizer := range finalizers {
+		reasons = append(reasons, fmt.Sprintf("finalizer %s is cleared by %s", finalizer, FinalizerController(finalizer)))
+	}
+
+	return fmt.Sprintf("%s is stuck deleting for %s: %s", object, age.Round(time.Second), strings.Join(reasons, "; "))
+}
+
+func (w *FinalizerWatchdog) observe(ctx context.Context, o deletingObject) error {
+	deleted := o.object.GetDeletionTimestamp()
+	if deleted == nil || len(o.finalizers) == 0 {
+		return nil
+	}
+	age := w.now().Sub(deleted.Time)
+	if age < w.Timeout {
+		return nil
+	}
+
+	if key := o.String(); !w.reported[key] {
+		w.reported[key] = true
+		if w.OnStuck != nil {
+			message := StuckMessage(key, age, o.finalizers)
+			if o.detail != "" {
+				message += ", " + o.detail
+			}
+			w.OnStuck(message)
+		}
+	}
+
+	if !w.Force || o.patch == nil {
+		return nil
+	}
+
+	var kept []string
+	for _, finalizer := range o.object.GetFinalizers() {
+		if !KubefirstFinalizer(o.kind, finalizer) {
+			kept = append(kept, finalizer)
+		}
+	}
+	if len(kept) == len(o.object.GetFinalizers()) {
+		return nil
+	}
+
+	return o.patch(ctx, finalizersPatch(kept))
+}
+
+// finalizersPatch replaces the metadata finalizers of an object with
+// finalizers, removing them when it is empty
+func finalizersPatch(finalizers []string) []byte {
+	patch, _ := json.Marshal(map[string]any{"metadata": map[string]any{"finalizers": finalizers}})
+	return patch
+}
+
+// waitDeleted polls list until it returns no object, passing every object
+// still there to the watchdog
+func (w *FinalizerWatchdog) waitDeleted(ctx context.Context, what string, list func(ctx context.Context) ([]deletingObject, error)) error {
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	var remaining []deletingObject
+	for {
+		current, err := list(ctx)
+		switch {
+		case err == nil:
+			remaining = current
+			if len(remaining) == 0 {
+				return nil
+			}
+			for _, o := range remaining {
+				if err := w.observe(ctx, o); err != nil && !apierrors.IsNotFound(err) {
+					return fmt.Errorf("failed to strip the finalizers of %s: %w", o, err)
+				}
+			}
+		case ctx.Err() == nil:
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to be deleted, remaining: %s: %w", what, describeRemaining(remaining), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func describeRemaining(remaining []deletingObject) string {
+	described := make([]string, 0, len(remaining))
+	for _, o := range remaining {
+		description := o.String()
+		if len(o.finalizers) > 0 {
+			description += fmt.Sprintf(" (finalizers %s)", strings.Join(o.finalizers, ", "))
+		}
+		described = append(described, description)
+	}
+	sort.Strings(described)
+
+	return strings.Join(described, "; ")
+}
+
+// DeleteApplications deletes the applications of scope. Applications
+// deploying elsewhere prune their resources through the ArgoCD resources
+// finalizer, while the ones deploying into the ArgoCD namespace, ArgoCD's
+// own and the app-of-apps, are deleted first and without it, so neither
+// ArgoCD nor the child applications are pruned out from under destroy
+func (c *Client) DeleteApplications(ctx context.Context, scope TeardownScope, w *FinalizerWatchdog) error {
+	apps := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+
+	for _, name := range scope.ApplicationSets {
+		err := c.ArgoCD.ArgoprojV1alpha1().ApplicationSets(ArgoCDNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete applicationset %q: %w", name, err)
+		}
+	}
+
+	var cascading []v1alpha1.Application
+	for _, name := range scope.Applications {
+		app, err := apps.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			if isConnectionError(err) {
+				return fmt.Errorf("%w: %w", ErrArgoCDUnreachable, err)
+			}
+			return fmt.Errorf("failed to get application %q: %w", name, err)
+		}
+
+		if app.Spec.Destination.Namespace != ArgoCDNamespace {
+			cascading = append(cascading, *app)
+			continue
+		}
+		if err := c.deleteApplication(ctx, app, false); err != nil {
+			return err
+		}
+	}
+
+	for i := range cascading {
+		if err := c.deleteApplication(ctx, &cascading[i], true); err != nil {
+			return err
+		}
+	}
+
+	return w.waitDeleted(ctx, "argocd applications", func(ctx context.Context) ([]deletingObject, error) {
+		var remaining []deletingObject
+		for _, name := range scope.Applications {
+			app, err := apps.Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get application %q: %w", name, err)
+			}
+			remaining = append(remaining, newDeletingObject("Application", app, func(ctx context.Context, patch []byte) error {
+				_, err := apps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+				return err
+			}))
+		}
+		return remaining, nil
+	})
+}
+
+// deleteApplication deletes app, setting or clearing the ArgoCD resources
+// finalizer first depending on whether its resources are pruned
+func (c *Client) deleteApplication(ctx context.Context, app *v1alpha1.Application, cascade bool) error {
+	apps := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+
+	finalizers := slices.DeleteFunc(slices.Clone(app.Finalizers), func(finalizer string) bool {
+		return finalizer == argoCDResourcesFinalizer
+	})
+	if cascade {
+		finalizers = append(finalizers, argoCDResourcesFinalizer)
+	}
+	if !slices.Equal(finalizers, app.Finalizers) {
+		if _, err := apps.Patch(ctx, app.Name, types.MergePatchType, finalizersPatch(finalizers), metav1.PatchOptions{FieldManager: fieldManager}); err != nil {
+			return fmt.Errorf("failed to set the finalizers of application %q: %w", app.Name, err)
+		}
+	}
+
+	if err := apps.Delete(ctx, app.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete application %q: %w", app.Name, err)
+	}
+
+	return nil
+}
+
+// WaitForWorkloadDeletion waits until the pods and volume claims pruning
+// left terminating in the namespaces of scope are gone
+func (c *Client) WaitForWorkloadDeletion(ctx context.Context, scope TeardownScope, w *FinalizerWatchdog) error {
+	core := c.Clientset.CoreV1()
+
+	return w.waitDeleted(ctx, "workloads", func(ctx context.Context) ([]deletingObject, error) {
+		var remaining []deletingObject
+		for _, namespace := range scope.Namespaces {
+			pods, err := core.Pods(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list pods in %q: %w", namespace, err)
+			}
+			for i := range pods.Items {
+				pod := &pods.Items[i]
+				if pod.DeletionTimestamp == nil {
+					continue
+				}
+				remaining = append(remaining, newDeletingObject("Pod", pod, func(ctx context.Context, patch []byte) error {
+					_, err := core.Pods(pod.Namespace).Patch(ctx, pod.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+					return err
+				}))
+			}
+
+			claims, err := core.PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list persistent volume claims in %q: %w", namespace, err)
+			}
+			for i := range claims.Items {
+				claim := &claims.Items[i]
+				if claim.DeletionTimestamp == nil {
+					continue
+				}
+				remaining = append(remaining, newDeletingObject("PersistentVolumeClaim", claim, func(ctx context.Context, patch []byte) error {
+					_, err := core.PersistentVolumeClaims(claim.Namespace).Patch(ctx, claim.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+					return err
+				}))
+			}
+		}
+		return remaining, nil
+	})
+}
+
+// ReleaseLoadBalancers deletes the LoadBalancer services in the namespaces
+// of scope and waits until they are gone. It then confirms no service
+// holds an address of pools any longer, unless pools is nil
+func (c *Client) ReleaseLoadBalancers(ctx context.Context, scope TeardownScope, pools LBPools, w *FinalizerWatchdog) error {
+	core := c.Clientset.CoreV1()
+
+	list := func(ctx context.Context) ([]deletingObject, error) {
+		var remaining []deletingObject
+		for _, namespace := range scope.Namespaces {
+			services, err := core.Services(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("failed to list services in %q: %w", namespace, err)
+			}
+			for i := range services.Items {
+				service := &services.Items[i]
+				if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+					continue
+				}
+				remaining = append(remaining, newDeletingObject("Service", service, func(ctx context.Context, patch []byte) error {
+					_, err := core.Services(service.Namespace).Patch(ctx, service.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+					return err
+				}))
+			}
+		}
+		return remaining, nil
+	}
+
+	services, err := list(ctx)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		if err := core.Services(service.object.GetNamespace()).Delete(ctx, service.object.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %w", service, err)
+		}
+	}
+
+	if err := w.waitDeleted(ctx, "load balancer services", list); err != nil {
+		return err
+	}
+
+	if pools == nil {
+		return nil
+	}
+
+	held, err := c.heldAddresses(ctx, pools)
+	if err != nil {
+		return err
+	}
+	if len(held) > 0 {
+		return fmt.Errorf("addresses of %s are still held by services outside of kubefirst: %s", pools, strings.Join(held, "; "))
+	}
+
+	return nil
+}
+
+func (c *Client) heldAddresses(ctx context.Context, pools LBPools) ([]string, error) {
+	services, err := c.Clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	var held []string
+	for _, service := range services.Items {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if addr, err := netip.ParseAddr(ingress.IP); err == nil && pools.Contains(addr) {
+				held = append(held, fmt.Sprintf("service %s/%s holds %s", service.Namespace, service.Name, ingress.IP))
+			}
+		}
+	}
+	sort.Strings(held)
+
+	return held, nil
+}
+
+// DeleteOwnedResources deletes the admission webhooks and custom resource
+// definitions the applications of scope deployed and ArgoCD did not prune,
+// as recorded by its tracking annotation or instance label
+func (c *Client) DeleteOwnedResources(ctx context.Context, scope TeardownScope, w *FinalizerWatchdog) error {
+	admission := c.Clientset.AdmissionregistrationV1()
+	crds := c.Dynamic.Resource(crdResource)
+
+	list := func(ctx context.Context) ([]deletingObject, error) {
+		var owned []deletingObject
+
+		validating, err := admission.ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list validating webhook configurations: %w", err)
+		}
+		for i := range validating.Items {
+			webhook := &validating.Items[i]
+			if ownedBy(webhook, scope.Applications) {
+				owned = append(owned, newDeletingObject("ValidatingWebhookConfiguration", webhook, func(ctx context.Context, patch []byte) error {
+					_, err := admission.ValidatingWebhookConfigurations().Patch(ctx, webhook.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+					return err
+				}))
+			}
+		}
+
+		mutating, err := admission.MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list mutating webhook configurations: %w", err)
+		}
+		for i := range mutating.Items {
+			webhook := &mutating.Items[i]
+			if ownedBy(webhook, scope.Applications) {
+				owned = append(owned, newDeletingObject("MutatingWebhookConfiguration", webhook, func(ctx context.Context, patch []byte) error {
+					_, err := admission.MutatingWebhookConfigurations().Patch(ctx, webhook.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+					return err
+				}))
+			}
+		}
+
+		definitions, err := crds.List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list custom resource definitions: %w", err)
+		}
+		for i := range definitions.Items {
+			crd := &definitions.Items[i]
+			if ownedBy(crd, scope.Applications) {
+				owned = append(owned, newDeletingObject("CustomResourceDefinition", crd, func(ctx context.Context, patch []byte) error {
+					_, err := crds.Patch(ctx, crd.GetName(), types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+					return err
+				}))
+			}
+		}
+
+		return owned, nil
+	}
+
+	owned, err := list(ctx)
+	if err != nil {
+		return err
+	}
+	for _, o := range owned {
+		var err error
+		switch o.kind {
+		case "ValidatingWebhookConfiguration":
+			err = admission.ValidatingWebhookConfigurations().Delete(ctx, o.object.GetName(), metav1.DeleteOptions{})
+		case "MutatingWebhookConfiguration":
+			err = admission.MutatingWebhookConfigurations().Delete(ctx, o.object.GetName(), metav1.DeleteOptions{})
+		default:
+			err = crds.Delete(ctx, o.object.GetName(), metav1.DeleteOptions{})
+		}
+		if err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %w", o, err)
+		}
+	}
+
+	return w.waitDeleted(ctx, "webhooks and custom resource definitions", list)
+}
+
+// ownedBy reports whether object was deployed by one of apps
+func ownedBy(object metav1.Object, apps []string) bool {
+	owner := applicationOwner(object)
+	return owner != "" && slices.Contains(apps, owner)
+}
+
+// DeleteNamespaces deletes the namespaces of scope and waits until they are
+// gone, reporting the content a stuck namespace still waits on
+func (c *Client) DeleteNamespaces(ctx context.Context, scope TeardownScope, w *FinalizerWatchdog) error {
+	namespaces := c.Clientset.CoreV1().Namespaces()
+
+	for _, name := range scope.Namespaces {
+		if err := namespaces.Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete namespace %q: %w", name, err)
+		}
+	}
+
+	return w.waitDeleted(ctx, "namespaces", func(ctx context.Context) ([]deletingObject, error) {
+		var remaining []deletingObject
+		for _, name := range scope.Namespaces {
+			namespace, err := namespaces.Get(ctx, name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get namespace %q: %w", name, err)
+			}
+
+			o := newDeletingObject("Namespace", namespace, func(ctx context.Context, patch []byte) error {
+				_, err := namespaces.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+				return err
+			})
+			for _, finalizer := range namespace.Spec.Finalizers {
+				o.finalizers = append(o.finalizers, string(finalizer))
+			}
+			o.detail = namespaceContentRemaining(namespace)
+			remaining = append(remaining, o)
+		}
+		return remaining, nil
+	})
+}
+
+// namespaceContentRemaining returns what the namespace controller reports
+// it still waits on before namespace is gone
+func namespaceContentRemaining(namespace *corev1.Namespace) string {
+	var messages []string
+	for _, condition := range namespace.Status.Conditions {
+		switch condition.Type {
+		case corev1.NamespaceContentRemaining, corev1.NamespaceFinalizersRemaining:
+			if condition.Status == corev1.ConditionTrue {
+				messages = append(messages, condition.Message)
+			}
+		}
+	}
+
+	return strings.Join(messages, ", ")
+}
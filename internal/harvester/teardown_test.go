@@ -0,0 +1,241 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newDestinationApplication(name, namespace string, finalizers ...string) *v1alpha1.Application {
+	return &v1alpha1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ArgoCDNamespace, Finalizers: finalizers},
+		Spec:       v1alpha1.ApplicationSpec{Destination: v1alpha1.ApplicationDestination{Namespace: namespace}},
+	}
+}
+
+func TestTeardownScope(t *testing.T) {
+	client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+		newDestinationApplication("registry", ArgoCDNamespace),
+		newDestinationApplication("vault", "vault"),
+		newDestinationApplication("kgateway", WildcardGatewayNamespace),
+		newDestinationApplication("kgateway-crds", WildcardGatewayNamespace),
+		newDestinationApplication("metrics", "kube-system"),
+	)}
+
+	scope, err := client.TeardownScope(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kgateway", "kgateway-crds", "metrics", "registry", "vault"}, scope.Applications)
+	assert.Equal(t, []string{WildcardGatewayNamespace, "vault", ArgoCDNamespace}, scope.Namespaces)
+}
+
+func TestDeleteApplications(t *testing.T) {
+	argocd := argocdfake.NewSimpleClientset(
+		newDestinationApplication("vault", "vault"),
+		newDestinationApplication("registry", ArgoCDNamespace, argoCDResourcesFinalizer),
+	)
+	client := &Client{ArgoCD: argocd}
+	scope := TeardownScope{Applications: []string{"vault", "registry"}}
+
+	require.NoError(t, client.DeleteApplications(context.Background(), scope, NewFinalizerWatchdog(DefaultFinalizerTimeout, false, nil)))
+
+	var actions []string
+	for _, action := range argocd.Actions() {
+		switch action := action.(type) {
+		case k8stesting.PatchAction:
+			actions = append(actions, fmt.Sprintf("patch %s %s", action.GetName(), action.GetPatch()))
+		case k8stesting.DeleteAction:
+			actions = append(actions, "delete "+action.GetName())
+		}
+	}
+	assert.Equal(t, []string{
+		`patch registry {"metadata":{"finalizers":[]}}`,
+		"delete registry",
+		`patch vault {"metadata":{"finalizers":["resources-finalizer.argocd.argoproj.io"]}}`,
+		"delete vault",
+	}, actions)
+}
+
+func TestFinalizerWatchdog(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deleted := metav1.NewTime(now.Add(-6 * time.Minute))
+	claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+		Name:              "data-vault-0",
+		Namespace:         "vault",
+		DeletionTimestamp: &deleted,
+		Finalizers:        []string{"kubernetes.io/pvc-protection"},
+	}}
+	app := &v1alpha1.Application{ObjectMeta: metav1.ObjectMeta{
+		Name:              "vault",
+		Namespace:         ArgoCDNamespace,
+		DeletionTimestamp: &deleted,
+		Finalizers:        []string{argoCDResourcesFinalizer, "example.com/cleanup"},
+	}}
+
+	t.Run("reports each stuck object once", func(t *testing.T) {
+		var messages []string
+		watchdog := NewFinalizerWatchdog(5*time.Minute, false, func(message string) { messages = append(messages, message) })
+		watchdog.now = func() time.Time { return now }
+
+		o := newDeletingObject("PersistentVolumeClaim", claim, nil)
+		require.NoError(t, watchdog.observe(context.Background(), o))
+		require.NoError(t, watchdog.observe(context.Background(), o))
+
+		require.Len(t, messages, 1)
+		assert.Equal(t, "PersistentVolumeClaim vault/data-vault-0 is stuck deleting for 6m0s: finalizer kubernetes.io/pvc-protection is cleared by the kube-controller-manager once no pod uses the claim", messages[0])
+	})
+
+	t.Run("waits for the timeout", func(t *testing.T) {
+		var messages []string
+		watchdog := NewFinalizerWatchdog(10*time.Minute, true, func(message string) { messages = append(messages, message) })
+		watchdog.now = func() time.Time { return now }
+
+		o := newDeletingObject("Application", app, func(context.Context, []byte) error {
+			t.Fatal("finalizers stripped before the timeout")
+			return nil
+		})
+		require.NoError(t, watchdog.observe(context.Background(), o))
+		assert.Empty(t, messages)
+	})
+
+	t.Run("force strips only kubefirst finalizers", func(t *testing.T) {
+		watchdog := NewFinalizerWatchdog(5*time.Minute, true, nil)
+		watchdog.now = func() time.Time { return now }
+
+		var patches []string
+		record := func(_ context.Context, patch []byte) error {
+			patches = append(patches, string(patch))
+			return nil
+		}
+
+		require.NoError(t, watchdog.observe(context.Background(), newDeletingObject("Application", app, record)))
+		require.NoError(t, watchdog.observe(context.Background(), newDeletingObject("PersistentVolumeClaim", claim, record)))
+
+		assert.Equal(t, []string{`{"metadata":{"finalizers":["example.com/cleanup"]}}`}, patches)
+	})
+}
+
+func TestFinalizerController(t *testing.T) {
+	assert.Equal(t, "the namespace controller once every object in the namespace is deleted", FinalizerController("kubernetes"))
+	assert.Equal(t, "the controller of vcluster.loft.sh", FinalizerController("vcluster.loft.sh/cleanup"))
+	assert.Equal(t, "the controller that added it", FinalizerController("cleanup"))
+}
+
+func TestKubefirstFinalizer(t *testing.T) {
+	assert.True(t, KubefirstFinalizer("Application", argoCDResourcesFinalizer))
+	assert.True(t, KubefirstFinalizer("Namespace", "platform.kubefirst.io/teardown"))
+	assert.False(t, KubefirstFinalizer("Service", argoCDResourcesFinalizer))
+	assert.False(t, KubefirstFinalizer("PersistentVolumeClaim", "kubernetes.io/pvc-protection"))
+	assert.False(t, KubefirstFinalizer("Service", "notkubefirst.io/cleanup"))
+}
+
+func TestReleaseLoadBalancers(t *testing.T) {
+	pools, err := ParseLBPools(LBPoolName, []string{"10.0.12.0/24"}, nil)
+	require.NoError(t, err)
+	scope := TeardownScope{Namespaces: []string{WildcardGatewayNamespace}}
+
+	t.Run("deletes the services of the scope", func(t *testing.T) {
+		client := &Client{Clientset: fake.NewSimpleClientset(
+			newLoadBalancerService(WildcardGatewayNamespace, "http", "10.0.12.20"),
+			newLoadBalancerService("other", "http", "192.168.1.20"),
+		)}
+
+		require.NoError(t, client.ReleaseLoadBalancers(context.Background(), scope, pools, NewFinalizerWatchdog(DefaultFinalizerTimeout, false, nil)))
+
+		_, err := client.Clientset.CoreV1().Services(WildcardGatewayNamespace).Get(context.Background(), "http", metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+
+	t.Run("names services still holding pool addresses", func(t *testing.T) {
+		client := &Client{Clientset: fake.NewSimpleClientset(
+			newLoadBalancerService("other", "http", "10.0.12.21"),
+		)}
+
+		err := client.ReleaseLoadBalancers(context.Background(), scope, pools, NewFinalizerWatchdog(DefaultFinalizerTimeout, false, nil))
+		assert.ErrorContains(t, err, "service other/http holds 10.0.12.21")
+	})
+}
+
+func TestDeleteOwnedResources(t *testing.T) {
+	crd := func(name, tracking string) *unstructured.Unstructured {
+		object := &unstructured.Unstructured{}
+		object.SetAPIVersion("apiextensions.k8s.io/v1")
+		object.SetKind("CustomResourceDefinition")
+		object.SetName(name)
+		object.SetAnnotations(map[string]string{argoCDTrackingAnnotation: tracking})
+		return object
+	}
+
+	client := &Client{
+		Clientset: fake.NewSimpleClientset(
+			&admissionregistrationv1.ValidatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "cert-manager-webhook", Labels: map[string]string{argoCDInstanceLabel: "cert-manager"}}},
+			&admissionregistrationv1.MutatingWebhookConfiguration{ObjectMeta: metav1.ObjectMeta{Name: "rancher-webhook"}},
+		),
+		Dynamic: dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+			map[schema.GroupVersionResource]string{crdResource: "CustomResourceDefinitionList"},
+			crd("certificates.cert-manager.io", "cert-manager:apiextensions.k8s.io/CustomResourceDefinition:/certificates.cert-manager.io"),
+			crd("clusters.provisioning.cattle.io", "rancher:apiextensions.k8s.io/CustomResourceDefinition:/clusters.provisioning.cattle.io"),
+		),
+	}
+	scope := TeardownScope{Applications: []string{"cert-manager"}}
+
+	require.NoError(t, client.DeleteOwnedResources(context.Background(), scope, NewFinalizerWatchdog(DefaultFinalizerTimeout, false, nil)))
+
+	_, err := client.Clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), "cert-manager-webhook", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+	_, err = client.Clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), "rancher-webhook", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	definitions, err := client.Dynamic.Resource(crdResource).List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, definitions.Items, 1)
+	assert.Equal(t, "clusters.provisioning.cattle.io", definitions.Items[0].GetName())
+}
+
+func TestDeleteNamespacesReportsStuckNamespaces(t *testing.T) {
+	watchdog := NewFinalizerWatchdog(time.Minute, false, nil)
+	deleted := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	namespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault", DeletionTimestamp: &deleted},
+		Spec:       corev1.NamespaceSpec{Finalizers: []corev1.FinalizerName{corev1.FinalizerKubernetes}},
+		Status: corev1.NamespaceStatus{Conditions: []corev1.NamespaceCondition{{
+			Type:    corev1.NamespaceFinalizersRemaining,
+			Status:  corev1.ConditionTrue,
+			Message: "Some content in the namespace has finalizers remaining: kubernetes.io/pvc-protection in 1 resource instances",
+		}}},
+	}
+
+	var messages []string
+	watchdog.OnStuck = func(message string) { messages = append(messages, message) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := watchdog.waitDeleted(ctx, "namespaces", func(context.Context) ([]deletingObject, error) {
+		o := newDeletingObject("Namespace", namespace, nil)
+		o.finalizers = []string{string(corev1.FinalizerKubernetes)}
+		o.detail = namespaceContentRemaining(namespace)
+		return []deletingObject{o}, nil
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	assert.ErrorContains(t, err, "Namespace vault (finalizers kubernetes)")
+
+	require.Len(t, messages, 1)
+	assert.Contains(t, messages[0], "finalizer kubernetes is cleared by the namespace controller")
+	assert.Contains(t, messages[0], "kubernetes.io/pvc-protection in 1 resource instances")
+}
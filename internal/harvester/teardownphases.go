@@ -0,0 +1,288 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// TeardownPhases are the phases destroy --phases tears down, in the order
+// they are removed
+var TeardownPhases = []string{PhaseVault, PhaseVCluster, PhaseIngress, PhaseArgoCD}
+
+// teardownDependents lists the phases that cannot outlive a phase, ArgoCD
+// syncs every other one
+var teardownDependents = map[string][]string{
+	PhaseArgoCD: {PhaseIngress, PhaseVCluster, PhaseVault},
+}
+
+// ValidateTeardownPhases ensures phases are teardown phases and that the
+// phases depending on them are torn down too, unless force is set. It
+// returns them in the order they are removed
+func ValidateTeardownPhases(phases []string, force bool) ([]string, error) {
+	for _, phase := range phases {
+		if !slices.Contains(TeardownPhases, phase) {
+			return nil, fmt.Errorf("unknown phase %q, must be one of %v", phase, TeardownPhases)
+		}
+	}
+
+	for _, phase := range phases {
+		var missing []string
+		for _, dependent := range teardownDependents[phase] {
+			if !slices.Contains(phases, dependent) {
+				missing = append(missing, dependent)
+			}
+		}
+		if len(missing) > 0 && !force {
+			return nil, fmt.Errorf("phase %s cannot be torn down without %s, which depend on it; add them or pass --force to leave them running", phase, strings.Join(missing, ", "))
+		}
+	}
+
+	ordered := slices.DeleteFunc(slices.Clone(TeardownPhases), func(phase string) bool {
+		return !slices.Contains(phases, phase)
+	})
+
+	return ordered, nil
+}
+
+// TeardownTargets returns the applications of the teardown phases other
+// than argocd, which owns every application none of them claims
+func TeardownTargets(vclusters []string, externalSecrets, vclusterAppSet bool) map[string]PhaseTarget {
+	return map[string]PhaseTarget{
+		PhaseIngress:  PhaseTargets(PhaseIngress, vclusters, externalSecrets, vclusterAppSet),
+		PhaseVCluster: PhaseTargets(PhaseVCluster, vclusters, externalSecrets, vclusterAppSet),
+		PhaseVault:    PhaseTargets(PhaseVault, vclusters, externalSecrets, vclusterAppSet),
+	}
+}
+
+// RollbackPhases returns the phases rolling back to phase removes: the ones
+// provisioned after it up to stopAfter, latest first, leaving out those
+// tornDown already. sso is left out too, it only reconfigures ArgoCD and
+// has no resources of its own
+func RollbackPhases(phase, stopAfter string, tornDown []string) ([]string, error) {
+	if !slices.Contains(Phases, phase) {
+		return nil, fmt.Errorf("unknown phase %q, must be one of %v", phase, Phases)
+	}
+
+	var phases []string
+	for i := len(Phases) - 1; Phases[i] != phase; i-- {
+		current := Phases[i]
+		if current == PhaseSSO || !PhaseEnabled(stopAfter, current) || slices.Contains(tornDown, current) {
+			continue
+		}
+		phases = append(phases, current)
+	}
+
+	return phases, nil
+}
+
+// RollbackTargets returns TeardownTargets along with observability, which
+// a rollback removes but destroy --phases leaves to the argocd phase
+func RollbackTargets(vclusters []string, externalSecrets, vclusterAppSet bool) map[string]PhaseTarget {
+	targets := TeardownTargets(vclusters, externalSecrets, vclusterAppSet)
+	targets[PhaseObservability] = PhaseTargets(PhaseObservability, vclusters, externalSecrets, vclusterAppSet)
+
+	return targets
+}
+
+// PhaseTeardownScope lists the applications and namespaces tearing down
+// phase removes. Owners are the applications outside of it that sync its
+// applications, their automated sync has to be paused for ArgoCD not to
+// recreate them
+func (c *Client) PhaseTeardownScope(ctx context.Context, phase string, targets map[string]PhaseTarget) (TeardownScope, error) {
+	apps, err := c.ListApplications(ctx, "*")
+	if err != nil && !errors.Is(err, ErrApplicationNotFound) {
+		return TeardownScope{}, err
+	}
+
+	inPhase := func(app *v1alpha1.Application) bool {
+		if phase != PhaseArgoCD {
+			return targets[phase].Matches(app)
+		}
+		for _, target := range targets {
+			if target.Matches(app) {
+				return false
+			}
+		}
+		return true
+	}
+
+	var scope TeardownScope
+	seen := map[string]bool{ArgoCDNamespace: true}
+	for i := range apps {
+		app := &apps[i]
+		if !inPhase(app) {
+			continue
+		}
+		scope.Applications = append(scope.Applications, app.Name)
+
+		namespace := app.Spec.Destination.Namespace
+		if seen[namespace] || protectedNamespace(namespace) {
+			continue
+		}
+		seen[namespace] = true
+		scope.Namespaces = append(scope.Namespaces, namespace)
+	}
+	sort.Strings(scope.Namespaces)
+	if phase == PhaseArgoCD {
+		scope.Namespaces = append(scope.Namespaces, ArgoCDNamespace)
+	}
+
+	addOwner := func(owner string) {
+		if owner != "" && !slices.Contains(scope.Applications, owner) && !slices.Contains(scope.Owners, owner) {
+			scope.Owners = append(scope.Owners, owner)
+		}
+	}
+	for i := range apps {
+		if !slices.Contains(scope.Applications, apps[i].Name) {
+			continue
+		}
+		addOwner(applicationOwner(&apps[i]))
+		if appSet := generatingAppSet(&apps[i]); appSet != "" && !slices.Contains(scope.ApplicationSets, appSet) {
+			scope.ApplicationSets = append(scope.ApplicationSets, appSet)
+		}
+	}
+
+	// the application syncing an ApplicationSet would recreate it
+	for _, name := range scope.ApplicationSets {
+		appSet, err := c.ArgoCD.ArgoprojV1alpha1().ApplicationSets(ArgoCDNamespace).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return TeardownScope{}, fmt.Errorf("failed to get applicationset %q: %w", name, err)
+		}
+		addOwner(applicationOwner(appSet))
+	}
+	sort.Strings(scope.Owners)
+	sort.Strings(scope.ApplicationSets)
+
+	return scope, nil
+}
+
+// applicationOwner returns the application that syncs object, as recorded
+// by its tracking annotation or instance label
+func applicationOwner(object metav1.Object) string {
+	owner := object.GetLabels()[argoCDInstanceLabel]
+	if tracking, ok := object.GetAnnotations()[argoCDTrackingAnnotation]; ok {
+		owner, _, _ = strings.Cut(tracking, ":")
+	}
+
+	return owner
+}
+
+// PauseSync disables the automated sync of apps, returning the policy each
+// had for RestoreSync. Applications without one are left alone
+func (c *Client) PauseSync(ctx context.Context, apps []string) (map[string]json.RawMessage, error) {
+	client := c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+
+	paused := map[string]json.RawMessage{}
+	for _, name := range apps {
+		app, err := client.Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return paused, fmt.Errorf("failed to get application %q: %w", name, err)
+		}
+		if app.Spec.SyncPolicy == nil || app.Spec.SyncPolicy.Automated == nil {
+			continue
+		}
+
+		automated, err := json.Marshal(app.Spec.SyncPolicy.Automated)
+		if err != nil {
+			return paused, fmt.Errorf("failed to encode the sync policy of application %q: %w", name, err)
+		}
+		if err := c.patchAutomatedSync(ctx, name, json.RawMessage("null")); err != nil {
+			return paused, err
+		}
+		paused[name] = automated
+	}
+
+	return paused, nil
+}
+
+// RestoreSync sets back the automated sync policies PauseSync returned
+func (c *Client) RestoreSync(ctx context.Context, paused map[string]json.RawMessage) error {
+	for _, name := range sortedKeys(paused) {
+		if err := c.patchAutomatedSync(ctx, name, paused[name]); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) patchAutomatedSync(ctx context.Context, name string, automated json.RawMessage) error {
+	patch, err := json.Marshal(map[string]any{"spec": map[string]any{"syncPolicy": map[string]any{"automated": automated}}})
+	if err != nil {
+		return fmt.Errorf("failed to encode the sync policy of application %q: %w", name, err)
+	}
+
+	_, err = c.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+	if err != nil {
+		return fmt.Errorf("failed to patch the sync policy of application %q: %w", name, err)
+	}
+
+	return nil
+}
+
+// TeardownState records the phases destroy --phases removed, for create
+// to rebuild them
+type TeardownState struct {
+	Phases []string `json:"phases"`
+	// PausedSync is the automated sync policy of the applications paused
+	// so they did not recreate the removed ones
+	PausedSync map[string]json.RawMessage `json:"pausedSync,omitempty"`
+}
+
+// ParseTeardownState parses the state recorded by destroy --phases, an
+// empty value being no state
+func ParseTeardownState(value string) (TeardownState, error) {
+	var state TeardownState
+	if value == "" {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		return TeardownState{}, fmt.Errorf("invalid teardown state: %w", err)
+	}
+
+	return state, nil
+}
+
+// Record adds phase as torn down, and the sync policies paused for it
+func (s *TeardownState) Record(phase string, paused map[string]json.RawMessage) {
+	if !slices.Contains(s.Phases, phase) {
+		s.Phases = append(s.Phases, phase)
+	}
+	for name, automated := range paused {
+		if s.PausedSync == nil {
+			s.PausedSync = map[string]json.RawMessage{}
+		}
+		// the first pause holds the policy to restore
+		if _, ok := s.PausedSync[name]; !ok {
+			s.PausedSync[name] = automated
+		}
+	}
+}
+
+// String encodes the state for the kubefirst config
+func (s TeardownState) String() string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
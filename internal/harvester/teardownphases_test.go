@@ -0,0 +1,128 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateTeardownPhases(t *testing.T) {
+	phases, err := ValidateTeardownPhases([]string{PhaseArgoCD, PhaseVCluster, PhaseVault, PhaseIngress}, false)
+	require.NoError(t, err)
+	assert.Equal(t, TeardownPhases, phases)
+
+	phases, err = ValidateTeardownPhases([]string{PhaseVCluster}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{PhaseVCluster}, phases)
+
+	_, err = ValidateTeardownPhases([]string{PhaseArgoCD, PhaseVault}, false)
+	assert.ErrorContains(t, err, "phase argocd cannot be torn down without ingress, vcluster")
+
+	_, err = ValidateTeardownPhases([]string{PhaseArgoCD}, true)
+	require.NoError(t, err)
+
+	_, err = ValidateTeardownPhases([]string{PhaseSSO}, false)
+	assert.ErrorContains(t, err, `unknown phase "sso"`)
+}
+
+func TestRollbackPhases(t *testing.T) {
+	phases, err := RollbackPhases(PhaseIngress, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{PhaseObservability, PhaseVault, PhaseVCluster}, phases)
+
+	phases, err = RollbackPhases(PhaseArgoCD, PhaseVault, []string{PhaseVCluster})
+	require.NoError(t, err)
+	assert.Equal(t, []string{PhaseVault, PhaseIngress}, phases)
+
+	phases, err = RollbackPhases(PhaseVault, PhaseVault, nil)
+	require.NoError(t, err)
+	assert.Empty(t, phases)
+
+	_, err = RollbackPhases("dns", "", nil)
+	assert.ErrorContains(t, err, `unknown phase "dns"`)
+}
+
+func TestPhaseTeardownScope(t *testing.T) {
+	child := func(name, namespace string) *v1alpha1.Application {
+		app := newDestinationApplication(name, namespace)
+		app.Annotations = map[string]string{argoCDTrackingAnnotation: "registry:argoproj.io/Application:argocd/" + name}
+		return app
+	}
+	client := &Client{ArgoCD: argocdfake.NewSimpleClientset(
+		newDestinationApplication("registry", ArgoCDNamespace),
+		child("platform-vcluster", ArgoCDNamespace),
+		child("vcluster-dev", VClusterNamespace("dev")),
+		child("vault", "vault"),
+		child("cert-manager", "cert-manager"),
+	)}
+	targets := TeardownTargets([]string{"dev"}, false, false)
+
+	scope, err := client.PhaseTeardownScope(context.Background(), PhaseVCluster, targets)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"platform-vcluster", "vcluster-dev"}, scope.Applications)
+	assert.Equal(t, []string{VClusterNamespace("dev")}, scope.Namespaces)
+	assert.Equal(t, []string{"registry"}, scope.Owners)
+
+	scope, err = client.PhaseTeardownScope(context.Background(), PhaseArgoCD, targets)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cert-manager", "registry"}, scope.Applications)
+	assert.Equal(t, []string{"cert-manager", ArgoCDNamespace}, scope.Namespaces)
+	assert.Empty(t, scope.Owners)
+
+	t.Run("applicationset applications", func(t *testing.T) {
+		generated := newDestinationApplication("vcluster-dev", VClusterNamespace("dev"))
+		generated.OwnerReferences = []metav1.OwnerReference{{Kind: "ApplicationSet", Name: VClusterAppSet}}
+		appSet := &v1alpha1.ApplicationSet{ObjectMeta: metav1.ObjectMeta{
+			Name:        VClusterAppSet,
+			Namespace:   ArgoCDNamespace,
+			Annotations: map[string]string{argoCDTrackingAnnotation: "registry:argoproj.io/ApplicationSet:argocd/" + VClusterAppSet},
+		}}
+		client := &Client{ArgoCD: argocdfake.NewSimpleClientset(newDestinationApplication("registry", ArgoCDNamespace), generated, appSet)}
+
+		scope, err := client.PhaseTeardownScope(context.Background(), PhaseVCluster, TeardownTargets([]string{"dev"}, false, true))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"vcluster-dev"}, scope.Applications)
+		assert.Equal(t, []string{VClusterAppSet}, scope.ApplicationSets)
+		assert.Equal(t, []string{"registry"}, scope.Owners)
+
+		require.NoError(t, client.DeleteApplications(context.Background(), scope, NewFinalizerWatchdog(time.Minute, false, nil)))
+		_, err = client.ArgoCD.ArgoprojV1alpha1().ApplicationSets(ArgoCDNamespace).Get(context.Background(), VClusterAppSet, metav1.GetOptions{})
+		assert.True(t, apierrors.IsNotFound(err))
+	})
+}
+
+func TestPauseSync(t *testing.T) {
+	registry := newDestinationApplication("registry", ArgoCDNamespace)
+	registry.Spec.SyncPolicy = &v1alpha1.SyncPolicy{Automated: &v1alpha1.SyncPolicyAutomated{Prune: true, SelfHeal: true}}
+	client := &Client{ArgoCD: argocdfake.NewSimpleClientset(registry, newDestinationApplication("manual", ArgoCDNamespace))}
+	apps := client.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace)
+
+	paused, err := client.PauseSync(context.Background(), []string{"registry", "manual", "gone"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"registry"}, sortedKeys(paused))
+
+	app, err := apps.Get(context.Background(), "registry", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, app.Spec.SyncPolicy.Automated)
+
+	state, err := ParseTeardownState("")
+	require.NoError(t, err)
+	state.Record(PhaseVCluster, paused)
+	state.Record(PhaseVault, map[string]json.RawMessage{"registry": json.RawMessage("null")})
+	state, err = ParseTeardownState(state.String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{PhaseVCluster, PhaseVault}, state.Phases)
+
+	require.NoError(t, client.RestoreSync(context.Background(), state.PausedSync))
+	app, err = apps.Get(context.Background(), "registry", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, &v1alpha1.SyncPolicyAutomated{Prune: true, SelfHeal: true}, app.Spec.SyncPolicy.Automated)
+}
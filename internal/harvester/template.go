@@ -0,0 +1,129 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const clusterNameToken = "<CLUSTER_NAME>"
+
+// scpLikeURL matches the user@host:path form of ssh git URLs
+var scpLikeURL = regexp.MustCompile(`^[A-Za-z0-9._-]+@[A-Za-z0-9.-]+:[^/].*$`)
+
+// ValidateGitopsTemplateURL ensures templateURL is an https, ssh or git URL
+// of a repository, or its user@host:path form
+func ValidateGitopsTemplateURL(templateURL string) error {
+	if templateURL == "" {
+		return errors.New("must not be empty")
+	}
+	if scpLikeURL.MatchString(templateURL) {
+		return nil
+	}
+
+	parsed, err := url.Parse(templateURL)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid url: %w", templateURL, err)
+	}
+	switch parsed.Scheme {
+	case "https", "ssh", "git":
+	case "":
+		return fmt.Errorf("%q has no scheme, an https or git url such as https://github.com/konstructio/gitops-template.git is expected", templateURL)
+	default:
+		return fmt.Errorf("%q has the %s scheme, only https, ssh and git are supported", templateURL, parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("%q has no host", templateURL)
+	}
+	if strings.Trim(parsed.Path, "/") == "" {
+		return fmt.Errorf("%q names no repository", templateURL)
+	}
+
+	return nil
+}
+
+// RegistryPath returns the path of the ArgoCD root Application inside the
+// gitops repository, defaulting to the standard registry/<cluster-name>
+func RegistryPath(registryPath, clusterName string) string {
+	if registryPath == "" {
+		return fmt.Sprintf("registry/%s", clusterName)
+	}
+
+	return strings.Trim(registryPath, "/")
+}
+
+// TemplatePathExists shallow clones the gitops template into memory and
+// reports whether repoPath exists in it. Paths are also matched against the
+// template's tokenized form, where the cluster name is still <CLUSTER_NAME>
+func TemplatePathExists(ctx context.Context, templateURL, branch, repoPath, clusterName, proxy string) (bool, error) {
+	tlsOptions := currentTLSOptions()
+	options := &git.CloneOptions{
+		URL:             templateURL,
+		Depth:           1,
+		SingleBranch:    true,
+		ProxyOptions:    gitProxyOptions(proxy, templateURL),
+		CABundle:        tlsOptions.CABundle,
+		InsecureSkipTLS: tlsOptions.InsecureSkipVerify,
+	}
+	if branch != "" {
+		options.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	}
+
+	fs := memfs.New()
+	if _, err := git.CloneContext(ctx, memory.NewStorage(), fs, options); err != nil {
+		return false, fmt.Errorf("failed to clone gitops template %q: %w", templateURL, err)
+	}
+
+	for _, candidate := range templatePaths(repoPath, clusterName) {
+		_, err := fs.Stat(candidate)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("failed to inspect %q in gitops template: %w", candidate, err)
+		}
+	}
+
+	return false, nil
+}
+
+// templatePaths returns repoPath and, when it contains the cluster name, its
+// tokenized form
+func templatePaths(repoPath, clusterName string) []string {
+	candidates := []string{repoPath}
+	if clusterName != "" && strings.Contains(repoPath, clusterName) {
+		candidates = append(candidates, strings.ReplaceAll(repoPath, clusterName, clusterNameToken))
+	}
+
+	return candidates
+}
+
+// templateFilesContain reports whether repoPath, or its tokenized form, is a
+// file or directory of the template files keyed by path
+func templateFilesContain(files map[string][]byte, repoPath, clusterName string) bool {
+	for _, candidate := range templatePaths(repoPath, clusterName) {
+		prefix := strings.Trim(candidate, "/") + "/"
+		for name := range files {
+			if name == strings.Trim(candidate, "/") || strings.HasPrefix(name, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+package harvester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTemplateRepo(t *testing.T, files ...string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte("kind: Application\n"), 0o644))
+		_, err := worktree.Add(file)
+		require.NoError(t, err)
+	}
+
+	_, err = worktree.Commit("init", &git.CommitOptions{
+		Author: &object.Signature{Name: "kbot", Email: "kbot@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	return dir
+}
+
+func TestValidateGitopsTemplateURL(t *testing.T) {
+	for _, templateURL := range []string{
+		"https://github.com/konstructio/gitops-template.git",
+		"ssh://git@gitea.internal:2222/platform/gitops-template.git",
+		"git@github.com:konstructio/gitops-template.git",
+	} {
+		require.NoError(t, ValidateGitopsTemplateURL(templateURL), templateURL)
+	}
+
+	for templateURL, message := range map[string]string{
+		"":                                   "must not be empty",
+		"github.com/konstructio/gitops":      "has no scheme",
+		"http://github.com/konstructio/repo": "has the http scheme, only https, ssh and git are supported",
+		"https:///konstructio/repo":          "has no host",
+		"https://github.com/":                "names no repository",
+	} {
+		require.ErrorContains(t, ValidateGitopsTemplateURL(templateURL), message, templateURL)
+	}
+}
+
+func TestRegistryPath(t *testing.T) {
+	assert.Equal(t, "registry/kubefirst", RegistryPath("", "kubefirst"))
+	assert.Equal(t, "platform/registry", RegistryPath("/platform/registry/", "kubefirst"))
+}
+
+func TestTemplatePathExists(t *testing.T) {
+	dir := newTemplateRepo(t, "registry/<CLUSTER_NAME>/registry.yaml", "custom/root/app.yaml")
+
+	tests := []struct {
+		name     string
+		repoPath string
+		want     bool
+	}{
+		{name: "tokenized default path", repoPath: "registry/kubefirst", want: true},
+		{name: "custom path", repoPath: "custom/root", want: true},
+		{name: "missing path", repoPath: "apps/registry", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, err := TemplatePathExists(context.Background(), dir, "", tt.repoPath, "kubefirst", "")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, exists)
+		})
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// TLSOptions are the TLS settings of every HTTP client and git remote
+// kubefirst builds for Harvester, for endpoints such as an internal GitLab
+// or UniFi controller signed by a private CA
+type TLSOptions struct {
+	// CABundle holds PEM certificates trusted on top of the system pool
+	CABundle []byte
+	// InsecureSkipVerify turns certificate verification off, for labs only
+	InsecureSkipVerify bool
+}
+
+var tlsState atomic.Pointer[tlsSettings]
+
+type tlsSettings struct {
+	options TLSOptions
+	roots   *x509.CertPool
+}
+
+// SetTLSOptions sets the TLS options for the whole process. Clients built
+// before the call keep the options they were built with
+func SetTLSOptions(options TLSOptions) error {
+	settings := &tlsSettings{options: options}
+	if len(options.CABundle) > 0 {
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(options.CABundle) {
+			return errors.New("the CA bundle holds no PEM certificate")
+		}
+		settings.roots = roots
+	}
+
+	tlsState.Store(settings)
+
+	return nil
+}
+
+// currentTLSOptions returns the options set by SetTLSOptions
+func currentTLSOptions() TLSOptions {
+	if settings := tlsState.Load(); settings != nil {
+		return settings.options
+	}
+
+	return TLSOptions{}
+}
+
+// tlsClientConfig returns the TLS config of HTTP transports, nil to keep
+// the Go defaults when no option is set
+func tlsClientConfig() *tls.Config {
+	settings := tlsState.Load()
+	if settings == nil || (settings.roots == nil && !settings.options.InsecureSkipVerify) {
+		return nil
+	}
+
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		RootCAs:            settings.roots,
+		InsecureSkipVerify: settings.options.InsecureSkipVerify, //nolint:gosec // opted into with --insecure-skip-tls-verify
+	}
+}
+
+// LoadCACerts reads the PEM certificates of paths into a single bundle.
+// A directory contributes its .pem, .crt and .cer files. Every file must
+// hold at least one certificate that parses, so a wrong path fails during
+// configuration rather than as an x509 error mid-provisioning
+func LoadCACerts(paths []string) ([]byte, error) {
+	var bundle bytes.Buffer
+	for _, path := range paths {
+		files, err := caCertFiles(os.ExpandEnv(path))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA certificate %q: %w", file, err)
+			}
+			if err := validateCACert(content); err != nil {
+				return nil, fmt.Errorf("invalid CA certificate %q: %w", file, err)
+			}
+
+			bundle.Write(bytes.TrimSpace(content))
+			bundle.WriteString("\n")
+		}
+	}
+
+	return bundle.Bytes(), nil
+}
+
+// caCertFiles returns path, or the certificate files of the directory at
+// path in name order
+func caCertFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate %q: %w", path, err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate directory %q: %w", path, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".pem", ".crt", ".cer":
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("CA certificate directory %q holds no .pem, .crt or .cer file", path)
+	}
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// validateCACert checks every PEM block of content is a certificate that
+// parses, and that there is at least one
+func validateCACert(content []byte) error {
+	count := 0
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("unexpected PEM block %q, only certificates are accepted", block.Type)
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		count++
+	}
+	if count == 0 {
+		return errors.New("no PEM certificate found")
+	}
+
+	return nil
+}
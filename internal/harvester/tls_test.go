@@ -0,0 +1,68 @@
+package harvester
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadCACerts(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "internal-ca.crt"), caPEM, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0o600))
+
+	t.Run("reads files and directories", func(t *testing.T) {
+		bundle, err := LoadCACerts([]string{filepath.Join(dir, "internal-ca.crt"), dir})
+		require.NoError(t, err)
+		assert.Equal(t, 2, countPEMBlocks(bundle))
+	})
+
+	t.Run("rejects a file that is not a certificate", func(t *testing.T) {
+		_, err := LoadCACerts([]string{filepath.Join(dir, "README")})
+		require.ErrorContains(t, err, "no PEM certificate found")
+	})
+
+	t.Run("rejects a missing file", func(t *testing.T) {
+		_, err := LoadCACerts([]string{filepath.Join(dir, "missing.pem")})
+		require.ErrorContains(t, err, "failed to read CA certificate")
+	})
+
+	t.Run("http clients trust the bundle", func(t *testing.T) {
+		t.Cleanup(func() { require.NoError(t, SetTLSOptions(TLSOptions{})) })
+
+		httpClient, err := NewHTTPClient("")
+		require.NoError(t, err)
+		_, err = httpClient.Get(server.URL)
+		require.ErrorContains(t, err, "certificate")
+
+		bundle, err := LoadCACerts([]string{dir})
+		require.NoError(t, err)
+		require.NoError(t, SetTLSOptions(TLSOptions{CABundle: bundle}))
+
+		httpClient, err = NewHTTPClient("")
+		require.NoError(t, err)
+		res, err := httpClient.Get(server.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	})
+}
+
+func countPEMBlocks(content []byte) int {
+	count := 0
+	for block, rest := pem.Decode(content); block != nil; block, rest = pem.Decode(rest) {
+		count++
+	}
+
+	return count
+}
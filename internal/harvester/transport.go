@@ -0,0 +1,203 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/konstructio/kubefirst/internal/readonly"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyEnv lists the proxy environment variables, upper case first as
+// httpproxy reads them
+var proxyEnv = map[string][]string{
+	"http-proxy":  {"HTTP_PROXY", "http_proxy"},
+	"https-proxy": {"HTTPS_PROXY", "https_proxy"},
+	"no-proxy":    {"NO_PROXY", "no_proxy"},
+}
+
+// SetProxyEnv overrides the proxy environment variables of the process
+// with the non-empty values of settings, keyed by the flag setting them:
+// http-proxy, https-proxy and no-proxy. Both cases of a variable are set,
+// so clients and child processes reading either agree
+func SetProxyEnv(settings map[string]string) error {
+	for flag, value := range settings {
+		if value == "" {
+			continue
+		}
+		names, ok := proxyEnv[flag]
+		if !ok {
+			return fmt.Errorf("unknown proxy setting %q", flag)
+		}
+		if flag != "no-proxy" {
+			if _, err := url.Parse(value); err != nil {
+				return fmt.Errorf("invalid --%s %q: %w", flag, value, err)
+			}
+		}
+		for _, name := range names {
+			if err := os.Setenv(name, value); err != nil {
+				return fmt.Errorf("failed to set %s: %w", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ProxyFunc returns the proxy selection every HTTP client kubefirst builds
+// for Harvester uses. An empty proxy honors HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY; otherwise proxy replaces HTTP_PROXY and HTTPS_PROXY while
+// NO_PROXY still applies, so in-network hosts can bypass it. The
+// environment is read when ProxyFunc is called rather than once per
+// process as http.ProxyFromEnvironment does, so SetProxyEnv applies
+func ProxyFunc(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	config := httpproxy.FromEnvironment()
+	if proxy != "" {
+		if _, err := url.Parse(proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy url %q: %w", proxy, err)
+		}
+		config.HTTPProxy = proxy
+		config.HTTPSProxy = proxy
+	}
+
+	proxyForURL := config.ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyForURL(req.URL)
+	}, nil
+}
+
+// ProxyFor returns the proxy requests to target go through, nil when they
+// go direct: target is exempted by NO_PROXY, is a loopback address or no
+// proxy is set for its scheme. Targets that are not http(s) urls, e.g. ssh
+// git remotes, always go direct
+func ProxyFor(proxy, target string) (*url.URL, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil || (targetURL.Scheme != "http" && targetURL.Scheme != "https") {
+		return nil, nil
+	}
+
+	proxyFunc, err := ProxyFunc(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyURL, err := proxyFunc(&http.Request{URL: targetURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to select proxy for %q: %w", target, err)
+	}
+
+	return proxyURL, nil
+}
+
+// NewHTTPClient returns an HTTP client routed through proxy as described
+// by ProxyFunc and verifying certificates as set by SetTLSOptions.
+// Mutating requests fail while read-only mode is enabled, the others are
+// counted towards the usage summary
+func NewHTTPClient(proxy string) (*http.Client, error) {
+	proxyFunc, err := ProxyFunc(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	httpTransport := http.DefaultTransport.(*http.Transport).Clone()
+	httpTransport.Proxy = proxyFunc
+	if tlsConfig := tlsClientConfig(); tlsConfig != nil {
+		httpTransport.TLSClientConfig = tlsConfig
+	}
+
+	return &http.Client{Transport: &readonly.RoundTripper{Next: &usageRoundTripper{next: httpTransport}}}, nil
+}
+
+// ProxyEndpoint is an endpoint kubefirst calls during create. Local
+// endpoints, such as the Harvester API server, are expected on the LAN
+type ProxyEndpoint struct {
+	Name  string
+	URL   string
+	Local bool
+}
+
+// ProxyRoutes describes the proxy requests to each of endpoints go through,
+// and warns about every local endpoint not exempted from it by NO_PROXY.
+// Only the scheme and host of the endpoints are printed, webhook paths can
+// hold secrets
+func ProxyRoutes(proxy string, endpoints []ProxyEndpoint) ([]string, []string, error) {
+	var routes, warnings []string
+	for _, endpoint := range endpoints {
+		proxyURL, err := ProxyFor(proxy, endpoint.URL)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		target := endpoint.URL
+		if endpointURL, err := url.Parse(endpoint.URL); err == nil && endpointURL.Host != "" {
+			target = endpointURL.Scheme + "://" + endpointURL.Host
+		}
+
+		if proxyURL == nil {
+			routes = append(routes, fmt.Sprintf("%s %s: direct", endpoint.Name, target))
+			continue
+		}
+
+		routes = append(routes, fmt.Sprintf("%s %s: through proxy %s", endpoint.Name, target, proxyURL.Redacted()))
+		if endpoint.Local {
+			warnings = append(warnings, fmt.Sprintf("the %s %s is reached through proxy %s, add its host to --no-proxy or NO_PROXY if it is on the LAN", endpoint.Name, target, proxyURL.Redacted()))
+		}
+	}
+
+	return routes, warnings, nil
+}
+
+// gitProxyOptions routes go-git to remote through the proxy ProxyFor
+// selects, so NO_PROXY exempts in-network git hosts from an explicit proxy
+// too. go-git goes direct with empty options
+func gitProxyOptions(proxy, remote string) transport.ProxyOptions {
+	proxyURL, err := ProxyFor(proxy, remote)
+	if err != nil || proxyURL == nil {
+		return transport.ProxyOptions{}
+	}
+
+	return transport.ProxyOptions{URL: proxyURL.String()}
+}
+
+// CheckProxyConnectivity verifies target can be reached through the proxy
+// selected for it, so a misconfigured proxy fails validation instead of
+// hanging provisioning. It does nothing when no proxy applies to target or
+// target is not an http(s) url, e.g. an ssh git remote
+func CheckProxyConnectivity(ctx context.Context, proxy, target string) error {
+	proxyURL, err := ProxyFor(proxy, target)
+	if err != nil {
+		return err
+	}
+	if proxyURL == nil {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request for %q: %w", target, err)
+	}
+
+	httpClient, err := NewHTTPClient(proxy)
+	if err != nil {
+		return err
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to reach %q through proxy %q: %w", target, proxyURL.Redacted(), err)
+	}
+	res.Body.Close()
+
+	return nil
+}
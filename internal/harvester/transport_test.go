@@ -0,0 +1,208 @@
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/konstructio/kubefirst/internal/readonly"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestProxyFunc(t *testing.T) {
+	t.Setenv("NO_PROXY", "harvester.lan")
+
+	proxyFunc, err := ProxyFunc("http://proxy.example.com:3128")
+	require.NoError(t, err)
+
+	t.Run("routes external hosts through the explicit proxy", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://api.cloudflare.com", nil)
+		require.NoError(t, err)
+
+		proxyURL, err := proxyFunc(req)
+		require.NoError(t, err)
+		require.NotNil(t, proxyURL)
+		assert.Equal(t, "proxy.example.com:3128", proxyURL.Host)
+	})
+
+	t.Run("still honors NO_PROXY", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, "https://harvester.lan:6443", nil)
+		require.NoError(t, err)
+
+		proxyURL, err := proxyFunc(req)
+		require.NoError(t, err)
+		assert.Nil(t, proxyURL)
+	})
+}
+
+func TestProxyRoutes(t *testing.T) {
+	for _, names := range proxyEnv {
+		for _, name := range names {
+			t.Setenv(name, "")
+		}
+	}
+	require.NoError(t, SetProxyEnv(map[string]string{"https-proxy": "http://proxy.example.com:3128", "no-proxy": "10.0.0.0/8,unifi.lan"}))
+
+	routes, warnings, err := ProxyRoutes("", []ProxyEndpoint{
+		{Name: "Cloudflare API", URL: CloudflareAPIURL},
+		{Name: "Slack webhook", URL: "https://hooks.slack.com/services/T000/B000/secret"},
+		{Name: "Harvester API server", URL: "https://10.0.0.5:6443", Local: true},
+		{Name: "UniFi controller", URL: "https://unifi.lan", Local: true},
+		{Name: "Harvester API server", URL: "https://harvester.example.com:6443", Local: true},
+		{Name: "gitops template", URL: "git@github.com:konstructio/gitops-template.git"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"Cloudflare API https://api.cloudflare.com: through proxy http://proxy.example.com:3128",
+		"Slack webhook https://hooks.slack.com: through proxy http://proxy.example.com:3128",
+		"Harvester API server https://10.0.0.5:6443: direct",
+		"UniFi controller https://unifi.lan: direct",
+		"Harvester API server https://harvester.example.com:6443: through proxy http://proxy.example.com:3128",
+		"gitops template git@github.com:konstructio/gitops-template.git: direct",
+	}, routes)
+	assert.Equal(t, []string{
+		"the Harvester API server https://harvester.example.com:6443 is reached through proxy http://proxy.example.com:3128, add its host to --no-proxy or NO_PROXY if it is on the LAN",
+	}, warnings)
+}
+
+func TestCheckProxyConnectivity(t *testing.T) {
+	t.Run("succeeds when the proxy answers", func(t *testing.T) {
+		var proxied bool
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			proxied = r.URL.Host == "gitops.example.com"
+		}))
+		defer proxy.Close()
+
+		err := CheckProxyConnectivity(context.Background(), proxy.URL, "http://gitops.example.com/template.git")
+		require.NoError(t, err)
+		assert.True(t, proxied)
+	})
+
+	t.Run("fails when the proxy is unreachable", func(t *testing.T) {
+		proxy := httptest.NewServer(http.NotFoundHandler())
+		proxy.Close()
+
+		err := CheckProxyConnectivity(context.Background(), proxy.URL, "http://gitops.example.com/template.git")
+		require.ErrorContains(t, err, "through proxy")
+	})
+
+	t.Run("skips ssh remotes", func(t *testing.T) {
+		err := CheckProxyConnectivity(context.Background(), "http://127.0.0.1:1", "git@github.com:konstructio/gitops-template.git")
+		require.NoError(t, err)
+	})
+}
+
+func newKubeconfig(t *testing.T, server string) string {
+	t.Helper()
+
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: harvester
+  cluster:
+    server: %s
+contexts:
+- name: harvester
+  context:
+    cluster: harvester
+current-context: harvester
+`, server)
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(path, []byte(kubeconfig), 0o600))
+
+	return path
+}
+
+func TestReadOnlyClients(t *testing.T) {
+	var mutated, loggedIn bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/auth/login":
+			loggedIn = true
+		case r.Method != http.MethodGet && !r.URL.Query().Has("dryRun"):
+			mutated = true
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"kind":"ApplicationList","apiVersion":"argoproj.io/v1alpha1","items":[]}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(newKubeconfig(t, server.URL), "", "")
+	require.NoError(t, err)
+
+	readonly.SetEnabled(true)
+	t.Cleanup(func() { readonly.SetEnabled(false) })
+
+	t.Run("kube clients can still read", func(t *testing.T) {
+		_, err := client.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).List(context.Background(), metav1.ListOptions{})
+		require.NoError(t, err)
+	})
+
+	t.Run("kube clients cannot patch", func(t *testing.T) {
+		_, err := client.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Patch(
+			context.Background(), "registry", types.MergePatchType, []byte(`{}`), metav1.PatchOptions{},
+		)
+		require.ErrorIs(t, err, readonly.ErrReadOnly)
+		assert.ErrorContains(t, err, "PATCH")
+	})
+
+	t.Run("dynamic client cannot apply", func(t *testing.T) {
+		err := client.applyObject(context.Background(), gatewayGVR, WildcardGateway([]string{"dev"}, "example.com", nil))
+		require.ErrorIs(t, err, readonly.ErrReadOnly)
+	})
+
+	t.Run("kube clients can still dry run", func(t *testing.T) {
+		_, err := client.ArgoCD.ArgoprojV1alpha1().Applications(ArgoCDNamespace).Patch(
+			context.Background(), "registry", types.MergePatchType, []byte(`{}`), metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}},
+		)
+		require.NoError(t, err)
+	})
+
+	t.Run("http client cannot post", func(t *testing.T) {
+		_, err := client.HTTPClient.Post(server.URL+"/v1/sys/auth/oidc", "application/json", nil)
+		require.ErrorIs(t, err, readonly.ErrReadOnly)
+	})
+
+	t.Run("gitops repository cannot be pushed", func(t *testing.T) {
+		repo := &GitopsRepo{URL: server.URL + "/gitops.git"}
+		_, err := repo.CommitFiles(context.Background(), map[string][]byte{"README.md": nil}, "update readme")
+		require.ErrorIs(t, err, readonly.ErrReadOnly)
+		assert.ErrorContains(t, err, "gitops.git")
+	})
+
+	t.Run("dns providers cannot change records", func(t *testing.T) {
+		cloudflare, err := NewCloudflareDNS("token", client.HTTPClient)
+		require.NoError(t, err)
+		cloudflare.apiURL = server.URL + "/"
+		err = cloudflare.UpdateARecord(context.Background(), &DNSRecord{ZoneID: "zone", ID: "record", Name: "argocd.example.com"}, "10.0.12.10")
+		require.ErrorIs(t, err, readonly.ErrReadOnly)
+
+		credentials := aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, nil
+		})
+		route53, err := NewRoute53DNS("/hostedzone/Z0123", credentials, client.HTTPClient)
+		require.NoError(t, err)
+		route53.apiURL = server.URL + "/"
+		err = route53.changeRecordSets(context.Background(), "kubefirst", nil)
+		require.ErrorIs(t, err, readonly.ErrReadOnly)
+	})
+
+	t.Run("unifi can log in but not forward ports", func(t *testing.T) {
+		controller, err := NewUniFiController(server.URL, "admin", "secret", client.HTTPClient)
+		require.NoError(t, err)
+		_, err = controller.UpsertPortForwards(context.Background(), []UniFiForward{{Name: "kubefirst-https", Enabled: true, Proto: "tcp", DstPort: "443", Fwd: "10.0.12.10", FwdPort: "443"}})
+		require.ErrorIs(t, err, readonly.ErrReadOnly)
+		assert.True(t, loggedIn)
+	})
+
+	assert.False(t, mutated)
+}
@@ -0,0 +1,416 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// TrustBundleName is the ConfigMap holding the --ca-cert certificates
+	// under TrustBundleKey, in TrustBundleNamespace of the host cluster
+	// and in the namespaces of the vclusters it is synced to
+	TrustBundleName      = "kubefirst-trust-bundle"
+	TrustBundleKey       = "ca.crt"
+	TrustBundleNamespace = GitopsHistorySecretNamespace
+
+	// TrustManagerApplication is the ArgoCD application installing
+	// trust-manager, which projects the trust bundle into the host
+	// namespaces as the ConfigMap TrustManagerBundle
+	TrustManagerApplication = "trust-manager"
+	TrustManagerVersion     = "v0.12.0"
+	TrustManagerBundle      = "kubefirst-ca-bundle"
+
+	// TrustBundleProbe is the Job checking from a vcluster that the trust
+	// bundle verifies --trust-bundle-probe-url
+	TrustBundleProbe = "kubefirst-trust-probe"
+
+	// DefaultTrustBundleProbeTimeout leaves the probe image time to pull
+	DefaultTrustBundleProbeTimeout = 3 * time.Minute
+
+	trustManagerRepo      = "https://charts.jetstack.io"
+	trustManagerNamespace = "cert-manager"
+	trustBundleProbeImage = "curlimages/curl:8.10.1"
+	trustBundleMountPath  = "/etc/kubefirst/trust"
+)
+
+// TrustBundleTarget is a namespace of a vcluster the trust bundle is synced
+// into
+type TrustBundleTarget struct {
+	VCluster  string
+	Namespace string
+}
+
+func (t TrustBundleTarget) String() string {
+	return t.VCluster + "/" + t.Namespace
+}
+
+// trustBundleHostName is the host ConfigMap the vclusters sync into the
+// virtual namespace, as a host object is synced to a single one
+func trustBundleHostName(namespace string) string {
+	return TrustBundleName + "-" + namespace
+}
+
+// ParseTrustBundleTargets parses the <vcluster>[/<namespace>] entries of
+// --trust-bundle-target, the namespace defaulting to default. Without
+// entries every vcluster gets the bundle in its default namespace
+func ParseTrustBundleTargets(vclusters, entries []string) ([]TrustBundleTarget, error) {
+	if len(entries) == 0 {
+		targets := make([]TrustBundleTarget, 0, len(vclusters))
+		for _, vcluster := range vclusters {
+			targets = append(targets, TrustBundleTarget{VCluster: vcluster, Namespace: "default"})
+		}
+		return targets, nil
+	}
+
+	var targets []TrustBundleTarget
+	for _, entry := range entries {
+		vcluster, namespace, ok := strings.Cut(entry, "/")
+		if !ok {
+			namespace = "default"
+		}
+		if !slices.Contains(vclusters, vcluster) {
+			return nil, fmt.Errorf("%q in %q does not match any vcluster in --vclusters %v", vcluster, entry, vclusters)
+		}
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q in %q: %s", namespace, entry, strings.Join(errs, ", "))
+		}
+
+		target := TrustBundleTarget{VCluster: vcluster, Namespace: namespace}
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+// ParseTrustBundleSelector parses --trust-bundle-namespace-selector, nil
+// selecting every namespace
+func ParseTrustBundleSelector(selector string) (*metav1.LabelSelector, error) {
+	if selector == "" {
+		return nil, nil
+	}
+
+	parsed, err := metav1.ParseToLabelSelector(selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+	}
+
+	return parsed, nil
+}
+
+// trustBundleValues returns the chart values of vcluster syncing the host
+// ConfigMaps of its targets into its virtual namespaces
+func trustBundleValues(vcluster string, targets []TrustBundleTarget) []chartValue {
+	byName := map[string]interface{}{}
+	for _, target := range targets {
+		if target.VCluster == vcluster {
+			byName[path.Join(TrustBundleNamespace, trustBundleHostName(target.Namespace))] = path.Join(target.Namespace, TrustBundleName)
+		}
+	}
+	if len(byName) == 0 {
+		return nil
+	}
+
+	return []chartValue{
+		{path: []string{"sync", "fromHost", "configMaps", "enabled"}, value: true},
+		{path: []string{"sync", "fromHost", "configMaps", "mappings", "byName"}, value: byName},
+	}
+}
+
+// TrustBundleValues adds the syncing of the trust bundle into the targets
+// of vcluster to its chart values
+func TrustBundleValues(values []byte, vcluster string, targets []TrustBundleTarget) ([]byte, error) {
+	set := trustBundleValues(vcluster, targets)
+	if len(set) == 0 {
+		return values, nil
+	}
+
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(values, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse vcluster values: %w", err)
+	}
+	for _, v := range set {
+		setValue(merged, v.value, v.path...)
+	}
+
+	rendered, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster values: %w", err)
+	}
+
+	return rendered, nil
+}
+
+// TrustBundleFiles sets the syncing of the trust bundle in the helm values
+// of the vcluster ArgoCD applications of files, keyed by their path in the
+// gitops repository. Only the files that changed are returned
+func TrustBundleFiles(files map[string][]byte, targets []TrustBundleTarget) (map[string][]byte, error) {
+	if len(targets) == 0 {
+		return map[string][]byte{}, nil
+	}
+
+	return patchChartValues(files, func(application, chart string) []chartValue {
+		if chart != "vcluster" {
+			return nil
+		}
+
+		return trustBundleValues(application, targets)
+	})
+}
+
+// ApplyTrustBundle applies bundle as the ConfigMap TrustBundleName of
+// TrustBundleNamespace, and as the host ConfigMap the vclusters sync into
+// every namespace of targets
+func (c *Client) ApplyTrustBundle(ctx context.Context, bundle []byte, targets []TrustBundleTarget) error {
+	namespace := corev1apply.Namespace(TrustBundleNamespace)
+	if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, namespace, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return fmt.Errorf("failed to apply namespace %s: %w", TrustBundleNamespace, err)
+	}
+
+	names := []string{TrustBundleName}
+	for _, target := range targets {
+		if name := trustBundleHostName(target.Namespace); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		configMap := corev1apply.ConfigMap(name, TrustBundleNamespace).
+			WithData(map[string]string{TrustBundleKey: string(bundle)})
+		if _, err := c.Clientset.CoreV1().ConfigMaps(TrustBundleNamespace).Apply(ctx, configMap, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+			return fmt.Errorf("failed to apply configmap %s/%s: %w", TrustBundleNamespace, name, err)
+		}
+	}
+
+	return nil
+}
+
+// TrustManagerManifests renders the ArgoCD application installing
+// trust-manager, trusting TrustBundleNamespace, and the Bundle projecting
+// the trust bundle along with the public CAs into the host namespaces of
+// selector, every namespace when nil
+func TrustManagerManifests(selector *metav1.LabelSelector) ([]byte, error) {
+	target := map[string]interface{}{
+		"configMap": map[string]interface{}{"key": TrustBundleKey},
+	}
+	if selector != nil {
+		namespaceSelector := map[string]interface{}{}
+		if len(selector.MatchLabels) > 0 {
+			namespaceSelector["matchLabels"] = selector.MatchLabels
+		}
+		if len(selector.MatchExpressions) > 0 {
+			var expressions []interface{}
+			for _, expression := range selector.MatchExpressions {
+				rendered := map[string]interface{}{"key": expression.Key, "operator": string(expression.Operator)}
+				if len(expression.Values) > 0 {
+					rendered["values"] = expression.Values
+				}
+				expressions = append(expressions, rendered)
+			}
+			namespaceSelector["matchExpressions"] = expressions
+		}
+		target["namespaceSelector"] = namespaceSelector
+	}
+
+	documents := []map[string]interface{}{
+		{
+			"apiVersion": "argoproj.io/v1alpha1",
+			"kind":       "Application",
+			"metadata": map[string]interface{}{
+				"name":      TrustManagerApplication,
+				"namespace": ArgoCDNamespace,
+			},
+			"spec": map[string]interface{}{
+				"project": "default",
+				"source": map[string]interface{}{
+					"repoURL":        trustManagerRepo,
+					"chart":          TrustManagerApplication,
+					"targetRevision": TrustManagerVersion,
+					"helm": map[string]interface{}{"valuesObject": map[string]interface{}{
+						"app": map[string]interface{}{"trust": map[string]interface{}{"namespace": TrustBundleNamespace}},
+					}},
+				},
+				"destination": map[string]interface{}{
+					"server":    "https://kubernetes.default.svc",
+					"namespace": trustManagerNamespace,
+				},
+				"syncPolicy": map[string]interface{}{
+					"automated":   map[string]interface{}{"prune": true, "selfHeal": true},
+					"syncOptions": []string{"CreateNamespace=true", "ServerSideApply=true"},
+				},
+			},
+		},
+		{
+			"apiVersion": "trust.cert-manager.io/v1alpha1",
+			"kind":       "Bundle",
+			"metadata": map[string]interface{}{
+				"name": TrustManagerBundle,
+				"annotations": map[string]string{
+					// the Bundle CRD comes with the trust-manager application
+					"argocd.argoproj.io/sync-wave":    "1",
+					"argocd.argoproj.io/sync-options": "SkipDryRunOnMissingResource=true",
+				},
+			},
+			"spec": map[string]interface{}{
+				"sources": []interface{}{
+					map[string]interface{}{"useDefaultCAs": true},
+					map[string]interface{}{"configMap": map[string]interface{}{"name": TrustBundleName, "key": TrustBundleKey}},
+				},
+				"target": target,
+			},
+		},
+	}
+
+	manifests, err := encodeDocuments(documents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render trust-manager manifests: %w", err)
+	}
+
+	return manifests, nil
+}
+
+// TrustBundleProbeJob is the Job fetching url with the trust bundle of
+// namespace as its only CA certificates, so it fails unless the bundle
+// verifies the certificate of url
+func TrustBundleProbeJob(namespace, url, registryMirror string) *batchv1.Job {
+	backoffLimit := int32(0)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: TrustBundleProbe, Namespace: namespace},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:    "probe",
+						Image:   MirrorImage(trustBundleProbeImage, registryMirror),
+						Command: []string{"curl", "--fail", "--silent", "--show-error", "--output", "/dev/null", "--cacert", path.Join(trustBundleMountPath, TrustBundleKey), url},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "trust-bundle",
+							MountPath: trustBundleMountPath,
+							ReadOnly:  true,
+						}},
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "trust-bundle",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: TrustBundleName}},
+						},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// RunTrustBundleProbe runs TrustBundleProbeJob in the namespace of target,
+// inside its vcluster reached through a port-forward to its API, and waits
+// for it to finish. A failed probe is an error carrying its logs
+func (c *Client) RunTrustBundleProbe(ctx context.Context, target TrustBundleTarget, url string) error {
+	clientset, stop, err := c.vclusterClientset(ctx, target.VCluster)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	return runTrustBundleProbe(ctx, clientset, TrustBundleProbeJob(target.Namespace, url, c.RegistryMirror))
+}
+
+func runTrustBundleProbe(ctx context.Context, clientset kubernetes.Interface, job *batchv1.Job) error {
+	jobs := clientset.BatchV1().Jobs(job.Namespace)
+
+	background := metav1.DeletePropagationBackground
+	if err := jobs.Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &background}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete previous job %s/%s: %w", job.Namespace, job.Name, err)
+	}
+
+	ticker := time.NewTicker(nodePollInterval)
+	defer ticker.Stop()
+
+	created := false
+	for {
+		if !created {
+			_, err := jobs.Create(ctx, job, metav1.CreateOptions{FieldManager: fieldManager})
+			// the previous job may still be deleting
+			if err != nil && !apierrors.IsAlreadyExists(err) {
+				return fmt.Errorf("failed to create job %s/%s: %w", job.Namespace, job.Name, err)
+			}
+			created = err == nil
+		}
+
+		if created {
+			current, err := jobs.Get(ctx, job.Name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("failed to read job %s/%s: %w", job.Namespace, job.Name, err)
+			}
+			if current.Status.Succeeded > 0 {
+				return nil
+			}
+			if current.Status.Failed > 0 {
+				return fmt.Errorf("TLS handshake of probe job %s/%s failed:\n%s", job.Namespace, job.Name, jobPodLogs(ctx, clientset, job.Namespace, job.Name))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("probe job %s/%s did not complete, check that the vcluster synced configmap %s: %w", job.Namespace, job.Name, TrustBundleName, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// vclusterClientset forwards a local port to the API of vcluster and
+// returns a clientset of it, authenticated with the kubeconfig of its
+// vc-<name> secret. stop ends the port-forward
+func (c *Client) vclusterClientset(ctx context.Context, vcluster string) (kubernetes.Interface, func(), error) {
+	target, err := ResolveConnectTarget(connectVClusterPrefix+vcluster, false, false, []string{vcluster})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	localPort, stop, err := c.forwardLocal(ctx, target)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to forward to the API of vcluster %q: %w", vcluster, err)
+	}
+
+	kubeconfig, err := kubeconfigFromSecret(ctx, c.Clientset, fmt.Sprintf("%s/vc-%s:config", target.Namespace, vcluster))
+	if err != nil {
+		stop()
+		return nil, nil, err
+	}
+	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+	if err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("failed to load the kubeconfig of vcluster %q: %w", vcluster, err)
+	}
+	restConfig.Host = target.URL(localPort)
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		stop()
+		return nil, nil, fmt.Errorf("failed to create kubernetes client of vcluster %q: %w", vcluster, err)
+	}
+
+	return clientset, stop, nil
+}
@@ -0,0 +1,100 @@
+package harvester
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestParseTrustBundleTargets(t *testing.T) {
+	vclusters := []string{"dev", "prod"}
+
+	targets, err := ParseTrustBundleTargets(vclusters, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []TrustBundleTarget{{VCluster: "dev", Namespace: "default"}, {VCluster: "prod", Namespace: "default"}}, targets)
+
+	targets, err = ParseTrustBundleTargets(vclusters, []string{"dev", "prod/payments", "dev/default"})
+	require.NoError(t, err)
+	assert.Equal(t, []TrustBundleTarget{{VCluster: "dev", Namespace: "default"}, {VCluster: "prod", Namespace: "payments"}}, targets)
+
+	_, err = ParseTrustBundleTargets(vclusters, []string{"staging"})
+	require.ErrorContains(t, err, "does not match any vcluster")
+	_, err = ParseTrustBundleTargets(vclusters, []string{"dev/Payments"})
+	require.ErrorContains(t, err, "invalid namespace")
+}
+
+func TestTrustBundleValues(t *testing.T) {
+	targets := []TrustBundleTarget{{VCluster: "dev", Namespace: "default"}, {VCluster: "dev", Namespace: "payments"}, {VCluster: "prod", Namespace: "default"}}
+
+	rendered, err := TrustBundleValues([]byte("controlPlane:\n  distro:\n    k3s:\n      enabled: true\n"), "dev", targets)
+	require.NoError(t, err)
+
+	values := map[string]interface{}{}
+	require.NoError(t, yaml.Unmarshal(rendered, &values))
+	assert.Equal(t, true, lookupValue(values, "controlPlane", "distro", "k3s", "enabled"))
+	assert.Equal(t, true, lookupValue(values, "sync", "fromHost", "configMaps", "enabled"))
+	assert.Equal(t, map[string]interface{}{
+		"kubefirst/kubefirst-trust-bundle-default":  "default/kubefirst-trust-bundle",
+		"kubefirst/kubefirst-trust-bundle-payments": "payments/kubefirst-trust-bundle",
+	}, lookupValue(values, "sync", "fromHost", "configMaps", "mappings", "byName"))
+
+	unchanged, err := TrustBundleValues([]byte("{}\n"), "staging", targets)
+	require.NoError(t, err)
+	assert.Equal(t, "{}\n", string(unchanged))
+}
+
+func TestApplyTrustBundle(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset()}
+	ctx := context.Background()
+
+	targets := []TrustBundleTarget{{VCluster: "dev", Namespace: "default"}, {VCluster: "prod", Namespace: "default"}}
+	require.NoError(t, client.ApplyTrustBundle(ctx, []byte("PEM"), targets))
+
+	configMaps, err := client.Clientset.CoreV1().ConfigMaps(TrustBundleNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, configMaps.Items, 2)
+	for _, configMap := range configMaps.Items {
+		assert.Equal(t, "PEM", configMap.Data[TrustBundleKey])
+	}
+}
+
+func TestTrustManagerManifests(t *testing.T) {
+	selector, err := ParseTrustBundleSelector("team in (payments,search),kubefirst.io/trust")
+	require.NoError(t, err)
+
+	manifests, err := TrustManagerManifests(selector)
+	require.NoError(t, err)
+	documents, err := decodeDocuments(manifests)
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+
+	assert.Equal(t, TrustBundleNamespace, lookupValue(documents[0], "spec", "source", "helm", "valuesObject", "app", "trust", "namespace"))
+	target := lookupValue(documents[1], "spec", "target").(map[string]interface{})
+	assert.Equal(t, TrustBundleKey, lookupValue(target, "configMap", "key"))
+	assert.Len(t, lookupValue(target, "namespaceSelector", "matchExpressions"), 2)
+
+	_, err = ParseTrustBundleSelector("team in")
+	require.Error(t, err)
+}
+
+func TestRunTrustBundleProbe(t *testing.T) {
+	clientset := fake.NewClientset()
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).Status.Failed = 1
+		return false, nil, nil
+	})
+
+	job := TrustBundleProbeJob("default", "https://gitlab.internal", "")
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command, "/etc/kubefirst/trust/ca.crt")
+
+	err := runTrustBundleProbe(context.Background(), clientset, job)
+	require.ErrorContains(t, err, "TLS handshake of probe job default/kubefirst-trust-probe failed")
+}
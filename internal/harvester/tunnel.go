@@ -0,0 +1,438 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// Ingress modes, as accepted by --ingress-mode
+const (
+	// IngressModeUniFi forwards the WAN ports of the UniFi controller to
+	// the ingress
+	IngressModeUniFi = "unifi"
+	// IngressModeCloudflareTunnel publishes the ingress through a
+	// Cloudflare Tunnel, opening no WAN port
+	IngressModeCloudflareTunnel = "cloudflare-tunnel"
+	// IngressModeNone only points the DNS records at the internal
+	// load balancer addresses
+	IngressModeNone = "none"
+)
+
+var IngressModes = []string{IngressModeUniFi, IngressModeCloudflareTunnel, IngressModeNone}
+
+const (
+	// CloudflaredNamespace is the namespace of the cloudflared connectors
+	CloudflaredNamespace = "cloudflared"
+
+	cloudflaredSecret   = "cloudflared-tunnel"
+	cloudflaredImage    = "cloudflare/cloudflared:2025.4.2"
+	cloudflaredReplicas = 2
+	tunnelDomain        = "cfargotunnel.com"
+)
+
+// ValidateIngressMode ensures mode is a supported ingress mode and the
+// flags it needs are set: the UniFi controller in the unifi mode, the
+// Cloudflare DNS provider in the cloudflare-tunnel mode
+func ValidateIngressMode(mode, dnsProvider, unifiHost, unifiPassword string) error {
+	switch mode {
+	case IngressModeUniFi:
+		if unifiHost == "" {
+			return errors.New(`required flag "unifi-host" not set, it is required with --ingress-mode unifi`)
+		}
+		if unifiPassword == "" {
+			return errors.New(`required flag "unifi-password" not set, it is required with --ingress-mode unifi`)
+		}
+	case IngressModeCloudflareTunnel:
+		if dnsProvider != "cloudflare" {
+			return fmt.Errorf("--ingress-mode %s requires --dns-provider cloudflare, got %q", mode, dnsProvider)
+		}
+	case IngressModeNone:
+	default:
+		return fmt.Errorf("unknown ingress mode %q, must be one of %v", mode, IngressModes)
+	}
+
+	return nil
+}
+
+// IngressModeOf returns the ingress mode recorded as mode, clusters created
+// before --ingress-mode existed being published through UniFi
+func IngressModeOf(mode string) string {
+	if mode == "" {
+		return IngressModeUniFi
+	}
+
+	return mode
+}
+
+// CloudflareTunnel is the Cloudflare Tunnel publishing the ingress of a
+// cluster in the cloudflare-tunnel mode
+type CloudflareTunnel struct {
+	AccountID string
+	ID        string
+	Name      string
+}
+
+// TunnelName is the name of the Cloudflare Tunnel of clusterName
+func TunnelName(clusterName string) string {
+	return "kubefirst-" + clusterName
+}
+
+// ParseCloudflareTunnel parses a tunnel recorded as account/id
+func ParseCloudflareTunnel(recorded string) (CloudflareTunnel, error) {
+	account, id, ok := strings.Cut(recorded, "/")
+	if !ok || account == "" || id == "" {
+		return CloudflareTunnel{}, fmt.Errorf("%q is not an account/id cloudflare tunnel", recorded)
+	}
+
+	return CloudflareTunnel{AccountID: account, ID: id}, nil
+}
+
+func (t CloudflareTunnel) String() string {
+	return t.AccountID + "/" + t.ID
+}
+
+// Target is the host the CNAME records of the tunnel point at
+func (t CloudflareTunnel) Target() string {
+	return t.ID + "." + tunnelDomain
+}
+
+func (t CloudflareTunnel) path(suffix string) string {
+	return fmt.Sprintf("accounts/%s/cfd_tunnel/%s%s", t.AccountID, t.ID, suffix)
+}
+
+// TunnelAccount returns the Cloudflare account owning the zone of host,
+// the one the tunnel of its records is created in
+func (d *CloudflareDNS) TunnelAccount(ctx context.Context, host string) (string, error) {
+	zoneID, err := d.zoneID(ctx, host)
+	if err != nil {
+		return "", err
+	}
+
+	var zone struct {
+		Account struct {
+			ID string `json:"id"`
+		} `json:"account"`
+	}
+	if err := d.request(ctx, "Cloudflare zone lookup", http.MethodGet, "zones/"+zoneID, nil, &zone); err != nil {
+		return "", fmt.Errorf("failed to look up the account of zone %s: %w", zoneID, err)
+	}
+	if zone.Account.ID == "" {
+		return "", fmt.Errorf("zone %s of %q has no account", zoneID, host)
+	}
+
+	return zone.Account.ID, nil
+}
+
+// EnsureTunnel returns the tunnel accountID has under name, creating it
+// when missing. Its ingress rules are managed through the Cloudflare API
+func (d *CloudflareDNS) EnsureTunnel(ctx context.Context, accountID, name string) (CloudflareTunnel, error) {
+	find := func(ctx context.Context) (CloudflareTunnel, bool, error) {
+		var tunnels []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		}
+		query := url.Values{"name": {name}, "is_deleted": {"false"}}
+		if err := d.request(ctx, "Cloudflare tunnel lookup", http.MethodGet, fmt.Sprintf("accounts/%s/cfd_tunnel?%s", accountID, query.Encode()), nil, &tunnels); err != nil {
+			return CloudflareTunnel{}, false, fmt.Errorf("failed to look up tunnel %q: %w", name, err)
+		}
+		for _, tunnel := range tunnels {
+			if tunnel.Name == name {
+				return CloudflareTunnel{AccountID: accountID, ID: tunnel.ID, Name: name}, true, nil
+			}
+		}
+		return CloudflareTunnel{}, false, nil
+	}
+
+	tunnel, ok, err := find(ctx)
+	if err != nil || ok {
+		return tunnel, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return CloudflareTunnel{}, fmt.Errorf("failed to generate the tunnel secret: %w", err)
+	}
+	encoded, err := json.Marshal(map[string]string{
+		"name":          name,
+		"config_src":    "cloudflare",
+		"tunnel_secret": base64.StdEncoding.EncodeToString(secret),
+	})
+	if err != nil {
+		return CloudflareTunnel{}, fmt.Errorf("failed to encode cloudflare request: %w", err)
+	}
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	err = d.Retry.DoUnlessExists(ctx, "Cloudflare tunnel creation", func(ctx context.Context) error {
+		return d.send(ctx, http.MethodPost, fmt.Sprintf("accounts/%s/cfd_tunnel", accountID), encoded, &created)
+	}, func(ctx context.Context) (bool, error) {
+		tunnel, ok, err := find(ctx)
+		created.ID = tunnel.ID
+		return ok, err
+	})
+	if err != nil {
+		return CloudflareTunnel{}, fmt.Errorf("failed to create tunnel %q: %w", name, err)
+	}
+
+	return CloudflareTunnel{AccountID: accountID, ID: created.ID, Name: name}, nil
+}
+
+// TunnelToken returns the token cloudflared runs tunnel with
+func (d *CloudflareDNS) TunnelToken(ctx context.Context, tunnel CloudflareTunnel) (string, error) {
+	var token string
+	if err := d.request(ctx, "Cloudflare tunnel token lookup", http.MethodGet, tunnel.path("/token"), nil, &token); err != nil {
+		return "", fmt.Errorf("failed to read the token of tunnel %s: %w", tunnel.ID, err)
+	}
+
+	return token, nil
+}
+
+// TunnelIngress renders the ingress rules of a tunnel forwarding every
+// host of origins to the address of the ingress serving it over https,
+// sending the host as SNI, and answering 404 to anything else
+func TunnelIngress(origins map[string]string) []map[string]interface{} {
+	hosts := sortedKeys(origins)
+	// cloudflared matches the rules in order, exact hosts go before the
+	// wildcards that would shadow them
+	sort.SliceStable(hosts, func(i, j int) bool {
+		return !strings.HasPrefix(hosts[i], "*.") && strings.HasPrefix(hosts[j], "*.")
+	})
+
+	rules := make([]map[string]interface{}, 0, len(hosts)+1)
+	for _, host := range hosts {
+		rules = append(rules, map[string]interface{}{
+			"hostname":      host,
+			"service":       "https://" + origins[host],
+			"originRequest": map[string]interface{}{"matchSNItoHost": true},
+		})
+	}
+
+	return append(rules, map[string]interface{}{"service": "http_status:404"})
+}
+
+// ConfigureTunnel replaces the ingress rules of tunnel with the ones of
+// TunnelIngress
+func (d *CloudflareDNS) ConfigureTunnel(ctx context.Context, tunnel CloudflareTunnel, origins map[string]string) error {
+	body := map[string]interface{}{"config": map[string]interface{}{"ingress": TunnelIngress(origins)}}
+	if err := d.request(ctx, "Cloudflare tunnel configuration", http.MethodPut, tunnel.path("/configurations"), body, nil); err != nil {
+		return fmt.Errorf("failed to configure tunnel %s: %w", tunnel.ID, err)
+	}
+
+	return nil
+}
+
+// ReconcileTunnelRecords points a proxied CNAME record of every host at
+// tunnel, creating the missing ones with comment. The A records carrying
+// comment an earlier mode created are replaced, records without it are
+// left as they are. The zones of the hosts and knownZones are returned for
+// destroy to find the records in
+func (d *CloudflareDNS) ReconcileTunnelRecords(ctx context.Context, tunnel CloudflareTunnel, hosts []string, comment string, knownZones []string) (DNSReconcileResult, error) {
+	var result DNSReconcileResult
+	zones := map[string]bool{}
+	for _, zoneID := range knownZones {
+		zones[zoneID] = true
+	}
+
+	for _, host := range hosts {
+		zoneID, err := d.zoneID(ctx, host)
+		if err != nil {
+			return result, err
+		}
+		zones[zoneID] = true
+
+		records, err := d.records(ctx, zoneID, url.Values{"name": {host}})
+		if err != nil {
+			return result, fmt.Errorf("failed to look up the records of %q: %w", host, err)
+		}
+
+		if slices.ContainsFunc(records, func(record DNSRecord) bool { return record.Comment != comment }) {
+			result.Unmanaged = append(result.Unmanaged, host)
+			continue
+		}
+
+		current := false
+		for _, record := range records {
+			if record.Type == "CNAME" && record.Content == tunnel.Target() && !current {
+				current = true
+				continue
+			}
+			if err := d.DeleteRecord(ctx, record); err != nil {
+				return result, err
+			}
+		}
+		if current {
+			continue
+		}
+
+		if err := d.createTunnelRecord(ctx, zoneID, host, tunnel, comment); err != nil {
+			return result, err
+		}
+		if len(records) > 0 {
+			result.Updated = append(result.Updated, host)
+		} else {
+			result.Created = append(result.Created, host)
+		}
+	}
+	result.Zones = sortedKeys(zones)
+
+	return result, nil
+}
+
+func (d *CloudflareDNS) createTunnelRecord(ctx context.Context, zoneID, host string, tunnel CloudflareTunnel, comment string) error {
+	encoded, err := json.Marshal(map[string]interface{}{
+		"type":    "CNAME",
+		"name":    host,
+		"content": tunnel.Target(),
+		"ttl":     1,
+		"proxied": true,
+		"comment": comment,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode cloudflare request: %w", err)
+	}
+
+	err = d.Retry.DoUnlessExists(ctx, "Cloudflare record creation", func(ctx context.Context) error {
+		return d.send(ctx, http.MethodPost, fmt.Sprintf("zones/%s/dns_records", zoneID), encoded, nil)
+	}, func(ctx context.Context) (bool, error) {
+		records, err := d.records(ctx, zoneID, url.Values{"type": {"CNAME"}, "name": {host}, "content": {tunnel.Target()}})
+		return len(records) > 0, err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create the CNAME record of %q: %w", host, err)
+	}
+
+	return nil
+}
+
+// DeleteTunnel deletes the CNAME records carrying comment that point at
+// tunnel in zones, then the tunnel itself once its connectors are cleaned
+// up, returning the hosts of the records deleted. A tunnel already gone is
+// no error
+func (d *CloudflareDNS) DeleteTunnel(ctx context.Context, tunnel CloudflareTunnel, zones []string, comment string) ([]string, error) {
+	var deleted []string
+	for _, zoneID := range zones {
+		records, err := d.records(ctx, zoneID, url.Values{"type": {"CNAME"}, "content": {tunnel.Target()}})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list the tunnel records of zone %s: %w", zoneID, err)
+		}
+		for _, record := range records {
+			if record.Comment != comment || record.Content != tunnel.Target() {
+				continue
+			}
+			if err := d.DeleteRecord(ctx, record); err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, record.Name)
+		}
+	}
+	sort.Strings(deleted)
+
+	var current []struct {
+		ID string `json:"id"`
+	}
+	query := url.Values{"uuid": {tunnel.ID}, "is_deleted": {"false"}}
+	if err := d.request(ctx, "Cloudflare tunnel lookup", http.MethodGet, fmt.Sprintf("accounts/%s/cfd_tunnel?%s", tunnel.AccountID, query.Encode()), nil, &current); err != nil {
+		return deleted, fmt.Errorf("failed to look up tunnel %s: %w", tunnel.ID, err)
+	}
+	if len(current) == 0 {
+		return deleted, nil
+	}
+
+	if err := d.request(ctx, "Cloudflare tunnel connection cleanup", http.MethodDelete, tunnel.path("/connections"), nil, nil); err != nil {
+		return deleted, fmt.Errorf("failed to clean up the connections of tunnel %s: %w", tunnel.ID, err)
+	}
+	if err := d.request(ctx, "Cloudflare tunnel deletion", http.MethodDelete, tunnel.path(""), nil, nil); err != nil {
+		return deleted, fmt.Errorf("failed to delete tunnel %s: %w", tunnel.ID, err)
+	}
+
+	return deleted, nil
+}
+
+// ApplyCloudflaredToken stores the token of the tunnel where the
+// cloudflared connectors read it. It is kept out of the gitops repository
+func (c *Client) ApplyCloudflaredToken(ctx context.Context, token string) error {
+	namespace := corev1apply.Namespace(CloudflaredNamespace)
+	if _, err := c.Clientset.CoreV1().Namespaces().Apply(ctx, namespace, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return fmt.Errorf("failed to apply namespace %s: %w", CloudflaredNamespace, err)
+	}
+
+	secret := corev1apply.Secret(cloudflaredSecret, CloudflaredNamespace).
+		WithStringData(map[string]string{"token": token})
+	if _, err := c.Clientset.CoreV1().Secrets(CloudflaredNamespace).Apply(ctx, secret, metav1.ApplyOptions{FieldManager: fieldManager, Force: true}); err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", CloudflaredNamespace, cloudflaredSecret, err)
+	}
+
+	return nil
+}
+
+// CloudflaredManifests renders the cloudflared connectors of the tunnel,
+// reading its token from the secret ApplyCloudflaredToken stores
+func CloudflaredManifests(registryMirror string) ([]byte, error) {
+	labels := map[string]interface{}{"app.kubernetes.io/name": "cloudflared"}
+	container := map[string]interface{}{
+		"name":  "cloudflared",
+		"image": MirrorImage(cloudflaredImage, registryMirror),
+		"args":  []interface{}{"tunnel", "--no-autoupdate", "--metrics", "0.0.0.0:2000", "run"},
+		"env": []interface{}{map[string]interface{}{
+			"name": "TUNNEL_TOKEN",
+			"valueFrom": map[string]interface{}{"secretKeyRef": map[string]interface{}{
+				"name": cloudflaredSecret,
+				"key":  "token",
+			}},
+		}},
+		"readinessProbe": map[string]interface{}{
+			"httpGet": map[string]interface{}{"path": "/ready", "port": 2000},
+		},
+		"securityContext": map[string]interface{}{
+			"allowPrivilegeEscalation": false,
+			"readOnlyRootFilesystem":   true,
+			"runAsNonRoot":             true,
+			"capabilities":             map[string]interface{}{"drop": []interface{}{"ALL"}},
+		},
+	}
+
+	manifests, err := encodeDocuments([]map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": CloudflaredNamespace},
+		},
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "cloudflared", "namespace": CloudflaredNamespace},
+			"spec": map[string]interface{}{
+				"replicas": cloudflaredReplicas,
+				"selector": map[string]interface{}{"matchLabels": labels},
+				"template": map[string]interface{}{
+					"metadata": map[string]interface{}{"labels": labels},
+					"spec":     map[string]interface{}{"containers": []interface{}{container}},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render cloudflared: %w", err)
+	}
+
+	return manifests, nil
+}
@@ -0,0 +1,219 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type fakeTunnelAPI struct {
+	tunnels    map[string]string
+	records    []map[string]interface{}
+	configured map[string]interface{}
+	deleted    []string
+}
+
+func newFakeTunnelAPI(t *testing.T, api *fakeTunnelAPI) *CloudflareDNS {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := "{}"
+		query := r.URL.Query()
+		path := strings.Trim(r.URL.Path, "/")
+		switch {
+		case path == "zones":
+			result = "[]"
+			if query.Get("name") == "example.com" {
+				result = `[{"id":"zone"}]`
+			}
+		case path == "zones/zone":
+			result = `{"id":"zone","account":{"id":"account"}}`
+		case path == "zones/zone/dns_records" && r.Method == http.MethodGet:
+			var matching []map[string]interface{}
+			for _, record := range api.records {
+				if (query.Get("name") == "" || record["name"] == query.Get("name")) &&
+					(query.Get("type") == "" || record["type"] == query.Get("type")) &&
+					(query.Get("content") == "" || record["content"] == query.Get("content")) {
+					matching = append(matching, record)
+				}
+			}
+			data, err := json.Marshal(matching)
+			require.NoError(t, err)
+			result = string(data)
+		case path == "zones/zone/dns_records" && r.Method == http.MethodPost:
+			record := map[string]interface{}{"id": fmt.Sprintf("new-%d", len(api.records))}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&record))
+			api.records = append(api.records, record)
+		case strings.HasPrefix(path, "zones/zone/dns_records/") && r.Method == http.MethodDelete:
+			for i, record := range api.records {
+				if record["id"] == strings.TrimPrefix(path, "zones/zone/dns_records/") {
+					api.records = append(api.records[:i], api.records[i+1:]...)
+					break
+				}
+			}
+		case path == "accounts/account/cfd_tunnel" && r.Method == http.MethodGet:
+			result = "[]"
+			for id, name := range api.tunnels {
+				if query.Get("name") == name || query.Get("uuid") == id {
+					result = fmt.Sprintf(`[{"id":%q,"name":%q}]`, id, name)
+				}
+			}
+		case path == "accounts/account/cfd_tunnel" && r.Method == http.MethodPost:
+			var body map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, "cloudflare", body["config_src"])
+			assert.NotEmpty(t, body["tunnel_secret"])
+			api.tunnels["tunnel"] = body["name"]
+			result = `{"id":"tunnel"}`
+		case path == "accounts/account/cfd_tunnel/tunnel/token":
+			result = `"secret-token"`
+		case path == "accounts/account/cfd_tunnel/tunnel/configurations":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&api.configured))
+		case strings.HasPrefix(path, "accounts/account/cfd_tunnel/tunnel") && r.Method == http.MethodDelete:
+			api.deleted = append(api.deleted, path)
+			if path == "accounts/account/cfd_tunnel/tunnel" {
+				delete(api.tunnels, "tunnel")
+			}
+		}
+
+		w.Write([]byte(`{"success":true,"errors":[],"result":` + result + `}`))
+	}))
+	t.Cleanup(server.Close)
+
+	dns, err := NewCloudflareDNS("token", server.Client())
+	require.NoError(t, err)
+	dns.apiURL = server.URL + "/"
+
+	return dns
+}
+
+func TestValidateIngressMode(t *testing.T) {
+	require.NoError(t, ValidateIngressMode(IngressModeUniFi, "cloudflare", "192.168.1.1", "password"))
+	require.ErrorContains(t, ValidateIngressMode(IngressModeUniFi, "cloudflare", "", "password"), `"unifi-host" not set`)
+	require.ErrorContains(t, ValidateIngressMode(IngressModeUniFi, "cloudflare", "192.168.1.1", ""), `"unifi-password" not set`)
+	require.NoError(t, ValidateIngressMode(IngressModeCloudflareTunnel, "cloudflare", "", ""))
+	require.ErrorContains(t, ValidateIngressMode(IngressModeCloudflareTunnel, "route53", "", ""), "requires --dns-provider cloudflare")
+	require.NoError(t, ValidateIngressMode(IngressModeNone, "cloudflare", "", ""))
+	require.ErrorContains(t, ValidateIngressMode("ngrok", "cloudflare", "", ""), `unknown ingress mode "ngrok"`)
+
+	assert.Equal(t, IngressModeUniFi, IngressModeOf(""))
+	assert.Equal(t, IngressModeNone, IngressModeOf(IngressModeNone))
+}
+
+func TestParseCloudflareTunnel(t *testing.T) {
+	tunnel, err := ParseCloudflareTunnel("account/tunnel")
+	require.NoError(t, err)
+	assert.Equal(t, CloudflareTunnel{AccountID: "account", ID: "tunnel"}, tunnel)
+	assert.Equal(t, "account/tunnel", tunnel.String())
+	assert.Equal(t, "tunnel.cfargotunnel.com", tunnel.Target())
+
+	_, err = ParseCloudflareTunnel("tunnel")
+	require.ErrorContains(t, err, "is not an account/id cloudflare tunnel")
+}
+
+func TestTunnelIngress(t *testing.T) {
+	rules := TunnelIngress(map[string]string{
+		"*.apps.example.com":  "10.0.12.6",
+		"argocd.example.com":  "10.0.12.5",
+		"console.example.com": "10.0.12.5",
+	})
+
+	require.Len(t, rules, 4)
+	assert.Equal(t, "argocd.example.com", rules[0]["hostname"])
+	assert.Equal(t, "https://10.0.12.5", rules[0]["service"])
+	assert.Equal(t, map[string]interface{}{"matchSNItoHost": true}, rules[0]["originRequest"])
+	assert.Equal(t, "console.example.com", rules[1]["hostname"])
+	assert.Equal(t, "*.apps.example.com", rules[2]["hostname"])
+	assert.Equal(t, map[string]interface{}{"service": "http_status:404"}, rules[3])
+}
+
+func TestCloudflareTunnel(t *testing.T) {
+	comment := ManagedRecordComment("homelab")
+	api := &fakeTunnelAPI{
+		tunnels: map[string]string{},
+		records: []map[string]interface{}{
+			{"id": "argocd-a", "type": "A", "name": "argocd.example.com", "content": "10.0.12.5", "comment": comment},
+			{"id": "www", "type": "A", "name": "www.example.com", "content": "10.0.12.9"},
+		},
+	}
+	dns := newFakeTunnelAPI(t, api)
+	ctx := context.Background()
+
+	account, err := dns.TunnelAccount(ctx, "argocd.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "account", account)
+
+	tunnel, err := dns.EnsureTunnel(ctx, account, TunnelName("homelab"))
+	require.NoError(t, err)
+	assert.Equal(t, CloudflareTunnel{AccountID: "account", ID: "tunnel", Name: "kubefirst-homelab"}, tunnel)
+	again, err := dns.EnsureTunnel(ctx, account, TunnelName("homelab"))
+	require.NoError(t, err)
+	assert.Equal(t, tunnel, again)
+
+	token, err := dns.TunnelToken(ctx, tunnel)
+	require.NoError(t, err)
+	assert.Equal(t, "secret-token", token)
+
+	require.NoError(t, dns.ConfigureTunnel(ctx, tunnel, map[string]string{"argocd.example.com": "10.0.12.5"}))
+	assert.Len(t, api.configured["config"].(map[string]interface{})["ingress"], 2)
+
+	hosts := []string{"argocd.example.com", "vault.example.com", "www.example.com"}
+	result, err := dns.ReconcileTunnelRecords(ctx, tunnel, hosts, comment, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vault.example.com"}, result.Created)
+	assert.Equal(t, []string{"argocd.example.com"}, result.Updated)
+	assert.Equal(t, []string{"www.example.com"}, result.Unmanaged)
+	assert.Equal(t, []string{"zone"}, result.Zones)
+
+	for _, record := range api.records {
+		if record["name"] != "www.example.com" {
+			assert.Equal(t, "CNAME", record["type"])
+			assert.Equal(t, "tunnel.cfargotunnel.com", record["content"])
+			assert.Equal(t, true, record["proxied"])
+		}
+	}
+
+	result, err = dns.ReconcileTunnelRecords(ctx, tunnel, hosts, comment, nil)
+	require.NoError(t, err)
+	assert.False(t, result.Changed())
+
+	deleted, err := dns.DeleteTunnel(ctx, tunnel, result.Zones, comment)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"argocd.example.com", "vault.example.com"}, deleted)
+	assert.Equal(t, []string{"accounts/account/cfd_tunnel/tunnel/connections", "accounts/account/cfd_tunnel/tunnel"}, api.deleted)
+	require.Len(t, api.records, 1)
+	assert.Equal(t, "www", api.records[0]["id"])
+
+	_, err = dns.DeleteTunnel(ctx, tunnel, result.Zones, comment)
+	require.NoError(t, err)
+	assert.Len(t, api.deleted, 2)
+}
+
+func TestApplyCloudflaredToken(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset()}
+	ctx := context.Background()
+
+	require.NoError(t, client.ApplyCloudflaredToken(ctx, "secret-token"))
+	secret, err := client.Clientset.CoreV1().Secrets(CloudflaredNamespace).Get(ctx, cloudflaredSecret, metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "secret-token", secret.StringData["token"])
+}
+
+func TestCloudflaredManifests(t *testing.T) {
+	manifests, err := CloudflaredManifests("registry.internal")
+	require.NoError(t, err)
+
+	documents, err := decodeDocuments(manifests)
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	container := lookupValue(documents[1], "spec", "template", "spec", "containers").([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, MirrorImage(cloudflaredImage, "registry.internal"), container["image"])
+	assert.Contains(t, container["args"], "run")
+}
@@ -0,0 +1,399 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/konstructio/kubefirst/internal/readonly"
+)
+
+// DefaultUniFiSite is the site of a UniFi controller managing a single one
+const DefaultUniFiSite = "default"
+
+// uniFiProtos are the protocols a UniFi port forward takes
+var uniFiProtos = []string{"tcp", "udp", "tcp_udp"}
+
+// UniFiForward is a port forward of the UniFi gateway: WAN port DstPort
+// reaches FwdPort on the LAN address Fwd
+type UniFiForward struct {
+	ID      string `json:"_id,omitempty"`
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	DstPort string `json:"dst_port"`
+	Fwd     string `json:"fwd"`
+	FwdPort string `json:"fwd_port"`
+	Proto   string `json:"proto"`
+	Src     string `json:"src"`
+}
+
+// UniFiController reads and changes the port forwards of the UniFi
+// controller create configured with --unifi-host
+type UniFiController struct {
+	// Site is the UniFi site the port forwards belong to, DefaultUniFiSite
+	// when empty
+	Site string
+
+	baseURL    string
+	user       string
+	password   string
+	httpClient *http.Client
+
+	// prefix and csrfToken are set by the login of the session
+	loggedIn  bool
+	prefix    string
+	csrfToken string
+}
+
+// NewUniFiController returns a UniFiController for host over the transport
+// of httpClient, keeping the session cookie of its login
+func NewUniFiController(host, user, password string, httpClient *http.Client) (*UniFiController, error) {
+	if host == "" {
+		return nil, fmt.Errorf("no unifi host configured")
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create unifi session: %w", err)
+	}
+
+	return &UniFiController{
+		baseURL:    UniFiURL(host),
+		user:       user,
+		password:   password,
+		httpClient: &http.Client{Transport: httpClient.Transport, Timeout: httpClient.Timeout, Jar: jar},
+	}, nil
+}
+
+// UniFiURL returns the base url of the UniFi controller at host, https
+// unless host names a scheme
+func UniFiURL(host string) string {
+	baseURL := host
+	if !strings.Contains(baseURL, "://") {
+		baseURL = "https://" + baseURL
+	}
+
+	return strings.TrimSuffix(baseURL, "/")
+}
+
+// login opens the session of the controller once. UniFi OS consoles serve
+// the network API under /proxy/network and expect the CSRF token of the
+// login on every change, standalone controllers serve it at the root
+func (u *UniFiController) login(ctx context.Context) error {
+	if u.loggedIn {
+		return nil
+	}
+
+	credentials, err := json.Marshal(map[string]string{"username": u.user, "password": u.password})
+	if err != nil {
+		return fmt.Errorf("failed to encode unifi login: %w", err)
+	}
+
+	// the login only opens a session, read-only mode lets it through
+	ctx = readonly.WithoutSideEffects(ctx)
+	prefix := "/proxy/network"
+	res, err := u.send(ctx, http.MethodPost, "/api/auth/login", credentials)
+	if err == nil && res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		prefix = ""
+		res, err = u.send(ctx, http.MethodPost, "/api/login", credentials)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to log in to unifi: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to log in to unifi: %s", res.Status)
+	}
+
+	u.loggedIn = true
+	u.prefix = prefix
+	u.csrfToken = res.Header.Get("X-CSRF-Token")
+
+	return nil
+}
+
+// portForwardsPath is the path of the port forwards of the site, or of the
+// port forward id
+func (u *UniFiController) portForwardsPath(id string) string {
+	site := u.Site
+	if site == "" {
+		site = DefaultUniFiSite
+	}
+
+	path := fmt.Sprintf("%s/api/s/%s/rest/portforward", u.prefix, site)
+	if id != "" {
+		path += "/" + id
+	}
+
+	return path
+}
+
+// PortForwards logs in and lists the port forwards of the site
+func (u *UniFiController) PortForwards(ctx context.Context) ([]UniFiForward, error) {
+	if err := u.login(ctx); err != nil {
+		return nil, err
+	}
+
+	var forwards []UniFiForward
+	if err := u.call(ctx, http.MethodGet, u.portForwardsPath(""), nil, &forwards); err != nil {
+		return nil, fmt.Errorf("failed to list unifi port forwards: %w", err)
+	}
+
+	return forwards, nil
+}
+
+// UpsertPortForwards creates the desired port forwards, or updates the
+// ones of the same name that differ, and returns the names of those it
+// changed. A forward of another name already publishing a desired port
+// is refused rather than shadowed
+func (u *UniFiController) UpsertPortForwards(ctx context.Context, desired []UniFiForward) ([]string, error) {
+	existing, err := u.PortForwards(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var conflicts []string
+	for _, forward := range desired {
+		for _, other := range existing {
+			if other.Name != forward.Name && other.Enabled && other.DstPort == forward.DstPort && protosOverlap(other.Proto, forward.Proto) {
+				conflicts = append(conflicts, fmt.Sprintf("%s port %s is already forwarded by %q to %s:%s", forward.Proto, forward.DstPort, other.Name, other.Fwd, other.FwdPort))
+			}
+		}
+	}
+	if len(conflicts) > 0 {
+		return nil, errors.New(strings.Join(conflicts, "; "))
+	}
+
+	var changed []string
+	for _, forward := range desired {
+		index := slices.IndexFunc(existing, func(other UniFiForward) bool { return other.Name == forward.Name })
+		if index < 0 {
+			if err := u.call(ctx, http.MethodPost, u.portForwardsPath(""), forward, nil); err != nil {
+				return changed, fmt.Errorf("failed to create unifi port forward %q: %w", forward.Name, err)
+			}
+			changed = append(changed, forward.Name)
+			continue
+		}
+
+		forward.ID = existing[index].ID
+		if existing[index] == forward {
+			continue
+		}
+		if err := u.call(ctx, http.MethodPut, u.portForwardsPath(forward.ID), forward, nil); err != nil {
+			return changed, fmt.Errorf("failed to update unifi port forward %q: %w", forward.Name, err)
+		}
+		changed = append(changed, forward.Name)
+	}
+
+	return changed, nil
+}
+
+// VerifyPortForwards reads the port forwards back and fails naming the
+// desired ones the controller does not hold as desired
+func (u *UniFiController) VerifyPortForwards(ctx context.Context, desired []UniFiForward) error {
+	existing, err := u.PortForwards(ctx)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, forward := range desired {
+		index := slices.IndexFunc(existing, func(other UniFiForward) bool { return other.Name == forward.Name })
+		if index < 0 {
+			missing = append(missing, fmt.Sprintf("%q is missing", forward.Name))
+			continue
+		}
+		forward.ID = existing[index].ID
+		if existing[index] != forward {
+			missing = append(missing, fmt.Sprintf("%q forwards %s port %s to %s:%s", forward.Name, existing[index].Proto, existing[index].DstPort, existing[index].Fwd, existing[index].FwdPort))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("unifi port forwards not as configured: %s", strings.Join(missing, "; "))
+	}
+
+	return nil
+}
+
+// DeletePortForwards deletes the port forwards named names and returns the
+// names of those it deleted, the ones already gone are skipped
+func (u *UniFiController) DeletePortForwards(ctx context.Context, names []string) ([]string, error) {
+	existing, err := u.PortForwards(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for _, forward := range existing {
+		if !slices.Contains(names, forward.Name) {
+			continue
+		}
+		if err := u.call(ctx, http.MethodDelete, u.portForwardsPath(forward.ID), nil, nil); err != nil {
+			return deleted, fmt.Errorf("failed to delete unifi port forward %q: %w", forward.Name, err)
+		}
+		deleted = append(deleted, forward.Name)
+	}
+
+	return deleted, nil
+}
+
+// call sends a request of the session and decodes the data of the answer
+// into out when set. UniFi reports failures in meta.msg
+func (u *UniFiController) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("failed to encode unifi request: %w", err)
+		}
+	}
+
+	res, err := u.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var decoded struct {
+		Meta struct {
+			RC  string `json:"rc"`
+			Msg string `json:"msg"`
+		} `json:"meta"`
+		Data json.RawMessage `json:"data"`
+	}
+	decodeErr := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&decoded)
+	if res.StatusCode != http.StatusOK {
+		if decoded.Meta.Msg != "" {
+			return fmt.Errorf("%s: %s", res.Status, decoded.Meta.Msg)
+		}
+		return errors.New(res.Status)
+	}
+	if decodeErr != nil && !errors.Is(decodeErr, io.EOF) {
+		return fmt.Errorf("failed to decode unifi response: %w", decodeErr)
+	}
+	if decoded.Meta.RC == "error" {
+		return errors.New(decoded.Meta.Msg)
+	}
+
+	if out == nil || len(decoded.Data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(decoded.Data, out); err != nil {
+		return fmt.Errorf("failed to decode unifi response: %w", err)
+	}
+
+	return nil
+}
+
+func protosOverlap(a, b string) bool {
+	return a == b || a == "tcp_udp" || b == "tcp_udp"
+}
+
+// UniFiPortMapping is a --unifi-port-mapping entry: ExternalPort of the
+// WAN reaches InternalPort of InternalIP over Proto
+type UniFiPortMapping struct {
+	Proto        string
+	ExternalPort int
+	InternalIP   string
+	InternalPort int
+}
+
+// ParseUniFiPortMappings parses proto:external-port:internal-ip:internal-port
+// entries, such as tcp:443:10.0.12.1:443. Proto is tcp, udp or tcp_udp and
+// an external port is only mapped once per protocol
+func ParseUniFiPortMappings(entries []string) ([]UniFiPortMapping, error) {
+	var mappings []UniFiPortMapping
+	for _, entry := range entries {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 4 {
+			return nil, fmt.Errorf("invalid port mapping %q, expected proto:external-port:internal-ip:internal-port", entry)
+		}
+
+		mapping := UniFiPortMapping{Proto: strings.ToLower(parts[0]), InternalIP: parts[2]}
+		if !slices.Contains(uniFiProtos, mapping.Proto) {
+			return nil, fmt.Errorf("invalid port mapping %q: protocol %q is not one of %s", entry, parts[0], strings.Join(uniFiProtos, ", "))
+		}
+		var err error
+		if mapping.ExternalPort, err = parsePort(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid port mapping %q: external port: %w", entry, err)
+		}
+		if mapping.InternalPort, err = parsePort(parts[3]); err != nil {
+			return nil, fmt.Errorf("invalid port mapping %q: internal port: %w", entry, err)
+		}
+		if ip := net.ParseIP(mapping.InternalIP); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("invalid port mapping %q: %q is not an IPv4 address", entry, mapping.InternalIP)
+		}
+
+		for _, other := range mappings {
+			if other.ExternalPort == mapping.ExternalPort && protosOverlap(other.Proto, mapping.Proto) {
+				return nil, fmt.Errorf("invalid port mapping %q: external port %d is already mapped by %q", entry, mapping.ExternalPort, other)
+			}
+		}
+		mappings = append(mappings, mapping)
+	}
+
+	return mappings, nil
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("%q is not a port between 1 and 65535", value)
+	}
+
+	return port, nil
+}
+
+func (m UniFiPortMapping) String() string {
+	return fmt.Sprintf("%s:%d:%s:%d", m.Proto, m.ExternalPort, m.InternalIP, m.InternalPort)
+}
+
+// Forward returns the port forward of the mapping for clusterName, named so
+// create and destroy find the forwards the cluster owns
+func (m UniFiPortMapping) Forward(clusterName string) UniFiForward {
+	return UniFiForward{
+		Name:    fmt.Sprintf("kubefirst-%s-%s-%d", clusterName, m.Proto, m.ExternalPort),
+		Enabled: true,
+		DstPort: strconv.Itoa(m.ExternalPort),
+		Fwd:     m.InternalIP,
+		FwdPort: strconv.Itoa(m.InternalPort),
+		Proto:   m.Proto,
+		Src:     "any",
+	}
+}
+
+func (u *UniFiController) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.baseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build unifi request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if u.csrfToken != "" && method != http.MethodGet {
+		req.Header.Set("X-CSRF-Token", u.csrfToken)
+	}
+
+	return u.httpClient.Do(req)
+}
@@ -0,0 +1,222 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUniFiPortForwards(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		forwardsPath := "/proxy/network/api/s/default/rest/portforward"
+		if legacy {
+			forwardsPath = "/api/s/default/rest/portforward"
+		}
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.URL.Path == "/api/auth/login" && legacy:
+				w.WriteHeader(http.StatusNotFound)
+			case r.URL.Path == "/api/auth/login" || r.URL.Path == "/api/login":
+				var login map[string]string
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&login))
+				if login["password"] != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: "session", Path: "/"})
+			case r.URL.Path == forwardsPath:
+				if _, err := r.Cookie("TOKEN"); err != nil {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`{"data": [{"name": "https", "enabled": true, "dst_port": "443", "fwd": "10.0.0.10", "fwd_port": "443", "proto": "tcp"}]}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		controller, err := NewUniFiController(server.URL, "admin", "secret", server.Client())
+		require.NoError(t, err)
+		forwards, err := controller.PortForwards(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []UniFiForward{{Name: "https", Enabled: true, DstPort: "443", Fwd: "10.0.0.10", FwdPort: "443", Proto: "tcp"}}, forwards)
+
+		controller, err = NewUniFiController(server.URL, "admin", "wrong", server.Client())
+		require.NoError(t, err)
+		_, err = controller.PortForwards(context.Background())
+		assert.ErrorContains(t, err, "401")
+
+		server.Close()
+	}
+}
+
+func TestParseUniFiPortMappings(t *testing.T) {
+	mappings, err := ParseUniFiPortMappings([]string{"tcp:443:10.0.12.1:443", "UDP:51820:10.0.12.2:51820"})
+	require.NoError(t, err)
+	assert.Equal(t, []UniFiPortMapping{
+		{Proto: "tcp", ExternalPort: 443, InternalIP: "10.0.12.1", InternalPort: 443},
+		{Proto: "udp", ExternalPort: 51820, InternalIP: "10.0.12.2", InternalPort: 51820},
+	}, mappings)
+	assert.Equal(t, UniFiForward{Name: "kubefirst-k1-tcp-443", Enabled: true, DstPort: "443", Fwd: "10.0.12.1", FwdPort: "443", Proto: "tcp", Src: "any"}, mappings[0].Forward("k1"))
+
+	for _, entries := range [][]string{
+		{"tcp:443:10.0.12.1"},
+		{"icmp:443:10.0.12.1:443"},
+		{"tcp:0:10.0.12.1:443"},
+		{"tcp:443:10.0.12.1:70000"},
+		{"tcp:443:fd00::1:443"},
+		{"tcp:443:10.0.12.1:443", "tcp_udp:443:10.0.12.2:8443"},
+	} {
+		_, err := ParseUniFiPortMappings(entries)
+		assert.Error(t, err, entries)
+	}
+}
+
+func TestUniFiUpsertPortForwards(t *testing.T) {
+	forwards := map[string]UniFiForward{
+		"1": {ID: "1", Name: "kubefirst-k1-tcp-443", Enabled: true, DstPort: "443", Fwd: "10.0.0.9", FwdPort: "443", Proto: "tcp", Src: "any"},
+		"2": {ID: "2", Name: "ssh", Enabled: true, DstPort: "22", Fwd: "10.0.0.2", FwdPort: "22", Proto: "tcp", Src: "any"},
+	}
+	forwardsPath := "/proxy/network/api/s/lab/rest/portforward"
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/auth/login" {
+			http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: "session", Path: "/"})
+			w.Header().Set("X-CSRF-Token", "csrf")
+			return
+		}
+		if _, err := r.Cookie("TOKEN"); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && r.Header.Get("X-CSRF-Token") != "csrf" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == forwardsPath:
+			var data []UniFiForward
+			for _, id := range sortedKeys(forwards) {
+				data = append(data, forwards[id])
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"meta": map[string]string{"rc": "ok"}, "data": data}))
+		case r.Method == http.MethodPost && r.URL.Path == forwardsPath:
+			var forward UniFiForward
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&forward))
+			forward.ID = fmt.Sprint(len(forwards) + 1)
+			forwards[forward.ID] = forward
+			w.Write([]byte(`{"meta": {"rc": "ok"}, "data": []}`))
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, forwardsPath+"/"):
+			var forward UniFiForward
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&forward))
+			forwards[strings.TrimPrefix(r.URL.Path, forwardsPath+"/")] = forward
+			w.Write([]byte(`{"meta": {"rc": "ok"}, "data": []}`))
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, forwardsPath+"/"):
+			delete(forwards, strings.TrimPrefix(r.URL.Path, forwardsPath+"/"))
+			w.Write([]byte(`{"meta": {"rc": "ok"}, "data": []}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	controller, err := NewUniFiController(server.URL, "admin", "secret", server.Client())
+	require.NoError(t, err)
+	controller.Site = "lab"
+	ctx := context.Background()
+
+	mappings, err := ParseUniFiPortMappings([]string{"tcp:443:10.0.12.1:443", "tcp:80:10.0.12.1:80"})
+	require.NoError(t, err)
+	desired := []UniFiForward{mappings[0].Forward("k1"), mappings[1].Forward("k1")}
+
+	require.Error(t, controller.VerifyPortForwards(ctx, desired))
+
+	changed, err := controller.UpsertPortForwards(ctx, desired)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kubefirst-k1-tcp-443", "kubefirst-k1-tcp-80"}, changed)
+	assert.Equal(t, "10.0.12.1", forwards["1"].Fwd)
+	require.NoError(t, controller.VerifyPortForwards(ctx, desired))
+
+	changed, err = controller.UpsertPortForwards(ctx, desired)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	conflicting, err := ParseUniFiPortMappings([]string{"tcp_udp:22:10.0.12.1:22"})
+	require.NoError(t, err)
+	_, err = controller.UpsertPortForwards(ctx, []UniFiForward{conflicting[0].Forward("k1")})
+	assert.ErrorContains(t, err, `already forwarded by "ssh"`)
+
+	deleted, err := controller.DeletePortForwards(ctx, []string{"kubefirst-k1-tcp-443", "kubefirst-k1-tcp-80", "kubefirst-k1-udp-53"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"kubefirst-k1-tcp-443", "kubefirst-k1-tcp-80"}, deleted)
+	assert.Len(t, forwards, 1)
+}
+
+func TestUniFiErrors(t *testing.T) {
+	_, err := NewUniFiController("", "admin", "secret", http.DefaultClient)
+	require.ErrorContains(t, err, "no unifi host")
+	assert.Equal(t, "https://unifi.lan", UniFiURL("unifi.lan/"))
+	assert.Equal(t, "http://10.0.0.1:8443", UniFiURL("http://10.0.0.1:8443"))
+
+	forwardsPath := "/proxy/network/api/s/default/rest/portforward"
+	for _, tc := range []struct {
+		name    string
+		login   int
+		status  int
+		body    string
+		wantErr string
+	}{
+		{name: "login", login: http.StatusInternalServerError, wantErr: "failed to log in to unifi: 500 Internal Server Error"},
+		{name: "status with message", login: http.StatusOK, status: http.StatusBadRequest, body: `{"meta": {"rc": "error", "msg": "api.err.InvalidPayload"}}`, wantErr: "400 Bad Request: api.err.InvalidPayload"},
+		{name: "status", login: http.StatusOK, status: http.StatusBadGateway, body: "bad gateway", wantErr: "failed to list unifi port forwards: 502 Bad Gateway"},
+		{name: "rc error", login: http.StatusOK, status: http.StatusOK, body: `{"meta": {"rc": "error", "msg": "api.err.NoSiteContext"}, "data": []}`, wantErr: "api.err.NoSiteContext"},
+		{name: "undecodable", login: http.StatusOK, status: http.StatusOK, body: "<html>", wantErr: "failed to decode unifi response"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/auth/login":
+					w.WriteHeader(tc.login)
+				case forwardsPath:
+					w.WriteHeader(tc.status)
+					w.Write([]byte(tc.body))
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			controller, err := NewUniFiController(server.URL, "admin", "secret", server.Client())
+			require.NoError(t, err)
+			_, err = controller.PortForwards(context.Background())
+			require.ErrorContains(t, err, tc.wantErr)
+
+			_, err = controller.UpsertPortForwards(context.Background(), []UniFiForward{{Name: "kubefirst-k1-tcp-443"}})
+			require.ErrorContains(t, err, tc.wantErr)
+		})
+	}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/auth/login":
+		case r.Method == http.MethodGet:
+			w.Write([]byte(`{"meta": {"rc": "ok"}, "data": []}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"meta": {"rc": "error", "msg": "api.err.PortForwardConflict"}}`))
+		}
+	}))
+	defer server.Close()
+
+	controller, err := NewUniFiController(server.URL, "admin", "secret", server.Client())
+	require.NoError(t, err)
+	changed, err := controller.UpsertPortForwards(context.Background(), []UniFiForward{{Name: "kubefirst-k1-tcp-443", DstPort: "443", Proto: "tcp"}})
+	require.ErrorContains(t, err, `failed to create unifi port forward "kubefirst-k1-tcp-443": 500 Internal Server Error: api.err.PortForwardConflict`)
+	assert.Empty(t, changed)
+}
@@ -0,0 +1,376 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// UsageSchemaVersion is the version of the usage.json schema. Fields are
+	// only ever added within a version, never renamed or removed
+	UsageSchemaVersion = 1
+
+	usageJSONFile = "usage.json"
+)
+
+// API providers external calls are counted under
+const (
+	UsageProviderGitHub     = "github"
+	UsageProviderGitLab     = "gitlab"
+	UsageProviderCloudflare = "cloudflare"
+	UsageProviderOther      = "other"
+)
+
+// apiUsage counts the requests and downloaded bytes of every HTTP client
+// built by NewHTTPClient for the whole process. Nothing it records ever
+// leaves the machine
+var apiUsage = &usageRecorder{calls: map[string]int{}}
+
+type usageRecorder struct {
+	mu    sync.Mutex
+	calls map[string]int
+	bytes atomic.Int64
+}
+
+func (u *usageRecorder) record(provider string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.calls[provider]++
+}
+
+// RecordedAPIUsage returns the external API calls made so far per provider
+// and the bytes their responses carried
+func RecordedAPIUsage() (map[string]int, int64) {
+	apiUsage.mu.Lock()
+	defer apiUsage.mu.Unlock()
+
+	calls := make(map[string]int, len(apiUsage.calls))
+	for provider, count := range apiUsage.calls {
+		calls[provider] = count
+	}
+
+	return calls, apiUsage.bytes.Load()
+}
+
+// UsageProvider names the provider a request to host and path is counted
+// under
+func UsageProvider(host string) string {
+	host = strings.ToLower(host)
+	switch {
+	case host == "github.com" || strings.HasSuffix(host, ".github.com") || strings.HasSuffix(host, ".githubusercontent.com"):
+		return UsageProviderGitHub
+	case host == "gitlab.com" || strings.HasSuffix(host, ".gitlab.com"):
+		return UsageProviderGitLab
+	case host == "api.cloudflare.com":
+		return UsageProviderCloudflare
+	default:
+		return UsageProviderOther
+	}
+}
+
+// usageRoundTripper counts every request and the bytes of its response in
+// apiUsage
+type usageRoundTripper struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (u *usageRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	apiUsage.record(UsageProvider(req.URL.Hostname()))
+
+	res, err := u.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = &countingBody{ReadCloser: res.Body}
+
+	return res, nil
+}
+
+type countingBody struct {
+	io.ReadCloser
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	apiUsage.bytes.Add(int64(n))
+
+	return n, err
+}
+
+// UsagePhase is how long a phase of the run took
+type UsagePhase struct {
+	Name            string  `json:"name"`
+	Status          string  `json:"status"`
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+// UsageResources sums the requests of the running platform pods
+type UsageResources struct {
+	Namespaces    []string `json:"namespaces"`
+	Pods          int      `json:"pods"`
+	CPUMillicores int64    `json:"cpuMillicores"`
+	MemoryBytes   int64    `json:"memoryBytes"`
+	StorageBytes  int64    `json:"ephemeralStorageBytes"`
+	QueryError    string   `json:"queryError,omitempty"`
+	QueriedAt     string   `json:"queriedAt,omitempty"`
+}
+
+// UsageAddresses are the addresses of the load balancer pool held by
+// services
+type UsageAddresses struct {
+	Pool       string   `json:"pool"`
+	Used       []string `json:"used"`
+	QueryError string   `json:"queryError,omitempty"`
+}
+
+// UsageSummary is what an install cost in time, traffic and resources. It
+// is written to usage.json with a stable schema, see UsageSchemaVersion
+type UsageSummary struct {
+	SchemaVersion   int            `json:"schemaVersion"`
+	ClusterName     string         `json:"clusterName"`
+	Succeeded       bool           `json:"succeeded"`
+	StartedAt       time.Time      `json:"startedAt"`
+	FinishedAt      time.Time      `json:"finishedAt"`
+	WallTimeSeconds float64        `json:"wallTimeSeconds"`
+	Phases          []UsagePhase   `json:"phases"`
+	BytesDownloaded int64          `json:"bytesDownloaded"`
+	APICalls        map[string]int `json:"apiCalls"`
+	Resources       UsageResources `json:"resources"`
+	LoadBalancerIPs UsageAddresses `json:"loadBalancerIPs"`
+}
+
+// NewUsageSummary summarizes a run of clusterName from startedAt until
+// now, taking the API usage recorded by this process
+func NewUsageSummary(clusterName string, startedAt time.Time, succeeded bool, phases []PhaseRecord) *UsageSummary {
+	finishedAt := time.Now().UTC()
+	calls, bytes := RecordedAPIUsage()
+
+	summary := &UsageSummary{
+		SchemaVersion:   UsageSchemaVersion,
+		ClusterName:     clusterName,
+		Succeeded:       succeeded,
+		StartedAt:       startedAt.UTC(),
+		FinishedAt:      finishedAt,
+		WallTimeSeconds: finishedAt.Sub(startedAt).Round(time.Second).Seconds(),
+		Phases:          []UsagePhase{},
+		BytesDownloaded: bytes,
+		APICalls:        calls,
+		Resources:       UsageResources{Namespaces: []string{}},
+		LoadBalancerIPs: UsageAddresses{Used: []string{}},
+	}
+	for _, phase := range phases {
+		summary.Phases = append(summary.Phases, UsagePhase{
+			Name:            phase.Name,
+			Status:          string(phase.Status),
+			DurationSeconds: phase.Duration.Round(time.Second).Seconds(),
+		})
+	}
+
+	return summary
+}
+
+// PlatformResources sums the container requests of the pods running in the
+// namespaces the platform applications deploy into
+func (c *Client) PlatformResources(ctx context.Context) (UsageResources, error) {
+	scope, err := c.TeardownScope(ctx)
+	if err != nil {
+		return UsageResources{}, err
+	}
+
+	resources := UsageResources{Namespaces: scope.Namespaces}
+	for _, namespace := range scope.Namespaces {
+		pods, err := c.Clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return UsageResources{}, fmt.Errorf("failed to list pods in namespace %q: %w", namespace, err)
+		}
+
+		for _, pod := range pods.Items {
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+
+			resources.Pods++
+			for _, container := range pod.Spec.Containers {
+				requests := container.Resources.Requests
+				resources.CPUMillicores += requests.Cpu().MilliValue()
+				resources.MemoryBytes += requests.Memory().Value()
+				resources.StorageBytes += requests.StorageEphemeral().Value()
+			}
+		}
+	}
+	resources.QueriedAt = time.Now().UTC().Format(time.RFC3339)
+
+	return resources, nil
+}
+
+// LoadBalancerAddresses lists the addresses of pools held by services
+func (c *Client) LoadBalancerAddresses(ctx context.Context, pools LBPools) (UsageAddresses, error) {
+	services, err := c.Clientset.CoreV1().Services("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return UsageAddresses{}, fmt.Errorf("failed to list services: %w", err)
+	}
+
+	addresses := UsageAddresses{Pool: pools.String(), Used: []string{}}
+	seen := map[string]bool{}
+	for _, service := range services.Items {
+		for _, ingress := range service.Status.LoadBalancer.Ingress {
+			if addr, err := netip.ParseAddr(ingress.IP); err == nil && pools.Contains(addr) && !seen[ingress.IP] {
+				seen[ingress.IP] = true
+				addresses.Used = append(addresses.Used, ingress.IP)
+			}
+		}
+	}
+	sort.Slice(addresses.Used, func(i, j int) bool {
+		return netip.MustParseAddr(addresses.Used[i]).Less(netip.MustParseAddr(addresses.Used[j]))
+	})
+
+	return addresses, nil
+}
+
+// WriteUsageSummary writes summary as usage.json into dir and returns its
+// path
+func WriteUsageSummary(dir string, summary *UsageSummary) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create report directory %q: %w", dir, err)
+	}
+
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to render usage summary: %w", err)
+	}
+
+	path := filepath.Join(dir, usageJSONFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("failed to write usage summary %q: %w", path, err)
+	}
+
+	return path, nil
+}
+
+// ReadUsageSummary reads the usage.json create wrote into dir
+func ReadUsageSummary(dir string) (*UsageSummary, error) {
+	path := filepath.Join(dir, usageJSONFile)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage summary %q: %w", path, err)
+	}
+
+	var summary UsageSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse usage summary %q: %w", path, err)
+	}
+	if summary.SchemaVersion > UsageSchemaVersion {
+		return nil, fmt.Errorf("usage summary %q has schema version %d, this kubefirst reads up to %d", path, summary.SchemaVersion, UsageSchemaVersion)
+	}
+
+	return &summary, nil
+}
+
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// Paragraph summarizes the usage in a few sentences, as printed at the end
+// of create
+func (s *UsageSummary) Paragraph() string {
+	var calls []string
+	total := 0
+	for _, provider := range sortedKeys(s.APICalls) {
+		calls = append(calls, fmt.Sprintf("%d %s", s.APICalls[provider], provider))
+		total += s.APICalls[provider]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "The install took %s over %d phases", formatSeconds(s.WallTimeSeconds), len(s.Phases))
+	fmt.Fprintf(&b, ", downloaded %s and made %d external API calls", resource.NewQuantity(s.BytesDownloaded, resource.BinarySI), total)
+	if len(calls) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(calls, ", "))
+	}
+	b.WriteString(".")
+	if s.Resources.QueryError == "" {
+		fmt.Fprintf(&b, " The platform requests %s CPU and %s memory across %d pods",
+			resource.NewMilliQuantity(s.Resources.CPUMillicores, resource.DecimalSI),
+			resource.NewQuantity(s.Resources.MemoryBytes, resource.BinarySI),
+			s.Resources.Pods)
+		if s.LoadBalancerIPs.Pool != "" && s.LoadBalancerIPs.QueryError == "" {
+			fmt.Fprintf(&b, " and holds %d addresses of %s", len(s.LoadBalancerIPs.Used), s.LoadBalancerIPs.Pool)
+		}
+		b.WriteString(".")
+	}
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+// WriteTable writes the usage as the table of harvester report
+func (s *UsageSummary) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	outcome := "succeeded"
+	if !s.Succeeded {
+		outcome = "failed"
+	}
+	fmt.Fprintf(w, "CLUSTER\t%s\n", s.ClusterName)
+	fmt.Fprintf(w, "RUN\t%s at %s\n", outcome, s.FinishedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "WALL TIME\t%s\n", formatSeconds(s.WallTimeSeconds))
+	fmt.Fprintf(w, "DOWNLOADED\t%s\n", resource.NewQuantity(s.BytesDownloaded, resource.BinarySI))
+
+	fmt.Fprintf(w, "\nPHASE\tSTATUS\tDURATION\n")
+	for _, phase := range s.Phases {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", phase.Name, phase.Status, formatSeconds(phase.DurationSeconds))
+	}
+
+	fmt.Fprintf(w, "\nPROVIDER\tAPI CALLS\n")
+	for _, provider := range sortedKeys(s.APICalls) {
+		fmt.Fprintf(w, "%s\t%d\n", provider, s.APICalls[provider])
+	}
+
+	fmt.Fprintf(w, "\nRESOURCE\tREQUESTED\n")
+	if s.Resources.QueryError != "" {
+		fmt.Fprintf(w, "unavailable\t%s\n", s.Resources.QueryError)
+	} else {
+		fmt.Fprintf(w, "pods\t%d\n", s.Resources.Pods)
+		fmt.Fprintf(w, "cpu\t%s\n", resource.NewMilliQuantity(s.Resources.CPUMillicores, resource.DecimalSI))
+		fmt.Fprintf(w, "memory\t%s\n", resource.NewQuantity(s.Resources.MemoryBytes, resource.BinarySI))
+		fmt.Fprintf(w, "ephemeral storage\t%s\n", resource.NewQuantity(s.Resources.StorageBytes, resource.BinarySI))
+	}
+
+	if s.LoadBalancerIPs.Pool != "" {
+		fmt.Fprintf(w, "\nPOOL\tUSED\n")
+		if s.LoadBalancerIPs.QueryError != "" {
+			fmt.Fprintf(w, "%s\tunavailable: %s\n", s.LoadBalancerIPs.Pool, s.LoadBalancerIPs.QueryError)
+		} else {
+			fmt.Fprintf(w, "%s\t%d %s\n", s.LoadBalancerIPs.Pool, len(s.LoadBalancerIPs.Used), strings.Join(s.LoadBalancerIPs.Used, ", "))
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write usage table: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestUsageProvider(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"api.github.com", UsageProviderGitHub},
+		{"objects.githubusercontent.com", UsageProviderGitHub},
+		{"gitlab.com", UsageProviderGitLab},
+		{"api.cloudflare.com", UsageProviderCloudflare},
+		{"charts.example.com", UsageProviderOther},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, UsageProvider(tt.host), tt.host)
+	}
+}
+
+func TestHTTPClientRecordsUsage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "0123456789")
+	}))
+	defer server.Close()
+
+	client, err := NewHTTPClient("")
+	require.NoError(t, err)
+
+	calls, bytes := RecordedAPIUsage()
+
+	res, err := client.Get(server.URL + "/api/v1/version")
+	require.NoError(t, err)
+	_, err = io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	after, afterBytes := RecordedAPIUsage()
+	assert.Equal(t, calls[UsageProviderOther]+1, after[UsageProviderOther])
+	assert.Equal(t, bytes+10, afterBytes)
+}
+
+func podWithRequests(namespace, name, cpu, memory string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name: "main",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse(cpu),
+				corev1.ResourceMemory: resource.MustParse(memory),
+			}},
+		}}},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestPlatformResources(t *testing.T) {
+	client := &Client{
+		ArgoCD: argocdfake.NewSimpleClientset(newDestinationApplication("vault", "vault")),
+		Clientset: fake.NewSimpleClientset(
+			podWithRequests("vault", "vault-0", "250m", "256Mi", corev1.PodRunning),
+			podWithRequests(ArgoCDNamespace, "argocd-server", "100m", "128Mi", corev1.PodRunning),
+			podWithRequests(ArgoCDNamespace, "argocd-job", "1", "1Gi", corev1.PodSucceeded),
+			podWithRequests("default", "unrelated", "2", "2Gi", corev1.PodRunning),
+		),
+	}
+
+	resources, err := client.PlatformResources(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"vault", ArgoCDNamespace}, resources.Namespaces)
+	assert.Equal(t, 2, resources.Pods)
+	assert.Equal(t, int64(350), resources.CPUMillicores)
+	assert.Equal(t, int64(384<<20), resources.MemoryBytes)
+}
+
+func TestLoadBalancerAddresses(t *testing.T) {
+	service := func(namespace, name string, ips ...string) *corev1.Service {
+		svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		for _, ip := range ips {
+			svc.Status.LoadBalancer.Ingress = append(svc.Status.LoadBalancer.Ingress, corev1.LoadBalancerIngress{IP: ip})
+		}
+		return svc
+	}
+	client := &Client{Clientset: fake.NewSimpleClientset(
+		service(WildcardGatewayNamespace, "gateway", "10.0.12.20"),
+		service(IstioNamespace, "ingress", "10.0.12.3", "10.0.12.20"),
+		service("default", "outside", "192.168.1.5"),
+	)}
+
+	pools, err := ParseLBPools(LBPoolName, []string{"10.0.12.0/24"}, nil)
+	require.NoError(t, err)
+
+	addresses, err := client.LoadBalancerAddresses(context.Background(), pools)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.12.0/24", addresses.Pool)
+	assert.Equal(t, []string{"10.0.12.3", "10.0.12.20"}, addresses.Used)
+}
+
+func TestUsageSummaryRoundTrip(t *testing.T) {
+	startedAt := time.Now().Add(-90 * time.Second)
+	summary := NewUsageSummary("demo", startedAt, true, []PhaseRecord{
+		{Name: "Install ArgoCD", Status: step.StatusComplete, Duration: 42 * time.Second},
+	})
+	summary.APICalls = map[string]int{UsageProviderGitHub: 12, UsageProviderCloudflare: 3}
+	summary.BytesDownloaded = 3 << 20
+	summary.Resources = UsageResources{Namespaces: []string{"vault"}, Pods: 4, CPUMillicores: 1500, MemoryBytes: 2 << 30}
+	summary.LoadBalancerIPs = UsageAddresses{Pool: "10.0.12.0/24", Used: []string{"10.0.12.3"}}
+
+	dir := t.TempDir()
+	_, err := WriteUsageSummary(dir, summary)
+	require.NoError(t, err)
+
+	read, err := ReadUsageSummary(dir)
+	require.NoError(t, err)
+	assert.Equal(t, summary.APICalls, read.APICalls)
+	assert.Equal(t, summary.Phases, read.Phases)
+	assert.Equal(t, float64(90), read.WallTimeSeconds)
+
+	assert.Equal(t, "The install took 1m30s over 1 phases, downloaded 3Mi and made 15 external API calls (3 cloudflare, 12 github). The platform requests 1500m CPU and 2Gi memory across 4 pods and holds 1 addresses of 10.0.12.0/24.\n", read.Paragraph())
+
+	var b strings.Builder
+	require.NoError(t, read.WriteTable(&b))
+	assert.Contains(t, b.String(), "Install ArgoCD")
+	assert.Contains(t, b.String(), "github")
+}
+
+func TestUsageSummarySchema(t *testing.T) {
+	data, err := json.Marshal(NewUsageSummary("demo", time.Now(), false, nil))
+	require.NoError(t, err)
+
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(data, &fields))
+	for _, field := range []string{"schemaVersion", "clusterName", "succeeded", "startedAt", "finishedAt", "wallTimeSeconds", "phases", "bytesDownloaded", "apiCalls", "resources", "loadBalancerIPs"} {
+		assert.Contains(t, fields, field)
+	}
+	assert.JSONEq(t, "[]", string(fields["phases"]))
+}
+
+func TestReadUsageSummaryNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	_, err := WriteUsageSummary(dir, &UsageSummary{SchemaVersion: UsageSchemaVersion + 1})
+	require.NoError(t, err)
+
+	_, err = ReadUsageSummary(dir)
+	assert.ErrorContains(t, err, "schema version")
+}
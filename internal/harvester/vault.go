@@ -0,0 +1,108 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	vaultNamespace  = "vault"
+	vaultSecretName = "vault-unseal-secret"
+
+	vaultOIDCMount  = "oidc"
+	vaultOIDCRole   = "admin"
+	vaultOIDCPolicy = "sso-admin"
+)
+
+// vaultAdminPolicy grants members of the identity provider's admin group the
+// same access as the kubefirst bootstrapped admins
+const vaultAdminPolicy = `path "*" {
+  capabilities = ["create", "read", "update", "delete", "list", "sudo"]
+}
+`
+
+// NewVaultClient builds a Vault client for the platform Vault at
+// https://vault.<domainName>, authenticated with the root token kubefirst
+// stored in the cluster when Vault was initialized
+func (c *Client) NewVaultClient(ctx context.Context, domainName string) (*vaultapi.Client, error) {
+	secret, err := c.Clientset.CoreV1().Secrets(vaultNamespace).Get(ctx, vaultSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", vaultNamespace, vaultSecretName, err)
+	}
+
+	rootToken := string(secret.Data["root-token"])
+	if rootToken == "" {
+		return nil, fmt.Errorf("secret %s/%s has no root-token", vaultNamespace, vaultSecretName)
+	}
+
+	vaultClient, err := vaultapi.NewClient(&vaultapi.Config{
+		Address:    fmt.Sprintf("https://vault.%s", domainName),
+		HttpClient: c.HTTPClient,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create vault client: %w", err)
+	}
+	vaultClient.SetToken(rootToken)
+
+	return vaultClient, nil
+}
+
+// ConfigureVaultOIDC enables the Vault OIDC auth method against the identity
+// provider and maps its admin group onto an admin policy. Reruns update the
+// existing configuration in place
+func ConfigureVaultOIDC(ctx context.Context, vaultClient *vaultapi.Client, cfg OIDCConfig, domainName string) error {
+	mounts, err := vaultClient.Sys().ListAuthWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list vault auth methods: %w", err)
+	}
+
+	if _, ok := mounts[vaultOIDCMount+"/"]; !ok {
+		err := vaultClient.Sys().EnableAuthWithOptionsWithContext(ctx, vaultOIDCMount, &vaultapi.EnableAuthOptions{Type: "oidc"})
+		if err != nil {
+			return fmt.Errorf("failed to enable vault oidc auth method: %w", err)
+		}
+	}
+
+	if err := vaultClient.Sys().PutPolicyWithContext(ctx, vaultOIDCPolicy, vaultAdminPolicy); err != nil {
+		return fmt.Errorf("failed to write vault policy %q: %w", vaultOIDCPolicy, err)
+	}
+
+	_, err = vaultClient.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/config", vaultOIDCMount), map[string]interface{}{
+		"oidc_discovery_url": cfg.IssuerURL,
+		"oidc_client_id":     cfg.ClientID,
+		"oidc_client_secret": cfg.ClientSecret,
+		"default_role":       vaultOIDCRole,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure vault oidc auth method: %w", err)
+	}
+
+	_, err = vaultClient.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/role/%s", vaultOIDCMount, vaultOIDCRole), map[string]interface{}{
+		"role_type":    "oidc",
+		"user_claim":   "sub",
+		"groups_claim": "groups",
+		"oidc_scopes":  []string{"profile", "email", "groups"},
+		"bound_claims": map[string]interface{}{
+			"groups": []string{cfg.AdminGroup},
+		},
+		"token_policies": []string{vaultOIDCPolicy},
+		"allowed_redirect_uris": []string{
+			fmt.Sprintf("https://vault.%s/ui/vault/auth/%s/oidc/callback", domainName, vaultOIDCMount),
+			"http://localhost:8250/oidc/callback",
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write vault oidc role %q: %w", vaultOIDCRole, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,445 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	batchv1apply "k8s.io/client-go/applyconfigurations/batch/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// Vault audit modes, as accepted by --vault-audit
+const (
+	VaultAuditFile   = "file"
+	VaultAuditSyslog = "syslog"
+	VaultAuditOff    = "off"
+)
+
+var VaultAuditModes = []string{VaultAuditFile, VaultAuditSyslog, VaultAuditOff}
+
+const (
+	// DefaultVaultAuditSize is the default size of the audit log volume of
+	// every Vault server
+	DefaultVaultAuditSize = "10Gi"
+
+	// VaultAuditMaxFiles is how many rotated audit logs the rotation
+	// sidecar keeps next to the active one
+	VaultAuditMaxFiles = 14
+
+	vaultStatefulSet = "vault"
+	// vaultAuditVolume is the volume claim template of the Vault chart
+	// holding the audit logs, its claims are named audit-vault-<n>
+	vaultAuditVolume      = "audit"
+	vaultAuditDir         = "/vault/audit"
+	vaultAuditLog         = "vault_audit.log"
+	vaultAuditRotateImage = "busybox:1.37"
+	vaultAuditMaxBytes    = 100 << 20
+	vaultAuditSyslogTag   = "vault"
+)
+
+// vaultAuditPollInterval is how often the rollout of the audit volume
+// checks on the Vault StatefulSet and pods
+var vaultAuditPollInterval = 5 * time.Second
+
+// vaultAuditRotateScript rotates the audit log daily and once it grows past
+// MAX_BYTES, signalling Vault to reopen it, and keeps the KEEP newest
+// rotated logs. With SHIP set it streams the active log to stdout for the
+// log collector of the cluster
+const vaultAuditRotateScript = `set -u
+log="${AUDIT_DIR}/vault_audit.log"
+day="$(date +%F)"
+if [ "${SHIP}" = "true" ]; then
+  tail -F -n 0 "${log}" 2>/dev/null &
+fi
+while true; do
+  sleep 300
+  [ -f "${log}" ] || continue
+  if [ "$(date +%F)" != "${day}" ] || [ "$(wc -c < "${log}")" -gt "${MAX_BYTES}" ]; then
+    mv "${log}" "${AUDIT_DIR}/vault_audit-$(date +%Y%m%dT%H%M%S).log"
+    pkill -HUP -x vault || echo "vault did not reopen its audit log" >&2
+    day="$(date +%F)"
+    ls -1t "${AUDIT_DIR}"/vault_audit-*.log 2>/dev/null | tail -n +"$((KEEP + 1))" | xargs -r rm -f
+  fi
+done
+`
+
+// VaultAudit is how Vault audits its requests, from the --vault-audit flags.
+// The file mode writes to a volume of its own in every Vault server, rotated
+// by a sidecar, the syslog mode to the syslog of the Vault pods
+type VaultAudit struct {
+	Mode string
+	// Size is the size of the audit log volume of the file mode
+	Size string
+	// Ship streams the file audit log to the stdout of the rotation
+	// sidecar, for the log collector of the cluster
+	Ship bool
+}
+
+// ValidateVaultAuditMode ensures mode is a supported mode
+func ValidateVaultAuditMode(mode string) error {
+	if slices.Contains(VaultAuditModes, mode) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown mode %q, must be one of %v", mode, VaultAuditModes)
+}
+
+// Enabled reports whether Vault gets an audit device
+func (a VaultAudit) Enabled() bool {
+	return a.Mode != VaultAuditOff
+}
+
+// Validate ensures the mode is supported and the volume size of the file
+// mode is a quantity
+func (a VaultAudit) Validate() error {
+	if err := ValidateVaultAuditMode(a.Mode); err != nil {
+		return err
+	}
+	if a.Mode != VaultAuditFile {
+		return nil
+	}
+	if _, err := resource.ParseQuantity(a.Size); err != nil {
+		return fmt.Errorf("invalid audit volume size %q: %w", a.Size, err)
+	}
+
+	return nil
+}
+
+// devicePath is the path of the audit device of the mode in Vault
+func (a VaultAudit) devicePath() string {
+	return a.Mode + "/"
+}
+
+// deviceOptions are the options of the audit device of the mode
+func (a VaultAudit) deviceOptions() map[string]string {
+	if a.Mode == VaultAuditFile {
+		return map[string]string{"file_path": path.Join(vaultAuditDir, vaultAuditLog)}
+	}
+
+	return map[string]string{"tag": vaultAuditSyslogTag, "facility": "AUTH"}
+}
+
+// VaultAuditFiles sets the chart values of the file mode in the ArgoCD
+// application of the Vault chart of files, keyed by their path in the gitops
+// repository: the audit volume and the sidecar rotating its logs. Only the
+// files that changed are returned, none for the other modes
+func VaultAuditFiles(files map[string][]byte, audit VaultAudit, registryMirror string) (map[string][]byte, error) {
+	if audit.Mode != VaultAuditFile {
+		return map[string][]byte{}, nil
+	}
+
+	sidecar := map[string]interface{}{
+		"name":    "audit-rotate",
+		"image":   MirrorImage(vaultAuditRotateImage, registryMirror),
+		"command": []interface{}{"/bin/sh", "-c", vaultAuditRotateScript},
+		"env": []interface{}{
+			map[string]interface{}{"name": "AUDIT_DIR", "value": vaultAuditDir},
+			map[string]interface{}{"name": "MAX_BYTES", "value": fmt.Sprint(vaultAuditMaxBytes)},
+			map[string]interface{}{"name": "KEEP", "value": fmt.Sprint(VaultAuditMaxFiles)},
+			map[string]interface{}{"name": "SHIP", "value": fmt.Sprint(audit.Ship)},
+		},
+		"volumeMounts": []interface{}{
+			map[string]interface{}{"name": vaultAuditVolume, "mountPath": vaultAuditDir},
+		},
+	}
+
+	return patchChartValues(files, func(_, chart string) []chartValue {
+		if chart != "vault" {
+			return nil
+		}
+
+		return []chartValue{
+			{path: []string{"server", "auditStorage", "enabled"}, value: true},
+			{path: []string{"server", "auditStorage", "size"}, value: audit.Size},
+			{path: []string{"server", "auditStorage", "mountPath"}, value: vaultAuditDir},
+			// the sidecar signals the Vault process to reopen rotated logs
+			{path: []string{"server", "shareProcessNamespace"}, value: true},
+			{path: []string{"server", "extraContainers"}, value: []interface{}{sidecar}},
+		}
+	})
+}
+
+// RolloutVaultAuditStorage brings the audit volume of the committed chart
+// values to the running Vault servers. Volume claim templates cannot change
+// on a StatefulSet, so one without the audit volume is deleted leaving its
+// pods running and synced again by ArgoCD. The Vault chart only updates
+// pods once deleted, so every pod missing the volume is deleted in turn and
+// waited for, unsealed with the keys of vault-unseal-secret, before the
+// next one
+func (c *Client) RolloutVaultAuditStorage(ctx context.Context) error {
+	statefulSets := c.Clientset.AppsV1().StatefulSets(vaultNamespace)
+
+	statefulSet, err := statefulSets.Get(ctx, vaultStatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to read statefulset %s/%s: %w", vaultNamespace, vaultStatefulSet, err)
+	}
+	if !hasClaimTemplate(statefulSet.Spec.VolumeClaimTemplates, vaultAuditVolume) {
+		orphan := metav1.DeletePropagationOrphan
+		if err := statefulSets.Delete(ctx, vaultStatefulSet, metav1.DeleteOptions{PropagationPolicy: &orphan}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete statefulset %s/%s: %w", vaultNamespace, vaultStatefulSet, err)
+		}
+		if err := c.SyncApplication(ctx, "vault"); err != nil {
+			return err
+		}
+
+		err := c.pollVaultAudit(ctx, "the vault statefulset to be recreated with the audit volume", func() (bool, error) {
+			current, err := statefulSets.Get(ctx, vaultStatefulSet, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to read statefulset %s/%s: %w", vaultNamespace, vaultStatefulSet, err)
+			}
+			statefulSet = current
+			return hasClaimTemplate(current.Spec.VolumeClaimTemplates, vaultAuditVolume), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	pods := c.Clientset.CoreV1().Pods(vaultNamespace)
+	selector := labels.SelectorFromSet(statefulSet.Spec.Selector.MatchLabels).String()
+	list, err := pods.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return fmt.Errorf("failed to list vault pods: %w", err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].Name < list.Items[j].Name })
+
+	for _, pod := range list.Items {
+		if hasVolumeClaim(&pod, vaultAuditVolume) {
+			continue
+		}
+
+		if err := pods.Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod %s/%s: %w", vaultNamespace, pod.Name, err)
+		}
+		err := c.pollVaultAudit(ctx, fmt.Sprintf("pod %s to restart with the audit volume", pod.Name), func() (bool, error) {
+			current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return false, nil
+			}
+			if err != nil {
+				return false, fmt.Errorf("failed to read pod %s/%s: %w", vaultNamespace, pod.Name, err)
+			}
+			return current.UID != pod.UID && current.Status.Phase == corev1.PodRunning, nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := c.unsealVaultPod(ctx, pod.Name); err != nil {
+			return err
+		}
+
+		err = c.pollVaultAudit(ctx, fmt.Sprintf("pod %s to be ready", pod.Name), func() (bool, error) {
+			current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, fmt.Errorf("failed to read pod %s/%s: %w", vaultNamespace, pod.Name, err)
+			}
+			return podReady(current), nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unsealVaultPod runs a Job unsealing the Vault server of pod with the
+// unseal keys of vault-unseal-secret and waits for it to succeed
+func (c *Client) unsealVaultPod(ctx context.Context, pod string) error {
+	jobs := c.Clientset.BatchV1().Jobs(vaultNamespace)
+	name := "vault-unseal-" + pod
+
+	job := batchv1apply.Job(name, vaultNamespace).
+		WithSpec(batchv1apply.JobSpec().
+			WithBackoffLimit(3).
+			WithTTLSecondsAfterFinished(600).
+			WithTemplate(corev1apply.PodTemplateSpec().
+				WithSpec(c.vaultUnsealPodSpec(fmt.Sprintf("http://%s.vault-internal:8200", pod)))))
+
+	background := metav1.DeletePropagationBackground
+	if err := jobs.Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &background}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete previous job %s/%s: %w", vaultNamespace, name, err)
+	}
+
+	applied := false
+	return c.pollVaultAudit(ctx, fmt.Sprintf("job %s to unseal pod %s", name, pod), func() (bool, error) {
+		if !applied {
+			_, err := jobs.Apply(ctx, job, metav1.ApplyOptions{FieldManager: fieldManager, Force: true})
+			// the previous job may still be deleting
+			if err != nil && !apierrors.IsConflict(err) && !apierrors.IsInvalid(err) {
+				return false, fmt.Errorf("failed to apply job %s/%s: %w", vaultNamespace, name, err)
+			}
+			applied = err == nil
+			return false, nil
+		}
+
+		current, err := jobs.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, fmt.Errorf("failed to read job %s/%s: %w", vaultNamespace, name, err)
+		}
+		for _, condition := range current.Status.Conditions {
+			if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+				return false, fmt.Errorf("job %s/%s failed to unseal pod %s: %s", vaultNamespace, name, pod, condition.Message)
+			}
+		}
+		return current.Status.Succeeded > 0, nil
+	})
+}
+
+// pollVaultAudit polls done until it reports true, fails or ctx is done
+func (c *Client) pollVaultAudit(ctx context.Context, what string, done func() (bool, error)) error {
+	ticker := time.NewTicker(vaultAuditPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := done()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s: %w", what, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func hasClaimTemplate(templates []corev1.PersistentVolumeClaim, name string) bool {
+	for _, template := range templates {
+		if template.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hasVolumeClaim reports whether pod mounts the claim of the claim template
+// name of its StatefulSet
+func hasVolumeClaim(pod *corev1.Pod, name string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Name == name && volume.PersistentVolumeClaim != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EnableVaultAudit enables the audit device of audit in Vault, unless it is
+// already enabled
+func EnableVaultAudit(ctx context.Context, vaultClient *vaultapi.Client, audit VaultAudit) error {
+	devices, err := vaultClient.Sys().ListAuditWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list vault audit devices: %w", err)
+	}
+	if _, ok := devices[audit.devicePath()]; ok {
+		return nil
+	}
+
+	if err := vaultClient.Sys().EnableAuditWithOptionsWithContext(ctx, audit.devicePath(), &vaultapi.EnableAuditOptions{
+		Type:        audit.Mode,
+		Description: "kubefirst audit log",
+		Options:     audit.deviceOptions(),
+	}); err != nil {
+		return fmt.Errorf("failed to enable the %s audit device: %w", audit.Mode, err)
+	}
+
+	return nil
+}
+
+// VerifyVaultAudit confirms the audit device of audit is enabled with the
+// options kubefirst set and writes: Vault refuses the requests no audit
+// device manages to log, so a request through the device succeeding proves
+// it writable
+func VerifyVaultAudit(ctx context.Context, vaultClient *vaultapi.Client, audit VaultAudit) error {
+	devices, err := vaultClient.Sys().ListAuditWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list vault audit devices: %w", err)
+	}
+
+	device, ok := devices[audit.devicePath()]
+	if !ok || device.Type != audit.Mode {
+		return fmt.Errorf("the %s audit device is not enabled at %s", audit.Mode, audit.devicePath())
+	}
+	for key, value := range audit.deviceOptions() {
+		if device.Options[key] != value {
+			return fmt.Errorf("the %s audit device has %s %q, expected %q", audit.Mode, key, device.Options[key], value)
+		}
+	}
+
+	if _, err := vaultClient.Sys().AuditHashWithContext(ctx, strings.TrimSuffix(audit.devicePath(), "/"), "kubefirst"); err != nil {
+		return fmt.Errorf("the %s audit device does not write: %w", audit.Mode, err)
+	}
+
+	return nil
+}
+
+// DeleteVaultAuditClaims deletes the audit log claims of the Vault servers,
+// which the StatefulSet leaves behind, when scope removes Vault, and waits
+// until they are gone
+func (c *Client) DeleteVaultAuditClaims(ctx context.Context, scope TeardownScope, w *FinalizerWatchdog) error {
+	if !slices.Contains(scope.Namespaces, vaultNamespace) {
+		return nil
+	}
+
+	claims := c.Clientset.CoreV1().PersistentVolumeClaims(vaultNamespace)
+	list := func(ctx context.Context) ([]deletingObject, error) {
+		current, err := claims.List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list persistent volume claims in %q: %w", vaultNamespace, err)
+		}
+
+		var remaining []deletingObject
+		for i := range current.Items {
+			claim := &current.Items[i]
+			if !strings.HasPrefix(claim.Name, vaultAuditVolume+"-"+vaultStatefulSet+"-") {
+				continue
+			}
+			remaining = append(remaining, newDeletingObject("PersistentVolumeClaim", claim, func(ctx context.Context, patch []byte) error {
+				_, err := claims.Patch(ctx, claim.Name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager})
+				return err
+			}))
+		}
+		return remaining, nil
+	}
+
+	remaining, err := list(ctx)
+	if err != nil {
+		return err
+	}
+	for _, o := range remaining {
+		if err := claims.Delete(ctx, o.object.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s: %w", o, err)
+		}
+	}
+
+	return w.waitDeleted(ctx, "vault audit log claims", list)
+}
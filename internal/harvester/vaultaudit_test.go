@@ -0,0 +1,115 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVaultAuditValidate(t *testing.T) {
+	require.NoError(t, VaultAudit{Mode: VaultAuditFile, Size: DefaultVaultAuditSize}.Validate())
+	require.NoError(t, VaultAudit{Mode: VaultAuditSyslog}.Validate())
+	require.NoError(t, VaultAudit{Mode: VaultAuditOff}.Validate())
+	require.ErrorContains(t, VaultAudit{Mode: "socket"}.Validate(), `unknown mode "socket"`)
+	require.ErrorContains(t, VaultAudit{Mode: VaultAuditFile, Size: "ten"}.Validate(), `invalid audit volume size "ten"`)
+}
+
+func TestVaultAuditFiles(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kubefirst/vault.yaml":        []byte("kind: Application\nmetadata:\n  name: vault\nspec:\n  source:\n    chart: vault\n"),
+		"registry/kubefirst/cert-manager.yaml": []byte("kind: Application\nspec:\n  source:\n    chart: cert-manager\n"),
+	}
+
+	changed, err := VaultAuditFiles(files, VaultAudit{Mode: VaultAuditFile, Size: "20Gi", Ship: true}, "")
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+
+	documents, err := decodeDocuments(changed["registry/kubefirst/vault.yaml"])
+	require.NoError(t, err)
+	values := lookupValue(documents[0], "spec", "source", "helm", "valuesObject", "server").(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"enabled": true, "size": "20Gi", "mountPath": vaultAuditDir}, values["auditStorage"])
+	assert.Equal(t, true, values["shareProcessNamespace"])
+	sidecar := values["extraContainers"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, vaultAuditRotateImage, sidecar["image"])
+	assert.Contains(t, sidecar["env"], map[string]interface{}{"name": "SHIP", "value": "true"})
+
+	changed, err = VaultAuditFiles(files, VaultAudit{Mode: VaultAuditSyslog}, "")
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+}
+
+func newVaultAuditServer(t *testing.T, devices map[string]interface{}) *vaultapi.Client {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/sys/audit":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": devices})
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/sys/audit/"):
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			devices[strings.TrimPrefix(r.URL.Path, "/v1/sys/audit/")+"/"] = body
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v1/sys/audit-hash/"):
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"hash": "hmac-sha256:0"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := vaultapi.NewClient(&vaultapi.Config{Address: server.URL})
+	require.NoError(t, err)
+	client.SetToken("root")
+
+	return client
+}
+
+func TestEnableVaultAudit(t *testing.T) {
+	devices := map[string]interface{}{}
+	client := newVaultAuditServer(t, devices)
+	audit := VaultAudit{Mode: VaultAuditFile, Size: DefaultVaultAuditSize}
+	ctx := context.Background()
+
+	require.ErrorContains(t, VerifyVaultAudit(ctx, client, audit), "the file audit device is not enabled at file/")
+
+	require.NoError(t, EnableVaultAudit(ctx, client, audit))
+	require.Contains(t, devices, "file/")
+	assert.Equal(t, map[string]interface{}{"file_path": "/vault/audit/vault_audit.log"}, devices["file/"].(map[string]interface{})["options"])
+
+	require.NoError(t, EnableVaultAudit(ctx, client, audit))
+	require.NoError(t, VerifyVaultAudit(ctx, client, audit))
+
+	devices["file/"].(map[string]interface{})["options"] = map[string]interface{}{"file_path": "stdout"}
+	require.ErrorContains(t, VerifyVaultAudit(ctx, client, audit), `has file_path "stdout", expected "/vault/audit/vault_audit.log"`)
+}
+
+func TestDeleteVaultAuditClaims(t *testing.T) {
+	claim := func(name string) *corev1.PersistentVolumeClaim {
+		return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: vaultNamespace}}
+	}
+	client := &Client{Clientset: fake.NewClientset(claim("audit-vault-0"), claim("audit-vault-1"), claim("data-vault-0"))}
+	ctx := context.Background()
+	watchdog := NewFinalizerWatchdog(DefaultFinalizerTimeout, false, nil)
+
+	require.NoError(t, client.DeleteVaultAuditClaims(ctx, TeardownScope{Namespaces: []string{"argocd"}}, watchdog))
+	list, err := client.Clientset.CoreV1().PersistentVolumeClaims(vaultNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, list.Items, 3)
+
+	require.NoError(t, client.DeleteVaultAuditClaims(ctx, TeardownScope{Namespaces: []string{vaultNamespace}}, watchdog))
+	list, err = client.Clientset.CoreV1().PersistentVolumeClaims(vaultNamespace).List(ctx, metav1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, list.Items, 1)
+	assert.Equal(t, "data-vault-0", list.Items[0].Name)
+}
@@ -0,0 +1,535 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"gopkg.in/yaml.v3"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// VaultKubernetesMount is the Vault auth method workloads log in with
+	// their ServiceAccount token through
+	VaultKubernetesMount = "kubernetes"
+	// VaultPlatformAdmin is the policy, role and ServiceAccount of the
+	// platform admin in the starter policies
+	VaultPlatformAdmin = "platform-admin"
+
+	vaultKubernetesHost  = "https://kubernetes.default.svc"
+	vaultRoleTokenTTL    = "1h"
+	vaultVerifyAccount   = "kubefirst-vault-verify"
+	vaultVerifySegment   = "kubefirst-verify"
+	vaultVerifyTokenTTL  = int64(600)
+	vclusterSyncedSuffix = "-x-"
+)
+
+var (
+	vaultPolicyName   = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	vaultCapabilities = []string{"create", "read", "update", "patch", "delete", "list", "sudo", "deny"}
+)
+
+// VaultPolicies is the declarative source of the Vault policies and the
+// Kubernetes auth roles granting them, as read by vault policies apply:
+//
+//	policies:
+//	  team-dev:
+//	    - path: secret/data/vclusters/dev/*
+//	      capabilities: [read, list]
+//	roles:
+//	  - name: team-dev
+//	    vcluster: dev
+//	    namespaces: [apps]
+//	    serviceAccounts: [web]
+//	    policies: [team-dev]
+//
+// A role with a vcluster binds the ServiceAccounts of the namespaces inside
+// that vcluster, through the identities vcluster syncs them to on the host
+type VaultPolicies struct {
+	Policies map[string][]VaultPolicyRule `yaml:"policies"`
+	Roles    []VaultRole                  `yaml:"roles"`
+}
+
+// VaultPolicyRule grants capabilities on a path, which may end in * or
+// hold + segments as in Vault
+type VaultPolicyRule struct {
+	Path         string   `yaml:"path"`
+	Capabilities []string `yaml:"capabilities"`
+}
+
+// VaultRole maps the ServiceAccounts of namespaces, each possibly "*", to
+// policies
+type VaultRole struct {
+	Name            string   `yaml:"name"`
+	VCluster        string   `yaml:"vcluster,omitempty"`
+	Namespaces      []string `yaml:"namespaces"`
+	ServiceAccounts []string `yaml:"serviceAccounts"`
+	Policies        []string `yaml:"policies"`
+}
+
+// ReadVaultPolicies parses a policies file without validating it, as for
+// the previous one to prune
+func ReadVaultPolicies(content []byte) (*VaultPolicies, error) {
+	var policies VaultPolicies
+	if err := yaml.Unmarshal(content, &policies); err != nil {
+		return nil, fmt.Errorf("failed to parse vault policies: %w", err)
+	}
+
+	return &policies, nil
+}
+
+// ParseVaultPolicies parses and validates a policies file. Roles may only
+// name the vclusters in vclusters and the policies of the file
+func ParseVaultPolicies(content []byte, vclusters []string) (*VaultPolicies, error) {
+	policies, err := ReadVaultPolicies(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies.Policies) == 0 {
+		return nil, errors.New("no policies defined")
+	}
+
+	for _, name := range sortedKeys(policies.Policies) {
+		if !vaultPolicyName.MatchString(name) || name == "root" || name == "default" {
+			return nil, fmt.Errorf("invalid policy name %q, must be lowercase alphanumeric with - or _ and not root or default", name)
+		}
+		if len(policies.Policies[name]) == 0 {
+			return nil, fmt.Errorf("policy %q has no paths", name)
+		}
+		for _, rule := range policies.Policies[name] {
+			if rule.Path == "" || strings.ContainsAny(rule.Path, "\"\n") {
+				return nil, fmt.Errorf("policy %q has an invalid path %q", name, rule.Path)
+			}
+			if len(rule.Capabilities) == 0 {
+				return nil, fmt.Errorf("path %q of policy %q has no capabilities", rule.Path, name)
+			}
+			for _, capability := range rule.Capabilities {
+				if !slices.Contains(vaultCapabilities, capability) {
+					return nil, fmt.Errorf("path %q of policy %q has unknown capability %q, must be one of %s", rule.Path, name, capability, strings.Join(vaultCapabilities, ", "))
+				}
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, role := range policies.Roles {
+		if !vaultPolicyName.MatchString(role.Name) {
+			return nil, fmt.Errorf("invalid role name %q, must be lowercase alphanumeric with - or _", role.Name)
+		}
+		if seen[role.Name] {
+			return nil, fmt.Errorf("role %q is defined more than once", role.Name)
+		}
+		seen[role.Name] = true
+
+		if role.VCluster != "" && !slices.Contains(vclusters, role.VCluster) {
+			return nil, fmt.Errorf("role %q names vcluster %q, which is not in --vclusters %v", role.Name, role.VCluster, vclusters)
+		}
+		if len(role.Namespaces) == 0 || len(role.ServiceAccounts) == 0 {
+			return nil, fmt.Errorf("role %q needs namespaces and serviceAccounts, * allows any", role.Name)
+		}
+		for _, name := range append(slices.Clone(role.Namespaces), role.ServiceAccounts...) {
+			if name == "*" {
+				continue
+			}
+			if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+				return nil, fmt.Errorf("role %q binds invalid name %q: %s", role.Name, name, strings.Join(errs, "; "))
+			}
+		}
+		if len(role.Policies) == 0 {
+			return nil, fmt.Errorf("role %q grants no policies", role.Name)
+		}
+		for _, policy := range role.Policies {
+			if _, ok := policies.Policies[policy]; !ok {
+				return nil, fmt.Errorf("role %q grants policy %q, which is not defined", role.Name, policy)
+			}
+		}
+	}
+
+	return policies, nil
+}
+
+// StarterVaultPolicies returns the policies --vault-team-policies installs:
+// every vcluster reads its own vclusters/<name> path of the KV engine, and
+// the platform-admin ServiceAccount of the vault namespace administers all
+// of Vault
+func StarterVaultPolicies(vclusters []string) *VaultPolicies {
+	policies := &VaultPolicies{
+		Policies: map[string][]VaultPolicyRule{
+			VaultPlatformAdmin: {{Path: "*", Capabilities: []string{"create", "read", "update", "delete", "list", "sudo"}}},
+		},
+		Roles: []VaultRole{{
+			Name:            VaultPlatformAdmin,
+			Namespaces:      []string{vaultNamespace},
+			ServiceAccounts: []string{VaultPlatformAdmin},
+			Policies:        []string{VaultPlatformAdmin},
+		}},
+	}
+
+	for _, vcluster := range vclusters {
+		name := "vcluster-" + vcluster + "-read"
+		policies.Policies[name] = []VaultPolicyRule{
+			{Path: fmt.Sprintf("%s/data/vclusters/%s/*", vaultSeedMount, vcluster), Capabilities: []string{"read"}},
+			{Path: fmt.Sprintf("%s/metadata/vclusters/%s/*", vaultSeedMount, vcluster), Capabilities: []string{"list"}},
+		}
+		policies.Roles = append(policies.Roles, VaultRole{
+			Name:            "vcluster-" + vcluster,
+			VCluster:        vcluster,
+			Namespaces:      []string{"*"},
+			ServiceAccounts: []string{"*"},
+			Policies:        []string{name},
+		})
+	}
+
+	return policies
+}
+
+// ApplyVaultPlatformAdmin creates the ServiceAccount the platform-admin
+// role of the starter policies binds
+func (c *Client) ApplyVaultPlatformAdmin(ctx context.Context) error {
+	account := corev1apply.ServiceAccount(VaultPlatformAdmin, vaultNamespace)
+	if _, err := c.Clientset.CoreV1().ServiceAccounts(vaultNamespace).Apply(ctx, account, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	}); err != nil {
+		return fmt.Errorf("failed to apply serviceaccount %s/%s: %w", vaultNamespace, VaultPlatformAdmin, err)
+	}
+
+	return nil
+}
+
+// YAML renders the policies in the syntax ParseVaultPolicies reads
+func (p *VaultPolicies) YAML() ([]byte, error) {
+	content, err := yaml.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vault policies: %w", err)
+	}
+
+	return content, nil
+}
+
+// HCL renders the policy name in the Vault policy language
+func (p *VaultPolicies) HCL(name string) string {
+	var b strings.Builder
+	for i, rule := range p.Policies[name] {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		quoted := make([]string, 0, len(rule.Capabilities))
+		for _, capability := range rule.Capabilities {
+			quoted = append(quoted, fmt.Sprintf("%q", capability))
+		}
+		fmt.Fprintf(&b, "path %q {\n  capabilities = [%s]\n}\n", rule.Path, strings.Join(quoted, ", "))
+	}
+
+	return b.String()
+}
+
+// Bindings returns the host namespaces and ServiceAccount names role binds.
+// The ServiceAccounts of a vcluster are synced to its host namespace as
+// <name>-x-<namespace>-x-<vcluster>, so a role binding any of them binds
+// every ServiceAccount of the host namespace
+func (r VaultRole) Bindings() (namespaces, serviceAccounts []string) {
+	if r.VCluster == "" {
+		return r.Namespaces, r.ServiceAccounts
+	}
+
+	namespaces = []string{VClusterNamespace(r.VCluster)}
+	if slices.Contains(r.Namespaces, "*") || slices.Contains(r.ServiceAccounts, "*") {
+		return namespaces, []string{"*"}
+	}
+	for _, namespace := range r.Namespaces {
+		for _, serviceAccount := range r.ServiceAccounts {
+			serviceAccounts = append(serviceAccounts, serviceAccount+vclusterSyncedSuffix+namespace+vclusterSyncedSuffix+r.VCluster)
+		}
+	}
+
+	return namespaces, serviceAccounts
+}
+
+// ApplyVaultPolicies writes every policy and role of policies to Vault,
+// enabling the Kubernetes auth method first if needed, and deletes the
+// policies and roles of previous that policies no longer defines
+func ApplyVaultPolicies(ctx context.Context, vaultClient *vaultapi.Client, policies, previous *VaultPolicies) error {
+	mounts, err := vaultClient.Sys().ListAuthWithContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list vault auth methods: %w", err)
+	}
+
+	if _, ok := mounts[VaultKubernetesMount+"/"]; !ok {
+		if err := vaultClient.Sys().EnableAuthWithOptionsWithContext(ctx, VaultKubernetesMount, &vaultapi.EnableAuthOptions{Type: "kubernetes"}); err != nil {
+			return fmt.Errorf("failed to enable vault kubernetes auth method: %w", err)
+		}
+		// Vault reviews the tokens with its own ServiceAccount
+		if _, err := vaultClient.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/config", VaultKubernetesMount), map[string]interface{}{
+			"kubernetes_host": vaultKubernetesHost,
+		}); err != nil {
+			return fmt.Errorf("failed to configure vault kubernetes auth method: %w", err)
+		}
+	}
+
+	for _, name := range sortedKeys(policies.Policies) {
+		if err := vaultClient.Sys().PutPolicyWithContext(ctx, name, policies.HCL(name)); err != nil {
+			return fmt.Errorf("failed to write vault policy %q: %w", name, err)
+		}
+	}
+
+	for _, role := range policies.Roles {
+		namespaces, serviceAccounts := role.Bindings()
+		if _, err := vaultClient.Logical().WriteWithContext(ctx, vaultRolePath(role.Name), map[string]interface{}{
+			"bound_service_account_names":      serviceAccounts,
+			"bound_service_account_namespaces": namespaces,
+			"token_policies":                   role.Policies,
+			"token_ttl":                        vaultRoleTokenTTL,
+		}); err != nil {
+			return fmt.Errorf("failed to write vault role %q: %w", role.Name, err)
+		}
+	}
+
+	if previous == nil {
+		return nil
+	}
+
+	for _, role := range previous.Roles {
+		if slices.ContainsFunc(policies.Roles, func(current VaultRole) bool { return current.Name == role.Name }) {
+			continue
+		}
+		if _, err := vaultClient.Logical().DeleteWithContext(ctx, vaultRolePath(role.Name)); err != nil {
+			return fmt.Errorf("failed to delete vault role %q: %w", role.Name, err)
+		}
+	}
+	for _, name := range sortedKeys(previous.Policies) {
+		if _, ok := policies.Policies[name]; ok {
+			continue
+		}
+		if err := vaultClient.Sys().DeletePolicyWithContext(ctx, name); err != nil {
+			return fmt.Errorf("failed to delete vault policy %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// VaultRoleCheck is the outcome of logging in under a role
+type VaultRoleCheck struct {
+	Role string
+	// Skipped tells why the role could not be logged in under
+	Skipped string
+	// Violations lists the paths the role could read but should not, or
+	// should read but could not
+	Violations []string
+}
+
+// VerifyVaultPolicies logs in under every role with a token of a
+// ServiceAccount it binds and checks it reads the paths its policies grant
+// read on and none of the paths only other roles read. Wildcard bindings
+// are logged in under with a temporary ServiceAccount, a bound
+// ServiceAccount that does not exist skips the role
+func (c *Client) VerifyVaultPolicies(ctx context.Context, vaultClient *vaultapi.Client, policies *VaultPolicies) ([]VaultRoleCheck, error) {
+	checks := make([]VaultRoleCheck, 0, len(policies.Roles))
+	for _, role := range policies.Roles {
+		check := VaultRoleCheck{Role: role.Name}
+
+		jwt, skipped, err := c.vaultRoleToken(ctx, role)
+		if err != nil {
+			return nil, err
+		}
+		if skipped != "" {
+			check.Skipped = skipped
+			checks = append(checks, check)
+			continue
+		}
+
+		login, err := vaultClient.Clone()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create vault client: %w", err)
+		}
+		login.ClearToken()
+		secret, err := login.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", VaultKubernetesMount), map[string]interface{}{
+			"role": role.Name,
+			"jwt":  jwt,
+		})
+		if err == nil && (secret == nil || secret.Auth == nil) {
+			err = errors.New("no token returned")
+		}
+		if err != nil {
+			check.Violations = append(check.Violations, fmt.Sprintf("login failed: %v", err))
+			checks = append(checks, check)
+			continue
+		}
+		login.SetToken(secret.Auth.ClientToken)
+
+		allowed := policies.rules(role.Policies)
+		for _, rule := range policies.readRules() {
+			readPath := verifyPath(rule.Path)
+			shouldRead := vaultRulesAllow(allowed, readPath, "read")
+			if shouldRead == vaultCanRead(ctx, login, readPath) {
+				continue
+			}
+			if shouldRead {
+				check.Violations = append(check.Violations, fmt.Sprintf("cannot read %s", readPath))
+			} else {
+				check.Violations = append(check.Violations, fmt.Sprintf("can read %s", readPath))
+			}
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// vaultRoleToken returns a token of a ServiceAccount role binds, or why
+// there is none
+func (c *Client) vaultRoleToken(ctx context.Context, role VaultRole) (string, string, error) {
+	namespaces, serviceAccounts := role.Bindings()
+
+	namespace := namespaces[0]
+	if namespace == "*" {
+		namespace = vaultNamespace
+	}
+
+	name := serviceAccounts[0]
+	if name == "*" {
+		name = vaultVerifyAccount
+		account := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+		if _, err := c.Clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, account, metav1.CreateOptions{FieldManager: fieldManager}); err != nil && !apierrors.IsAlreadyExists(err) {
+			return "", "", fmt.Errorf("failed to create serviceaccount %s/%s: %w", namespace, name, err)
+		}
+		defer func() {
+			_ = c.Clientset.CoreV1().ServiceAccounts(namespace).Delete(context.WithoutCancel(ctx), name, metav1.DeleteOptions{})
+		}()
+	}
+
+	expiration := vaultVerifyTokenTTL
+	token, err := c.Clientset.CoreV1().ServiceAccounts(namespace).CreateToken(ctx, name, &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &expiration},
+	}, metav1.CreateOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", fmt.Sprintf("serviceaccount %s/%s does not exist", namespace, name), nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create a token of serviceaccount %s/%s: %w", namespace, name, err)
+	}
+
+	return token.Status.Token, "", nil
+}
+
+// rules returns the rules of the named policies
+func (p *VaultPolicies) rules(names []string) []VaultPolicyRule {
+	var rules []VaultPolicyRule
+	for _, name := range names {
+		rules = append(rules, p.Policies[name]...)
+	}
+
+	return rules
+}
+
+// readRules returns the rules granting read of every policy, the paths
+// verification reads under every role
+func (p *VaultPolicies) readRules() []VaultPolicyRule {
+	var rules []VaultPolicyRule
+	for _, name := range sortedKeys(p.Policies) {
+		for _, rule := range p.Policies[name] {
+			if slices.Contains(rule.Capabilities, "read") && rule.Path != "*" {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	return rules
+}
+
+// verifyPath turns the glob path of a rule into a path it matches
+func verifyPath(glob string) string {
+	segments := strings.Split(strings.TrimSuffix(glob, "*"), "/")
+	for i, segment := range segments {
+		if segment == "+" {
+			segments[i] = vaultVerifySegment
+		}
+	}
+	concrete := strings.Join(segments, "/")
+	if strings.HasSuffix(glob, "*") {
+		concrete += vaultVerifySegment
+	}
+
+	return concrete
+}
+
+// vaultRulesAllow tells whether rules grant capability on concrete, with
+// deny overriding as in Vault
+func vaultRulesAllow(rules []VaultPolicyRule, concrete, capability string) bool {
+	allowed := false
+	for _, rule := range rules {
+		if !vaultPathMatch(rule.Path, concrete) {
+			continue
+		}
+		if slices.Contains(rule.Capabilities, "deny") {
+			return false
+		}
+		if slices.Contains(rule.Capabilities, capability) {
+			allowed = true
+		}
+	}
+
+	return allowed
+}
+
+// vaultPathMatch matches concrete against a policy path, where a trailing
+// * matches any suffix and a + segment any single segment
+func vaultPathMatch(glob, concrete string) bool {
+	prefix, wildcard := strings.CutSuffix(glob, "*")
+	globSegments := strings.Split(prefix, "/")
+	segments := strings.Split(concrete, "/")
+	if len(segments) < len(globSegments) || !wildcard && len(segments) != len(globSegments) {
+		return false
+	}
+
+	for i, segment := range globSegments {
+		last := i == len(globSegments)-1
+		switch {
+		case segment == "+":
+		case last && wildcard:
+			if !strings.HasPrefix(segments[i], segment) {
+				return false
+			}
+		case segment != segments[i]:
+			return false
+		}
+	}
+
+	return true
+}
+
+// vaultCanRead tells whether the token of vaultClient may read readPath,
+// reading a path holding no secret still proves it may
+func vaultCanRead(ctx context.Context, vaultClient *vaultapi.Client, readPath string) bool {
+	_, err := vaultClient.Logical().ReadWithContext(ctx, readPath)
+
+	var respErr *vaultapi.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden {
+		return false
+	}
+
+	return err == nil
+}
+
+func vaultRolePath(name string) string {
+	return path.Join("auth", VaultKubernetesMount, "role", name)
+}
@@ -0,0 +1,105 @@
+package harvester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVaultPolicies(t *testing.T) {
+	vclusters := []string{"dev"}
+
+	starter, err := StarterVaultPolicies(vclusters).YAML()
+	require.NoError(t, err)
+	policies, err := ParseVaultPolicies(starter, vclusters)
+	require.NoError(t, err)
+	assert.Equal(t, StarterVaultPolicies(vclusters), policies)
+	assert.Equal(t, "path \"secret/data/vclusters/dev/*\" {\n  capabilities = [\"read\"]\n}\n\npath \"secret/metadata/vclusters/dev/*\" {\n  capabilities = [\"list\"]\n}\n", policies.HCL("vcluster-dev-read"))
+
+	for content, message := range map[string]string{
+		"roles: []": "no policies defined",
+		"policies: {root: [{path: a, capabilities: [read]}]}":  `invalid policy name "root"`,
+		"policies: {team: [{path: a, capabilities: [write]}]}": `unknown capability "write"`,
+		"policies: {team: [{path: a, capabilities: [read]}]}\nroles: [{name: team, vcluster: prod, namespaces: ['*'], serviceAccounts: ['*'], policies: [team]}]": `names vcluster "prod"`,
+		"policies: {team: [{path: a, capabilities: [read]}]}\nroles: [{name: team, namespaces: [apps], serviceAccounts: [web], policies: [other]}]":               `grants policy "other", which is not defined`,
+		"policies: {team: [{path: a, capabilities: [read]}]}\nroles: [{name: team, namespaces: [Apps], serviceAccounts: [web], policies: [team]}]":                `binds invalid name "Apps"`,
+	} {
+		_, err := ParseVaultPolicies([]byte(content), vclusters)
+		assert.ErrorContains(t, err, message, content)
+	}
+}
+
+func TestVaultRoleBindings(t *testing.T) {
+	namespaces, serviceAccounts := VaultRole{VCluster: "dev", Namespaces: []string{"apps", "jobs"}, ServiceAccounts: []string{"web"}}.Bindings()
+	assert.Equal(t, []string{"vcluster-dev"}, namespaces)
+	assert.Equal(t, []string{"web-x-apps-x-dev", "web-x-jobs-x-dev"}, serviceAccounts)
+
+	_, serviceAccounts = VaultRole{VCluster: "dev", Namespaces: []string{"*"}, ServiceAccounts: []string{"web"}}.Bindings()
+	assert.Equal(t, []string{"*"}, serviceAccounts)
+
+	namespaces, serviceAccounts = VaultRole{Namespaces: []string{"vault"}, ServiceAccounts: []string{"platform-admin"}}.Bindings()
+	assert.Equal(t, []string{"vault"}, namespaces)
+	assert.Equal(t, []string{"platform-admin"}, serviceAccounts)
+}
+
+func TestVaultPathMatch(t *testing.T) {
+	assert.True(t, vaultPathMatch("secret/data/vclusters/dev/*", "secret/data/vclusters/dev/app/db"))
+	assert.True(t, vaultPathMatch("secret/data/team-*", "secret/data/team-a"))
+	assert.True(t, vaultPathMatch("secret/data/+/config", "secret/data/dev/config"))
+	assert.False(t, vaultPathMatch("secret/data/+/config", "secret/data/dev/other"))
+	assert.False(t, vaultPathMatch("secret/data/vclusters/dev/*", "secret/data/vclusters/ml/app"))
+	assert.False(t, vaultPathMatch("secret/data/exact", "secret/data/exact/more"))
+
+	assert.Equal(t, "secret/data/kubefirst-verify/config/kubefirst-verify", verifyPath("secret/data/+/config/*"))
+
+	rules := []VaultPolicyRule{
+		{Path: "secret/data/*", Capabilities: []string{"read"}},
+		{Path: "secret/data/private/*", Capabilities: []string{"deny"}},
+	}
+	assert.True(t, vaultRulesAllow(rules, "secret/data/public", "read"))
+	assert.False(t, vaultRulesAllow(rules, "secret/data/private/key", "read"))
+}
+
+func TestApplyVaultPolicies(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		if r.Method == http.MethodGet && r.URL.Path == "/v1/sys/auth" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data": {"token/": {"type": "token"}}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	vaultClient, err := vaultapi.NewClient(&vaultapi.Config{Address: server.URL})
+	require.NoError(t, err)
+
+	previous := StarterVaultPolicies([]string{"dev", "ml"})
+	require.NoError(t, ApplyVaultPolicies(context.Background(), vaultClient, StarterVaultPolicies([]string{"dev"}), previous))
+
+	sort.Strings(requests)
+	assert.Equal(t, []string{
+		"DELETE /v1/auth/kubernetes/role/vcluster-ml",
+		"DELETE /v1/sys/policies/acl/vcluster-ml-read",
+		"GET /v1/sys/auth",
+		"POST /v1/sys/auth/kubernetes",
+		"PUT /v1/auth/kubernetes/config",
+		"PUT /v1/auth/kubernetes/role/platform-admin",
+		"PUT /v1/auth/kubernetes/role/vcluster-dev",
+		"PUT /v1/sys/policies/acl/platform-admin",
+		"PUT /v1/sys/policies/acl/vcluster-dev-read",
+	}, requests)
+}
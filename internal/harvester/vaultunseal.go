@@ -0,0 +1,204 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	vaultapi "github.com/hashicorp/vault/api"
+	"gopkg.in/yaml.v3"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	batchv1apply "k8s.io/client-go/applyconfigurations/batch/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+// Vault auto-unseal modes, as accepted by --vault-auto-unseal. kubefirst-api
+// initializes Vault with the Shamir seal and its chart values cannot be
+// changed from here, so the only mode keeps that seal and unseals with the
+// keys stored in the cluster
+const VaultUnsealStatic = "static"
+
+var VaultUnsealModes = []string{VaultUnsealStatic}
+
+// ValidateVaultUnsealMode ensures mode is empty or a supported mode
+func ValidateVaultUnsealMode(mode string) error {
+	if mode == "" || slices.Contains(VaultUnsealModes, mode) {
+		return nil
+	}
+
+	return fmt.Errorf("unknown mode %q, must be one of %v", mode, VaultUnsealModes)
+}
+
+const (
+	// VaultStaticUnsealName names the CronJob unsealing Vault in static mode
+	VaultStaticUnsealName = "vault-auto-unseal"
+
+	// vaultSeedMount is the KV v2 engine kubefirst enables in Vault
+	vaultSeedMount = "secret"
+
+	vaultStaticUnsealSchedule = "* * * * *"
+	vaultStaticUnsealAddress  = "http://vault-0.vault-internal:8200"
+	vaultStaticUnsealKeys     = "/vault-unseal"
+)
+
+// vaultStaticUnsealScript hands the unseal keys stored when Vault was
+// initialized to a sealed Vault until it reports itself unsealed
+const vaultStaticUnsealScript = `set -eu
+if curl -sf "${VAULT_ADDR}/v1/sys/seal-status" | grep -q '"sealed":false'; then
+  exit 0
+fi
+for key in "${UNSEAL_KEYS}"/root-unseal-key-*; do
+  if curl -sf -X PUT --data "{\"key\":\"$(cat "${key}")\"}" "${VAULT_ADDR}/v1/sys/unseal" | grep -q '"sealed":false'; then
+    echo "vault unsealed"
+    exit 0
+  fi
+done
+echo "vault is still sealed" >&2
+exit 1
+`
+
+// VaultAutoUnseal configures how Vault unseals itself after a restart. The
+// static mode unseals with the keys stored in the cluster, an empty mode
+// keeps manual unsealing
+type VaultAutoUnseal struct {
+	Mode string
+}
+
+// Enabled reports whether an auto-unseal mode is set
+func (u VaultAutoUnseal) Enabled() bool {
+	return u.Mode != ""
+}
+
+// Validate ensures the mode is known
+func (u VaultAutoUnseal) Validate() error {
+	return ValidateVaultUnsealMode(u.Mode)
+}
+
+// ApplyVaultStaticUnseal runs a CronJob unsealing Vault every minute with
+// the unseal keys kubefirst stored in vault-unseal-secret when Vault was
+// initialized. Anyone able to read that secret can unseal Vault
+func (c *Client) ApplyVaultStaticUnseal(ctx context.Context) error {
+	cronJob := batchv1apply.CronJob(VaultStaticUnsealName, vaultNamespace).
+		WithSpec(batchv1apply.CronJobSpec().
+			WithSchedule(vaultStaticUnsealSchedule).
+			WithSuspend(false).
+			WithConcurrencyPolicy(batchv1.ForbidConcurrent).
+			WithSuccessfulJobsHistoryLimit(1).
+			WithJobTemplate(batchv1apply.JobTemplateSpec().
+				WithSpec(batchv1apply.JobSpec().
+					WithBackoffLimit(0).
+					WithTemplate(corev1apply.PodTemplateSpec().
+						WithSpec(c.vaultUnsealPodSpec(vaultStaticUnsealAddress))))))
+
+	if _, err := c.Clientset.BatchV1().CronJobs(vaultNamespace).Apply(ctx, cronJob, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	}); err != nil {
+		return fmt.Errorf("failed to apply cronjob %s/%s: %w", vaultNamespace, VaultStaticUnsealName, err)
+	}
+
+	return nil
+}
+
+// vaultUnsealPodSpec runs vaultStaticUnsealScript against the Vault server
+// at address with the unseal keys of vault-unseal-secret
+func (c *Client) vaultUnsealPodSpec(address string) *corev1apply.PodSpecApplyConfiguration {
+	container := corev1apply.Container().
+		WithName("unseal").
+		WithImage(MirrorImage(vaultReplicationImage, c.RegistryMirror)).
+		WithCommand("/bin/sh", "-c", vaultStaticUnsealScript).
+		WithEnv(
+			corev1apply.EnvVar().WithName("VAULT_ADDR").WithValue(address),
+			corev1apply.EnvVar().WithName("UNSEAL_KEYS").WithValue(vaultStaticUnsealKeys),
+		).
+		WithVolumeMounts(corev1apply.VolumeMount().WithName("unseal-keys").WithMountPath(vaultStaticUnsealKeys).WithReadOnly(true))
+
+	return corev1apply.PodSpec().
+		WithRestartPolicy(corev1.RestartPolicyNever).
+		WithContainers(container).
+		WithVolumes(corev1apply.Volume().
+			WithName("unseal-keys").
+			WithSecret(corev1apply.SecretVolumeSource().WithSecretName(vaultSecretName)))
+}
+
+// VaultUnsealKeysLocation tells where the static mode reads the unseal keys
+func VaultUnsealKeysLocation() string {
+	return fmt.Sprintf("secret %s/%s, keys root-unseal-key-*", vaultNamespace, vaultSecretName)
+}
+
+// VaultSeed maps KV paths under the secret engine to the values written
+// there
+type VaultSeed map[string]map[string]string
+
+// ParseVaultSeedFile reads a --vault-seed-file, a YAML mapping of KV paths to
+// key/value pairs such as
+//
+//	apps/grafana:
+//	  admin-password: env:GRAFANA_ADMIN_PASSWORD
+//
+// Every value is resolved with ResolveSecret
+func ParseVaultSeedFile(path string) (VaultSeed, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault seed file %q: %w", path, err)
+	}
+
+	var seed VaultSeed
+	if err := yaml.Unmarshal(content, &seed); err != nil {
+		return nil, fmt.Errorf("failed to parse vault seed file %q: %w", path, err)
+	}
+	if len(seed) == 0 {
+		return nil, fmt.Errorf("vault seed file %q has no paths", path)
+	}
+
+	for _, secretPath := range sortedKeys(seed) {
+		values := seed[secretPath]
+		if strings.Trim(secretPath, "/") == "" {
+			return nil, fmt.Errorf("vault seed file %q has an empty path", path)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("path %q has no values", secretPath)
+		}
+		for _, key := range sortedKeys(values) {
+			value, err := ResolveSecret(values[key])
+			if err != nil {
+				return nil, fmt.Errorf("unable to resolve %s of path %q: %w", key, secretPath, err)
+			}
+			values[key] = value
+		}
+	}
+
+	return seed, nil
+}
+
+// Paths returns the seeded paths in order
+func (s VaultSeed) Paths() []string {
+	return sortedKeys(s)
+}
+
+// SeedVault writes every path of seed to the KV v2 engine of Vault. Seeding
+// again writes a new version of each path
+func SeedVault(ctx context.Context, vaultClient *vaultapi.Client, seed VaultSeed) error {
+	kv := vaultClient.KVv2(vaultSeedMount)
+	for _, secretPath := range seed.Paths() {
+		data := make(map[string]interface{}, len(seed[secretPath]))
+		for key, value := range seed[secretPath] {
+			data[key] = value
+		}
+		if _, err := kv.Put(ctx, strings.Trim(secretPath, "/"), data); err != nil {
+			return fmt.Errorf("failed to write vault path %s/%s: %w", vaultSeedMount, secretPath, err)
+		}
+	}
+
+	return nil
+}
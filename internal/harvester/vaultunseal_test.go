@@ -0,0 +1,45 @@
+package harvester
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVaultAutoUnsealValidate(t *testing.T) {
+	assert.NoError(t, VaultAutoUnseal{}.Validate())
+	assert.NoError(t, VaultAutoUnseal{Mode: VaultUnsealStatic}.Validate())
+	assert.ErrorContains(t, VaultAutoUnseal{Mode: "transit"}.Validate(), "unknown mode")
+}
+
+func TestApplyVaultStaticUnseal(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset(), RegistryMirror: "registry.lan:5000"}
+	require.NoError(t, client.ApplyVaultStaticUnseal(context.Background()))
+
+	cronJob, err := client.Clientset.BatchV1().CronJobs(vaultNamespace).Get(context.Background(), VaultStaticUnsealName, metav1.GetOptions{})
+	require.NoError(t, err)
+	pod := cronJob.Spec.JobTemplate.Spec.Template.Spec
+	assert.Equal(t, MirrorImage(vaultReplicationImage, "registry.lan:5000"), pod.Containers[0].Image)
+	assert.Equal(t, vaultSecretName, pod.Volumes[0].Secret.SecretName)
+}
+
+func TestParseVaultSeedFile(t *testing.T) {
+	t.Setenv("GRAFANA_ADMIN_PASSWORD", "hunter2")
+	path := filepath.Join(t.TempDir(), "seed.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("apps/grafana:\n  admin-password: env:GRAFANA_ADMIN_PASSWORD\n  admin-user: admin\nci/registry:\n  token: abc\n"), 0o600))
+
+	seed, err := ParseVaultSeedFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"apps/grafana", "ci/registry"}, seed.Paths())
+	assert.Equal(t, map[string]string{"admin-password": "hunter2", "admin-user": "admin"}, seed["apps/grafana"])
+
+	require.NoError(t, os.WriteFile(path, []byte("apps/slack:\n  webhook: env:UNSET_SLACK_WEBHOOK\n"), 0o600))
+	_, err = ParseVaultSeedFile(path)
+	assert.ErrorContains(t, err, `unable to resolve webhook of path "apps/slack"`)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// GitopsCatalogRepoURL is the repository of the gitops catalog, holding a
+// directory of manifests per catalog app
+const GitopsCatalogRepoURL = "https://github.com/konstructio/gitops-catalog.git"
+
+// ParseVClusterApps parses the vcluster:app,app entries of --vcluster-apps
+// into the catalog apps of every vcluster listed, an empty app list
+// installing none. Entries may also be given space-separated in one value.
+// Every vcluster must be one that will be created and be listed once
+func ParseVClusterApps(vclusters, entries []string) (map[string][]string, error) {
+	apps := map[string][]string{}
+	for _, value := range entries {
+		for _, entry := range strings.Fields(value) {
+			vcluster, list, ok := strings.Cut(entry, ":")
+			if !ok || vcluster == "" {
+				return nil, fmt.Errorf("%q is not a vcluster:app,app entry", entry)
+			}
+			if !slices.Contains(vclusters, vcluster) {
+				return nil, fmt.Errorf("%q in %q does not match any vcluster in --vclusters %v", vcluster, entry, vclusters)
+			}
+			if _, ok := apps[vcluster]; ok {
+				return nil, fmt.Errorf("vcluster %q is listed more than once", vcluster)
+			}
+
+			names := []string{}
+			for _, name := range strings.Split(list, ",") {
+				name = strings.TrimSpace(name)
+				if name == "" {
+					continue
+				}
+				if errs := validation.IsDNS1123Label(name); len(errs) > 0 {
+					return nil, fmt.Errorf("invalid catalog app %q in %q: %s", name, entry, strings.Join(errs, ", "))
+				}
+				if !slices.Contains(names, name) {
+					names = append(names, name)
+				}
+			}
+			apps[vcluster] = names
+		}
+	}
+
+	return apps, nil
+}
+
+// VClusterCatalogApps returns every catalog app apps lists, once each and
+// sorted, for the catalog to validate
+func VClusterCatalogApps(apps map[string][]string) []string {
+	var names []string
+	for _, vcluster := range sortedKeys(apps) {
+		for _, name := range apps[vcluster] {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// VClusterAppsFile is the registry file holding the catalog app
+// applications of vcluster
+func VClusterAppsFile(vcluster string) string {
+	return fmt.Sprintf("vcluster-apps-%s.yaml", vcluster)
+}
+
+// VClusterAppsManifests renders an ArgoCD application per catalog app of
+// vcluster, deploying the directory of the app in the gitops catalog into
+// the vcluster ArgoCD registered under its name. No apps renders a document
+// with a comment only, so committing it removes the apps of an earlier run
+func VClusterAppsManifests(vcluster string, apps []string) ([]byte, error) {
+	if len(apps) == 0 {
+		return []byte(fmt.Sprintf("# no catalog apps are installed into vcluster %s\n", vcluster)), nil
+	}
+
+	var buf bytes.Buffer
+	for i, app := range apps {
+		manifest, err := yaml.Marshal(vclusterCatalogApplication(vcluster, app))
+		if err != nil {
+			return nil, fmt.Errorf("failed to render catalog app %s of vcluster %s: %w", app, vcluster, err)
+		}
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(manifest)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// VClusterAppName is the name of the ArgoCD application installing the
+// catalog app into vcluster
+func VClusterAppName(vcluster, app string) string {
+	return fmt.Sprintf("%s-%s", vcluster, app)
+}
+
+func vclusterCatalogApplication(vcluster, app string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata": map[string]interface{}{
+			"name":       VClusterAppName(vcluster, app),
+			"namespace":  ArgoCDNamespace,
+			"finalizers": []string{"resources-finalizer.argocd.argoproj.io"},
+		},
+		"spec": map[string]interface{}{
+			"project": "default",
+			"source": map[string]interface{}{
+				"repoURL":        GitopsCatalogRepoURL,
+				"targetRevision": "HEAD",
+				"path":           app,
+				"directory":      map[string]interface{}{"recurse": true},
+			},
+			"destination": map[string]interface{}{
+				"name":      vcluster,
+				"namespace": app,
+			},
+			"syncPolicy": map[string]interface{}{
+				"automated":   map[string]interface{}{"prune": true, "selfHeal": true},
+				"syncOptions": []string{"CreateNamespace=true"},
+			},
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package harvester
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseVClusterApps(t *testing.T) {
+	vclusters := []string{"dev", "qa", "prod"}
+
+	apps, err := ParseVClusterApps(vclusters, []string{"dev:metaphor,argo prod:", "qa:metaphor,metaphor"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		"dev":  {"metaphor", "argo"},
+		"prod": {},
+		"qa":   {"metaphor"},
+	}, apps)
+	assert.Equal(t, []string{"argo", "metaphor"}, VClusterCatalogApps(apps))
+
+	tests := map[string]string{
+		"dev":             "is not a vcluster:app,app entry",
+		":metaphor":       "is not a vcluster:app,app entry",
+		"stage:metaphor":  `"stage" in "stage:metaphor" does not match any vcluster`,
+		"dev:Metaphor":    `invalid catalog app "Metaphor"`,
+		"dev: dev:argo":   `vcluster "dev" is listed more than once`,
+		"prod:a,b_c,d":    `invalid catalog app "b_c"`,
+		"qa:metaphor qa:": `vcluster "qa" is listed more than once`,
+	}
+	for entry, wantErr := range tests {
+		_, err := ParseVClusterApps(vclusters, []string{entry})
+		require.ErrorContains(t, err, wantErr, entry)
+	}
+}
+
+func TestVClusterAppsManifests(t *testing.T) {
+	manifests, err := VClusterAppsManifests("prod", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "# no catalog apps are installed into vcluster prod\n", string(manifests))
+
+	manifests, err = VClusterAppsManifests("dev", []string{"metaphor", "argo"})
+	require.NoError(t, err)
+	docs := strings.Split(string(manifests), "---\n")
+	require.Len(t, docs, 2)
+
+	var app struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+		Spec struct {
+			Source struct {
+				RepoURL string `yaml:"repoURL"`
+				Path    string `yaml:"path"`
+			} `yaml:"source"`
+			Destination struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"destination"`
+		} `yaml:"spec"`
+	}
+	require.NoError(t, yaml.Unmarshal([]byte(docs[1]), &app))
+	assert.Equal(t, "dev-argo", app.Metadata.Name)
+	assert.Equal(t, GitopsCatalogRepoURL, app.Spec.Source.RepoURL)
+	assert.Equal(t, "argo", app.Spec.Source.Path)
+	assert.Equal(t, "dev", app.Spec.Destination.Name)
+	assert.Equal(t, "argo", app.Spec.Destination.Namespace)
+}
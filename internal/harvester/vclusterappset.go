@@ -0,0 +1,147 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// VClusterAppSet is the ApplicationSet generating an ArgoCD application
+	// per directory of VClustersPath
+	VClusterAppSet       = "vclusters"
+	VClusterChartRepo    = "https://charts.loft.sh"
+	VClusterChartVersion = "0.20.0"
+
+	// VClusterValuesFile holds the chart values of a vcluster in its
+	// directory, the directory exists as long as it does
+	VClusterValuesFile = "values.yaml"
+
+	vclusterAppSetFile = "vclusters-appset.yaml"
+	vclustersDirectory = "vclusters"
+)
+
+// VClusterAppSetEnabled reports whether the vclusters are generated by the
+// ApplicationSet rather than declared one application each: it was not
+// turned off with --vcluster-appset=false and the vcluster phase runs
+func VClusterAppSetEnabled(stopAfter string, appSet bool) bool {
+	return appSet && PhaseEnabled(stopAfter, PhaseVCluster)
+}
+
+// VClustersPath is the directory of the gitops repository holding a
+// directory per vcluster in the ApplicationSet layout
+func VClustersPath(registryPath string) string {
+	return path.Join(registryPath, vclustersDirectory)
+}
+
+// VClusterDirectoryFiles returns the files committing the directory of
+// vcluster, its chart values or an empty document with the chart defaults
+func VClusterDirectoryFiles(registryPath, vcluster, values string) map[string][]byte {
+	if values == "" {
+		values = "{}\n"
+	}
+
+	return map[string][]byte{
+		path.Join(VClustersPath(registryPath), vcluster, VClusterValuesFile): []byte(values),
+	}
+}
+
+// VClusterAppSetFiles returns the files of the ApplicationSet layout: the
+// ApplicationSet in the registry directory and the directory of every
+// vcluster with its values from VClusterHelmValues. repoURL is the gitops
+// repository as ArgoCD clones it
+func VClusterAppSetFiles(repoURL, registryPath string, vclusters []string, values map[string]string) (map[string][]byte, error) {
+	manifest, err := yaml.Marshal(vclusterApplicationSet(repoURL, registryPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster applicationset: %w", err)
+	}
+
+	files := map[string][]byte{path.Join(registryPath, vclusterAppSetFile): manifest}
+	for _, vcluster := range vclusters {
+		for name, content := range VClusterDirectoryFiles(registryPath, vcluster, values[vcluster]) {
+			files[name] = content
+		}
+	}
+
+	return files, nil
+}
+
+// vclusterApplicationSet renders the ApplicationSet generating the
+// application of every vcluster directory. Each installs the vcluster chart
+// into VClusterNamespace with the values file of its directory, so adding
+// an environment is a single directory commit
+func vclusterApplicationSet(repoURL, registryPath string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "ApplicationSet",
+		"metadata": map[string]interface{}{
+			"name":      VClusterAppSet,
+			"namespace": ArgoCDNamespace,
+		},
+		"spec": map[string]interface{}{
+			"goTemplate":        true,
+			"goTemplateOptions": []string{"missingkey=error"},
+			"generators": []interface{}{
+				map[string]interface{}{
+					"git": map[string]interface{}{
+						"repoURL":     repoURL,
+						"revision":    "HEAD",
+						"directories": []interface{}{map[string]string{"path": VClustersPath(registryPath) + "/*"}},
+					},
+				},
+			},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": VClusterNamespace("{{.path.basename}}"),
+				},
+				"spec": map[string]interface{}{
+					"project": "default",
+					"sources": []interface{}{
+						map[string]interface{}{
+							"repoURL":        VClusterChartRepo,
+							"chart":          "vcluster",
+							"targetRevision": VClusterChartVersion,
+							"helm": map[string]interface{}{
+								"releaseName": "{{.path.basename}}",
+								"valueFiles":  []string{"$values/{{.path.path}}/" + VClusterValuesFile},
+							},
+						},
+						map[string]interface{}{
+							"repoURL":        repoURL,
+							"targetRevision": "HEAD",
+							"ref":            "values",
+						},
+					},
+					"destination": map[string]interface{}{
+						"server":    "https://kubernetes.default.svc",
+						"namespace": VClusterNamespace("{{.path.basename}}"),
+					},
+					"syncPolicy": map[string]interface{}{
+						"automated":   map[string]interface{}{"prune": true, "selfHeal": true},
+						"syncOptions": []string{"CreateNamespace=true"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// generatingAppSet returns the ApplicationSet that generated app, empty for
+// applications declared in the gitops repository
+func generatingAppSet(app *v1alpha1.Application) string {
+	for _, owner := range app.OwnerReferences {
+		if owner.Kind == "ApplicationSet" {
+			return owner.Name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,49 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestVClusterAppSetFiles(t *testing.T) {
+	files, err := VClusterAppSetFiles("git@github.com:holybits/harvester-argo.git", "registry/kubefirst", []string{"dev", "prod"}, map[string]string{"prod": "controlPlane: {}\n"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"registry/kubefirst/vclusters-appset.yaml",
+		"registry/kubefirst/vclusters/dev/values.yaml",
+		"registry/kubefirst/vclusters/prod/values.yaml",
+	}, sortedKeys(files))
+	assert.Equal(t, "{}\n", string(files["registry/kubefirst/vclusters/dev/values.yaml"]))
+	assert.Equal(t, "controlPlane: {}\n", string(files["registry/kubefirst/vclusters/prod/values.yaml"]))
+
+	var appSet struct {
+		Kind string `yaml:"kind"`
+		Spec struct {
+			Generators []struct {
+				Git struct {
+					RepoURL     string `yaml:"repoURL"`
+					Directories []struct {
+						Path string `yaml:"path"`
+					} `yaml:"directories"`
+				} `yaml:"git"`
+			} `yaml:"generators"`
+			Template struct {
+				Spec struct {
+					Destination struct {
+						Namespace string `yaml:"namespace"`
+					} `yaml:"destination"`
+				} `yaml:"spec"`
+			} `yaml:"template"`
+		} `yaml:"spec"`
+	}
+	require.NoError(t, yaml.Unmarshal(files["registry/kubefirst/vclusters-appset.yaml"], &appSet))
+	assert.Equal(t, "ApplicationSet", appSet.Kind)
+	require.Len(t, appSet.Spec.Generators, 1)
+	assert.Equal(t, "git@github.com:holybits/harvester-argo.git", appSet.Spec.Generators[0].Git.RepoURL)
+	assert.Equal(t, "registry/kubefirst/vclusters/*", appSet.Spec.Generators[0].Git.Directories[0].Path)
+	assert.Equal(t, VClusterNamespace("{{.path.basename}}"), appSet.Spec.Template.Spec.Destination.Namespace)
+}
@@ -0,0 +1,245 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// vclusterConnectPolicy is the Istio AuthorizationPolicy of the host
+// namespace of a vcluster admitting the vclusters connected to it
+const vclusterConnectPolicy = "kubefirst-vcluster-connect"
+
+// VClusterConnection exposes the Service Service in the namespace Namespace
+// of the vcluster Target to the vcluster Source, under the same name and
+// namespace
+type VClusterConnection struct {
+	Source    string
+	Target    string
+	Namespace string
+	Service   string
+}
+
+func (c VClusterConnection) String() string {
+	return fmt.Sprintf("%s->%s:%s/%s", c.Source, c.Target, c.Namespace, c.Service)
+}
+
+// hostService is the name of the host Service the target vcluster
+// replicates the Service to, in its host namespace
+func (c VClusterConnection) hostService() string {
+	if c.Namespace == "default" {
+		return c.Service
+	}
+
+	return c.Service + "-x-" + c.Namespace
+}
+
+// ParseVClusterConnections parses the src->dst:[namespace/]service entries
+// of --vcluster-connect, both vclusters of which must be vclusters that will
+// be created. The namespace defaults to default
+func ParseVClusterConnections(vclusters, entries []string) ([]VClusterConnection, error) {
+	connections := make([]VClusterConnection, 0, len(entries))
+	for _, entry := range entries {
+		pair, service, ok := strings.Cut(entry, ":")
+		if !ok || service == "" {
+			return nil, fmt.Errorf("%q is not a src->dst:service entry", entry)
+		}
+		source, target, ok := strings.Cut(pair, "->")
+		if !ok || source == "" || target == "" {
+			return nil, fmt.Errorf("%q is not a src->dst:service entry", entry)
+		}
+		for _, vcluster := range []string{source, target} {
+			if !slices.Contains(vclusters, vcluster) {
+				return nil, fmt.Errorf("%q in %q does not match any vcluster in --vclusters %v", vcluster, entry, vclusters)
+			}
+		}
+		if source == target {
+			return nil, fmt.Errorf("%q connects a vcluster to itself, its services already resolve", entry)
+		}
+
+		namespace, name, ok := strings.Cut(service, "/")
+		if !ok {
+			namespace, name = "default", service
+		}
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q in %q: %s", namespace, entry, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid service %q in %q: %s", name, entry, strings.Join(errs, ", "))
+		}
+
+		connection := VClusterConnection{Source: source, Target: target, Namespace: namespace, Service: name}
+		for _, other := range connections {
+			if other.Source == source && other.Namespace == namespace && other.Service == name {
+				return nil, fmt.Errorf("%q and %q both expose %s/%s in vcluster %q", other, connection, namespace, name, source)
+			}
+		}
+		connections = append(connections, connection)
+	}
+
+	return connections, nil
+}
+
+// ValidateVClusterConnectIstio ensures Istio authorizes the traffic of the
+// connections: every vcluster of the connections is in Istio ambient mode
+// per vclusterIstio. CreateFlagConstraints requires Istio for them
+func ValidateVClusterConnectIstio(connections []VClusterConnection, vclusterIstio map[string]bool) error {
+	for _, connection := range connections {
+		for _, vcluster := range []string{connection.Source, connection.Target} {
+			if !vclusterIstio[vcluster] {
+				return fmt.Errorf("%q needs vcluster %q in Istio ambient mode, set --vcluster-istio %s=true", connection, vcluster, vcluster)
+			}
+		}
+	}
+
+	return nil
+}
+
+// VClusterConnectionAllows returns the network policy allows the
+// connections need through vcluster network isolation
+func VClusterConnectionAllows(connections []VClusterConnection) []VClusterAllow {
+	var allows []VClusterAllow
+	for _, connection := range connections {
+		allow := VClusterAllow{Source: connection.Source, Destination: connection.Target}
+		if !slices.Contains(allows, allow) {
+			allows = append(allows, allow)
+		}
+	}
+
+	return allows
+}
+
+// vclusterConnectValues returns the chart values of vcluster replicating
+// its services of the connections to its host namespace, and the host
+// services of the vclusters it connects to into itself. The syncer of the
+// source vcluster creates a selectorless Service there, with Endpoints on
+// the cluster IP of the host service
+func vclusterConnectValues(vcluster string, connections []VClusterConnection) []chartValue {
+	var toHost, fromHost []interface{}
+	for _, connection := range connections {
+		virtual := connection.Namespace + "/" + connection.Service
+		host := VClusterNamespace(connection.Target) + "/" + connection.hostService()
+
+		if connection.Target == vcluster {
+			replicated := map[string]interface{}{"from": virtual, "to": host}
+			if !slices.ContainsFunc(toHost, func(v interface{}) bool { return v.(map[string]interface{})["from"] == virtual }) {
+				toHost = append(toHost, replicated)
+			}
+		}
+		if connection.Source == vcluster {
+			fromHost = append(fromHost, map[string]interface{}{"from": host, "to": virtual})
+		}
+	}
+
+	var values []chartValue
+	if len(toHost) > 0 {
+		values = append(values, chartValue{path: []string{"networking", "replicateServices", "toHost"}, value: toHost})
+	}
+	if len(fromHost) > 0 {
+		values = append(values, chartValue{path: []string{"networking", "replicateServices", "fromHost"}, value: fromHost})
+	}
+
+	return values
+}
+
+// VClusterConnectionValues adds the replicated services of vcluster in the
+// connections to its chart values
+func VClusterConnectionValues(values []byte, vcluster string, connections []VClusterConnection) ([]byte, error) {
+	set := vclusterConnectValues(vcluster, connections)
+	if len(set) == 0 {
+		return values, nil
+	}
+
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(values, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse vcluster values: %w", err)
+	}
+	for _, v := range set {
+		setValue(merged, v.value, v.path...)
+	}
+
+	rendered, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster values: %w", err)
+	}
+
+	return rendered, nil
+}
+
+// VClusterConnectionFiles sets the replicated services of the connections
+// in the helm values of the vcluster ArgoCD applications of files, keyed by
+// their path in the gitops repository, every application getting those of
+// the vcluster it is named after. Only the files that changed are returned
+func VClusterConnectionFiles(files map[string][]byte, connections []VClusterConnection) (map[string][]byte, error) {
+	if len(connections) == 0 {
+		return map[string][]byte{}, nil
+	}
+
+	return patchChartValues(files, func(application, chart string) []chartValue {
+		if chart != "vcluster" {
+			return nil
+		}
+
+		return vclusterConnectValues(application, connections)
+	})
+}
+
+// VClusterConnectionPolicies renders an Istio AuthorizationPolicy per
+// target vcluster of the connections. Once a namespace has an ALLOW policy
+// Istio denies what it does not allow, so the policy admits the vclusters
+// connected to the target and every namespace but the other vclusters
+func VClusterConnectionPolicies(vclusters []string, connections []VClusterConnection) ([]byte, error) {
+	sources := map[string][]string{}
+	for _, connection := range connections {
+		namespace := VClusterNamespace(connection.Source)
+		if !slices.Contains(sources[connection.Target], namespace) {
+			sources[connection.Target] = append(sources[connection.Target], namespace)
+		}
+	}
+
+	var documents []map[string]interface{}
+	for _, target := range sortedKeys(sources) {
+		var others []string
+		for _, vcluster := range vclusters {
+			if namespace := VClusterNamespace(vcluster); vcluster != target && !slices.Contains(sources[target], namespace) {
+				others = append(others, namespace)
+			}
+		}
+
+		host := map[string]interface{}{}
+		if len(others) > 0 {
+			host["notNamespaces"] = others
+		}
+		documents = append(documents, map[string]interface{}{
+			"apiVersion": "security.istio.io/v1",
+			"kind":       "AuthorizationPolicy",
+			"metadata": map[string]interface{}{
+				"name":      vclusterConnectPolicy,
+				"namespace": VClusterNamespace(target),
+			},
+			"spec": map[string]interface{}{
+				"action": "ALLOW",
+				"rules": []interface{}{
+					map[string]interface{}{"from": []interface{}{map[string]interface{}{"source": map[string]interface{}{"namespaces": sources[target]}}}},
+					map[string]interface{}{"from": []interface{}{map[string]interface{}{"source": host}}},
+				},
+			},
+		})
+	}
+
+	manifests, err := encodeDocuments(documents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster connection policies: %w", err)
+	}
+
+	return manifests, nil
+}
@@ -0,0 +1,78 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVClusterConnections(t *testing.T) {
+	vclusters := []string{"dev", "qa", "prod"}
+
+	connections, err := ParseVClusterConnections(vclusters, []string{"dev->prod:auth-svc", "qa->prod:shared/billing"})
+	require.NoError(t, err)
+	assert.Equal(t, []VClusterConnection{
+		{Source: "dev", Target: "prod", Namespace: "default", Service: "auth-svc"},
+		{Source: "qa", Target: "prod", Namespace: "shared", Service: "billing"},
+	}, connections)
+
+	tests := map[string]string{
+		"dev:prod":           "is not a src->dst:service entry",
+		"dev-prod:auth":      "is not a src->dst:service entry",
+		"dev->stage:auth":    `"stage" in "dev->stage:auth" does not match any vcluster`,
+		"dev->dev:auth":      "connects a vcluster to itself",
+		"dev->prod:Auth_Svc": `invalid service "Auth_Svc"`,
+		"qa->prod:Bad/svc":   `invalid namespace "Bad"`,
+	}
+	for entry, wantErr := range tests {
+		_, err := ParseVClusterConnections(vclusters, []string{entry})
+		require.ErrorContains(t, err, wantErr, entry)
+	}
+
+	_, err = ParseVClusterConnections(vclusters, []string{"dev->prod:auth", "dev->qa:auth"})
+	require.ErrorContains(t, err, `both expose default/auth in vcluster "dev"`)
+}
+
+func TestValidateVClusterConnectIstio(t *testing.T) {
+	connections := []VClusterConnection{{Source: "dev", Target: "prod", Namespace: "default", Service: "auth"}}
+
+	require.NoError(t, ValidateVClusterConnectIstio(connections, map[string]bool{"dev": true, "prod": true}))
+	require.ErrorContains(t, ValidateVClusterConnectIstio(connections, map[string]bool{"dev": true}), `needs vcluster "prod" in Istio ambient mode`)
+}
+
+func TestVClusterConnectionValues(t *testing.T) {
+	connections := []VClusterConnection{
+		{Source: "dev", Target: "prod", Namespace: "default", Service: "auth"},
+		{Source: "qa", Target: "prod", Namespace: "default", Service: "auth"},
+		{Source: "dev", Target: "qa", Namespace: "shared", Service: "billing"},
+	}
+
+	prod, err := VClusterConnectionValues(nil, "prod", connections)
+	require.NoError(t, err)
+	assert.Equal(t, "networking:\n    replicateServices:\n        toHost:\n            - from: default/auth\n              to: vcluster-prod/auth\n", string(prod))
+
+	dev, err := VClusterConnectionValues([]byte("sync: {}\n"), "dev", connections)
+	require.NoError(t, err)
+	assert.Contains(t, string(dev), "fromHost:\n            - from: vcluster-prod/auth\n              to: default/auth\n            - from: vcluster-qa/billing-x-shared\n              to: shared/billing\n")
+
+	unchanged, err := VClusterConnectionValues([]byte("sync: {}\n"), "stage", connections)
+	require.NoError(t, err)
+	assert.Equal(t, "sync: {}\n", string(unchanged))
+}
+
+func TestVClusterConnectionPolicies(t *testing.T) {
+	connections := []VClusterConnection{{Source: "dev", Target: "prod", Namespace: "default", Service: "auth"}}
+
+	manifests, err := VClusterConnectionPolicies([]string{"dev", "qa", "prod"}, connections)
+	require.NoError(t, err)
+
+	documents, err := decodeDocuments(manifests)
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	assert.Equal(t, "vcluster-prod", lookupValue(documents[0], "metadata", "namespace"))
+	rules := lookupValue(documents[0], "spec", "rules").([]interface{})
+	require.Len(t, rules, 2)
+	assert.Equal(t, []interface{}{"vcluster-dev"}, lookupValue(rules[0].(map[string]interface{})["from"].([]interface{})[0].(map[string]interface{}), "source", "namespaces"))
+	assert.Equal(t, []interface{}{"vcluster-qa"}, lookupValue(rules[1].(map[string]interface{})["from"].([]interface{})[0].(map[string]interface{}), "source", "notNamespaces"))
+}
@@ -0,0 +1,169 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// VClusterPlacement schedules the workloads of a vcluster onto the host
+// nodes labeled with NodeSelector, tolerating the taints in Tolerations
+type VClusterPlacement struct {
+	NodeSelector map[string]string
+	// Tolerations are key=value:Effect taints, as enforced by vcluster
+	Tolerations []string
+}
+
+var taintEffects = []corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute}
+
+// ParseVClusterPlacements parses the --vcluster-node-selector entries, each
+// <vcluster>:<key>=<value> restricting the vcluster to the nodes with that
+// label, or <vcluster>:<key>=<value>:<effect> also tolerating the taint
+// key=value of that effect. Entries of the same vcluster add up, entries
+// naming a vcluster not in vclusters are refused
+func ParseVClusterPlacements(vclusters, entries []string) (map[string]VClusterPlacement, error) {
+	placements := map[string]VClusterPlacement{}
+	for _, entry := range entries {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		vcluster, selector, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a <vcluster>:<key>=<value> entry", entry)
+		}
+		if !slices.Contains(vclusters, vcluster) {
+			return nil, fmt.Errorf("entry %q names vcluster %q, which is not in --vclusters %v", entry, vcluster, vclusters)
+		}
+
+		label, effect, tolerate := strings.Cut(selector, ":")
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q of entry %q is not a key=value node label", label, entry)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid node label key %q of entry %q: %s", key, entry, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid node label value %q of entry %q: %s", value, entry, strings.Join(errs, "; "))
+		}
+
+		placement := placements[vcluster]
+		if placement.NodeSelector == nil {
+			placement.NodeSelector = map[string]string{}
+		}
+		if existing, seen := placement.NodeSelector[key]; seen && existing != value {
+			return nil, fmt.Errorf("vcluster %q selects node label %s both as %q and %q", vcluster, key, existing, value)
+		}
+		placement.NodeSelector[key] = value
+
+		if tolerate {
+			if !slices.Contains(taintEffects, corev1.TaintEffect(effect)) {
+				return nil, fmt.Errorf("invalid taint effect %q of entry %q, must be one of %v", effect, entry, taintEffects)
+			}
+			if toleration := label + ":" + effect; !slices.Contains(placement.Tolerations, toleration) {
+				placement.Tolerations = append(placement.Tolerations, toleration)
+			}
+		}
+		placements[vcluster] = placement
+	}
+
+	return placements, nil
+}
+
+// String renders the placement in the --vcluster-node-selector syntax,
+// without the vcluster, or "any node" when it restricts nothing
+func (p VClusterPlacement) String() string {
+	if len(p.NodeSelector) == 0 {
+		return "any node"
+	}
+
+	var selectors []string
+	for _, key := range sortedKeys(p.NodeSelector) {
+		label := key + "=" + p.NodeSelector[key]
+		for _, toleration := range p.Tolerations {
+			if strings.HasPrefix(toleration, label+":") {
+				label = toleration
+			}
+		}
+		selectors = append(selectors, label)
+	}
+
+	return strings.Join(selectors, ",")
+}
+
+// HelmValues adds the placement to the chart values of a vcluster: only the
+// selected host nodes are synced, which vcluster enforces as the node
+// selector of the pods it syncs to the host, and the tolerations are
+// enforced on those pods
+func (p VClusterPlacement) HelmValues(values []byte) ([]byte, error) {
+	merged := map[string]interface{}{}
+	if err := yaml.Unmarshal(values, &merged); err != nil {
+		return nil, fmt.Errorf("failed to parse vcluster values: %w", err)
+	}
+
+	labels := map[string]interface{}{}
+	for key, value := range p.NodeSelector {
+		labels[key] = value
+	}
+	setValue(merged, true, "sync", "fromHost", "nodes", "enabled")
+	setValue(merged, map[string]interface{}{"labels": labels}, "sync", "fromHost", "nodes", "selector")
+
+	if len(p.Tolerations) > 0 {
+		var tolerations []interface{}
+		if pods, ok := lookupValue(merged, "sync", "toHost", "pods").(map[string]interface{}); ok {
+			tolerations, _ = pods["enforceTolerations"].([]interface{})
+		}
+		for _, toleration := range p.Tolerations {
+			if !slices.Contains(tolerations, interface{}(toleration)) {
+				tolerations = append(tolerations, toleration)
+			}
+		}
+		setValue(merged, tolerations, "sync", "toHost", "pods", "enforceTolerations")
+	}
+
+	rendered, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster values: %w", err)
+	}
+
+	return rendered, nil
+}
+
+// setValue sets the chart value at path, creating the maps leading to it
+func setValue(values map[string]interface{}, value interface{}, path ...string) {
+	node := values
+	for _, key := range path[:len(path)-1] {
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			node[key] = child
+		}
+		node = child
+	}
+	node[path[len(path)-1]] = value
+}
+
+// lookupValue returns the chart value at path, or nil
+func lookupValue(values map[string]interface{}, path ...string) interface{} {
+	var value interface{} = values
+	for _, key := range path {
+		node, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = node[key]
+	}
+
+	return value
+}
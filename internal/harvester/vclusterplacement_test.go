@@ -0,0 +1,51 @@
+package harvester
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseVClusterPlacements(t *testing.T) {
+	vclusters := []string{"dev", "ml"}
+
+	placements, err := ParseVClusterPlacements(vclusters, []string{"ml:gpu=true:NoSchedule", "ml:zone=rack-2", " "})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]VClusterPlacement{
+		"ml": {NodeSelector: map[string]string{"gpu": "true", "zone": "rack-2"}, Tolerations: []string{"gpu=true:NoSchedule"}},
+	}, placements)
+	assert.Equal(t, "gpu=true:NoSchedule,zone=rack-2", placements["ml"].String())
+	assert.Equal(t, "any node", placements["dev"].String())
+
+	for _, entry := range []string{"ml", "prod:gpu=true", "ml:gpu", "ml:gpu=true:Never", "ml:-gpu=true", "ml:gpu=not valid"} {
+		_, err := ParseVClusterPlacements(vclusters, []string{entry})
+		assert.Error(t, err, entry)
+	}
+	_, err = ParseVClusterPlacements(vclusters, []string{"ml:gpu=true", "ml:gpu=false"})
+	assert.ErrorContains(t, err, `selects node label gpu both as "true" and "false"`)
+}
+
+func TestVClusterPlacementHelmValues(t *testing.T) {
+	placements := map[string]VClusterPlacement{
+		"ml": {NodeSelector: map[string]string{"gpu": "true"}, Tolerations: []string{"gpu=true:NoSchedule"}},
+	}
+
+	values, err := VClusterHelmValues(map[string]VClusterSpec{"dev": {}, "ml": {CPU: "2"}}, true, placements)
+	require.NoError(t, err)
+	require.Contains(t, values, "dev")
+
+	var ml map[string]any
+	require.NoError(t, yaml.Unmarshal([]byte(values["ml"]), &ml))
+	assert.Equal(t, map[string]any{
+		"fromHost": map[string]any{"nodes": map[string]any{"enabled": true, "selector": map[string]any{"labels": map[string]any{"gpu": "true"}}}},
+		"toHost":   map[string]any{"pods": map[string]any{"enforceTolerations": []any{"nvidia.com/gpu=present:NoSchedule", "gpu=true:NoSchedule"}}},
+	}, ml["sync"])
+	assert.Contains(t, ml, "controlPlane")
+
+	values, err = VClusterHelmValues(map[string]VClusterSpec{"dev": {}, "ml": {}}, false, placements)
+	require.NoError(t, err)
+	assert.NotContains(t, values, "dev")
+	assert.Contains(t, values["ml"], "gpu=true:NoSchedule")
+}
@@ -0,0 +1,266 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Keys of a --vcluster-spec entry, e.g. dev=cpu:2,mem:4Gi,k8s:v1.29
+const (
+	vclusterSpecCPU        = "cpu"
+	vclusterSpecMemory     = "mem"
+	vclusterSpecKubernetes = "k8s"
+)
+
+var kubernetesVersion = regexp.MustCompile(`^v\d+\.\d+(\.\d+)?$`)
+
+// VClusterSpec sizes a vcluster and pins its Kubernetes version. Empty
+// fields fall back to --vcluster-default-spec, then to the chart defaults
+type VClusterSpec struct {
+	CPU               string
+	Memory            string
+	KubernetesVersion string
+}
+
+// ParseVClusterSpec parses the key:value pairs of a spec, such as
+// cpu:2,mem:4Gi,k8s:v1.29
+func ParseVClusterSpec(value string) (VClusterSpec, error) {
+	var spec VClusterSpec
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		if err := spec.set(pair); err != nil {
+			return VClusterSpec{}, err
+		}
+	}
+
+	return spec, nil
+}
+
+func (s *VClusterSpec) set(pair string) error {
+	key, value, ok := strings.Cut(pair, ":")
+	if !ok || value == "" {
+		return fmt.Errorf("%q is not a key:value pair", pair)
+	}
+
+	switch key {
+	case vclusterSpecCPU, vclusterSpecMemory:
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s quantity %q: %w", key, value, err)
+		}
+		if quantity.Sign() <= 0 {
+			return fmt.Errorf("%s quantity %q must be positive", key, value)
+		}
+		if key == vclusterSpecCPU {
+			s.CPU = value
+		} else {
+			s.Memory = value
+		}
+	case vclusterSpecKubernetes:
+		if !kubernetesVersion.MatchString(value) {
+			return fmt.Errorf("invalid kubernetes version %q, must look like v1.29 or v1.29.4", value)
+		}
+		s.KubernetesVersion = value
+	default:
+		return fmt.Errorf("unknown key %q, must be one of %s, %s or %s", key, vclusterSpecCPU, vclusterSpecMemory, vclusterSpecKubernetes)
+	}
+
+	return nil
+}
+
+// ResolveVClusterSpecs parses the --vcluster-spec entries, given either as
+// separate values or joined by commas like dev=cpu:2,mem:4Gi,test=cpu:1, and
+// returns the spec of every vcluster with defaultSpec filling in what an
+// entry leaves out. Entries naming a vcluster not in vclusters are refused
+func ResolveVClusterSpecs(vclusters, entries []string, defaultSpec string) (map[string]VClusterSpec, error) {
+	defaults, err := ParseVClusterSpec(defaultSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default spec: %w", err)
+	}
+
+	specs := map[string]VClusterSpec{}
+	current := ""
+	for _, token := range strings.Split(strings.Join(entries, ","), ",") {
+		if token = strings.TrimSpace(token); token == "" {
+			continue
+		}
+
+		if name, pairs, ok := strings.Cut(token, "="); ok {
+			if !slices.Contains(vclusters, name) {
+				return nil, fmt.Errorf("spec %q names vcluster %q, which is not in --vclusters %v", token, name, vclusters)
+			}
+			if _, seen := specs[name]; seen {
+				return nil, fmt.Errorf("vcluster %q has more than one spec", name)
+			}
+			current, token = name, pairs
+			specs[current] = VClusterSpec{}
+		}
+		if current == "" {
+			return nil, fmt.Errorf("%q does not follow a <vcluster>= entry", token)
+		}
+
+		spec := specs[current]
+		if err := spec.set(token); err != nil {
+			return nil, fmt.Errorf("invalid spec of vcluster %q: %w", current, err)
+		}
+		specs[current] = spec
+	}
+
+	resolved := make(map[string]VClusterSpec, len(vclusters))
+	for _, vcluster := range vclusters {
+		spec := specs[vcluster]
+		if spec.CPU == "" {
+			spec.CPU = defaults.CPU
+		}
+		if spec.Memory == "" {
+			spec.Memory = defaults.Memory
+		}
+		if spec.KubernetesVersion == "" {
+			spec.KubernetesVersion = defaults.KubernetesVersion
+		}
+		resolved[vcluster] = spec
+	}
+
+	return resolved, nil
+}
+
+// String renders the spec in the --vcluster-spec syntax, or "chart defaults"
+// when it sets nothing
+func (s VClusterSpec) String() string {
+	var pairs []string
+	if s.CPU != "" {
+		pairs = append(pairs, vclusterSpecCPU+":"+s.CPU)
+	}
+	if s.Memory != "" {
+		pairs = append(pairs, vclusterSpecMemory+":"+s.Memory)
+	}
+	if s.KubernetesVersion != "" {
+		pairs = append(pairs, vclusterSpecKubernetes+":"+s.KubernetesVersion)
+	}
+	if len(pairs) == 0 {
+		return "chart defaults"
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// HelmValues renders the vcluster chart values applying the spec: the
+// resources are set as both requests and limits of the syncer and of the
+// embedded Kubernetes control plane, and the version pins the control
+// plane components. A spec setting nothing renders no values
+func (s VClusterSpec) HelmValues() ([]byte, error) {
+	if s == (VClusterSpec{}) {
+		return nil, nil
+	}
+
+	resources := map[string]string{}
+	if s.CPU != "" {
+		resources["cpu"] = s.CPU
+	}
+	if s.Memory != "" {
+		resources["memory"] = s.Memory
+	}
+
+	k8s := map[string]interface{}{"enabled": true}
+	statefulSet := map[string]interface{}{}
+	if len(resources) > 0 {
+		k8s["resources"] = map[string]interface{}{"requests": resources, "limits": resources}
+		statefulSet["resources"] = map[string]interface{}{"requests": resources, "limits": resources}
+	}
+	if s.KubernetesVersion != "" {
+		k8s["version"] = s.KubernetesVersion
+	}
+
+	controlPlane := map[string]interface{}{"distro": map[string]interface{}{"k8s": k8s}}
+	if len(statefulSet) > 0 {
+		controlPlane["statefulSet"] = statefulSet
+	}
+
+	values, err := yaml.Marshal(map[string]interface{}{"controlPlane": controlPlane})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render vcluster values: %w", err)
+	}
+
+	return values, nil
+}
+
+// VClusterHelmValues renders the chart values of every vcluster whose spec
+// or placement sets anything, keyed by vcluster name. With gpu every
+// vcluster schedules onto the tainted --gpu-nodes, see GPUVClusterValues
+func VClusterHelmValues(specs map[string]VClusterSpec, gpu bool, placements map[string]VClusterPlacement) (map[string]string, error) {
+	values := map[string]string{}
+	for _, vcluster := range sortedKeys(specs) {
+		rendered, err := specs[vcluster].HelmValues()
+		if err == nil && gpu {
+			rendered, err = GPUVClusterValues(rendered)
+		}
+		if placement, ok := placements[vcluster]; ok && err == nil {
+			rendered, err = placement.HelmValues(rendered)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("vcluster %q: %w", vcluster, err)
+		}
+		if rendered != nil {
+			values[vcluster] = string(rendered)
+		}
+	}
+
+	return values, nil
+}
+
+// VClusterValuesFiles sets the chart values of VClusterHelmValues in the
+// helm values of the vcluster ArgoCD applications of files, keyed by their
+// path in the gitops repository, every application getting those of the
+// vcluster it is named after. It covers the per-vcluster applications of
+// the gitops template, the ApplicationSet directories hold the values
+// files instead. Only the files that changed are returned
+func VClusterValuesFiles(files map[string][]byte, values map[string]string) (map[string][]byte, error) {
+	set := map[string][]chartValue{}
+	for vcluster, rendered := range values {
+		parsed := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(rendered), &parsed); err != nil {
+			return nil, fmt.Errorf("vcluster %q: failed to parse values: %w", vcluster, err)
+		}
+		set[vcluster] = chartValuesOf(parsed, nil)
+	}
+	if len(set) == 0 {
+		return map[string][]byte{}, nil
+	}
+
+	return patchChartValues(files, func(application, chart string) []chartValue {
+		if chart != "vcluster" {
+			return nil
+		}
+
+		return set[application]
+	})
+}
+
+// chartValuesOf flattens values into the chart value of every leaf under
+// prefix, lists being leaves
+func chartValuesOf(values map[string]interface{}, prefix []string) []chartValue {
+	var flattened []chartValue
+	for _, key := range sortedKeys(values) {
+		path := append(slices.Clone(prefix), key)
+		if nested, ok := values[key].(map[string]interface{}); ok && len(nested) > 0 {
+			flattened = append(flattened, chartValuesOf(nested, path)...)
+			continue
+		}
+		flattened = append(flattened, chartValue{path: path, value: values[key]})
+	}
+
+	return flattened
+}
@@ -0,0 +1,111 @@
+package harvester
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveVClusterSpecs(t *testing.T) {
+	vclusters := []string{"dev", "test", "prod"}
+
+	specs, err := ResolveVClusterSpecs(vclusters, []string{"dev=cpu:2,mem:4Gi,k8s:v1.29", "prod=mem:8Gi"}, "cpu:1,mem:2Gi")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]VClusterSpec{
+		"dev":  {CPU: "2", Memory: "4Gi", KubernetesVersion: "v1.29"},
+		"test": {CPU: "1", Memory: "2Gi"},
+		"prod": {CPU: "1", Memory: "8Gi"},
+	}, specs)
+	assert.Equal(t, "cpu:2,mem:4Gi,k8s:v1.29", specs["dev"].String())
+
+	// a single comma-separated value holds several entries
+	joined, err := ResolveVClusterSpecs(vclusters, []string{"dev=cpu:2", "mem:4Gi", "k8s:v1.29", "prod=mem:8Gi"}, "cpu:1,mem:2Gi")
+	require.NoError(t, err)
+	assert.Equal(t, specs, joined)
+
+	for _, entries := range [][]string{
+		{"staging=cpu:2"},
+		{"dev=cpu:two"},
+		{"dev=mem:-1Gi"},
+		{"dev=k8s:1.29"},
+		{"dev=disk:10Gi"},
+		{"cpu:2"},
+		{"dev=cpu:1", "dev=mem:1Gi"},
+	} {
+		_, err := ResolveVClusterSpecs(vclusters, entries, "")
+		assert.Error(t, err, entries)
+	}
+}
+
+func TestVClusterSpecHelmValues(t *testing.T) {
+	values, err := VClusterSpec{}.HelmValues()
+	require.NoError(t, err)
+	assert.Nil(t, values)
+
+	values, err = VClusterSpec{CPU: "2", Memory: "4Gi", KubernetesVersion: "v1.29"}.HelmValues()
+	require.NoError(t, err)
+	assert.YAMLEq(t, `
+controlPlane:
+  distro:
+    k8s:
+      enabled: true
+      version: v1.29
+      resources:
+        requests: {cpu: "2", memory: 4Gi}
+        limits: {cpu: "2", memory: 4Gi}
+  statefulSet:
+    resources:
+      requests: {cpu: "2", memory: 4Gi}
+      limits: {cpu: "2", memory: 4Gi}
+`, string(values))
+}
+
+func TestVClusterValuesFiles(t *testing.T) {
+	files := map[string][]byte{
+		"registry/kubefirst/vclusters.yaml": []byte(strings.Join([]string{
+			"kind: Application",
+			"metadata:",
+			"  name: dev",
+			"spec:",
+			"  source:",
+			"    chart: vcluster",
+			"    helm:",
+			"      valuesObject:",
+			"        sync:",
+			"          toHost:",
+			"            ingresses:",
+			"              enabled: true",
+			"---",
+			"kind: Application",
+			"metadata:",
+			"  name: prod",
+			"spec:",
+			"  source:",
+			"    chart: vcluster",
+			"",
+		}, "\n")),
+		"registry/kubefirst/vault.yaml": []byte("kind: Application\nmetadata:\n  name: dev\nspec:\n  source:\n    chart: vault\n"),
+	}
+
+	values, err := VClusterHelmValues(map[string]VClusterSpec{"dev": {CPU: "2", KubernetesVersion: "v1.29"}}, false, nil)
+	require.NoError(t, err)
+
+	changed, err := VClusterValuesFiles(files, values)
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+
+	documents, err := decodeDocuments(changed["registry/kubefirst/vclusters.yaml"])
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	dev := lookupValue(documents[0], "spec", "source", "helm", "valuesObject").(map[string]interface{})
+	assert.Equal(t, "v1.29", lookupValue(dev, "controlPlane", "distro", "k8s", "version"))
+	assert.Equal(t, "2", lookupValue(dev, "controlPlane", "statefulSet", "resources", "requests", "cpu"))
+	assert.Equal(t, true, lookupValue(dev, "sync", "toHost", "ingresses", "enabled"))
+	assert.Nil(t, lookupValue(documents[1], "spec", "source", "helm"))
+
+	changed, err = VClusterValuesFiles(files, map[string]string{})
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+}
@@ -0,0 +1,300 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultVerifyTimeout is how long the platform gets to become healthy
+	// after provisioning before verification fails
+	DefaultVerifyTimeout = 10 * time.Minute
+
+	diagnosticTimeout = 30 * time.Second
+	maxPodEvents      = 3
+)
+
+// PlatformComponent is a core workload that has to be ready for the platform
+// to be usable, independently of the ArgoCD application deploying it
+type PlatformComponent struct {
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+func (p PlatformComponent) String() string {
+	return fmt.Sprintf("%s %s/%s", p.Kind, p.Namespace, p.Name)
+}
+
+// PlatformComponents returns the core components installed with the given
+// options
+func PlatformComponents(installIstio, installKgateway, vault, observability bool) []PlatformComponent {
+	var components []PlatformComponent
+	if installIstio {
+		components = append(components, PlatformComponent{Kind: "Deployment", Namespace: "istio-system", Name: "istiod"})
+	}
+	if installKgateway {
+		components = append(components, PlatformComponent{Kind: "Deployment", Namespace: WildcardGatewayNamespace, Name: "kgateway"})
+	}
+	if vault {
+		components = append(components, PlatformComponent{Kind: "StatefulSet", Namespace: vaultNamespace, Name: "vault"})
+	}
+	if observability {
+		components = append(components,
+			PlatformComponent{Kind: "StatefulSet", Namespace: ObservabilityNamespace, Name: "prometheus-" + prometheusService},
+			PlatformComponent{Kind: "Deployment", Namespace: ObservabilityNamespace, Name: grafanaService},
+		)
+	}
+
+	return components
+}
+
+// ResourceDiagnostic describes why an application or component is not
+// healthy
+type ResourceDiagnostic struct {
+	Resource string
+	Status   string
+	Message  string
+	Events   []string
+
+	namespace string
+	podPrefix string
+}
+
+// PlatformHealthError lists every application and component that did not
+// become healthy in time
+type PlatformHealthError struct {
+	Unhealthy []ResourceDiagnostic
+	Err       error
+}
+
+func (e *PlatformHealthError) Error() string {
+	resources := make([]string, 0, len(e.Unhealthy))
+	for _, diagnostic := range e.Unhealthy {
+		resources = append(resources, diagnostic.Resource)
+	}
+
+	return fmt.Sprintf("%d platform resource(s) not healthy: %s: %v", len(e.Unhealthy), strings.Join(resources, ", "), e.Err)
+}
+
+func (e *PlatformHealthError) Unwrap() error {
+	return e.Err
+}
+
+// Report renders the per-resource diagnostic, one resource per paragraph
+func (e *PlatformHealthError) Report() string {
+	var b strings.Builder
+	for _, diagnostic := range e.Unhealthy {
+		fmt.Fprintf(&b, "%s: %s\n", diagnostic.Resource, diagnostic.Status)
+		if diagnostic.Message != "" {
+			fmt.Fprintf(&b, "    %s\n", diagnostic.Message)
+		}
+		for _, event := range diagnostic.Events {
+			fmt.Fprintf(&b, "    event: %s\n", event)
+		}
+	}
+
+	return b.String()
+}
+
+// VerifyPlatformHealth polls every ArgoCD application and the given
+// components until all are Healthy/Synced. When ctx ends first it returns a
+// *PlatformHealthError with a diagnostic for each unhealthy resource
+func (c *Client) VerifyPlatformHealth(ctx context.Context, components []PlatformComponent) error {
+	ticker := time.NewTicker(applicationPollInterval)
+	defer ticker.Stop()
+
+	for {
+		unhealthy, err := c.unhealthyResources(ctx, components)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+		if err == nil && len(unhealthy) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return c.platformHealthError(ctx, unhealthy)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Client) platformHealthError(ctx context.Context, unhealthy []ResourceDiagnostic) error {
+	diagnosticCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), diagnosticTimeout)
+	defer cancel()
+
+	for i := range unhealthy {
+		unhealthy[i].Events = c.podEvents(diagnosticCtx, unhealthy[i].namespace, unhealthy[i].podPrefix)
+	}
+
+	return &PlatformHealthError{Unhealthy: unhealthy, Err: ctx.Err()}
+}
+
+func (c *Client) unhealthyResources(ctx context.Context, components []PlatformComponent) ([]ResourceDiagnostic, error) {
+	var unhealthy []ResourceDiagnostic
+
+	apps, err := c.ListApplications(ctx, "*")
+	switch {
+	case errors.Is(err, ErrArgoCDUnreachable):
+		unhealthy = append(unhealthy, ResourceDiagnostic{Resource: "ArgoCD", Status: "Unreachable", Message: err.Error()})
+	case err != nil && !errors.Is(err, ErrApplicationNotFound):
+		return nil, fmt.Errorf("failed to list argocd applications: %w", err)
+	}
+
+	for i := range apps {
+		if diagnostic, ok := applicationDiagnostic(&apps[i]); ok {
+			unhealthy = append(unhealthy, diagnostic)
+		}
+	}
+
+	for _, component := range components {
+		diagnostic, ok, err := c.componentDiagnostic(ctx, component)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			unhealthy = append(unhealthy, diagnostic)
+		}
+	}
+
+	return unhealthy, nil
+}
+
+// applicationDiagnostic describes app when it is not Synced and Healthy,
+// pointing pod events at its first unhealthy resource
+func applicationDiagnostic(app *v1alpha1.Application) (ResourceDiagnostic, bool) {
+	if IsApplicationReady(app) {
+		return ResourceDiagnostic{}, false
+	}
+
+	diagnostic := ResourceDiagnostic{
+		Resource: fmt.Sprintf("Application %s", app.Name),
+		Status:   fmt.Sprintf("%s/%s", app.Status.Sync.Status, app.Status.Health.Status),
+	}
+
+	var messages []string
+	if app.Status.Health.Message != "" {
+		messages = append(messages, app.Status.Health.Message)
+	}
+	if state := app.Status.OperationState; state != nil && state.Message != "" {
+		messages = append(messages, fmt.Sprintf("last sync: %s", state.Message))
+	}
+	for _, condition := range app.Status.Conditions {
+		messages = append(messages, fmt.Sprintf("%s: %s", condition.Type, condition.Message))
+	}
+	diagnostic.Message = strings.Join(messages, "; ")
+
+	for _, resource := range app.Status.Resources {
+		if resource.Health != nil && resource.Health.Status != health.HealthStatusHealthy && resource.Namespace != "" {
+			diagnostic.namespace = resource.Namespace
+			diagnostic.podPrefix = resource.Name
+			break
+		}
+	}
+
+	return diagnostic, true
+}
+
+func (c *Client) componentDiagnostic(ctx context.Context, component PlatformComponent) (ResourceDiagnostic, bool, error) {
+	desired, ready, err := c.workloadReplicas(ctx, component)
+
+	diagnostic := ResourceDiagnostic{
+		Resource:  component.String(),
+		namespace: component.Namespace,
+		podPrefix: component.Name,
+	}
+
+	switch {
+	case apierrors.IsNotFound(err):
+		diagnostic.Status = "Missing"
+		return diagnostic, true, nil
+	case err != nil:
+		return ResourceDiagnostic{}, false, fmt.Errorf("failed to get %s: %w", component, err)
+	case ready < desired:
+		diagnostic.Status = fmt.Sprintf("%d/%d ready", ready, desired)
+		return diagnostic, true, nil
+	}
+
+	return ResourceDiagnostic{}, false, nil
+}
+
+// workloadReplicas returns the desired and ready replica counts of the
+// component's workload
+func (c *Client) workloadReplicas(ctx context.Context, component PlatformComponent) (int32, int32, error) {
+	desired := int32(1)
+
+	switch component.Kind {
+	case "Deployment":
+		deployment, err := c.Clientset.AppsV1().Deployments(component.Namespace).Get(ctx, component.Name, metav1.GetOptions{})
+		if err != nil {
+			return 0, 0, err
+		}
+		if deployment.Spec.Replicas != nil {
+			desired = *deployment.Spec.Replicas
+		}
+		return desired, deployment.Status.ReadyReplicas, nil
+	case "StatefulSet":
+		statefulSet, err := c.Clientset.AppsV1().StatefulSets(component.Namespace).Get(ctx, component.Name, metav1.GetOptions{})
+		if err != nil {
+			return 0, 0, err
+		}
+		if statefulSet.Spec.Replicas != nil {
+			desired = *statefulSet.Spec.Replicas
+		}
+		return desired, statefulSet.Status.ReadyReplicas, nil
+	default:
+		return 0, 0, fmt.Errorf("unsupported component kind %q", component.Kind)
+	}
+}
+
+// podEvents returns the latest warning events of the pods in namespace whose
+// name starts with prefix
+func (c *Client) podEvents(ctx context.Context, namespace, prefix string) []string {
+	if namespace == "" || c.Clientset == nil {
+		return nil
+	}
+
+	events, err := c.Clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil
+	}
+
+	var warnings []corev1.Event
+	for _, event := range events.Items {
+		if event.Type == corev1.EventTypeWarning && event.InvolvedObject.Kind == "Pod" && strings.HasPrefix(event.InvolvedObject.Name, prefix) {
+			warnings = append(warnings, event)
+		}
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].LastTimestamp.Before(&warnings[j].LastTimestamp)
+	})
+	if len(warnings) > maxPodEvents {
+		warnings = warnings[len(warnings)-maxPodEvents:]
+	}
+
+	messages := make([]string, 0, len(warnings))
+	for _, event := range warnings {
+		messages = append(messages, fmt.Sprintf("Pod %s: %s: %s", event.InvolvedObject.Name, event.Reason, event.Message))
+	}
+
+	return messages
+}
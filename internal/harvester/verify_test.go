@@ -0,0 +1,82 @@
+package harvester
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	argocdfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestVerifyPlatformHealth(t *testing.T) {
+	components := PlatformComponents(true, false, true, false)
+	replicas := int32(2)
+
+	istiod := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "istiod", Namespace: "istio-system"},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: 2},
+	}
+
+	t.Run("returns once everything is healthy", func(t *testing.T) {
+		client := &Client{
+			ArgoCD: argocdfake.NewSimpleClientset(newApplication("vault", v1alpha1.SyncStatusCodeSynced, health.HealthStatusHealthy)),
+			Clientset: fake.NewSimpleClientset(istiod, &appsv1.StatefulSet{
+				ObjectMeta: metav1.ObjectMeta{Name: "vault", Namespace: "vault"},
+				Status:     appsv1.StatefulSetStatus{ReadyReplicas: 1},
+			}),
+		}
+
+		require.NoError(t, client.VerifyPlatformHealth(context.Background(), components))
+	})
+
+	t.Run("diagnoses every unhealthy resource on timeout", func(t *testing.T) {
+		app := newApplication("vault", v1alpha1.SyncStatusCodeOutOfSync, health.HealthStatusDegraded)
+		app.Status.OperationState = &v1alpha1.OperationState{Message: "one or more objects failed to apply"}
+		app.Status.Resources = []v1alpha1.ResourceStatus{{
+			Kind:      "StatefulSet",
+			Namespace: "vault",
+			Name:      "vault",
+			Health:    &v1alpha1.HealthStatus{Status: health.HealthStatusDegraded},
+		}}
+
+		client := &Client{
+			ArgoCD: argocdfake.NewSimpleClientset(app),
+			Clientset: fake.NewSimpleClientset(istiod, &corev1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "vault-0.1", Namespace: "vault"},
+				InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "vault-0"},
+				Type:           corev1.EventTypeWarning,
+				Reason:         "FailedMount",
+				Message:        "secret vault-tls not found",
+			}),
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := client.VerifyPlatformHealth(ctx, components)
+
+		var healthErr *PlatformHealthError
+		require.True(t, errors.As(err, &healthErr))
+		require.ErrorIs(t, err, context.Canceled)
+		require.Len(t, healthErr.Unhealthy, 2)
+
+		assert.Equal(t, "Application vault", healthErr.Unhealthy[0].Resource)
+		assert.Equal(t, "OutOfSync/Degraded", healthErr.Unhealthy[0].Status)
+		assert.Contains(t, healthErr.Unhealthy[0].Message, "last sync: one or more objects failed to apply")
+		assert.Equal(t, []string{"Pod vault-0: FailedMount: secret vault-tls not found"}, healthErr.Unhealthy[0].Events)
+
+		assert.Equal(t, "StatefulSet vault/vault", healthErr.Unhealthy[1].Resource)
+		assert.Equal(t, "Missing", healthErr.Unhealthy[1].Status)
+
+		assert.Contains(t, healthErr.Report(), "event: Pod vault-0: FailedMount")
+	})
+}
@@ -0,0 +1,221 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Apps inside a vcluster are exposed on the wildcard hostname by attaching
+// an HTTPRoute to the listener named after their environment, e.g.
+//
+//	parentRefs:
+//	- name: vcluster-wildcard
+//	  namespace: kgateway-system
+//	  sectionName: dev
+//
+// The route is synced into the vcluster's host namespace, which is the only
+// namespace the listener accepts routes from.
+const (
+	WildcardGatewayName      = "vcluster-wildcard"
+	WildcardGatewayNamespace = "kgateway-system"
+	WildcardGatewayClass     = "kgateway"
+	WildcardClusterIssuer    = "letsencrypt-prod"
+
+	// wildcardProbePrefix starts the random hosts WildcardProbeHosts
+	// returns
+	wildcardProbePrefix = "kubefirst-probe-"
+)
+
+// VClusterNamespace returns the host cluster namespace a vcluster runs in
+func VClusterNamespace(vcluster string) string {
+	return "vcluster-" + vcluster
+}
+
+// WildcardHostname returns the wildcard ingress hostname for a vcluster
+func WildcardHostname(vcluster, domainName string, domainMap map[string]string) string {
+	return "*." + VClusterDomain(vcluster, domainName, domainMap)
+}
+
+func wildcardCertificateName(vcluster string) string {
+	return fmt.Sprintf("%s-wildcard-tls", vcluster)
+}
+
+// WildcardCertificate renders the cert-manager Certificate backing the
+// wildcard listener of a vcluster
+func WildcardCertificate(vcluster, domainName string, domainMap map[string]string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "cert-manager.io/v1",
+		"kind":       "Certificate",
+		"metadata": map[string]interface{}{
+			"name":      wildcardCertificateName(vcluster),
+			"namespace": WildcardGatewayNamespace,
+		},
+		"spec": map[string]interface{}{
+			"secretName": wildcardCertificateName(vcluster),
+			"dnsNames":   []interface{}{WildcardHostname(vcluster, domainName, domainMap)},
+			"issuerRef": map[string]interface{}{
+				"kind": "ClusterIssuer",
+				"name": WildcardClusterIssuer,
+			},
+		},
+	}}
+}
+
+// WildcardGateway renders a single Gateway in the host cluster with one
+// HTTPS listener per vcluster, each only accepting routes from that
+// vcluster's host namespace
+func WildcardGateway(vclusters []string, domainName string, domainMap map[string]string) *unstructured.Unstructured {
+	listeners := make([]interface{}, 0, len(vclusters))
+	hostnames := ""
+
+	for i, vcluster := range vclusters {
+		hostname := WildcardHostname(vcluster, domainName, domainMap)
+		if i > 0 {
+			hostnames += ","
+		}
+		hostnames += hostname
+
+		listeners = append(listeners, map[string]interface{}{
+			"name":     vcluster,
+			"hostname": hostname,
+			"port":     int64(443),
+			"protocol": "HTTPS",
+			"tls": map[string]interface{}{
+				"mode": "Terminate",
+				"certificateRefs": []interface{}{
+					map[string]interface{}{"name": wildcardCertificateName(vcluster)},
+				},
+			},
+			"allowedRoutes": map[string]interface{}{
+				"namespaces": map[string]interface{}{
+					"from": "Selector",
+					"selector": map[string]interface{}{
+						"matchLabels": map[string]interface{}{
+							"kubernetes.io/metadata.name": VClusterNamespace(vcluster),
+						},
+					},
+				},
+			},
+		})
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       "Gateway",
+		"metadata": map[string]interface{}{
+			"name":      WildcardGatewayName,
+			"namespace": WildcardGatewayNamespace,
+			"annotations": map[string]interface{}{
+				// picked up by external-dns to publish the wildcard records
+				"external-dns.alpha.kubernetes.io/hostname": hostnames,
+			},
+		},
+		"spec": map[string]interface{}{
+			"gatewayClassName": WildcardGatewayClass,
+			"listeners":        listeners,
+		},
+	}}
+}
+
+// ApplyVClusterIngressWildcards creates the wildcard certificates and the
+// Gateway routing *.{env}.<domain> into each vcluster
+func (c *Client) ApplyVClusterIngressWildcards(ctx context.Context, vclusters []string, domainName string, domainMap map[string]string) error {
+	for _, vcluster := range vclusters {
+		if err := c.applyObject(ctx, certificateGVR, WildcardCertificate(vcluster, domainName, domainMap)); err != nil {
+			return fmt.Errorf("failed to create wildcard certificate for vcluster %q: %w", vcluster, err)
+		}
+	}
+
+	if err := c.applyObject(ctx, gatewayGVR, WildcardGateway(vclusters, domainName, domainMap)); err != nil {
+		return fmt.Errorf("failed to create wildcard gateway: %w", err)
+	}
+
+	return nil
+}
+
+// WildcardProbeHosts returns a random host under the wildcard hostname of
+// every vcluster. No record names them, only the wildcard answers for them
+func WildcardProbeHosts(vclusters []string, domainName string, domainMap map[string]string) ([]string, error) {
+	hosts := make([]string, 0, len(vclusters))
+	for _, vcluster := range vclusters {
+		label := make([]byte, 4)
+		if _, err := rand.Read(label); err != nil {
+			return nil, fmt.Errorf("failed to generate wildcard probe host: %w", err)
+		}
+		hosts = append(hosts, wildcardProbePrefix+hex.EncodeToString(label)+"."+VClusterDomain(vcluster, domainName, domainMap))
+	}
+
+	return hosts, nil
+}
+
+// ProbeWildcardIngress polls until every host is served over HTTPS with a
+// certificate valid for it, as httpClient verifies them. Any status
+// passes, no route serves the hosts so the gateway answers 404 once its
+// listener terminates TLS
+func ProbeWildcardIngress(ctx context.Context, httpClient *http.Client, hosts []string) error {
+	ticker := time.NewTicker(dnsPollInterval)
+	defer ticker.Stop()
+
+	for {
+		pending := map[string]error{}
+		for _, host := range hosts {
+			if err := probeHTTPS(ctx, httpClient, host); err != nil {
+				pending[host] = err
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return wildcardProbeError(pending)
+		case <-ticker.C:
+		}
+	}
+}
+
+func probeHTTPS(ctx context.Context, httpClient *http.Client, host string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+host+"/", nil)
+	if err != nil {
+		return fmt.Errorf("failed to build probe request: %w", err)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	return nil
+}
+
+func wildcardProbeError(pending map[string]error) error {
+	hosts := make([]string, 0, len(pending))
+	for host := range pending {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	problems := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		problems = append(problems, fmt.Sprintf("%s: %v", host, pending[host]))
+	}
+
+	return fmt.Errorf("the wildcard gateway does not serve %s", strings.Join(problems, "; "))
+}
@@ -0,0 +1,81 @@
+package harvester
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestWildcardGateway(t *testing.T) {
+	gateway := WildcardGateway([]string{"dev", "prod"}, "example.com", map[string]string{"prod": "apps.example.com"})
+
+	listeners, found, err := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Len(t, listeners, 2)
+
+	dev := listeners[0].(map[string]interface{})
+	assert.Equal(t, "dev", dev["name"])
+	assert.Equal(t, "*.dev.example.com", dev["hostname"])
+
+	namespace, _, _ := unstructured.NestedString(dev, "allowedRoutes", "namespaces", "selector", "matchLabels", "kubernetes.io/metadata.name")
+	assert.Equal(t, "vcluster-dev", namespace)
+
+	prod := listeners[1].(map[string]interface{})
+	assert.Equal(t, "*.apps.example.com", prod["hostname"])
+
+	assert.Equal(t, "*.dev.example.com,*.apps.example.com", gateway.GetAnnotations()["external-dns.alpha.kubernetes.io/hostname"])
+}
+
+func TestWildcardCertificate(t *testing.T) {
+	cert := WildcardCertificate("dev", "example.com", nil)
+
+	dnsNames, _, err := unstructured.NestedStringSlice(cert.Object, "spec", "dnsNames")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"*.dev.example.com"}, dnsNames)
+	assert.Equal(t, "dev-wildcard-tls", cert.GetName())
+}
+
+func TestWildcardProbeHosts(t *testing.T) {
+	hosts, err := WildcardProbeHosts([]string{"dev", "prod"}, "example.com", map[string]string{"prod": "apps.example.com"})
+	require.NoError(t, err)
+	require.Len(t, hosts, 2)
+	assert.True(t, strings.HasPrefix(hosts[0], "kubefirst-probe-") && strings.HasSuffix(hosts[0], ".dev.example.com"), hosts[0])
+	assert.True(t, strings.HasSuffix(hosts[1], ".apps.example.com"), hosts[1])
+
+	again, err := WildcardProbeHosts([]string{"dev"}, "example.com", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, hosts[0], again[0])
+}
+
+func TestProbeWildcardIngress(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// every host reaches the server, whose certificate is valid for
+	// example.com only
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	require.NoError(t, ProbeWildcardIngress(context.Background(), httpClient, []string{"example.com"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := ProbeWildcardIngress(ctx, httpClient, []string{"example.com", "kubefirst-probe-1.dev.example.org"})
+	require.ErrorContains(t, err, "the wildcard gateway does not serve kubefirst-probe-1.dev.example.org: ")
+	assert.ErrorContains(t, err, "certificate")
+	assert.NotContains(t, err.Error(), "example.com:")
+}
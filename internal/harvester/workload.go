@@ -0,0 +1,266 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1apply "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	ClusterTypeMgmt     = "mgmt"
+	ClusterTypeWorkload = "workload"
+
+	// WorkloadClusterLabel marks the ArgoCD cluster secret of a workload
+	// cluster with its name, the WorkloadAppSet generates an application
+	// for every secret carrying it
+	WorkloadClusterLabel = "kubefirst.konstruct.io/workload-cluster"
+	// ParentClusterLabel names the management cluster a workload cluster
+	// was registered with
+	ParentClusterLabel = "kubefirst.konstruct.io/parent-cluster"
+
+	// WorkloadAppSet is the ApplicationSet of the management cluster
+	// deploying the directory of every workload cluster to it
+	WorkloadAppSet = "workload-clusters"
+
+	workloadAppSetFile    = "workload-clusters-appset.yaml"
+	workloadsDirectory    = "workloads"
+	workloadKustomization = "kustomization.yaml"
+	workloadClusterPrefix = "cluster-"
+	workloadNamespace     = "default"
+)
+
+var ClusterTypes = []string{ClusterTypeMgmt, ClusterTypeWorkload}
+
+// ValidateClusterType ensures clusterType is one of ClusterTypes
+func ValidateClusterType(clusterType string) error {
+	if !slices.Contains(ClusterTypes, clusterType) {
+		return fmt.Errorf("unknown cluster type %q, must be one of %v", clusterType, ClusterTypes)
+	}
+
+	return nil
+}
+
+// WorkloadClusterSecretName is the ArgoCD cluster secret of the workload
+// cluster clusterName in the management cluster
+func WorkloadClusterSecretName(clusterName string) string {
+	return workloadClusterPrefix + clusterName
+}
+
+// WorkloadGitopsPath is the directory of the gitops repository of the
+// management cluster holding the manifests deployed to the workload
+// cluster clusterName, apart from the registry of any cluster
+func WorkloadGitopsPath(clusterName string) string {
+	return path.Join(workloadsDirectory, clusterName)
+}
+
+// WorkloadDirectoryFiles returns the files starting the directory of the
+// workload cluster clusterName, an empty kustomization to add its
+// manifests to
+func WorkloadDirectoryFiles(clusterName string) map[string][]byte {
+	return map[string][]byte{
+		path.Join(WorkloadGitopsPath(clusterName), workloadKustomization): []byte("apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources: []\n"),
+	}
+}
+
+// WorkloadAppSetFiles returns the WorkloadAppSet in the registry directory
+// of the management cluster. repoURL is the gitops repository as ArgoCD
+// clones it
+func WorkloadAppSetFiles(repoURL, registryPath string) (map[string][]byte, error) {
+	manifest, err := yaml.Marshal(workloadApplicationSet(repoURL))
+	if err != nil {
+		return nil, fmt.Errorf("failed to render workload cluster applicationset: %w", err)
+	}
+
+	return map[string][]byte{path.Join(registryPath, workloadAppSetFile): manifest}, nil
+}
+
+// workloadApplicationSet renders the ApplicationSet generating an
+// application per workload cluster secret, each syncing the
+// WorkloadGitopsPath of the cluster to it
+func workloadApplicationSet(repoURL string) map[string]interface{} {
+	name := `{{index .metadata.labels "` + WorkloadClusterLabel + `"}}`
+
+	return map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "ApplicationSet",
+		"metadata": map[string]interface{}{
+			"name":      WorkloadAppSet,
+			"namespace": ArgoCDNamespace,
+		},
+		"spec": map[string]interface{}{
+			"goTemplate":        true,
+			"goTemplateOptions": []string{"missingkey=error"},
+			"generators": []interface{}{
+				map[string]interface{}{
+					"clusters": map[string]interface{}{
+						"selector": map[string]interface{}{
+							"matchExpressions": []interface{}{
+								map[string]interface{}{"key": WorkloadClusterLabel, "operator": "Exists"},
+							},
+						},
+					},
+				},
+			},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "workload-" + name,
+				},
+				"spec": map[string]interface{}{
+					"project": "default",
+					"source": map[string]interface{}{
+						"repoURL":        repoURL,
+						"targetRevision": "HEAD",
+						"path":           workloadsDirectory + "/" + name,
+					},
+					"destination": map[string]interface{}{
+						"server":    "{{.server}}",
+						"namespace": workloadNamespace,
+					},
+					"syncPolicy": map[string]interface{}{
+						"automated":   map[string]interface{}{"prune": true, "selfHeal": true},
+						"syncOptions": []string{"CreateNamespace=true"},
+					},
+				},
+			},
+		},
+	}
+}
+
+type argoCDClusterConfig struct {
+	BearerToken     string                `json:"bearerToken,omitempty"`
+	Username        string                `json:"username,omitempty"`
+	Password        string                `json:"password,omitempty"`
+	TLSClientConfig argoCDTLSClientConfig `json:"tlsClientConfig"`
+}
+
+type argoCDTLSClientConfig struct {
+	Insecure   bool   `json:"insecure"`
+	ServerName string `json:"serverName,omitempty"`
+	CAData     []byte `json:"caData,omitempty"`
+	CertData   []byte `json:"certData,omitempty"`
+	KeyData    []byte `json:"keyData,omitempty"`
+}
+
+// ArgoCDClusterConfig returns how restConfig connects in the config format
+// of an ArgoCD cluster secret, with the files it references inlined. The
+// exec and auth provider plugins of a kubeconfig cannot run in ArgoCD
+func ArgoCDClusterConfig(restConfig *rest.Config) ([]byte, error) {
+	if restConfig.ExecProvider != nil || restConfig.AuthProvider != nil {
+		return nil, errors.New("the kubeconfig authenticates with a plugin ArgoCD cannot run, use one with a token or a client certificate")
+	}
+
+	inlined := rest.CopyConfig(restConfig)
+	if err := rest.LoadTLSFiles(inlined); err != nil {
+		return nil, fmt.Errorf("failed to read the certificates of the kubeconfig: %w", err)
+	}
+	token := inlined.BearerToken
+	if token == "" && inlined.BearerTokenFile != "" {
+		data, err := os.ReadFile(inlined.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the token of the kubeconfig: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	config, err := json.Marshal(argoCDClusterConfig{
+		BearerToken: token,
+		Username:    inlined.Username,
+		Password:    inlined.Password,
+		TLSClientConfig: argoCDTLSClientConfig{
+			Insecure:   inlined.Insecure,
+			ServerName: inlined.ServerName,
+			CAData:     inlined.CAData,
+			CertData:   inlined.CertData,
+			KeyData:    inlined.KeyData,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode argocd cluster config: %w", err)
+	}
+
+	return config, nil
+}
+
+// RegisterWorkloadCluster applies the ArgoCD cluster secret of the workload
+// cluster clusterName, reached with workload, to the ArgoCD of this
+// management cluster, parent. The labels of the secret name the workload
+// cluster and its parent
+func (c *Client) RegisterWorkloadCluster(ctx context.Context, clusterName, parent string, workload *rest.Config) error {
+	config, err := ArgoCDClusterConfig(workload)
+	if err != nil {
+		return err
+	}
+
+	name := WorkloadClusterSecretName(clusterName)
+	secret := corev1apply.Secret(name, ArgoCDNamespace).
+		WithLabels(map[string]string{
+			argoCDSecretTypeLabel: argoCDClusterSecret,
+			WorkloadClusterLabel:  clusterName,
+			ParentClusterLabel:    parent,
+		}).
+		WithStringData(map[string]string{
+			"name":   clusterName,
+			"server": workload.Host,
+			"config": string(config),
+		})
+
+	_, err = c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Apply(ctx, secret, metav1.ApplyOptions{
+		FieldManager: fieldManager,
+		Force:        true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply secret %s/%s: %w", ArgoCDNamespace, name, err)
+	}
+
+	return nil
+}
+
+// DeregisterWorkloadCluster deletes the ArgoCD cluster secret of the
+// workload cluster clusterName, the application generated for it going
+// with it. A cluster that is not registered is not an error
+func (c *Client) DeregisterWorkloadCluster(ctx context.Context, clusterName string) error {
+	name := WorkloadClusterSecretName(clusterName)
+	err := c.Clientset.CoreV1().Secrets(ArgoCDNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s/%s: %w", ArgoCDNamespace, name, err)
+	}
+
+	return nil
+}
+
+// SameGitopsRepository reports whether the clone urls a and b, over ssh or
+// https, name the same repository
+func SameGitopsRepository(a, b string) bool {
+	return repositoryPath(a) == repositoryPath(b)
+}
+
+// repositoryPath returns host/owner/name of a clone url
+func repositoryPath(repoURL string) string {
+	repoURL = strings.ToLower(strings.TrimSpace(repoURL))
+	if parsed, err := url.Parse(repoURL); err == nil && parsed.Host != "" {
+		repoURL = parsed.Hostname() + "/" + strings.TrimPrefix(parsed.Path, "/")
+	} else if user, location, ok := strings.Cut(repoURL, "@"); ok && !strings.Contains(user, "/") {
+		// scp-like ssh, git@host:owner/name.git
+		repoURL = strings.Replace(location, ":", "/", 1)
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(repoURL, "/"), ".git")
+}
@@ -0,0 +1,76 @@
+package harvester
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func TestRegisterWorkloadCluster(t *testing.T) {
+	client := &Client{Clientset: fake.NewClientset()}
+	workload := &rest.Config{
+		Host:            "https://10.0.12.20:6443",
+		BearerToken:     "token",
+		TLSClientConfig: rest.TLSClientConfig{CAData: []byte("ca")},
+	}
+
+	require.NoError(t, client.RegisterWorkloadCluster(context.Background(), "edge", "homelab", workload))
+
+	secret, err := client.Clientset.CoreV1().Secrets(ArgoCDNamespace).Get(context.Background(), "cluster-edge", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		argoCDSecretTypeLabel: "cluster",
+		WorkloadClusterLabel:  "edge",
+		ParentClusterLabel:    "homelab",
+	}, secret.Labels)
+	assert.Equal(t, "https://10.0.12.20:6443", secret.StringData["server"])
+
+	var config argoCDClusterConfig
+	require.NoError(t, json.Unmarshal([]byte(secret.StringData["config"]), &config))
+	assert.Equal(t, "token", config.BearerToken)
+	assert.Equal(t, []byte("ca"), config.TLSClientConfig.CAData)
+
+	require.NoError(t, client.DeregisterWorkloadCluster(context.Background(), "edge"))
+	require.NoError(t, client.DeregisterWorkloadCluster(context.Background(), "edge"))
+
+	_, err = ArgoCDClusterConfig(&rest.Config{ExecProvider: &clientcmdapi.ExecConfig{Command: "aws"}})
+	require.ErrorContains(t, err, "plugin")
+}
+
+func TestWorkloadAppSetFiles(t *testing.T) {
+	files, err := WorkloadAppSetFiles("git@github.com:holybits/gitops.git", "registry/homelab")
+	require.NoError(t, err)
+	require.Contains(t, files, "registry/homelab/workload-clusters-appset.yaml")
+
+	var appSet struct {
+		Spec struct {
+			Template struct {
+				Spec struct {
+					Source struct {
+						Path string `yaml:"path"`
+					} `yaml:"source"`
+				} `yaml:"spec"`
+			} `yaml:"template"`
+		} `yaml:"spec"`
+	}
+	require.NoError(t, yaml.Unmarshal(files["registry/homelab/workload-clusters-appset.yaml"], &appSet))
+	assert.Equal(t, `workloads/{{index .metadata.labels "kubefirst.konstruct.io/workload-cluster"}}`, appSet.Spec.Template.Spec.Source.Path)
+	assert.Contains(t, WorkloadDirectoryFiles("edge"), "workloads/edge/kustomization.yaml")
+}
+
+func TestSameGitopsRepository(t *testing.T) {
+	assert.True(t, SameGitopsRepository("git@github.com:HolyBits/gitops.git", "https://github.com/holybits/gitops.git"))
+	assert.True(t, SameGitopsRepository("ssh://git@git.example.com/holybits/gitops", "https://git.example.com/holybits/gitops.git"))
+	assert.False(t, SameGitopsRepository("git@github.com:holybits/gitops.git", "https://github.com/holybits/gitops-edge.git"))
+
+	require.NoError(t, ValidateClusterType("workload"))
+	require.ErrorContains(t, ValidateClusterType("edge"), `unknown cluster type "edge"`)
+}
@@ -7,6 +7,7 @@ See the LICENSE file for more details.
 package progress
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -18,7 +19,8 @@ import (
 // Commands
 func GetClusterInterval(clusterName string) tea.Cmd {
 	return tea.Every(time.Second*10, func(_ time.Time) tea.Msg {
-		provisioningCluster, err := cluster.GetCluster(clusterName)
+		// the progress terminal is driven by bubbletea ticks, not a command context
+		provisioningCluster, err := cluster.GetCluster(context.Background(), clusterName)
 		if err != nil {
 			log.Printf("failed to get cluster %q: %v", clusterName, err)
 			return nil
@@ -18,6 +18,7 @@ import (
 	utils "github.com/konstructio/kubefirst-api/pkg/utils"
 	"github.com/konstructio/kubefirst/internal/cluster"
 	"github.com/konstructio/kubefirst/internal/gitShim"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
 	"github.com/konstructio/kubefirst/internal/launch"
 	"github.com/konstructio/kubefirst/internal/progress"
 	"github.com/konstructio/kubefirst/internal/step"
@@ -27,7 +28,7 @@ import (
 	"github.com/spf13/viper"
 )
 
-func CreateMgmtClusterRequest(gitAuth apiTypes.GitAuth, cliFlags types.CliFlags, catalogApps []apiTypes.GitopsCatalogApp) error {
+func CreateMgmtClusterRequest(ctx context.Context, gitAuth apiTypes.GitAuth, cliFlags types.CliFlags, catalogApps []apiTypes.GitopsCatalogApp) error {
 	clusterRecord, err := utilities.CreateClusterDefinitionRecordFromRaw(
 		gitAuth,
 		cliFlags,
@@ -37,37 +38,67 @@ func CreateMgmtClusterRequest(gitAuth apiTypes.GitAuth, cliFlags types.CliFlags,
 		return fmt.Errorf("error creating cluster definition record: %w", err)
 	}
 
-	clusterCreated, err := cluster.GetCluster(clusterRecord.ClusterName)
-	if err != nil && !errors.Is(err, cluster.ErrNotFound) {
+	// labels given again replace the recorded ones, a resumed run without
+	// them keeps them
+	if len(cliFlags.ClusterLabels) > 0 {
+		if err := cluster.SetClusterLabels(ctx, clusterRecord.ClusterName, cliFlags.ClusterLabels); err != nil {
+			return fmt.Errorf("error recording cluster labels: %w", err)
+		}
+	}
+
+	clusterCreated, err := cluster.GetCluster(ctx, clusterRecord.ClusterName)
+	if err != nil && !errors.Is(err, cluster.ErrClusterNotFound) {
 		log.Printf("error retrieving cluster %q: %v", clusterRecord.ClusterName, err)
 		return fmt.Errorf("error retrieving cluster: %w", err)
 	}
 
-	if errors.Is(err, cluster.ErrNotFound) {
-		if err := cluster.CreateCluster(*clusterRecord); err != nil {
+	if errors.Is(err, cluster.ErrClusterNotFound) {
+		if err := cluster.CreateCluster(ctx, *clusterRecord); err != nil {
 			return fmt.Errorf("error creating cluster: %w", err)
 		}
+
+		return nil
 	}
 
 	if clusterCreated.Status == "error" {
-		cluster.ResetClusterProgress(clusterRecord.ClusterName)
-		if err := cluster.CreateCluster(*clusterRecord); err != nil {
+		if err := cluster.ResetClusterProgress(ctx, clusterRecord.ClusterName); err != nil {
+			return fmt.Errorf("error resetting cluster progress after error state: %w", err)
+		}
+		if err := cluster.CreateCluster(ctx, *clusterRecord); err != nil {
 			return fmt.Errorf("error re-creating cluster after error state: %w", err)
 		}
+
+		return nil
+	}
+
+	// --force overwrites the state left behind by a previous attempt
+	if cliFlags.Force {
+		if err := cluster.ResetClusterProgress(ctx, clusterRecord.ClusterName); err != nil {
+			return fmt.Errorf("error resetting cluster progress over existing state: %w", err)
+		}
+		if err := cluster.CreateCluster(ctx, *clusterRecord); err != nil {
+			return fmt.Errorf("error re-creating cluster over existing state: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// PollInterval is how often the provisioner asks kubefirst-api for the
+// progress of the install steps
+const PollInterval = 5 * time.Second
+
 type Provisioner struct {
-	watcher *Watcher
-	stepper step.Stepper
+	watcher      *Watcher
+	stepper      step.Stepper
+	pollInterval time.Duration
 }
 
 func NewProvisioner(watcher *Watcher, stepper step.Stepper) *Provisioner {
 	return &Provisioner{
-		watcher: watcher,
-		stepper: stepper,
+		watcher:      watcher,
+		stepper:      stepper,
+		pollInterval: PollInterval,
 	}
 }
 
@@ -75,7 +106,7 @@ func (p *Provisioner) ProvisionManagementCluster(ctx context.Context, cliFlags *
 	p.stepper.NewProgressStep("Initialize Configuration")
 
 	clusterSetupComplete := viper.GetBool("kubefirst-checks.cluster-install-complete")
-	if clusterSetupComplete {
+	if clusterSetupComplete && !cliFlags.Force && cliFlags.ResumeFrom == "" {
 		p.stepper.InfoStep(step.EmojiCheck, "Cluster already successfully provisioned")
 
 		return nil
@@ -83,31 +114,66 @@ func (p *Provisioner) ProvisionManagementCluster(ctx context.Context, cliFlags *
 
 	utilities.CreateK1ClusterDirectory(cliFlags.ClusterName)
 
+	// the phases failing transiently are run again, configuration errors
+	// fail at once
+	retry := internalharvester.NewPhaseRetry(cliFlags.RetryCount, cliFlags.RetryBackoff, p.stepper)
+
 	p.stepper.NewProgressStep("Validate Git Credentials")
+	if err := stopped(ctx, "Validate Git Credentials"); err != nil {
+		return err
+	}
 
-	gitAuth, err := gitShim.ValidateGitCredentials(cliFlags.GitProvider, cliFlags.GithubOrg, cliFlags.GitlabGroup)
+	httpClient, err := internalharvester.NewHTTPClient(cliFlags.Proxy)
 	if err != nil {
-		return fmt.Errorf("failed to validate git credentials: %w", err)
+		wrerr := fmt.Errorf("failed to create git provider client: %w", err)
+		p.stepper.FailCurrentStep(wrerr)
+		return wrerr
 	}
 
-	// Validate git
-	executionControl := viper.GetBool(fmt.Sprintf("kubefirst-checks.%s-credentials", cliFlags.GitProvider))
-	if !executionControl {
-		newRepositoryNames := []string{"gitops", "metaphor"}
-		newTeamNames := []string{"admins", "developers"}
-
-		initGitParameters := gitShim.GitInitParameters{
-			GitProvider:  cliFlags.GitProvider,
-			GitToken:     gitAuth.Token,
-			GitOwner:     gitAuth.Owner,
-			Repositories: newRepositoryNames,
-			Teams:        newTeamNames,
+	var gitAuth apiTypes.GitAuth
+	err = retry.Do(ctx, `phase "Validate Git Credentials"`, func(ctx context.Context) error {
+		// checked before the token, the organization endpoints answer
+		// tokens not authorized for its SAML SSO with a 404. Missing owners
+		// and tokens are reported by ValidateGitCredentials
+		owner := cliFlags.GithubOrg
+		if cliFlags.GitProvider == "gitlab" {
+			owner = cliFlags.GitlabGroup
+		}
+		if token := os.Getenv(internalharvester.GitTokenEnv(cliFlags.GitProvider)); owner != "" && token != "" {
+			if err := internalharvester.CheckGitSSO(ctx, httpClient, cliFlags.GitProvider, owner, token); err != nil {
+				return fmt.Errorf("failed to validate git credentials: %w", err)
+			}
 		}
 
-		err = gitShim.InitializeGitProvider(&initGitParameters)
+		var err error
+		gitAuth, err = gitShim.ValidateGitCredentials(cliFlags.GitProvider, cliFlags.GithubOrg, cliFlags.GitlabGroup, httpClient)
 		if err != nil {
-			return fmt.Errorf("failed to initialize Git provider: %w", err)
+			return fmt.Errorf("failed to validate git credentials: %w", err)
+		}
+
+		// Validate git
+		executionControl := viper.GetBool(fmt.Sprintf("kubefirst-checks.%s-credentials", cliFlags.GitProvider))
+		if !executionControl {
+			newRepositoryNames := []string{"gitops", "metaphor"}
+			newTeamNames := []string{"admins", "developers"}
+
+			initGitParameters := gitShim.GitInitParameters{
+				GitProvider:  cliFlags.GitProvider,
+				GitToken:     gitAuth.Token,
+				GitOwner:     gitAuth.Owner,
+				Repositories: newRepositoryNames,
+				Teams:        newTeamNames,
+			}
+
+			if err := gitShim.InitializeGitProvider(&initGitParameters); err != nil {
+				return fmt.Errorf("failed to initialize Git provider: %w", err)
+			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 	viper.Set(fmt.Sprintf("kubefirst-checks.%s-credentials", cliFlags.GitProvider), true)
 	if err = viper.WriteConfig(); err != nil {
@@ -146,25 +212,28 @@ func (p *Provisioner) ProvisionManagementCluster(ctx context.Context, cliFlags *
 	}
 
 	p.stepper.NewProgressStep("Create Management Cluster")
-
-	if err := CreateMgmtClusterRequest(gitAuth, *cliFlags, catalogApps); err != nil {
-		return fmt.Errorf("failed to request management cluster creation: %w", err)
+	if err := stopped(ctx, "Create Management Cluster"); err != nil {
+		return err
 	}
 
-	for !p.watcher.IsComplete() {
-		p.stepper.NewProgressStep(p.watcher.GetCurrentStep())
-		if err := p.watcher.UpdateProvisionProgress(); err != nil {
-			return fmt.Errorf("failed to provision management cluster: %w", err)
+	// kubefirst-api resumes the cluster it already holds, and the watch
+	// picks up from the install steps it completed
+	err = retry.Do(ctx, `phase "Create Management Cluster"`, func(ctx context.Context) error {
+		if err := CreateMgmtClusterRequest(ctx, gitAuth, *cliFlags, catalogApps); err != nil {
+			return fmt.Errorf("failed to request management cluster creation: %w", err)
 		}
 
-		time.Sleep(5 * time.Second)
+		return p.watchProvision(ctx)
+	})
+	if err != nil {
+		return err
 	}
 
 	p.stepper.CompleteCurrentStep()
 
 	p.stepper.InfoStep(step.EmojiTada, "Your kubefirst platform has been provisioned!")
 
-	clusterInfo, err := cluster.GetCluster(cliFlags.ClusterName)
+	clusterInfo, err := cluster.GetCluster(ctx, cliFlags.ClusterName)
 	if err != nil {
 		return fmt.Errorf("failed to get management cluster: %w", err)
 	}
@@ -172,3 +241,43 @@ func (p *Provisioner) ProvisionManagementCluster(ctx context.Context, cliFlags *
 
 	return nil
 }
+
+// stopped returns why ctx is done, once the run has to stop before starting
+// stepName. The operations of a step run to completion, a run interrupted
+// between two steps being canceled with an interrupt as cause
+func stopped(ctx context.Context, stepName string) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+
+	return fmt.Errorf("stopped before %q: %w", stepName, context.Cause(ctx))
+}
+
+// watchProvision follows the install steps until kubefirst-api completed
+// them all, polling every pollInterval, and stops as soon as ctx is done
+func (p *Provisioner) watchProvision(ctx context.Context) error {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+
+	for !p.watcher.IsComplete() {
+		if ctx.Err() != nil {
+			return fmt.Errorf("stopped waiting for %q: %w", p.watcher.GetCurrentStep(), context.Cause(ctx))
+		}
+
+		p.stepper.NewProgressStep(p.watcher.GetCurrentStep())
+		if err := p.watcher.UpdateProvisionProgress(ctx); err != nil {
+			return fmt.Errorf("failed to provision management cluster: %w", err)
+		}
+
+		if p.watcher.IsComplete() {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
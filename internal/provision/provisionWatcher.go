@@ -1,8 +1,10 @@
 package provision
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
 	"github.com/konstructio/kubefirst/internal/cluster"
@@ -27,16 +29,27 @@ const (
 	ProvisionComplete          = "Provision Complete"
 )
 
+// ErrExistingState is returned when a cluster name already has provision
+// state recorded by kubefirst-api
+var ErrExistingState = errors.New("cluster already has provision state")
+
 type ClusterClient interface {
-	GetCluster(clusterName string) (*apiTypes.Cluster, error)
-	CreateCluster(cluster apiTypes.ClusterDefinition) error
-	ResetClusterProgress(clusterName string) error
+	GetCluster(ctx context.Context, clusterName string) (*apiTypes.Cluster, error)
+	CreateCluster(ctx context.Context, cluster apiTypes.ClusterDefinition) error
+	ResetClusterProgress(ctx context.Context, clusterName string) error
+}
+
+// HealthChecker inspects the cluster workloads while the watcher waits on
+// kubefirst-api, returning an error when provisioning is not going to converge
+type HealthChecker interface {
+	Check(ctx context.Context) error
 }
 
 type Watcher struct {
-	clusterName  string
-	installSteps []installStep
-	client       ClusterClient
+	clusterName   string
+	installSteps  []installStep
+	client        ClusterClient
+	healthChecker HealthChecker
 }
 
 type installStep struct {
@@ -75,6 +88,10 @@ func (c *Watcher) SetClusterName(clusterName string) {
 	c.clusterName = clusterName
 }
 
+func (c *Watcher) SetHealthChecker(checker HealthChecker) {
+	c.healthChecker = checker
+}
+
 func (c *Watcher) IsComplete() bool {
 	return len(c.installSteps) == 0
 }
@@ -83,6 +100,93 @@ func (c *Watcher) GetCurrentStep() string {
 	return c.installSteps[0].StepName
 }
 
+// StepSlug returns the command line form of an install step name, e.g.
+// argocd-install for ArgoCD Install
+func StepSlug(stepName string) string {
+	return strings.ToLower(strings.ReplaceAll(stepName, " ", "-"))
+}
+
+// InstallStepSlugs returns the StepSlug of every install step in order,
+// the values --resume-from accepts next to its ResumeInterrupted
+func InstallStepSlugs() []string {
+	steps := NewProvisionWatcher("", nil).installSteps
+	slugs := make([]string, 0, len(steps)+1)
+	for _, step := range steps {
+		slugs = append(slugs, StepSlug(step.StepName))
+	}
+
+	return append(slugs, StepSlug(ProvisionComplete))
+}
+
+// CheckExistingState returns an error wrapping ErrExistingState when
+// kubefirst-api already holds state for the cluster, naming the first step
+// that did not complete and how to proceed from there
+func (c *Watcher) CheckExistingState(ctx context.Context) error {
+	provisionedCluster, err := c.client.GetCluster(ctx, c.clusterName)
+	if errors.Is(err, cluster.ErrClusterNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving cluster %q: %w", c.clusterName, err)
+	}
+
+	pending := c.pendingStep(provisionedCluster)
+	status := provisionedCluster.Status
+	if status == "" {
+		status = "provisioning"
+	}
+
+	return fmt.Errorf(
+		"%w: cluster %q is %s and stopped before %q; rerun with --resume-from %s to continue it, --force to discard its state and provision again, or run destroy to remove it",
+		ErrExistingState, c.clusterName, status, pending, StepSlug(pending),
+	)
+}
+
+// PendingStep returns the first install step kubefirst-api holds no
+// completion for, ProvisionComplete when they all completed. It reports
+// false when kubefirst-api holds no state for the cluster
+func (c *Watcher) PendingStep(ctx context.Context) (string, bool, error) {
+	provisionedCluster, err := c.client.GetCluster(ctx, c.clusterName)
+	if errors.Is(err, cluster.ErrClusterNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("error retrieving cluster %q: %w", c.clusterName, err)
+	}
+
+	return c.pendingStep(provisionedCluster), true, nil
+}
+
+func (c *Watcher) pendingStep(provisionedCluster *apiTypes.Cluster) string {
+	clusterStepStatus := c.mapClusterStepStatus(provisionedCluster)
+	for _, step := range c.installSteps {
+		if !clusterStepStatus[step.StepName] {
+			return step.StepName
+		}
+	}
+
+	return ProvisionComplete
+}
+
+// ResumeFrom skips the install steps before the one whose StepSlug is slug
+func (c *Watcher) ResumeFrom(slug string) error {
+	if slug == StepSlug(ProvisionComplete) {
+		c.installSteps = nil
+		return nil
+	}
+
+	slugs := make([]string, 0, len(c.installSteps))
+	for i, step := range c.installSteps {
+		if StepSlug(step.StepName) == slug {
+			c.installSteps = c.installSteps[i:]
+			return nil
+		}
+		slugs = append(slugs, StepSlug(step.StepName))
+	}
+
+	return fmt.Errorf("unknown install step %q, expected one of: %s", slug, strings.Join(slugs, ", "))
+}
+
 func (c *Watcher) popStep() string {
 	if len(c.installSteps) == 0 {
 		return ProvisionComplete
@@ -93,10 +197,10 @@ func (c *Watcher) popStep() string {
 	return step.StepName
 }
 
-func (c *Watcher) UpdateProvisionProgress() error {
-	provisionedCluster, err := c.client.GetCluster(c.clusterName)
+func (c *Watcher) UpdateProvisionProgress(ctx context.Context) error {
+	provisionedCluster, err := c.client.GetCluster(ctx, c.clusterName)
 	if err != nil {
-		if errors.Is(err, cluster.ErrNotFound) {
+		if errors.Is(err, cluster.ErrClusterNotFound) {
 			return nil
 		}
 
@@ -107,6 +211,12 @@ func (c *Watcher) UpdateProvisionProgress() error {
 		return fmt.Errorf("cluster in error state: %s", provisionedCluster.LastCondition)
 	}
 
+	if c.healthChecker != nil {
+		if err := c.healthChecker.Check(ctx); err != nil {
+			return fmt.Errorf("cluster workloads are unhealthy during %q: %w", c.GetCurrentStep(), err)
+		}
+	}
+
 	clusterStepStatus := c.mapClusterStepStatus(provisionedCluster)
 
 	if clusterStepStatus[c.GetCurrentStep()] {
@@ -1,6 +1,8 @@
 package provision
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
@@ -13,22 +15,30 @@ type MockClusterClient struct {
 	clusters map[string]apiTypes.Cluster
 }
 
-func (m *MockClusterClient) GetCluster(clusterName string) (*apiTypes.Cluster, error) {
+func (m *MockClusterClient) GetCluster(_ context.Context, clusterName string) (*apiTypes.Cluster, error) {
 	foundCluster, exists := m.clusters[clusterName]
 	if !exists {
-		return nil, cluster.ErrNotFound
+		return nil, cluster.ErrClusterNotFound
 	}
 	return &foundCluster, nil
 }
 
-func (m *MockClusterClient) CreateCluster(cluster apiTypes.ClusterDefinition) error {
+func (m *MockClusterClient) CreateCluster(_ context.Context, cluster apiTypes.ClusterDefinition) error {
 	return nil
 }
 
-func (m *MockClusterClient) ResetClusterProgress(clusterName string) error {
+func (m *MockClusterClient) ResetClusterProgress(_ context.Context, clusterName string) error {
 	return nil
 }
 
+type MockHealthChecker struct {
+	err error
+}
+
+func (m *MockHealthChecker) Check(_ context.Context) error {
+	return m.err
+}
+
 func TestClusterProvision(t *testing.T) {
 	t.Run("should have checks after initialized", func(t *testing.T) {
 		client := &MockClusterClient{}
@@ -113,7 +123,7 @@ func TestClusterProvision(t *testing.T) {
 		}
 		cp := NewProvisionWatcher("test-cluster", client)
 
-		err := cp.UpdateProvisionProgress()
+		err := cp.UpdateProvisionProgress(context.Background())
 		assert.NoError(t, err)
 		assert.Equal(t, DomainLivenessCheck, cp.GetCurrentStep())
 	})
@@ -130,7 +140,7 @@ func TestClusterProvision(t *testing.T) {
 		}
 		cp := NewProvisionWatcher("test-cluster", client)
 
-		err := cp.UpdateProvisionProgress()
+		err := cp.UpdateProvisionProgress(context.Background())
 		assert.Error(t, err)
 	})
 
@@ -140,7 +150,75 @@ func TestClusterProvision(t *testing.T) {
 		}
 		cp := NewProvisionWatcher("test-cluster", client)
 
-		err := cp.UpdateProvisionProgress()
+		err := cp.UpdateProvisionProgress(context.Background())
 		assert.NoError(t, err)
 	})
+
+	t.Run("should return an error if the health checker reports stuck workloads", func(t *testing.T) {
+		client := &MockClusterClient{
+			clusters: map[string]apiTypes.Cluster{
+				"test-cluster": {ClusterName: "test-cluster"},
+			},
+		}
+		cp := NewProvisionWatcher("test-cluster", client)
+		cp.SetHealthChecker(&MockHealthChecker{err: errors.New("vault is degraded")})
+
+		err := cp.UpdateProvisionProgress(context.Background())
+		assert.ErrorContains(t, err, "vault is degraded")
+		assert.Equal(t, InstallToolsCheck, cp.GetCurrentStep())
+	})
+
+	t.Run("should accept a cluster without state", func(t *testing.T) {
+		cp := NewProvisionWatcher("test-cluster", &MockClusterClient{})
+
+		assert.NoError(t, cp.CheckExistingState(context.Background()))
+	})
+
+	t.Run("should refuse a cluster with existing state and name the pending step", func(t *testing.T) {
+		client := &MockClusterClient{
+			clusters: map[string]apiTypes.Cluster{
+				"test-cluster": {
+					ClusterName:         "test-cluster",
+					InstallToolsCheck:   true,
+					DomainLivenessCheck: true,
+				},
+			},
+		}
+		cp := NewProvisionWatcher("test-cluster", client)
+
+		err := cp.CheckExistingState(context.Background())
+		require.ErrorIs(t, err, ErrExistingState)
+		assert.ErrorContains(t, err, "--resume-from kbot-setup")
+		assert.ErrorContains(t, err, "--force")
+		assert.ErrorContains(t, err, "destroy")
+	})
+
+	t.Run("should return the pending step of a cluster with state", func(t *testing.T) {
+		client := &MockClusterClient{
+			clusters: map[string]apiTypes.Cluster{
+				"test-cluster": {ClusterName: "test-cluster", InstallToolsCheck: true},
+			},
+		}
+
+		pending, found, err := NewProvisionWatcher("test-cluster", client).PendingStep(context.Background())
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, DomainLivenessCheck, pending)
+
+		_, found, err = NewProvisionWatcher("other-cluster", client).PendingStep(context.Background())
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("should resume from the named step", func(t *testing.T) {
+		cp := NewProvisionWatcher("test-cluster", &MockClusterClient{})
+
+		require.NoError(t, cp.ResumeFrom("argocd-install"))
+		assert.Equal(t, ArgoCDInstallCheck, cp.GetCurrentStep())
+
+		assert.ErrorContains(t, cp.ResumeFrom("unknown"), "expected one of: argocd-install")
+
+		require.NoError(t, cp.ResumeFrom(StepSlug(ProvisionComplete)))
+		assert.True(t, cp.IsComplete())
+	})
 }
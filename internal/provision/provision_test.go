@@ -0,0 +1,89 @@
+package provision
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelingClusterClient completes one install step per poll, like
+// kubefirst-api does for a healthy cluster, until poll cancelAt, where it
+// cancels the run while the step is still in progress
+type cancelingClusterClient struct {
+	MockClusterClient
+	polls      int
+	cancelAt   int
+	cancel     context.CancelFunc
+	canceledAt time.Time
+}
+
+func (c *cancelingClusterClient) GetCluster(_ context.Context, clusterName string) (*apiTypes.Cluster, error) {
+	c.polls++
+	if c.polls == c.cancelAt {
+		c.canceledAt = time.Now()
+		c.cancel()
+		return &apiTypes.Cluster{ClusterName: clusterName}, nil
+	}
+
+	return &apiTypes.Cluster{
+		ClusterName:                clusterName,
+		InstallToolsCheck:          true,
+		DomainLivenessCheck:        true,
+		KbotSetupCheck:             true,
+		GitInitCheck:               true,
+		GitopsReadyCheck:           true,
+		GitTerraformApplyCheck:     true,
+		GitopsPushedCheck:          true,
+		CloudTerraformApplyCheck:   true,
+		ClusterSecretsCreatedCheck: true,
+		ArgoCDInstallCheck:         true,
+		ArgoCDInitializeCheck:      true,
+		VaultInitializedCheck:      true,
+		VaultTerraformApplyCheck:   true,
+		UsersTerraformApplyCheck:   true,
+		FinalCheck:                 true,
+	}, nil
+}
+
+func TestWatchProvisionCancellation(t *testing.T) {
+	const pollInterval = 10 * time.Millisecond
+
+	for i, phase := range NewProvisionWatcher("test-cluster", nil).installSteps {
+		t.Run(phase.StepName, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client := &cancelingClusterClient{cancelAt: i + 1, cancel: cancel}
+			p := NewProvisioner(NewProvisionWatcher("test-cluster", client), step.NewStepFactory(io.Discard))
+			p.pollInterval = pollInterval
+
+			err := p.watchProvision(ctx)
+			returnedAt := time.Now()
+
+			require.ErrorIs(t, err, context.Canceled)
+			assert.ErrorContains(t, err, phase.StepName)
+			assert.Equal(t, i+1, client.polls, "polled again after the context was canceled")
+			assert.Less(t, returnedAt.Sub(client.canceledAt), pollInterval)
+		})
+	}
+}
+
+func TestProvisionStopsWithCause(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(internalharvester.ErrInterrupted)
+
+	require.NoError(t, stopped(context.Background(), "Create Management Cluster"))
+	require.ErrorIs(t, stopped(ctx, "Create Management Cluster"), internalharvester.ErrInterrupted)
+
+	p := NewProvisioner(NewProvisionWatcher("test-cluster", &MockClusterClient{}), step.NewStepFactory(io.Discard))
+	require.ErrorIs(t, p.watchProvision(ctx), internalharvester.ErrInterrupted)
+}
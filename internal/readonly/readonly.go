@@ -0,0 +1,97 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package readonly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+// EnvVar enables read-only mode without passing --read-only
+const EnvVar = "KUBEFIRST_READ_ONLY"
+
+// ErrReadOnly is returned by every client asked to perform a mutation while
+// read-only mode is enabled
+var ErrReadOnly = errors.New("blocked by read-only mode")
+
+var enabled atomic.Bool
+
+// SetEnabled turns read-only mode on or off for the whole process
+func SetEnabled(on bool) {
+	enabled.Store(on)
+}
+
+// Enabled reports whether read-only mode was requested through --read-only
+// or KUBEFIRST_READ_ONLY
+func Enabled() bool {
+	if enabled.Load() {
+		return true
+	}
+
+	on, err := strconv.ParseBool(os.Getenv(EnvVar))
+	return err == nil && on
+}
+
+// Check fails with ErrReadOnly naming mutation when read-only mode is
+// enabled. Clients call it before any external or cluster side effect
+func Check(mutation string) error {
+	if !Enabled() {
+		return nil
+	}
+
+	return fmt.Errorf("%w: refusing to %s", ErrReadOnly, mutation)
+}
+
+// safeMethods never change server side state
+var safeMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+type withoutSideEffectsKey struct{}
+
+// WithoutSideEffects marks the requests sent with ctx as changing nothing
+// despite their method, such as the POST opening a UniFi session
+func WithoutSideEffects(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutSideEffectsKey{}, true)
+}
+
+// Safe reports whether req leaves server side state alone: a GET, HEAD or
+// OPTIONS, a Kubernetes server-side dry run, or a request marked with
+// WithoutSideEffects
+func Safe(req *http.Request) bool {
+	if safeMethods[req.Method] || req.URL.Query().Has("dryRun") {
+		return true
+	}
+
+	marked, _ := req.Context().Value(withoutSideEffectsKey{}).(bool)
+	return marked
+}
+
+// RoundTripper refuses every request that is not Safe while read-only mode
+// is enabled, so HTTP based clients such as the Kubernetes and Vault
+// clients cannot mutate anything
+type RoundTripper struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !Safe(req) {
+		if err := Check(fmt.Sprintf("%s %s", req.Method, req.URL.Redacted())); err != nil {
+			return nil, err
+		}
+	}
+
+	return r.Next.RoundTrip(req)
+}
@@ -0,0 +1,70 @@
+package readonly
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	t.Run("allows mutations by default", func(t *testing.T) {
+		t.Setenv(EnvVar, "")
+		require.NoError(t, Check("push to the gitops repository"))
+	})
+
+	t.Run("blocks mutations when enabled", func(t *testing.T) {
+		SetEnabled(true)
+		t.Cleanup(func() { SetEnabled(false) })
+
+		err := Check("push to the gitops repository")
+		require.ErrorIs(t, err, ErrReadOnly)
+		assert.ErrorContains(t, err, "push to the gitops repository")
+	})
+
+	t.Run("blocks mutations when enabled through the environment", func(t *testing.T) {
+		t.Setenv(EnvVar, "1")
+		require.ErrorIs(t, Check("push to the gitops repository"), ErrReadOnly)
+	})
+}
+
+func TestRoundTripper(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &RoundTripper{Next: http.DefaultTransport}}
+
+	SetEnabled(true)
+	t.Cleanup(func() { SetEnabled(false) })
+
+	t.Run("lets reads through", func(t *testing.T) {
+		res, err := client.Get(server.URL + "/api/v1/namespaces")
+		require.NoError(t, err)
+		res.Body.Close()
+	})
+
+	t.Run("blocks writes", func(t *testing.T) {
+		_, err := client.Post(server.URL+"/api/v1/namespaces", "application/json", nil)
+		require.ErrorIs(t, err, ErrReadOnly)
+		assert.ErrorContains(t, err, "POST "+server.URL+"/api/v1/namespaces")
+	})
+
+	t.Run("lets server-side dry runs through", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/v1/namespaces/default?dryRun=All", nil)
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+	})
+
+	t.Run("lets requests without side effects through", func(t *testing.T) {
+		req, err := http.NewRequestWithContext(WithoutSideEffects(context.Background()), http.MethodPost, server.URL+"/api/auth/login", nil)
+		require.NoError(t, err)
+		res, err := client.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+	})
+}
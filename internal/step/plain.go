@@ -0,0 +1,92 @@
+package step
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// NoColorEnvVar disables colors and the step spinner when set to any
+// value, see https://no-color.org
+const NoColorEnvVar = "NO_COLOR"
+
+var noColor atomic.Bool
+
+// SetNoColor turns plain output on or off for the whole process, as
+// --no-color does
+func SetNoColor(on bool) {
+	noColor.Store(on)
+}
+
+// Plain reports whether output to w has to go without ANSI styling or
+// spinner: --no-color or NO_COLOR is set, or w is not a terminal
+func Plain(w io.Writer) bool {
+	if noColor.Load() || os.Getenv(NoColorEnvVar) != "" {
+		return true
+	}
+
+	f, ok := w.(*os.File)
+	return !ok || !term.IsTerminal(int(f.Fd()))
+}
+
+// runningStep is a step being rendered, by the spinner of cli-utils or as
+// plain lines
+type runningStep interface {
+	GetName() string
+	Complete(err error) error
+}
+
+// plainStep renders a step as one line when it starts and one when it
+// completes or fails, for logs that do not interpret carriage returns. A
+// single line step only renders the second, with the duration of the step
+type plainStep struct {
+	name       string
+	writer     io.Writer
+	progress   *Progress
+	singleLine bool
+	started    time.Time
+	done       bool
+}
+
+func newPlainStep(writer io.Writer, progress *Progress, name string, singleLine bool) *plainStep {
+	s := &plainStep{name: name, writer: writer, progress: progress, singleLine: singleLine, started: time.Now()}
+	if !singleLine {
+		s.println("⏳ " + name)
+	}
+
+	return s
+}
+
+func (s *plainStep) GetName() string {
+	return s.name
+}
+
+func (s *plainStep) Complete(err error) error {
+	if s.done {
+		return nil
+	}
+	s.done = true
+
+	name := s.name
+	if s.singleLine {
+		name = fmt.Sprintf("%s (%s)", s.name, time.Since(s.started).Round(time.Second))
+	}
+	if err != nil {
+		s.println(fmt.Sprintf("%s %s - error: %s", EmojiError, name, err.Error()))
+		return err
+	}
+	s.println(EmojiCheck + " " + name)
+
+	return nil
+}
+
+func (s *plainStep) println(line string) {
+	if s.progress != nil {
+		line = fmt.Sprintf("[%s] %s", s.progress.label(false), line)
+	}
+	fmt.Fprintln(s.writer, line)
+}
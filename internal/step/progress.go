@@ -0,0 +1,217 @@
+package step
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// Progress turns the steps of a run into an overall percent-complete and an
+// estimate of the time left. Every step weighs its expected duration, steps
+// without a weight count for nothing, and a running step moves the percent
+// by the fraction of its sub-operations it reported done
+type Progress struct {
+	mu          sync.Mutex
+	now         func() time.Time
+	weights     map[string]time.Duration
+	total       time.Duration
+	done        time.Duration
+	current     string
+	fraction    float64
+	percent     int
+	startedAt   time.Time
+	stepStarted time.Time
+}
+
+// NewProgress returns a Progress expecting the steps of weights to run,
+// the run starting now
+func NewProgress(weights map[string]time.Duration) *Progress {
+	p := &Progress{weights: weights, now: time.Now}
+	p.startedAt = p.now()
+	for _, weight := range weights {
+		p.total += weight
+	}
+
+	return p
+}
+
+// Percent returns how far along the run is. It never goes back, and only
+// reaches 100 once every weighted step completed or Finish is called
+func (p *Progress) Percent() int {
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.percent
+}
+
+// Elapsed returns how long the run and its current step have been going
+func (p *Progress) Elapsed() (run, step time.Duration) {
+	if p == nil {
+		return 0, 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.current != "" {
+		step = now.Sub(p.stepStarted)
+	}
+
+	return now.Sub(p.startedAt), step
+}
+
+// Remaining estimates how long the run still takes: the weights of the
+// steps yet to complete, less what the running step already did by its
+// reported sub-operations or its elapsed time. It is 0 without weights
+func (p *Progress) Remaining() time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.remainingLocked()
+}
+
+func (p *Progress) remainingLocked() time.Duration {
+	if p.total == 0 || p.percent == 100 {
+		return 0
+	}
+
+	remaining := p.total - p.done
+	if weight := p.weights[p.current]; weight > 0 {
+		did := max(time.Duration(p.fraction*float64(weight)), p.now().Sub(p.stepStarted))
+		remaining -= min(did, weight)
+	}
+
+	return max(remaining, 0)
+}
+
+// label renders the percent prefixing the lines of a step, with the
+// elapsed times of the step and the run when live, and the time left
+func (p *Progress) label(live bool) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	label := fmt.Sprintf("%3d%%", p.percent)
+	if live && p.current != "" {
+		now := p.now()
+		label += fmt.Sprintf(" | step %s | total %s", now.Sub(p.stepStarted).Round(time.Second), now.Sub(p.startedAt).Round(time.Second))
+	}
+	if remaining := p.remainingLocked(); remaining > 0 {
+		label += fmt.Sprintf(" | ~%s left", formatMinutes(remaining))
+	}
+
+	return label
+}
+
+// formatMinutes renders d rounded up to the minute, e.g. 1h05m or 13m
+func formatMinutes(d time.Duration) string {
+	minutes := int(math.Ceil(d.Minutes()))
+	if minutes >= 60 {
+		return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+	}
+
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// Finish marks the run complete
+func (p *Progress) Finish() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.percent = 100
+}
+
+func (p *Progress) start(stepName string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.current = stepName
+	p.fraction = 0
+	p.stepStarted = p.now()
+}
+
+// complete counts stepName done, failed steps are never counted
+func (p *Progress) complete(stepName string) {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current != stepName {
+		return
+	}
+	p.done += p.weights[stepName]
+	p.current = ""
+	p.fraction = 0
+	p.updateLocked()
+}
+
+func (p *Progress) report(stepName string, done, total int) {
+	if p == nil || total <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current != stepName {
+		return
+	}
+	p.fraction = min(max(float64(done)/float64(total), 0), 1)
+	p.updateLocked()
+}
+
+func (p *Progress) updateLocked() {
+	if p.total == 0 {
+		return
+	}
+
+	percent := 100
+	if p.done < p.total {
+		elapsed := float64(p.done) + p.fraction*float64(p.weights[p.current])
+		percent = min(int(elapsed*100/float64(p.total)), 99)
+	}
+	p.percent = max(p.percent, percent)
+}
+
+// progressWriter prefixes the lines of the step spinner with the percent,
+// the elapsed times and the time left, each frame being written at once
+// behind a carriage return, so they tick along with the spinner
+type progressWriter struct {
+	writer   io.Writer
+	progress *Progress
+}
+
+func (w *progressWriter) Write(b []byte) (int, error) {
+	frame, ok := bytes.CutPrefix(b, []byte("\r"))
+	if !ok {
+		return w.writer.Write(b)
+	}
+
+	if _, err := fmt.Fprintf(w.writer, "\r[%s] %s", w.progress.label(true), frame); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
@@ -3,6 +3,8 @@ package step
 import (
 	"fmt"
 	"io"
+	"os"
+	"time"
 
 	"github.com/konstructio/cli-utils/stepper"
 )
@@ -31,30 +33,203 @@ type Stepper interface {
 	DisplayLogHints(cloudProvider string, estimatedTime int)
 }
 
+// StepStatus is the state of a step reported in a StepEvent
+type StepStatus string
+
+const (
+	// StatusPending reports a step registered by NewProgressStep, waiting
+	// on the gates of WithGate before it starts
+	StatusPending  StepStatus = "pending"
+	StatusRunning  StepStatus = "running"
+	StatusComplete StepStatus = "complete"
+	StatusFailed   StepStatus = "failed"
+	// StatusProgress reports the running step completing a fraction of
+	// its sub-operations
+	StatusProgress StepStatus = "progress"
+)
+
+// StepEvent describes a step changing status. Message holds the error of a
+// failed step, or the done/total sub-operations of a progress event.
+// Percent is the overall percent-complete, 0 without WithProgress
+type StepEvent struct {
+	Phase     string
+	Status    StepStatus
+	Message   string
+	Percent   int
+	Timestamp time.Time
+}
+
+// ProgressReporter is implemented by steppers reporting the fraction of the
+// sub-operations of the current step that are done
+type ProgressReporter interface {
+	ReportProgress(done, total int)
+}
+
+// ReportProgress reports done of total sub-operations of the current step
+// of s, when s supports it
+func ReportProgress(s Stepper, done, total int) {
+	if reporter, ok := s.(ProgressReporter); ok {
+		reporter.ReportProgress(done, total)
+	}
+}
+
 type Factory struct {
 	writer      io.Writer
-	currentStep *stepper.Step
+	output      io.Writer
+	plain       bool
+	terminal    *terminal
+	currentStep runningStep
+	events      []chan<- StepEvent
+	progress    *Progress
+	gates       []func(stepName string)
+	singleLine  bool
+	finished    bool
 }
 
-func NewStepFactory(writer io.Writer) *Factory {
-	return &Factory{writer: writer}
+// Option configures a Factory
+type Option func(*Factory)
+
+// WithEventChannel makes the Factory send a StepEvent to ch whenever a step
+// is registered, starts, completes or fails, in addition to rendering it. Sends block, so
+// ch has to be buffered or drained while steps run. Every channel passed
+// receives every event
+func WithEventChannel(ch chan<- StepEvent) Option {
+	return func(s *Factory) {
+		s.events = append(s.events, ch)
+	}
+}
+
+// WithProgress makes the Factory follow p, prefixing the steps it renders
+// and the events it sends with the percent-complete of the run
+func WithProgress(p *Progress) Option {
+	return func(s *Factory) {
+		s.progress = p
+	}
+}
+
+// WithGate makes the Factory call gate with the name of each step before
+// starting it, after the previous step completed. A gate blocking holds the
+// run between two steps, e.g. while a user paused it. Every gate passed is
+// called, in order
+func WithGate(gate func(stepName string)) Option {
+	return func(s *Factory) {
+		s.gates = append(s.gates, gate)
+	}
+}
+
+// WithSingleLineSteps makes the Factory render each step as a single line
+// once it completes or fails, with its duration, instead of a spinner, as
+// CI logs want them
+func WithSingleLineSteps() Option {
+	return func(s *Factory) {
+		s.singleLine = true
+	}
+}
+
+func NewStepFactory(writer io.Writer, opts ...Option) *Factory {
+	s := &Factory{writer: writer, output: writer, plain: Plain(writer)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.plain = s.plain || s.singleLine
+	if f, ok := writer.(*os.File); ok && !s.plain {
+		s.terminal = terminalFor(f)
+		s.writer, s.output = s.terminal, s.terminal
+	}
+	if s.progress != nil {
+		s.writer = &progressWriter{writer: s.writer, progress: s.progress}
+	}
+
+	return s
 }
 
 func (s *Factory) NewProgressStep(stepName string) {
 	if s.currentStep == nil {
-		s.currentStep = stepper.New(s.writer, stepName)
+		s.waitGate(stepName)
+		s.currentStep = s.newStep(stepName)
+		s.start(stepName)
 	} else if s.currentStep != nil && s.currentStep.GetName() != stepName {
+		s.progress.complete(s.currentStep.GetName())
 		s.currentStep.Complete(nil)
-		s.currentStep = stepper.New(s.writer, stepName)
+		s.finish(StatusComplete, "")
+		s.waitGate(stepName)
+		s.currentStep = s.newStep(stepName)
+		s.start(stepName)
 	}
 }
 
 func (s *Factory) FailCurrentStep(err error) {
 	s.currentStep.Complete(err)
+
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	s.finish(StatusFailed, message)
 }
 
 func (s *Factory) CompleteCurrentStep() {
+	s.progress.complete(s.currentStep.GetName())
 	s.currentStep.Complete(nil)
+	s.finish(StatusComplete, "")
+}
+
+// ReportProgress reports done of total sub-operations of the current step,
+// e.g. ArgoCD applications healthy, moving the percent-complete within the
+// weight of the step
+func (s *Factory) ReportProgress(done, total int) {
+	if s.currentStep == nil || s.finished {
+		return
+	}
+
+	s.progress.report(s.currentStep.GetName(), done, total)
+	s.emit(s.currentStep.GetName(), StatusProgress, fmt.Sprintf("%d/%d", done, total))
+}
+
+// newStep renders stepName with the spinner, or as plain lines when the
+// output has no colors or the terminal stopped taking the spinner
+func (s *Factory) newStep(stepName string) runningStep {
+	if s.plain || s.terminal.Plain() {
+		return newPlainStep(s.output, s.progress, stepName, s.singleLine)
+	}
+
+	return stepper.New(s.writer, stepName)
+}
+
+func (s *Factory) waitGate(stepName string) {
+	s.emit(stepName, StatusPending, "")
+	for _, gate := range s.gates {
+		gate(stepName)
+	}
+}
+
+func (s *Factory) start(stepName string) {
+	s.finished = false
+	s.progress.start(stepName)
+	s.emit(stepName, StatusRunning, "")
+}
+
+// finish reports the outcome of the current step once, as completing a step
+// that already completed or failed renders nothing either
+func (s *Factory) finish(status StepStatus, message string) {
+	if s.finished {
+		return
+	}
+	s.finished = true
+	s.emit(s.currentStep.GetName(), status, message)
+}
+
+func (s *Factory) emit(phase string, status StepStatus, message string) {
+	event := StepEvent{
+		Phase:     phase,
+		Status:    status,
+		Message:   message,
+		Percent:   s.progress.Percent(),
+		Timestamp: time.Now(),
+	}
+	for _, ch := range s.events {
+		ch <- event
+	}
 }
 
 func (s *Factory) GetCurrentStep() string {
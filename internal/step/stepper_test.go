@@ -84,6 +84,83 @@ func TestStepFactory_CompleteCurrentStep(t *testing.T) {
 	})
 }
 
+func TestStepFactory_WithEventChannel(t *testing.T) {
+	t.Run("should emit an event per status change", func(t *testing.T) {
+		events := make(chan StepEvent, 10)
+		buf := &bytes.Buffer{}
+		sf := NewStepFactory(buf, WithEventChannel(events))
+
+		sf.NewProgressStep("first step")
+		sf.NewProgressStep("second step")
+		sf.CompleteCurrentStep()
+		sf.NewProgressStep("third step")
+		sf.FailCurrentStep(fmt.Errorf("test error"))
+		close(events)
+
+		var got []StepEvent
+		for event := range events {
+			assert.False(t, event.Timestamp.IsZero())
+			event.Timestamp = time.Time{}
+			got = append(got, event)
+		}
+
+		assert.Equal(t, []StepEvent{
+			{Phase: "first step", Status: StatusPending},
+			{Phase: "first step", Status: StatusRunning},
+			{Phase: "first step", Status: StatusComplete},
+			{Phase: "second step", Status: StatusPending},
+			{Phase: "second step", Status: StatusRunning},
+			{Phase: "second step", Status: StatusComplete},
+			{Phase: "third step", Status: StatusPending},
+			{Phase: "third step", Status: StatusRunning},
+			{Phase: "third step", Status: StatusFailed, Message: "test error"},
+		}, got)
+		assert.Contains(t, buf.String(), "test error")
+	})
+
+	t.Run("should send every event to every channel", func(t *testing.T) {
+		first := make(chan StepEvent, 10)
+		second := make(chan StepEvent, 10)
+		sf := NewStepFactory(&bytes.Buffer{}, WithEventChannel(first), WithEventChannel(second))
+
+		sf.NewProgressStep("first step")
+		sf.ReportProgress(1, 2)
+		sf.CompleteCurrentStep()
+		close(first)
+		close(second)
+
+		statuses := func(events chan StepEvent) []StepStatus {
+			var got []StepStatus
+			for event := range events {
+				got = append(got, event.Status)
+			}
+			return got
+		}
+		expected := []StepStatus{StatusPending, StatusRunning, StatusProgress, StatusComplete}
+		assert.Equal(t, expected, statuses(first))
+		assert.Equal(t, expected, statuses(second))
+	})
+}
+
+func TestStepFactory_WithGate(t *testing.T) {
+	events := make(chan StepEvent, 10)
+	var order []string
+	gate := func(stepName string) {
+		for len(events) > 0 {
+			event := <-events
+			order = append(order, event.Phase+" "+string(event.Status))
+		}
+		order = append(order, "gate "+stepName)
+	}
+	sf := NewStepFactory(&bytes.Buffer{}, WithEventChannel(events), WithGate(gate))
+
+	sf.NewProgressStep("first step")
+	sf.NewProgressStep("first step")
+	sf.NewProgressStep("second step")
+
+	assert.Equal(t, []string{"first step pending", "gate first step", "first step running", "first step complete", "second step pending", "gate second step"}, order)
+}
+
 func TestStepFactory_DisplayLogHints(t *testing.T) {
 	type fields struct {
 		writer io.Writer
@@ -119,3 +196,90 @@ func TestStepFactory_DisplayLogHints(t *testing.T) {
 		})
 	}
 }
+
+func TestStepFactory_WithProgress(t *testing.T) {
+	events := make(chan StepEvent, 16)
+	buf := &bytes.Buffer{}
+	progress := NewProgress(map[string]time.Duration{"first step": time.Minute, "second step": 3 * time.Minute})
+	sf := NewStepFactory(buf, WithEventChannel(events), WithProgress(progress))
+
+	sf.NewProgressStep("first step")
+	sf.NewProgressStep("unweighted step")
+	sf.NewProgressStep("second step")
+	sf.ReportProgress(7, 12)
+	assert.Equal(t, 68, progress.Percent())
+	sf.ReportProgress(2, 12)
+	assert.Equal(t, 68, progress.Percent(), "percent never goes back")
+	sf.CompleteCurrentStep()
+	close(events)
+
+	var percents []int
+	for event := range events {
+		percents = append(percents, event.Percent)
+		if event.Status == StatusProgress {
+			assert.Contains(t, []string{"7/12", "2/12"}, event.Message)
+		}
+	}
+	assert.Equal(t, []int{0, 0, 25, 25, 25, 25, 25, 25, 68, 68, 100}, percents)
+	assert.Contains(t, buf.String(), "[100%] "+EmojiCheck+" second step")
+}
+
+func TestPlain(t *testing.T) {
+	assert.True(t, Plain(&bytes.Buffer{}), "a buffer is no terminal")
+
+	t.Setenv(NoColorEnvVar, "1")
+	assert.True(t, Plain(os.Stderr))
+
+	t.Setenv(NoColorEnvVar, "")
+	SetNoColor(true)
+	t.Cleanup(func() { SetNoColor(false) })
+	assert.True(t, Plain(os.Stderr))
+}
+
+func TestStepFactory_Plain(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sf := NewStepFactory(buf)
+
+	sf.NewProgressStep("first step")
+	sf.NewProgressStep("second step")
+	sf.FailCurrentStep(fmt.Errorf("test error"))
+
+	assert.Equal(t, "⏳ first step\n"+EmojiCheck+" first step\n⏳ second step\n"+EmojiError+" second step - error: test error\n", buf.String())
+}
+
+func TestProgress_Remaining(t *testing.T) {
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	progress := NewProgress(map[string]time.Duration{"first step": 10 * time.Minute, "second step": 20 * time.Minute})
+	progress.now = func() time.Time { return clock }
+	progress.startedAt = clock
+
+	assert.Equal(t, 30*time.Minute, progress.Remaining())
+
+	progress.start("first step")
+	clock = clock.Add(4 * time.Minute)
+	assert.Equal(t, 26*time.Minute, progress.Remaining())
+	progress.report("first step", 1, 2)
+	assert.Equal(t, 25*time.Minute, progress.Remaining(), "the reported sub-operations outrun the elapsed time")
+
+	clock = clock.Add(20 * time.Minute)
+	assert.Equal(t, 20*time.Minute, progress.Remaining(), "an overrunning step leaves the later ones")
+	run, step := progress.Elapsed()
+	assert.Equal(t, 24*time.Minute, run)
+	assert.Equal(t, 24*time.Minute, step)
+	assert.Equal(t, " 16% | step 24m0s | total 24m0s | ~20m left", progress.label(true))
+
+	progress.complete("first step")
+	assert.Equal(t, " 33% | ~20m left", progress.label(true))
+	progress.Finish()
+	assert.Zero(t, progress.Remaining())
+}
+
+func TestStepFactory_SingleLineSteps(t *testing.T) {
+	buf := &bytes.Buffer{}
+	sf := NewStepFactory(buf, WithSingleLineSteps())
+	sf.NewProgressStep("first step")
+	sf.NewProgressStep("second step")
+	sf.FailCurrentStep(fmt.Errorf("test error"))
+
+	assert.Equal(t, EmojiCheck+" first step (0s)\n"+EmojiError+" second step (0s) - error: test error\n", buf.String())
+}
@@ -0,0 +1,244 @@
+package step
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+// terminalWriteTimeout is how long a write to the terminal may block, e.g.
+// while nothing drains a stopped pager or the process writes from the
+// background, before the steps fall back to plain lines
+const terminalWriteTimeout = 2 * time.Second
+
+var (
+	terminalsMu sync.Mutex
+	terminals   = map[uintptr]*terminal{}
+)
+
+// terminal guards every write of the steps to a terminal. It remembers the
+// spinner line being drawn to fit it in the width of the terminal and paint
+// it again once the terminal is resized or the process resumes, and draws
+// nothing while the process is suspended or in the background
+type terminal struct {
+	mu      sync.Mutex
+	out     io.Writer
+	fd      int
+	width   int
+	timeout time.Duration
+
+	line    string
+	paused  bool
+	plain   bool
+	blocked bool
+	backlog []byte
+
+	// initial is the state of the terminal when the steps started, given
+	// back to the shell while the process is suspended
+	initial   *term.State
+	suspended *term.State
+
+	stop       func()
+	foreground func(fd int) bool
+}
+
+// terminalFor returns the terminal of f shared by every Factory writing to
+// it, following the signals of the process the first time
+func terminalFor(f *os.File) *terminal {
+	terminalsMu.Lock()
+	defer terminalsMu.Unlock()
+
+	if t, ok := terminals[f.Fd()]; ok {
+		return t
+	}
+
+	t := newTerminal(f, int(f.Fd()))
+	terminals[f.Fd()] = t
+	if len(terminalSignals) > 0 {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, terminalSignals...)
+		go t.watch(signals)
+	}
+
+	return t
+}
+
+func newTerminal(out io.Writer, fd int) *terminal {
+	t := &terminal{
+		out:        out,
+		fd:         fd,
+		timeout:    terminalWriteTimeout,
+		stop:       stopProcess,
+		foreground: isForeground,
+	}
+	t.width, _, _ = term.GetSize(fd)
+	t.initial, _ = term.GetState(fd)
+
+	return t
+}
+
+// Plain reports whether the terminal fell back to plain lines after a
+// write blocked
+func (t *terminal) Plain() bool {
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.plain
+}
+
+// Write draws b, the frame of a spinner when it starts with a carriage
+// return. It never blocks longer than the write timeout and never fails,
+// so a spinner cannot hang on a terminal that stopped reading
+func (t *terminal) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	frame, redraw := bytes.CutPrefix(b, []byte("\r"))
+	switch {
+	case redraw && !bytes.HasSuffix(frame, []byte("\n")):
+		t.line = string(frame)
+		t.drawLocked()
+	case redraw:
+		// a completed step replaces the spinner line
+		t.line = ""
+		t.writeLocked(append([]byte("\r\x1b[K"), frame...))
+	default:
+		t.writeLocked(b)
+	}
+
+	return len(b), nil
+}
+
+func (t *terminal) watch(signals <-chan os.Signal) {
+	for sig := range signals {
+		t.handle(sig)
+	}
+}
+
+func (t *terminal) handle(sig os.Signal) {
+	switch sig {
+	case resizeSignal:
+		t.resize()
+	case suspendSignal:
+		t.suspend()
+	case resumeSignal:
+		t.resume()
+	}
+}
+
+func (t *terminal) resize() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if width, _, err := term.GetSize(t.fd); err == nil {
+		t.width = width
+	}
+	t.drawLocked()
+}
+
+// suspend clears the spinner line and gives the shell back the terminal
+// as it was before stopping the process, as SIGTSTP does when not caught
+func (t *terminal) suspend() {
+	t.mu.Lock()
+	t.paused = true
+	if t.line != "" && !t.plain {
+		t.writeLocked([]byte("\r\x1b[K"))
+	}
+	if state, err := term.GetState(t.fd); err == nil && t.initial != nil {
+		t.suspended = state
+		_ = term.Restore(t.fd, t.initial)
+	}
+	t.mu.Unlock()
+
+	t.stop()
+}
+
+// resume draws the spinner again once the process is back in the
+// foreground, after fg rather than bg
+func (t *terminal) resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.foreground(t.fd) {
+		t.paused = true
+		return
+	}
+	if t.suspended != nil {
+		_ = term.Restore(t.fd, t.suspended)
+		t.suspended = nil
+	}
+	t.paused = false
+	if width, _, err := term.GetSize(t.fd); err == nil {
+		t.width = width
+	}
+	t.drawLocked()
+}
+
+// drawLocked paints the spinner line over the current one, cut to the
+// width of the terminal as a wrapped line cannot be drawn over
+func (t *terminal) drawLocked() {
+	if t.line == "" || t.plain || t.paused {
+		return
+	}
+
+	line := t.line
+	if t.width > 1 {
+		line = runewidth.Truncate(line, t.width-1, "")
+	}
+	t.writeLocked([]byte("\r" + line + "\x1b[K"))
+}
+
+// writeLocked writes b after what is still waiting for the terminal
+func (t *terminal) writeLocked(b []byte) {
+	t.backlog = append(t.backlog, b...)
+	if !t.blocked {
+		t.flushLocked()
+	}
+}
+
+// flushLocked writes the backlog, falling back to plain lines when the
+// terminal does not take it within the timeout. The lines written in the
+// meantime wait for the blocked write to return
+func (t *terminal) flushLocked() {
+	pending := t.backlog
+	t.backlog = nil
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = t.out.Write(pending)
+	}()
+
+	timer := time.NewTimer(t.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		t.blocked = true
+		t.plain = true
+		go t.unblock(done)
+	}
+}
+
+func (t *terminal) unblock(done <-chan struct{}) {
+	<-done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.blocked = false
+	if len(t.backlog) > 0 {
+		t.flushLocked()
+	}
+}
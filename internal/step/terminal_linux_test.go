@@ -0,0 +1,155 @@
+package step
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// openPty returns the controller and the terminal of a new pty of 40 columns
+func openPty(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+
+	controller, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pty: %v", err)
+	}
+	t.Cleanup(func() { controller.Close() })
+
+	require.NoError(t, unix.IoctlSetPointerInt(int(controller.Fd()), unix.TIOCSPTLCK, 0))
+	n, err := unix.IoctlGetInt(int(controller.Fd()), unix.TIOCGPTN)
+	require.NoError(t, err)
+
+	tty, err := os.OpenFile("/dev/pts/"+strconv.Itoa(n), os.O_RDWR|unix.O_NOCTTY, 0)
+	require.NoError(t, err)
+	t.Cleanup(func() { tty.Close() })
+	setColumns(t, tty, 40)
+
+	return controller, tty
+}
+
+func setColumns(t *testing.T, tty *os.File, columns uint16) {
+	t.Helper()
+	require.NoError(t, unix.IoctlSetWinsize(int(tty.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: 24, Col: columns}))
+}
+
+// screen collects what the terminal of a pty is sent
+type screen struct {
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (s *screen) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.out.Write(b)
+}
+
+// take returns what was sent since the last call
+func (s *screen) take() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer s.out.Reset()
+	return s.out.String()
+}
+
+func TestTerminal_ResizeAndSuspend(t *testing.T) {
+	controller, tty := openPty(t)
+	sent := &screen{}
+	go io.Copy(sent, controller)
+
+	terminal := newTerminal(tty, int(tty.Fd()))
+	stopped := 0
+	foreground := true
+	terminal.stop = func() { stopped++ }
+	terminal.foreground = func(int) bool { return foreground }
+
+	line := "🕐 Provision the management cluster on harvester"
+	eventually := func(expected string) {
+		t.Helper()
+		var got strings.Builder
+		if !assert.Eventually(t, func() bool {
+			got.WriteString(sent.take())
+			return strings.Contains(got.String(), expected)
+		}, time.Second, 10*time.Millisecond) {
+			t.Logf("sent %q", got.String())
+		}
+	}
+	quiet := func() {
+		t.Helper()
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, sent.take())
+	}
+
+	terminal.Write([]byte("\r" + line))
+	eventually("\r🕐 Provision the management cluster on \x1b[K")
+
+	setColumns(t, tty, 80)
+	terminal.handle(resizeSignal)
+	eventually("\r" + line + "\x1b[K")
+
+	// the run puts the terminal in raw mode, the shell gets it back cooked
+	raw, err := term.MakeRaw(int(tty.Fd()))
+	require.NoError(t, err)
+	t.Cleanup(func() { term.Restore(int(tty.Fd()), raw) })
+
+	terminal.handle(suspendSignal)
+	assert.Equal(t, 1, stopped)
+	eventually("\r\x1b[K")
+	assert.True(t, echoes(t, tty), "the shell gets the terminal it started with")
+
+	terminal.Write([]byte("\r" + line))
+	quiet()
+
+	foreground = false
+	terminal.handle(resumeSignal)
+	quiet()
+	assert.True(t, echoes(t, tty), "a process resumed in the background leaves the terminal alone")
+
+	foreground = true
+	terminal.handle(resumeSignal)
+	eventually("\r" + line + "\x1b[K")
+	assert.False(t, echoes(t, tty), "the run gets its raw terminal back")
+
+	terminal.Write([]byte("\r✅ Provision the management cluster on harvester\n"))
+	eventually("\r\x1b[K✅ Provision the management cluster on harvester")
+}
+
+func echoes(t *testing.T, tty *os.File) bool {
+	t.Helper()
+
+	termios, err := unix.IoctlGetTermios(int(tty.Fd()), unix.TCGETS)
+	require.NoError(t, err)
+
+	return termios.Lflag&unix.ECHO != 0
+}
+
+func TestTerminal_BlockedWrite(t *testing.T) {
+	reader, writer := io.Pipe()
+	terminal := newTerminal(writer, -1)
+	terminal.timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	terminal.Write([]byte("\r🕐 first step"))
+	assert.Less(t, time.Since(start), time.Second, "a blocked write does not hang the spinner")
+	assert.True(t, terminal.Plain())
+
+	terminal.Write([]byte("\r🕑 first step"))
+	terminal.Write([]byte("\r✅ first step\n"))
+
+	out, err := io.ReadAll(io.LimitReader(reader, int64(len("\r🕐 first step\x1b[K\r\x1b[K✅ first step\n"))))
+	require.NoError(t, err)
+	assert.Equal(t, "\r🕐 first step\x1b[K\r\x1b[K✅ first step\n", string(out), "frames are dropped once plain, lines wait for the terminal")
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package step
+
+import "os"
+
+// the terminal is neither resized nor suspended through signals
+var (
+	resizeSignal  os.Signal
+	suspendSignal os.Signal
+	resumeSignal  os.Signal
+
+	terminalSignals []os.Signal
+)
+
+func stopProcess() {}
+
+func isForeground(int) bool {
+	return true
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package step
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	resizeSignal  os.Signal = unix.SIGWINCH
+	suspendSignal os.Signal = unix.SIGTSTP
+	resumeSignal  os.Signal = unix.SIGCONT
+
+	terminalSignals = []os.Signal{resizeSignal, suspendSignal, resumeSignal}
+)
+
+// stopProcess stops the process once the terminal is handed back, as a
+// caught SIGTSTP no longer does
+func stopProcess() {
+	_ = unix.Kill(os.Getpid(), unix.SIGSTOP)
+}
+
+// isForeground reports whether the process group of the process is the
+// one the terminal of fd reads and writes for
+func isForeground(fd int) bool {
+	foreground, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP)
+	if err != nil {
+		return true
+	}
+	group, err := unix.Getpgid(0)
+	if err != nil {
+		return true
+	}
+
+	return foreground == group
+}
@@ -6,6 +6,8 @@ See the LICENSE file for more details.
 */
 package types
 
+import "time"
+
 type CliFlags struct {
 	AlertsEmail          string
 	Ci                   bool
@@ -37,17 +39,135 @@ type CliFlags struct {
 	InstallKubefirstPro  bool
 	AMIType              string
 	// Harvester specific
-	HarvesterKubeconfigPath string
-	HarvesterLBIPRange      string
-	VClusters               []string
-	InstallIstio            bool
-	IstioVersion            string
-	InstallKgateway         bool
-	GitopsRepo              string
+	HarvesterKubeconfigPath  string
+	KubeconfigFromSecret     string
+	KubeconfigContext        string
+	HarvesterLBIPRanges      []string
+	HarvesterLBIPRangeNames  []string
+	HarvesterLBPoolName      string
+	HA                       bool
+	HANodeCount              int
+	GPUNodes                 []string
+	GPUDriverVersion         string
+	Proxy                    string
+	CACerts                  []string
+	CommitPerChange          bool
+	RegistryMirror           string
+	DNSCheckDoH              string
+	PruneDNS                 bool
+	DNSProviders             []string
+	Route53HostedZoneID      string
+	MgmtKubeconfig           string
+	VClusters                []string
+	VClusterIngressWildcard  bool
+	VClusterNetworkIsolation bool
+	VClusterAppSet           bool
+	VClusterAllows           []string
+	VClusterConnections      []string
+	VClusterApps             []string
+	TrustBundle              bool
+	TrustBundleTargets       []string
+	TrustManager             bool
+	TrustBundleSelector      string
+	TrustBundleProbeURL      string
+	VClusterSpecs            []string
+	VClusterDefaultSpec      string
+	VClusterNodeSelectors    []string
+	InstallIstio             bool
+	VClusterIstio            map[string]bool
+	IstioVersion             string
+	InstallKgateway          bool
+	InstallObservability     bool
+	Logging                  bool
+	LoggingRetention         string
+	GitopsRepo               string
+	GitopsRegistryPath       string
+	GitopsOverlayDir         string
+	FromBundle               string
+	LargeFileWarnSize        string
+	LargeFileMaxSize         string
+	AllowLargeFiles          bool
+	GitLFSPatterns           []string
+	NoBranchProtection       bool
+	ArgoCDWriteAccess        bool
+	GitHubAppID              int64
+	GitHubAppKeyPath         string
+	ExtraDomains             []string
+	VClusterDomainMap        map[string]string
+	StorageClass             string
+	VClusterStorageClasses   map[string]string
+	ClusterLabels            map[string]string
 	// UniFi ingress
-	UniFiHost     string
-	UniFiUser     string
-	UniFiPassword string
+	IngressMode       string
+	UniFiHost         string
+	UniFiUser         string
+	UniFiPassword     string
+	UniFiSite         string
+	UniFiPortMappings []string
+	// Kubefirst pro
+	KubefirstProVersion  string
+	KubefirstProChartURL string
+	// Component versions
+	ComponentsVersionFile string
+	// Low bandwidth
+	LowBandwidth bool
+	// OIDC/SSO
+	OIDCIssuerURL    string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCAdminGroup   string
 	// Staged provisioning
 	StopAfter string
+	// External Secrets Operator next to Vault
+	ExternalSecrets        bool
+	ExternalSecretsBackend string
+	// Velero backups
+	BackupSchedule string
+	BackupStorage  string
+	BackupTTL      string
+	BackupBucket   string
+	BackupPrefix   string
+	// Vault auto-unseal and seeding
+	VaultAutoUnseal   string
+	VaultSeedFile     string
+	VaultTeamPolicies bool
+	VaultAudit        string
+	VaultAuditSize    string
+	// Chat notifications
+	SlackWebhook  string
+	TeamsWebhook  string
+	NotifyWebhook string
+	NotifyOnPhase []string
+	// Notification hook
+	NotifyWebhookURL string
+	NotifyFormat     string
+	NotifyOn         string
+	// Installation report
+	ReportPath  string
+	SummaryFile string
+	MetricsFile string
+	// Existing provision state
+	Force      bool
+	ResumeFrom string
+	// Manifest export
+	ExportManifests      string
+	ExportIncludeSecrets bool
+	DryRun               bool
+	// Cost estimate
+	CostEstimate   bool
+	CostRateCPU    float64
+	CostRateMemory float64
+	// ArgoCD health watching
+	WatchVerbose        bool
+	DegradedGracePeriod time.Duration
+	MaxHealthFlaps      int
+	SkipVerify          bool
+	VerifyTimeout       time.Duration
+	Wait                bool
+	APIRetryMax         int
+	RetryCount          int
+	RetryBackoff        time.Duration
+	// Provenance describes where the value of every flag came from, keyed by
+	// flag name, e.g. "from config file cluster.yaml:14"
+	Provenance map[string]string
 }
@@ -18,3 +18,8 @@ type ProxyCreateClusterRequest struct {
 type ProxyResetClusterRequest struct {
 	URL string `bson:"url" json:"url"`
 }
+
+type ProxyClusterSecretRequest struct {
+	Body interface{} `bson:"body" json:"body"`
+	URL  string      `bson:"url" json:"url"`
+}
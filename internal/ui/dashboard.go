@@ -0,0 +1,511 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package ui
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/konstructio/kubefirst/internal/step"
+	"golang.org/x/term"
+)
+
+const (
+	// MinDashboardWidth and MinDashboardHeight are the smallest terminal
+	// the dashboard renders in
+	MinDashboardWidth  = 80
+	MinDashboardHeight = 20
+
+	stepColumnWidth = 34
+	maxStepLogLines = 500
+)
+
+var (
+	stepStyle    = lipgloss.NewStyle().PaddingLeft(2)
+	runningStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("33"))
+	failedStyle  = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("196"))
+	logStyle     = lipgloss.NewStyle().PaddingLeft(1).BorderStyle(lipgloss.NormalBorder()).BorderLeft(true)
+)
+
+var stepIcons = map[step.StepStatus]string{
+	step.StatusPending:  "·",
+	step.StatusRunning:  "▶",
+	step.StatusComplete: "✓",
+	step.StatusFailed:   "✗",
+}
+
+// Frontend is what a run shown by RunDashboard renders to: step events go to
+// Events, everything it prints to Log, and its stepper calls Gate before each
+// step so the user can pause between two. Attempt counts the runs, from 1
+type Frontend struct {
+	Events  chan<- step.StepEvent
+	Log     io.Writer
+	Gate    func(stepName string)
+	Attempt int
+}
+
+// DashboardRun runs what RunDashboard shows, returning once it ends.
+// Retrying calls it again with the next attempt
+type DashboardRun func(ctx context.Context, frontend Frontend) error
+
+// eventMsg is a step event of the run
+type eventMsg step.StepEvent
+
+// logMsg is a line the run printed
+type logMsg string
+
+// pausedMsg tells the run waits at the gate of the step it names
+type pausedMsg string
+
+// finishedMsg carries the outcome of an attempt of the run
+type finishedMsg struct {
+	err error
+}
+
+type dashboardStep struct {
+	name    string
+	status  step.StepStatus
+	message string
+	logs    []string
+}
+
+// Dashboard is a bubbletea model listing the steps of a run with their
+// status next to the log tail of the selected step, with keys to pause the
+// run before its next step, retry it once it failed or abort it
+type Dashboard struct {
+	title    string
+	controls *dashboardControls
+
+	steps    []dashboardStep
+	preamble []string
+	current  int
+	selected int
+	follow   bool
+	percent  int
+	width    int
+	height   int
+
+	pausing  bool
+	pausedAt string
+	aborting bool
+	finished bool
+	err      error
+}
+
+// NewDashboard returns a Dashboard under title, following the running step
+// until the user selects another
+func NewDashboard(title string) Dashboard {
+	return Dashboard{
+		title:    title,
+		controls: newDashboardControls(),
+		current:  -1,
+		selected: -1,
+		follow:   true,
+	}
+}
+
+func (d Dashboard) Init() tea.Cmd {
+	return nil
+}
+
+func (d Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		d.width, d.height = msg.Width, msg.Height
+		return d, nil
+
+	case eventMsg:
+		return d.event(step.StepEvent(msg)), nil
+
+	case logMsg:
+		if d.current < 0 {
+			d.preamble = appendLog(d.preamble, string(msg))
+			return d, nil
+		}
+		d.steps[d.current].logs = appendLog(d.steps[d.current].logs, string(msg))
+		return d, nil
+
+	case pausedMsg:
+		d.pausedAt = string(msg)
+		return d, nil
+
+	case finishedMsg:
+		d.finished = true
+		d.err = msg.err
+		d.pausedAt = ""
+		if d.aborting {
+			return d, tea.Quit
+		}
+		return d, nil
+
+	case tea.KeyMsg:
+		return d.key(msg)
+	}
+
+	return d, nil
+}
+
+func (d Dashboard) event(event step.StepEvent) Dashboard {
+	d.percent = event.Percent
+
+	index := -1
+	for i, s := range d.steps {
+		if s.name == event.Phase {
+			index = i
+		}
+	}
+	if index < 0 {
+		d.steps = append(d.steps, dashboardStep{name: event.Phase, status: step.StatusPending})
+		index = len(d.steps) - 1
+	}
+
+	switch event.Status {
+	case step.StatusRunning:
+		d.steps[index].status = step.StatusRunning
+		d.steps[index].message = ""
+		d.current = index
+		d.pausedAt = ""
+		if d.follow {
+			d.selected = index
+		}
+	case step.StatusProgress:
+		d.steps[index].message = event.Message
+	default:
+		d.steps[index].status = event.Status
+		d.steps[index].message = event.Message
+	}
+
+	return d
+}
+
+func (d Dashboard) key(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "k":
+		if d.selected > 0 {
+			d.selected--
+			d.follow = false
+		}
+	case "down", "j":
+		if d.selected < len(d.steps)-1 {
+			d.selected++
+			d.follow = false
+		}
+	case "f":
+		d.follow = true
+		d.selected = d.current
+	case "p":
+		if d.finished || d.aborting {
+			return d, nil
+		}
+		d.pausing = !d.pausing
+		if !d.pausing {
+			d.pausedAt = ""
+		}
+		d.controls.pause(d.pausing)
+	case "r":
+		if !d.finished || d.err == nil {
+			return d, nil
+		}
+		d.finished = false
+		d.err = nil
+		d.controls.decide(true)
+	case "q", "ctrl+c":
+		if d.finished {
+			d.controls.decide(false)
+			return d, tea.Quit
+		}
+		if !d.aborting {
+			d.aborting = true
+			d.controls.abort()
+		}
+	}
+
+	return d, nil
+}
+
+func (d Dashboard) View() string {
+	if d.width > 0 && (d.width < MinDashboardWidth || d.height < MinDashboardHeight) {
+		return fmt.Sprintf("\n  the terminal is smaller than %dx%d, enlarge it to see the steps\n", MinDashboardWidth, MinDashboardHeight)
+	}
+
+	var b strings.Builder
+	b.WriteString("\n" + titleStyle.Render(fmt.Sprintf("%s [%d%%]", d.title, d.percent)) + "\n\n")
+
+	rows := max(d.height-7, 1)
+	logWidth := max(d.width-stepColumnWidth-3, 20)
+	b.WriteString(lipgloss.JoinHorizontal(lipgloss.Top,
+		lipgloss.NewStyle().Width(stepColumnWidth).Render(d.stepsView(rows)),
+		logStyle.Render(d.logView(rows, logWidth)),
+	) + "\n\n")
+
+	switch {
+	case d.aborting && !d.finished:
+		b.WriteString(helpStyle.Render("aborting, waiting for the current step to stop...") + "\n")
+	case d.finished && d.err != nil:
+		b.WriteString(errorStyle.Render(lipgloss.NewStyle().MaxWidth(max(d.width-2, 20)).Render(d.err.Error())) + "\n")
+	case d.finished:
+		b.WriteString(helpStyle.Render("done") + "\n")
+	case d.pausedAt != "":
+		b.WriteString(helpStyle.Render("paused before "+d.pausedAt) + "\n")
+	case d.pausing:
+		b.WriteString(helpStyle.Render("pausing before the next step...") + "\n")
+	default:
+		b.WriteString("\n")
+	}
+
+	b.WriteString(helpStyle.Render(d.help()) + "\n")
+
+	return b.String()
+}
+
+func (d Dashboard) help() string {
+	switch {
+	case d.finished && d.err != nil:
+		return "↑/↓ to pick a step, r to retry, q to quit"
+	case d.finished:
+		return "↑/↓ to pick a step, q to quit"
+	case d.pausing:
+		return "↑/↓ to pick a step, f to follow, p to resume, q to abort"
+	default:
+		return "↑/↓ to pick a step, f to follow, p to pause before the next step, q to abort"
+	}
+}
+
+// stepsView renders the steps in rows lines, scrolled to keep the selected
+// step in view
+func (d Dashboard) stepsView(rows int) string {
+	first := max(d.selected-rows+1, 0)
+	last := min(first+rows, len(d.steps))
+
+	var b strings.Builder
+	for i := first; i < last; i++ {
+		s := d.steps[i]
+		label := lipgloss.NewStyle().MaxWidth(stepColumnWidth - 6).Render(stepIcons[s.status] + " " + s.name)
+		if s.status == step.StatusRunning && s.message != "" {
+			label += " " + s.message
+		}
+
+		switch {
+		case i == d.selected:
+			b.WriteString(selectedStyle.Render("> "+label) + "\n")
+		case s.status == step.StatusRunning:
+			b.WriteString(runningStyle.Render("  "+label) + "\n")
+		case s.status == step.StatusFailed:
+			b.WriteString(failedStyle.Render("  "+label) + "\n")
+		default:
+			b.WriteString(stepStyle.Render("  "+label) + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// logView renders the last rows lines the selected step printed, cut at
+// width, or what the run printed before its first step
+func (d Dashboard) logView(rows, width int) string {
+	logs := d.preamble
+	if d.selected >= 0 && d.selected < len(d.steps) {
+		logs = d.steps[d.selected].logs
+	}
+	logs = logs[max(len(logs)-rows, 0):]
+
+	lines := make([]string, rows)
+	cut := lipgloss.NewStyle().MaxWidth(width)
+	for i, line := range logs {
+		lines[i] = cut.Render(line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func appendLog(logs []string, line string) []string {
+	logs = append(logs, line)
+	if len(logs) > maxStepLogLines {
+		logs = logs[len(logs)-maxStepLogLines:]
+	}
+
+	return logs
+}
+
+// dashboardControls carries the choices made on the dashboard to the
+// goroutine running the run
+type dashboardControls struct {
+	mu        sync.Mutex
+	resume    chan struct{}
+	cancel    context.CancelFunc
+	decisions chan bool
+}
+
+func newDashboardControls() *dashboardControls {
+	return &dashboardControls{
+		cancel:    func() {},
+		decisions: make(chan bool, 1),
+	}
+}
+
+// pause makes the gate hold the run before its next step, or lets it go on
+func (c *dashboardControls) pause(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case paused && c.resume == nil:
+		c.resume = make(chan struct{})
+	case !paused && c.resume != nil:
+		close(c.resume)
+		c.resume = nil
+	}
+}
+
+// gate blocks while the run is paused, until it resumes or ctx ends
+func (c *dashboardControls) gate(ctx context.Context, send func(tea.Msg), stepName string) {
+	c.mu.Lock()
+	resume := c.resume
+	c.mu.Unlock()
+	if resume == nil {
+		return
+	}
+
+	send(pausedMsg(stepName))
+	select {
+	case <-resume:
+	case <-ctx.Done():
+	}
+}
+
+func (c *dashboardControls) abort() {
+	c.pause(false)
+	c.cancel()
+}
+
+// decide answers whether to retry the run that failed
+func (c *dashboardControls) decide(retry bool) {
+	select {
+	case c.decisions <- retry:
+	default:
+	}
+}
+
+// lineWriter sends what is written to it line by line, keeping only what
+// follows the last carriage return of a line so spinner frames redrawn in
+// place do not flood the log
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	send func(tea.Msg)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		end := bytes.IndexByte(w.buf, '\n')
+		if end < 0 {
+			break
+		}
+		line := w.buf[:end]
+		if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+			line = line[i+1:]
+		}
+		if text := strings.TrimRight(string(line), " "); text != "" {
+			w.send(logMsg(text))
+		}
+		w.buf = w.buf[end+1:]
+	}
+
+	return len(p), nil
+}
+
+// DashboardFits reports whether out is a terminal of at least
+// MinDashboardWidth by MinDashboardHeight
+func DashboardFits(out io.Writer) bool {
+	f, ok := out.(*os.File)
+	if !ok || !term.IsTerminal(int(f.Fd())) {
+		return false
+	}
+
+	width, height, err := term.GetSize(int(f.Fd()))
+	if err != nil {
+		return false
+	}
+
+	return width >= MinDashboardWidth && height >= MinDashboardHeight
+}
+
+// RunDashboard shows d on the terminal of in and out while run runs,
+// retrying it from the dashboard as long as the user asks. Aborting cancels
+// the context of the run and waits for it to return. It returns the error
+// of the last attempt
+func RunDashboard(ctx context.Context, d Dashboard, in io.Reader, out io.Writer, run DashboardRun) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.controls.cancel = cancel
+
+	program := tea.NewProgram(d, tea.WithContext(ctx), tea.WithInput(in), tea.WithOutput(out), tea.WithAltScreen())
+
+	events := make(chan step.StepEvent)
+	go func() {
+		for event := range events {
+			program.Send(eventMsg(event))
+		}
+	}()
+
+	exited := make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		defer close(events)
+
+		frontend := Frontend{
+			Events: events,
+			Log:    &lineWriter{send: program.Send},
+			Gate: func(stepName string) {
+				d.controls.gate(runCtx, program.Send, stepName)
+			},
+		}
+		for {
+			frontend.Attempt++
+			err := run(runCtx, frontend)
+			program.Send(finishedMsg{err: err})
+			if err == nil || runCtx.Err() != nil {
+				result <- err
+				return
+			}
+
+			select {
+			case retry := <-d.controls.decisions:
+				if !retry {
+					result <- err
+					return
+				}
+			case <-exited:
+				result <- err
+				return
+			}
+		}
+	}()
+
+	_, err := program.Run()
+	close(exited)
+	if err != nil && !errors.Is(err, tea.ErrProgramKilled) {
+		cancel()
+		<-result
+		return fmt.Errorf("failed to run the dashboard: %w", err)
+	}
+
+	cancel()
+	return <-result
+}
@@ -0,0 +1,132 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/konstructio/kubefirst/internal/step"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feed updates d with msgs in order
+func feed(d Dashboard, msgs ...tea.Msg) Dashboard {
+	var model tea.Model = d
+	for _, msg := range msgs {
+		model, _ = model.Update(msg)
+	}
+
+	return model.(Dashboard)
+}
+
+func TestDashboard(t *testing.T) {
+	up := tea.KeyMsg{Type: tea.KeyUp}
+	size := tea.WindowSizeMsg{Width: 100, Height: 30}
+	events := []tea.Msg{
+		size,
+		logMsg("checking flags"),
+		eventMsg{Phase: "Create Cluster", Status: step.StatusRunning},
+		logMsg("cluster requested"),
+		eventMsg{Phase: "Create Cluster", Status: step.StatusComplete, Percent: 40},
+		eventMsg{Phase: "Install ArgoCD", Status: step.StatusRunning, Percent: 40},
+		logMsg("argocd chart applied"),
+		eventMsg{Phase: "Install ArgoCD", Status: step.StatusProgress, Message: "3/7", Percent: 55},
+	}
+
+	t.Run("follows the running step", func(t *testing.T) {
+		d := feed(NewDashboard("kubefirst harvester create"), events...)
+
+		assert.Equal(t, 1, d.selected)
+		assert.Equal(t, []string{"checking flags"}, d.preamble)
+		assert.Equal(t, []string{"cluster requested"}, d.steps[0].logs)
+		view := d.View()
+		assert.Contains(t, view, "[55%]")
+		assert.Contains(t, view, "✓ Create Cluster")
+		assert.Contains(t, view, "▶ Install ArgoCD 3/7")
+		assert.Contains(t, view, "argocd chart applied")
+	})
+
+	t.Run("shows the log of the selected step", func(t *testing.T) {
+		d := feed(NewDashboard("test"), append(events, up, eventMsg{Phase: "Install Vault", Status: step.StatusRunning})...)
+
+		assert.Equal(t, 0, d.selected)
+		assert.Contains(t, d.View(), "cluster requested")
+		assert.NotContains(t, d.View(), "argocd chart applied")
+
+		d = feed(d, runes("f"))
+		assert.Equal(t, 2, d.selected)
+	})
+
+	t.Run("retries a failed run", func(t *testing.T) {
+		d := feed(NewDashboard("test"), append(events,
+			eventMsg{Phase: "Install ArgoCD", Status: step.StatusFailed, Message: "timed out"},
+			finishedMsg{err: errors.New("timed out")},
+		)...)
+		assert.Contains(t, d.View(), "r to retry")
+
+		d = feed(d, runes("r"))
+		assert.False(t, d.finished)
+		assert.True(t, <-d.controls.decisions)
+	})
+
+	t.Run("aborts a running run", func(t *testing.T) {
+		var cancelled bool
+		d := NewDashboard("test")
+		d.controls.cancel = func() { cancelled = true }
+
+		d = feed(d, append(events, runes("q"))...)
+		assert.True(t, cancelled)
+		assert.Contains(t, d.View(), "aborting")
+
+		_, cmd := d.Update(finishedMsg{err: context.Canceled})
+		require.NotNil(t, cmd)
+		assert.Equal(t, tea.Quit(), cmd())
+	})
+
+	t.Run("asks for a larger terminal", func(t *testing.T) {
+		d := feed(NewDashboard("test"), tea.WindowSizeMsg{Width: 60, Height: 30})
+
+		assert.Contains(t, d.View(), fmt.Sprintf("smaller than %dx%d", MinDashboardWidth, MinDashboardHeight))
+	})
+}
+
+func TestDashboardControls_Gate(t *testing.T) {
+	controls := newDashboardControls()
+	var paused []tea.Msg
+	send := func(msg tea.Msg) { paused = append(paused, msg) }
+
+	controls.gate(context.Background(), send, "first step")
+	assert.Empty(t, paused)
+
+	controls.pause(true)
+	released := make(chan struct{})
+	go func() {
+		controls.gate(context.Background(), send, "second step")
+		close(released)
+	}()
+
+	select {
+	case <-released:
+		t.Fatal("gate released while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	controls.pause(false)
+	<-released
+	assert.Equal(t, []tea.Msg{pausedMsg("second step")}, paused)
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []tea.Msg
+	w := &lineWriter{send: func(msg tea.Msg) { lines = append(lines, msg) }}
+
+	fmt.Fprint(w, "\r| Create Cluster\r/ Create Cluster")
+	fmt.Fprint(w, "\r✅ Create Cluster\ncluster ")
+	fmt.Fprint(w, "ready\n\n")
+
+	assert.Equal(t, []tea.Msg{logMsg("✅ Create Cluster"), logMsg("cluster ready")}, lines)
+}
@@ -0,0 +1,324 @@
+/*
+Copyright (C) 2021-2023, Kubefirst
+
+This program is licensed under MIT.
+See the LICENSE file for more details.
+*/
+package ui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+var (
+	titleStyle    = lipgloss.NewStyle().Bold(true).MarginLeft(2)
+	promptStyle   = lipgloss.NewStyle().MarginLeft(2)
+	helpStyle     = lipgloss.NewStyle().MarginLeft(2).Foreground(lipgloss.Color("241"))
+	errorStyle    = lipgloss.NewStyle().MarginLeft(2).Foreground(lipgloss.Color("196"))
+	selectedStyle = lipgloss.NewStyle().PaddingLeft(2).Foreground(lipgloss.Color("170"))
+	optionStyle   = lipgloss.NewStyle().PaddingLeft(4)
+	reviewStyle   = lipgloss.NewStyle().Margin(1, 2)
+)
+
+// Question is a screen of a Wizard. Questions with Options are answered by
+// picking one, the others by typing the answer
+type Question struct {
+	Name    string
+	Prompt  string
+	Help    string
+	Options []string
+	Default string
+	// Secret masks the typed answer
+	Secret bool
+	// Skip leaves the question out given the answers so far
+	Skip func(answers map[string]string) bool
+	// Validate checks an answer before the wizard advances. It runs outside
+	// the update loop, so it may call out to APIs
+	Validate func(ctx context.Context, answers map[string]string, value string) error
+}
+
+// Action is what the user chose to do with the answers on the review screen
+type Action int
+
+const (
+	ActionCancel Action = iota
+	ActionRun
+	ActionCopy
+)
+
+var reviewActions = []struct {
+	action Action
+	label  string
+}{
+	{ActionRun, "run it now"},
+	{ActionCopy, "copy it to the clipboard"},
+	{ActionCancel, "exit"},
+}
+
+// validatedMsg carries the result of the validation of the answer to the
+// question at index
+type validatedMsg struct {
+	index int
+	value string
+	err   error
+}
+
+// Wizard is a bubbletea model asking its questions one screen at a time,
+// then reviewing the answers as rendered by its review function
+type Wizard struct {
+	ctx       context.Context
+	title     string
+	questions []Question
+	review    func(answers map[string]string) string
+
+	answers    map[string]string
+	index      int
+	cursor     int
+	input      textinput.Model
+	validating bool
+	err        error
+	action     Action
+	done       bool
+}
+
+// NewWizard returns a Wizard asking questions under title, validations
+// run with ctx
+func NewWizard(ctx context.Context, title string, questions []Question, review func(answers map[string]string) string) Wizard {
+	w := Wizard{
+		ctx:       ctx,
+		title:     title,
+		questions: questions,
+		review:    review,
+		answers:   map[string]string{},
+		index:     -1,
+	}
+
+	return w.advance(1)
+}
+
+// Answers returns the answers by question name
+func (w Wizard) Answers() map[string]string {
+	return w.answers
+}
+
+// Action returns what the user chose on the review screen, ActionCancel
+// when the wizard was quit before
+func (w Wizard) Action() Action {
+	return w.action
+}
+
+func (w Wizard) reviewing() bool {
+	return w.index == len(w.questions)
+}
+
+// advance moves by step to the next question that is not skipped, or to
+// the review screen past the last one
+func (w Wizard) advance(step int) Wizard {
+	next := w.index + step
+	for next >= 0 && next < len(w.questions) && w.questions[next].Skip != nil && w.questions[next].Skip(w.answers) {
+		next += step
+	}
+	if next < 0 {
+		return w
+	}
+
+	w.index = min(next, len(w.questions))
+	w.err = nil
+	w.cursor = 0
+	if w.reviewing() {
+		return w
+	}
+
+	question := w.questions[w.index]
+	value, answered := w.answers[question.Name]
+	if !answered {
+		value = question.Default
+	}
+
+	if len(question.Options) > 0 {
+		for i, option := range question.Options {
+			if option == value {
+				w.cursor = i
+			}
+		}
+		return w
+	}
+
+	w.input = textinput.New()
+	w.input.Placeholder = question.Default
+	w.input.SetValue(value)
+	if question.Secret {
+		w.input.EchoMode = textinput.EchoPassword
+	}
+	w.input.Focus()
+
+	return w
+}
+
+func (w Wizard) Init() tea.Cmd {
+	return textinput.Blink
+}
+
+func (w Wizard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case validatedMsg:
+		if msg.index != w.index {
+			return w, nil
+		}
+		w.validating = false
+		if msg.err != nil {
+			w.err = msg.err
+			return w, nil
+		}
+		w.answers[w.questions[w.index].Name] = msg.value
+		return w.advance(1), nil
+
+	case tea.KeyMsg:
+		if msg.Type == tea.KeyCtrlC {
+			w.action = ActionCancel
+			w.done = true
+			return w, tea.Quit
+		}
+		if w.validating {
+			return w, nil
+		}
+
+		switch msg.Type {
+		case tea.KeyEsc:
+			return w.advance(-1), nil
+		case tea.KeyUp, tea.KeyDown:
+			if count := w.optionCount(); count > 0 {
+				if msg.Type == tea.KeyUp {
+					w.cursor = (w.cursor + count - 1) % count
+				} else {
+					w.cursor = (w.cursor + 1) % count
+				}
+				return w, nil
+			}
+		case tea.KeyEnter:
+			return w.submit()
+		}
+	}
+
+	if w.reviewing() || len(w.questions[w.index].Options) > 0 {
+		return w, nil
+	}
+
+	var cmd tea.Cmd
+	w.input, cmd = w.input.Update(msg)
+	return w, cmd
+}
+
+func (w Wizard) optionCount() int {
+	if w.reviewing() {
+		return len(reviewActions)
+	}
+
+	return len(w.questions[w.index].Options)
+}
+
+// submit answers the current question, validating it in the background,
+// or ends the wizard with the action picked on the review screen
+func (w Wizard) submit() (tea.Model, tea.Cmd) {
+	if w.reviewing() {
+		w.action = reviewActions[w.cursor].action
+		w.done = true
+		return w, tea.Quit
+	}
+
+	question := w.questions[w.index]
+	value := strings.TrimSpace(w.input.Value())
+	if len(question.Options) > 0 {
+		value = question.Options[w.cursor]
+	} else if value == "" {
+		value = question.Default
+	}
+
+	if question.Validate == nil {
+		w.answers[question.Name] = value
+		return w.advance(1), nil
+	}
+
+	w.validating = true
+	w.err = nil
+	answers := make(map[string]string, len(w.answers))
+	for name, answer := range w.answers {
+		answers[name] = answer
+	}
+	ctx, index := w.ctx, w.index
+
+	return w, func() tea.Msg {
+		return validatedMsg{index: index, value: value, err: question.Validate(ctx, answers, value)}
+	}
+}
+
+func (w Wizard) View() string {
+	if w.done {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n" + titleStyle.Render(w.title) + "\n\n")
+
+	if w.reviewing() {
+		b.WriteString(promptStyle.Render("The equivalent command is:") + "\n")
+		b.WriteString(reviewStyle.Render(w.review(w.answers)) + "\n")
+		for i, action := range reviewActions {
+			b.WriteString(optionView(action.label, i == w.cursor) + "\n")
+		}
+		b.WriteString("\n" + helpStyle.Render("↑/↓ to pick, enter to confirm, esc to go back") + "\n")
+		return b.String()
+	}
+
+	question := w.questions[w.index]
+	b.WriteString(promptStyle.Render(question.Prompt) + "\n")
+	if question.Help != "" {
+		b.WriteString(helpStyle.Render(question.Help) + "\n")
+	}
+	b.WriteString("\n")
+
+	if len(question.Options) > 0 {
+		for i, option := range question.Options {
+			b.WriteString(optionView(option, i == w.cursor) + "\n")
+		}
+	} else {
+		b.WriteString(promptStyle.Render(w.input.View()) + "\n")
+	}
+
+	switch {
+	case w.validating:
+		b.WriteString("\n" + helpStyle.Render("checking...") + "\n")
+	case w.err != nil:
+		b.WriteString("\n" + errorStyle.Render(w.err.Error()) + "\n")
+	}
+
+	b.WriteString("\n" + helpStyle.Render("enter to continue, esc to go back, ctrl+c to quit") + "\n")
+
+	return b.String()
+}
+
+func optionView(label string, selected bool) string {
+	if selected {
+		return selectedStyle.Render("> " + label)
+	}
+
+	return optionStyle.Render(label)
+}
+
+// RunWizard runs w on the terminal of in and out until the user picks an
+// action on the review screen or quits
+func RunWizard(ctx context.Context, w Wizard, in io.Reader, out io.Writer) (Wizard, error) {
+	model, err := tea.NewProgram(w, tea.WithContext(ctx), tea.WithInput(in), tea.WithOutput(out)).Run()
+	if err != nil {
+		return w, fmt.Errorf("failed to run the wizard: %w", err)
+	}
+
+	return model.(Wizard), nil
+}
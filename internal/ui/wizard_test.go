@@ -0,0 +1,77 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drive feeds keys to w, running the commands that validations return
+func drive(t *testing.T, w Wizard, keys ...tea.KeyMsg) Wizard {
+	t.Helper()
+
+	var model tea.Model = w
+	for _, key := range keys {
+		var cmd tea.Cmd
+		model, cmd = model.Update(key)
+		if cmd == nil {
+			continue
+		}
+		if msg, ok := cmd().(validatedMsg); ok {
+			model, _ = model.Update(msg)
+		}
+	}
+
+	return model.(Wizard)
+}
+
+func runes(value string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(value)}
+}
+
+func TestWizard(t *testing.T) {
+	questions := []Question{
+		{Name: "provider", Prompt: "provider", Options: []string{"github", "gitlab"}},
+		{Name: "host", Prompt: "host", Skip: func(answers map[string]string) bool { return answers["provider"] != "gitlab" }},
+		{Name: "owner", Prompt: "owner", Validate: func(_ context.Context, _ map[string]string, value string) error {
+			if value != "kubefirst" {
+				return errors.New("unknown owner")
+			}
+			return nil
+		}},
+		{Name: "cluster", Prompt: "cluster", Default: "kubefirst"},
+	}
+	review := func(answers map[string]string) string { return answers["owner"] }
+
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	down := tea.KeyMsg{Type: tea.KeyDown}
+
+	t.Run("validates before advancing", func(t *testing.T) {
+		w := drive(t, NewWizard(context.Background(), "test", questions, review), enter, runes("nobody"), enter)
+
+		assert.Equal(t, 2, w.index)
+		require.Error(t, w.err)
+		assert.Contains(t, w.View(), "unknown owner")
+	})
+
+	t.Run("skips, defaults and reviews", func(t *testing.T) {
+		w := drive(t, NewWizard(context.Background(), "test", questions, review), enter, runes("kubefirst"), enter, enter)
+
+		require.True(t, w.reviewing())
+		assert.Equal(t, map[string]string{"provider": "github", "owner": "kubefirst", "cluster": "kubefirst"}, w.Answers())
+
+		w = drive(t, w, down, enter)
+		assert.Equal(t, ActionCopy, w.Action())
+	})
+
+	t.Run("goes back to skipped answers", func(t *testing.T) {
+		w := drive(t, NewWizard(context.Background(), "test", questions, review), down, enter, runes("gitlab.example.com"), enter, tea.KeyMsg{Type: tea.KeyEsc})
+
+		assert.Equal(t, 1, w.index)
+		assert.Equal(t, "gitlab.example.com", w.input.Value())
+	})
+}
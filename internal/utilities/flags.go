@@ -8,8 +8,10 @@ package utilities
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
 	"github.com/konstructio/kubefirst/internal/types"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -198,11 +200,132 @@ func GetFlags(cmd *cobra.Command, cloudProvider string) (*types.CliFlags, error)
 		}
 		cliFlags.HarvesterKubeconfigPath = harvesterKubeconfigPath
 
-		harvesterLBIPRange, err := cmd.Flags().GetString("lb-ip-range")
+		kubeconfigFromSecret, err := cmd.Flags().GetString("kubeconfig-from-secret")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get kubeconfig-from-secret flag: %w", err)
+		}
+		cliFlags.KubeconfigFromSecret = kubeconfigFromSecret
+
+		kubeconfigContext, err := cmd.Flags().GetString("kubeconfig-context")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get kubeconfig-context flag: %w", err)
+		}
+		cliFlags.KubeconfigContext = kubeconfigContext
+
+		ciFlag, err := cmd.Flags().GetBool("ci")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get ci flag: %w", err)
+		}
+		cliFlags.Ci = ciFlag
+
+		harvesterLBIPRangeNames, err := cmd.Flags().GetStringSlice("lb-ip-range-name")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get lb-ip-range-name flag: %w", err)
+		}
+		cliFlags.HarvesterLBIPRangeNames = harvesterLBIPRangeNames
+
+		harvesterLBIPRanges, err := cmd.Flags().GetStringSlice("lb-ip-range")
 		if err != nil {
 			return &cliFlags, fmt.Errorf("failed to get lb-ip-range flag: %w", err)
 		}
-		cliFlags.HarvesterLBIPRange = harvesterLBIPRange
+		// the default range only applies when no pool is given at all
+		if !cmd.Flags().Changed("lb-ip-range") && len(harvesterLBIPRangeNames) > 0 {
+			harvesterLBIPRanges = []string{}
+		}
+		cliFlags.HarvesterLBIPRanges = harvesterLBIPRanges
+
+		harvesterLBPoolName, err := cmd.Flags().GetString("lb-pool-name")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get lb-pool-name flag: %w", err)
+		}
+		cliFlags.HarvesterLBPoolName = harvesterLBPoolName
+
+		ha, err := cmd.Flags().GetBool("ha")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get ha flag: %w", err)
+		}
+		cliFlags.HA = ha
+
+		haNodeCount, err := cmd.Flags().GetInt("ha-node-count")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get ha-node-count flag: %w", err)
+		}
+		cliFlags.HANodeCount = haNodeCount
+
+		gpuNodes, err := cmd.Flags().GetStringSlice("gpu-nodes")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get gpu-nodes flag: %w", err)
+		}
+		cliFlags.GPUNodes = gpuNodes
+
+		gpuDriverVersion, err := cmd.Flags().GetString("gpu-driver-version")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get gpu-driver-version flag: %w", err)
+		}
+		cliFlags.GPUDriverVersion = gpuDriverVersion
+
+		proxy, err := cmd.Flags().GetString("proxy")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get proxy flag: %w", err)
+		}
+		cliFlags.Proxy = proxy
+
+		caCerts, err := cmd.Flags().GetStringSlice("ca-cert")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get ca-cert flag: %w", err)
+		}
+		if !cmd.Flags().Changed("ca-cert") {
+			caCerts = viper.GetStringSlice("flags.ca-cert")
+		}
+		cliFlags.CACerts = caCerts
+
+		commitPerChange, err := cmd.Flags().GetBool("commit-per-change")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get commit-per-change flag: %w", err)
+		}
+		if !cmd.Flags().Changed("commit-per-change") {
+			commitPerChange = viper.GetBool("flags.commit-per-change")
+		}
+		cliFlags.CommitPerChange = commitPerChange
+
+		registryMirror, err := cmd.Flags().GetString("registry-mirror")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get registry-mirror flag: %w", err)
+		}
+		cliFlags.RegistryMirror = registryMirror
+
+		dnsCheckDoH, err := cmd.Flags().GetString("dns-check-doh")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get dns-check-doh flag: %w", err)
+		}
+		cliFlags.DNSCheckDoH = dnsCheckDoH
+
+		pruneDNS, err := cmd.Flags().GetBool("prune-dns")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get prune-dns flag: %w", err)
+		}
+		cliFlags.PruneDNS = pruneDNS
+
+		// an invalid list is reported by ValidateProvidedFlags
+		cliFlags.DNSProviders, _ = internalharvester.ParseDNSProviders(cliFlags.DNSProvider)
+
+		route53HostedZoneID, err := cmd.Flags().GetString("route53-hosted-zone-id")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get route53-hosted-zone-id flag: %w", err)
+		}
+		cliFlags.Route53HostedZoneID = route53HostedZoneID
+
+		clusterType, err := cmd.Flags().GetString("cluster-type")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get cluster-type flag: %w", err)
+		}
+		cliFlags.ClusterType = clusterType
+
+		mgmtKubeconfig, err := cmd.Flags().GetString("mgmt-kubeconfig")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get mgmt-kubeconfig flag: %w", err)
+		}
+		cliFlags.MgmtKubeconfig = mgmtKubeconfig
 
 		vclusters, err := cmd.Flags().GetStringSlice("vclusters")
 		if err != nil {
@@ -210,12 +333,139 @@ func GetFlags(cmd *cobra.Command, cloudProvider string) (*types.CliFlags, error)
 		}
 		cliFlags.VClusters = vclusters
 
+		vclusterIngressWildcard, err := cmd.Flags().GetBool("vcluster-ingress-wildcard")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-ingress-wildcard flag: %w", err)
+		}
+		cliFlags.VClusterIngressWildcard = vclusterIngressWildcard
+
+		vclusterNetworkIsolation, err := cmd.Flags().GetBool("vcluster-network-isolation")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-network-isolation flag: %w", err)
+		}
+		cliFlags.VClusterNetworkIsolation = vclusterNetworkIsolation
+
+		vclusterAppSet, err := cmd.Flags().GetBool("vcluster-appset")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-appset flag: %w", err)
+		}
+		cliFlags.VClusterAppSet = vclusterAppSet
+
+		vclusterAllows, err := cmd.Flags().GetStringSlice("allow-vcluster-to-vcluster")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get allow-vcluster-to-vcluster flag: %w", err)
+		}
+		cliFlags.VClusterAllows = vclusterAllows
+
+		vclusterConnections, err := cmd.Flags().GetStringSlice("vcluster-connect")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-connect flag: %w", err)
+		}
+		cliFlags.VClusterConnections = vclusterConnections
+
+		vclusterApps, err := cmd.Flags().GetStringArray("vcluster-apps")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-apps flag: %w", err)
+		}
+		cliFlags.VClusterApps = vclusterApps
+
+		trustBundle, err := cmd.Flags().GetBool("trust-bundle")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get trust-bundle flag: %w", err)
+		}
+		cliFlags.TrustBundle = trustBundle
+
+		trustBundleTargets, err := cmd.Flags().GetStringSlice("trust-bundle-target")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get trust-bundle-target flag: %w", err)
+		}
+		cliFlags.TrustBundleTargets = trustBundleTargets
+
+		trustManager, err := cmd.Flags().GetBool("trust-manager")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get trust-manager flag: %w", err)
+		}
+		cliFlags.TrustManager = trustManager
+
+		trustBundleSelector, err := cmd.Flags().GetString("trust-bundle-namespace-selector")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get trust-bundle-namespace-selector flag: %w", err)
+		}
+		cliFlags.TrustBundleSelector = trustBundleSelector
+
+		trustBundleProbeURL, err := cmd.Flags().GetString("trust-bundle-probe-url")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get trust-bundle-probe-url flag: %w", err)
+		}
+		cliFlags.TrustBundleProbeURL = trustBundleProbeURL
+
+		vclusterSpecs, err := cmd.Flags().GetStringSlice("vcluster-spec")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-spec flag: %w", err)
+		}
+		cliFlags.VClusterSpecs = vclusterSpecs
+
+		vclusterDefaultSpec, err := cmd.Flags().GetString("vcluster-default-spec")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-default-spec flag: %w", err)
+		}
+		cliFlags.VClusterDefaultSpec = vclusterDefaultSpec
+
+		vclusterNodeSelectors, err := cmd.Flags().GetStringSlice("vcluster-node-selector")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-node-selector flag: %w", err)
+		}
+		cliFlags.VClusterNodeSelectors = vclusterNodeSelectors
+
+		extraDomains, err := cmd.Flags().GetStringSlice("extra-domains")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get extra-domains flag: %w", err)
+		}
+		cliFlags.ExtraDomains = extraDomains
+
+		vclusterDomainMap, err := cmd.Flags().GetStringToString("vcluster-domain-map")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-domain-map flag: %w", err)
+		}
+		cliFlags.VClusterDomainMap = vclusterDomainMap
+
+		storageClass, err := cmd.Flags().GetString("storage-class")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get storage-class flag: %w", err)
+		}
+		cliFlags.StorageClass = storageClass
+
+		vclusterStorageClasses, err := cmd.Flags().GetStringToString("vcluster-storage-class")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-storage-class flag: %w", err)
+		}
+		cliFlags.VClusterStorageClasses = vclusterStorageClasses
+
+		clusterLabels, err := cmd.Flags().GetStringToString("cluster-labels")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get cluster-labels flag: %w", err)
+		}
+		cliFlags.ClusterLabels = clusterLabels
+
 		installIstio, err := cmd.Flags().GetBool("install-istio")
 		if err != nil {
 			return &cliFlags, fmt.Errorf("failed to get install-istio flag: %w", err)
 		}
 		cliFlags.InstallIstio = installIstio
 
+		vclusterIstio, err := cmd.Flags().GetStringToString("vcluster-istio")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vcluster-istio flag: %w", err)
+		}
+		cliFlags.VClusterIstio = make(map[string]bool, len(vclusterIstio))
+		for vcluster, value := range vclusterIstio {
+			enabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return &cliFlags, fmt.Errorf("failed to parse vcluster-istio flag: %q is not a boolean for vcluster %q", value, vcluster)
+			}
+			cliFlags.VClusterIstio[vcluster] = enabled
+		}
+
 		istioVersion, err := cmd.Flags().GetString("istio-version")
 		if err != nil {
 			return &cliFlags, fmt.Errorf("failed to get istio-version flag: %w", err)
@@ -228,12 +478,102 @@ func GetFlags(cmd *cobra.Command, cloudProvider string) (*types.CliFlags, error)
 		}
 		cliFlags.InstallKgateway = installKgateway
 
+		installObservability, err := cmd.Flags().GetBool("install-observability")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get install-observability flag: %w", err)
+		}
+		cliFlags.InstallObservability = installObservability
+
+		logging, err := cmd.Flags().GetBool("logging")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get logging flag: %w", err)
+		}
+		cliFlags.Logging = logging
+
+		loggingRetention, err := cmd.Flags().GetString("logging-retention")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get logging-retention flag: %w", err)
+		}
+		cliFlags.LoggingRetention = loggingRetention
+
 		gitopsRepo, err := cmd.Flags().GetString("gitops-repo")
 		if err != nil {
 			return &cliFlags, fmt.Errorf("failed to get gitops-repo flag: %w", err)
 		}
 		cliFlags.GitopsRepo = gitopsRepo
 
+		gitopsRegistryPath, err := cmd.Flags().GetString("gitops-registry-path")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get gitops-registry-path flag: %w", err)
+		}
+		cliFlags.GitopsRegistryPath = gitopsRegistryPath
+
+		gitopsOverlayDir, err := cmd.Flags().GetString("gitops-overlay-dir")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get gitops-overlay-dir flag: %w", err)
+		}
+		cliFlags.GitopsOverlayDir = gitopsOverlayDir
+
+		fromBundle, err := cmd.Flags().GetString("from-bundle")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get from-bundle flag: %w", err)
+		}
+		cliFlags.FromBundle = fromBundle
+
+		largeFileWarnSize, err := cmd.Flags().GetString("large-file-warn-size")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get large-file-warn-size flag: %w", err)
+		}
+		cliFlags.LargeFileWarnSize = largeFileWarnSize
+
+		largeFileMaxSize, err := cmd.Flags().GetString("large-file-max-size")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get large-file-max-size flag: %w", err)
+		}
+		cliFlags.LargeFileMaxSize = largeFileMaxSize
+
+		allowLargeFiles, err := cmd.Flags().GetBool("allow-large-files")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get allow-large-files flag: %w", err)
+		}
+		cliFlags.AllowLargeFiles = allowLargeFiles
+
+		gitLFSPatterns, err := cmd.Flags().GetStringSlice("git-lfs-patterns")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get git-lfs-patterns flag: %w", err)
+		}
+		cliFlags.GitLFSPatterns = gitLFSPatterns
+
+		noBranchProtection, err := cmd.Flags().GetBool("no-branch-protection")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get no-branch-protection flag: %w", err)
+		}
+		cliFlags.NoBranchProtection = noBranchProtection
+
+		argoCDWriteAccess, err := cmd.Flags().GetBool("argocd-write-access")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get argocd-write-access flag: %w", err)
+		}
+		cliFlags.ArgoCDWriteAccess = argoCDWriteAccess
+
+		gitHubAppID, err := cmd.Flags().GetInt64("github-app-id")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get github-app-id flag: %w", err)
+		}
+		cliFlags.GitHubAppID = gitHubAppID
+
+		gitHubAppKeyPath, err := cmd.Flags().GetString("github-app-key-path")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get github-app-key-path flag: %w", err)
+		}
+		cliFlags.GitHubAppKeyPath = gitHubAppKeyPath
+
+		ingressMode, err := cmd.Flags().GetString("ingress-mode")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get ingress-mode flag: %w", err)
+		}
+		cliFlags.IngressMode = ingressMode
+
 		uniFiHost, err := cmd.Flags().GetString("unifi-host")
 		if err != nil {
 			return &cliFlags, fmt.Errorf("failed to get unifi-host flag: %w", err)
@@ -252,23 +592,377 @@ func GetFlags(cmd *cobra.Command, cloudProvider string) (*types.CliFlags, error)
 		}
 		cliFlags.UniFiPassword = uniFiPassword
 
+		uniFiSite, err := cmd.Flags().GetString("unifi-site")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get unifi-site flag: %w", err)
+		}
+		cliFlags.UniFiSite = uniFiSite
+
+		uniFiPortMappings, err := cmd.Flags().GetStringSlice("unifi-port-mapping")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get unifi-port-mapping flag: %w", err)
+		}
+		cliFlags.UniFiPortMappings = uniFiPortMappings
+
+		kubefirstProVersion, err := cmd.Flags().GetString("kubefirst-pro-version")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get kubefirst-pro-version flag: %w", err)
+		}
+		cliFlags.KubefirstProVersion = kubefirstProVersion
+
+		kubefirstProChartURL, err := cmd.Flags().GetString("kubefirst-pro-chart-url")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get kubefirst-pro-chart-url flag: %w", err)
+		}
+		cliFlags.KubefirstProChartURL = kubefirstProChartURL
+
+		componentsVersionFile, err := cmd.Flags().GetString("components-version-file")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get components-version-file flag: %w", err)
+		}
+		cliFlags.ComponentsVersionFile = componentsVersionFile
+
+		lowBandwidth, err := cmd.Flags().GetBool("low-bandwidth")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get low-bandwidth flag: %w", err)
+		}
+		cliFlags.LowBandwidth = lowBandwidth
+
+		oidcIssuerURL, err := cmd.Flags().GetString("oidc-issuer-url")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get oidc-issuer-url flag: %w", err)
+		}
+		cliFlags.OIDCIssuerURL = oidcIssuerURL
+
+		oidcClientID, err := cmd.Flags().GetString("oidc-client-id")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get oidc-client-id flag: %w", err)
+		}
+		cliFlags.OIDCClientID = oidcClientID
+
+		oidcClientSecret, err := cmd.Flags().GetString("oidc-client-secret")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get oidc-client-secret flag: %w", err)
+		}
+		cliFlags.OIDCClientSecret = oidcClientSecret
+
+		oidcAdminGroup, err := cmd.Flags().GetString("oidc-admin-group")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get oidc-admin-group flag: %w", err)
+		}
+		cliFlags.OIDCAdminGroup = oidcAdminGroup
+
 		stopAfter, err := cmd.Flags().GetString("stop-after")
 		if err != nil {
 			return &cliFlags, fmt.Errorf("failed to get stop-after flag: %w", err)
 		}
 		cliFlags.StopAfter = stopAfter
 
+		externalSecrets, err := cmd.Flags().GetBool("external-secrets")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get external-secrets flag: %w", err)
+		}
+		cliFlags.ExternalSecrets = externalSecrets
+
+		externalSecretsBackend, err := cmd.Flags().GetString("external-secrets-backend")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get external-secrets-backend flag: %w", err)
+		}
+		cliFlags.ExternalSecretsBackend = externalSecretsBackend
+
+		for flag, value := range map[string]*string{
+			"backup-schedule": &cliFlags.BackupSchedule,
+			"backup-storage":  &cliFlags.BackupStorage,
+			"backup-ttl":      &cliFlags.BackupTTL,
+			"backup-bucket":   &cliFlags.BackupBucket,
+			"backup-prefix":   &cliFlags.BackupPrefix,
+		} {
+			if *value, err = cmd.Flags().GetString(flag); err != nil {
+				return &cliFlags, fmt.Errorf("failed to get %s flag: %w", flag, err)
+			}
+		}
+
+		vaultAutoUnseal, err := cmd.Flags().GetString("vault-auto-unseal")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vault-auto-unseal flag: %w", err)
+		}
+		cliFlags.VaultAutoUnseal = vaultAutoUnseal
+
+		vaultAudit, err := cmd.Flags().GetString("vault-audit")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vault-audit flag: %w", err)
+		}
+		cliFlags.VaultAudit = vaultAudit
+
+		vaultAuditSize, err := cmd.Flags().GetString("vault-audit-size")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vault-audit-size flag: %w", err)
+		}
+		cliFlags.VaultAuditSize = vaultAuditSize
+
+		vaultSeedFile, err := cmd.Flags().GetString("vault-seed-file")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vault-seed-file flag: %w", err)
+		}
+		cliFlags.VaultSeedFile = vaultSeedFile
+
+		vaultTeamPolicies, err := cmd.Flags().GetBool("vault-team-policies")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get vault-team-policies flag: %w", err)
+		}
+		cliFlags.VaultTeamPolicies = vaultTeamPolicies
+
+		slackWebhook, err := cmd.Flags().GetString("slack-webhook")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get slack-webhook flag: %w", err)
+		}
+		cliFlags.SlackWebhook = slackWebhook
+
+		teamsWebhook, err := cmd.Flags().GetString("teams-webhook")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get teams-webhook flag: %w", err)
+		}
+		cliFlags.TeamsWebhook = teamsWebhook
+
+		notifyWebhook, err := cmd.Flags().GetString("notify-webhook")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get notify-webhook flag: %w", err)
+		}
+		cliFlags.NotifyWebhook = notifyWebhook
+
+		notifyOnPhase, err := cmd.Flags().GetStringSlice("notify-on-phase")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get notify-on-phase flag: %w", err)
+		}
+		cliFlags.NotifyOnPhase = notifyOnPhase
+
+		notifyWebhookURL, err := cmd.Flags().GetString("notify-webhook-url")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get notify-webhook-url flag: %w", err)
+		}
+		cliFlags.NotifyWebhookURL = notifyWebhookURL
+
+		notifyFormat, err := cmd.Flags().GetString("notify-format")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get notify-format flag: %w", err)
+		}
+		cliFlags.NotifyFormat = notifyFormat
+
+		notifyOn, err := cmd.Flags().GetString("notify-on")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get notify-on flag: %w", err)
+		}
+		cliFlags.NotifyOn = notifyOn
+
+		reportPath, err := cmd.Flags().GetString("report-path")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get report-path flag: %w", err)
+		}
+		cliFlags.ReportPath = reportPath
+
+		summaryFile, err := cmd.Flags().GetString("summary-file")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get summary-file flag: %w", err)
+		}
+		cliFlags.SummaryFile = summaryFile
+
+		metricsFile, err := cmd.Flags().GetString("metrics-file")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get metrics-file flag: %w", err)
+		}
+		cliFlags.MetricsFile = metricsFile
+
+		force, err := cmd.Flags().GetBool("force")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get force flag: %w", err)
+		}
+		cliFlags.Force = force
+
+		resumeFrom, err := cmd.Flags().GetString("resume-from")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get resume-from flag: %w", err)
+		}
+		cliFlags.ResumeFrom = resumeFrom
+
+		exportManifests, err := cmd.Flags().GetString("export-manifests")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get export-manifests flag: %w", err)
+		}
+		cliFlags.ExportManifests = exportManifests
+
+		exportIncludeSecrets, err := cmd.Flags().GetBool("export-include-secrets")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get export-include-secrets flag: %w", err)
+		}
+		cliFlags.ExportIncludeSecrets = exportIncludeSecrets
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get dry-run flag: %w", err)
+		}
+		cliFlags.DryRun = dryRun
+
+		costEstimate, err := cmd.Flags().GetBool("cost-estimate")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get cost-estimate flag: %w", err)
+		}
+		cliFlags.CostEstimate = costEstimate
+
+		costRateCPU, err := cmd.Flags().GetFloat64("cost-rate-cpu")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get cost-rate-cpu flag: %w", err)
+		}
+		cliFlags.CostRateCPU = costRateCPU
+
+		costRateMemory, err := cmd.Flags().GetFloat64("cost-rate-memory")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get cost-rate-memory flag: %w", err)
+		}
+		cliFlags.CostRateMemory = costRateMemory
+
+		watchVerbose, err := cmd.Flags().GetBool("watch-verbose")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get watch-verbose flag: %w", err)
+		}
+		cliFlags.WatchVerbose = watchVerbose
+
+		degradedGracePeriod, err := cmd.Flags().GetDuration("degraded-grace-period")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get degraded-grace-period flag: %w", err)
+		}
+		cliFlags.DegradedGracePeriod = degradedGracePeriod
+
+		maxHealthFlaps, err := cmd.Flags().GetInt("max-health-flaps")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get max-health-flaps flag: %w", err)
+		}
+		cliFlags.MaxHealthFlaps = maxHealthFlaps
+
+		skipVerify, err := cmd.Flags().GetBool("skip-verify")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get skip-verify flag: %w", err)
+		}
+		cliFlags.SkipVerify = skipVerify
+
+		verifyTimeout, err := cmd.Flags().GetDuration("verify-timeout")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get verify-timeout flag: %w", err)
+		}
+		cliFlags.VerifyTimeout = verifyTimeout
+
+		wait, err := cmd.Flags().GetBool("wait")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get wait flag: %w", err)
+		}
+		cliFlags.Wait = wait
+
+		apiRetryMax, err := cmd.Flags().GetInt("api-retry-max")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get api-retry-max flag: %w", err)
+		}
+		cliFlags.APIRetryMax = apiRetryMax
+
+		retryCount, err := cmd.Flags().GetInt("retry-count")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get retry-count flag: %w", err)
+		}
+		cliFlags.RetryCount = retryCount
+
+		retryBackoff, err := cmd.Flags().GetDuration("retry-backoff")
+		if err != nil {
+			return &cliFlags, fmt.Errorf("failed to get retry-backoff flag: %w", err)
+		}
+		cliFlags.RetryBackoff = retryBackoff
+
+		cliFlags.Provenance = internalharvester.FlagProvenances(cmd.Flags())
+
 		viper.Set("flags.kubeconfig-path", cliFlags.HarvesterKubeconfigPath)
-		viper.Set("flags.lb-ip-range", cliFlags.HarvesterLBIPRange)
+		viper.Set("flags.lb-ip-range", cliFlags.HarvesterLBIPRanges)
+		viper.Set("flags.lb-ip-range-name", cliFlags.HarvesterLBIPRangeNames)
+		viper.Set("flags.lb-pool-name", cliFlags.HarvesterLBPoolName)
+		viper.Set("flags.ha", cliFlags.HA)
+		viper.Set("flags.ha-node-count", cliFlags.HANodeCount)
+		viper.Set("flags.gpu-nodes", cliFlags.GPUNodes)
+		viper.Set("flags.gpu-driver-version", cliFlags.GPUDriverVersion)
+		viper.Set("flags.proxy", cliFlags.Proxy)
+		viper.Set("flags.ca-cert", cliFlags.CACerts)
+		viper.Set("flags.commit-per-change", cliFlags.CommitPerChange)
+		viper.Set("flags.registry-mirror", cliFlags.RegistryMirror)
+		viper.Set("flags.dns-check-doh", cliFlags.DNSCheckDoH)
+		viper.Set("flags.prune-dns", cliFlags.PruneDNS)
+		viper.Set("flags.route53-hosted-zone-id", cliFlags.Route53HostedZoneID)
+		viper.Set("flags.cluster-type", cliFlags.ClusterType)
+		viper.Set("flags.mgmt-kubeconfig", cliFlags.MgmtKubeconfig)
+		viper.Set("flags.api-retry-max", cliFlags.APIRetryMax)
+		viper.Set("flags.retry-count", cliFlags.RetryCount)
+		viper.Set("flags.retry-backoff", cliFlags.RetryBackoff.String())
 		viper.Set("flags.vclusters", cliFlags.VClusters)
+		viper.Set("flags.vcluster-ingress-wildcard", cliFlags.VClusterIngressWildcard)
+		viper.Set("flags.vcluster-network-isolation", cliFlags.VClusterNetworkIsolation)
+		viper.Set("flags.vcluster-appset", cliFlags.VClusterAppSet)
+		viper.Set("flags.allow-vcluster-to-vcluster", cliFlags.VClusterAllows)
+		viper.Set("flags.vcluster-connect", cliFlags.VClusterConnections)
+		viper.Set("flags.vcluster-apps", cliFlags.VClusterApps)
+		viper.Set("flags.trust-bundle", cliFlags.TrustBundle)
+		viper.Set("flags.trust-bundle-target", cliFlags.TrustBundleTargets)
+		viper.Set("flags.trust-manager", cliFlags.TrustManager)
+		viper.Set("flags.trust-bundle-namespace-selector", cliFlags.TrustBundleSelector)
+		viper.Set("flags.vcluster-spec", cliFlags.VClusterSpecs)
+		viper.Set("flags.vcluster-default-spec", cliFlags.VClusterDefaultSpec)
+		viper.Set("flags.vcluster-node-selector", cliFlags.VClusterNodeSelectors)
+		viper.Set("flags.extra-domains", cliFlags.ExtraDomains)
+		viper.Set("flags.vcluster-domain-map", cliFlags.VClusterDomainMap)
+		viper.Set("flags.storage-class", cliFlags.StorageClass)
+		viper.Set("flags.vcluster-storage-class", cliFlags.VClusterStorageClasses)
+		viper.Set("flags.cluster-labels", cliFlags.ClusterLabels)
 		viper.Set("flags.install-istio", cliFlags.InstallIstio)
+		viper.Set("flags.vcluster-istio", cliFlags.VClusterIstio)
 		viper.Set("flags.istio-version", cliFlags.IstioVersion)
 		viper.Set("flags.install-kgateway", cliFlags.InstallKgateway)
+		viper.Set("flags.install-observability", cliFlags.InstallObservability)
+		viper.Set("flags.logging", cliFlags.Logging)
+		viper.Set("flags.logging-retention", cliFlags.LoggingRetention)
 		viper.Set("flags.gitops-repo", cliFlags.GitopsRepo)
+		viper.Set("flags.gitops-registry-path", cliFlags.GitopsRegistryPath)
+		viper.Set("flags.gitops-overlay-dir", cliFlags.GitopsOverlayDir)
+		viper.Set("flags.large-file-warn-size", cliFlags.LargeFileWarnSize)
+		viper.Set("flags.large-file-max-size", cliFlags.LargeFileMaxSize)
+		viper.Set("flags.allow-large-files", cliFlags.AllowLargeFiles)
+		viper.Set("flags.git-lfs-patterns", cliFlags.GitLFSPatterns)
+		viper.Set("flags.no-branch-protection", cliFlags.NoBranchProtection)
+		viper.Set("flags.argocd-write-access", cliFlags.ArgoCDWriteAccess)
+		viper.Set("flags.github-app-id", cliFlags.GitHubAppID)
+		viper.Set("flags.github-app-key-path", cliFlags.GitHubAppKeyPath)
+		viper.Set("flags.ingress-mode", cliFlags.IngressMode)
 		viper.Set("flags.unifi-host", cliFlags.UniFiHost)
 		viper.Set("flags.unifi-user", cliFlags.UniFiUser)
 		viper.Set("flags.unifi-password", cliFlags.UniFiPassword)
+		viper.Set("flags.unifi-site", cliFlags.UniFiSite)
+		viper.Set("flags.unifi-port-mapping", cliFlags.UniFiPortMappings)
+		viper.Set("flags.kubefirst-pro-chart-url", cliFlags.KubefirstProChartURL)
+		viper.Set("flags.components-version-file", cliFlags.ComponentsVersionFile)
+		viper.Set("flags.low-bandwidth", cliFlags.LowBandwidth)
+		viper.Set("flags.oidc-issuer-url", cliFlags.OIDCIssuerURL)
+		viper.Set("flags.oidc-client-id", cliFlags.OIDCClientID)
+		viper.Set("flags.oidc-admin-group", cliFlags.OIDCAdminGroup)
 		viper.Set("flags.stop-after", cliFlags.StopAfter)
+		viper.Set("flags.wait", cliFlags.Wait)
+		viper.Set("flags.external-secrets", cliFlags.ExternalSecrets)
+		viper.Set("flags.external-secrets-backend", cliFlags.ExternalSecretsBackend)
+		viper.Set("flags.backup-schedule", cliFlags.BackupSchedule)
+		viper.Set("flags.backup-storage", cliFlags.BackupStorage)
+		viper.Set("flags.backup-ttl", cliFlags.BackupTTL)
+		viper.Set("flags.backup-bucket", cliFlags.BackupBucket)
+		viper.Set("flags.backup-prefix", cliFlags.BackupPrefix)
+		viper.Set("flags.vault-auto-unseal", cliFlags.VaultAutoUnseal)
+		viper.Set("flags.vault-seed-file", cliFlags.VaultSeedFile)
+		viper.Set("flags.vault-team-policies", cliFlags.VaultTeamPolicies)
+		viper.Set("flags.vault-audit", cliFlags.VaultAudit)
+		viper.Set("flags.vault-audit-size", cliFlags.VaultAuditSize)
+		viper.Set("flags.report-path", cliFlags.ReportPath)
+		viper.Set("flags.summary-file", cliFlags.SummaryFile)
+		viper.Set("flags.metrics-file", cliFlags.MetricsFile)
+		viper.Set("flag-provenance", cliFlags.Provenance)
 	}
 
 	if err := viper.WriteConfig(); err != nil {
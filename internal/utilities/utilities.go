@@ -12,11 +12,13 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/konstructio/kubefirst-api/pkg/configs"
 	"github.com/konstructio/kubefirst-api/pkg/k8s"
 	apiTypes "github.com/konstructio/kubefirst-api/pkg/types"
+	internalharvester "github.com/konstructio/kubefirst/internal/harvester"
 	"github.com/konstructio/kubefirst/internal/types"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
@@ -67,7 +69,7 @@ func CreateClusterRecordFromRaw(
 		CreationTimestamp:      fmt.Sprintf("%v", time.Now().UTC()),
 		UseTelemetry:           useTelemetry,
 		Status:                 "provisioned",
-		AlertsEmail:            viper.GetString("flags.alerts-email"),
+		AlertsEmail:            letsEncryptEmail(),
 		ClusterName:            viper.GetString("flags.cluster-name"),
 		CloudProvider:          cloudProvider,
 		CloudRegion:            viper.GetString("flags.cloud-region"),
@@ -135,6 +137,13 @@ func CreateClusterRecordFromRaw(
 	return cl
 }
 
+// letsEncryptEmail returns the first of the alerts emails, the address the
+// Let's Encrypt account of the cluster is registered with
+func letsEncryptEmail() string {
+	first, _, _ := strings.Cut(viper.GetString("flags.alerts-email"), ",")
+	return strings.TrimSpace(first)
+}
+
 func CreateClusterDefinitionRecordFromRaw(gitAuth apiTypes.GitAuth, cliFlags types.CliFlags, catalogApps []apiTypes.GitopsCatalogApp) (*apiTypes.ClusterDefinition, error) {
 	cloudProvider := viper.GetString("kubefirst.cloud-provider")
 	domainName := viper.GetString("flags.domain-name")
@@ -150,14 +159,20 @@ func CreateClusterDefinitionRecordFromRaw(gitAuth apiTypes.GitAuth, cliFlags typ
 		log.Info().Msg("Unable to convert node count to type string")
 	}
 
+	// only the harvester create sets the cluster type
+	clusterType := internalharvester.ClusterTypeMgmt
+	if cliFlags.ClusterType != "" {
+		clusterType = cliFlags.ClusterType
+	}
+
 	cl := apiTypes.ClusterDefinition{
-		AdminEmail:             viper.GetString("flags.alerts-email"),
+		AdminEmail:             letsEncryptEmail(),
 		ClusterName:            viper.GetString("flags.cluster-name"),
 		CloudProvider:          cloudProvider,
 		CloudRegion:            viper.GetString("flags.cloud-region"),
 		DomainName:             domainName,
 		SubdomainName:          cliFlags.SubDomainName,
-		Type:                   "mgmt",
+		Type:                   clusterType,
 		NodeType:               cliFlags.NodeType,
 		NodeCount:              stringToIntNodeCount,
 		GitopsTemplateURL:      cliFlags.GitopsTemplateURL,
@@ -235,17 +250,36 @@ func CreateClusterDefinitionRecordFromRaw(gitAuth apiTypes.GitAuth, cliFlags typ
 		cl.GoogleAuth.KeyFile = string(jsonContent)
 		cl.GoogleAuth.ProjectID = cliFlags.GoogleProject
 	case "harvester":
+		// kubefirst-api provisions the cluster with the first of the dns
+		// providers the records are published to
+		dnsProvider, err := internalharvester.PrimaryDNSProvider(viper.GetString("flags.dns-provider"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns provider: %w", err)
+		}
+		cl.DNSProvider = dnsProvider
 		// Harvester uses an existing kubeconfig file
 		cl.HarvesterAuth.KubeconfigPath = viper.GetString("flags.kubeconfig-path")
-		cl.HarvesterAuth.LBIPRange = viper.GetString("flags.lb-ip-range")
-		cl.HarvesterAuth.VClusters = viper.GetStringSlice("flags.vclusters")
+		lbPools, err := internalharvester.ParseLBPools(viper.GetString("flags.lb-pool-name"), viper.GetStringSlice("flags.lb-ip-range"), viper.GetStringSlice("flags.lb-ip-range-name"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid load balancer ranges: %w", err)
+		}
+		cl.HarvesterAuth.LBIPRange = lbPools.String()
+		// kubefirst-api commits an application per vcluster it is given, the
+		// vcluster ApplicationSet committed after provisioning replaces them
+		if !internalharvester.VClusterAppSetEnabled(viper.GetString("flags.stop-after"), viper.GetBool("flags.vcluster-appset")) {
+			cl.HarvesterAuth.VClusters = viper.GetStringSlice("flags.vclusters")
+		}
 		cl.HarvesterAuth.InstallIstio = viper.GetBool("flags.install-istio")
 		cl.HarvesterAuth.IstioVersion = viper.GetString("flags.istio-version")
 		cl.HarvesterAuth.InstallKgateway = viper.GetBool("flags.install-kgateway")
 		cl.HarvesterAuth.GitopsRepo = viper.GetString("flags.gitops-repo")
-		cl.HarvesterAuth.UniFiHost = viper.GetString("flags.unifi-host")
-		cl.HarvesterAuth.UniFiUser = viper.GetString("flags.unifi-user")
-		cl.HarvesterAuth.UniFiPassword = viper.GetString("flags.unifi-password")
+		// kubefirst-api configures the UniFi port forwards whenever it gets
+		// a controller, the other ingress modes leave the WAN ports closed
+		if internalharvester.IngressModeOf(viper.GetString("flags.ingress-mode")) == internalharvester.IngressModeUniFi {
+			cl.HarvesterAuth.UniFiHost = viper.GetString("flags.unifi-host")
+			cl.HarvesterAuth.UniFiUser = viper.GetString("flags.unifi-user")
+			cl.HarvesterAuth.UniFiPassword = viper.GetString("flags.unifi-password")
+		}
 		cl.HarvesterAuth.StopAfterPhase = viper.GetString("flags.stop-after")
 	}
 